@@ -0,0 +1,292 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// ParseADXFunctions parses a script of stored function definitions
+// exported from Azure Data Explorer as control commands:
+//
+//	.create-or-alter function with (docstring = "Failed sign-ins", folder = "Security")
+//	FailedSignIns() {
+//	    SigninLogs | where ResultType != 0
+//	}
+//
+// The .create, .create-or-alter, and .alter commands are accepted,
+// optionally with ifnotexists.
+// Functions with parameters are not supported and cause an error.
+// Function bodies are not checked until they are compiled.
+func ParseADXFunctions(source string) ([]*StoredFunction, error) {
+	p := &adxParser{source: source}
+	var functions []*StoredFunction
+	for {
+		p.skipSpace()
+		if p.pos >= len(source) {
+			return functions, nil
+		}
+		fn, err := p.function()
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, fn)
+	}
+}
+
+// LoadADXFunctionsFile reads Azure Data Explorer function definitions
+// from the file at the given path with [ParseADXFunctions].
+func LoadADXFunctionsFile(path string) ([]*StoredFunction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	functions, err := ParseADXFunctions(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return functions, nil
+}
+
+// adxParser reads Azure Data Explorer control commands.
+type adxParser struct {
+	source string
+	pos    int
+}
+
+// errorf returns an error at the parser's current position.
+func (p *adxParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%v: %s", parser.PositionFor(p.source, p.pos), fmt.Sprintf(format, args...))
+}
+
+// function reads a single function definition command.
+func (p *adxParser) function() (*StoredFunction, error) {
+	start := p.pos
+	switch command := p.word(); command {
+	case ".create", ".create-or-alter", ".alter":
+	default:
+		p.pos = start
+		return nil, p.errorf("expected .create function, got %q", command)
+	}
+	p.skipSpace()
+	start = p.pos
+	if word := p.word(); word != "function" {
+		p.pos = start
+		return nil, p.errorf("expected function, got %q", word)
+	}
+	fn := new(StoredFunction)
+	p.skipSpace()
+	start = p.pos
+	switch word := p.word(); word {
+	case "ifnotexists":
+	case "with":
+		if err := p.properties(fn); err != nil {
+			return nil, err
+		}
+	default:
+		p.pos = start
+	}
+
+	p.skipSpace()
+	start = p.pos
+	fn.Name = p.word()
+	if !isSimpleIdentifier(fn.Name) {
+		p.pos = start
+		return nil, p.errorf("expected function name, got %q", fn.Name)
+	}
+	p.skipSpace()
+	if !p.consume("(") {
+		return nil, p.errorf("expected ( after function name")
+	}
+	p.skipSpace()
+	if !p.consume(")") {
+		return nil, p.errorf("function %s has parameters, which are not supported", fn.Name)
+	}
+	p.skipSpace()
+	if !p.consume("{") {
+		return nil, p.errorf("expected { before body of function %s", fn.Name)
+	}
+	bodyStart := p.pos
+	for depth := 1; depth > 0; {
+		p.skipSpace()
+		if p.pos >= len(p.source) {
+			return nil, p.errorf("missing } after body of function %s", fn.Name)
+		}
+		switch c := p.source[p.pos]; c {
+		case '{':
+			depth++
+			p.pos++
+		case '}':
+			depth--
+			p.pos++
+		case '"', '\'':
+			if _, err := p.string(); err != nil {
+				return nil, err
+			}
+		case '@':
+			if strings.HasPrefix(p.source[p.pos+1:], `"`) || strings.HasPrefix(p.source[p.pos+1:], "'") {
+				if _, err := p.string(); err != nil {
+					return nil, err
+				}
+			} else {
+				p.pos++
+			}
+		case '`':
+			if strings.HasPrefix(p.source[p.pos:], "```") {
+				if _, err := p.string(); err != nil {
+					return nil, err
+				}
+				break
+			}
+			// Skip a quoted identifier.
+			end := strings.IndexByte(p.source[p.pos+1:], '`')
+			if end < 0 {
+				return nil, p.errorf("unterminated quoted identifier")
+			}
+			p.pos += end + 2
+		default:
+			p.pos++
+		}
+	}
+	fn.Body = strings.TrimSpace(p.source[bodyStart : p.pos-1])
+	return fn, nil
+}
+
+// properties reads the parenthesized list after "with".
+func (p *adxParser) properties(fn *StoredFunction) error {
+	p.skipSpace()
+	if !p.consume("(") {
+		return p.errorf("expected ( after with")
+	}
+	for {
+		p.skipSpace()
+		if p.consume(")") {
+			return nil
+		}
+		name := p.word()
+		if name == "" {
+			return p.errorf("expected property name")
+		}
+		p.skipSpace()
+		if !p.consume("=") {
+			return p.errorf("expected = after %s", name)
+		}
+		p.skipSpace()
+		var value string
+		if p.pos < len(p.source) && strings.IndexByte(`"'@`, p.source[p.pos]) >= 0 {
+			var err error
+			value, err = p.string()
+			if err != nil {
+				return err
+			}
+		} else {
+			value = p.word()
+		}
+		switch strings.ToLower(name) {
+		case "docstring":
+			fn.DocString = value
+		case "folder":
+			fn.Folder = value
+		}
+		p.skipSpace()
+		if !p.consume(",") && !strings.HasPrefix(p.source[p.pos:], ")") {
+			return p.errorf("expected , or ) after %s property", name)
+		}
+	}
+}
+
+// string reads a string literal and returns its value.
+// Verbatim strings prefixed with @ and multi-line strings
+// delimited by three backticks are supported.
+func (p *adxParser) string() (string, error) {
+	start := p.pos
+	verbatim := p.consume("@")
+	if !verbatim && p.consume("```") {
+		end := strings.Index(p.source[p.pos:], "```")
+		if end < 0 {
+			p.pos = start
+			return "", p.errorf("unterminated string")
+		}
+		value := p.source[p.pos : p.pos+end]
+		p.pos += end + len("```")
+		return value, nil
+	}
+	if p.pos >= len(p.source) || (p.source[p.pos] != '"' && p.source[p.pos] != '\'') {
+		p.pos = start
+		return "", p.errorf("expected string")
+	}
+	quote := p.source[p.pos]
+	p.pos++
+	sb := new(strings.Builder)
+	for p.pos < len(p.source) {
+		c := p.source[p.pos]
+		p.pos++
+		switch {
+		case c == quote && verbatim && p.pos < len(p.source) && p.source[p.pos] == quote:
+			sb.WriteByte(quote)
+			p.pos++
+		case c == quote:
+			return sb.String(), nil
+		case c == '\\' && !verbatim && p.pos < len(p.source):
+			switch e := p.source[p.pos]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte(e)
+			}
+			p.pos++
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+// word reads a run of characters that may appear in command names and identifiers.
+func (p *adxParser) word() string {
+	start := p.pos
+	for p.pos < len(p.source) {
+		c := p.source[p.pos]
+		if c != '.' && c != '-' && c != '_' && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			break
+		}
+		p.pos++
+	}
+	return p.source[start:p.pos]
+}
+
+// consume advances past s if the source continues with it.
+func (p *adxParser) consume(s string) bool {
+	if !strings.HasPrefix(p.source[p.pos:], s) {
+		return false
+	}
+	p.pos += len(s)
+	return true
+}
+
+// skipSpace advances past whitespace and // comments.
+func (p *adxParser) skipSpace() {
+	for p.pos < len(p.source) {
+		switch {
+		case strings.IndexByte(" \t\r\n", p.source[p.pos]) >= 0:
+			p.pos++
+		case strings.HasPrefix(p.source[p.pos:], "//"):
+			end := strings.IndexByte(p.source[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.source)
+			} else {
+				p.pos += end + 1
+			}
+		default:
+			return
+		}
+	}
+}
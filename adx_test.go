@@ -0,0 +1,77 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseADXFunctions(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    []*StoredFunction
+		wantErr string
+	}{
+		{
+			name:   "Empty",
+			source: "// No functions.\n",
+		},
+		{
+			name: "Multiple",
+			source: ".create-or-alter function with (docstring = \"Failed sign-ins\", folder = @'Security\\Auth', skipvalidation = true)\n" +
+				"FailedSignIns() {\n" +
+				"    SigninLogs | where ResultType != 0 and Note != \"}\"\n" +
+				"}\n" +
+				"\n" +
+				"// Comments between commands are ignored.\n" +
+				".create function ifnotexists Threshold() { 10 }\n" +
+				".alter function Nested(){let x = 1; T | where a == x}",
+			want: []*StoredFunction{
+				{
+					Name:      "FailedSignIns",
+					Body:      "SigninLogs | where ResultType != 0 and Note != \"}\"",
+					DocString: "Failed sign-ins",
+					Folder:    `Security\Auth`,
+				},
+				{Name: "Threshold", Body: "10"},
+				{Name: "Nested", Body: "let x = 1; T | where a == x"},
+			},
+		},
+		{
+			name:    "Parameters",
+			source:  ".create function F(x: int) { T | take x }",
+			wantErr: `1:20: function F has parameters, which are not supported`,
+		},
+		{
+			name:    "NotAFunction",
+			source:  ".create table T (a: int)",
+			wantErr: `1:9: expected function, got "table"`,
+		},
+		{
+			name:    "Unterminated",
+			source:  ".create function F() { T | where a == '}' ",
+			wantErr: `1:43: missing } after body of function F`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseADXFunctions(test.source)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Errorf("ParseADXFunctions(%q) error = %v; want %s", test.source, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ParseADXFunctions(%q) (-want +got):\n%s", test.source, diff)
+			}
+		})
+	}
+}
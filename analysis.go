@@ -0,0 +1,1320 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:generate stringer -type=CompletionKind
+
+package pql
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// AnalysisContext is information about the eventual execution environment
+// passed in to assist in analysis tasks.
+type AnalysisContext struct {
+	Tables map[string]*AnalysisTable
+
+	// Databases is a map of database names to the tables they contain.
+	// Tables in a database are referred to by qualified names
+	// like "security.Events".
+	Databases map[string]*AnalysisDatabase
+
+	// Provider is an optional source of tables
+	// consulted for tables that are not present in Tables.
+	Provider TableProvider
+
+	// FuzzyMatch enables approximate matching of completions.
+	// If false, SuggestCompletions only returns completions
+	// whose labels start with the identifier before the cursor.
+	// If true, SuggestCompletions also returns completions
+	// that match case-insensitively, by camel-hump abbreviation (e.g. "SE" for "StormEvents"),
+	// by substring, or by subsequence.
+	// Results are ranked by match quality in that order.
+	FuzzyMatch bool
+
+	// Parameters is the set of query parameters available to the query,
+	// in the same form as [CompileOptions.Parameters].
+	// Parameter names are suggested as completions in expressions
+	// unless a let statement shadows them.
+	Parameters map[string]string
+
+	// Tracer, if not nil, is notified of each call to SuggestCompletionsContext
+	// and to the SuggestCompletions method of its completion sessions.
+	Tracer Tracer
+}
+
+// TableProvider is the interface implemented by types
+// that fetch table schemas on demand,
+// such as from a catalog service.
+type TableProvider interface {
+	// LookupTable returns the schema of the table with the given name.
+	// LookupTable returns (nil, nil) if no such table exists.
+	LookupTable(ctx context.Context, name string) (*AnalysisTable, error)
+	// ListTables returns the names of the tables that start with the given prefix.
+	// The prefix may be empty, in which case all tables should be returned.
+	ListTables(ctx context.Context, prefix string) ([]string, error)
+}
+
+// AnalysisTable is the schema of a table.
+type AnalysisTable struct {
+	Columns []*AnalysisColumn
+
+	// Description is an optional human-readable description of the table.
+	Description string
+}
+
+// AnalysisDatabase is the schema of a database.
+type AnalysisDatabase struct {
+	Tables map[string]*AnalysisTable
+
+	// Description is an optional human-readable description of the database.
+	Description string
+}
+
+// AnalysisColumn is information about a column.
+type AnalysisColumn struct {
+	Name string
+
+	// Type is an optional name of the column's data type, like "String".
+	Type string
+	// Description is an optional human-readable description of the column.
+	Description string
+
+	// Fields is the list of known nested fields
+	// for a column with a structured type, like a JSON object.
+	// Fields may themselves have nested fields.
+	Fields []*AnalysisColumn
+}
+
+// CompletionKind is an enumeration of the types of items
+// that a [Completion] can insert.
+type CompletionKind int
+
+// Completion kinds.
+const (
+	// CompletionTable is the name of a table.
+	CompletionTable CompletionKind = 1 + iota
+	// CompletionColumn is the name of a column.
+	CompletionColumn
+	// CompletionFunction is the name of a scalar or aggregation function.
+	CompletionFunction
+	// CompletionKeyword is a keyword like "by".
+	CompletionKeyword
+	// CompletionOperator is the name of a tabular operator like "where".
+	CompletionOperator
+	// CompletionVariable is a name bound by a let statement or a query parameter.
+	CompletionVariable
+	// CompletionField is the name of a nested field in a column.
+	CompletionField
+	// CompletionDatabase is the name of a database.
+	CompletionDatabase
+)
+
+// Completion is a single completion suggestion.
+type Completion struct {
+	// Label is the text that should be displayed for the completion.
+	Label string
+	// Text is the text that should be inserted in place of Span.
+	Text string
+	// Span is the range of the source that should be replaced by Text.
+	// It includes any partially typed identifier before the cursor.
+	Span parser.Span
+
+	// Kind is the type of item the completion inserts.
+	Kind CompletionKind
+	// Detail is a short piece of additional information about the item,
+	// like a column's type or a function's signature.
+	Detail string
+	// Documentation is a human-readable description of the item.
+	// It may be empty.
+	Documentation string
+	// SortText is the key that should be used to order the completion
+	// relative to others from the same call.
+	// SuggestCompletions returns completions sorted by this key.
+	SortText string
+}
+
+// SuggestCompletions suggests possible snippets to insert
+// given a partial pql statement and a selected range.
+// Errors from the context's [TableProvider] are ignored.
+// Use [*AnalysisContext.SuggestCompletionsContext] to observe them.
+func (ac *AnalysisContext) SuggestCompletions(source string, cursor parser.Span) []*Completion {
+	completions, _ := ac.SuggestCompletionsContext(context.Background(), source, cursor)
+	return completions
+}
+
+// SuggestCompletionsContext suggests possible snippets to insert
+// given a partial pql statement and a selected range.
+// The given context is passed to the AnalysisContext's [TableProvider], if any.
+// If the provider returns an error,
+// SuggestCompletionsContext returns the completions it could compute
+// along with the error.
+func (ac *AnalysisContext) SuggestCompletionsContext(ctx context.Context, source string, cursor parser.Span) ([]*Completion, error) {
+	return ac.suggestCompletions(ctx, nil, source, cursor)
+}
+
+// suggestCompletions implements [*AnalysisContext.SuggestCompletionsContext].
+// session may be nil.
+func (ac *AnalysisContext) suggestCompletions(ctx context.Context, session *CompletionSession, source string, cursor parser.Span) (_ []*Completion, err error) {
+	if ac != nil && ac.Tracer != nil {
+		var end func(err error)
+		ctx, end = ac.Tracer.Start(ctx, TracePhaseComplete)
+		defer func() { end(err) }()
+	}
+	if !cursor.IsValid() || cursor.End > len(source) {
+		return nil, nil
+	}
+	var tokens []parser.Token
+	if session != nil {
+		tokens = session.tokensBefore(cursor.Start)
+	} else {
+		tokens = parser.Scan(source[:cursor.Start])
+	}
+
+	// Find the partially typed identifier (if any) immediately before the cursor.
+	prefix := ""
+	replace := cursor
+	if len(tokens) > 0 {
+		last := tokens[len(tokens)-1]
+		if last.Span.End == cursor.Start {
+			switch last.Kind {
+			case parser.TokenIdentifier, parser.TokenAnd, parser.TokenOr, parser.TokenIn, parser.TokenBy:
+				prefix = source[last.Span.Start:last.Span.End]
+				replace.Start = last.Span.Start
+				tokens = tokens[:len(tokens)-1]
+			case parser.TokenError, parser.TokenString, parser.TokenQuotedIdentifier, parser.TokenNumber:
+				// Cursor is inside a literal.
+				return nil, nil
+			}
+		}
+	}
+
+	// Find the dot-separated path (if any) before the cursor.
+	var fieldPath []string
+	fieldDot := parser.Span{Start: -1, End: -1}
+	for len(tokens) >= 2 && tokens[len(tokens)-1].Kind == parser.TokenDot {
+		n := 2
+		name := tokens[len(tokens)-2]
+		switch {
+		case name.Kind == parser.TokenIdentifier || name.Kind == parser.TokenQuotedIdentifier:
+		case isQualifierCall(tokens[:len(tokens)-1], "database"):
+			n = 5
+			name = tokens[len(tokens)-3]
+		case isQualifierCall(tokens[:len(tokens)-1], "cluster"):
+			// The tables of other clusters are not known.
+			return nil, nil
+		default:
+			n = 0
+		}
+		if n == 0 {
+			break
+		}
+		if fieldPath == nil {
+			fieldDot = tokens[len(tokens)-1].Span
+		}
+		fieldPath = append([]string{name.Value}, fieldPath...)
+		tokens = tokens[:len(tokens)-n]
+	}
+
+	// Only consider the statement the cursor is in.
+	stmtStart := 0
+	for i, tok := range tokens {
+		if tok.Kind == parser.TokenSemi {
+			stmtStart = i + 1
+		}
+	}
+	stmtSourceStart := 0
+	if stmtStart > 0 {
+		stmtSourceStart = tokens[stmtStart-1].Span.End
+	}
+	tokens = tokens[stmtStart:]
+
+	c := &completer{
+		columnResolver: columnResolver{
+			ac:     ac,
+			ctx:    ctx,
+			source: source,
+		},
+		session: session,
+		prefix:  prefix,
+		fuzzy:   ac != nil && ac.FuzzyMatch,
+		replace: replace,
+
+		fieldPath: fieldPath,
+		fieldDot:  fieldDot,
+	}
+	c.addLets(source[:stmtSourceStart])
+	if len(tokens) > 0 && tokens[0].Kind == parser.TokenIdentifier && tokens[0].Value == "let" {
+		if len(tokens) < 3 || tokens[2].Kind != parser.TokenAssign {
+			return c.finish()
+		}
+		value := tokens[3:]
+		if hasTokenKind(value, parser.TokenPipe) {
+			c.tabularExpr(tokens[2].Span.End, value)
+		} else {
+			c.tables()
+			c.scalarExpr(nil)
+		}
+		return c.finish()
+	}
+	c.tabularExpr(stmtSourceStart, tokens)
+	return c.finish()
+}
+
+// completionFrame is a tabular expression being analyzed for completion.
+type completionFrame struct {
+	// start is the position in the source where the tabular expression begins.
+	start int
+	// tokens is the list of tokens in the frame, excluding nested frames.
+	tokens []parser.Token
+	// lastPipe is the index into tokens of the last pipe at the top level,
+	// or -1 if there are no pipes.
+	lastPipe int
+	// parenDepth is the number of unclosed expression parentheses.
+	parenDepth int
+	// joinRight is the span of the most recently closed join subexpression
+	// in the current operator.
+	joinRight parser.Span
+}
+
+func (f *completionFrame) operatorTokens() []parser.Token {
+	if f.lastPipe < 0 {
+		return nil
+	}
+	return f.tokens[f.lastPipe+1:]
+}
+
+// sourceTokens returns the tokens of the current operator,
+// or the tokens of the data source if there are no pipes.
+func (f *completionFrame) sourceTokens() []parser.Token {
+	if f.lastPipe < 0 {
+		return f.tokens
+	}
+	return f.operatorTokens()
+}
+
+type completer struct {
+	columnResolver
+	session *CompletionSession // may be nil
+	prefix  string
+	fuzzy   bool
+	replace parser.Span
+
+	// fieldPath is the list of identifiers before the dot
+	// preceding the cursor, if any.
+	fieldPath []string
+	// fieldDot is the span of the dot preceding the cursor
+	// when fieldPath is not empty.
+	fieldDot parser.Span
+
+	// lets is the list of names bound to scalar expressions
+	// by let statements before the cursor's statement.
+	// The embedded columnResolver's tabularLets
+	// are the tabular let statements before the cursor's statement.
+	lets []string
+
+	result []*Completion
+}
+
+func (c *completer) tabularExpr(start int, tokens []parser.Token) {
+	frames := []*completionFrame{{
+		start:     start,
+		lastPipe:  -1,
+		joinRight: parser.Span{Start: -1, End: -1},
+	}}
+	for _, tok := range tokens {
+		top := frames[len(frames)-1]
+		switch tok.Kind {
+		case parser.TokenLParen:
+			if top.parenDepth == 0 && (len(top.tokens) == 0 || isJoinLparen(top.operatorTokens()) || expectsUnionTable(top.sourceTokens())) {
+				top.tokens = append(top.tokens, tok)
+				frames = append(frames, &completionFrame{
+					start:     tok.Span.End,
+					lastPipe:  -1,
+					joinRight: parser.Span{Start: -1, End: -1},
+				})
+				continue
+			}
+			top.parenDepth++
+		case parser.TokenRParen:
+			if top.parenDepth > 0 {
+				top.parenDepth--
+			} else if len(frames) > 1 {
+				frames = frames[:len(frames)-1]
+				parent := frames[len(frames)-1]
+				parent.joinRight = parser.Span{Start: top.start, End: tok.Span.Start}
+				parent.tokens = append(parent.tokens, tok)
+				continue
+			}
+		case parser.TokenPipe:
+			if top.parenDepth == 0 {
+				top.lastPipe = len(top.tokens)
+				top.joinRight = parser.Span{Start: -1, End: -1}
+			}
+		}
+		top.tokens = append(top.tokens, tok)
+	}
+
+	top := frames[len(frames)-1]
+	if top.lastPipe < 0 {
+		switch {
+		case len(top.tokens) == 0:
+			c.tables()
+		case expectsUnionTable(top.tokens):
+			if len(top.tokens) == 1 {
+				c.keyword("withsource", 1)
+			}
+			c.tables()
+		case len(top.tokens) == 2 &&
+			top.tokens[0].Kind == parser.TokenIdentifier &&
+			top.tokens[0].Value == "database" &&
+			top.tokens[1].Kind == parser.TokenLParen:
+			c.databaseNames()
+		}
+		return
+	}
+	opTokens := top.operatorTokens()
+	if len(opTokens) == 0 {
+		c.operators()
+		return
+	}
+	if opTokens[0].Kind != parser.TokenIdentifier {
+		return
+	}
+	cols := c.pipelineColumns(parser.Span{
+		Start: top.start,
+		End:   top.tokens[top.lastPipe].Span.Start,
+	})
+	last := opTokens[len(opTokens)-1]
+	switch operatorName(opTokens) {
+	case "where", "filter", "extend", "project", "mv-expand":
+		c.scalarExpr(cols)
+	case "distinct", "project-away":
+		c.columns(cols, columnSortRank)
+	case "summarize":
+		c.summarizeExpr(cols, opTokens)
+	case "sort", "order":
+		if !hasTokenKind(opTokens, parser.TokenBy) {
+			c.keyword("by", 0)
+			return
+		}
+		if last.Kind == parser.TokenBy || last.Kind == parser.TokenComma {
+			c.scalarExpr(cols)
+			return
+		}
+		c.keyword("asc", 1)
+		c.keyword("desc", 1)
+		c.scalarExpr(cols)
+	case "top":
+		if len(opTokens) > 1 && !hasTokenKind(opTokens, parser.TokenBy) {
+			c.keyword("by", 0)
+			return
+		}
+		if hasTokenKind(opTokens, parser.TokenBy) {
+			c.scalarExpr(cols)
+		}
+	case "union":
+		if expectsUnionTable(opTokens) {
+			if len(opTokens) == 1 {
+				c.keyword("withsource", 1)
+			}
+			c.tables()
+		}
+	case "join":
+		switch {
+		case top.joinRight.IsValid():
+			if !hasIdent(opTokens, "on") {
+				c.keyword("on", 0)
+				return
+			}
+			rightCols := c.pipelineColumns(top.joinRight)
+			c.joinConditions(cols, rightCols)
+		case len(opTokens) == 1:
+			c.keyword("kind", 0)
+		case last.Kind == parser.TokenAssign && len(opTokens) >= 2 && opTokens[len(opTokens)-2].Value == "kind":
+			for _, flavor := range joinFlavors {
+				c.keyword(flavor, 0)
+			}
+		}
+	}
+}
+
+// joinConditions adds completions for the conditions of a join operator.
+// Columns from either side can be referred to
+// with the $left and $right prefixes.
+// Unqualified column names are only suggested
+// if they are present on both sides of the join.
+func (c *completer) joinConditions(leftCols, rightCols []*AnalysisColumn) {
+	if len(c.fieldPath) > 0 {
+		var cols []*AnalysisColumn
+		switch c.fieldPath[0] {
+		case leftJoinTableAlias:
+			cols = leftCols
+		case rightJoinTableAlias:
+			cols = rightCols
+		default:
+			return
+		}
+		if len(c.fieldPath) == 1 {
+			c.columns(cols, columnSortRank)
+			return
+		}
+		prevPath := c.fieldPath
+		c.fieldPath = c.fieldPath[1:]
+		c.fields(cols)
+		c.fieldPath = prevPath
+		return
+	}
+
+	c.add(&Completion{
+		Label:         leftJoinTableAlias,
+		Text:          leftJoinTableAlias + ".",
+		Kind:          CompletionKeyword,
+		Documentation: "Refers to a column from the left side of the join.",
+	}, 0)
+	c.add(&Completion{
+		Label:         rightJoinTableAlias,
+		Text:          rightJoinTableAlias + ".",
+		Kind:          CompletionKeyword,
+		Documentation: "Refers to a column from the right side of the join.",
+	}, 0)
+	var common []*AnalysisColumn
+	for _, col := range leftCols {
+		if columnIndex(rightCols, col.Name) >= 0 {
+			common = append(common, col)
+		}
+	}
+	c.columns(common, columnSortRank+1)
+	c.variables(letSortRank + 1)
+	for name, f := range initKnownFunctions() {
+		c.function(name, f.signature, f.doc, functionSortRank+1)
+	}
+}
+
+// isJoinLparen reports whether the next left parenthesis
+// in the given operator tokens starts the right side of a join.
+func isJoinLparen(opTokens []parser.Token) bool {
+	if len(opTokens) == 0 || opTokens[0].Kind != parser.TokenIdentifier || opTokens[0].Value != "join" {
+		return false
+	}
+	return !hasTokenKind(opTokens, parser.TokenLParen) && !hasTokenKind(opTokens, parser.TokenRParen)
+}
+
+// expectsUnionTable reports whether tokens are a union
+// whose next token begins one of its arguments.
+func expectsUnionTable(tokens []parser.Token) bool {
+	if len(tokens) == 0 || tokens[0].Kind != parser.TokenIdentifier || tokens[0].Value != "union" {
+		return false
+	}
+	return len(tokens) == 1 ||
+		tokens[len(tokens)-1].Kind == parser.TokenComma ||
+		len(tokens) == 4 && tokens[1].Value == "withsource" && tokens[2].Kind == parser.TokenAssign
+}
+
+// operatorName returns the name of the operator that opTokens begin with,
+// joining directly adjacent hyphenated parts like those of mv-expand.
+func operatorName(opTokens []parser.Token) string {
+	name := opTokens[0].Value
+	for i := 1; i+1 < len(opTokens); i += 2 {
+		minus, part := opTokens[i], opTokens[i+1]
+		if minus.Kind != parser.TokenMinus || part.Kind != parser.TokenIdentifier ||
+			minus.Span.Start != opTokens[i-1].Span.End || part.Span.Start != minus.Span.End {
+			break
+		}
+		name += "-" + part.Value
+	}
+	return name
+}
+
+// isQualifierCall reports whether tokens end with a call like database("security").
+func isQualifierCall(tokens []parser.Token, funcName string) bool {
+	if len(tokens) < 4 {
+		return false
+	}
+	call := tokens[len(tokens)-4:]
+	return call[0].Kind == parser.TokenIdentifier &&
+		call[0].Value == funcName &&
+		call[1].Kind == parser.TokenLParen &&
+		call[2].Kind == parser.TokenString &&
+		call[3].Kind == parser.TokenRParen
+}
+
+func hasTokenKind(tokens []parser.Token, kind parser.TokenKind) bool {
+	for _, tok := range tokens {
+		if tok.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func hasIdent(tokens []parser.Token, name string) bool {
+	for _, tok := range tokens {
+		if tok.Kind == parser.TokenIdentifier && tok.Value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// addLets records the let statements in the given prefix of the source.
+func (c *completer) addLets(prelude string) {
+	if c.session != nil && c.session.scopeValid && c.session.prelude == prelude {
+		c.lets = c.session.lets
+		c.tabularLets = c.session.tabularLets
+		c.visibleTabularLets = len(c.tabularLets)
+		return
+	}
+	defer func() {
+		if c.session != nil {
+			c.session.setScope(prelude, c.lets, c.tabularLets)
+		}
+	}()
+
+	var stmts []parser.Statement
+	if c.session != nil {
+		stmts = c.session.statementsBefore(len(prelude))
+	} else {
+		stmts, _ = parser.Parse(prelude)
+	}
+	for _, stmt := range stmts {
+		let, ok := stmt.(*parser.LetStatement)
+		if !ok || let.Name == nil {
+			continue
+		}
+		if let.Tabular != nil {
+			c.tabularLets = append(c.tabularLets, let)
+		} else {
+			c.lets = append(c.lets, let.Name.Name)
+		}
+	}
+	c.visibleTabularLets = len(c.tabularLets)
+}
+
+// Sort ranks for completions.
+// Lower ranks sort earlier.
+const (
+	aggregateSortRank = 0
+	columnSortRank    = 0
+	letSortRank       = 1
+	functionSortRank  = 2
+)
+
+func (c *completer) tables() {
+	if len(c.fieldPath) > 0 {
+		c.databaseTables()
+		return
+	}
+	for _, let := range c.tabularLets {
+		c.add(&Completion{
+			Label:  let.Name.Name,
+			Text:   formatIdent(let.Name.Name),
+			Kind:   CompletionTable,
+			Detail: "let",
+		}, 0)
+	}
+	if c.ac == nil {
+		return
+	}
+	for name, tbl := range c.ac.Tables {
+		c.add(&Completion{
+			Label:         name,
+			Text:          formatIdent(name),
+			Kind:          CompletionTable,
+			Detail:        "table",
+			Documentation: tbl.Description,
+		}, 0)
+	}
+	for name, db := range c.ac.Databases {
+		c.add(&Completion{
+			Label:         name,
+			Text:          formatIdent(name),
+			Kind:          CompletionDatabase,
+			Detail:        "database",
+			Documentation: db.Description,
+		}, 1)
+	}
+	if c.ac.Provider == nil {
+		return
+	}
+	listPrefix := c.prefix
+	if c.fuzzy {
+		// Fuzzy matches don't necessarily share a prefix.
+		listPrefix = ""
+	}
+	names, err := c.ac.Provider.ListTables(c.ctx, listPrefix)
+	if err != nil {
+		c.fail(fmt.Errorf("list tables: %w", err))
+		return
+	}
+	for _, name := range names {
+		if _, inMap := c.ac.Tables[name]; inMap {
+			continue
+		}
+		c.add(&Completion{
+			Label:  name,
+			Text:   formatIdent(name),
+			Kind:   CompletionTable,
+			Detail: "table",
+		}, 0)
+	}
+}
+
+// databaseNames adds completions for the names of databases
+// as string literals for the argument of a database() call.
+func (c *completer) databaseNames() {
+	if c.ac == nil || len(c.fieldPath) > 0 {
+		return
+	}
+	for name, db := range c.ac.Databases {
+		c.add(&Completion{
+			Label:         name,
+			Text:          formatString(name),
+			Kind:          CompletionDatabase,
+			Detail:        "database",
+			Documentation: db.Description,
+		}, 0)
+	}
+}
+
+// databaseTables adds completions for the tables
+// in the database named before the cursor.
+func (c *completer) databaseTables() {
+	if c.ac == nil || len(c.fieldPath) != 1 {
+		return
+	}
+	db := c.ac.Databases[c.fieldPath[0]]
+	if db == nil {
+		return
+	}
+	for name, tbl := range db.Tables {
+		c.add(&Completion{
+			Label:         name,
+			Text:          formatIdent(name),
+			Kind:          CompletionTable,
+			Detail:        "table",
+			Documentation: tbl.Description,
+		}, 0)
+	}
+}
+
+// unionColumns returns the columns of the given tables,
+// in the order they first appear.
+// Columns with the same name are only included once.
+func unionColumns(tables []*AnalysisTable) []*AnalysisColumn {
+	var cols []*AnalysisColumn
+	seen := make(map[string]struct{})
+	for _, tbl := range tables {
+		for _, col := range tbl.Columns {
+			if _, dup := seen[col.Name]; dup {
+				continue
+			}
+			seen[col.Name] = struct{}{}
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// matchTables returns the sorted names of the tables
+// whose names match the given wildcard pattern.
+// If database is not empty, only tables in that database are matched.
+// Otherwise, the tables in ac.Tables and ac.Provider are matched.
+func (ac *AnalysisContext) matchTables(ctx context.Context, database, pattern string) ([]string, error) {
+	var names []string
+	if database != "" {
+		if db := ac.Databases[database]; db != nil {
+			for name := range db.Tables {
+				if matchWildcard(pattern, name) {
+					names = append(names, name)
+				}
+			}
+		}
+		slices.Sort(names)
+		return names, nil
+	}
+
+	for name := range ac.Tables {
+		if matchWildcard(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	if ac.Provider != nil {
+		prefix, _, _ := strings.Cut(pattern, "*")
+		listed, err := ac.Provider.ListTables(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("list tables: %w", err)
+		}
+		for _, name := range listed {
+			if _, inMap := ac.Tables[name]; !inMap && matchWildcard(pattern, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// matchWildcard reports whether name matches pattern,
+// where an asterisk in pattern matches any sequence of characters.
+func matchWildcard(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return len(name) >= len(last) && strings.HasSuffix(name, last)
+}
+
+var tabularOperatorCompletions = []struct {
+	name   string
+	detail string
+	doc    string
+}{
+	{"as", "as Name", "Binds a name to the operator's input tabular expression."},
+	{"count", "count", "Returns the number of records in the input."},
+	{"distinct", "distinct Column, ...", "Produces a table with the distinct combinations of the given columns."},
+	{"extend", "extend [Column =] Expression, ...", "Creates calculated columns and appends them to the result."},
+	{"filter", "filter Predicate", "Filters the input to the rows that satisfy a predicate."},
+	{"join", "join [kind = Flavor] (Right) on Conditions", "Merges the rows of two tables by matching values."},
+	{"limit", "limit NumberOfRows", "Returns up to the specified number of rows."},
+	{"order", "order by Column [asc | desc], ...", "Sorts the rows of the input by one or more columns."},
+	{"mv-expand", "mv-expand [Column =] ArrayExpression, ...", "Expands arrays into a row for each element."},
+	{"project", "project Column [= Expression], ...", "Selects the columns to include, rename, or compute."},
+	{"project-away", "project-away Column, ...", "Removes the given columns from the result."},
+	{"render", "render Visualization [with (Property = Value, ...)]", "Instructs the user agent to render a visualization of the results."},
+	{"sort", "sort by Column [asc | desc], ...", "Sorts the rows of the input by one or more columns."},
+	{"summarize", "summarize [Column =] Aggregation, ... [by [Column =] GroupExpression, ...]", "Produces a table that aggregates the content of the input."},
+	{"take", "take NumberOfRows", "Returns up to the specified number of rows."},
+	{"top", "top NumberOfRows by Expression [asc | desc]", "Returns the first N rows sorted by the specified expression."},
+	{"union", "union [withsource = Column] Table, ...", "Returns the rows of the input and of one or more other tables."},
+	{"where", "where Predicate", "Filters the input to the rows that satisfy a predicate."},
+}
+
+func (c *completer) operators() {
+	for _, op := range tabularOperatorCompletions {
+		c.add(&Completion{
+			Label:         op.name,
+			Text:          op.name,
+			Kind:          CompletionOperator,
+			Detail:        op.detail,
+			Documentation: op.doc,
+		}, 0)
+	}
+}
+
+var joinFlavors = []string{
+	"cross",
+	"fullouter",
+	"inner",
+	"innerunique",
+	"leftanti",
+	"leftouter",
+	"leftsemi",
+	"rightanti",
+	"rightouter",
+	"rightsemi",
+}
+
+func (c *completer) keyword(name string, rank int) {
+	if len(c.fieldPath) > 0 {
+		// Keywords can't follow a dot.
+		return
+	}
+	c.add(&Completion{
+		Label: name,
+		Text:  name,
+		Kind:  CompletionKeyword,
+	}, rank)
+}
+
+// scalarExpr adds completions for a scalar expression
+// that has the given columns in scope.
+func (c *completer) scalarExpr(cols []*AnalysisColumn) {
+	if len(c.fieldPath) > 0 {
+		c.fields(cols)
+		return
+	}
+	c.columns(cols, columnSortRank)
+	c.variables(letSortRank)
+	for name, f := range initKnownFunctions() {
+		c.function(name, f.signature, f.doc, functionSortRank)
+	}
+}
+
+// summarizeExpr adds completions for the arguments of a summarize operator.
+// opTokens is the operator's tokens before the cursor.
+//
+// At the top level of an aggregation (before "by"),
+// aggregate functions are suggested first.
+// In the group expressions (after "by"),
+// columns are suggested first and aggregate functions are omitted.
+// Inside parentheses, summarizeExpr suggests a plain scalar expression.
+func (c *completer) summarizeExpr(cols []*AnalysisColumn, opTokens []parser.Token) {
+	if len(c.fieldPath) > 0 {
+		c.fields(cols)
+		return
+	}
+	depth := 0
+	for _, tok := range opTokens {
+		switch tok.Kind {
+		case parser.TokenLParen:
+			depth++
+		case parser.TokenRParen:
+			depth = max(depth-1, 0)
+		}
+	}
+	if depth > 0 {
+		c.scalarExpr(cols)
+		return
+	}
+
+	if hasTokenKind(opTokens, parser.TokenBy) {
+		c.columns(cols, columnSortRank)
+		c.variables(letSortRank)
+		for name, f := range initKnownFunctions() {
+			if !f.aggregate {
+				c.function(name, f.signature, f.doc, functionSortRank)
+			}
+		}
+		return
+	}
+
+	for name, f := range initKnownFunctions() {
+		if f.aggregate {
+			c.function(name, f.signature, f.doc, aggregateSortRank)
+		}
+	}
+	for _, f := range sqlAggregateFunctions {
+		c.function(f.name, f.signature, f.doc, aggregateSortRank)
+	}
+	c.columns(cols, columnSortRank+1)
+	c.variables(letSortRank + 1)
+	for name, f := range initKnownFunctions() {
+		if !f.aggregate {
+			c.function(name, f.signature, f.doc, functionSortRank+1)
+		}
+	}
+	if len(opTokens) > 1 {
+		c.keyword("by", columnSortRank+1)
+	}
+}
+
+// sqlAggregateFunctions is the set of aggregate functions
+// that have no entry in knownFunctions
+// because they are passed through to SQL unchanged.
+var sqlAggregateFunctions = []struct {
+	name      string
+	signature string
+	doc       string
+}{
+	{"avg", "avg(x)", "Returns the average of x across the group."},
+	{"max", "max(x)", "Returns the maximum value of x across the group."},
+	{"min", "min(x)", "Returns the minimum value of x across the group."},
+	{"sum", "sum(x)", "Returns the sum of x across the group."},
+}
+
+func (c *completer) columns(cols []*AnalysisColumn, rank int) {
+	for _, col := range cols {
+		c.add(&Completion{
+			Label:         col.Name,
+			Text:          formatIdent(col.Name),
+			Kind:          CompletionColumn,
+			Detail:        col.Type,
+			Documentation: col.Description,
+		}, rank)
+	}
+}
+
+// variables adds completions for the let statements
+// and parameters in scope.
+func (c *completer) variables(rank int) {
+	for _, name := range c.lets {
+		c.add(&Completion{
+			Label:  name,
+			Text:   name,
+			Kind:   CompletionVariable,
+			Detail: "let",
+		}, rank)
+	}
+	if c.ac == nil {
+		return
+	}
+	for name := range c.ac.Parameters {
+		if slices.Contains(c.lets, name) {
+			continue
+		}
+		c.add(&Completion{
+			Label:  name,
+			Text:   name,
+			Kind:   CompletionVariable,
+			Detail: "parameter",
+		}, rank)
+	}
+}
+
+func (c *completer) function(name, signature, doc string, rank int) {
+	c.add(&Completion{
+		Label:         name,
+		Text:          name + "(",
+		Kind:          CompletionFunction,
+		Detail:        signature,
+		Documentation: doc,
+	}, rank)
+}
+
+// fields adds completions for the nested fields
+// of the column path before the cursor.
+func (c *completer) fields(cols []*AnalysisColumn) {
+	i := columnIndex(cols, c.fieldPath[0])
+	if i < 0 {
+		return
+	}
+	col := cols[i]
+	for _, name := range c.fieldPath[1:] {
+		i := columnIndex(col.Fields, name)
+		if i < 0 {
+			return
+		}
+		col = col.Fields[i]
+	}
+	for _, field := range col.Fields {
+		comp := &Completion{
+			Label:         field.Name,
+			Text:          field.Name,
+			Kind:          CompletionField,
+			Detail:        field.Type,
+			Documentation: field.Description,
+		}
+		if isPlainIdent(field.Name) {
+			c.add(comp, columnSortRank)
+			continue
+		}
+		// Use index syntax for names that can't be written after a dot.
+		sb := new(strings.Builder)
+		sb.WriteString("[")
+		quotePQLString(sb, field.Name)
+		sb.WriteString("]")
+		comp.Text = sb.String()
+		c.addSpan(comp, columnSortRank, parser.Span{
+			Start: c.fieldDot.Start,
+			End:   c.replace.End,
+		})
+	}
+}
+
+// add adds the completion to the result list if it matches the prefix.
+func (c *completer) add(comp *Completion, rank int) {
+	c.addSpan(comp, rank, c.replace)
+}
+
+// addSpan adds the completion to the result list if it matches the prefix,
+// replacing the given span instead of the typed identifier.
+func (c *completer) addSpan(comp *Completion, rank int, replace parser.Span) {
+	quality := matchCompletion(comp.Label, c.prefix, c.fuzzy)
+	if quality == noMatch {
+		return
+	}
+	comp.Span = replace
+	comp.SortText = fmt.Sprintf("%d_%d_%s", quality, rank, comp.Label)
+	c.result = append(c.result, comp)
+}
+
+// matchQuality is a ranking of how well a completion's label
+// matches the typed text.
+// Lower values are better matches.
+type matchQuality int
+
+const (
+	prefixMatch matchQuality = iota
+	foldedPrefixMatch
+	camelHumpMatch
+	substringMatch
+	subsequenceMatch
+
+	noMatch matchQuality = -1
+)
+
+// matchCompletion reports how well label matches the typed text.
+// If fuzzy is false, only case-sensitive prefix matches are considered.
+func matchCompletion(label, typed string, fuzzy bool) matchQuality {
+	switch {
+	case strings.HasPrefix(label, typed):
+		return prefixMatch
+	case !fuzzy:
+		return noMatch
+	case len(label) >= len(typed) && strings.EqualFold(label[:len(typed)], typed):
+		return foldedPrefixMatch
+	case isCamelHumpMatch(label, typed):
+		return camelHumpMatch
+	case strings.Contains(strings.ToLower(label), strings.ToLower(typed)):
+		return substringMatch
+	case isSubsequence(strings.ToLower(label), strings.ToLower(typed)):
+		return subsequenceMatch
+	default:
+		return noMatch
+	}
+}
+
+// isCamelHumpMatch reports whether each character in typed matches
+// either the character after the previously matched one in label
+// or the start of a later "hump" in label.
+// Humps start at uppercase letters that follow lowercase letters
+// and at characters following an underscore or space.
+// Matching is case-insensitive.
+func isCamelHumpMatch(label, typed string) bool {
+	if typed == "" {
+		return true
+	}
+	labelRunes := []rune(label)
+	i := 0
+	for _, c := range typed {
+		if i < len(labelRunes) && equalFoldRune(labelRunes[i], c) {
+			i++
+			continue
+		}
+		next := -1
+		for j := i + 1; j < len(labelRunes); j++ {
+			if isHumpStart(labelRunes, j) && equalFoldRune(labelRunes[j], c) {
+				next = j
+				break
+			}
+		}
+		if next < 0 {
+			return false
+		}
+		i = next + 1
+	}
+	return true
+}
+
+func isHumpStart(s []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev := s[i-1]
+	return prev == '_' || prev == ' ' || unicode.IsUpper(s[i]) && !unicode.IsUpper(prev)
+}
+
+func equalFoldRune(c1, c2 rune) bool {
+	return unicode.ToLower(c1) == unicode.ToLower(c2)
+}
+
+// isSubsequence reports whether the characters of sub
+// appear in s in the same order.
+func isSubsequence(s, sub string) bool {
+	for _, c := range sub {
+		i := strings.IndexRune(s, c)
+		if i < 0 {
+			return false
+		}
+		s = s[i+utf8.RuneLen(c):]
+	}
+	return true
+}
+
+func (c *completer) finish() ([]*Completion, error) {
+	slices.SortStableFunc(c.result, func(a, b *Completion) int {
+		return strings.Compare(a.SortText, b.SortText)
+	})
+	result := slices.CompactFunc(c.result, func(a, b *Completion) bool {
+		return a.Label == b.Label && a.Kind == b.Kind
+	})
+	return result, c.err
+}
+
+// pipelineColumns returns the columns produced by the tabular expression
+// in the given span of source.
+// It returns nil if the columns cannot be determined.
+func (c *completer) pipelineColumns(span parser.Span) []*AnalysisColumn {
+	source := c.source[span.Start:span.End]
+	if c.session != nil {
+		if cols, ok := c.session.columns[source]; ok {
+			return cols
+		}
+	}
+	stmts, _ := parser.Parse(source)
+	if len(stmts) == 0 {
+		return nil
+	}
+	expr, ok := stmts[0].(*parser.TabularExpr)
+	if !ok {
+		return nil
+	}
+	prevErr := c.err
+	cols := c.tabularColumns(source, expr)
+	if c.session != nil && c.err == prevErr {
+		c.session.cacheColumns(source, cols)
+	}
+	return cols
+}
+
+// derivedColumnName returns the name of a column
+// in the same way the compiler names it.
+func derivedColumnName(source string, name *parser.Ident, x parser.Expr) string {
+	if name != nil {
+		return name.Name
+	}
+	span := x.Span()
+	if !span.IsValid() || span.End > len(source) {
+		return ""
+	}
+	return source[span.Start:span.End]
+}
+
+// exprType returns the name of the data type of x
+// evaluated on rows with the given columns,
+// or the empty string if the type is not known.
+// Types are named as in ClickHouse.
+func exprType(cols []*AnalysisColumn, x parser.Expr) string {
+	switch x := x.(type) {
+	case *parser.QualifiedIdent:
+		if len(x.Parts) != 1 {
+			return ""
+		}
+		if i := columnIndex(cols, x.Parts[0].Name); i >= 0 {
+			return cols[i].Type
+		}
+		switch x.Parts[0].Name {
+		case "true", "false":
+			return "Bool"
+		}
+		return ""
+	case *parser.BasicLit:
+		switch {
+		case x.Kind == parser.TokenString:
+			return "String"
+		case strings.ContainsAny(x.Value, ".eE"):
+			return "Float64"
+		default:
+			return "Int64"
+		}
+	case *parser.ParenExpr:
+		return exprType(cols, x.X)
+	case *parser.InExpr:
+		return "Bool"
+	case *parser.BinaryExpr:
+		switch x.Op {
+		case parser.TokenEq, parser.TokenNE, parser.TokenLT, parser.TokenLE, parser.TokenGT, parser.TokenGE,
+			parser.TokenCaseInsensitiveEq, parser.TokenCaseInsensitiveNE,
+			parser.TokenAnd, parser.TokenOr:
+			return "Bool"
+		}
+		return ""
+	case *parser.CallExpr:
+		switch strings.ToLower(x.Func.Name) {
+		case "count", "countif", "dcount":
+			return "UInt64"
+		case "not", "isnull", "isnotnull":
+			return "Bool"
+		case "strcat", "tolower", "toupper", "tostring":
+			return "String"
+		case "min", "max", "any":
+			if len(x.Args) == 1 {
+				return exprType(cols, x.Args[0])
+			}
+		}
+		return ""
+	default:
+		return ""
+	}
+}
+
+func columnIndex(cols []*AnalysisColumn, name string) int {
+	return slices.IndexFunc(cols, func(col *AnalysisColumn) bool {
+		return col.Name == name
+	})
+}
+
+// arrayElementType returns the type of the elements of an array type
+// like Array(String), or the empty string if typ is not an array type.
+func arrayElementType(typ string) string {
+	elem, ok := strings.CutPrefix(typ, "Array(")
+	if !ok {
+		return ""
+	}
+	elem, _ = strings.CutSuffix(elem, ")")
+	return elem
+}
+
+// setColumn replaces the column with the same name as col in cols
+// or appends col if no such column exists.
+func setColumn(cols []*AnalysisColumn, col *AnalysisColumn) []*AnalysisColumn {
+	if i := columnIndex(cols, col.Name); i >= 0 {
+		cols[i] = col
+		return cols
+	}
+	return append(cols, col)
+}
+
+// mergeColumns returns the columns in a
+// followed by the columns in b that do not appear in a.
+func mergeColumns(a, b []*AnalysisColumn) []*AnalysisColumn {
+	result := slices.Clone(a)
+	for _, col := range b {
+		if columnIndex(result, col.Name) < 0 {
+			result = append(result, col)
+		}
+	}
+	return result
+}
+
+// formatIdent returns name as it should appear in pql source,
+// quoting it with backticks if necessary.
+func formatIdent(name string) string {
+	if isPlainIdent(name) {
+		return name
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// formatString returns s as a double-quoted pql string literal.
+func formatString(s string) string {
+	sb := new(strings.Builder)
+	quotePQLString(sb, s)
+	return sb.String()
+}
+
+// quotePQLString writes s to sb as a double-quoted pql string literal.
+func quotePQLString(sb *strings.Builder, s string) {
+	sb.WriteString(`"`)
+	for _, c := range s {
+		switch c {
+		case '"', '\\':
+			sb.WriteRune('\\')
+			sb.WriteRune(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	sb.WriteString(`"`)
+}
+
+func isPlainIdent(name string) bool {
+	t := parser.NewTokenizer(name)
+	return t.Next() &&
+		t.Token().Kind == parser.TokenIdentifier &&
+		t.Token().Span == (parser.Span{Start: 0, End: len(name)})
+}
@@ -0,0 +1,346 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// Check reports references in source to tables and columns
+// that are not in the context's schema
+// with the [CodeUnknownTable] and [CodeUnknownColumn] codes.
+// Columns are only checked in pipelines whose input columns are known,
+// so a schema that omits a table does not produce column diagnostics.
+// Check does not report syntax errors:
+// it analyzes as much of source as can be parsed.
+// The given context is passed to the AnalysisContext's [TableProvider], if any.
+// If the provider returns an error,
+// Check returns the diagnostics it could compute along with the error.
+func (ac *AnalysisContext) Check(ctx context.Context, source string) ([]parser.Diagnostic, error) {
+	if ac == nil {
+		return nil, nil
+	}
+	c := &checker{
+		completer: completer{
+			columnResolver: columnResolver{
+				ac:     ac,
+				ctx:    ctx,
+				source: source,
+			},
+		},
+	}
+	stmts, _ := parser.Parse(source)
+	for _, stmt := range stmts {
+		c.visibleTabularLets = len(c.tabularLets)
+		c.asNames = make(map[string]struct{})
+		parser.Walk(stmt, func(n parser.Node) bool {
+			if op, ok := n.(*parser.AsOperator); ok && op.Name != nil {
+				c.asNames[op.Name.Name] = struct{}{}
+			}
+			return true
+		})
+
+		switch stmt := stmt.(type) {
+		case *parser.TabularExpr:
+			c.tabularExpr(stmt)
+		case *parser.LetStatement:
+			if stmt.Tabular != nil {
+				c.tabularExpr(stmt.Tabular)
+			}
+			if stmt.Name == nil {
+				continue
+			}
+			if stmt.Tabular != nil {
+				c.tabularLets = append(c.tabularLets, stmt)
+			} else {
+				c.lets = append(c.lets, stmt.Name.Name)
+			}
+		}
+	}
+	slices.SortStableFunc(c.diags, func(a, b parser.Diagnostic) int {
+		return a.Span.Start - b.Span.Start
+	})
+	return c.diags, c.err
+}
+
+// ResultColumns returns the columns of the rows produced by
+// the last tabular expression statement in source,
+// using the context's schema for the columns of tables.
+// The types of computed columns are only known
+// for simple expressions like comparisons and counts.
+// ResultColumns returns nil if the columns cannot be determined,
+// like when the query reads from a table that is not in the schema.
+// It returns an error if source cannot be parsed
+// or if the context's [TableProvider] returns an error.
+func (ac *AnalysisContext) ResultColumns(ctx context.Context, source string) ([]*AnalysisColumn, error) {
+	stmts, err := parser.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	r := &columnResolver{
+		ac:     ac,
+		ctx:    ctx,
+		source: source,
+	}
+	var result *parser.TabularExpr
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *parser.TabularExpr:
+			result = stmt
+			r.visibleTabularLets = len(r.tabularLets)
+		case *parser.LetStatement:
+			if stmt.Name != nil && stmt.Tabular != nil {
+				r.tabularLets = append(r.tabularLets, stmt)
+			}
+		}
+	}
+	if result == nil {
+		return nil, nil
+	}
+	cols := r.tabularColumns(source, result)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return cols, nil
+}
+
+// checker finds references to unknown tables and columns.
+// It reuses a [completer] to infer the columns of pipelines.
+type checker struct {
+	completer
+
+	// asNames is the set of names bound by as operators
+	// in the current statement.
+	asNames map[string]struct{}
+
+	diags []parser.Diagnostic
+}
+
+func (c *checker) tabularExpr(expr *parser.TabularExpr) {
+	if expr == nil {
+		return
+	}
+	c.dataSource(expr.Source)
+	for i, op := range expr.Operators {
+		switch op := op.(type) {
+		case *parser.JoinOperator:
+			// Join conditions refer to both sides,
+			// so only the right side is checked.
+			c.tabularExpr(op.Right)
+			continue
+		case *parser.UnionOperator:
+			for _, src := range op.Tables {
+				c.dataSource(src)
+			}
+			continue
+		}
+		cols := c.tabularColumns(c.source, &parser.TabularExpr{
+			Source:    expr.Source,
+			Operators: expr.Operators[:i],
+		})
+		if cols == nil {
+			continue
+		}
+		c.operator(op, cols)
+	}
+}
+
+func (c *checker) dataSource(src parser.TabularDataSource) {
+	switch src := src.(type) {
+	case *parser.TableRef:
+		c.tableRef(src)
+	case *parser.ParenTabularExpr:
+		c.tabularExpr(src.X)
+	case *parser.UnionSource:
+		for _, src := range src.Tables {
+			c.dataSource(src)
+		}
+	}
+}
+
+func (c *checker) tableRef(ref *parser.TableRef) {
+	if ref.Cluster != nil || ref.Table == nil {
+		// The tables of other clusters are not known.
+		return
+	}
+	name := ref.Table.Name
+	if ref.Database != nil {
+		name = ref.Database.Name + "." + name
+	} else if _, ok := c.asNames[name]; ok {
+		return
+	}
+	if c.lookupTableRef(ref) != nil || c.err != nil {
+		return
+	}
+	c.diags = append(c.diags, parser.Diagnostic{
+		Span:     ref.Span(),
+		Severity: parser.SeverityError,
+		Code:     CodeUnknownTable,
+		Message:  fmt.Sprintf("unknown table %q", name),
+	})
+}
+
+// operator checks the column references in op,
+// given the columns of its input.
+func (c *checker) operator(op parser.TabularOperator, cols []*AnalysisColumn) {
+	known := make(map[string]struct{}, len(cols))
+	for _, col := range cols {
+		known[col.Name] = struct{}{}
+	}
+	check := func(x parser.Expr) {
+		if x != nil {
+			c.expr(x, cols, known)
+		}
+	}
+	// Columns defined by an operator can be used by the columns after them.
+	define := func(name *parser.Ident) {
+		if name != nil {
+			known[name.Name] = struct{}{}
+		}
+	}
+
+	switch op := op.(type) {
+	case *parser.WhereOperator:
+		check(op.Predicate)
+	case *parser.SortOperator:
+		for _, term := range op.Terms {
+			check(term.X)
+		}
+	case *parser.TopOperator:
+		if op.Col != nil {
+			check(op.Col.X)
+		}
+	case *parser.ProjectOperator:
+		for _, col := range op.Cols {
+			if col.X == nil {
+				if col.Name != nil {
+					check(col.Name.AsQualified())
+				}
+				continue
+			}
+			check(col.X)
+			define(col.Name)
+		}
+	case *parser.ProjectAwayOperator:
+		for _, col := range op.Cols {
+			check(col.AsQualified())
+		}
+	case *parser.DistinctOperator:
+		for _, col := range op.Cols {
+			check(col.AsQualified())
+		}
+	case *parser.ExtendOperator:
+		for _, col := range op.Cols {
+			check(col.X)
+			define(col.Name)
+		}
+	case *parser.MvExpandOperator:
+		for _, col := range op.Cols {
+			check(col.X)
+			define(col.Name)
+		}
+	case *parser.SummarizeOperator:
+		for _, col := range op.Cols {
+			check(col.X)
+		}
+		for _, col := range op.GroupBy {
+			check(col.X)
+		}
+	}
+}
+
+// expr reports the identifiers in x that do not refer to
+// a column, a let statement, a query parameter, or a builtin.
+func (c *checker) expr(x parser.Expr, cols []*AnalysisColumn, known map[string]struct{}) {
+	parser.Walk(x, func(n parser.Node) bool {
+		id, ok := n.(*parser.QualifiedIdent)
+		if !ok {
+			return true
+		}
+		if len(id.Parts) == 0 {
+			return false
+		}
+		part := id.Parts[0]
+		if _, ok := known[part.Name]; ok {
+			return false
+		}
+		if !part.Quoted {
+			if _, ok := builtinIdentifiers[part.Name]; ok {
+				return false
+			}
+			if _, ok := c.ac.Parameters[part.Name]; ok {
+				return false
+			}
+			if slices.Contains(c.lets, part.Name) {
+				return false
+			}
+		}
+		msg := fmt.Sprintf("unknown column %q", part.Name)
+		if suggestion := closestColumn(cols, part.Name); suggestion != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		c.diags = append(c.diags, parser.Diagnostic{
+			Span:     part.Span(),
+			Severity: parser.SeverityError,
+			Code:     CodeUnknownColumn,
+			Message:  msg,
+		})
+		return false
+	})
+}
+
+// closestColumn returns the name of the column in cols
+// that is most likely to be a misspelling of name,
+// or the empty string if no column is close.
+func closestColumn(cols []*AnalysisColumn, name string) string {
+	best := ""
+	// Allow one edit for every three characters.
+	// Short names are too likely to be close to an unrelated column.
+	bestDist := len(name)/3 + 1
+	if len(name) < 3 {
+		bestDist = 1
+	}
+	for _, col := range cols {
+		if strings.EqualFold(col.Name, name) {
+			return col.Name
+		}
+		if d := editDistance(col.Name, name); d < bestDist {
+			best, bestDist = col.Name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the number of single-character insertions, deletions,
+// substitutions, and transpositions of adjacent characters
+// needed to change a into b.
+func editDistance(a, b string) int {
+	// d[i][j] is the distance between a[:i] and b[:j].
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
@@ -0,0 +1,283 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// HoverInfo describes the item at a position in a query,
+// like a table, column, function, or operator.
+type HoverInfo struct {
+	// Span is the range of the source that names the item.
+	Span parser.Span
+	// Kind is the type of the item.
+	Kind CompletionKind
+	// Name is the name of the item.
+	Name string
+	// Detail is a short piece of additional information about the item,
+	// like a column's type or a function's signature.
+	Detail string
+	// Documentation is a human-readable description of the item.
+	// It may be empty.
+	Documentation string
+}
+
+// Hover returns information about the item at the given byte offset in source,
+// or nil if there is no known item at pos.
+// The given context is passed to the AnalysisContext's [TableProvider], if any.
+// If the provider returns an error,
+// Hover returns the information it could compute along with the error.
+func (ac *AnalysisContext) Hover(ctx context.Context, source string, pos int) (*HoverInfo, error) {
+	h := &hoverer{
+		completer: completer{
+			columnResolver: columnResolver{
+				ac:     ac,
+				ctx:    ctx,
+				source: source,
+			},
+		},
+		pos: pos,
+	}
+	stmts, _ := parser.Parse(source)
+	for _, stmt := range stmts {
+		h.visibleTabularLets = len(h.tabularLets)
+		if h.contains(stmt.Span()) {
+			switch stmt := stmt.(type) {
+			case *parser.TabularExpr:
+				h.tabularExpr(stmt)
+			case *parser.LetStatement:
+				switch {
+				case h.contains(stmt.Name.Span()):
+					h.set(stmt.Name.Span(), &HoverInfo{Kind: CompletionVariable, Name: stmt.Name.Name, Detail: "let"})
+				case stmt.Tabular != nil:
+					h.tabularExpr(stmt.Tabular)
+				case stmt.X != nil:
+					h.expr(stmt.X, nil)
+				}
+			}
+			return h.result, h.err
+		}
+		if let, ok := stmt.(*parser.LetStatement); ok && let.Name != nil {
+			if let.Tabular != nil {
+				h.tabularLets = append(h.tabularLets, let)
+			} else {
+				h.lets = append(h.lets, let.Name.Name)
+			}
+		}
+	}
+	return h.result, h.err
+}
+
+// hoverer finds the item at a position.
+// It reuses a [completer] to look up tables and infer the columns of pipelines.
+type hoverer struct {
+	completer
+	pos    int
+	result *HoverInfo
+}
+
+// contains reports whether span includes the position.
+// The end of the span is included so that the item before the cursor is found.
+func (h *hoverer) contains(span parser.Span) bool {
+	return span.IsValid() && span.Start <= h.pos && h.pos <= span.End
+}
+
+func (h *hoverer) set(span parser.Span, info *HoverInfo) {
+	info.Span = span
+	h.result = info
+}
+
+func (h *hoverer) tabularExpr(expr *parser.TabularExpr) {
+	if expr == nil {
+		return
+	}
+	if h.contains(expr.Source.Span()) {
+		h.dataSource(expr.Source)
+		return
+	}
+
+	for i, op := range expr.Operators {
+		if !h.contains(op.Span()) {
+			continue
+		}
+		if keyword := operatorKeyword(op); h.contains(keyword) {
+			name := h.source[keyword.Start:keyword.End]
+			for _, c := range tabularOperatorCompletions {
+				if c.name == name {
+					h.set(keyword, &HoverInfo{Kind: CompletionOperator, Name: name, Detail: c.detail, Documentation: c.doc})
+					return
+				}
+			}
+			return
+		}
+		switch op := op.(type) {
+		case *parser.JoinOperator:
+			h.tabularExpr(op.Right)
+			return
+		case *parser.UnionOperator:
+			for _, src := range op.Tables {
+				if h.contains(src.Span()) {
+					h.dataSource(src)
+					return
+				}
+			}
+			return
+		}
+		cols := h.tabularColumns(h.source, &parser.TabularExpr{
+			Source:    expr.Source,
+			Operators: expr.Operators[:i],
+		})
+		parser.Walk(op, func(n parser.Node) bool {
+			switch n := n.(type) {
+			case *parser.ProjectColumn:
+				if n.X == nil && n.Name != nil && h.contains(n.Name.Span()) {
+					h.expr(n.Name.AsQualified(), cols)
+					return false
+				}
+			case parser.Expr:
+				if h.contains(n.Span()) {
+					h.expr(n, cols)
+				}
+				return false
+			}
+			return true
+		})
+		return
+	}
+}
+
+// dataSource finds the item at the position in src.
+func (h *hoverer) dataSource(src parser.TabularDataSource) {
+	switch src := src.(type) {
+	case *parser.TableRef:
+		if h.contains(src.Database.Span()) && h.ac != nil {
+			info := &HoverInfo{Kind: CompletionDatabase, Name: src.Database.Name, Detail: "database"}
+			if db := h.ac.Databases[src.Database.Name]; db != nil {
+				info.Documentation = db.Description
+			}
+			h.set(src.Database.Span(), info)
+			return
+		}
+		if h.contains(src.Table.Span()) {
+			tbl := h.lookupTableRef(src)
+			if tbl == nil {
+				return
+			}
+			h.set(src.Table.Span(), &HoverInfo{
+				Kind:          CompletionTable,
+				Name:          src.Table.Name,
+				Detail:        "table",
+				Documentation: tbl.Description,
+			})
+			return
+		}
+	case *parser.ParenTabularExpr:
+		h.tabularExpr(src.X)
+	case *parser.UnionSource:
+		for _, src := range src.Tables {
+			if h.contains(src.Span()) {
+				h.dataSource(src)
+				return
+			}
+		}
+	}
+}
+
+// expr finds the item at the position in x,
+// given the columns of the operator's input.
+func (h *hoverer) expr(x parser.Expr, cols []*AnalysisColumn) {
+	parser.Walk(x, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.CallExpr:
+			if n.Func != nil && h.contains(n.Func.Span()) {
+				h.function(n.Func)
+				return false
+			}
+		case *parser.QualifiedIdent:
+			h.ident(n, cols)
+			return false
+		}
+		return true
+	})
+}
+
+func (h *hoverer) function(id *parser.Ident) {
+	if f := initKnownFunctions()[id.Name]; f != nil {
+		h.set(id.Span(), &HoverInfo{Kind: CompletionFunction, Name: id.Name, Detail: f.signature, Documentation: f.doc})
+		return
+	}
+	for _, f := range sqlAggregateFunctions {
+		if f.name == id.Name {
+			h.set(id.Span(), &HoverInfo{Kind: CompletionFunction, Name: id.Name, Detail: f.signature, Documentation: f.doc})
+			return
+		}
+	}
+}
+
+func (h *hoverer) ident(id *parser.QualifiedIdent, cols []*AnalysisColumn) {
+	for i, part := range id.Parts {
+		if !h.contains(part.Span()) {
+			continue
+		}
+		col := findColumn(cols, id.Parts[0].Name)
+		if col == nil {
+			if i == 0 && !part.Quoted {
+				h.variable(part)
+			}
+			return
+		}
+		for _, field := range id.Parts[1 : i+1] {
+			col = findColumn(col.Fields, field.Name)
+			if col == nil {
+				return
+			}
+		}
+		kind := CompletionColumn
+		if i > 0 {
+			kind = CompletionField
+		}
+		h.set(part.Span(), &HoverInfo{Kind: kind, Name: col.Name, Detail: col.Type, Documentation: col.Description})
+		return
+	}
+}
+
+// variable describes a let statement or query parameter.
+func (h *hoverer) variable(id *parser.Ident) {
+	for _, name := range h.lets {
+		if name == id.Name {
+			h.set(id.Span(), &HoverInfo{Kind: CompletionVariable, Name: id.Name, Detail: "let"})
+			return
+		}
+	}
+	if h.ac == nil {
+		return
+	}
+	if _, ok := h.ac.Parameters[id.Name]; ok {
+		h.set(id.Span(), &HoverInfo{Kind: CompletionVariable, Name: id.Name, Detail: "parameter"})
+	}
+}
+
+func findColumn(cols []*AnalysisColumn, name string) *AnalysisColumn {
+	if i := columnIndex(cols, name); i >= 0 {
+		return cols[i]
+	}
+	return nil
+}
+
+// operatorKeyword returns the span of the keyword that names op.
+func operatorKeyword(op parser.TabularOperator) parser.Span {
+	v := reflect.ValueOf(op)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return parser.Span{Start: -1, End: -1}
+	}
+	f := v.Elem().FieldByName("Keyword")
+	if !f.IsValid() || f.Type() != reflect.TypeOf(parser.Span{}) {
+		return parser.Span{Start: -1, End: -1}
+	}
+	return f.Interface().(parser.Span)
+}
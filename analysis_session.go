@@ -0,0 +1,194 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// A CompletionSession suggests completions for successive versions of a document,
+// like the text of an editor as the user types.
+// It keeps the document's tokens and parsed statements,
+// the let statements in scope,
+// and the schemas inferred for pipelines between calls,
+// so that each call only re-analyzes the parts of the document that changed.
+// The document can be updated with [*CompletionSession.Edit]
+// or by passing its new text to [*CompletionSession.SuggestCompletions].
+// Each document should have its own session.
+//
+// Table schemas obtained from the AnalysisContext are cached
+// for as long as the statements before the cursor's statement are unchanged.
+// A CompletionSession is not safe to use from multiple goroutines concurrently.
+type CompletionSession struct {
+	ac *AnalysisContext
+
+	// doc is the latest version of the document
+	// or nil if the session has not seen the document yet.
+	doc *parser.ParseResult
+
+	// prelude is the source that precedes the cursor's statement.
+	// lets and tabularLets are the let statements in prelude.
+	scopeValid  bool
+	prelude     string
+	lets        []string
+	tabularLets []*parser.LetStatement
+
+	// columns is a cache of pipeline source text
+	// to the columns the pipeline produces.
+	columns map[string][]*AnalysisColumn
+}
+
+// maxCachedPipelines is the maximum number of entries
+// in [CompletionSession.columns].
+const maxCachedPipelines = 64
+
+// NewCompletionSession returns a new session
+// that suggests completions using the context's schema.
+func (ac *AnalysisContext) NewCompletionSession() *CompletionSession {
+	return &CompletionSession{ac: ac}
+}
+
+// sessionParseOptions are the options for parsing a session's document.
+// The document is not limited to a number of tokens or statements,
+// since completion only analyzes the statement at the cursor.
+var sessionParseOptions = &parser.ParseOptions{
+	MaxTokens:     -1,
+	MaxStatements: -1,
+}
+
+// SuggestCompletions suggests possible snippets to insert
+// given the current text of the document and a selected range.
+// It returns the same results as [*AnalysisContext.SuggestCompletionsContext].
+// If source differs from the session's document,
+// the changed region is treated as an edit like [*CompletionSession.Edit].
+func (s *CompletionSession) SuggestCompletions(ctx context.Context, source string, cursor parser.Span) ([]*Completion, error) {
+	s.setText(source)
+	return s.ac.suggestCompletions(ctx, s, source, cursor)
+}
+
+// SuggestCompletionsAt is like [*CompletionSession.SuggestCompletions]
+// for the session's current document.
+func (s *CompletionSession) SuggestCompletionsAt(ctx context.Context, cursor parser.Span) ([]*Completion, error) {
+	return s.SuggestCompletions(ctx, s.Text(), cursor)
+}
+
+// Text returns the text of the session's document.
+// It is empty until the first call to
+// [*CompletionSession.Edit] or [*CompletionSession.SuggestCompletions].
+func (s *CompletionSession) Text() string {
+	if s.doc == nil {
+		return ""
+	}
+	return s.doc.Source()
+}
+
+// Edit replaces the given span of the session's document with newText,
+// like a change reported by an editor.
+// Only the statements that overlap the span are parsed again.
+// Edit panics if span is not a valid span of the document.
+func (s *CompletionSession) Edit(span parser.Span, newText string) {
+	if s.doc == nil {
+		s.doc = sessionParseOptions.ParseIncremental("")
+	}
+	s.doc = s.doc.Reparse(span, newText)
+}
+
+// setText replaces the session's document with text
+// by editing the region between their common prefix and suffix.
+func (s *CompletionSession) setText(text string) {
+	if s.doc == nil {
+		s.doc = sessionParseOptions.ParseIncremental(text)
+		return
+	}
+	old := s.doc.Source()
+	if old == text {
+		return
+	}
+	prefix := commonPrefixLen(old, text)
+	suffix := commonSuffixLen(old[prefix:], text[prefix:])
+	s.Edit(parser.Span{Start: prefix, End: len(old) - suffix}, text[prefix:len(text)-suffix])
+}
+
+// tokensBefore returns the tokens that [parser.Scan] would return
+// for the document's text up to pos.
+func (s *CompletionSession) tokensBefore(pos int) []parser.Token {
+	tokens := s.doc.Tokens()
+	// Tokens that end at or after pos may be cut short
+	// (e.g. the start of an identifier that is being typed).
+	keep := 0
+	for keep < len(tokens) && tokens[keep].Span.End < pos {
+		keep++
+	}
+	start := 0
+	if keep > 0 {
+		start = tokens[keep-1].Span.End
+	}
+	// Limit the capacity so that appending does not modify the document's tokens.
+	tokens = tokens[:keep:keep]
+	for t := parser.NewTokenizer(s.doc.Source()[start:pos]); t.Next(); {
+		tok := t.Token()
+		tok.Span.Start += start
+		tok.Span.End += start
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
+
+// statementsBefore returns the statements that [parser.Parse] would return
+// for the document's text up to end,
+// which must be the end of a statement's semicolon.
+func (s *CompletionSession) statementsBefore(end int) []parser.Statement {
+	stmts := s.doc.Statements()
+	n := 0
+	for n < len(stmts) && stmts[n].Span().End <= end {
+		n++
+	}
+	return stmts[:n]
+}
+
+// setScope caches the let statements in the given prelude.
+// If the prelude differs from the previous one,
+// setScope also invalidates the pipeline cache,
+// since pipelines can refer to let statements.
+func (s *CompletionSession) setScope(prelude string, lets []string, tabularLets []*parser.LetStatement) {
+	if !s.scopeValid || s.prelude != prelude {
+		clear(s.columns)
+	}
+	s.scopeValid = true
+	s.prelude = prelude
+	s.lets = lets
+	s.tabularLets = tabularLets
+}
+
+func (s *CompletionSession) cacheColumns(pipeline string, cols []*AnalysisColumn) {
+	if s.columns == nil {
+		s.columns = make(map[string][]*AnalysisColumn)
+	}
+	if len(s.columns) >= maxCachedPipelines {
+		clear(s.columns)
+	}
+	s.columns[pipeline] = cols
+}
+
+func commonPrefixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+func commonSuffixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[len(a)-1-i] != b[len(b)-1-i] {
+			return i
+		}
+	}
+	return n
+}
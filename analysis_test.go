@@ -0,0 +1,1429 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/runreveal/pql/parser"
+)
+
+var testAnalysisContext = &AnalysisContext{
+	Tables: map[string]*AnalysisTable{
+		"People": {
+			Description: "Everyone we know.",
+			Columns: []*AnalysisColumn{
+				{Name: "Name", Type: "String", Description: "Full name."},
+				{Name: "Age", Type: "Int64"},
+			},
+		},
+		"Orders": {
+			Columns: []*AnalysisColumn{
+				{Name: "OrderID", Type: "Int64"},
+				{Name: "Name", Type: "String"},
+				{Name: "Total Cost", Type: "Float64"},
+			},
+		},
+	},
+}
+
+func TestSuggestCompletions(t *testing.T) {
+	functionNames := make([]string, 0, len(initKnownFunctions()))
+	for name := range initKnownFunctions() {
+		functionNames = append(functionNames, name)
+	}
+	slices.Sort(functionNames)
+	withFunctions := func(names ...string) []string {
+		return append(names, functionNames...)
+	}
+	var scalarFunctionNames []string
+	for _, name := range functionNames {
+		if !initKnownFunctions()[name].aggregate {
+			scalarFunctionNames = append(scalarFunctionNames, name)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		// cursor is the position of the cursor in source.
+		// If negative, the cursor is at the end of source.
+		cursor int
+		want   []string
+	}{
+		{
+			name:   "Empty",
+			source: "",
+			cursor: -1,
+			want:   []string{"Orders", "People"},
+		},
+		{
+			name:   "TablePrefix",
+			source: "Pe",
+			cursor: -1,
+			want:   []string{"People"},
+		},
+		{
+			name:   "AfterTableName",
+			source: "People ",
+			cursor: -1,
+			want:   nil,
+		},
+		{
+			name:   "AfterPipe",
+			source: "People | ",
+			cursor: -1,
+			want: []string{
+				"as",
+				"count",
+				"distinct",
+				"extend",
+				"filter",
+				"join",
+				"limit",
+				"mv-expand",
+				"order",
+				"project",
+				"project-away",
+				"render",
+				"sort",
+				"summarize",
+				"take",
+				"top",
+				"union",
+				"where",
+			},
+		},
+		{
+			name:   "OperatorPrefix",
+			source: "People | s",
+			cursor: -1,
+			want:   []string{"sort", "summarize"},
+		},
+		{
+			name:   "ProjectAway",
+			source: "People | project-away ",
+			cursor: -1,
+			want:   []string{"Age", "Name"},
+		},
+		{
+			name:   "Distinct",
+			source: "People | distinct Name, ",
+			cursor: -1,
+			want:   []string{"Age", "Name"},
+		},
+		{
+			name:   "MvExpand",
+			source: "People | mv-expand ",
+			cursor: -1,
+			want:   withFunctions("Age", "Name"),
+		},
+		{
+			name:   "Where",
+			source: "People | where ",
+			cursor: -1,
+			want:   withFunctions("Age", "Name"),
+		},
+		{
+			name:   "WherePrefix",
+			source: "People | where Na",
+			cursor: -1,
+			want:   []string{"Name"},
+		},
+		{
+			name:   "CursorInMiddle",
+			source: "People | where Na | take 5",
+			cursor: len("People | where Na"),
+			want:   []string{"Name"},
+		},
+		{
+			name:   "UnknownTable",
+			source: "Foo | where ",
+			cursor: -1,
+			want:   functionNames,
+		},
+		{
+			name:   "AfterProject",
+			source: "People | project Name | where ",
+			cursor: -1,
+			want:   withFunctions("Name"),
+		},
+		{
+			name:   "AfterExtend",
+			source: "People | extend Foo = Age + 1 | where F",
+			cursor: -1,
+			want:   []string{"Foo"},
+		},
+		{
+			name:   "AfterSummarize",
+			source: "People | summarize count() by Name | where ",
+			cursor: -1,
+			want:   withFunctions("Name", "count()"),
+		},
+		{
+			name:   "SummarizeBy",
+			source: "People | summarize count() b",
+			cursor: -1,
+			want:   []string{"by"},
+		},
+		{
+			name:   "SummarizeAggregation",
+			source: "People | summarize ",
+			cursor: -1,
+			want: append([]string{
+				"avg",
+				"count",
+				"countif",
+				"max",
+				"min",
+				"sum",
+				"Age",
+				"Name",
+			}, scalarFunctionNames...),
+		},
+		{
+			name:   "SummarizeSecondAggregation",
+			source: "People | summarize n = count(), ",
+			cursor: -1,
+			want: append([]string{
+				"avg",
+				"count",
+				"countif",
+				"max",
+				"min",
+				"sum",
+				"Age",
+				"Name",
+				"by",
+			}, scalarFunctionNames...),
+		},
+		{
+			name:   "SummarizeAggregationArgument",
+			source: "People | summarize sum(",
+			cursor: -1,
+			want:   withFunctions("Age", "Name"),
+		},
+		{
+			name:   "SummarizeGroup",
+			source: "People | summarize count() by ",
+			cursor: -1,
+			want:   append([]string{"Age", "Name"}, scalarFunctionNames...),
+		},
+		{
+			name:   "SortBy",
+			source: "People | sort ",
+			cursor: -1,
+			want:   []string{"by"},
+		},
+		{
+			name:   "SortTerm",
+			source: "People | sort by Name ",
+			cursor: -1,
+			want:   withFunctions("Age", "Name", "asc", "desc"),
+		},
+		{
+			name:   "JoinKind",
+			source: "People | join ",
+			cursor: -1,
+			want:   []string{"kind"},
+		},
+		{
+			name:   "JoinFlavor",
+			source: "People | join kind=",
+			cursor: -1,
+			want: []string{
+				"cross",
+				"fullouter",
+				"inner",
+				"innerunique",
+				"leftanti",
+				"leftouter",
+				"leftsemi",
+				"rightanti",
+				"rightouter",
+				"rightsemi",
+			},
+		},
+		{
+			name:   "JoinHintValue",
+			source: "People | join hint.strategy=",
+			cursor: -1,
+			want:   nil,
+		},
+		{
+			name:   "JoinRightTable",
+			source: "People | join (Or",
+			cursor: -1,
+			want:   []string{"Orders"},
+		},
+		{
+			name:   "JoinRightOperator",
+			source: "People | join (Orders | where O",
+			cursor: -1,
+			want:   []string{"OrderID"},
+		},
+		{
+			name:   "JoinOn",
+			source: "People | join (Orders) ",
+			cursor: -1,
+			want:   []string{"on"},
+		},
+		{
+			name:   "JoinConditions",
+			source: "People | join (Orders) on ",
+			cursor: -1,
+			want:   withFunctions("$left", "$right", "Name"),
+		},
+		{
+			name:   "JoinLeftColumn",
+			source: "People | join (Orders) on $left.",
+			cursor: -1,
+			want:   []string{"Age", "Name"},
+		},
+		{
+			name:   "JoinRightColumn",
+			source: "People | join (Orders) on $left.Name == $right.",
+			cursor: -1,
+			want:   []string{"Name", "OrderID", "Total Cost"},
+		},
+		{
+			name:   "JoinSidePrefix",
+			source: "People | join (Orders) on $r",
+			cursor: -1,
+			want:   []string{"$right"},
+		},
+		{
+			name:   "UnionTable",
+			source: "People | union ",
+			cursor: -1,
+			want:   []string{"Orders", "People", "withsource"},
+		},
+		{
+			name:   "UnionSourceTable",
+			source: "union withsource=T People, Or",
+			cursor: -1,
+			want:   []string{"Orders"},
+		},
+		{
+			name:   "UnionInnerOperator",
+			source: "People | union (Orders | where O",
+			cursor: -1,
+			want:   []string{"OrderID"},
+		},
+		{
+			name:   "UnionOperator",
+			source: "union withsource=T People, Orders | where ",
+			cursor: -1,
+			want:   withFunctions("Age", "Name", "OrderID", "T", "Total Cost"),
+		},
+		{
+			name:   "ParenSourceTable",
+			source: "(Pe",
+			cursor: -1,
+			want:   []string{"People"},
+		},
+		{
+			name:   "ParenSourceOperator",
+			source: "(Orders | project OrderID, Name) | where ",
+			cursor: -1,
+			want:   withFunctions("Name", "OrderID"),
+		},
+		{
+			name:   "ParenSourceInnerOperator",
+			source: "(Orders | where O",
+			cursor: -1,
+			want:   []string{"OrderID"},
+		},
+		{
+			name:   "Let",
+			source: "let x = 5; People | where x",
+			cursor: -1,
+			want:   []string{"x"},
+		},
+		{
+			name:   "LetExpression",
+			source: "let x = 5; let y = ",
+			cursor: -1,
+			want:   withFunctions("Orders", "People", "x"),
+		},
+		{
+			name:   "LetTabularExpression",
+			source: "let T = People | where ",
+			cursor: -1,
+			want:   withFunctions("Age", "Name"),
+		},
+		{
+			name:   "LetTabularSource",
+			source: "let T = People | project Name; ",
+			cursor: -1,
+			want:   []string{"Orders", "People", "T"},
+		},
+		{
+			name:   "LetTabularJoin",
+			source: "let T = People | project Name; Orders | join (",
+			cursor: -1,
+			want:   []string{"Orders", "People", "T"},
+		},
+		{
+			name:   "LetTabularColumns",
+			source: "let T = People | project Name; T | where ",
+			cursor: -1,
+			want:   withFunctions("Name"),
+		},
+		{
+			name:   "LetTabularChain",
+			source: "let T = People | project Name; let U = T | extend Foo = 1; U | where ",
+			cursor: -1,
+			want:   withFunctions("Foo", "Name"),
+		},
+		{
+			name:   "LetTabularShadow",
+			source: "let People = People | project Age; People | where ",
+			cursor: -1,
+			want:   withFunctions("Age"),
+		},
+		{
+			name:   "SecondStatement",
+			source: "Orders; Pe",
+			cursor: -1,
+			want:   []string{"People"},
+		},
+		{
+			name:   "InsideString",
+			source: `People | where Name == "Pe`,
+			cursor: -1,
+			want:   nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cursor := test.cursor
+			if cursor < 0 {
+				cursor = len(test.source)
+			}
+			completions := testAnalysisContext.SuggestCompletions(test.source, parser.Span{
+				Start: cursor,
+				End:   cursor,
+			})
+			var got []string
+			for _, c := range completions {
+				got = append(got, c.Label)
+			}
+			if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("SuggestCompletions(%q, %d) labels (-want +got):\n%s", test.source, cursor, diff)
+			}
+		})
+	}
+}
+
+func TestSuggestCompletionsMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []*Completion
+	}{
+		{
+			name:   "Table",
+			source: "Peo",
+			want: []*Completion{
+				{
+					Label:         "People",
+					Text:          "People",
+					Span:          parser.Span{Start: 0, End: 3},
+					Kind:          CompletionTable,
+					Detail:        "table",
+					Documentation: "Everyone we know.",
+					SortText:      "0_0_People",
+				},
+			},
+		},
+		{
+			name:   "Column",
+			source: "People | where Na",
+			want: []*Completion{
+				{
+					Label:         "Name",
+					Text:          "Name",
+					Span:          parser.Span{Start: 15, End: 17},
+					Kind:          CompletionColumn,
+					Detail:        "String",
+					Documentation: "Full name.",
+					SortText:      "0_0_Name",
+				},
+			},
+		},
+		{
+			name:   "QuotedColumn",
+			source: "Orders | where Tot",
+			want: []*Completion{
+				{
+					Label:    "Total Cost",
+					Text:     "`Total Cost`",
+					Span:     parser.Span{Start: 15, End: 18},
+					Kind:     CompletionColumn,
+					Detail:   "Float64",
+					SortText: "0_0_Total Cost",
+				},
+			},
+		},
+		{
+			name:   "Function",
+			source: "People | where str",
+			want: []*Completion{
+				{
+					Label:         "strcat",
+					Text:          "strcat(",
+					Span:          parser.Span{Start: 15, End: 18},
+					Kind:          CompletionFunction,
+					Detail:        "strcat(x, ...)",
+					Documentation: "Concatenates its string arguments.",
+					SortText:      "0_2_strcat",
+				},
+			},
+		},
+		{
+			name:   "Operator",
+			source: "People | wh",
+			want: []*Completion{
+				{
+					Label:         "where",
+					Text:          "where",
+					Span:          parser.Span{Start: 9, End: 11},
+					Kind:          CompletionOperator,
+					Detail:        "where Predicate",
+					Documentation: "Filters the input to the rows that satisfy a predicate.",
+					SortText:      "0_0_where",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := testAnalysisContext.SuggestCompletions(test.source, parser.Span{
+				Start: len(test.source),
+				End:   len(test.source),
+			})
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("SuggestCompletions(%q, ...) (-want +got):\n%s", test.source, diff)
+			}
+		})
+	}
+}
+
+func TestSuggestCompletionsFuzzy(t *testing.T) {
+	ctx := &AnalysisContext{
+		Tables: map[string]*AnalysisTable{
+			"StormEvents": {
+				Columns: []*AnalysisColumn{
+					{Name: "EventType"},
+					{Name: "event_id"},
+					{Name: "State"},
+					{Name: "DamageProperty"},
+					{Name: "BeginLocation"},
+				},
+			},
+		},
+		FuzzyMatch: true,
+	}
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{
+			source: "StormEvents | where Ev",
+			want:   []string{"EventType", "event_id"},
+		},
+		{
+			source: "StormEvents | where DP",
+			want:   []string{"DamageProperty"},
+		},
+		{
+			source: "StormEvents | where ty",
+			want:   []string{"EventType", "DamageProperty"},
+		},
+		{
+			source: "StormEvents | where ei",
+			want:   []string{"event_id", "BeginLocation"},
+		},
+		{
+			source: "SE",
+			want:   []string{"StormEvents"},
+		},
+	}
+	for _, test := range tests {
+		completions := ctx.SuggestCompletions(test.source, parser.Span{
+			Start: len(test.source),
+			End:   len(test.source),
+		})
+		var got []string
+		for _, c := range completions {
+			if c.Kind == CompletionFunction {
+				continue
+			}
+			got = append(got, c.Label)
+		}
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("SuggestCompletions(%q, ...) labels (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
+
+func TestSuggestCompletionsProvider(t *testing.T) {
+	provider := &fakeTableProvider{
+		tables: map[string]*AnalysisTable{
+			"Events_2024": {
+				Columns: []*AnalysisColumn{{Name: "Timestamp"}},
+			},
+			"Events_2023": {
+				Columns: []*AnalysisColumn{{Name: "Time"}},
+			},
+			"Users": {
+				Columns: []*AnalysisColumn{{Name: "ID"}},
+			},
+		},
+	}
+	ctx := &AnalysisContext{
+		Tables: map[string]*AnalysisTable{
+			"People": {Columns: []*AnalysisColumn{{Name: "Name"}}},
+		},
+		Provider: provider,
+	}
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{
+			source: "",
+			want:   []string{"Events_2023", "Events_2024", "People", "Users"},
+		},
+		{
+			source: "Ev",
+			want:   []string{"Events_2023", "Events_2024"},
+		},
+		{
+			source: "Events_2024 | where T",
+			want:   []string{"Timestamp"},
+		},
+		{
+			source: "People | join (Users) on $right.I",
+			want:   []string{"ID"},
+		},
+		{
+			source: "Events_* | where T",
+			want:   []string{"Time", "Timestamp"},
+		},
+		{
+			source: `table("Events_" + "2024") | where T`,
+			want:   []string{"Timestamp"},
+		},
+	}
+	for _, test := range tests {
+		completions, err := ctx.SuggestCompletionsContext(context.Background(), test.source, parser.Span{
+			Start: len(test.source),
+			End:   len(test.source),
+		})
+		if err != nil {
+			t.Errorf("SuggestCompletionsContext(ctx, %q, ...): %v", test.source, err)
+		}
+		var got []string
+		for _, c := range completions {
+			if c.Kind == CompletionFunction {
+				continue
+			}
+			got = append(got, c.Label)
+		}
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("SuggestCompletionsContext(ctx, %q, ...) labels (-want +got):\n%s", test.source, diff)
+		}
+	}
+
+	t.Run("Canceled", func(t *testing.T) {
+		cancelCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := ctx.SuggestCompletionsContext(cancelCtx, "Events_2024 | where ", parser.Span{
+			Start: len("Events_2024 | where "),
+			End:   len("Events_2024 | where "),
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("SuggestCompletionsContext(canceledCtx, ...) error = %v; want %v", err, context.Canceled)
+		}
+	})
+}
+
+type fakeTableProvider struct {
+	tables  map[string]*AnalysisTable
+	lookups int
+}
+
+func (p *fakeTableProvider) LookupTable(ctx context.Context, name string) (*AnalysisTable, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.lookups++
+	return p.tables[name], nil
+}
+
+func (p *fakeTableProvider) ListTables(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range p.tables {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func TestSuggestCompletionsFields(t *testing.T) {
+	ctx := &AnalysisContext{
+		Tables: map[string]*AnalysisTable{
+			"Logs": {
+				Columns: []*AnalysisColumn{
+					{Name: "Timestamp", Type: "DateTime"},
+					{
+						Name: "payload",
+						Type: "JSON",
+						Fields: []*AnalysisColumn{
+							{Name: "user", Fields: []*AnalysisColumn{
+								{Name: "id", Type: "Int64"},
+								{Name: "email", Type: "String"},
+							}},
+							{Name: "source ip", Type: "String"},
+							{Name: "status", Type: "Int64"},
+						},
+					},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		source string
+		want   []*Completion
+	}{
+		{
+			source: "Logs | where payload.s",
+			want: []*Completion{
+				{
+					Label:    "source ip",
+					Text:     `["source ip"]`,
+					Span:     parser.Span{Start: 20, End: 22},
+					Kind:     CompletionField,
+					Detail:   "String",
+					SortText: "0_0_source ip",
+				},
+				{
+					Label:    "status",
+					Text:     "status",
+					Span:     parser.Span{Start: 21, End: 22},
+					Kind:     CompletionField,
+					Detail:   "Int64",
+					SortText: "0_0_status",
+				},
+			},
+		},
+		{
+			source: "Logs | project payload | where payload.user.",
+			want: []*Completion{
+				{
+					Label:    "email",
+					Text:     "email",
+					Span:     parser.Span{Start: 44, End: 44},
+					Kind:     CompletionField,
+					Detail:   "String",
+					SortText: "0_0_email",
+				},
+				{
+					Label:    "id",
+					Text:     "id",
+					Span:     parser.Span{Start: 44, End: 44},
+					Kind:     CompletionField,
+					Detail:   "Int64",
+					SortText: "0_0_id",
+				},
+			},
+		},
+		{
+			source: "Logs | sort by payload.user.i",
+			want: []*Completion{
+				{
+					Label:    "id",
+					Text:     "id",
+					Span:     parser.Span{Start: 28, End: 29},
+					Kind:     CompletionField,
+					Detail:   "Int64",
+					SortText: "0_0_id",
+				},
+			},
+		},
+		{
+			source: "Logs | where Timestamp.",
+			want:   nil,
+		},
+		{
+			source: "Logs | where bogus.",
+			want:   nil,
+		},
+	}
+	for _, test := range tests {
+		got := ctx.SuggestCompletions(test.source, parser.Span{
+			Start: len(test.source),
+			End:   len(test.source),
+		})
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("SuggestCompletions(%q, ...) (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
+
+func TestCompletionSession(t *testing.T) {
+	const doc = "let Adults = People | where Age >= 18;\n" +
+		"let n = 5;\n" +
+		"Adults | project Name, Years = Age | where Ye | join kind=inner (Orders) on Name | sort by Tot"
+	ctx := context.Background()
+	session := testAnalysisContext.NewCompletionSession()
+	// Simulate typing the document one character at a time,
+	// then deleting it one character at a time.
+	var edits []string
+	for i := 0; i <= len(doc); i++ {
+		edits = append(edits, doc[:i])
+	}
+	for i := len(doc) - 1; i >= 0; i-- {
+		edits = append(edits, doc[:i])
+	}
+	for _, source := range edits {
+		cursor := parser.Span{Start: len(source), End: len(source)}
+		want, err := testAnalysisContext.SuggestCompletionsContext(ctx, source, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := session.SuggestCompletions(ctx, source, cursor)
+		if err != nil {
+			t.Errorf("session.SuggestCompletions(ctx, %q, ...): %v", source, err)
+			continue
+		}
+		if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("session.SuggestCompletions(ctx, %q, ...) (-want +got):\n%s", source, diff)
+		}
+	}
+}
+
+func TestCompletionSessionEdit(t *testing.T) {
+	ctx := context.Background()
+	session := testAnalysisContext.NewCompletionSession()
+	edits := []struct {
+		span    parser.Span
+		newText string
+	}{
+		{parser.Span{Start: 0, End: 0}, "let n = 5;\nPeople | where "},
+		{parser.Span{Start: 26, End: 26}, "A"},
+		// Rename the let statement before the cursor's statement.
+		{parser.Span{Start: 4, End: 5}, "limit"},
+		{parser.Span{Start: 0, End: 0}, "let Adults = People | where Age >= 18;\n"},
+		{parser.Span{Start: 54, End: 60}, "Adults"},
+		{parser.Span{Start: 69, End: 70}, "Na"},
+	}
+	for _, edit := range edits {
+		session.Edit(edit.span, edit.newText)
+		source := session.Text()
+		cursor := parser.Span{Start: len(source), End: len(source)}
+		want, err := testAnalysisContext.SuggestCompletionsContext(ctx, source, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := session.SuggestCompletionsAt(ctx, cursor)
+		if err != nil {
+			t.Errorf("session.SuggestCompletionsAt(ctx, ...) for %q: %v", source, err)
+			continue
+		}
+		if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("session.SuggestCompletionsAt(ctx, ...) for %q (-want +got):\n%s", source, diff)
+		}
+	}
+	const wantText = "let Adults = People | where Age >= 18;\nlet limit = 5;\nAdults | where Na"
+	if got := session.Text(); got != wantText {
+		t.Errorf("session.Text() = %q; want %q", got, wantText)
+	}
+}
+
+func TestCompletionSessionCachesTables(t *testing.T) {
+	provider := &fakeTableProvider{
+		tables: map[string]*AnalysisTable{
+			"Events": {
+				Columns: []*AnalysisColumn{{Name: "Type", Type: "String"}},
+			},
+		},
+	}
+	session := (&AnalysisContext{Provider: provider}).NewCompletionSession()
+	ctx := context.Background()
+	for _, source := range []string{"Events | where ", "Events | where T", "Events | where Ty"} {
+		got, err := session.SuggestCompletions(ctx, source, parser.Span{
+			Start: len(source),
+			End:   len(source),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.ContainsFunc(got, func(c *Completion) bool { return c.Label == "Type" }) {
+			t.Errorf("session.SuggestCompletions(ctx, %q, ...) does not include Type", source)
+		}
+	}
+	if provider.lookups != 1 {
+		t.Errorf("LookupTable called %d times; want 1", provider.lookups)
+	}
+}
+
+func TestSuggestCompletionsParameters(t *testing.T) {
+	ctx := &AnalysisContext{
+		Tables: testAnalysisContext.Tables,
+		Parameters: map[string]string{
+			"minAge": "$1",
+			"limit":  "$2",
+		},
+	}
+	tests := []struct {
+		source string
+		want   []*Completion
+	}{
+		{
+			source: "People | where Age > min",
+			want: []*Completion{
+				{
+					Label:    "minAge",
+					Text:     "minAge",
+					Span:     parser.Span{Start: 21, End: 24},
+					Kind:     CompletionVariable,
+					Detail:   "parameter",
+					SortText: "0_1_minAge",
+				},
+			},
+		},
+		{
+			source: "let minAge = 21;\nPeople | where Age > min",
+			want: []*Completion{
+				{
+					Label:    "minAge",
+					Text:     "minAge",
+					Span:     parser.Span{Start: 38, End: 41},
+					Kind:     CompletionVariable,
+					Detail:   "let",
+					SortText: "0_1_minAge",
+				},
+			},
+		},
+		{
+			source: "lim",
+			want:   nil,
+		},
+	}
+	for _, test := range tests {
+		got := ctx.SuggestCompletions(test.source, parser.Span{
+			Start: len(test.source),
+			End:   len(test.source),
+		})
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("SuggestCompletions(%q, ...) (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
+
+func TestSuggestCompletionsNilContext(t *testing.T) {
+	var ctx *AnalysisContext
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: "T | where ", want: "count"},
+		{source: "T | ", want: "where"},
+		{source: "let limit = 10;\nT | where x > lim", want: "limit"},
+	}
+	for _, test := range tests {
+		got := ctx.SuggestCompletions(test.source, parser.Span{
+			Start: len(test.source),
+			End:   len(test.source),
+		})
+		if !slices.ContainsFunc(got, func(c *Completion) bool { return c.Label == test.want }) {
+			t.Errorf("(*AnalysisContext)(nil).SuggestCompletions(%q, ...) = %v; want a completion for %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestSuggestCompletionsDatabases(t *testing.T) {
+	ctx := &AnalysisContext{
+		Tables: testAnalysisContext.Tables,
+		Databases: map[string]*AnalysisDatabase{
+			"security": {
+				Description: "Security logs.",
+				Tables: map[string]*AnalysisTable{
+					"Events": {
+						Columns: []*AnalysisColumn{
+							{Name: "Actor", Type: "String"},
+						},
+					},
+					"Login Attempts": {},
+				},
+			},
+			"my db": {},
+		},
+	}
+	tests := []struct {
+		source string
+		want   []*Completion
+	}{
+		{
+			source: "se",
+			want: []*Completion{
+				{
+					Label:         "security",
+					Text:          "security",
+					Span:          parser.Span{Start: 0, End: 2},
+					Kind:          CompletionDatabase,
+					Detail:        "database",
+					Documentation: "Security logs.",
+					SortText:      "0_1_security",
+				},
+			},
+		},
+		{
+			source: "my",
+			want: []*Completion{
+				{
+					Label:    "my db",
+					Text:     "`my db`",
+					Span:     parser.Span{Start: 0, End: 2},
+					Kind:     CompletionDatabase,
+					Detail:   "database",
+					SortText: "0_1_my db",
+				},
+			},
+		},
+		{
+			source: "security.",
+			want: []*Completion{
+				{
+					Label:    "Events",
+					Text:     "Events",
+					Span:     parser.Span{Start: 9, End: 9},
+					Kind:     CompletionTable,
+					Detail:   "table",
+					SortText: "0_0_Events",
+				},
+				{
+					Label:    "Login Attempts",
+					Text:     "`Login Attempts`",
+					Span:     parser.Span{Start: 9, End: 9},
+					Kind:     CompletionTable,
+					Detail:   "table",
+					SortText: "0_0_Login Attempts",
+				},
+			},
+		},
+		{
+			source: "security.Ev",
+			want: []*Completion{
+				{
+					Label:    "Events",
+					Text:     "Events",
+					Span:     parser.Span{Start: 9, End: 11},
+					Kind:     CompletionTable,
+					Detail:   "table",
+					SortText: "0_0_Events",
+				},
+			},
+		},
+		{
+			source: "security.Events | where Ac",
+			want: []*Completion{
+				{
+					Label:    "Actor",
+					Text:     "Actor",
+					Span:     parser.Span{Start: 24, End: 26},
+					Kind:     CompletionColumn,
+					Detail:   "String",
+					SortText: "0_0_Actor",
+				},
+			},
+		},
+		{
+			source: "bogus.",
+			want:   nil,
+		},
+		{
+			source: `database("security").Ev`,
+			want: []*Completion{
+				{
+					Label:    "Events",
+					Text:     "Events",
+					Span:     parser.Span{Start: 21, End: 23},
+					Kind:     CompletionTable,
+					Detail:   "table",
+					SortText: "0_0_Events",
+				},
+			},
+		},
+		{
+			source: `database("security").Events | where Ac`,
+			want: []*Completion{
+				{
+					Label:    "Actor",
+					Text:     "Actor",
+					Span:     parser.Span{Start: 36, End: 38},
+					Kind:     CompletionColumn,
+					Detail:   "String",
+					SortText: "0_0_Actor",
+				},
+			},
+		},
+		{
+			source: "database(",
+			want: []*Completion{
+				{
+					Label:    "my db",
+					Text:     `"my db"`,
+					Span:     parser.Span{Start: 9, End: 9},
+					Kind:     CompletionDatabase,
+					Detail:   "database",
+					SortText: "0_0_my db",
+				},
+				{
+					Label:         "security",
+					Text:          `"security"`,
+					Span:          parser.Span{Start: 9, End: 9},
+					Kind:          CompletionDatabase,
+					Detail:        "database",
+					Documentation: "Security logs.",
+					SortText:      "0_0_security",
+				},
+			},
+		},
+		{
+			source: `cluster("primary").database("security").`,
+			want:   nil,
+		},
+	}
+	for _, test := range tests {
+		got := ctx.SuggestCompletions(test.source, parser.Span{
+			Start: len(test.source),
+			End:   len(test.source),
+		})
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("SuggestCompletions(%q, ...) (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "Valid",
+			source: "let n = 3; let adults = People | where Age > 18; adults | project Name, Twice = Age * 2 | where Twice > n",
+		},
+		{
+			name:   "UnknownTable",
+			source: "Peeple | take 1",
+			want:   []string{`Peeple unknown-table unknown table "Peeple"`},
+		},
+		{
+			name:   "UnknownColumn",
+			source: "People | where Agee > 1 | extend x = 1, y = x + 1 | project Name, z",
+			want: []string{
+				`Agee unknown-column unknown column "Agee" (did you mean "Age"?)`,
+				`z unknown-column unknown column "z"`,
+			},
+		},
+		{
+			name:   "TabularLet",
+			source: "let adults = People | where Age > 18; adults | where Nmae == \"x\"",
+			want:   []string{`Nmae unknown-column unknown column "Nmae" (did you mean "Name"?)`},
+		},
+		{
+			name:   "Summarize",
+			source: "People | summarize n = count() by Name | where n > 1 and Age > 1",
+			want:   []string{`Age unknown-column unknown column "Age"`},
+		},
+		{
+			name:   "Join",
+			source: "People | as P | join (Orders) on Name | where OrderID > 1 and Foo",
+			want:   []string{`Foo unknown-column unknown column "Foo"`},
+		},
+		{
+			name:   "Union",
+			source: "People | union Peeple, (Orders | where Totl > 1) | where OrderID > 1",
+			want: []string{
+				`Peeple unknown-table unknown table "Peeple"`,
+				`Totl unknown-column unknown column "Totl"`,
+			},
+		},
+		{
+			name:   "UnknownColumnsOfUnknownTable",
+			source: "Unknown | where x > 1",
+			want:   []string{`Unknown unknown-table unknown table "Unknown"`},
+		},
+		{
+			name:   "SyntaxError",
+			source: "People | where",
+		},
+		{
+			name:   "IncompleteJoin",
+			source: "People | join",
+		},
+		{
+			name:   "IncompleteJoinRight",
+			source: "People | join kind=inner (",
+		},
+		{
+			name:   "IncompleteTop",
+			source: "People | where Agee > 1 | top",
+			want:   []string{`Agee unknown-column unknown column "Agee" (did you mean "Age"?)`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diags, err := testAnalysisContext.Check(context.Background(), test.source)
+			if err != nil {
+				t.Error(err)
+			}
+			var got []string
+			for _, diag := range diags {
+				got = append(got, test.source[diag.Span.Start:diag.Span.End]+" "+diag.Code+" "+diag.Message)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Check(ctx, %q) (-want +got):\n%s", test.source, diff)
+			}
+		})
+	}
+}
+
+func TestResultColumns(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []*AnalysisColumn
+	}{
+		{
+			source: "People",
+			want:   testAnalysisContext.Tables["People"].Columns,
+		},
+		{
+			source: "People | where Age > 18 | project Name, Adult = Age >= 21, Years = Age, Label = strcat(Name, \"!\")",
+			want: []*AnalysisColumn{
+				{Name: "Name", Type: "String", Description: "Full name."},
+				{Name: "Adult", Type: "Bool"},
+				{Name: "Years", Type: "Int64"},
+				{Name: "Label", Type: "String"},
+			},
+		},
+		{
+			source: "let adults = People | where Age > 18; adults | summarize n = count(), oldest = max(Age) by Name",
+			want: []*AnalysisColumn{
+				{Name: "Name", Type: "String", Description: "Full name."},
+				{Name: "n", Type: "UInt64"},
+				{Name: "oldest", Type: "Int64"},
+			},
+		},
+		{
+			source: "People | extend Score = Age * 2",
+			want: []*AnalysisColumn{
+				{Name: "Name", Type: "String", Description: "Full name."},
+				{Name: "Age", Type: "Int64"},
+				{Name: "Score"},
+			},
+		},
+		{
+			source: "People | union withsource=Source (Orders | project OrderID)",
+			want: []*AnalysisColumn{
+				{Name: "Source", Type: "String"},
+				{Name: "Name", Type: "String", Description: "Full name."},
+				{Name: "Age", Type: "Int64"},
+				{Name: "OrderID", Type: "Int64"},
+			},
+		},
+		{
+			source: "People | project-away Age",
+			want: []*AnalysisColumn{
+				{Name: "Name", Type: "String", Description: "Full name."},
+			},
+		},
+		{
+			source: "Unknown | take 1",
+			want:   nil,
+		},
+		{
+			source: "let n = 1",
+			want:   nil,
+		},
+	}
+	for _, test := range tests {
+		got, err := testAnalysisContext.ResultColumns(context.Background(), test.source)
+		if err != nil {
+			t.Errorf("ResultColumns(ctx, %q): %v", test.source, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("ResultColumns(ctx, %q) (-want +got):\n%s", test.source, diff)
+		}
+	}
+
+	if _, err := testAnalysisContext.ResultColumns(context.Background(), "People | where"); err == nil {
+		t.Error("ResultColumns(ctx, \"People | where\") did not return an error")
+	}
+}
+
+func TestHover(t *testing.T) {
+	ac := &AnalysisContext{
+		Tables: map[string]*AnalysisTable{
+			"People": {
+				Description: "Everyone we know.",
+				Columns: []*AnalysisColumn{
+					{Name: "Name", Type: "String", Description: "Full name."},
+					{Name: "Address", Type: "JSON", Fields: []*AnalysisColumn{
+						{Name: "City", Type: "String"},
+					}},
+				},
+			},
+		},
+		Parameters: map[string]string{"minAge": "18"},
+	}
+	tests := []struct {
+		name   string
+		source string
+		// target is the text to hover over.
+		// The position is the middle of its first occurrence in source.
+		target string
+		want   *HoverInfo
+	}{
+		{
+			name:   "Table",
+			source: "People | take 1",
+			target: "People",
+			want:   &HoverInfo{Kind: CompletionTable, Name: "People", Detail: "table", Documentation: "Everyone we know."},
+		},
+		{
+			name:   "UnionTable",
+			source: "union (People | take 1), People",
+			target: "People",
+			want:   &HoverInfo{Kind: CompletionTable, Name: "People", Detail: "table", Documentation: "Everyone we know."},
+		},
+		{
+			name:   "Operator",
+			source: "People | where Name == \"x\"",
+			target: "where",
+			want: &HoverInfo{
+				Kind:          CompletionOperator,
+				Name:          "where",
+				Detail:        "where Predicate",
+				Documentation: "Filters the input to the rows that satisfy a predicate.",
+			},
+		},
+		{
+			name:   "Column",
+			source: "People | where Name == \"x\"",
+			target: "Name",
+			want:   &HoverInfo{Kind: CompletionColumn, Name: "Name", Detail: "String", Documentation: "Full name."},
+		},
+		{
+			name:   "ProjectColumn",
+			source: "People | project Name",
+			target: "Name",
+			want:   &HoverInfo{Kind: CompletionColumn, Name: "Name", Detail: "String", Documentation: "Full name."},
+		},
+		{
+			name:   "Field",
+			source: "People | where Address.City == \"x\"",
+			target: "City",
+			want:   &HoverInfo{Kind: CompletionField, Name: "City", Detail: "String"},
+		},
+		{
+			name:   "Function",
+			source: "People | summarize countif(isnull(Name))",
+			target: "countif",
+			want: &HoverInfo{
+				Kind:          CompletionFunction,
+				Name:          "countif",
+				Detail:        "countif(predicate)",
+				Documentation: "Returns the number of records in the group for which predicate is true.",
+			},
+		},
+		{
+			name:   "Let",
+			source: "let n = 1; People | take n",
+			target: "n",
+			want:   &HoverInfo{Kind: CompletionVariable, Name: "n", Detail: "let"},
+		},
+		{
+			name:   "Parameter",
+			source: "People | extend x = minAge",
+			target: "minAge",
+			want:   &HoverInfo{Kind: CompletionVariable, Name: "minAge", Detail: "parameter"},
+		},
+		{
+			name:   "JoinRight",
+			source: "People | join (People | where Name == \"x\") on Name",
+			target: "Name ==",
+			want:   &HoverInfo{Kind: CompletionColumn, Name: "Name", Detail: "String", Documentation: "Full name."},
+		},
+		{
+			name:   "UnknownColumn",
+			source: "People | where Age > 1",
+			target: "Age",
+		},
+		{
+			name:   "Literal",
+			source: "People | take 10",
+			target: "10",
+		},
+		{
+			name:   "IncompleteTop",
+			source: "People | top",
+			target: "| top",
+		},
+		{
+			name:   "IncompleteTopAfterWhere",
+			source: "People | where Name == \"x\" | top",
+			target: "| top",
+		},
+		{
+			name:   "IncompleteJoin",
+			source: "People | join",
+			target: "join",
+			want: &HoverInfo{
+				Kind:          CompletionOperator,
+				Name:          "join",
+				Detail:        "join [kind = Flavor] (Right) on Conditions",
+				Documentation: "Merges the rows of two tables by matching values.",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := strings.Index(test.source, test.target)
+			if start < 0 {
+				t.Fatalf("%q not in source", test.target)
+			}
+			pos := start + 1
+			got, err := ac.Hover(context.Background(), test.source, pos)
+			if err != nil {
+				t.Error(err)
+			}
+			if test.want != nil {
+				test.want.Span = parser.Span{Start: start, End: start + len(test.want.Name)}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Hover(ctx, %q, %d) (-want +got):\n%s", test.source, pos, diff)
+			}
+		})
+	}
+}
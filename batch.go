@@ -0,0 +1,72 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// A CompileResult is the outcome of compiling one query
+// with [*CompileOptions.CompileAll].
+type CompileResult struct {
+	// SQL is the compiled query.
+	// It is empty if Err is not nil.
+	SQL string
+	// Err is the error that [*CompileOptions.Compile] would return for the query.
+	Err error
+}
+
+// CompileAll compiles independent queries concurrently.
+// This is equivalent to new(CompileOptions).CompileAll(ctx, sources).
+func CompileAll(ctx context.Context, sources []string) []CompileResult {
+	return ((*CompileOptions)(nil)).CompileAll(ctx, sources)
+}
+
+// CompileAll compiles each of the given queries
+// like [*CompileOptions.CompileContext]
+// and returns their results in the same order as sources.
+// At most [CompileOptions.Concurrency] queries are compiled at once.
+// A failure in one query does not stop the others from being compiled.
+// If ctx is canceled, the queries that have not started compiling
+// fail with ctx.Err().
+//
+// Because the queries are compiled concurrently,
+// the callbacks in opts, like Warn and Tracer,
+// and the TableProvider of its AnalysisContext, if any,
+// must be safe to call from multiple goroutines.
+func (opts *CompileOptions) CompileAll(ctx context.Context, sources []string) []CompileResult {
+	results := make([]CompileResult, len(sources))
+	workers := runtime.GOMAXPROCS(0)
+	if opts != nil && opts.Concurrency > 0 {
+		workers = opts.Concurrency
+	}
+	workers = min(workers, len(sources))
+
+	// Each worker claims the next uncompiled query
+	// so that a slow query does not hold up a fixed share of the batch.
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(sources) {
+					return
+				}
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i].SQL, results[i].Err = opts.compile(ctx, sources[i], nil)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
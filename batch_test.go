@@ -0,0 +1,49 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCompileAll(t *testing.T) {
+	sources := []string{
+		"StormEvents | take 10",
+		"StormEvents | where",
+		`StormEvents | where State == "TEXAS" | count`,
+		"",
+	}
+	for i := 0; i < 20; i++ {
+		sources = append(sources, fmt.Sprintf("T | project x = %d", i))
+	}
+
+	for _, concurrency := range []int{0, 1, 3} {
+		opts := &CompileOptions{Concurrency: concurrency}
+		got := opts.CompileAll(context.Background(), sources)
+		if len(got) != len(sources) {
+			t.Fatalf("Concurrency=%d: CompileAll(ctx, sources) returned %d results; want %d", concurrency, len(got), len(sources))
+		}
+		for i, source := range sources {
+			wantSQL, wantErr := opts.Compile(source)
+			if got[i].SQL != wantSQL || (got[i].Err == nil) != (wantErr == nil) {
+				t.Errorf("Concurrency=%d: CompileAll(ctx, sources)[%d] = %q, %v; want %q, %v",
+					concurrency, i, got[i].SQL, got[i].Err, wantSQL, wantErr)
+			}
+		}
+	}
+}
+
+func TestCompileAllCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got := CompileAll(ctx, []string{"T | take 1", "T | count"})
+	for i, r := range got {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("CompileAll(canceled, sources)[%d].Err = %v; want %v", i, r.Err, context.Canceled)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package build provides functions for constructing Pipeline Query Language queries
+// from Go code, such as from the state of a user interface.
+// Names and literals are quoted as needed,
+// so the resulting query is always syntactically valid.
+//
+//	q := build.Table("Events").
+//		Where(build.Eq(build.Col("x"), build.Str("y"))).
+//		Take(10)
+package build
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// A Query is a tabular expression.
+// Queries are immutable:
+// methods like [*Query.Where] return a new Query.
+type Query struct {
+	src string
+}
+
+// Table returns a query that reads from the table with the given name.
+func Table(name string) *Query {
+	return &Query{src: formatIdent(name)}
+}
+
+// DatabaseTable returns a query that reads from the table with the given name
+// in the given database.
+func DatabaseTable(database, name string) *Query {
+	return &Query{src: formatIdent(database) + "." + formatIdent(name)}
+}
+
+func (q *Query) pipe(op string) *Query {
+	return &Query{src: q.src + "\n| " + op}
+}
+
+// Where returns a query that filters q to the rows that satisfy pred.
+func (q *Query) Where(pred Expr) *Query {
+	return q.pipe("where " + pred.src)
+}
+
+// Project returns a query that selects the given columns from q.
+func (q *Query) Project(cols ...Column) *Query {
+	return q.pipe("project " + formatColumns(cols))
+}
+
+// Extend returns a query that adds the given computed columns to q.
+func (q *Query) Extend(cols ...Column) *Query {
+	return q.pipe("extend " + formatColumns(cols))
+}
+
+// Summarize returns a query that aggregates all of q's rows into a single row.
+func (q *Query) Summarize(aggs ...Column) *Query {
+	return q.pipe("summarize " + formatColumns(aggs))
+}
+
+// SummarizeBy returns a query that aggregates q's rows into groups
+// that have the same values for the group expressions.
+func (q *Query) SummarizeBy(aggs []Column, groupBy ...Column) *Query {
+	op := "summarize "
+	if len(aggs) > 0 {
+		op += formatColumns(aggs) + " "
+	}
+	return q.pipe(op + "by " + formatColumns(groupBy))
+}
+
+// Sort returns a query that sorts q's rows by the given terms.
+func (q *Query) Sort(terms ...SortTerm) *Query {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		parts = append(parts, term.src)
+	}
+	return q.pipe("sort by " + strings.Join(parts, ", "))
+}
+
+// Take returns a query that returns up to n rows from q.
+func (q *Query) Take(n int64) *Query {
+	return q.pipe("take " + strconv.FormatInt(n, 10))
+}
+
+// Top returns a query that returns the first n rows from q
+// when sorted by the given term.
+func (q *Query) Top(n int64, term SortTerm) *Query {
+	return q.pipe("top " + strconv.FormatInt(n, 10) + " by " + term.src)
+}
+
+// Count returns a query that returns the number of rows in q.
+func (q *Query) Count() *Query {
+	return q.pipe("count")
+}
+
+// As returns a query that binds a name to q's result.
+func (q *Query) As(name string) *Query {
+	return q.pipe("as " + formatIdent(name))
+}
+
+// Join returns a query that merges the rows of q and right
+// that satisfy the given conditions.
+// flavor is the kind of join, like "inner" or "leftouter".
+// If flavor is empty, the default join kind is used.
+func (q *Query) Join(flavor string, right *Query, conditions ...Expr) *Query {
+	op := "join "
+	if flavor != "" {
+		op += "kind=" + formatIdent(flavor) + " "
+	}
+	op += "(" + right.src + ") on " + formatExprs(conditions)
+	return q.pipe(op)
+}
+
+// String returns the query's Pipeline Query Language source.
+func (q *Query) String() string {
+	return q.src
+}
+
+// TabularExpr returns the query's syntax tree.
+// The spans in the syntax tree refer to the text returned by [*Query.String].
+// TabularExpr returns an error if the query's source cannot be parsed,
+// which indicates a bug in this package.
+func (q *Query) TabularExpr() (*parser.TabularExpr, error) {
+	stmts, err := parser.Parse(q.src)
+	if err != nil {
+		return nil, err
+	}
+	if len(stmts) != 1 {
+		return nil, fmt.Errorf("build query: got %d statements", len(stmts))
+	}
+	expr, ok := stmts[0].(*parser.TabularExpr)
+	if !ok {
+		return nil, fmt.Errorf("build query: got %T instead of tabular expression", stmts[0])
+	}
+	return expr, nil
+}
+
+// An Expr is a scalar expression.
+type Expr struct {
+	src string
+	// binary is true if src is a binary expression
+	// that must be parenthesized when used as an operand.
+	binary bool
+	// path is true if src is a dot-separated sequence of identifiers.
+	path bool
+}
+
+// Col returns an expression that refers to the column with the given name.
+func Col(name string) Expr {
+	return Expr{src: formatIdent(name), path: true}
+}
+
+// Field returns an expression that refers to a nested field of x.
+func Field(x Expr, name string) Expr {
+	if x.path {
+		return Expr{src: x.src + "." + formatIdent(name), path: true}
+	}
+	return Expr{src: operand(x) + "[" + Str(name).src + "]"}
+}
+
+// Left returns an expression that refers to the column with the given name
+// on the left side of a join.
+func Left(name string) Expr {
+	return Expr{src: "$left." + formatIdent(name), path: true}
+}
+
+// Right returns an expression that refers to the column with the given name
+// on the right side of a join.
+func Right(name string) Expr {
+	return Expr{src: "$right." + formatIdent(name), path: true}
+}
+
+// Str returns a string literal.
+func Str(s string) Expr {
+	sb := new(strings.Builder)
+	sb.WriteString(`"`)
+	for _, c := range s {
+		switch c {
+		case '"', '\\':
+			sb.WriteRune('\\')
+			sb.WriteRune(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	sb.WriteString(`"`)
+	return Expr{src: sb.String()}
+}
+
+// Int returns an integer literal.
+func Int(i int64) Expr {
+	if i < 0 {
+		return Expr{src: "-" + strconv.FormatUint(uint64(-i), 10)}
+	}
+	return Expr{src: strconv.FormatInt(i, 10)}
+}
+
+// Float returns a floating-point literal.
+// Float panics if f is infinite or NaN.
+func Float(f float64) Expr {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		panic(fmt.Errorf("build.Float(%v): not a finite number", f))
+	}
+	s := strconv.FormatFloat(math.Abs(f), 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	if math.Signbit(f) {
+		s = "-" + s
+	}
+	return Expr{src: s}
+}
+
+// Bool returns a boolean literal.
+func Bool(b bool) Expr {
+	return Expr{src: strconv.FormatBool(b)}
+}
+
+// Call returns a function call expression.
+func Call(name string, args ...Expr) Expr {
+	return Expr{src: formatIdent(name) + "(" + formatExprs(args) + ")"}
+}
+
+// Not returns the logical negation of x.
+func Not(x Expr) Expr {
+	return Call("not", x)
+}
+
+// In returns an expression that reports whether x is equal to any of vals.
+func In(x Expr, vals ...Expr) Expr {
+	return Expr{src: operand(x) + " in (" + formatExprs(vals) + ")", binary: true}
+}
+
+// Eq returns an expression that reports whether x == y.
+func Eq(x, y Expr) Expr { return binary(x, "==", y) }
+
+// Ne returns an expression that reports whether x != y.
+func Ne(x, y Expr) Expr { return binary(x, "!=", y) }
+
+// CaseInsensitiveEq returns an expression that reports whether x =~ y,
+// which compares strings ignoring case.
+func CaseInsensitiveEq(x, y Expr) Expr { return binary(x, "=~", y) }
+
+// CaseInsensitiveNe returns an expression that reports whether x !~ y,
+// which compares strings ignoring case.
+func CaseInsensitiveNe(x, y Expr) Expr { return binary(x, "!~", y) }
+
+// Lt returns an expression that reports whether x < y.
+func Lt(x, y Expr) Expr { return binary(x, "<", y) }
+
+// Le returns an expression that reports whether x <= y.
+func Le(x, y Expr) Expr { return binary(x, "<=", y) }
+
+// Gt returns an expression that reports whether x > y.
+func Gt(x, y Expr) Expr { return binary(x, ">", y) }
+
+// Ge returns an expression that reports whether x >= y.
+func Ge(x, y Expr) Expr { return binary(x, ">=", y) }
+
+// Add returns the expression x + y.
+func Add(x, y Expr) Expr { return binary(x, "+", y) }
+
+// Sub returns the expression x - y.
+func Sub(x, y Expr) Expr { return binary(x, "-", y) }
+
+// Mul returns the expression x * y.
+func Mul(x, y Expr) Expr { return binary(x, "*", y) }
+
+// Div returns the expression x / y.
+func Div(x, y Expr) Expr { return binary(x, "/", y) }
+
+// And returns an expression that reports whether all of the given expressions are true.
+// And panics if no expressions are given.
+func And(x ...Expr) Expr { return chain("and", x) }
+
+// Or returns an expression that reports whether any of the given expressions are true.
+// Or panics if no expressions are given.
+func Or(x ...Expr) Expr { return chain("or", x) }
+
+func binary(x Expr, op string, y Expr) Expr {
+	return Expr{src: operand(x) + " " + op + " " + operand(y), binary: true}
+}
+
+func chain(op string, x []Expr) Expr {
+	if len(x) == 0 {
+		panic(fmt.Errorf("build: %s called with no expressions", op))
+	}
+	if len(x) == 1 {
+		return x[0]
+	}
+	parts := make([]string, 0, len(x))
+	for _, xi := range x {
+		parts = append(parts, operand(xi))
+	}
+	return Expr{src: strings.Join(parts, " "+op+" "), binary: true}
+}
+
+// operand returns the source of x, parenthesized if necessary.
+func operand(x Expr) string {
+	if x.binary {
+		return "(" + x.src + ")"
+	}
+	return x.src
+}
+
+// String returns the expression's Pipeline Query Language source.
+func (x Expr) String() string {
+	return x.src
+}
+
+// A Column is a column definition in an operator like project or summarize.
+// An [Expr] is a Column whose name is derived from the expression.
+type Column interface {
+	columnSource() string
+}
+
+func (x Expr) columnSource() string {
+	return x.src
+}
+
+type namedColumn struct {
+	name string
+	x    Expr
+}
+
+// Named returns a column definition that assigns the result of x to a column
+// with the given name.
+func Named(name string, x Expr) Column {
+	return namedColumn{name: name, x: x}
+}
+
+func (col namedColumn) columnSource() string {
+	return formatIdent(col.name) + " = " + col.x.src
+}
+
+// A SortTerm is an expression to sort by.
+type SortTerm struct {
+	src string
+}
+
+// Asc returns a term that sorts by x in ascending order.
+func Asc(x Expr) SortTerm {
+	return SortTerm{src: operand(x) + " asc"}
+}
+
+// Desc returns a term that sorts by x in descending order.
+func Desc(x Expr) SortTerm {
+	return SortTerm{src: operand(x) + " desc"}
+}
+
+func formatColumns(cols []Column) string {
+	parts := make([]string, 0, len(cols))
+	for _, col := range cols {
+		parts = append(parts, col.columnSource())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatExprs(exprs []Expr) string {
+	parts := make([]string, 0, len(exprs))
+	for _, x := range exprs {
+		parts = append(parts, x.src)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatIdent returns name as it should appear in pql source,
+// quoting it with backticks if necessary.
+func formatIdent(name string) string {
+	t := parser.NewTokenizer(name)
+	if t.Next() &&
+		t.Token().Kind == parser.TokenIdentifier &&
+		t.Token().Span == (parser.Span{Start: 0, End: len(name)}) {
+		return name
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package build
+
+import (
+	"testing"
+
+	"github.com/runreveal/pql/parser"
+)
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query *Query
+		want  string
+	}{
+		{
+			name:  "Table",
+			query: Table("StormEvents"),
+			want:  "StormEvents",
+		},
+		{
+			name:  "QuotedTable",
+			query: Table("Storm Events"),
+			want:  "`Storm Events`",
+		},
+		{
+			name:  "DatabaseTable",
+			query: DatabaseTable("security", "by"),
+			want:  "security.`by`",
+		},
+		{
+			name: "Where",
+			query: Table("Events").
+				Where(Eq(Col("x"), Str("y"))).
+				Take(10),
+			want: "Events\n| where x == \"y\"\n| take 10",
+		},
+		{
+			name: "Precedence",
+			query: Table("T").
+				Where(And(
+					Or(Eq(Col("a"), Int(1)), Eq(Col("a"), Int(-2))),
+					Gt(Mul(Add(Col("b"), Float(1)), Col("c")), Float(1e6)),
+					Not(In(Col("d"), Str("x"), Str("y"))),
+				)),
+			want: "T\n| where ((a == 1) or (a == -2)) and (((b + 1.0) * c) > 1e+06) and not(d in (\"x\", \"y\"))",
+		},
+		{
+			name:  "CaseInsensitive",
+			query: Table("T").Where(Or(CaseInsensitiveEq(Col("a"), Str("x")), CaseInsensitiveNe(Col("b"), Str("y")))),
+			want:  "T\n| where (a =~ \"x\") or (b !~ \"y\")",
+		},
+		{
+			name:  "Strings",
+			query: Table("T").Where(Eq(Col("s"), Str("a \"quoted\"\n\\string"))),
+			want:  "T\n| where s == \"a \\\"quoted\\\"\\n\\\\string\"",
+		},
+		{
+			name: "Fields",
+			query: Table("T").
+				Where(Eq(Field(Field(Col("payload"), "user"), "first name"), Str("x"))).
+				Project(Named("y", Field(Call("parse_json", Col("z")), "k"))),
+			want: "T\n| where payload.user.`first name` == \"x\"\n| project y = parse_json(z)[\"k\"]",
+		},
+		{
+			name: "Summarize",
+			query: Table("T").
+				Extend(Named("doubled", Mul(Col("x"), Int(2)))).
+				SummarizeBy([]Column{Named("n", Call("count")), Call("sum", Col("doubled"))}, Col("State")).
+				Sort(Desc(Col("n")), Asc(Col("State"))),
+			want: "T\n| extend doubled = x * 2\n| summarize n = count(), sum(doubled) by State\n| sort by n desc, State asc",
+		},
+		{
+			name:  "SummarizeWithoutAggregates",
+			query: Table("T").SummarizeBy(nil, Col("State")),
+			want:  "T\n| summarize by State",
+		},
+		{
+			name:  "Top",
+			query: Table("T").Top(3, Desc(Col("x"))).Count(),
+			want:  "T\n| top 3 by x desc\n| count",
+		},
+		{
+			name: "Join",
+			query: Table("T").Join("inner", Table("U").Where(Bool(true)), Col("id"), Eq(Left("a"), Right("b"))).
+				As("Joined"),
+			want: "T\n| join kind=inner (U\n| where true) on id, $left.a == $right.b\n| as Joined",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.query.String(); got != test.want {
+				t.Errorf("String() = %q; want %q", got, test.want)
+			}
+			expr, err := test.query.TabularExpr()
+			if err != nil {
+				t.Fatal("TabularExpr:", err)
+			}
+			want, err := parser.Parse(test.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !parser.Equal(want[0], expr) {
+				t.Errorf("TabularExpr() = %#v; want %#v", expr, want[0])
+			}
+		})
+	}
+}
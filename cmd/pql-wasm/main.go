@@ -0,0 +1,243 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build js && wasm
+
+// pql-wasm exposes the pql compiler to JavaScript
+// when built with GOOS=js GOARCH=wasm.
+//
+// Running the module defines a global pql object with the methods:
+//
+//	compile(source) -> {sql: string|null, diagnostics: [Diagnostic]}
+//	diagnostics(source) -> [Diagnostic]
+//	suggestCompletions(source, offset) -> [Completion]
+//	setSchema(text) -> error message or null
+//	setDialect(name) -> error message or null
+//
+// Offsets are indices into the JavaScript source string
+// (UTF-16 code units), not byte offsets.
+// setSchema takes the text of a schema file as described by [pql.ParseSchema],
+// or an empty string to clear the schema.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"syscall/js"
+	"unicode/utf8"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/parser"
+)
+
+func main() {
+	b := &bindings{opts: new(pql.CompileOptions)}
+	b.session = b.opts.AnalysisContext.NewCompletionSession()
+	js.Global().Set("pql", js.ValueOf(map[string]any{
+		"compile":            js.FuncOf(b.compile),
+		"diagnostics":        js.FuncOf(b.diagnostics),
+		"suggestCompletions": js.FuncOf(b.suggestCompletions),
+		"setSchema":          js.FuncOf(b.setSchema),
+		"setDialect":         js.FuncOf(b.setDialect),
+	}))
+	// Keep the module running so that the functions can be called.
+	select {}
+}
+
+// bindings holds the state shared by the JavaScript functions.
+type bindings struct {
+	opts    *pql.CompileOptions
+	session *pql.CompletionSession
+}
+
+// jsDiagnostic is the JavaScript representation of a [parser.Diagnostic].
+// The offsets are omitted if the diagnostic
+// does not refer to a specific location.
+type jsDiagnostic struct {
+	Start    *int   `json:"start,omitempty"`
+	End      *int   `json:"end,omitempty"`
+	Severity string `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message"`
+}
+
+// jsCompletion is the JavaScript representation of a [pql.Completion].
+type jsCompletion struct {
+	Label         string `json:"label"`
+	Text          string `json:"text"`
+	Start         int    `json:"start"`
+	End           int    `json:"end"`
+	Kind          string `json:"kind"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+type compileResult struct {
+	SQL         *string         `json:"sql"`
+	Diagnostics []*jsDiagnostic `json:"diagnostics"`
+}
+
+func (b *bindings) compile(this js.Value, args []js.Value) any {
+	source := stringArg(args, 0)
+	sql, diags := b.check(source)
+	result := &compileResult{Diagnostics: newJSDiagnostics(source, diags)}
+	if !hasError(diags) {
+		result.SQL = &sql
+	}
+	return toJS(result)
+}
+
+func (b *bindings) diagnostics(this js.Value, args []js.Value) any {
+	source := stringArg(args, 0)
+	_, diags := b.check(source)
+	return toJS(newJSDiagnostics(source, diags))
+}
+
+// check compiles source and returns its SQL
+// along with the problems reported by the compiler
+// and by the schema, if one is set.
+func (b *bindings) check(source string) (string, []parser.Diagnostic) {
+	var diags []parser.Diagnostic
+	opts := new(pql.CompileOptions)
+	*opts = *b.opts
+	opts.Warn = func(diag parser.Diagnostic) {
+		diags = append(diags, diag)
+	}
+	sql, err := opts.Compile(source)
+	if err != nil {
+		return "", append(diags, parser.Diagnostics(err)...)
+	}
+	checkDiags, err := b.opts.AnalysisContext.Check(context.Background(), source)
+	diags = append(diags, checkDiags...)
+	diags = append(diags, parser.Diagnostics(err)...)
+	return sql, diags
+}
+
+func (b *bindings) suggestCompletions(this js.Value, args []js.Value) any {
+	source := stringArg(args, 0)
+	pos := 0
+	if len(args) > 1 && args[1].Type() == js.TypeNumber {
+		pos = byteOffset(source, args[1].Int())
+	}
+	completions, _ := b.session.SuggestCompletions(context.Background(), source, parser.Span{Start: pos, End: pos})
+	result := make([]*jsCompletion, 0, len(completions))
+	for _, c := range completions {
+		result = append(result, &jsCompletion{
+			Label:         c.Label,
+			Text:          c.Text,
+			Start:         utf16Offset(source, c.Span.Start),
+			End:           utf16Offset(source, c.Span.End),
+			Kind:          strings.ToLower(strings.TrimPrefix(c.Kind.String(), "Completion")),
+			Detail:        c.Detail,
+			Documentation: c.Documentation,
+		})
+	}
+	return toJS(result)
+}
+
+func (b *bindings) setSchema(this js.Value, args []js.Value) any {
+	text := stringArg(args, 0)
+	var ac *pql.AnalysisContext
+	if text != "" {
+		var err error
+		ac, err = pql.ParseSchema([]byte(text))
+		if err != nil {
+			return err.Error()
+		}
+	}
+	b.opts.AnalysisContext = ac
+	b.session = ac.NewCompletionSession()
+	return nil
+}
+
+func (b *bindings) setDialect(this js.Value, args []js.Value) any {
+	name := stringArg(args, 0)
+	for _, d := range []pql.Dialect{pql.ClickHouseDialect, pql.PostgresDialect, pql.DuckDBDialect} {
+		if name == d.String() {
+			b.opts.Dialect = d
+			return nil
+		}
+	}
+	return fmt.Sprintf("unknown dialect %q (must be clickhouse, postgres, or duckdb)", name)
+}
+
+func hasError(diags []parser.Diagnostic) bool {
+	for _, diag := range diags {
+		if diag.Severity == parser.SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func newJSDiagnostics(source string, diags []parser.Diagnostic) []*jsDiagnostic {
+	slices.SortStableFunc(diags, func(a, b parser.Diagnostic) int {
+		return a.Span.Start - b.Span.Start
+	})
+	result := make([]*jsDiagnostic, 0, len(diags))
+	for _, diag := range diags {
+		jd := &jsDiagnostic{
+			Severity: diag.Severity.String(),
+			Code:     diag.Code,
+			Message:  diag.Message,
+		}
+		if diag.Span.IsValid() {
+			start := utf16Offset(source, diag.Span.Start)
+			end := utf16Offset(source, diag.Span.End)
+			jd.Start, jd.End = &start, &end
+		}
+		result = append(result, jd)
+	}
+	return result
+}
+
+// toJS converts v to a JavaScript value by way of JSON.
+func toJS(v any) js.Value {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return js.Global().Get("JSON").Call("parse", string(data))
+}
+
+// stringArg returns args[i] as a string
+// or the empty string if it is missing or not a string.
+func stringArg(args []js.Value, i int) string {
+	if i >= len(args) || args[i].Type() != js.TypeString {
+		return ""
+	}
+	return args[i].String()
+}
+
+// byteOffset returns the byte offset in s
+// of the given number of UTF-16 code units.
+// Offsets past the end of s return len(s).
+func byteOffset(s string, units int) int {
+	for i, c := range s {
+		if units <= 0 {
+			return i
+		}
+		units -= utf16Len(c)
+	}
+	return len(s)
+}
+
+// utf16Offset returns the number of UTF-16 code units
+// in s before the byte offset off.
+func utf16Offset(s string, off int) int {
+	units := 0
+	for _, c := range s[:min(off, len(s))] {
+		units += utf16Len(c)
+	}
+	return units
+}
+
+func utf16Len(c rune) int {
+	if c >= 0x10000 && c <= utf8.MaxRune {
+		return 2
+	}
+	return 1
+}
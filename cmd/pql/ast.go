@@ -0,0 +1,174 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/runreveal/pql/parser"
+	"github.com/spf13/cobra"
+)
+
+func newASTCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "ast [options] [FILE]",
+		Short: "Print the syntax tree of Pipeline Query Language statements",
+		Long: "Print the syntax tree of Pipeline Query Language statements.\n\n" +
+			"The json format writes a JSON array with one object per statement.\n" +
+			"Each node has a \"type\" and a \"span\" of byte offsets,\n" +
+			"followed by its fields.\n" +
+			"The dump format is the debugging format of parser.Dump.\n" +
+			"With no file, ast reads standard input.",
+		Args:                  cobra.MaximumNArgs(1),
+		DisableFlagsInUseLine: true,
+	}
+	format := c.Flags().String("format", "json", "output `format`: json or dump")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		name := "<stdin>"
+		var source []byte
+		var err error
+		if len(args) == 0 || args[0] == "-" {
+			source, err = io.ReadAll(os.Stdin)
+		} else {
+			name = args[0]
+			source, err = os.ReadFile(name)
+		}
+		if err != nil {
+			return err
+		}
+		return runAST(os.Stdout, os.Stderr, name, string(source), *format)
+	}
+	return c
+}
+
+// runAST parses source and writes its syntax tree to output in the given format.
+// Parse errors are written to diagOutput.
+func runAST(output, diagOutput io.Writer, name, source, format string) error {
+	if format != "json" && format != "dump" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	stmts, err := parser.Parse(source)
+	if err != nil {
+		for _, diag := range parser.Diagnostics(err) {
+			if err := writeTextDiagnostic(diagOutput, name, source, diag); err != nil {
+				return err
+			}
+		}
+		return errors.New("parse failed")
+	}
+
+	if format == "dump" {
+		for _, stmt := range stmts {
+			if _, err := fmt.Fprintln(output, parser.Dump(stmt)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	nodes := make([]any, 0, len(stmts))
+	for _, stmt := range stmts {
+		nodes = append(nodes, astJSON(reflect.ValueOf(stmt)))
+	}
+	data, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = output.Write(data)
+	return err
+}
+
+var (
+	nodeType = reflect.TypeOf((*parser.Node)(nil)).Elem()
+	spanType = reflect.TypeOf(parser.Span{})
+)
+
+// astJSON converts v, a [parser.Node] or a slice of nodes,
+// to a value that encodes as JSON.
+// Nodes become objects with their type, span, and exported fields.
+// Nil nodes, invalid spans, and false booleans are omitted.
+func astJSON(v reflect.Value) any {
+	switch {
+	case v.Kind() == reflect.Slice:
+		list := make([]any, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			list = append(list, astJSON(v.Index(i)))
+		}
+		return list
+	case v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Interface {
+			return astJSON(v.Elem())
+		}
+	case v.Type() == spanType:
+		span := v.Interface().(parser.Span)
+		if !span.IsValid() {
+			return nil
+		}
+		return jsonObject{{"start", span.Start}, {"end", span.End}}
+	}
+	if !v.Type().Implements(nodeType) {
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+		return v.Interface()
+	}
+
+	n := v.Interface().(parser.Node)
+	elem := v.Elem()
+	obj := jsonObject{
+		{"type", elem.Type().Name()},
+		{"span", astJSON(reflect.ValueOf(n.Span()))},
+	}
+	for i := 0; i < elem.NumField(); i++ {
+		field := elem.Type().Field(i)
+		fv := elem.Field(i)
+		if !field.IsExported() || fv.IsZero() {
+			continue
+		}
+		if value := astJSON(fv); value != nil {
+			obj = append(obj, jsonField{field.Name, value})
+		}
+	}
+	return obj
+}
+
+// jsonObject is a JSON object that preserves the order of its fields.
+type jsonObject []jsonField
+
+type jsonField struct {
+	name  string
+	value any
+}
+
+func (obj jsonObject) MarshalJSON() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString("{")
+	for i, f := range obj {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		name, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
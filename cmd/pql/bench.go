@@ -0,0 +1,275 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/parser"
+	"github.com/runreveal/pql/pqleval"
+	"github.com/spf13/cobra"
+)
+
+func newBenchCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "bench [options] [FILE [...]]",
+		Short: "Measure how long queries take to compile",
+		Long: "Parse and compile the query in each file repeatedly\n" +
+			"and report the latency distribution and allocations of each phase.\n\n" +
+			"With --exec-count and either --dsn or --table,\n" +
+			"bench also runs each query and reports the latency of compiling,\n" +
+			"running, and reading every row of the result.\n\n" +
+			"The text format writes a table with one row per file and phase.\n" +
+			"The json format writes one JSON object per file and phase\n" +
+			"with latencies in nanoseconds.\n" +
+			"With no files, bench reads standard input.",
+		Args:                  cobra.ArbitraryArgs,
+		DisableFlagsInUseLine: true,
+	}
+	count := c.Flags().IntP("count", "n", 1000, "number of times to parse and compile each query")
+	execCount := c.Flags().Int("exec-count", 0, "number of times to run each query")
+	dsn := c.Flags().String("dsn", "", "`URL` of a ClickHouse database to run queries on")
+	tableFlags := c.Flags().StringArray("table", nil, "`NAME=FILE` to load as a table for running queries (may be repeated)")
+	dialectName := c.Flags().String("dialect", "clickhouse", "SQL dialect to write: clickhouse, postgres, or duckdb")
+	c.RegisterFlagCompletionFunc("dialect", completeDialects)
+	format := c.Flags().String("format", "text", "output `format`: text or json")
+	c.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		opts := &benchOptions{
+			compile:   new(pql.CompileOptions),
+			count:     *count,
+			execCount: *execCount,
+			dsn:       *dsn,
+		}
+		opts.compile.Dialect, err = parseDialect(*dialectName)
+		if err != nil {
+			return err
+		}
+		if *dsn != "" && len(*tableFlags) > 0 {
+			return fmt.Errorf("cannot use --table with --dsn")
+		}
+		opts.tables, err = parseTableFlags(*tableFlags)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			args = []string{"-"}
+		}
+		var queries []benchQuery
+		for _, path := range args {
+			q := benchQuery{name: path}
+			var source []byte
+			if path == "-" {
+				q.name = "<stdin>"
+				source, err = io.ReadAll(os.Stdin)
+			} else {
+				source, err = os.ReadFile(path)
+			}
+			if err != nil {
+				return err
+			}
+			q.source = string(source)
+			queries = append(queries, q)
+		}
+		return runBench(cmd.Context(), os.Stdout, queries, opts, *format)
+	}
+	return c
+}
+
+type benchQuery struct {
+	name   string
+	source string
+}
+
+type benchOptions struct {
+	compile *pql.CompileOptions
+	// count is the number of times to parse and compile each query.
+	count int
+	// execCount is the number of times to run each query.
+	// Queries are run on the ClickHouse server named by dsn if it is set,
+	// or over the files in tables otherwise.
+	execCount int
+	dsn       string
+	tables    map[string]string
+}
+
+// benchResult is the measurements of one phase of a query.
+// Durations are encoded in JSON as nanoseconds.
+type benchResult struct {
+	Name        string        `json:"name"`
+	Phase       string        `json:"phase"`
+	N           int           `json:"n"`
+	Min         time.Duration `json:"min_ns"`
+	P50         time.Duration `json:"p50_ns"`
+	P90         time.Duration `json:"p90_ns"`
+	P99         time.Duration `json:"p99_ns"`
+	Max         time.Duration `json:"max_ns"`
+	AllocsPerOp uint64        `json:"allocs_per_op"`
+	BytesPerOp  uint64        `json:"bytes_per_op"`
+}
+
+// runBench measures each query and writes the results to output
+// in the given format.
+func runBench(ctx context.Context, output io.Writer, queries []benchQuery, opts *benchOptions, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if opts.count < 1 {
+		return errors.New("count must be positive")
+	}
+	if opts.execCount < 0 {
+		return errors.New("exec count must not be negative")
+	}
+	var env *pqleval.Env
+	if opts.execCount > 0 && opts.dsn == "" {
+		if len(opts.tables) == 0 {
+			return errors.New("running queries requires --dsn or --table")
+		}
+		var err error
+		env, err = newFileEnv(opts.tables)
+		if err != nil {
+			return err
+		}
+	}
+
+	var results []*benchResult
+	for _, q := range queries {
+		// Report queries that don't compile before spending time on them.
+		if _, err := opts.compile.Compile(q.source); err != nil {
+			return fmt.Errorf("%s: %v", q.name, err)
+		}
+
+		r, err := measure(opts.count, func() error {
+			_, err := parser.Parse(q.source)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", q.name, err)
+		}
+		r.Name, r.Phase = q.name, "parse"
+		results = append(results, r)
+
+		r, err = measure(opts.count, func() error {
+			_, err := opts.compile.Compile(q.source)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", q.name, err)
+		}
+		r.Name, r.Phase = q.name, "compile"
+		results = append(results, r)
+
+		if opts.execCount == 0 {
+			continue
+		}
+		r, err = measure(opts.execCount, func() error {
+			var rows pqleval.RowIterator
+			if opts.dsn != "" {
+				sql, err := opts.compile.Compile(q.source)
+				if err != nil {
+					return err
+				}
+				rows, err = queryClickHouse(ctx, http.DefaultClient, opts.dsn, sql)
+				if err != nil {
+					return err
+				}
+			} else {
+				prepared, err := pqleval.Prepare(q.source)
+				if err != nil {
+					return err
+				}
+				rows, err = prepared.Run(ctx, env)
+				if err != nil {
+					return err
+				}
+			}
+			err := drainRows(ctx, rows)
+			if err2 := rows.Close(); err == nil {
+				err = err2
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", q.name, err)
+		}
+		r.Name, r.Phase = q.name, "exec"
+		results = append(results, r)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(output)
+		for _, r := range results {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(output, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPHASE\tN\tMIN\tP50\tP90\tP99\tMAX\tALLOCS/OP\tB/OP")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%v\t%v\t%v\t%v\t%v\t%d\t%d\n",
+			r.Name, r.Phase, r.N, r.Min, r.P50, r.P90, r.P99, r.Max, r.AllocsPerOp, r.BytesPerOp)
+	}
+	return tw.Flush()
+}
+
+// measure calls f n times and returns the distribution of its latency
+// and the average number of allocations per call.
+func measure(n int, f func() error) (*benchResult, error) {
+	durations := make([]time.Duration, n)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := range durations {
+		start := time.Now()
+		if err := f(); err != nil {
+			return nil, err
+		}
+		durations[i] = time.Since(start)
+	}
+	runtime.ReadMemStats(&after)
+
+	slices.Sort(durations)
+	return &benchResult{
+		N:           n,
+		Min:         durations[0],
+		P50:         percentile(durations, 50),
+		P90:         percentile(durations, 90),
+		P99:         percentile(durations, 99),
+		Max:         durations[n-1],
+		AllocsPerOp: (after.Mallocs - before.Mallocs) / uint64(n),
+		BytesPerOp:  (after.TotalAlloc - before.TotalAlloc) / uint64(n),
+	}, nil
+}
+
+// percentile returns the p-th percentile of sorted
+// using the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	return sorted[max(i-1, 0)]
+}
+
+// drainRows reads every row from rows.
+func drainRows(ctx context.Context, rows pqleval.RowIterator) error {
+	for {
+		_, err := rows.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/internal/document"
+	"github.com/runreveal/pql/parser"
+)
+
+// runFiles compiles the statements in each of the named files
+// (or standard input for "-" or no files)
+// and writes the SQL to sqlOutput
+// and the problems it finds to diagOutput in the given format ("text" or "json").
+// If sqlOutput is nil, runFiles only checks the statements.
+// It returns [errParse] if any file has a syntax error
+// or [errHasProblems] if any file has another error-level problem.
+func runFiles(sqlOutput, diagOutput io.Writer, paths []string, opts *pql.CompileOptions, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	failed, parseFailed := false, false
+	for _, path := range paths {
+		name := path
+		var source []byte
+		var err error
+		if path == "-" {
+			name = "<stdin>"
+			source, err = io.ReadAll(os.Stdin)
+		} else {
+			source, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		var write func(sql string)
+		if sqlOutput != nil {
+			write = func(sql string) {
+				fmt.Fprintf(sqlOutput, "%s\n\n", sql)
+			}
+		}
+		for _, diag := range document.Compile(context.Background(), opts, string(source), write) {
+			if diag.Severity == parser.SeverityError {
+				failed = true
+				parseFailed = parseFailed || isParseCode(diag.Code)
+			}
+			if format == "json" {
+				err = writeJSONDiagnostic(diagOutput, name, string(source), diag)
+			} else {
+				err = writeTextDiagnostic(diagOutput, name, string(source), diag)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if parseFailed {
+		return errParse
+	}
+	if failed {
+		return errHasProblems
+	}
+	return nil
+}
+
+// errHasProblems is returned by runFiles
+// when it finds an error-level problem other than a syntax error.
+var errHasProblems = errors.New("one or more statements have problems")
+
+func writeTextDiagnostic(w io.Writer, name, source string, diag parser.Diagnostic) error {
+	var err error
+	if diag.Span.IsValid() {
+		_, err = fmt.Fprintf(w, "%s:%v: %v: %s\n", name, parser.PositionFor(source, diag.Span.Start), diag.Severity, diag.Message)
+	} else {
+		_, err = fmt.Fprintf(w, "%s: %v: %s\n", name, diag.Severity, diag.Message)
+	}
+	return err
+}
+
+// jsonDiagnostic is the JSON representation of a [parser.Diagnostic].
+// Offsets are byte offsets into the file.
+// Lines and columns are 1-based.
+// The location fields are omitted if the diagnostic
+// does not refer to a specific location,
+// and the file is omitted if it is not known.
+type jsonDiagnostic struct {
+	File      string `json:"file,omitempty"`
+	Start     *int   `json:"start,omitempty"`
+	End       *int   `json:"end,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+	Severity  string `json:"severity"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message"`
+}
+
+// writeJSONDiagnostic writes diag to w as a single line of JSON.
+func writeJSONDiagnostic(w io.Writer, name, source string, diag parser.Diagnostic) error {
+	data, err := json.Marshal(newJSONDiagnostic(name, source, diag))
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+func newJSONDiagnostic(name, source string, diag parser.Diagnostic) *jsonDiagnostic {
+	jd := &jsonDiagnostic{
+		File:     name,
+		Severity: diag.Severity.String(),
+		Code:     diag.Code,
+		Message:  diag.Message,
+	}
+	if diag.Span.IsValid() {
+		jd.Start = &diag.Span.Start
+		jd.End = &diag.Span.End
+		start := parser.PositionFor(source, diag.Span.Start)
+		end := parser.PositionFor(source, diag.Span.End)
+		jd.Line, jd.Column = start.Line, start.Column
+		jd.EndLine, jd.EndColumn = end.Line, end.Column
+	}
+	return jd
+}
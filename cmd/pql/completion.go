@@ -0,0 +1,126 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/parser"
+	"github.com/spf13/cobra"
+)
+
+// schemaEnvVar is the environment variable that names the schema file
+// used to complete queries on the command line
+// when the command does not have a --schema flag.
+const schemaEnvVar = "PQL_SCHEMA"
+
+// completeQuery is a [cobra.Command.ValidArgsFunction]
+// that suggests table and database names for a query argument.
+// Tables come from the command's --schema flag or $PQL_SCHEMA,
+// and from the command's --table flags.
+func completeQuery(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ac := loadCompletionSchema(cmd)
+	if ac == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	directive := cobra.ShellCompDirectiveNoFileComp
+	var suggestions []string
+	for _, c := range ac.SuggestCompletions(toComplete, parser.Span{Start: len(toComplete), End: len(toComplete)}) {
+		text := toComplete[:c.Span.Start] + c.Text
+		switch c.Kind {
+		case pql.CompletionTable:
+		case pql.CompletionDatabase:
+			// Leave the cursor after the dot so that the table can be typed.
+			text += "."
+			directive |= cobra.ShellCompDirectiveNoSpace
+		default:
+			continue
+		}
+		if c.Documentation != "" {
+			text += "\t" + firstLine(c.Documentation)
+		} else if c.Detail != "" {
+			text += "\t" + c.Detail
+		}
+		suggestions = append(suggestions, text)
+	}
+	return suggestions, directive
+}
+
+// loadCompletionSchema returns the tables that can be used
+// in a query argument of cmd, or nil if there are none.
+// Errors are ignored, since there is nowhere to report them
+// while the shell is completing a command.
+func loadCompletionSchema(cmd *cobra.Command) *pql.AnalysisContext {
+	path := os.Getenv(schemaEnvVar)
+	if f := cmd.Flags().Lookup("schema"); f != nil && f.Value.String() != "" {
+		path = f.Value.String()
+	}
+	var ac *pql.AnalysisContext
+	if path != "" {
+		ac, _ = pql.LoadSchemaFile(path)
+	}
+	tableFlags, _ := cmd.Flags().GetStringArray("table")
+	tables, _ := parseTableFlags(tableFlags)
+	if len(tables) == 0 {
+		return ac
+	}
+	if ac == nil {
+		ac = new(pql.AnalysisContext)
+	}
+	if ac.Tables == nil {
+		ac.Tables = make(map[string]*pql.AnalysisTable)
+	}
+	for name := range tables {
+		if ac.Tables[name] == nil {
+			ac.Tables[name] = new(pql.AnalysisTable)
+		}
+	}
+	return ac
+}
+
+// completeTableFlag suggests the tables in the schema
+// as the name half of a --table NAME=FILE flag.
+func completeTableFlag(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if strings.Contains(toComplete, "=") {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	path := os.Getenv(schemaEnvVar)
+	if path == "" {
+		return nil, cobra.ShellCompDirectiveNoSpace
+	}
+	ac, err := pql.LoadSchemaFile(path)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoSpace
+	}
+	var suggestions []string
+	for name := range ac.Tables {
+		if strings.HasPrefix(name, toComplete) {
+			suggestions = append(suggestions, name+"=")
+		}
+	}
+	slices.Sort(suggestions)
+	return suggestions, cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeDialects suggests the values of the --dialect flag.
+func completeDialects(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var names []string
+	for _, d := range []pql.Dialect{pql.ClickHouseDialect, pql.PostgresDialect, pql.DuckDBDialect} {
+		names = append(names, d.String())
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// firstLine returns the text of s up to its first newline.
+func firstLine(s string) string {
+	s, _, _ = strings.Cut(s, "\n")
+	return s
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/parser"
+	"github.com/spf13/cobra"
+)
+
+func newConvertCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "convert [FILE]",
+		Short: "Translate a SQL query into Pipeline Query Language",
+		Long: "Translate a SQL SELECT statement into Pipeline Query Language.\n\n" +
+			"convert supports projections, filters, joins, GROUP BY, HAVING,\n" +
+			"ORDER BY, LIMIT, subqueries in FROM, and WITH clauses.\n" +
+			"Statements that use other features are reported as errors.\n" +
+			"With no file, convert reads standard input.",
+		Args:                  cobra.MaximumNArgs(1),
+		DisableFlagsInUseLine: true,
+	}
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		name := "<stdin>"
+		var source []byte
+		var err error
+		if len(args) == 0 || args[0] == "-" {
+			source, err = io.ReadAll(os.Stdin)
+		} else {
+			name = args[0]
+			source, err = os.ReadFile(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		return runConvert(os.Stdout, os.Stderr, name, string(source))
+	}
+	return c
+}
+
+// runConvert translates the SQL in source into pql and writes it to output.
+// Problems with the SQL are written to diagOutput.
+func runConvert(output, diagOutput io.Writer, name, source string) error {
+	query, err := pql.ConvertSQL(source)
+	if err != nil {
+		diags := parser.Diagnostics(err)
+		if len(diags) == 0 {
+			return err
+		}
+		for _, diag := range diags {
+			if err := writeTextDiagnostic(diagOutput, name, source, diag); err != nil {
+				return err
+			}
+		}
+		return errors.New("convert failed")
+	}
+	_, err = fmt.Fprintln(output, query)
+	return err
+}
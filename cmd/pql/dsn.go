@@ -0,0 +1,167 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/pqleval"
+)
+
+// runDSN compiles query, runs it on the ClickHouse server named by dsn,
+// and writes the result to output in the given format.
+// Rows are written as the server sends them.
+func runDSN(ctx context.Context, output io.Writer, dsn, query, format string) error {
+	if err := checkOutputFormat(format); err != nil {
+		return err
+	}
+	sql, err := pql.Compile(query)
+	if err != nil {
+		return err
+	}
+	rows, err := queryClickHouse(ctx, http.DefaultClient, dsn, sql)
+	if err != nil {
+		return err
+	}
+	err = writeRows(ctx, output, rows, format)
+	if err2 := rows.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// clickHouseRequest returns a request that runs sql
+// over the ClickHouse HTTP interface.
+// dsn is either a URL of the form
+// clickhouse://[user[:password]@]host[:port][/database][?secure=true]
+// or the http:// or https:// URL of the HTTP interface.
+// Other query parameters in dsn are passed to the server as settings.
+func clickHouseRequest(ctx context.Context, dsn, sql string) (*http.Request, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dsn: %v", err)
+	}
+	params := u.Query()
+	switch u.Scheme {
+	case "clickhouse":
+		u.Scheme = "http"
+		port := "8123"
+		if params.Get("secure") == "true" {
+			u.Scheme = "https"
+			port = "8443"
+		}
+		params.Del("secure")
+		if u.Port() == "" {
+			u.Host += ":" + port
+		}
+		if db := strings.Trim(u.Path, "/"); db != "" {
+			params.Set("database", db)
+		}
+		u.Path = "/"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid dsn: unsupported scheme %q (must be clickhouse, http, or https)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid dsn: missing host")
+	}
+	params.Set("default_format", "JSONCompactEachRowWithNames")
+	params.Set("output_format_json_quote_64bit_integers", "0")
+	user := u.User
+	u.User = nil
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(strings.TrimSuffix(sql, ";")))
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		req.Header.Set("X-ClickHouse-User", user.Username())
+		if password, ok := user.Password(); ok {
+			req.Header.Set("X-ClickHouse-Key", password)
+		}
+	}
+	return req, nil
+}
+
+// queryClickHouse runs sql on the ClickHouse server named by dsn
+// and returns an iterator over the result.
+func queryClickHouse(ctx context.Context, client *http.Client, dsn, sql string) (pqleval.RowIterator, error) {
+	req, err := clickHouseRequest(ctx, dsn, sql)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		return nil, fmt.Errorf("clickhouse: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	it := &clickHouseIterator{body: resp.Body, scanner: bufio.NewScanner(resp.Body)}
+	it.scanner.Buffer(nil, 16<<20)
+	if err := it.readLine(&it.cols); err != nil && err != io.EOF {
+		resp.Body.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// clickHouseIterator reads rows in the JSONCompactEachRowWithNames format:
+// a JSON array of column names followed by one JSON array per row.
+type clickHouseIterator struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	cols    []string
+}
+
+func (it *clickHouseIterator) Columns() []string {
+	return it.cols
+}
+
+func (it *clickHouseIterator) Next(ctx context.Context) (pqleval.Row, error) {
+	var row pqleval.Row
+	if err := it.readLine(&row); err != nil {
+		return nil, err
+	}
+	for i, v := range row {
+		row[i] = convertJSON(v)
+	}
+	return row, nil
+}
+
+// readLine decodes the next line of the response into v.
+// ClickHouse reports errors that happen after it has started sending rows
+// as text at the end of the response, so lines that are not JSON
+// are returned as errors.
+func (it *clickHouseIterator) readLine(v any) error {
+	if !it.scanner.Scan() {
+		if err := it.scanner.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	line := it.scanner.Bytes()
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("clickhouse: %s", bytes.TrimSpace(line))
+	}
+	return nil
+}
+
+func (it *clickHouseIterator) Close() error {
+	return it.body.Close()
+}
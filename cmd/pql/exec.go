@@ -0,0 +1,588 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/runreveal/pql/pqlarrow"
+	"github.com/runreveal/pql/pqleval"
+	"github.com/spf13/cobra"
+)
+
+func newExecCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "exec [options] QUERY",
+		Short: "Run a query over local CSV, NDJSON, or Arrow files or a ClickHouse database",
+		Long: "Run a query over local CSV, NDJSON, or Arrow files without a database,\n" +
+			"or on a ClickHouse database with --dsn.\n\n" +
+			"Each --table flag names a file to use as a table.\n" +
+			"Files ending in .csv must have a header row.\n" +
+			"Files ending in .json, .jsonl, or .ndjson must have one JSON object per line.\n" +
+			"Files ending in .arrow or .feather are read as Arrow IPC files\n" +
+			"and files ending in .arrows as Arrow IPC streams.\n\n" +
+			"--dsn is a URL of the form\n" +
+			"clickhouse://[user[:password]@]host[:port][/database][?secure=true]\n" +
+			"or the http:// or https:// URL of a ClickHouse HTTP interface.",
+		Args:                  cobra.ExactArgs(1),
+		ValidArgsFunction:     completeQuery,
+		DisableFlagsInUseLine: true,
+	}
+	tableFlags := c.Flags().StringArray("table", nil, "`NAME=FILE` to load as a table (may be repeated)")
+	dsn := c.Flags().String("dsn", "", "`URL` of a ClickHouse database to run the query on")
+	outputPath := c.Flags().StringP("output", "o", "", "file to write results to (defaults to stdout)")
+	format := c.Flags().String("format", "csv", "output format: csv, ndjson, table, or arrow (an Arrow IPC stream)")
+	c.RegisterFlagCompletionFunc("table", completeTableFlag)
+	c.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		if *dsn != "" && len(*tableFlags) > 0 {
+			return fmt.Errorf("cannot use --table with --dsn")
+		}
+		tables, err := parseTableFlags(*tableFlags)
+		if err != nil {
+			return err
+		}
+		output, err := makeOutput(*outputPath)
+		if err != nil {
+			return err
+		}
+		if *dsn != "" {
+			err = runDSN(cmd.Context(), output, *dsn, args[0], *format)
+		} else {
+			err = runExec(cmd.Context(), output, args[0], tables, *format)
+		}
+		if err2 := output.Close(); err == nil {
+			err = err2
+		}
+		return err
+	}
+	return c
+}
+
+// runExec evaluates query over the files in tables,
+// which maps table names to paths,
+// and writes the result to output in the given format.
+// Files are read as the query needs their rows,
+// so only operators that buffer their input, like sort and summarize,
+// hold a whole file in memory.
+func runExec(ctx context.Context, output io.Writer, query string, tables map[string]string, format string) error {
+	if err := checkOutputFormat(format); err != nil {
+		return err
+	}
+	env, err := newFileEnv(tables)
+	if err != nil {
+		return err
+	}
+	q, err := pqleval.Prepare(query)
+	if err != nil {
+		return err
+	}
+	rows, err := q.Run(ctx, env)
+	if err != nil {
+		return err
+	}
+	err = writeRows(ctx, output, rows, format)
+	if err2 := rows.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// parseTableFlags parses --table arguments of the form NAME=FILE
+// into a map of table names to paths.
+func parseTableFlags(args []string) (map[string]string, error) {
+	tables := make(map[string]string, len(args))
+	for _, arg := range args {
+		name, path, ok := strings.Cut(arg, "=")
+		if !ok || name == "" || path == "" {
+			return nil, fmt.Errorf("invalid --table %q (must be NAME=FILE)", arg)
+		}
+		tables[name] = path
+	}
+	return tables, nil
+}
+
+// newFileEnv returns an environment whose sources read the files in tables,
+// which maps table names to paths.
+func newFileEnv(tables map[string]string) (*pqleval.Env, error) {
+	env := &pqleval.Env{Sources: make(map[string]pqleval.Source, len(tables))}
+	for name, path := range tables {
+		src, err := newFileSource(path)
+		if err != nil {
+			return nil, err
+		}
+		env.Sources[name] = src
+	}
+	return env, nil
+}
+
+func checkOutputFormat(format string) error {
+	if format != "csv" && format != "ndjson" && format != "table" && format != "arrow" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return nil
+}
+
+// writeRows writes rows to w in the given format.
+func writeRows(ctx context.Context, w io.Writer, rows pqleval.RowIterator, format string) error {
+	switch format {
+	case "ndjson":
+		return writeNDJSON(ctx, w, rows)
+	case "table":
+		return writeTable(ctx, w, rows)
+	case "arrow":
+		return writeArrow(ctx, w, rows)
+	default:
+		return writeCSV(ctx, w, rows)
+	}
+}
+
+// fileSource is a [pqleval.Source] that reads a file
+// according to its extension.
+type fileSource struct {
+	path string
+	open func(f *os.File) (pqleval.RowIterator, error)
+}
+
+func newFileSource(path string) (*fileSource, error) {
+	src := &fileSource{path: path}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		src.open = openCSV
+	case ".json", ".jsonl", ".ndjson":
+		src.open = openNDJSON
+	case ".arrow", ".feather":
+		src.open = openArrowFile
+	case ".arrows":
+		src.open = openArrowStream
+	default:
+		return nil, fmt.Errorf("%s: unknown file type %q", path, ext)
+	}
+	return src, nil
+}
+
+func (src *fileSource) Open(ctx context.Context) (pqleval.RowIterator, error) {
+	f, err := os.Open(src.path)
+	if err != nil {
+		return nil, err
+	}
+	it, err := src.open(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", src.path, err)
+	}
+	return &fileIterator{path: src.path, RowIterator: it}, nil
+}
+
+// fileIterator adds the file's path to the errors of a RowIterator.
+type fileIterator struct {
+	path string
+	pqleval.RowIterator
+}
+
+func (it *fileIterator) Next(ctx context.Context) (pqleval.Row, error) {
+	row, err := it.RowIterator.Next(ctx)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %v", it.path, err)
+	}
+	return row, err
+}
+
+// csvIterator reads a CSV file with a header row.
+// Fields that look like integers, floating point numbers, or booleans
+// are converted to those types and empty fields are null.
+type csvIterator struct {
+	f    *os.File
+	r    *csv.Reader
+	cols []string
+}
+
+func openCSV(f *os.File) (pqleval.RowIterator, error) {
+	it := &csvIterator{f: f, r: csv.NewReader(f)}
+	header, err := it.r.Read()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	it.cols = header
+	return it, nil
+}
+
+func (it *csvIterator) Columns() []string {
+	return it.cols
+}
+
+func (it *csvIterator) Next(ctx context.Context) (pqleval.Row, error) {
+	if it.cols == nil {
+		return nil, io.EOF
+	}
+	record, err := it.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make(pqleval.Row, len(record))
+	for i, field := range record {
+		row[i] = parseCSVField(field)
+	}
+	return row, nil
+}
+
+func (it *csvIterator) Close() error {
+	return it.f.Close()
+}
+
+func parseCSVField(s string) any {
+	if s == "" {
+		return nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if s == "true" || s == "false" {
+		return s == "true"
+	}
+	return s
+}
+
+// ndjsonIterator reads a file with one JSON object per line.
+// The columns are the union of the objects' keys, sorted by name.
+type ndjsonIterator struct {
+	f       *os.File
+	scanner *bufio.Scanner
+	lineno  int
+	cols    []string
+}
+
+func openNDJSON(f *os.File) (pqleval.RowIterator, error) {
+	// Read the file once to find the columns
+	// without holding its rows in memory.
+	it := &ndjsonIterator{f: f}
+	it.reset()
+	keys := make(map[string]struct{})
+	for {
+		row, err := it.readObject()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for k := range row {
+			keys[k] = struct{}{}
+		}
+	}
+	for k := range keys {
+		it.cols = append(it.cols, k)
+	}
+	slices.Sort(it.cols)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	it.reset()
+	return it, nil
+}
+
+func (it *ndjsonIterator) reset() {
+	it.scanner = bufio.NewScanner(it.f)
+	it.scanner.Buffer(nil, 16<<20)
+	it.lineno = 0
+}
+
+// readObject returns the object on the next non-blank line.
+func (it *ndjsonIterator) readObject() (map[string]any, error) {
+	for it.scanner.Scan() {
+		it.lineno++
+		line := bytes.TrimSpace(it.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		var row map[string]any
+		if err := dec.Decode(&row); err != nil {
+			return nil, fmt.Errorf("line %d: %v", it.lineno, err)
+		}
+		return row, nil
+	}
+	if err := it.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (it *ndjsonIterator) Columns() []string {
+	return it.cols
+}
+
+func (it *ndjsonIterator) Next(ctx context.Context) (pqleval.Row, error) {
+	obj, err := it.readObject()
+	if err != nil {
+		return nil, err
+	}
+	row := make(pqleval.Row, len(it.cols))
+	for i, col := range it.cols {
+		row[i] = convertJSON(obj[col])
+	}
+	return row, nil
+}
+
+func (it *ndjsonIterator) Close() error {
+	return it.f.Close()
+}
+
+// convertJSON replaces the [json.Number] values in v
+// with integers or floating point numbers.
+func convertJSON(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, elem := range v {
+			v[k] = convertJSON(elem)
+		}
+		return v
+	case []any:
+		for i, elem := range v {
+			v[i] = convertJSON(elem)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// openArrowFile reads the record batches of an Arrow IPC file.
+func openArrowFile(f *os.File) (pqleval.RowIterator, error) {
+	r, err := ipc.NewFileReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return pqlarrow.NewRowIterator(&arrowFileReader{f: f, r: r}), nil
+}
+
+// openArrowStream reads the record batches of an Arrow IPC stream.
+func openArrowStream(f *os.File) (pqleval.RowIterator, error) {
+	r, err := ipc.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return pqlarrow.NewRowIterator(&arrowStreamReader{Reader: r, f: f}), nil
+}
+
+// arrowStreamReader is an Arrow IPC stream reader
+// that closes its file when it is released.
+type arrowStreamReader struct {
+	*ipc.Reader
+	f *os.File
+}
+
+func (r *arrowStreamReader) Release() {
+	r.Reader.Release()
+	r.f.Close()
+}
+
+// arrowFileReader reads the record batches of an Arrow IPC file in order
+// and closes the file when it is released.
+type arrowFileReader struct {
+	f   *os.File
+	r   *ipc.FileReader
+	rec arrow.Record
+	err error
+}
+
+func (r *arrowFileReader) Retain() {}
+
+func (r *arrowFileReader) Release() {
+	r.r.Close()
+	r.f.Close()
+}
+
+func (r *arrowFileReader) Schema() *arrow.Schema {
+	return r.r.Schema()
+}
+
+func (r *arrowFileReader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	r.rec, r.err = r.r.Read()
+	return r.err == nil
+}
+
+func (r *arrowFileReader) Record() arrow.Record {
+	return r.rec
+}
+
+func (r *arrowFileReader) Err() error {
+	if r.err == io.EOF {
+		return nil
+	}
+	return r.err
+}
+
+func writeCSV(ctx context.Context, w io.Writer, rows pqleval.RowIterator) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(rows.Columns()); err != nil {
+		return err
+	}
+	record := make([]string, len(rows.Columns()))
+	for {
+		row, err := rows.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for j, v := range row {
+			s, err := formatValue(v)
+			if err != nil {
+				return err
+			}
+			record[j] = s
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTable writes rows to w as columns aligned with spaces.
+// Since the column widths depend on every row,
+// the output is written after the last row is read.
+func writeTable(ctx context.Context, w io.Writer, rows pqleval.RowIterator) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	record := make([]string, len(rows.Columns()))
+	for j, col := range rows.Columns() {
+		record[j] = tableCell(col)
+	}
+	fmt.Fprintln(tw, strings.Join(record, "\t"))
+	for {
+		row, err := rows.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for j, v := range row {
+			s, err := formatValue(v)
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				s = "null"
+			}
+			record[j] = tableCell(s)
+		}
+		fmt.Fprintln(tw, strings.Join(record, "\t"))
+	}
+	return tw.Flush()
+}
+
+// tableCell replaces the characters in s that would break a table's layout.
+func tableCell(s string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+func formatValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case map[string]any, []any:
+		data, err := json.Marshal(v)
+		return string(data), err
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// writeArrow writes rows to w as an Arrow IPC stream.
+// The column types are chosen from the first batch of rows.
+func writeArrow(ctx context.Context, w io.Writer, rows pqleval.RowIterator) error {
+	r, err := pqlarrow.NewRecordReader(ctx, memory.DefaultAllocator, rows, 0)
+	if err != nil {
+		return err
+	}
+	defer r.Release()
+	iw := ipc.NewWriter(w, ipc.WithSchema(r.Schema()))
+	for r.Next() {
+		if err := iw.Write(r.Record()); err != nil {
+			iw.Close()
+			return err
+		}
+	}
+	if err := r.Err(); err != nil {
+		iw.Close()
+		return err
+	}
+	return iw.Close()
+}
+
+func writeNDJSON(ctx context.Context, w io.Writer, rows pqleval.RowIterator) error {
+	cols := rows.Columns()
+	names := make([][]byte, len(cols))
+	for j, col := range cols {
+		name, err := json.Marshal(col)
+		if err != nil {
+			return err
+		}
+		names[j] = name
+	}
+	buf := new(bytes.Buffer)
+	for {
+		row, err := rows.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		buf.Reset()
+		buf.WriteString("{")
+		for j, v := range row {
+			if j > 0 {
+				buf.WriteString(",")
+			}
+			value, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", cols[j], err)
+			}
+			buf.Write(names[j])
+			buf.WriteString(":")
+			buf.Write(value)
+		}
+		buf.WriteString("}\n")
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+}
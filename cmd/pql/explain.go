@@ -0,0 +1,136 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/parser"
+	"github.com/spf13/cobra"
+)
+
+func newExplainCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "explain [options] [QUERY]",
+		Short: "Show how a query is translated into SQL",
+		Long: "Show how a Pipeline Query Language query is divided into SQL statements.\n\n" +
+			"For each common table expression and the final query,\n" +
+			"explain prints the pql operators it was compiled from,\n" +
+			"the operators that became its ORDER BY and LIMIT clauses,\n" +
+			"and its SQL.\n" +
+			"With --dsn, explain also prints the server's EXPLAIN output for the query.\n" +
+			"With no query, explain reads standard input.",
+		Args:                  cobra.MaximumNArgs(1),
+		ValidArgsFunction:     completeQuery,
+		DisableFlagsInUseLine: true,
+	}
+	dsn := c.Flags().String("dsn", "", "ClickHouse `URL` to run EXPLAIN on")
+	dialectName := c.Flags().String("dialect", "clickhouse", "SQL dialect to write: clickhouse, postgres, or duckdb")
+	c.RegisterFlagCompletionFunc("dialect", completeDialects)
+	c.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		opts := new(pql.CompileOptions)
+		opts.Dialect, err = parseDialect(*dialectName)
+		if err != nil {
+			return err
+		}
+		if *dsn != "" && opts.Dialect != pql.ClickHouseDialect {
+			return errors.New("--dsn requires the clickhouse dialect")
+		}
+		var query string
+		if len(args) == 0 || args[0] == "-" {
+			source, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			query = string(source)
+		} else {
+			query = args[0]
+		}
+		return runExplain(cmd.Context(), os.Stdout, query, opts, *dsn)
+	}
+	return c
+}
+
+// runExplain writes the stages of query's SQL to output.
+// If dsn is not empty, runExplain also writes the result of
+// running EXPLAIN on the ClickHouse server named by dsn.
+func runExplain(ctx context.Context, output io.Writer, query string, opts *pql.CompileOptions, dsn string) error {
+	plan, err := opts.Explain(query)
+	if err != nil {
+		return err
+	}
+	buf := new(strings.Builder)
+	for i, stage := range plan.Stages {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		if stage.Name == "" {
+			buf.WriteString("final query\n")
+		} else {
+			buf.WriteString(stage.Name + "\n")
+		}
+		for j, span := range stage.Operators {
+			label := "  from: "
+			if j > 0 {
+				label = "        "
+			}
+			buf.WriteString(label + operatorText(query, span) + "\n")
+		}
+		if stage.Sort.IsValid() {
+			buf.WriteString("  sort: " + operatorText(query, stage.Sort) + "\n")
+		}
+		if stage.Take.IsValid() {
+			buf.WriteString("  take: " + operatorText(query, stage.Take) + "\n")
+		}
+		buf.WriteString("  sql:\n")
+		writeIndented(buf, stage.SQL, "    ")
+	}
+	if _, err := io.WriteString(output, buf.String()); err != nil {
+		return err
+	}
+	if dsn == "" {
+		return nil
+	}
+
+	rows, err := queryClickHouse(ctx, http.DefaultClient, dsn, "EXPLAIN "+strings.TrimSuffix(plan.SQL, ";"))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	buf.Reset()
+	buf.WriteString("\nEXPLAIN\n")
+	for {
+		row, err := rows.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) > 0 {
+			buf.WriteString("  " + fmt.Sprint(row[0]) + "\n")
+		}
+	}
+	_, err = io.WriteString(output, buf.String())
+	return err
+}
+
+// operatorText returns the source of the operator at span on a single line.
+func operatorText(source string, span parser.Span) string {
+	return strings.Join(strings.Fields(source[span.Start:span.End]), " ")
+}
+
+// writeIndented writes each line of s to buf with the given prefix.
+func writeIndented(buf *strings.Builder, s, prefix string) {
+	for _, line := range strings.Split(s, "\n") {
+		buf.WriteString(prefix + line + "\n")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/runreveal/pql/internal/document"
+	"github.com/spf13/cobra"
+)
+
+func newFmtCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "fmt [options] [FILE [...]]",
+		Short: "Reformat Pipeline Query Language files",
+		Long: "Reformat Pipeline Query Language files in the canonical style.\n\n" +
+			"Comments before a statement are kept.\n" +
+			"Statements that contain comments are left unchanged.\n" +
+			"With no files, fmt reads standard input.",
+		Args:                  cobra.ArbitraryArgs,
+		DisableFlagsInUseLine: true,
+	}
+	write := c.Flags().BoolP("write", "w", false, "write result to the source file instead of stdout")
+	diff := c.Flags().BoolP("diff", "d", false, "display diffs instead of rewriting files")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			if *write {
+				return fmt.Errorf("cannot use -w with standard input")
+			}
+			return runFmt(os.Stdout, "<stdin>", os.Stdin, false, *diff)
+		}
+		for _, path := range args {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			err = runFmt(os.Stdout, path, f, *write, *diff)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return c
+}
+
+// runFmt formats the source read from input.
+// If diff is true, runFmt writes a diff of the changes to output.
+// Otherwise, if write is true, runFmt replaces the file at path.
+// Otherwise, runFmt writes the formatted source to output.
+func runFmt(output io.Writer, path string, input io.Reader, write, diff bool) error {
+	source, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	formatted, err := document.Format(string(source))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	switch {
+	case diff:
+		if formatted == string(source) {
+			return nil
+		}
+		d, err := diffText(path, source, []byte(formatted))
+		if err != nil {
+			return err
+		}
+		_, err = output.Write(d)
+		return err
+	case write:
+		if formatted == string(source) {
+			return nil
+		}
+		return os.WriteFile(path, []byte(formatted), 0o666)
+	default:
+		_, err = io.WriteString(output, formatted)
+		return err
+	}
+}
+
+// diffText returns a unified diff of the original and formatted contents
+// of the file at path.
+func diffText(path string, original, formatted []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "pql-fmt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "orig")
+	f2 := filepath.Join(dir, "new")
+	if err := os.WriteFile(f1, original, 0o666); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(f2, formatted, 0o666); err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("diff", "-u", "--label", path+".orig", "--label", path, f1, f2).Output()
+	if len(out) > 0 {
+		// diff exits with a non-zero status when the files differ.
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("computing diff: %v", err)
+	}
+	return nil, nil
+}
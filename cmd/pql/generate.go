@@ -0,0 +1,538 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/parser"
+	"github.com/spf13/cobra"
+)
+
+func newGenerateCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "generate --schema FILE [options] FILE [...]",
+		Short: "Generate Go code for queries",
+		Long: "Generate Go functions that run the queries in annotated .pql files.\n\n" +
+			"Each query begins with a \"// name: Name\" comment\n" +
+			"and continues until the next one.\n" +
+			"The comments that follow the name describe the query's parameters\n" +
+			"as \"// param: name Type\", where Type is a database type like Int64,\n" +
+			"and any other comments become the documentation of the generated function:\n\n" +
+			"  // name: RecentLogins\n" +
+			"  // Returns the logins of a user since a time.\n" +
+			"  // param: user String\n" +
+			"  // param: since DateTime64(9)\n" +
+			"  Logins | where User == user and Timestamp > since | project Timestamp, Address\n\n" +
+			"For each query, generate writes a constant with its SQL,\n" +
+			"a struct for its parameters, a struct for its result rows,\n" +
+			"and a function that runs it with database/sql.\n" +
+			"The result columns and their types are inferred from the schema,\n" +
+			"so every table that a query reads must be in the schema.",
+		Args:                  cobra.MinimumNArgs(1),
+		DisableFlagsInUseLine: true,
+	}
+	schemaPath := c.Flags().String("schema", "", "schema `file` describing the available tables")
+	outputPath := c.Flags().StringP("output", "o", "", "Go `file` to write (defaults to stdout)")
+	packageName := c.Flags().String("package", "", "Go package `name` (defaults to the output file's directory name)")
+	dialectName := c.Flags().String("dialect", "clickhouse", "SQL dialect to write: clickhouse, postgres, or duckdb")
+	c.RegisterFlagCompletionFunc("dialect", completeDialects)
+	c.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		if *schemaPath == "" {
+			return errors.New("--schema is required")
+		}
+		opts := new(pql.CompileOptions)
+		opts.Dialect, err = parseDialect(*dialectName)
+		if err != nil {
+			return err
+		}
+		opts.AnalysisContext, err = pql.LoadSchemaFile(*schemaPath)
+		if err != nil {
+			return err
+		}
+		pkg := *packageName
+		if pkg == "" {
+			if *outputPath == "" {
+				return errors.New("--package is required when writing to stdout")
+			}
+			abs, err := filepath.Abs(*outputPath)
+			if err != nil {
+				return err
+			}
+			pkg = filepath.Base(filepath.Dir(abs))
+		}
+		if !token.IsIdentifier(pkg) {
+			return fmt.Errorf("invalid package name %q", pkg)
+		}
+
+		files := make([]*generateFile, 0, len(args))
+		for _, name := range args {
+			source, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			files = append(files, &generateFile{name: name, source: string(source)})
+		}
+		code, err := runGenerate(cmd.Context(), os.Stderr, pkg, files, opts)
+		if err != nil {
+			return err
+		}
+		if *outputPath == "" {
+			_, err = os.Stdout.Write(code)
+			return err
+		}
+		return os.WriteFile(*outputPath, code, 0o666)
+	}
+	return c
+}
+
+// generateFile is an annotated .pql file given to pql generate.
+type generateFile struct {
+	name   string
+	source string
+}
+
+// generateQuery is a query in an annotated .pql file.
+type generateQuery struct {
+	file *generateFile
+	// start is the offset in the file's source where the query begins.
+	start  int
+	source string
+
+	name   string
+	doc    []string
+	params []*generateParam
+}
+
+// generateParam is a parameter of a query.
+type generateParam struct {
+	name string
+	// line is the 1-based line number of the parameter's annotation.
+	line   int
+	dbType string
+}
+
+// runGenerate returns the Go source for the queries in files.
+// Problems with the queries are written to diagOutput.
+func runGenerate(ctx context.Context, diagOutput io.Writer, pkg string, files []*generateFile, opts *pql.CompileOptions) ([]byte, error) {
+	var queries []*generateQuery
+	names := make(map[string]*generateQuery)
+	for _, f := range files {
+		fileQueries, err := parseGenerateFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range fileQueries {
+			if prev := names[q.name]; prev != nil {
+				return nil, fmt.Errorf("%s:%v: query %s is already defined at %s:%v",
+					f.name, parser.PositionFor(f.source, q.start), q.name,
+					prev.file.name, parser.PositionFor(prev.file.source, prev.start))
+			}
+			names[q.name] = q
+			queries = append(queries, q)
+		}
+	}
+
+	g := &generator{opts: opts}
+	failed := false
+	for _, q := range queries {
+		diags, err := g.query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		if len(diags) > 0 {
+			for _, diag := range diags {
+				if diag.Span.IsValid() {
+					diag.Span.Start += q.start
+					diag.Span.End += q.start
+				}
+				if err := writeTextDiagnostic(diagOutput, q.file.name, q.file.source, diag); err != nil {
+					return nil, err
+				}
+			}
+			failed = true
+		}
+	}
+	if failed {
+		return nil, errValidate
+	}
+
+	header := new(strings.Builder)
+	header.WriteString("// Code generated by pql generate. DO NOT EDIT.\n")
+	for _, f := range files {
+		fmt.Fprintf(header, "// source: %s\n", filepath.ToSlash(f.name))
+	}
+	fmt.Fprintf(header, "\npackage %s\n\nimport (\n\t\"context\"\n\t\"database/sql\"\n", pkg)
+	if g.usesTime {
+		header.WriteString("\t\"time\"\n")
+	}
+	header.WriteString(")\n\n" +
+		"// Querier runs queries.\n" +
+		"// It is implemented by [*sql.DB], [*sql.Conn], and [*sql.Tx].\n" +
+		"type Querier interface {\n" +
+		"\tQueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)\n" +
+		"}\n")
+	code, err := format.Source([]byte(header.String() + g.buf.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v", err)
+	}
+	return code, nil
+}
+
+var (
+	nameAnnotation  = regexp.MustCompile(`^//\s*name:\s*(\S*)\s*$`)
+	paramAnnotation = regexp.MustCompile(`^//\s*param:\s*(.*)$`)
+)
+
+// parseGenerateFile splits an annotated .pql file into its queries.
+func parseGenerateFile(f *generateFile) ([]*generateQuery, error) {
+	var queries []*generateQuery
+	var q *generateQuery
+	inHeader := false
+	lineStart := 0
+	for lineno := 1; lineStart < len(f.source); lineno++ {
+		lineEnd := len(f.source)
+		if i := strings.IndexByte(f.source[lineStart:], '\n'); i >= 0 {
+			lineEnd = lineStart + i + 1
+		}
+		line := strings.TrimSpace(f.source[lineStart:lineEnd])
+
+		if m := nameAnnotation.FindStringSubmatch(line); m != nil {
+			if q != nil {
+				q.source = f.source[q.start:lineStart]
+			}
+			name := m[1]
+			if !token.IsIdentifier(name) || !token.IsExported(name) {
+				return nil, fmt.Errorf("%s:%d: query name %q is not an exported Go identifier", f.name, lineno, name)
+			}
+			q = &generateQuery{file: f, start: lineStart, name: name}
+			queries = append(queries, q)
+			inHeader = true
+		} else if inHeader && strings.HasPrefix(line, "//") {
+			if m := paramAnnotation.FindStringSubmatch(line); m != nil {
+				name, dbType, _ := strings.Cut(strings.TrimSpace(m[1]), " ")
+				dbType = strings.TrimSpace(dbType)
+				if !token.IsIdentifier(name) {
+					return nil, fmt.Errorf("%s:%d: invalid parameter name %q", f.name, lineno, name)
+				}
+				for _, p := range q.params {
+					if p.name == name {
+						return nil, fmt.Errorf("%s:%d: parameter %s is already declared on line %d", f.name, lineno, name, p.line)
+					}
+				}
+				q.params = append(q.params, &generateParam{name: name, line: lineno, dbType: dbType})
+			} else {
+				q.doc = append(q.doc, strings.TrimSpace(strings.TrimPrefix(line, "//")))
+			}
+		} else if line != "" {
+			inHeader = false
+			if q == nil && !strings.HasPrefix(line, "//") {
+				return nil, fmt.Errorf("%s:%d: query is not preceded by a // name: comment", f.name, lineno)
+			}
+		}
+		lineStart = lineEnd
+	}
+	if q != nil {
+		q.source = f.source[q.start:]
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%s: no queries with // name: comments", f.name)
+	}
+	return queries, nil
+}
+
+// generator accumulates the Go declarations for queries.
+type generator struct {
+	opts     *pql.CompileOptions
+	buf      strings.Builder
+	usesTime bool
+}
+
+// paramMarker matches the SQL that stands in for parameters
+// while a query is compiled.
+var paramMarker = regexp.MustCompile(`__pql_generate_param_([0-9]+)__`)
+
+// query writes the declarations for a single query.
+// It returns the problems that prevent the query from being compiled
+// or its result columns from being determined.
+func (g *generator) query(ctx context.Context, q *generateQuery) ([]parser.Diagnostic, error) {
+	opts := *g.opts
+	opts.Parameters = make(map[string]string, len(q.params))
+	for i, p := range q.params {
+		opts.Parameters[p.name] = fmt.Sprintf("__pql_generate_param_%d__", i)
+	}
+	ac := *opts.AnalysisContext
+	ac.Parameters = opts.Parameters
+	opts.AnalysisContext = &ac
+	sql, err := opts.CompileContext(ctx, q.source)
+	if err != nil {
+		return parser.Diagnostics(err), nil
+	}
+	checkDiags, err := ac.Check(ctx, q.source)
+	if err != nil {
+		return nil, err
+	}
+	var diags []parser.Diagnostic
+	for _, diag := range checkDiags {
+		if diag.Severity == parser.SeverityError {
+			diags = append(diags, diag)
+		}
+	}
+	if len(diags) > 0 {
+		return diags, nil
+	}
+	cols, err := ac.ResultColumns(ctx, q.source)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return []parser.Diagnostic{{
+			Span:     parser.Span{Start: 0, End: len(q.source)},
+			Severity: parser.SeverityError,
+			Message:  fmt.Sprintf("cannot determine the columns of query %s (are its tables in the schema?)", q.name),
+		}}, nil
+	}
+
+	// Replace the parameter markers with placeholders
+	// and record the order of the arguments.
+	var argOrder []int
+	sql = paramMarker.ReplaceAllStringFunc(sql, func(marker string) string {
+		i, _ := strconv.Atoi(paramMarker.FindStringSubmatch(marker)[1])
+		if opts.Dialect == pql.PostgresDialect {
+			return "$" + strconv.Itoa(i+1)
+		}
+		argOrder = append(argOrder, i)
+		return "?"
+	})
+	sql = strings.TrimSuffix(strings.TrimSpace(sql), ";")
+	if opts.Dialect == pql.PostgresDialect {
+		for i := range q.params {
+			argOrder = append(argOrder, i)
+		}
+	}
+
+	paramFields := goFieldNames(len(q.params), func(i int) string { return q.params[i].name })
+	colFields := goFieldNames(len(cols), func(i int) string { return cols[i].Name })
+
+	fmt.Fprintf(&g.buf, "\n// %sSQL is the SQL of the %s query in %s.\n", q.name, q.name, filepath.ToSlash(q.file.name))
+	fmt.Fprintf(&g.buf, "const %sSQL = %s\n", q.name, goStringLiteral(sql))
+
+	if len(q.params) > 0 {
+		fmt.Fprintf(&g.buf, "\n// %sParams holds the parameters of [%s].\n", q.name, q.name)
+		fmt.Fprintf(&g.buf, "type %sParams struct {\n", q.name)
+		for i, p := range q.params {
+			fmt.Fprintf(&g.buf, "\t%s %s\n", paramFields[i], g.goType(p.dbType))
+		}
+		g.buf.WriteString("}\n")
+		fmt.Fprintf(&g.buf, "\n// args returns the arguments for the placeholders in [%sSQL].\n", q.name)
+		fmt.Fprintf(&g.buf, "func (p *%sParams) args() []any {\n\treturn []any{", q.name)
+		for i, arg := range argOrder {
+			if i > 0 {
+				g.buf.WriteString(", ")
+			}
+			g.buf.WriteString("p." + paramFields[arg])
+		}
+		g.buf.WriteString("}\n}\n")
+	}
+
+	fmt.Fprintf(&g.buf, "\n// %sRow is a row returned by [%s].\n", q.name, q.name)
+	fmt.Fprintf(&g.buf, "type %sRow struct {\n", q.name)
+	for i, col := range cols {
+		fmt.Fprintf(&g.buf, "\t%s %s `json:%s`\n", colFields[i], g.goType(col.Type), strconv.Quote(col.Name))
+	}
+	g.buf.WriteString("}\n\n")
+
+	if len(q.doc) > 0 {
+		for _, line := range q.doc {
+			g.buf.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+		}
+	} else {
+		fmt.Fprintf(&g.buf, "// %s runs the %s query.\n", q.name, q.name)
+	}
+	fmt.Fprintf(&g.buf, "func %s(ctx context.Context, db Querier", q.name)
+	args := ""
+	if len(q.params) > 0 {
+		fmt.Fprintf(&g.buf, ", params *%sParams", q.name)
+		args = ", params.args()..."
+	}
+	fmt.Fprintf(&g.buf, ") ([]*%sRow, error) {\n", q.name)
+	fmt.Fprintf(&g.buf, "\trows, err := db.QueryContext(ctx, %sSQL%s)\n", q.name, args)
+	g.buf.WriteString("\tif err != nil {\n\t\treturn nil, err\n\t}\n" +
+		"\tdefer rows.Close()\n")
+	fmt.Fprintf(&g.buf, "\tvar result []*%sRow\n", q.name)
+	g.buf.WriteString("\tfor rows.Next() {\n")
+	fmt.Fprintf(&g.buf, "\t\trow := new(%sRow)\n", q.name)
+	g.buf.WriteString("\t\tif err := rows.Scan(")
+	for i := range cols {
+		if i > 0 {
+			g.buf.WriteString(", ")
+		}
+		g.buf.WriteString("&row." + colFields[i])
+	}
+	g.buf.WriteString("); err != nil {\n\t\t\treturn nil, err\n\t\t}\n" +
+		"\t\tresult = append(result, row)\n" +
+		"\t}\n" +
+		"\treturn result, rows.Err()\n" +
+		"}\n")
+	return nil, nil
+}
+
+// goType returns the Go type that values of the given database type are scanned into.
+// Types that are not known are scanned into any.
+func (g *generator) goType(dbType string) string {
+	dbType = strings.TrimSpace(dbType)
+	if inner, ok := typeArgument(dbType, "Nullable"); ok {
+		t := g.goType(inner)
+		if t == "any" {
+			return t
+		}
+		return "*" + t
+	}
+	if inner, ok := typeArgument(dbType, "LowCardinality"); ok {
+		return g.goType(inner)
+	}
+	if inner, ok := typeArgument(dbType, "Array"); ok {
+		return "[]" + g.goType(inner)
+	}
+	if inner, ok := typeArgument(dbType, "Map"); ok {
+		key, value, ok := splitTypeArguments(inner)
+		if !ok {
+			return "any"
+		}
+		return "map[" + g.goType(key) + "]" + g.goType(value)
+	}
+	if _, ok := typeArgument(dbType, "FixedString"); ok {
+		return "string"
+	}
+
+	switch dbType {
+	case "Int8", "Int16", "Int32", "Int64", "UInt8", "UInt16", "UInt32", "UInt64", "Float32", "Float64":
+		return strings.ToLower(dbType)
+	case "Bool":
+		return "bool"
+	case "String", "UUID":
+		return "string"
+	}
+	lower := strings.ToLower(dbType)
+	switch {
+	case lower == "smallint" || lower == "int2":
+		return "int16"
+	case lower == "integer" || lower == "int" || lower == "int4":
+		return "int32"
+	case lower == "bigint":
+		return "int64"
+	case lower == "real" || lower == "float4":
+		return "float32"
+	case lower == "double" || lower == "double precision" || lower == "float8":
+		return "float64"
+	case lower == "boolean":
+		return "bool"
+	case lower == "text" || lower == "varchar" || strings.HasPrefix(lower, "character varying"):
+		return "string"
+	case strings.HasPrefix(lower, "date") || strings.HasPrefix(lower, "timestamp"):
+		g.usesTime = true
+		return "time.Time"
+	default:
+		return "any"
+	}
+}
+
+// typeArgument returns the argument of a parameterized type name
+// like "Nullable(String)" if the type's name is name.
+func typeArgument(dbType, name string) (string, bool) {
+	rest, ok := strings.CutPrefix(dbType, name+"(")
+	if !ok || !strings.HasSuffix(rest, ")") {
+		return "", false
+	}
+	return rest[:len(rest)-1], true
+}
+
+// splitTypeArguments splits the arguments of a type with two arguments,
+// like "String, Array(Int64)", at the top-level comma.
+func splitTypeArguments(s string) (first, second string, ok bool) {
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// goFieldNames returns unique exported Go identifiers for n names.
+func goFieldNames(n int, name func(i int) string) []string {
+	result := make([]string, n)
+	used := make(map[string]bool, n)
+	for i := range result {
+		base := goIdentifier(name(i))
+		id := base
+		for j := 2; used[id]; j++ {
+			id = base + strconv.Itoa(j)
+		}
+		used[id] = true
+		result[i] = id
+	}
+	return result
+}
+
+// goInitialisms are the words that are written in uppercase in Go identifiers.
+var goInitialisms = map[string]bool{
+	"API": true, "HTTP": true, "ID": true, "IP": true, "JSON": true,
+	"SQL": true, "URL": true, "UUID": true,
+}
+
+// goIdentifier converts a column or parameter name like "user_id"
+// into an exported Go identifier like "UserID".
+func goIdentifier(name string) string {
+	sb := new(strings.Builder)
+	words := strings.FieldsFunc(name, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	for _, word := range words {
+		if upper := strings.ToUpper(word); goInitialisms[upper] {
+			sb.WriteString(upper)
+			continue
+		}
+		first, size := utf8.DecodeRuneInString(word)
+		sb.WriteRune(unicode.ToUpper(first))
+		sb.WriteString(word[size:])
+	}
+	id := sb.String()
+	if id == "" {
+		return "Column"
+	}
+	if !unicode.IsLetter([]rune(id)[0]) {
+		id = "X" + id
+	}
+	return id
+}
+
+// goStringLiteral returns a Go string literal for s,
+// preferring a raw string literal.
+func goStringLiteral(s string) string {
+	if strings.ContainsAny(s, "`\r") || !strconv.CanBackquote(strings.ReplaceAll(s, "\n", "")) {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
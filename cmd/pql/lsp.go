@@ -0,0 +1,47 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/lsp"
+	"github.com/spf13/cobra"
+)
+
+func newLSPCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "lsp [options]",
+		Short: "Run a Language Server Protocol server",
+		Long: "Run a Language Server Protocol server on stdin and stdout\n" +
+			"that provides diagnostics, completion, hover, document symbols,\n" +
+			"formatting, and renaming for Pipeline Query Language documents.\n\n" +
+			"Editors like VS Code and Neovim can start it as a language server\n" +
+			"without installing a separate binary.",
+		Args:                  cobra.NoArgs,
+		DisableFlagsInUseLine: true,
+	}
+	// Language clients commonly pass --stdio to select the transport.
+	// It is the only transport, so the flag has no effect.
+	c.Flags().Bool("stdio", true, "communicate over stdin and stdout")
+	schemaPath := c.Flags().String("schema", "", "schema `file` describing the available tables")
+	dialectName := c.Flags().String("dialect", "clickhouse", "SQL dialect to write: clickhouse, postgres, or duckdb")
+	c.RegisterFlagCompletionFunc("dialect", completeDialects)
+	c.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		opts := new(pql.CompileOptions)
+		opts.Dialect, err = parseDialect(*dialectName)
+		if err != nil {
+			return err
+		}
+		if *schemaPath != "" {
+			opts.AnalysisContext, err = pql.LoadSchemaFile(*schemaPath)
+			if err != nil {
+				return err
+			}
+		}
+		return lsp.NewServer(opts).Serve(cmd.Context(), os.Stdout, os.Stdin)
+	}
+	return c
+}
@@ -4,44 +4,195 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/runreveal/pql"
 	"github.com/runreveal/pql/parser"
 	"github.com/spf13/cobra"
+	"github.com/tailscale/hujson"
 	"golang.org/x/term"
 	"zombiezen.com/go/bass/sigterm"
 )
 
 func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), sigterm.Signals()...)
+	err := newRootCommand().ExecuteContext(ctx)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pql: %v\n", err)
+		os.Exit(exitStatus(err))
+	}
+}
+
+// Exit statuses of the pql command.
+const (
+	// exitFailure is the status for usage errors
+	// and failures that do not fall into another category.
+	exitFailure = 1
+	// exitParseError is the status for a query that could not be parsed.
+	exitParseError = 2
+	// exitValidationError is the status for a query that parsed
+	// but could not be compiled or refers to unknown tables or columns.
+	exitValidationError = 3
+	// exitIOError is the status for a failure to read or write a file
+	// or to communicate with a database.
+	exitIOError = 4
+)
+
+// Errors returned when one or more statements have problems
+// that have already been reported.
+var (
+	errParse    = errors.New("one or more statements could not be parsed")
+	errValidate = errors.New("one or more statements could not be compiled")
+)
+
+// exitStatus returns the status that pql exits with
+// after a command returns err.
+func exitStatus(err error) int {
+	var netErr net.Error
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, errParse):
+		return exitParseError
+	case errors.Is(err, errValidate) || errors.Is(err, errHasProblems):
+		return exitValidationError
+	case errors.As(err, &pathErr) || errors.As(err, &netErr):
+		return exitIOError
+	}
+	if diags := parser.Diagnostics(err); len(diags) > 0 && diags[0].Code != "" {
+		return exitStatus(problemError(err))
+	}
+	return exitFailure
+}
+
+// problemError returns [errParse] if err describes a syntax error
+// or [errValidate] otherwise.
+func problemError(err error) error {
+	for _, diag := range parser.Diagnostics(err) {
+		if isParseCode(diag.Code) {
+			return errParse
+		}
+	}
+	return errValidate
+}
+
+// isParseCode reports whether a diagnostic code is produced by the parser.
+func isParseCode(code string) bool {
+	switch code {
+	case parser.CodeSyntax, parser.CodeInvalidToken, parser.CodeUnknownOperator, parser.CodeLimitExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+func newRootCommand() *cobra.Command {
 	rootCommand := &cobra.Command{
 		Use:   "pql [options] [FILE [...]]",
 		Short: "Translate Pipeline Query Language into SQL",
+		Args:  cobra.ArbitraryArgs,
 
 		DisableFlagsInUseLine: true,
 		SilenceErrors:         true,
 		SilenceUsage:          true,
 	}
-	outputPath := rootCommand.Flags().StringP("output", "o", "", "file to write SQL to (defaults to stdout)")
+	rootCommand.AddCommand(newExecCommand())
+	rootCommand.AddCommand(newFmtCommand())
+	rootCommand.AddCommand(newASTCommand())
+	rootCommand.AddCommand(newTokensCommand())
+	rootCommand.AddCommand(newServeCommand())
+	rootCommand.AddCommand(newExplainCommand())
+	rootCommand.AddCommand(newBenchCommand())
+	rootCommand.AddCommand(newConvertCommand())
+	rootCommand.AddCommand(newLSPCommand())
+	rootCommand.AddCommand(newGenerateCommand())
+	outputPath := rootCommand.Flags().StringP("output", "o", "", "file or directory to write SQL to (defaults to stdout)")
+	suffix := rootCommand.Flags().String("suffix", "", "write the SQL for each input file to a file with the input's name and this `extension`")
+	schemaPath := rootCommand.Flags().String("schema", "", "schema `file` describing the available tables")
+	functionsPath := rootCommand.Flags().String("functions", "", "`file` of Azure Data Explorer .create function commands to make available to queries")
+	dialectName := rootCommand.Flags().String("dialect", "clickhouse", "SQL dialect to write: clickhouse, postgres, or duckdb")
+	check := rootCommand.Flags().Bool("check", false, "report problems in the input without writing SQL")
+	strict := rootCommand.Flags().Bool("strict", false, "fail on constructs that cannot be translated to SQL and on unknown functions")
+	kql := rootCommand.Flags().Bool("kql", false, "with --check or --format json, report constructs that behave differently than in KQL")
+	diagFormat := rootCommand.Flags().String("format", "text", "format of reported problems: text, or json for one JSON object per line on stdout")
+	paramFlags := rootCommand.Flags().StringArray("param", nil, "`NAME=SQL` to substitute for unquoted NAME identifiers (may be repeated)")
+	paramsPath := rootCommand.Flags().String("params-file", "", "JSON `file` with an object of parameter names to SQL")
 	rootCommand.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		opts := &pql.CompileOptions{
+			Strict:                   *strict,
+			DisallowUnknownFunctions: *strict,
+			KQLCompatibility:         *kql,
+		}
+		opts.Dialect, err = parseDialect(*dialectName)
+		if err != nil {
+			return err
+		}
+		opts.Parameters, err = loadParameters(*paramsPath, *paramFlags)
+		if err != nil {
+			return err
+		}
+		if *schemaPath != "" {
+			opts.AnalysisContext, err = pql.LoadSchemaFile(*schemaPath)
+			if err != nil {
+				return err
+			}
+			opts.AnalysisContext.Parameters = opts.Parameters
+		}
+		if *functionsPath != "" {
+			opts.Functions, err = pql.LoadADXFunctionsFile(*functionsPath)
+			if err != nil {
+				return err
+			}
+		}
+		if *check {
+			opts.Strict = true
+			diagOutput := io.Writer(os.Stderr)
+			if *diagFormat == "json" {
+				diagOutput = os.Stdout
+			}
+			return runFiles(nil, diagOutput, args, opts, *diagFormat)
+		}
+		if *suffix != "" || isDirOutput(*outputPath) {
+			return runEachFile(args, *outputPath, *suffix, opts, *diagFormat)
+		}
+		if *diagFormat != "text" {
+			// Reporting positions within each file
+			// requires reading the files one at a time.
+			output, err := makeOutput(*outputPath)
+			if err != nil {
+				return err
+			}
+			err = runFiles(output, os.Stdout, args, opts, *diagFormat)
+			if err2 := output.Close(); err == nil {
+				err = err2
+			}
+			return err
+		}
 		input, err := makeInput(args)
 		if err != nil {
 			return err
 		}
+		if (len(args) == 0 || len(args) == 1 && args[0] == "-") && isTerminal(input) {
+			input = newTerminalInput(os.Stdin, opts.AnalysisContext)
+		}
 		output, err := makeOutput(*outputPath)
 		if err != nil {
 			input.Close()
 			return err
 		}
 
-		err = run(cmd.Context(), output, input, func(err error) {
+		err = run(cmd.Context(), output, input, opts, func(err error) {
 			fmt.Fprintf(os.Stderr, "pql: %v\n", err)
 		})
 		if err2 := output.Close(); err == nil {
@@ -50,73 +201,203 @@ func main() {
 		input.Close()
 		return err
 	}
-
-	ctx, cancel := signal.NotifyContext(context.Background(), sigterm.Signals()...)
-	err := rootCommand.ExecuteContext(ctx)
-	cancel()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "pql: %v\n", err)
-		os.Exit(1)
-	}
+	rootCommand.RegisterFlagCompletionFunc("dialect", completeDialects)
+	return rootCommand
 }
 
-func run(ctx context.Context, output io.Writer, input io.Reader, logError func(error)) error {
-	scanner := bufio.NewScanner(input)
-	sb := new(strings.Builder)
-
+func run(ctx context.Context, output io.Writer, input io.Reader, opts *pql.CompileOptions, logError func(error)) error {
 	if isTerminal(input) {
 		// Nudge for usage if running interactively.
 		fmt.Fprintln(os.Stderr, "Reading from terminal (use semicolons to end statements)...")
 	}
 
 	var finalError error
+	fail := func(err error) {
+		logError(err)
+		if finalError != errParse {
+			finalError = problemError(err)
+		}
+	}
 	letStatements := new(strings.Builder)
-	for scanner.Scan() {
-		sb.Write(scanner.Bytes())
-		sb.WriteByte('\n')
-
-		statements := parser.SplitStatements(sb.String())
-		if len(statements) == 1 {
+	// Statements are compiled as soon as they are read,
+	// so that large files and interactive input are not buffered.
+	statements := parser.NewStatementScanner(input)
+	for statements.Scan() {
+		stmt := statements.Text()
+		t := parser.NewTokenizer(stmt)
+		if !t.Next() {
 			continue
 		}
 
-		for _, stmt := range statements[:len(statements)-1] {
-			// Valid let statements are prepended to an ongoing prelude.
-			tokens := parser.Scan(stmt)
-			if len(tokens) > 0 && tokens[0].Kind == parser.TokenIdentifier && tokens[0].Value == "let" {
-				if _, err := pql.Compile(letStatements.String() + stmt + ";X"); err != nil {
-					logError(err)
-					finalError = errors.New("one or more statements could not be compiled")
-				} else {
-					letStatements.WriteString(stmt)
-					letStatements.WriteString(";\n")
+		// Valid let statements are prepended to an ongoing prelude.
+		if tok := t.Token(); tok.Kind == parser.TokenIdentifier && tok.Value == "let" {
+			if _, err := opts.Compile(letStatements.String() + stmt + ";X"); err != nil {
+				fail(err)
+			} else {
+				if err := checkSchema(ctx, opts, letStatements.String(), stmt); err != nil {
+					fail(err)
 				}
-				continue
+				letStatements.WriteString(stmt)
+				letStatements.WriteString(";\n")
 			}
+			continue
+		}
 
-			sql, err := pql.Compile(letStatements.String() + stmt)
-			if err != nil {
-				logError(err)
-				finalError = errors.New("one or more statements could not be compiled")
-				continue
-			}
-			fmt.Fprintf(output, "%s\n\n", sql)
+		sql, err := opts.Compile(letStatements.String() + stmt)
+		if err == nil {
+			err = checkSchema(ctx, opts, letStatements.String(), stmt)
 		}
+		if err != nil {
+			fail(err)
+			continue
+		}
+		fmt.Fprintf(output, "%s\n\n", sql)
+	}
+	if err := statements.Err(); err != nil {
+		return err
+	}
 
-		sb.Reset()
-		sb.WriteString(statements[len(statements)-1])
+	return finalError
+}
+
+// checkSchema returns an error that describes the references in stmt
+// to tables and columns that are not in opts's AnalysisContext.
+// prelude is the let statements that precede stmt.
+func checkSchema(ctx context.Context, opts *pql.CompileOptions, prelude, stmt string) error {
+	if opts == nil || opts.AnalysisContext == nil {
+		return nil
+	}
+	text := prelude + stmt
+	diags, err := opts.AnalysisContext.Check(ctx, text)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, diag := range diags {
+		if diag.Severity != parser.SeverityError || diag.Span.Start < len(prelude) {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%v: %s", parser.PositionFor(text, diag.Span.Start), diag.Message))
 	}
+	return errors.Join(errs...)
+}
+
+// isDirOutput reports whether the -o argument names a directory:
+// either an existing directory or a path that ends in a separator.
+func isDirOutput(arg string) bool {
+	if arg == "" || arg == "-" {
+		return false
+	}
+	if strings.HasSuffix(arg, "/") || strings.HasSuffix(arg, string(filepath.Separator)) {
+		return true
+	}
+	info, err := os.Stat(arg)
+	return err == nil && info.IsDir()
+}
+
+// runEachFile compiles each of the files in paths
+// and writes its SQL to a separate file.
+// The output file has the input's name with its extension replaced by suffix
+// (".sql" if empty)
+// and is written to dir, or next to the input if dir is empty.
+// The output of a file that has errors is removed.
+func runEachFile(paths []string, dir, suffix string, opts *pql.CompileOptions, format string) error {
+	if len(paths) == 0 || slices.Contains(paths, "-") {
+		return errors.New("writing one output per input requires input files")
+	}
+	if suffix == "" {
+		suffix = ".sql"
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o777); err != nil {
+			return err
+		}
+	}
+	diagOutput := io.Writer(os.Stderr)
+	if format == "json" {
+		diagOutput = os.Stdout
+	}
+	var failure error
+	outputs := make(map[string]string, len(paths))
+	for _, path := range paths {
+		outPath := outputPathFor(path, dir, suffix)
+		if other, ok := outputs[outPath]; ok {
+			return fmt.Errorf("%s and %s would both be written to %s", other, path, outPath)
+		}
+		outputs[outPath] = path
+		if outPath == path {
+			return fmt.Errorf("%s would be overwritten by its output", path)
+		}
 
-	if stmt := sb.String(); len(parser.Scan(stmt)) > 0 {
-		sql, err := pql.Compile(stmt)
+		output, err := os.Create(outPath)
 		if err != nil {
-			logError(err)
-			return errors.New("one or more statements could not be compiled")
+			return err
+		}
+		err = runFiles(output, diagOutput, []string{path}, opts, format)
+		if err2 := output.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			os.Remove(outPath)
+			if err != errHasProblems && err != errParse {
+				return err
+			}
+			if failure != errParse {
+				failure = err
+			}
 		}
-		fmt.Fprintf(output, "%s\n\n", sql)
 	}
+	return failure
+}
 
-	return finalError
+// outputPathFor returns the path of the output file for the input file at path.
+func outputPathFor(path, dir, suffix string) string {
+	name := strings.TrimSuffix(path, filepath.Ext(path)) + suffix
+	if dir == "" {
+		return name
+	}
+	return filepath.Join(dir, filepath.Base(name))
+}
+
+func parseDialect(name string) (pql.Dialect, error) {
+	for _, d := range []pql.Dialect{pql.ClickHouseDialect, pql.PostgresDialect, pql.DuckDBDialect} {
+		if name == d.String() {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown dialect %q (must be clickhouse, postgres, or duckdb)", name)
+}
+
+// loadParameters returns the query parameters
+// from the JSON object in the file at path (if not empty)
+// and the NAME=SQL arguments in args.
+// Arguments take precedence over the file.
+func loadParameters(path string, args []string) (map[string]string, error) {
+	if path == "" && len(args) == 0 {
+		return nil, nil
+	}
+	params := make(map[string]string)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data, err = hujson.Standardize(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %v", path, err)
+		}
+		if err := json.Unmarshal(data, &params); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", path, err)
+		}
+	}
+	for _, arg := range args {
+		name, sql, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid --param %q (must be NAME=SQL)", arg)
+		}
+		params[name] = sql
+	}
+	return params, nil
 }
 
 func makeInput(args []string) (io.ReadCloser, error) {
@@ -158,6 +439,8 @@ func isTerminal(r io.Reader) bool {
 		switch rt := r.(type) {
 		case *os.File:
 			return term.IsTerminal(int(rt.Fd()))
+		case *terminalInput:
+			return true
 		case nopReadCloser:
 			r = rt.Reader
 		default:
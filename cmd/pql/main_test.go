@@ -4,10 +4,26 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/google/go-cmp/cmp"
 	"github.com/runreveal/pql"
 )
 
@@ -17,6 +33,11 @@ func TestRun(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	// The statement after the last semicolon can use earlier let statements.
+	letOutput, err := pql.Compile("let n = 5;\n\nStormEvents | take n")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	tests := []struct {
 		name   string
@@ -48,13 +69,24 @@ func TestRun(t *testing.T) {
 			input: "!",
 			fail:  true,
 		},
+		{
+			name:   "LetWithoutFinalSemicolon",
+			input:  "let n = 5;\nStormEvents | take n",
+			output: letOutput + "\n\n",
+		},
+		{
+			name:   "UnclosedStringDoesNotHideSemicolon",
+			input:  "StormEvents | where State == 'TEXAS\\';\n" + inputStatement + ";\n",
+			output: outputStatement + "\n\n",
+			fail:   true,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			ctx := context.Background()
 			gotOutput := new(strings.Builder)
-			gotError := run(ctx, gotOutput, strings.NewReader(test.input), func(error) {})
+			gotError := run(ctx, gotOutput, strings.NewReader(test.input), nil, func(error) {})
 
 			if got := gotOutput.String(); got != test.output {
 				t.Errorf("output = %q; want %q", got, test.output)
@@ -68,3 +100,792 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadParameters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.json")
+	if err := os.WriteFile(path, []byte(`{"start": "$1", "user": "$2", // trailing comma
+}`), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadParameters(path, []string{"user=$3", "end={end: DateTime}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"start": "$1",
+		"user":  "$3",
+		"end":   "{end: DateTime}",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("loadParameters(...) (-want +got):\n%s", diff)
+	}
+
+	if _, err := loadParameters("", []string{"start"}); err == nil {
+		t.Error("loadParameters accepted an argument without '='")
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	_, parseErr := pql.Compile("T | where")
+	_, compileErr := pql.Compile("T | where now(1)")
+	_, readErr := os.ReadFile(filepath.Join(t.TempDir(), "missing.pql"))
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "Parse", err: parseErr, want: exitParseError},
+		{name: "Compile", err: compileErr, want: exitValidationError},
+		{name: "Reported", err: errHasProblems, want: exitValidationError},
+		{name: "ReportedParse", err: errParse, want: exitParseError},
+		{name: "IO", err: readErr, want: exitIOError},
+		{name: "Other", err: errors.New("bork"), want: exitFailure},
+	}
+	for _, test := range tests {
+		if got := exitStatus(test.err); got != test.want {
+			t.Errorf("%s: exitStatus(%v) = %d; want %d", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+func TestRunStrict(t *testing.T) {
+	const input = "T | where bork(x)"
+	for _, strict := range []bool{false, true} {
+		opts := &pql.CompileOptions{Strict: strict, DisallowUnknownFunctions: strict}
+		err := run(context.Background(), io.Discard, strings.NewReader(input), opts, func(error) {})
+		if strict && err != errValidate {
+			t.Errorf("run(%q) with strict = %v; want %v", input, err, errValidate)
+		}
+		if !strict && err != nil {
+			t.Errorf("run(%q): %v", input, err)
+		}
+	}
+}
+
+func TestRunEachFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.pql": "T | take 1",
+		"b.kql": "let x = 2;\nU | take x",
+		"c.pql": "T | where",
+	}
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	outDir := filepath.Join(dir, "out")
+	if err := runEachFile(paths, outDir, "", nil, "text"); err == nil {
+		t.Error("runEachFile did not return an error for an invalid file")
+	}
+	for name, want := range map[string]string{
+		"a.sql": "SELECT * FROM \"T\" LIMIT 1;\n\n",
+		"b.sql": "SELECT * FROM \"U\" LIMIT 2;\n\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q; want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "c.sql")); !os.IsNotExist(err) {
+		t.Errorf("output of invalid file exists (Stat error = %v)", err)
+	}
+
+	if err := runEachFile(paths[:1], "", ".ch.sql", nil, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.ch.sql")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompleteLine(t *testing.T) {
+	ac := &pql.AnalysisContext{
+		Tables: map[string]*pql.AnalysisTable{
+			"StormEvents": {Columns: []*pql.AnalysisColumn{
+				{Name: "State"},
+				{Name: "StartTime"},
+			}},
+		},
+	}
+	tests := []struct {
+		name        string
+		history     string
+		line        string
+		pos         int
+		wantLine    string
+		wantChoices []string
+	}{
+		{
+			name:     "Table",
+			line:     "Sto",
+			pos:      3,
+			wantLine: "StormEvents",
+		},
+		{
+			name:        "CommonPrefix",
+			line:        "StormEvents | where S",
+			pos:         21,
+			wantLine:    "StormEvents | where Sta",
+			wantChoices: []string{"StartTime", "State"},
+		},
+		{
+			name:        "Ambiguous",
+			line:        "StormEvents | where Sta",
+			pos:         23,
+			wantLine:    "StormEvents | where Sta",
+			wantChoices: []string{"StartTime", "State"},
+		},
+		{
+			name:     "History",
+			history:  "StormEvents\n",
+			line:     "| where Stat",
+			pos:      12,
+			wantLine: "| where State",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotLine, gotPos, gotChoices := completeLine(ac, test.history, test.line, test.pos)
+			if gotLine != test.wantLine || gotPos != len(test.wantLine) {
+				t.Errorf("completeLine(...) = %q, %d; want %q, %d", gotLine, gotPos, test.wantLine, len(test.wantLine))
+			}
+			if diff := cmp.Diff(test.wantChoices, gotChoices); diff != "" {
+				t.Errorf("choices (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunFilesJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query.pql")
+	if err := os.WriteFile(path, []byte("T;\nT | where |"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	sqlOutput := new(strings.Builder)
+	diagOutput := new(strings.Builder)
+	if err := runFiles(sqlOutput, diagOutput, []string{path}, nil, "json"); err == nil {
+		t.Error("runFiles did not return an error")
+	}
+	if got, want := sqlOutput.String(), "SELECT * FROM \"T\";\n\n"; got != want {
+		t.Errorf("SQL output = %q; want %q", got, want)
+	}
+	want := `{"file":"` + path + `","start":13,"end":14,"line":2,"column":11,"endLine":2,"endColumn":12,"severity":"error","code":"syntax","message":"missing operator name after pipe"}` + "\n" +
+		`{"file":"` + path + `","start":14,"end":14,"line":2,"column":12,"endLine":2,"endColumn":12,"severity":"error","code":"syntax","message":"expected expression, got EOF"}` + "\n"
+	if diff := cmp.Diff(want, diagOutput.String()); diff != "" {
+		t.Errorf("diagnostics (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunAST(t *testing.T) {
+	got := new(strings.Builder)
+	if err := runAST(got, io.Discard, "x.pql", "T | take 5", "json"); err != nil {
+		t.Fatal(err)
+	}
+	want := `[
+  {
+    "type": "TabularExpr",
+    "span": {
+      "start": 0,
+      "end": 10
+    },
+    "Source": {
+      "type": "TableRef",
+      "span": {
+        "start": 0,
+        "end": 1
+      },
+      "Table": {
+        "type": "Ident",
+        "span": {
+          "start": 0,
+          "end": 1
+        },
+        "Name": "T",
+        "NameSpan": {
+          "start": 0,
+          "end": 1
+        }
+      }
+    },
+    "Operators": [
+      {
+        "type": "TakeOperator",
+        "span": {
+          "start": 2,
+          "end": 10
+        },
+        "Pipe": {
+          "start": 2,
+          "end": 3
+        },
+        "Keyword": {
+          "start": 4,
+          "end": 8
+        },
+        "RowCount": {
+          "type": "BasicLit",
+          "span": {
+            "start": 9,
+            "end": 10
+          },
+          "ValueSpan": {
+            "start": 9,
+            "end": 10
+          },
+          "Kind": "TokenNumber",
+          "Value": "5"
+        }
+      }
+    ]
+  }
+]
+`
+	if diff := cmp.Diff(want, got.String()); diff != "" {
+		t.Errorf("runAST(...) (-want +got):\n%s", diff)
+	}
+
+	diags := new(strings.Builder)
+	if err := runAST(io.Discard, diags, "x.pql", "T | ", "json"); err == nil {
+		t.Error("runAST did not return an error for an invalid query")
+	}
+	if want := "x.pql:1:3: error: missing operator name after pipe\n"; diags.String() != want {
+		t.Errorf("diagnostics = %q; want %q", diags, want)
+	}
+}
+
+func TestRunTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		all    bool
+		format string
+		want   string
+	}{
+		{
+			name:   "Text",
+			source: "T | take 5 // x",
+			format: "text",
+			want: "1:1\t[0,1)\tTokenIdentifier\t\"T\"\n" +
+				"1:3\t[2,3)\tTokenPipe\t\"\"\n" +
+				"1:5\t[4,8)\tTokenIdentifier\t\"take\"\n" +
+				"1:10\t[9,10)\tTokenNumber\t\"5\"\n",
+		},
+		{
+			name:   "All",
+			source: "T // x",
+			all:    true,
+			format: "text",
+			want: "1:1\t[0,1)\tTokenIdentifier\t\"T\"\n" +
+				"1:2\t[1,2)\tTokenWhitespace\t\"\"\n" +
+				"1:3\t[2,6)\tTokenComment\t\" x\"\n",
+		},
+		{
+			name:   "JSON",
+			source: "T\n|",
+			format: "json",
+			want: `{"kind":"TokenIdentifier","start":0,"end":1,"line":1,"column":1,"value":"T"}` + "\n" +
+				`{"kind":"TokenPipe","start":2,"end":3,"line":2,"column":1}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := new(strings.Builder)
+			if err := runTokens(got, test.source, test.all, test.format); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got.String()); diff != "" {
+				t.Errorf("runTokens(%q) (-want +got):\n%s", test.source, diff)
+			}
+		})
+	}
+}
+
+func TestRunExec(t *testing.T) {
+	dir := t.TempDir()
+	eventsPath := filepath.Join(dir, "events.csv")
+	err := os.WriteFile(eventsPath, []byte("host,status,bytes\na,200,10\nb,500,\na,404,2.5\n"), 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostsPath := filepath.Join(dir, "hosts.ndjson")
+	err = os.WriteFile(hostsPath, []byte(`{"host":"a","tags":{"env":"prod"}}`+"\n"+`{"host":"b","tags":{"env":"dev"}}`+"\n"), 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := map[string]string{
+		"Events": eventsPath,
+		"Hosts":  hostsPath,
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		format string
+		want   string
+	}{
+		{
+			name:   "CSV",
+			query:  "Events | where status >= 400 | project host, status, bytes",
+			format: "csv",
+			want:   "host,status,bytes\nb,500,\na,404,2.5\n",
+		},
+		{
+			name:   "Table",
+			query:  "Events | where status >= 400 | project host, status, bytes",
+			format: "table",
+			want:   "host  status  bytes\nb     500     null\na     404     2.5\n",
+		},
+		{
+			name:   "NDJSON",
+			query:  "Events | join kind=inner (Hosts) on host | summarize n = count() by env = tags.env | sort by env asc",
+			format: "ndjson",
+			want:   `{"env":"dev","n":1}` + "\n" + `{"env":"prod","n":2}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := new(strings.Builder)
+			if err := runExec(context.Background(), got, test.query, tables, test.format); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != test.want {
+				t.Errorf("output = %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRunExecArrow(t *testing.T) {
+	dir := t.TempDir()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "host", Type: arrow.BinaryTypes.String},
+		{Name: "status", Type: arrow.PrimitiveTypes.Int32},
+	}, nil)
+	rec, _, err := array.RecordFromJSON(memory.DefaultAllocator, schema, strings.NewReader(
+		`[{"host": "a", "status": 200}, {"host": "b", "status": 500}, {"host": "a", "status": 404}]`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+
+	filePath := filepath.Join(dir, "events.arrow")
+	f, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw, err := ipc.NewFileWriter(f, ipc.WithSchema(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := new(bytes.Buffer)
+	sw := ipc.NewWriter(stream, ipc.WithSchema(schema))
+	if err := sw.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	streamPath := filepath.Join(dir, "events.arrows")
+	if err := os.WriteFile(streamPath, stream.Bytes(), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filePath, streamPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			got := new(strings.Builder)
+			const query = "Events | where status >= 400 | project host, status"
+			err := runExec(context.Background(), got, query, map[string]string{"Events": path}, "csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "host,status\nb,500\na,404\n"; got.String() != want {
+				t.Errorf("output = %q; want %q", got, want)
+			}
+		})
+	}
+
+	t.Run("Output", func(t *testing.T) {
+		output := new(bytes.Buffer)
+		const query = "Events | summarize n = count() by host | sort by host asc"
+		err := runExec(context.Background(), output, query, map[string]string{"Events": streamPath}, "arrow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := ipc.NewReader(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Release()
+		var got []string
+		for r.Next() {
+			data, err := r.Record().MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(data))
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{`[{"host":"a","n":2}` + "\n," + `{"host":"b","n":1}` + "\n]"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("records (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestRunDSN(t *testing.T) {
+	var gotSQL string
+	var gotQuery url.Values
+	var gotUser, gotKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotSQL = string(body)
+		gotQuery = r.URL.Query()
+		gotUser = r.Header.Get("X-ClickHouse-User")
+		gotKey = r.Header.Get("X-ClickHouse-Key")
+		if strings.Contains(gotSQL, "Missing") {
+			http.Error(w, "Code: 60. DB::Exception: Unknown table", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, `["host","n"]`+"\n"+`["a",2]`+"\n"+`["b",null]`+"\n")
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsn := "clickhouse://alice:secret@" + u.Host + "/logs"
+
+	got := new(strings.Builder)
+	if err := runDSN(context.Background(), got, dsn, "Events | take 2", "ndjson"); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"host":"a","n":2}` + "\n" + `{"host":"b","n":null}` + "\n"; got.String() != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+	wantSQL, err := pql.Compile("Events | take 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSuffix(wantSQL, ";"); gotSQL != want {
+		t.Errorf("server got SQL %q; want %q", gotSQL, want)
+	}
+	if got, want := gotQuery.Get("database"), "logs"; got != want {
+		t.Errorf("database = %q; want %q", got, want)
+	}
+	if got, want := gotQuery.Get("default_format"), "JSONCompactEachRowWithNames"; got != want {
+		t.Errorf("default_format = %q; want %q", got, want)
+	}
+	if gotUser != "alice" || gotKey != "secret" {
+		t.Errorf("credentials = %q, %q; want %q, %q", gotUser, gotKey, "alice", "secret")
+	}
+
+	err = runDSN(context.Background(), io.Discard, dsn, "Missing", "csv")
+	if err == nil || !strings.Contains(err.Error(), "Unknown table") {
+		t.Errorf("runDSN(..., %q, ...) = %v; want server error", "Missing", err)
+	}
+}
+
+func TestRunExplain(t *testing.T) {
+	const query = "T | where x > 1 | project x, y = x + 1 | sort by x | take 3"
+	got := new(strings.Builder)
+	if err := runExplain(context.Background(), got, query, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	want := "__subquery0\n" +
+		"  from: | where x > 1\n" +
+		"        | project x, y = x + 1\n" +
+		"  sql:\n" +
+		`    SELECT "x" AS "x", "x" + 1 AS "y" FROM "T" WHERE "x" > 1` + "\n" +
+		"\n" +
+		"final query\n" +
+		"  from: | sort by x\n" +
+		"        | take 3\n" +
+		"  sort: | sort by x\n" +
+		"  take: | take 3\n" +
+		"  sql:\n" +
+		`    SELECT * FROM "__subquery0" ORDER BY "x" DESC NULLS LAST LIMIT 3` + "\n"
+	if diff := cmp.Diff(want, got.String()); diff != "" {
+		t.Errorf("output (-want +got):\n%s", diff)
+	}
+
+	var gotSQL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotSQL = string(body)
+		io.WriteString(w, `["explain"]`+"\n"+`["Expression"]`+"\n"+`["  ReadFromMergeTree"]`+"\n")
+	}))
+	defer srv.Close()
+	got.Reset()
+	if err := runExplain(context.Background(), got, "T", nil, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if want := `EXPLAIN SELECT * FROM "T"`; gotSQL != want {
+		t.Errorf("server got SQL %q; want %q", gotSQL, want)
+	}
+	want = "final query\n" +
+		"  sql:\n" +
+		`    SELECT * FROM "T"` + "\n" +
+		"\n" +
+		"EXPLAIN\n" +
+		"  Expression\n" +
+		"    ReadFromMergeTree\n"
+	if diff := cmp.Diff(want, got.String()); diff != "" {
+		t.Errorf("output with dsn (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	dir := t.TempDir()
+	tablePath := filepath.Join(dir, "T.csv")
+	if err := os.WriteFile(tablePath, []byte("x\n1\n2\n3\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	queries := []benchQuery{{name: "q.pql", source: "T | where x > 1"}}
+	opts := &benchOptions{
+		count:     5,
+		execCount: 2,
+		tables:    map[string]string{"T": tablePath},
+	}
+	got := new(bytes.Buffer)
+	if err := runBench(context.Background(), got, queries, opts, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var phases []string
+	dec := json.NewDecoder(got)
+	for dec.More() {
+		r := new(benchResult)
+		if err := dec.Decode(r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Name != "q.pql" {
+			t.Errorf("name = %q; want %q", r.Name, "q.pql")
+		}
+		wantN := opts.count
+		if r.Phase == "exec" {
+			wantN = opts.execCount
+		}
+		if r.N != wantN {
+			t.Errorf("%s n = %d; want %d", r.Phase, r.N, wantN)
+		}
+		if !(r.Min <= r.P50 && r.P50 <= r.P90 && r.P90 <= r.P99 && r.P99 <= r.Max) {
+			t.Errorf("%s latencies are not ordered: %+v", r.Phase, r)
+		}
+		phases = append(phases, r.Phase)
+	}
+	if want := []string{"parse", "compile", "exec"}; !slices.Equal(phases, want) {
+		t.Errorf("phases = %q; want %q", phases, want)
+	}
+
+	opts.tables = nil
+	if err := runBench(context.Background(), io.Discard, queries, opts, "text"); err == nil {
+		t.Error("runBench with --exec-count and no tables did not return an error")
+	}
+	opts.execCount = 0
+	queries = []benchQuery{{name: "bad.pql", source: "T | where"}}
+	if err := runBench(context.Background(), io.Discard, queries, opts, "text"); err == nil || !strings.HasPrefix(err.Error(), "bad.pql: ") {
+		t.Errorf("runBench(%q) = %v; want error for bad.pql", queries[0].source, err)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i + 1)
+	}
+	tests := []struct {
+		p    int
+		want time.Duration
+	}{
+		{0, 1},
+		{50, 50},
+		{90, 90},
+		{99, 99},
+		{100, 100},
+	}
+	for _, test := range tests {
+		if got := percentile(sorted, test.p); got != test.want {
+			t.Errorf("percentile(1..100, %d) = %v; want %v", test.p, got, test.want)
+		}
+	}
+	if got := percentile(sorted[:1], 99); got != 1 {
+		t.Errorf("percentile([1], 99) = %v; want 1", got)
+	}
+}
+
+func TestRunConvert(t *testing.T) {
+	got := new(strings.Builder)
+	if err := runConvert(got, io.Discard, "q.sql", "SELECT a FROM T WHERE b > 1"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "T\n| where b > 1\n| project a\n"; got.String() != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+
+	diagOutput := new(strings.Builder)
+	if err := runConvert(io.Discard, diagOutput, "q.sql", "SELECT a\nFROM T\nUNION SELECT a FROM U"); err == nil {
+		t.Error("runConvert with UNION did not return an error")
+	}
+	if want := "q.sql:3:1: "; !strings.HasPrefix(diagOutput.String(), want) {
+		t.Errorf("diagnostics = %q; want prefix %q", diagOutput, want)
+	}
+}
+
+func TestServe(t *testing.T) {
+	opts := &pql.CompileOptions{
+		AnalysisContext: &pql.AnalysisContext{
+			Tables: map[string]*pql.AnalysisTable{
+				"T": {Columns: []*pql.AnalysisColumn{{Name: "a", Type: "Int64"}}},
+			},
+		},
+	}
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"compile","params":{"source":"T | take 1; T | where b"}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"completion","params":{"source":"T | where a","offset":11}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"hover","params":{"source":"T | where a > 1","offset":10}}`,
+		`{"jsonrpc":"2.0","method":"diagnostics","params":{"source":"T"}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"hover","params":{"source":"T","offset":5}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"format"}`,
+		`not json`,
+	}, "\n")
+	got := new(strings.Builder)
+	if err := newServer(opts).serve(context.Background(), got, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"jsonrpc":"2.0","id":1,"result":{"sql":["SELECT * FROM \"T\" LIMIT 1;"],"diagnostics":[{"start":22,"end":23,"line":1,"column":23,"endLine":1,"endColumn":24,"severity":"error","code":"unknown-column","message":"unknown column \"b\""}]}}` + "\n" +
+		`{"jsonrpc":"2.0","id":2,"result":{"items":[{"label":"a","text":"a","start":10,"end":11,"kind":"column","detail":"Int64"}]}}` + "\n" +
+		`{"jsonrpc":"2.0","id":3,"result":{"start":10,"end":11,"kind":"column","name":"a","detail":"Int64"}}` + "\n" +
+		`{"jsonrpc":"2.0","id":4,"error":{"code":-32602,"message":"offset must be within source"}}` + "\n" +
+		`{"jsonrpc":"2.0","id":5,"error":{"code":-32601,"message":"unknown method \"format\""}}` + "\n" +
+		`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid character 'o' in literal null (expecting 'u')"}}` + "\n"
+	if diff := cmp.Diff(want, got.String()); diff != "" {
+		t.Errorf("serve output (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompleteQuery(t *testing.T) {
+	schemaPath := filepath.Join(t.TempDir(), "schema.json")
+	schema := `{"tables": {"StormEvents": {"description": "Storm data"}, "Users": {}}}`
+	if err := os.WriteFile(schemaPath, []byte(schema), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(schemaEnvVar, schemaPath)
+
+	cmd := newExecCommand()
+	if err := cmd.Flags().Set("table", "Logs=logs.csv"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		toComplete string
+		want       []string
+	}{
+		{toComplete: "St", want: []string{"StormEvents\tStorm data"}},
+		{toComplete: "Lo", want: []string{"Logs\ttable"}},
+		{toComplete: "X"},
+	}
+	for _, test := range tests {
+		got, _ := completeQuery(cmd, nil, test.toComplete)
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("completeQuery(%q) (-want +got):\n%s", test.toComplete, diff)
+		}
+	}
+
+	got, _ := completeTableFlag(cmd, nil, "U")
+	if want := []string{"Users="}; !slices.Equal(got, want) {
+		t.Errorf("completeTableFlag(\"U\") = %q; want %q", got, want)
+	}
+}
+
+func TestRunGenerate(t *testing.T) {
+	opts := &pql.CompileOptions{
+		AnalysisContext: &pql.AnalysisContext{
+			Tables: map[string]*pql.AnalysisTable{
+				"Logins": {Columns: []*pql.AnalysisColumn{
+					{Name: "user_id", Type: "UInt64"},
+					{Name: "ts", Type: "DateTime64(3)"},
+					{Name: "address", Type: "Nullable(String)"},
+				}},
+			},
+		},
+	}
+	source := "// Logins by user.\n\n" +
+		"// name: RecentLogins\n" +
+		"// RecentLogins returns the logins of a user since a time.\n" +
+		"// param: user UInt64\n" +
+		"// param: since DateTime64(3)\n" +
+		"Logins\n" +
+		"| where user_id == user and ts > since and ts < since + 3600\n" +
+		"| project ts, address\n" +
+		"\n" +
+		"// name: CountLogins\n" +
+		"Logins | summarize n = count() by user_id\n"
+	got, err := runGenerate(context.Background(), io.Discard, "queries", []*generateFile{{name: "logins.pql", source: source}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotSource := string(got)
+	for _, want := range []string{
+		"package queries\n",
+		"const RecentLoginsSQL = `SELECT \"ts\" AS \"ts\", \"address\" AS \"address\" FROM \"Logins\" " +
+			"WHERE ((coalesce(\"user_id\" = ?, FALSE)) AND (\"ts\" > ?)) AND (\"ts\" < (? + 3600))`\n",
+		"type RecentLoginsParams struct {\n\tUser  uint64\n\tSince time.Time\n}\n",
+		"return []any{p.User, p.Since, p.Since}\n",
+		"type RecentLoginsRow struct {\n\tTs      time.Time `json:\"ts\"`\n\tAddress *string   `json:\"address\"`\n}\n",
+		"// RecentLogins returns the logins of a user since a time.\n" +
+			"func RecentLogins(ctx context.Context, db Querier, params *RecentLoginsParams) ([]*RecentLoginsRow, error) {\n",
+		"if err := rows.Scan(&row.Ts, &row.Address); err != nil {\n",
+		"type CountLoginsRow struct {\n\tUserID uint64 `json:\"user_id\"`\n\tN      uint64 `json:\"n\"`\n}\n",
+		"func CountLogins(ctx context.Context, db Querier) ([]*CountLoginsRow, error) {\n",
+	} {
+		if !strings.Contains(gotSource, want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, gotSource)
+		}
+	}
+
+	opts.Dialect = pql.PostgresDialect
+	got, err = runGenerate(context.Background(), io.Discard, "queries", []*generateFile{{name: "logins.pql", source: source}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "return []any{p.User, p.Since}\n"; !strings.Contains(string(got), want) {
+		t.Errorf("generated code for postgres does not contain %q:\n%s", want, got)
+	}
+
+	diagOutput := new(strings.Builder)
+	bad := "// name: Bad\nLogins | where nope > 1\n"
+	if _, err := runGenerate(context.Background(), diagOutput, "queries", []*generateFile{{name: "bad.pql", source: bad}}, opts); err == nil {
+		t.Error("runGenerate with unknown column did not return an error")
+	}
+	if want := "bad.pql:2:16: "; !strings.HasPrefix(diagOutput.String(), want) {
+		t.Errorf("diagnostics = %q; want prefix %q", diagOutput, want)
+	}
+
+	unknown := "// name: Unknown\nElsewhere | take 1\n"
+	if _, err := runGenerate(context.Background(), io.Discard, "queries", []*generateFile{{name: "u.pql", source: unknown}}, opts); err == nil {
+		t.Error("runGenerate with unknown table did not return an error")
+	}
+	if _, err := parseGenerateFile(&generateFile{name: "x.pql", source: "T | take 1\n"}); err == nil {
+		t.Error("parseGenerateFile without a name annotation did not return an error")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/parser"
+	"golang.org/x/term"
+)
+
+const (
+	replPrompt             = "pql> "
+	replContinuationPrompt = "...> "
+)
+
+// terminalInput is an [io.ReadCloser] that reads lines from a terminal
+// with line editing.
+// Pressing tab completes table names, columns, operators, and functions
+// using [*pql.AnalysisContext.SuggestCompletions].
+type terminalInput struct {
+	f  *os.File
+	t  *term.Terminal
+	ac *pql.AnalysisContext
+
+	// history is the text read so far.
+	// It provides the let statements and the start of the statement
+	// for completions.
+	history strings.Builder
+	buf     []byte
+}
+
+// newTerminalInput returns a terminalInput that reads from f,
+// which must be a terminal,
+// and writes prompts to standard error.
+// ac may be nil.
+func newTerminalInput(f *os.File, ac *pql.AnalysisContext) *terminalInput {
+	ti := &terminalInput{f: f, ac: ac}
+	ti.t = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{f, os.Stderr}, replPrompt)
+	ti.t.AutoCompleteCallback = ti.complete
+	return ti
+}
+
+func (ti *terminalInput) Read(p []byte) (int, error) {
+	if len(ti.buf) == 0 {
+		// The terminal is only in raw mode while reading a line
+		// so that output is written normally.
+		fd := int(ti.f.Fd())
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return 0, err
+		}
+		line, err := ti.t.ReadLine()
+		term.Restore(fd, state)
+		if err == term.ErrPasteIndicator {
+			err = nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		ti.buf = append(ti.buf, line...)
+		ti.buf = append(ti.buf, '\n')
+		ti.history.WriteString(line)
+		ti.history.WriteString("\n")
+
+		stmts := parser.SplitStatements(ti.history.String())
+		if parser.NewTokenizer(stmts[len(stmts)-1]).Next() {
+			ti.t.SetPrompt(replContinuationPrompt)
+		} else {
+			ti.t.SetPrompt(replPrompt)
+		}
+	}
+	n := copy(p, ti.buf)
+	ti.buf = ti.buf[n:]
+	return n, nil
+}
+
+func (ti *terminalInput) Close() error {
+	return nil
+}
+
+func (ti *terminalInput) complete(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	newLine, newPos, choices := completeLine(ti.ac, ti.history.String(), line, pos)
+	if len(choices) > 1 && newLine == line {
+		ti.t.Write([]byte(strings.Join(choices, "  ") + "\n"))
+	}
+	return newLine, newPos, true
+}
+
+// completeLine completes the text before pos in line,
+// which follows the text in history.
+// If there is a single completion, completeLine inserts it.
+// Otherwise, completeLine inserts the longest prefix the completions share
+// and returns their labels as choices.
+func completeLine(ac *pql.AnalysisContext, history, line string, pos int) (newLine string, newPos int, choices []string) {
+	cursor := len(history) + pos
+	completions := ac.SuggestCompletions(history+line, parser.Span{Start: cursor, End: cursor})
+	var texts []string
+	replace := parser.Span{Start: cursor, End: cursor}
+	for _, c := range completions {
+		if c.Span.Start < len(history) {
+			// Completions can't change lines that have already been read.
+			continue
+		}
+		if len(texts) > 0 && c.Span != replace {
+			continue
+		}
+		replace = c.Span
+		texts = append(texts, c.Text)
+		choices = append(choices, c.Label)
+	}
+	if len(texts) == 0 {
+		return line, pos, nil
+	}
+
+	text := texts[0]
+	for _, t := range texts[1:] {
+		n := 0
+		for n < len(text) && n < len(t) && text[n] == t[n] {
+			n++
+		}
+		text = text[:n]
+	}
+	start := replace.Start - len(history)
+	end := replace.End - len(history)
+	if len(texts) > 1 && len(text) <= pos-start {
+		// Nothing more can be inserted without choosing a completion.
+		return line, pos, slices.Compact(choices)
+	}
+	newLine = line[:start] + text + line[end:]
+	newPos = start + len(text)
+	if len(texts) > 1 {
+		return newLine, newPos, slices.Compact(choices)
+	}
+	return newLine, newPos, nil
+}
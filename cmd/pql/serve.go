@@ -0,0 +1,293 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/internal/document"
+	"github.com/runreveal/pql/parser"
+	"github.com/spf13/cobra"
+)
+
+func newServeCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "serve --stdio [options]",
+		Short: "Serve editor requests over JSON-RPC",
+		Long: "Serve compile, diagnostics, completion, and hover requests\n" +
+			"for editor integrations over JSON-RPC 2.0.\n\n" +
+			"Each request and response is a single line of JSON.\n" +
+			"Requests have a \"source\" parameter with the document's text,\n" +
+			"and completion and hover requests have an \"offset\" parameter\n" +
+			"with the byte offset of the cursor.\n" +
+			"All offsets are byte offsets into the source.\n\n" +
+			"Methods:\n" +
+			"  compile      {source} -> {sql: [string], diagnostics: [Diagnostic]}\n" +
+			"  diagnostics  {source} -> {diagnostics: [Diagnostic]}\n" +
+			"  completion   {source, offset} -> {items: [CompletionItem]}\n" +
+			"  hover        {source, offset} -> Hover or null",
+		Args:                  cobra.NoArgs,
+		DisableFlagsInUseLine: true,
+	}
+	stdio := c.Flags().Bool("stdio", false, "read requests from stdin and write responses to stdout")
+	schemaPath := c.Flags().String("schema", "", "schema `file` describing the available tables")
+	dialectName := c.Flags().String("dialect", "clickhouse", "SQL dialect to write: clickhouse, postgres, or duckdb")
+	c.RegisterFlagCompletionFunc("dialect", completeDialects)
+	c.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		if !*stdio {
+			return errors.New("--stdio is required")
+		}
+		opts := new(pql.CompileOptions)
+		opts.Dialect, err = parseDialect(*dialectName)
+		if err != nil {
+			return err
+		}
+		if *schemaPath != "" {
+			opts.AnalysisContext, err = pql.LoadSchemaFile(*schemaPath)
+			if err != nil {
+				return err
+			}
+		}
+		return newServer(opts).serve(cmd.Context(), os.Stdout, os.Stdin)
+	}
+	return c
+}
+
+// server answers JSON-RPC requests from editors.
+type server struct {
+	opts    *pql.CompileOptions
+	session *pql.CompletionSession
+}
+
+func newServer(opts *pql.CompileOptions) *server {
+	return &server{
+		opts:    opts,
+		session: opts.AnalysisContext.NewCompletionSession(),
+	}
+}
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// serve reads requests from input, one per line,
+// and writes a response line to output for each request that has an ID.
+// serve returns when input ends or ctx is canceled.
+func (srv *server) serve(ctx context.Context, output io.Writer, input io.Reader) error {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, 64<<20)
+	enc := json.NewEncoder(output)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		resp := srv.handle(ctx, line)
+		if resp == nil {
+			continue
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// handle returns the response to a request
+// or nil if the request is a notification.
+func (srv *server) handle(ctx context.Context, line []byte) *rpcResponse {
+	resp := &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	req := new(rpcRequest)
+	if err := json.Unmarshal(line, req); err != nil {
+		resp.Error = &rpcError{Code: rpcParseError, Message: err.Error()}
+		return resp
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}
+		return resp
+	}
+	result, err := srv.call(ctx, req.Method, req.Params)
+	if len(req.ID) == 0 {
+		return nil
+	}
+	resp.ID = req.ID
+	if err != nil {
+		rpcErr := new(rpcError)
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+		return resp
+	}
+	if result == nil {
+		resp.Result = json.RawMessage("null")
+	} else {
+		resp.Result = result
+	}
+	return resp
+}
+
+type documentParams struct {
+	Source string `json:"source"`
+	Offset *int   `json:"offset"`
+}
+
+type compileResult struct {
+	SQL         []string          `json:"sql"`
+	Diagnostics []*jsonDiagnostic `json:"diagnostics"`
+}
+
+type diagnosticsResult struct {
+	Diagnostics []*jsonDiagnostic `json:"diagnostics"`
+}
+
+type completionResult struct {
+	Items []*jsonCompletion `json:"items"`
+}
+
+// jsonCompletion is the JSON representation of a [pql.Completion].
+type jsonCompletion struct {
+	Label         string `json:"label"`
+	Text          string `json:"text"`
+	Start         int    `json:"start"`
+	End           int    `json:"end"`
+	Kind          string `json:"kind"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// jsonHover is the JSON representation of a [pql.HoverInfo].
+type jsonHover struct {
+	Start         int    `json:"start"`
+	End           int    `json:"end"`
+	Kind          string `json:"kind"`
+	Name          string `json:"name"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+func (srv *server) call(ctx context.Context, method string, rawParams json.RawMessage) (any, error) {
+	params := new(documentParams)
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+	}
+	offset := func() (int, error) {
+		if params.Offset == nil || *params.Offset < 0 || *params.Offset > len(params.Source) {
+			return 0, &rpcError{Code: rpcInvalidParams, Message: "offset must be within source"}
+		}
+		return *params.Offset, nil
+	}
+
+	switch method {
+	case "compile":
+		result := &compileResult{SQL: []string{}}
+		diags := document.Compile(ctx, srv.opts, params.Source, func(sql string) {
+			result.SQL = append(result.SQL, sql)
+		})
+		result.Diagnostics = newJSONDiagnostics(params.Source, diags)
+		return result, nil
+	case "diagnostics":
+		diags := document.Compile(ctx, srv.opts, params.Source, nil)
+		return &diagnosticsResult{Diagnostics: newJSONDiagnostics(params.Source, diags)}, nil
+	case "completion":
+		pos, err := offset()
+		if err != nil {
+			return nil, err
+		}
+		completions, err := srv.session.SuggestCompletions(ctx, params.Source, parser.Span{Start: pos, End: pos})
+		if err != nil {
+			return nil, err
+		}
+		result := &completionResult{Items: make([]*jsonCompletion, 0, len(completions))}
+		for _, c := range completions {
+			result.Items = append(result.Items, &jsonCompletion{
+				Label:         c.Label,
+				Text:          c.Text,
+				Start:         c.Span.Start,
+				End:           c.Span.End,
+				Kind:          completionKindName(c.Kind),
+				Detail:        c.Detail,
+				Documentation: c.Documentation,
+			})
+		}
+		return result, nil
+	case "hover":
+		pos, err := offset()
+		if err != nil {
+			return nil, err
+		}
+		info, err := srv.opts.AnalysisContext.Hover(ctx, params.Source, pos)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			return nil, nil
+		}
+		return &jsonHover{
+			Start:         info.Span.Start,
+			End:           info.Span.End,
+			Kind:          completionKindName(info.Kind),
+			Name:          info.Name,
+			Detail:        info.Detail,
+			Documentation: info.Documentation,
+		}, nil
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", method)}
+	}
+}
+
+func newJSONDiagnostics(source string, diags []parser.Diagnostic) []*jsonDiagnostic {
+	result := make([]*jsonDiagnostic, 0, len(diags))
+	for _, diag := range diags {
+		result = append(result, newJSONDiagnostic("", source, diag))
+	}
+	return result
+}
+
+// completionKindName returns the name of kind without its prefix,
+// like "table" for [pql.CompletionTable].
+func completionKindName(kind pql.CompletionKind) string {
+	return strings.ToLower(strings.TrimPrefix(kind.String(), "Completion"))
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/runreveal/pql/parser"
+	"github.com/spf13/cobra"
+)
+
+func newTokensCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "tokens [options] [FILE]",
+		Short: "Print the tokens in Pipeline Query Language source",
+		Long: "Print the tokens in Pipeline Query Language source, one per line.\n\n" +
+			"The text format writes the position, byte span, kind, and value of each token.\n" +
+			"The json format writes one JSON object per token.\n" +
+			"With no file, tokens reads standard input.",
+		Args:                  cobra.MaximumNArgs(1),
+		DisableFlagsInUseLine: true,
+	}
+	all := c.Flags().BoolP("all", "a", false, "include whitespace and comment tokens")
+	format := c.Flags().String("format", "text", "output `format`: text or json")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		var source []byte
+		var err error
+		if len(args) == 0 || args[0] == "-" {
+			source, err = io.ReadAll(os.Stdin)
+		} else {
+			source, err = os.ReadFile(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		return runTokens(os.Stdout, string(source), *all, *format)
+	}
+	return c
+}
+
+// jsonToken is the JSON representation of a [parser.Token].
+// Offsets are byte offsets into the file.
+// Lines and columns are 1-based.
+type jsonToken struct {
+	Kind   string `json:"kind"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Value  string `json:"value,omitempty"`
+}
+
+// runTokens writes the tokens in source to output in the given format.
+// If all is true, whitespace and comment tokens are included.
+func runTokens(output io.Writer, source string, all bool, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	var tokens []parser.Token
+	if all {
+		tokens = parser.ScanFull(source)
+	} else {
+		tokens = parser.Scan(source)
+	}
+	for _, tok := range tokens {
+		pos := parser.PositionFor(source, tok.Span.Start)
+		if format == "json" {
+			data, err := json.Marshal(&jsonToken{
+				Kind:   tok.Kind.String(),
+				Start:  tok.Span.Start,
+				End:    tok.Span.End,
+				Line:   pos.Line,
+				Column: pos.Column,
+				Value:  tok.Value,
+			})
+			if err != nil {
+				return err
+			}
+			data = append(data, '\n')
+			if _, err := output.Write(data); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(output, "%v\t%v\t%v\t%q\n", pos, tok.Span, tok.Kind, tok.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
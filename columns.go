@@ -0,0 +1,324 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// columnResolver determines the columns of tabular expressions
+// from an [AnalysisContext] and the tabular let statements in scope.
+// The compiler uses it for rewrites that depend on a query's columns,
+// and the completer embeds it to describe the queries being edited.
+type columnResolver struct {
+	ac     *AnalysisContext
+	ctx    context.Context
+	source string
+
+	// tabularLets is the list of let statements
+	// that bind tabular expressions.
+	tabularLets []*parser.LetStatement
+	// visibleTabularLets is the number of elements in tabularLets
+	// that are in scope for table lookups.
+	visibleTabularLets int
+
+	err error
+}
+
+// lookupTableRef returns the table referenced by the given node
+// or nil if the table is not known.
+func (r *columnResolver) lookupTableRef(ref *parser.TableRef) *AnalysisTable {
+	if ref.Cluster != nil {
+		// The tables of other clusters are not known.
+		return nil
+	}
+	if ref.Database == nil {
+		return r.lookupTable(ref.Table.Name)
+	}
+	if r.ac == nil {
+		return nil
+	}
+	db := r.ac.Databases[ref.Database.Name]
+	if db == nil {
+		return nil
+	}
+	return db.Tables[ref.Table.Name]
+}
+
+// lookupTable returns the table with the given name
+// or nil if the table is not known.
+func (r *columnResolver) lookupTable(name string) *AnalysisTable {
+	for i := r.visibleTabularLets - 1; i >= 0; i-- {
+		let := r.tabularLets[i]
+		if let.Name.Name != name {
+			continue
+		}
+		// A let statement can only refer to the statements before it.
+		prevVisible := r.visibleTabularLets
+		r.visibleTabularLets = i
+		cols := r.tabularColumns(r.source, let.Tabular)
+		r.visibleTabularLets = prevVisible
+		return &AnalysisTable{Columns: cols}
+	}
+	if r.ac == nil {
+		return nil
+	}
+	if tbl := r.ac.Tables[name]; tbl != nil {
+		return tbl
+	}
+	if r.ac.Provider == nil || r.err != nil {
+		return nil
+	}
+	tbl, err := r.ac.Provider.LookupTable(r.ctx, name)
+	if err != nil {
+		r.fail(fmt.Errorf("look up table %q: %w", name, err))
+		return nil
+	}
+	return tbl
+}
+
+// lookupTablePattern returns the union of the columns
+// of the tables that match the given wildcard pattern
+// or nil if no tables match.
+func (r *columnResolver) lookupTablePattern(database *parser.Ident, pattern string) []*AnalysisColumn {
+	if r.ac == nil || r.err != nil {
+		return nil
+	}
+	dbName := ""
+	if database != nil {
+		dbName = database.Name
+	}
+	names, err := r.ac.matchTables(r.ctx, dbName, pattern)
+	if err != nil {
+		r.fail(err)
+		return nil
+	}
+	var tables []*AnalysisTable
+	for _, name := range names {
+		var tbl *AnalysisTable
+		if database == nil {
+			tbl = r.lookupTable(name)
+		} else {
+			tbl = r.ac.Databases[dbName].Tables[name]
+		}
+		if tbl != nil {
+			tables = append(tables, tbl)
+		}
+	}
+	return unionColumns(tables)
+}
+
+// fail records the first error encountered while resolving columns.
+func (r *columnResolver) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// tabularColumns returns the columns produced by a tabular expression.
+// It returns nil if the columns cannot be determined.
+func (r *columnResolver) tabularColumns(source string, expr *parser.TabularExpr) []*AnalysisColumn {
+	cols, ok := r.dataSourceColumns(source, expr.Source)
+	if !ok {
+		return nil
+	}
+	for _, op := range expr.Operators {
+		switch op := op.(type) {
+		case *parser.ProjectOperator:
+			newCols := make([]*AnalysisColumn, 0, len(op.Cols))
+			for _, col := range op.Cols {
+				if col.Name == nil {
+					continue
+				}
+				if col.X == nil {
+					if i := columnIndex(cols, col.Name.Name); i >= 0 {
+						newCols = append(newCols, cols[i])
+						continue
+					}
+				}
+				newCols = append(newCols, &AnalysisColumn{
+					Name: col.Name.Name,
+					Type: exprType(cols, col.X),
+				})
+			}
+			cols = newCols
+		case *parser.ExtendOperator:
+			for _, col := range op.Cols {
+				if col.X == nil {
+					continue
+				}
+				cols = setColumn(cols, &AnalysisColumn{
+					Name: derivedColumnName(source, col.Name, col.X),
+					Type: exprType(cols, col.X),
+				})
+			}
+		case *parser.MvExpandOperator:
+			for _, col := range op.Cols {
+				if col.X == nil {
+					continue
+				}
+				cols = setColumn(cols, &AnalysisColumn{
+					Name: derivedColumnName(source, col.Name, col.X),
+					Type: arrayElementType(exprType(cols, col.X)),
+				})
+			}
+		case *parser.SummarizeOperator:
+			newCols := make([]*AnalysisColumn, 0, len(op.GroupBy)+len(op.Cols))
+			for _, col := range op.GroupBy {
+				if col.X == nil {
+					continue
+				}
+				name := derivedColumnName(source, col.Name, col.X)
+				if i := columnIndex(cols, name); i >= 0 && col.Name == nil {
+					newCols = setColumn(newCols, cols[i])
+				} else {
+					newCols = setColumn(newCols, &AnalysisColumn{
+						Name: name,
+						Type: exprType(cols, col.X),
+					})
+				}
+			}
+			for _, col := range op.Cols {
+				if col.X == nil {
+					continue
+				}
+				newCols = setColumn(newCols, &AnalysisColumn{
+					Name: derivedColumnName(source, col.Name, col.X),
+					Type: exprType(cols, col.X),
+				})
+			}
+			cols = newCols
+		case *parser.ProjectAwayOperator:
+			cols = slices.DeleteFunc(slices.Clone(cols), func(col *AnalysisColumn) bool {
+				return slices.ContainsFunc(op.Cols, func(id *parser.Ident) bool { return id.Name == col.Name })
+			})
+		case *parser.DistinctOperator:
+			newCols := make([]*AnalysisColumn, 0, len(op.Cols))
+			for _, col := range op.Cols {
+				if i := columnIndex(cols, col.Name); i >= 0 {
+					newCols = append(newCols, cols[i])
+				} else {
+					newCols = append(newCols, &AnalysisColumn{Name: col.Name})
+				}
+			}
+			cols = newCols
+		case *parser.CountOperator:
+			cols = []*AnalysisColumn{{Name: "count()", Type: "UInt64"}}
+		case *parser.JoinOperator:
+			if op.Right == nil {
+				return nil
+			}
+			switch joinFlavorName(op) {
+			case "leftsemi", "leftanti":
+				// Only the left side's rows are returned.
+			case "rightsemi", "rightanti":
+				cols = r.tabularColumns(source, op.Right)
+			default:
+				cols = mergeColumns(cols, r.tabularColumns(source, op.Right))
+			}
+		case *parser.UnionOperator:
+			var ok bool
+			cols, ok = r.unionInputColumns(source, cols, op.SourceColumn, op.Tables)
+			if !ok {
+				return nil
+			}
+		}
+	}
+	return cols
+}
+
+// dataSourceColumns returns the columns produced by a data source.
+// The second result is false if the columns cannot be determined.
+func (r *columnResolver) dataSourceColumns(source string, src parser.TabularDataSource) ([]*AnalysisColumn, bool) {
+	var cols []*AnalysisColumn
+	switch src := src.(type) {
+	case *parser.TableRef:
+		tbl := r.lookupTableRef(src)
+		if tbl == nil {
+			return nil, false
+		}
+		return slices.Clone(tbl.Columns), true
+	case *parser.TableWildcard:
+		cols = r.lookupTablePattern(src.Database, src.Pattern)
+	case *parser.TableCall:
+		pattern, ok := constantString(src.Name, nil)
+		if !ok {
+			return nil, false
+		}
+		cols = r.lookupTablePattern(nil, pattern)
+	case *parser.ParenTabularExpr:
+		if src.X == nil {
+			return nil, false
+		}
+		cols = r.tabularColumns(source, src.X)
+	case *parser.UnionSource:
+		return r.unionInputColumns(source, nil, src.SourceColumn, src.Tables)
+	default:
+		return nil, false
+	}
+	return cols, cols != nil
+}
+
+// unionInputColumns returns the columns produced by a union
+// of rows with the given columns and the given tables.
+// Like the columns of a table wildcard,
+// the columns of the inputs are combined by name.
+// The second result is false if the columns of any input cannot be determined.
+func (r *columnResolver) unionInputColumns(source string, cols []*AnalysisColumn, sourceColumn *parser.Ident, tables []parser.TabularDataSource) ([]*AnalysisColumn, bool) {
+	for _, src := range tables {
+		srcCols, ok := r.dataSourceColumns(source, src)
+		if !ok {
+			return nil, false
+		}
+		cols = mergeColumns(cols, srcCols)
+	}
+	if sourceColumn != nil {
+		cols = mergeColumns([]*AnalysisColumn{{Name: sourceColumn.Name, Type: "String"}}, cols)
+	}
+	return cols, true
+}
+
+// exactColumns returns the columns produced by expr
+// or nil if any of them cannot be determined.
+func (r *columnResolver) exactColumns(expr *parser.TabularExpr) []*AnalysisColumn {
+	for _, op := range expr.Operators {
+		switch op := op.(type) {
+		case *parser.JoinOperator:
+			// tabularColumns ignores the right side of a join if it is unknown.
+			if op.Right == nil || r.exactColumns(op.Right) == nil {
+				return nil
+			}
+		case *parser.UnionOperator:
+			for _, src := range op.Tables {
+				if !r.exactSourceColumns(src) {
+					return nil
+				}
+			}
+		}
+	}
+	if !r.exactSourceColumns(expr.Source) {
+		return nil
+	}
+	return r.tabularColumns(r.source, expr)
+}
+
+// exactSourceColumns reports whether the columns of the tabular expressions
+// nested in src can all be determined.
+func (r *columnResolver) exactSourceColumns(src parser.TabularDataSource) bool {
+	switch src := src.(type) {
+	case *parser.ParenTabularExpr:
+		return src.X == nil || r.exactColumns(src.X) != nil
+	case *parser.UnionSource:
+		for _, src := range src.Tables {
+			if !r.exactSourceColumns(src) {
+				return false
+			}
+		}
+	}
+	return true
+}
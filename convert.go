@@ -0,0 +1,1868 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// ConvertSQL translates a SQL SELECT statement into
+// an equivalent Pipeline Query Language query.
+// It is intended to help migrate existing SQL queries to pql,
+// so it supports a common subset of SQL rather than any one dialect:
+//
+//   - WITH clauses, which become let statements
+//   - SELECT lists with aliases, * and SELECT DISTINCT
+//   - FROM with a table name or a subquery
+//   - INNER, LEFT, RIGHT, FULL, and CROSS joins with ON or USING
+//   - WHERE, GROUP BY, and HAVING
+//     with the count, sum, avg, min, and max aggregate functions
+//   - ORDER BY and LIMIT
+//
+// Expressions may use comparisons, arithmetic, AND, OR, NOT, IN,
+// IS NULL, BETWEEN, CASE, and function calls.
+// Functions that pql does not know are kept as calls,
+// which the compiler passes through to SQL unchanged,
+// so LIKE is written as a call to a like function.
+//
+// A sort in pql is descending unless asc is given,
+// so the result always gives the direction of each ORDER BY term.
+// The position of NULLs is only kept if the statement gives it
+// with NULLS FIRST or NULLS LAST.
+//
+// If the statement uses a feature outside of this subset,
+// ConvertSQL returns an error that refers to the feature's position in sql
+// with the [CodeUnsupported] code.
+// Syntax errors have the [parser.CodeSyntax] code.
+// Errors can be converted into diagnostics with [parser.Diagnostics].
+func ConvertSQL(sql string) (string, error) {
+	p := &sqlParser{source: sql}
+	if err := p.scan(); err != nil {
+		return "", err
+	}
+	stmt, err := p.statement()
+	if err != nil {
+		return "", err
+	}
+	cv := &sqlConverter{
+		source:  sql,
+		inlined: make(map[string]string),
+	}
+	query, err := cv.statement(stmt)
+	if err != nil {
+		return "", err
+	}
+	if _, err := parser.Parse(query); err != nil {
+		return "", fmt.Errorf("convert sql: produced invalid query: %v", err)
+	}
+	return query, nil
+}
+
+type sqlLexemeKind int
+
+const (
+	sqlEOF sqlLexemeKind = iota
+	// sqlWord is an unquoted identifier or keyword.
+	sqlWord
+	sqlQuotedIdent
+	sqlString
+	sqlNumber
+	sqlPunct
+)
+
+// sqlLexeme is a token of a statement read by [ConvertSQL].
+type sqlLexeme struct {
+	kind  sqlLexemeKind
+	value string
+	span  parser.Span
+}
+
+// sqlReservedWords are the keywords that end an expression,
+// so they can't be used as an alias without AS.
+var sqlReservedWords = map[string]struct{}{
+	"all": {}, "and": {}, "as": {}, "asc": {}, "between": {}, "by": {},
+	"case": {}, "cross": {}, "desc": {}, "distinct": {}, "else": {}, "end": {},
+	"except": {}, "from": {}, "full": {}, "group": {}, "having": {}, "ilike": {},
+	"in": {}, "inner": {}, "intersect": {}, "is": {}, "join": {}, "left": {},
+	"like": {}, "limit": {}, "natural": {}, "not": {}, "nulls": {}, "offset": {},
+	"on": {}, "or": {}, "order": {}, "outer": {}, "right": {}, "select": {},
+	"then": {}, "union": {}, "using": {}, "when": {}, "where": {}, "with": {},
+}
+
+type sqlParser struct {
+	source string
+	tokens []sqlLexeme
+	pos    int
+}
+
+func (p *sqlParser) syntaxError(span parser.Span, format string, args ...any) error {
+	return &compileError{
+		source: p.source,
+		span:   span,
+		err:    fmt.Errorf(format, args...),
+		code:   parser.CodeSyntax,
+	}
+}
+
+func (p *sqlParser) unsupported(span parser.Span, format string, args ...any) error {
+	return &compileError{
+		source: p.source,
+		span:   span,
+		err:    fmt.Errorf(format, args...),
+		code:   CodeUnsupported,
+	}
+}
+
+// scan splits the source into tokens, skipping whitespace and comments.
+func (p *sqlParser) scan() error {
+	s := p.source
+	for i := 0; i < len(s); {
+		c := s[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+			continue
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				end = len(s) - i
+			}
+			i += end
+			continue
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return p.syntaxError(parser.Span{Start: i, End: len(s)}, "unterminated comment")
+			}
+			i += 2 + end + 2
+			continue
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			for i < len(s) && (s[i] == '_' || s[i] == '$' || 'a' <= s[i] && s[i] <= 'z' || 'A' <= s[i] && s[i] <= 'Z' || '0' <= s[i] && s[i] <= '9') {
+				i++
+			}
+			p.tokens = append(p.tokens, sqlLexeme{kind: sqlWord, value: s[start:i], span: parser.Span{Start: start, End: i}})
+			continue
+		case '0' <= c && c <= '9' || c == '.' && i+1 < len(s) && '0' <= s[i+1] && s[i+1] <= '9':
+			for i < len(s) && ('0' <= s[i] && s[i] <= '9' || s[i] == '.') {
+				i++
+			}
+			if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+				i++
+				if i < len(s) && (s[i] == '+' || s[i] == '-') {
+					i++
+				}
+				for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+					i++
+				}
+			}
+			p.tokens = append(p.tokens, sqlLexeme{kind: sqlNumber, value: s[start:i], span: parser.Span{Start: start, End: i}})
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			// Quotes are escaped by doubling them.
+			value := new(strings.Builder)
+			i++
+			for {
+				if i >= len(s) {
+					return p.syntaxError(parser.Span{Start: start, End: i}, "unterminated quoted string")
+				}
+				if s[i] == c {
+					if i+1 < len(s) && s[i+1] == c {
+						value.WriteByte(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				value.WriteByte(s[i])
+				i++
+			}
+			kind := sqlQuotedIdent
+			if c == '\'' {
+				kind = sqlString
+			}
+			p.tokens = append(p.tokens, sqlLexeme{kind: kind, value: value.String(), span: parser.Span{Start: start, End: i}})
+			continue
+		}
+		for _, punct := range []string{"<=", ">=", "<>", "!=", "||"} {
+			if strings.HasPrefix(s[i:], punct) {
+				i += len(punct)
+				break
+			}
+		}
+		if i == start {
+			if !strings.ContainsRune(",().*+-/%=<>;", rune(c)) {
+				return p.syntaxError(parser.Span{Start: i, End: i + 1}, "unexpected %q", s[i:i+1])
+			}
+			i++
+		}
+		p.tokens = append(p.tokens, sqlLexeme{kind: sqlPunct, value: s[start:i], span: parser.Span{Start: start, End: i}})
+	}
+	p.tokens = append(p.tokens, sqlLexeme{kind: sqlEOF, span: parser.Span{Start: len(s), End: len(s)}})
+	return nil
+}
+
+func (p *sqlParser) peek() sqlLexeme {
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) next() sqlLexeme {
+	tok := p.tokens[p.pos]
+	if tok.kind != sqlEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// isKeyword reports whether tok is the given keyword.
+func (tok sqlLexeme) isKeyword(kw string) bool {
+	return tok.kind == sqlWord && strings.EqualFold(tok.value, kw)
+}
+
+func (tok sqlLexeme) isPunct(punct string) bool {
+	return tok.kind == sqlPunct && tok.value == punct
+}
+
+func (tok sqlLexeme) String() string {
+	if tok.kind == sqlEOF {
+		return "end of statement"
+	}
+	return strconv.Quote(tok.value)
+}
+
+// keyword consumes the next token if it is one of the given keywords
+// and reports whether it did so.
+func (p *sqlParser) keyword(kws ...string) bool {
+	for _, kw := range kws {
+		if p.peek().isKeyword(kw) {
+			p.next()
+			return true
+		}
+	}
+	return false
+}
+
+func (p *sqlParser) punct(punct string) bool {
+	if p.peek().isPunct(punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectKeyword(kw string) error {
+	if !p.keyword(kw) {
+		tok := p.peek()
+		return p.syntaxError(tok.span, "unexpected %v (expected %s)", tok, strings.ToUpper(kw))
+	}
+	return nil
+}
+
+func (p *sqlParser) expectPunct(punct string) error {
+	if !p.punct(punct) {
+		tok := p.peek()
+		return p.syntaxError(tok.span, "unexpected %v (expected %q)", tok, punct)
+	}
+	return nil
+}
+
+// ident parses an identifier.
+func (p *sqlParser) ident() (string, parser.Span, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case sqlQuotedIdent:
+		p.next()
+		return tok.value, tok.span, nil
+	case sqlWord:
+		if _, reserved := sqlReservedWords[strings.ToLower(tok.value)]; !reserved {
+			p.next()
+			return tok.value, tok.span, nil
+		}
+	}
+	return "", tok.span, p.syntaxError(tok.span, "unexpected %v (expected identifier)", tok)
+}
+
+// alias parses an optional alias with or without AS.
+func (p *sqlParser) alias() (string, error) {
+	if p.keyword("as") {
+		name, _, err := p.ident()
+		return name, err
+	}
+	tok := p.peek()
+	if tok.kind == sqlQuotedIdent || tok.kind == sqlWord {
+		if _, reserved := sqlReservedWords[strings.ToLower(tok.value)]; !reserved || tok.kind == sqlQuotedIdent {
+			p.next()
+			return tok.value, nil
+		}
+	}
+	return "", nil
+}
+
+type sqlSelectStmt struct {
+	ctes     []*sqlCTE
+	distinct bool
+	items    []*sqlSelectItem
+	from     *sqlTableExpr
+	joins    []*sqlJoin
+	where    sqlExpr
+	groupBy  []sqlExpr
+	having   sqlExpr
+	orderBy  []*sqlOrderTerm
+	// limit is the text of the LIMIT clause's row count
+	// or the empty string if the statement has no LIMIT clause.
+	limit string
+}
+
+type sqlCTE struct {
+	name  string
+	query *sqlSelectStmt
+}
+
+type sqlSelectItem struct {
+	star  bool
+	x     sqlExpr
+	alias string
+	span  parser.Span
+}
+
+// sqlTableExpr is a table name or subquery in a FROM or JOIN clause.
+type sqlTableExpr struct {
+	name     []string
+	subquery *sqlSelectStmt
+	alias    string
+}
+
+type sqlJoin struct {
+	// kind is the pql join flavor.
+	kind  string
+	right *sqlTableExpr
+	on    sqlExpr
+	using []string
+}
+
+type sqlOrderTerm struct {
+	x     sqlExpr
+	desc  bool
+	nulls string
+}
+
+// statement parses a complete SELECT statement.
+func (p *sqlParser) statement() (*sqlSelectStmt, error) {
+	var ctes []*sqlCTE
+	if p.keyword("with") {
+		if p.peek().isKeyword("recursive") {
+			return nil, p.unsupported(p.peek().span, "recursive common table expressions are not supported")
+		}
+		for {
+			name, _, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("as"); err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct("("); err != nil {
+				return nil, err
+			}
+			query, err := p.selectStmt()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			ctes = append(ctes, &sqlCTE{name: name, query: query})
+			if !p.punct(",") {
+				break
+			}
+		}
+	}
+	stmt, err := p.selectStmt()
+	if err != nil {
+		return nil, err
+	}
+	stmt.ctes = ctes
+	p.punct(";")
+	if tok := p.peek(); tok.kind != sqlEOF {
+		for _, kw := range []string{"union", "intersect", "except"} {
+			if tok.isKeyword(kw) {
+				return nil, p.unsupported(tok.span, "%s is not supported", strings.ToUpper(kw))
+			}
+		}
+		if tok.isKeyword("offset") {
+			return nil, p.unsupported(tok.span, "OFFSET is not supported")
+		}
+		return nil, p.syntaxError(tok.span, "unexpected %v", tok)
+	}
+	return stmt, nil
+}
+
+func (p *sqlParser) selectStmt() (*sqlSelectStmt, error) {
+	if err := p.expectKeyword("select"); err != nil {
+		return nil, err
+	}
+	stmt := new(sqlSelectStmt)
+	if p.keyword("distinct") {
+		stmt.distinct = true
+	} else {
+		p.keyword("all")
+	}
+	for {
+		item, err := p.selectItem()
+		if err != nil {
+			return nil, err
+		}
+		stmt.items = append(stmt.items, item)
+		if !p.punct(",") {
+			break
+		}
+	}
+
+	if tok := p.peek(); !p.keyword("from") {
+		return nil, p.unsupported(tok.span, "SELECT without FROM is not supported")
+	}
+	var err error
+	stmt.from, err = p.tableExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		join := new(sqlJoin)
+		tok := p.peek()
+		switch {
+		case p.punct(","):
+			join.kind = "cross"
+		case p.keyword("join"):
+			join.kind = "inner"
+		case p.keyword("inner"):
+			join.kind = "inner"
+		case p.keyword("left"):
+			join.kind = "leftouter"
+		case p.keyword("right"):
+			join.kind = "rightouter"
+		case p.keyword("full"):
+			join.kind = "fullouter"
+		case p.keyword("cross"):
+			join.kind = "cross"
+		case tok.isKeyword("natural"):
+			return nil, p.unsupported(tok.span, "NATURAL JOIN is not supported")
+		}
+		if join.kind == "" {
+			break
+		}
+		if !tok.isKeyword("join") && !tok.isPunct(",") {
+			if join.kind != "inner" && join.kind != "cross" {
+				p.keyword("outer")
+			}
+			if err := p.expectKeyword("join"); err != nil {
+				return nil, err
+			}
+		}
+		join.right, err = p.tableExpr()
+		if err != nil {
+			return nil, err
+		}
+		if join.kind != "cross" {
+			switch {
+			case p.keyword("on"):
+				join.on, err = p.expr()
+				if err != nil {
+					return nil, err
+				}
+			case p.keyword("using"):
+				if err := p.expectPunct("("); err != nil {
+					return nil, err
+				}
+				for {
+					name, _, err := p.ident()
+					if err != nil {
+						return nil, err
+					}
+					join.using = append(join.using, name)
+					if !p.punct(",") {
+						break
+					}
+				}
+				if err := p.expectPunct(")"); err != nil {
+					return nil, err
+				}
+			default:
+				tok := p.peek()
+				return nil, p.syntaxError(tok.span, "unexpected %v (expected ON or USING)", tok)
+			}
+		}
+		stmt.joins = append(stmt.joins, join)
+	}
+
+	if p.keyword("where") {
+		stmt.where, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("group") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		stmt.groupBy, err = p.exprList()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("having") {
+		stmt.having, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("order") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		for {
+			term := new(sqlOrderTerm)
+			term.x, err = p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if p.keyword("desc") {
+				term.desc = true
+			} else {
+				p.keyword("asc")
+			}
+			if p.keyword("nulls") {
+				switch tok := p.next(); {
+				case tok.isKeyword("first"):
+					term.nulls = "first"
+				case tok.isKeyword("last"):
+					term.nulls = "last"
+				default:
+					return nil, p.syntaxError(tok.span, "unexpected %v (expected FIRST or LAST)", tok)
+				}
+			}
+			stmt.orderBy = append(stmt.orderBy, term)
+			if !p.punct(",") {
+				break
+			}
+		}
+	}
+	if p.keyword("limit") {
+		tok := p.next()
+		if tok.kind != sqlNumber {
+			return nil, p.unsupported(tok.span, "LIMIT must be a number")
+		}
+		if p.peek().isPunct(",") {
+			return nil, p.unsupported(p.peek().span, "LIMIT with an offset is not supported")
+		}
+		stmt.limit = tok.value
+	}
+	return stmt, nil
+}
+
+func (p *sqlParser) selectItem() (*sqlSelectItem, error) {
+	start := p.peek().span.Start
+	if p.punct("*") {
+		return &sqlSelectItem{star: true, span: parser.Span{Start: start, End: p.tokens[p.pos-1].span.End}}, nil
+	}
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	alias, err := p.alias()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlSelectItem{
+		x:     x,
+		alias: alias,
+		span:  parser.Span{Start: start, End: p.tokens[p.pos-1].span.End},
+	}, nil
+}
+
+func (p *sqlParser) tableExpr() (*sqlTableExpr, error) {
+	t := new(sqlTableExpr)
+	if p.punct("(") {
+		var err error
+		t.subquery, err = p.selectStmt()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			name, _, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			t.name = append(t.name, name)
+			if !p.punct(".") {
+				break
+			}
+		}
+		if tok := p.peek(); tok.isPunct("(") {
+			return nil, p.unsupported(tok.span, "table functions are not supported")
+		}
+	}
+	var err error
+	t.alias, err = p.alias()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (p *sqlParser) exprList() ([]sqlExpr, error) {
+	var list []sqlExpr
+	for {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, x)
+		if !p.punct(",") {
+			return list, nil
+		}
+	}
+}
+
+// sqlExpr is an expression in a statement read by [ConvertSQL].
+type sqlExpr interface {
+	sqlSpan() parser.Span
+}
+
+type sqlNode struct {
+	span parser.Span
+}
+
+func (n *sqlNode) sqlSpan() parser.Span {
+	return n.span
+}
+
+type sqlColumnRef struct {
+	sqlNode
+	parts []string
+}
+
+// sqlLiteral is a constant.
+type sqlLiteral struct {
+	sqlNode
+	// pql is the literal written in pql.
+	pql    string
+	number bool
+}
+
+type sqlCallExpr struct {
+	sqlNode
+	name     string
+	star     bool
+	distinct bool
+	args     []sqlExpr
+}
+
+type sqlUnaryExpr struct {
+	sqlNode
+	// op is "-" or "not".
+	op string
+	x  sqlExpr
+}
+
+type sqlBinaryExpr struct {
+	sqlNode
+	// op is the SQL operator in lower case.
+	op   string
+	x, y sqlExpr
+}
+
+type sqlIsNullExpr struct {
+	sqlNode
+	x   sqlExpr
+	not bool
+}
+
+type sqlInExpr struct {
+	sqlNode
+	x    sqlExpr
+	vals []sqlExpr
+	not  bool
+}
+
+type sqlLikeExpr struct {
+	sqlNode
+	x, pattern      sqlExpr
+	not             bool
+	caseInsensitive bool
+}
+
+type sqlBetweenExpr struct {
+	sqlNode
+	x, lo, hi sqlExpr
+	not       bool
+}
+
+type sqlCaseExpr struct {
+	sqlNode
+	operand sqlExpr
+	whens   [][2]sqlExpr
+	els     sqlExpr
+}
+
+func (p *sqlParser) spanFrom(start int) sqlNode {
+	return sqlNode{span: parser.Span{Start: start, End: p.tokens[max(p.pos-1, 0)].span.End}}
+}
+
+func (p *sqlParser) expr() (sqlExpr, error) {
+	return p.binary(0)
+}
+
+// sqlBinaryLevels are the binary operators from lowest to highest precedence.
+var sqlBinaryLevels = [][]string{
+	{"or"},
+	{"and"},
+	nil, // NOT and comparisons are parsed by [*sqlParser.not].
+	{"+", "-", "||"},
+	{"*", "/", "%"},
+}
+
+func (p *sqlParser) binary(level int) (sqlExpr, error) {
+	if level == 2 {
+		return p.not()
+	}
+	if level >= len(sqlBinaryLevels) {
+		return p.unary()
+	}
+	start := p.peek().span.Start
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		op := ""
+		for _, candidate := range sqlBinaryLevels[level] {
+			if tok.isPunct(candidate) || tok.isKeyword(candidate) {
+				op = candidate
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &sqlBinaryExpr{sqlNode: p.spanFrom(start), op: op, x: x, y: y}
+	}
+}
+
+func (p *sqlParser) not() (sqlExpr, error) {
+	start := p.peek().span.Start
+	if p.keyword("not") {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlUnaryExpr{sqlNode: p.spanFrom(start), op: "not", x: x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *sqlParser) comparison() (sqlExpr, error) {
+	start := p.peek().span.Start
+	x, err := p.binary(3)
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	for _, op := range []string{"=", "<>", "!=", "<", "<=", ">", ">="} {
+		if tok.isPunct(op) {
+			p.next()
+			y, err := p.binary(3)
+			if err != nil {
+				return nil, err
+			}
+			return &sqlBinaryExpr{sqlNode: p.spanFrom(start), op: op, x: x, y: y}, nil
+		}
+	}
+	if p.keyword("is") {
+		not := p.keyword("not")
+		if err := p.expectKeyword("null"); err != nil {
+			return nil, err
+		}
+		return &sqlIsNullExpr{sqlNode: p.spanFrom(start), x: x, not: not}, nil
+	}
+	not := false
+	if tok.isKeyword("not") {
+		if next := p.tokens[p.pos+1]; next.isKeyword("in") || next.isKeyword("like") || next.isKeyword("ilike") || next.isKeyword("between") {
+			p.next()
+			not = true
+		}
+	}
+	switch tok := p.peek(); {
+	case p.keyword("in"):
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		if sub := p.peek(); sub.isKeyword("select") {
+			return nil, p.unsupported(sub.span, "subqueries in expressions are not supported")
+		}
+		vals, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &sqlInExpr{sqlNode: p.spanFrom(start), x: x, vals: vals, not: not}, nil
+	case p.keyword("like", "ilike"):
+		pattern, err := p.binary(3)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlLikeExpr{
+			sqlNode:         p.spanFrom(start),
+			x:               x,
+			pattern:         pattern,
+			not:             not,
+			caseInsensitive: tok.isKeyword("ilike"),
+		}, nil
+	case p.keyword("between"):
+		lo, err := p.binary(3)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		hi, err := p.binary(3)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlBetweenExpr{sqlNode: p.spanFrom(start), x: x, lo: lo, hi: hi, not: not}, nil
+	}
+	return x, nil
+}
+
+func (p *sqlParser) unary() (sqlExpr, error) {
+	start := p.peek().span.Start
+	if p.punct("-") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := x.(*sqlLiteral); ok && lit.number {
+			return &sqlLiteral{sqlNode: p.spanFrom(start), pql: "-" + lit.pql, number: true}, nil
+		}
+		return &sqlUnaryExpr{sqlNode: p.spanFrom(start), op: "-", x: x}, nil
+	}
+	if p.punct("+") {
+		return p.unary()
+	}
+	return p.primary()
+}
+
+func (p *sqlParser) primary() (sqlExpr, error) {
+	tok := p.next()
+	start := tok.span.Start
+	switch tok.kind {
+	case sqlNumber:
+		return &sqlLiteral{sqlNode: sqlNode{span: tok.span}, pql: tok.value, number: true}, nil
+	case sqlString:
+		return &sqlLiteral{sqlNode: sqlNode{span: tok.span}, pql: formatString(tok.value)}, nil
+	case sqlPunct:
+		if tok.value != "(" {
+			break
+		}
+		if sub := p.peek(); sub.isKeyword("select") {
+			return nil, p.unsupported(sub.span, "subqueries in expressions are not supported")
+		}
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case sqlWord:
+		switch strings.ToLower(tok.value) {
+		case "true", "false", "null":
+			return &sqlLiteral{sqlNode: sqlNode{span: tok.span}, pql: strings.ToLower(tok.value)}, nil
+		case "case":
+			return p.caseExpr(start)
+		case "cast", "exists", "interval", "extract":
+			return nil, p.unsupported(tok.span, "%s is not supported", strings.ToUpper(tok.value))
+		}
+		if next := p.peek(); next.kind == sqlString {
+			return nil, p.unsupported(parser.Span{Start: start, End: next.span.End}, "typed literals are not supported")
+		}
+		if _, reserved := sqlReservedWords[strings.ToLower(tok.value)]; reserved {
+			break
+		}
+		if p.punct("(") {
+			return p.call(tok)
+		}
+		return p.columnRef(tok)
+	case sqlQuotedIdent:
+		return p.columnRef(tok)
+	}
+	return nil, p.syntaxError(tok.span, "unexpected %v (expected expression)", tok)
+}
+
+func (p *sqlParser) columnRef(first sqlLexeme) (sqlExpr, error) {
+	ref := &sqlColumnRef{parts: []string{first.value}}
+	for p.punct(".") {
+		if tok := p.peek(); tok.isPunct("*") {
+			return nil, p.unsupported(parser.Span{Start: first.span.Start, End: tok.span.End}, "qualified * is not supported")
+		}
+		name, _, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		ref.parts = append(ref.parts, name)
+	}
+	ref.sqlNode = p.spanFrom(first.span.Start)
+	return ref, nil
+}
+
+// call parses the arguments of a function call after its left parenthesis.
+func (p *sqlParser) call(name sqlLexeme) (sqlExpr, error) {
+	call := &sqlCallExpr{name: name.value}
+	switch {
+	case p.punct("*"):
+		call.star = true
+	case p.peek().isPunct(")"):
+	default:
+		call.distinct = p.keyword("distinct")
+		var err error
+		call.args, err = p.exprList()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.isKeyword("over") || tok.isKeyword("filter") {
+		return nil, p.unsupported(tok.span, "%s clauses are not supported", strings.ToUpper(tok.value))
+	}
+	call.sqlNode = p.spanFrom(name.span.Start)
+	return call, nil
+}
+
+// caseExpr parses a CASE expression after the CASE keyword.
+func (p *sqlParser) caseExpr(start int) (sqlExpr, error) {
+	x := new(sqlCaseExpr)
+	if !p.peek().isKeyword("when") {
+		var err error
+		x.operand, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for p.keyword("when") {
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("then"); err != nil {
+			return nil, err
+		}
+		result, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		x.whens = append(x.whens, [2]sqlExpr{cond, result})
+	}
+	if len(x.whens) == 0 {
+		tok := p.peek()
+		return nil, p.syntaxError(tok.span, "unexpected %v (expected WHEN)", tok)
+	}
+	if p.keyword("else") {
+		var err error
+		x.els, err = p.expr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("end"); err != nil {
+		return nil, err
+	}
+	x.sqlNode = p.spanFrom(start)
+	return x, nil
+}
+
+// sqlAggregateNames maps the SQL aggregate functions that [ConvertSQL] supports
+// to their pql names.
+var sqlAggregateNames = map[string]string{
+	"count": "count",
+	"sum":   "sum",
+	"avg":   "avg",
+	"min":   "min",
+	"max":   "max",
+}
+
+// sqlFunctionNames maps SQL functions to pql functions with different names.
+var sqlFunctionNames = map[string]string{
+	"lower":  "tolower",
+	"upper":  "toupper",
+	"concat": "strcat",
+}
+
+func isSQLAggregate(x sqlExpr) bool {
+	call, ok := x.(*sqlCallExpr)
+	return ok && sqlAggregateNames[strings.ToLower(call.name)] != ""
+}
+
+// sqlConverter writes the pql for a parsed statement.
+type sqlConverter struct {
+	source string
+	// inlined maps the names of common table expressions without operators
+	// to the pql for their source,
+	// since let statements require at least one operator.
+	inlined map[string]string
+}
+
+func (cv *sqlConverter) unsupported(span parser.Span, format string, args ...any) error {
+	return &compileError{
+		source: cv.source,
+		span:   span,
+		err:    fmt.Errorf(format, args...),
+		code:   CodeUnsupported,
+	}
+}
+
+func (cv *sqlConverter) statement(stmt *sqlSelectStmt) (string, error) {
+	sb := new(strings.Builder)
+	for _, cte := range stmt.ctes {
+		stages, err := cv.pipeline(cte.query)
+		if err != nil {
+			return "", err
+		}
+		if len(stages) == 1 {
+			cv.inlined[cte.name] = stages[0]
+			continue
+		}
+		sb.WriteString("let " + formatIdent(cte.name) + " = " + strings.Join(stages, " ") + ";\n")
+	}
+	stages, err := cv.pipeline(stmt)
+	if err != nil {
+		return "", err
+	}
+	sb.WriteString(strings.Join(stages, "\n"))
+	return sb.String(), nil
+}
+
+// sqlScope is the set of tables that columns in a statement can refer to.
+type sqlScope struct {
+	// names are the aliases or names of the tables
+	// in the order they appear in the FROM clause.
+	names []string
+}
+
+func (scope *sqlScope) add(t *sqlTableExpr) {
+	switch {
+	case t.alias != "":
+		scope.names = append(scope.names, t.alias)
+	case len(t.name) > 0:
+		scope.names = append(scope.names, t.name[len(t.name)-1])
+	default:
+		scope.names = append(scope.names, "")
+	}
+}
+
+// lookup returns the index of the table with the given name or -1.
+func (scope *sqlScope) lookup(name string) int {
+	if i := slices.Index(scope.names, name); i >= 0 {
+		return i
+	}
+	return slices.IndexFunc(scope.names, func(n string) bool {
+		return n != "" && strings.EqualFold(n, name)
+	})
+}
+
+// sqlExprContext is the context an expression is converted in.
+type sqlExprContext struct {
+	scope *sqlScope
+	// joinLeft is the number of tables on the left side of a join
+	// whose condition is being converted, or -1 outside of a join condition.
+	// Columns of the left and right side are written as $left and $right fields.
+	joinLeft int
+	// replace returns the pql for x if it refers to the output
+	// of an earlier operator, like an aggregate.
+	replace func(x sqlExpr) (string, bool, error)
+}
+
+// sqlOutput is a column of a SELECT list.
+type sqlOutput struct {
+	name string
+	// pql is the expression that computes the column.
+	pql string
+}
+
+func (out *sqlOutput) String() string {
+	if out.pql == formatIdent(out.name) {
+		return out.pql
+	}
+	return formatIdent(out.name) + " = " + out.pql
+}
+
+func joinOutputs(outputs []*sqlOutput) string {
+	parts := make([]string, 0, len(outputs))
+	for _, out := range outputs {
+		parts = append(parts, out.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// pipeline returns the source and tabular operators of a pql query
+// equivalent to stmt.
+func (cv *sqlConverter) pipeline(stmt *sqlSelectStmt) ([]string, error) {
+	scope := new(sqlScope)
+	src, err := cv.tableSource(stmt.from)
+	if err != nil {
+		return nil, err
+	}
+	scope.add(stmt.from)
+	stages := []string{src}
+
+	for i, join := range stmt.joins {
+		right, err := cv.tableSource(join.right)
+		if err != nil {
+			return nil, err
+		}
+		scope.add(join.right)
+		op := "| join kind=" + join.kind + " (" + right + ")"
+		var conds []string
+		for _, name := range join.using {
+			conds = append(conds, formatIdent(name))
+		}
+		if join.on != nil {
+			conds, err = cv.joinConditions(join.on, &sqlExprContext{scope: scope, joinLeft: i + 1})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(conds) > 0 {
+			op += " on " + strings.Join(conds, ", ")
+		}
+		stages = append(stages, op)
+	}
+
+	ctx := &sqlExprContext{scope: scope, joinLeft: -1}
+	if stmt.where != nil {
+		if sqlContainsAggregate(stmt.where) {
+			return nil, cv.unsupported(stmt.where.sqlSpan(), "aggregate functions are not allowed in WHERE")
+		}
+		cond, _, err := cv.expr(stmt.where, ctx)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, "| where "+cond)
+	}
+
+	grouped := len(stmt.groupBy) > 0 || stmt.having != nil
+	for _, item := range stmt.items {
+		grouped = grouped || !item.star && sqlContainsAggregate(item.x)
+	}
+	var rest []string
+	if grouped {
+		rest, err = cv.groupedSelect(stmt, ctx)
+	} else {
+		rest, err = cv.simpleSelect(stmt, ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(stages, rest...), nil
+}
+
+// tableSource returns the pql for a table in a FROM or JOIN clause.
+func (cv *sqlConverter) tableSource(t *sqlTableExpr) (string, error) {
+	if t.subquery != nil {
+		stages, err := cv.pipeline(t.subquery)
+		if err != nil {
+			return "", err
+		}
+		if len(stages) == 1 {
+			return stages[0], nil
+		}
+		return "(" + strings.Join(stages, " ") + ")", nil
+	}
+	if len(t.name) == 1 {
+		if src, ok := cv.inlined[t.name[0]]; ok {
+			return src, nil
+		}
+	}
+	parts := make([]string, 0, len(t.name))
+	for _, part := range t.name {
+		parts = append(parts, formatIdent(part))
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// joinConditions returns the pql conditions for a join's ON clause.
+// Equality comparisons of columns with the same name on each side
+// are written as just the column's name.
+func (cv *sqlConverter) joinConditions(on sqlExpr, ctx *sqlExprContext) ([]string, error) {
+	var conds []string
+	for _, term := range sqlConjuncts(on) {
+		if eq, ok := term.(*sqlBinaryExpr); ok && eq.op == "=" {
+			x, xok := eq.x.(*sqlColumnRef)
+			y, yok := eq.y.(*sqlColumnRef)
+			if xok && yok && len(x.parts) == 2 && len(y.parts) == 2 && x.parts[1] == y.parts[1] {
+				xi, yi := ctx.scope.lookup(x.parts[0]), ctx.scope.lookup(y.parts[0])
+				if xi >= 0 && yi >= 0 && (xi < ctx.joinLeft) != (yi < ctx.joinLeft) {
+					conds = append(conds, formatIdent(x.parts[1]))
+					continue
+				}
+			}
+		}
+		cond, _, err := cv.expr(term, ctx)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	return conds, nil
+}
+
+func sqlConjuncts(x sqlExpr) []sqlExpr {
+	if b, ok := x.(*sqlBinaryExpr); ok && b.op == "and" {
+		return append(sqlConjuncts(b.x), sqlConjuncts(b.y)...)
+	}
+	return []sqlExpr{x}
+}
+
+// simpleSelect returns the operators for the SELECT list, ORDER BY, and LIMIT
+// of a statement without aggregates.
+func (cv *sqlConverter) simpleSelect(stmt *sqlSelectStmt, ctx *sqlExprContext) ([]string, error) {
+	var outputs []*sqlOutput
+	star := false
+	unnamed := 0
+	for i, item := range stmt.items {
+		if item.star {
+			if i > 0 {
+				return nil, cv.unsupported(item.span, "* must be the first item in the SELECT list")
+			}
+			star = true
+			continue
+		}
+		x, _, err := cv.expr(item.x, ctx)
+		if err != nil {
+			return nil, err
+		}
+		name := item.alias
+		if name == "" {
+			if star {
+				return nil, cv.unsupported(item.span, "items after * must have an alias")
+			}
+			name = sqlDefaultName(item.x, &unnamed)
+		}
+		outputs = append(outputs, &sqlOutput{name: name, pql: x})
+	}
+	if star && stmt.distinct {
+		return nil, cv.unsupported(stmt.items[0].span, "SELECT DISTINCT * is not supported")
+	}
+
+	var selectOp string
+	switch {
+	case star && len(outputs) > 0:
+		selectOp = "| extend " + joinOutputs(outputs)
+	case star:
+	case stmt.distinct:
+		selectOp = "| summarize by " + joinOutputs(outputs)
+	default:
+		selectOp = "| project " + joinOutputs(outputs)
+	}
+
+	// Sort after the SELECT list if the terms only refer to its columns.
+	// Otherwise, sort before it so that the terms can refer to the input.
+	var stages []string
+	sortOp, afterSelect, err := cv.orderBy(stmt.orderBy, outputs, star, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if sortOp != "" && !afterSelect {
+		if stmt.distinct {
+			return nil, cv.unsupported(stmt.orderBy[0].x.sqlSpan(), "ORDER BY with DISTINCT must refer to selected columns")
+		}
+		stages = append(stages, sortOp)
+		if stmt.limit != "" {
+			stages = append(stages, "| take "+stmt.limit)
+		}
+		if selectOp != "" {
+			stages = append(stages, selectOp)
+		}
+		return stages, nil
+	}
+	if selectOp != "" {
+		stages = append(stages, selectOp)
+	}
+	if sortOp != "" {
+		stages = append(stages, sortOp)
+	}
+	if stmt.limit != "" {
+		stages = append(stages, "| take "+stmt.limit)
+	}
+	return stages, nil
+}
+
+// groupedSelect returns the operators for the SELECT list, GROUP BY, HAVING,
+// ORDER BY, and LIMIT of a statement with aggregates.
+// Aggregates and group keys are computed by a summarize operator,
+// and the SELECT list is written in terms of its columns.
+func (cv *sqlConverter) groupedSelect(stmt *sqlSelectStmt, ctx *sqlExprContext) ([]string, error) {
+	if stmt.distinct {
+		return nil, cv.unsupported(stmt.items[0].span, "SELECT DISTINCT with aggregates is not supported")
+	}
+	unnamed := 0
+	names := make([]string, len(stmt.items))
+	for i, item := range stmt.items {
+		if item.star {
+			return nil, cv.unsupported(item.span, "* is not supported with GROUP BY")
+		}
+		names[i] = item.alias
+		if names[i] == "" {
+			names[i] = sqlDefaultName(item.x, &unnamed)
+		}
+	}
+
+	// Give aggregates that are a whole item of the SELECT list the item's name.
+	var aggs []*sqlOutput
+	aggNames := make(map[string]string)
+	for i, item := range stmt.items {
+		if !isSQLAggregate(item.x) {
+			continue
+		}
+		x, _, err := cv.expr(item.x, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := aggNames[x]; !ok {
+			aggNames[x] = names[i]
+			aggs = append(aggs, &sqlOutput{name: names[i], pql: x})
+		}
+	}
+
+	var keys []*sqlOutput
+	keyNames := make(map[string]string)
+	for _, g := range stmt.groupBy {
+		if lit, ok := g.(*sqlLiteral); ok && lit.number {
+			n, err := strconv.Atoi(lit.pql)
+			if err != nil || n < 1 || n > len(stmt.items) {
+				return nil, cv.unsupported(g.sqlSpan(), "GROUP BY position %s is out of range", lit.pql)
+			}
+			g = stmt.items[n-1].x
+		}
+		if sqlContainsAggregate(g) {
+			return nil, cv.unsupported(g.sqlSpan(), "aggregate functions are not allowed in GROUP BY")
+		}
+		x, _, err := cv.expr(g, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keyNames[x]; ok {
+			continue
+		}
+		name := ""
+		for i, item := range stmt.items {
+			if itemX, _, err := cv.expr(item.x, ctx); err == nil && itemX == x {
+				name = names[i]
+				break
+			}
+		}
+		if name == "" {
+			if ref, ok := g.(*sqlColumnRef); ok {
+				name = ref.parts[len(ref.parts)-1]
+			} else {
+				name = fmt.Sprintf("__key%d", len(keys)+1)
+			}
+		}
+		keyNames[x] = name
+		keys = append(keys, &sqlOutput{name: name, pql: x})
+	}
+
+	// Expressions after the summarize operator refer to its columns.
+	groupedCtx := &sqlExprContext{scope: ctx.scope, joinLeft: -1}
+	groupedCtx.replace = func(x sqlExpr) (string, bool, error) {
+		text, _, err := cv.expr(x, ctx)
+		if err != nil {
+			return "", false, err
+		}
+		if name, ok := keyNames[text]; ok {
+			return formatIdent(name), true, nil
+		}
+		if !isSQLAggregate(x) {
+			return "", false, nil
+		}
+		name, ok := aggNames[text]
+		if !ok {
+			name = fmt.Sprintf("__agg%d", len(aggs)+1)
+			aggNames[text] = name
+			aggs = append(aggs, &sqlOutput{name: name, pql: text})
+		}
+		return formatIdent(name), true, nil
+	}
+
+	outputs := make([]*sqlOutput, 0, len(stmt.items))
+	for i, item := range stmt.items {
+		x, _, err := cv.expr(item.x, groupedCtx)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, &sqlOutput{name: names[i], pql: x})
+	}
+	var having string
+	if stmt.having != nil {
+		var err error
+		having, _, err = cv.expr(stmt.having, groupedCtx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortOp, afterSelect, err := cv.orderBy(stmt.orderBy, outputs, false, groupedCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	summarize := "| summarize"
+	if len(aggs) > 0 {
+		summarize += " " + joinOutputs(aggs)
+	}
+	if len(keys) > 0 {
+		summarize += " by " + joinOutputs(keys)
+	}
+	stages := []string{summarize}
+	if having != "" {
+		stages = append(stages, "| where "+having)
+	}
+
+	// The summarize operator writes its keys followed by its aggregates.
+	// A project operator is needed unless these are the SELECT list.
+	summarized := append(slices.Clone(keys), aggs...)
+	needProject := len(summarized) != len(outputs)
+	for i := 0; i < len(outputs) && !needProject; i++ {
+		needProject = outputs[i].name != summarized[i].name || outputs[i].pql != formatIdent(outputs[i].name)
+	}
+	if sortOp != "" && !afterSelect {
+		stages = append(stages, sortOp)
+		sortOp = ""
+	}
+	if needProject {
+		stages = append(stages, "| project "+joinOutputs(outputs))
+	}
+	if sortOp != "" {
+		stages = append(stages, sortOp)
+	}
+	if stmt.limit != "" {
+		stages = append(stages, "| take "+stmt.limit)
+	}
+	return stages, nil
+}
+
+// orderBy returns the sort operator for an ORDER BY clause
+// and whether it can follow the operator for the SELECT list.
+// It can if every term refers to a column of the SELECT list by name or position,
+// or to any column if the SELECT list has only * and named expressions.
+// Otherwise, the operator is written in terms of the input of the SELECT list
+// and references to the SELECT list's columns are replaced with their expressions.
+func (cv *sqlConverter) orderBy(terms []*sqlOrderTerm, outputs []*sqlOutput, star bool, ctx *sqlExprContext) (op string, afterSelect bool, err error) {
+	if len(terms) == 0 {
+		return "", false, nil
+	}
+	outputIndex := func(x sqlExpr) int {
+		switch x := x.(type) {
+		case *sqlLiteral:
+			if n, err := strconv.Atoi(x.pql); err == nil && x.number && 1 <= n && n <= len(outputs) {
+				return n - 1
+			}
+		case *sqlColumnRef:
+			if len(x.parts) == 1 {
+				return slices.IndexFunc(outputs, func(out *sqlOutput) bool {
+					return out.name == x.parts[0]
+				})
+			}
+		}
+		return -1
+	}
+	afterSelect = true
+	for _, term := range terms {
+		if outputIndex(term.x) >= 0 {
+			continue
+		}
+		if lit, ok := term.x.(*sqlLiteral); ok && lit.number {
+			return "", false, cv.unsupported(lit.span, "ORDER BY position %s is out of range", lit.pql)
+		}
+		if ref, ok := term.x.(*sqlColumnRef); ok && star && len(ref.parts) == 1 {
+			continue
+		}
+		afterSelect = false
+	}
+
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		var x string
+		if i := outputIndex(term.x); i >= 0 {
+			if afterSelect {
+				x = formatIdent(outputs[i].name)
+			} else {
+				x = outputs[i].pql
+			}
+		} else {
+			x, _, err = cv.expr(term.x, ctx)
+			if err != nil {
+				return "", false, err
+			}
+		}
+		if term.desc {
+			x += " desc"
+		} else {
+			x += " asc"
+		}
+		if term.nulls != "" {
+			x += " nulls " + term.nulls
+		}
+		parts = append(parts, x)
+	}
+	return "| sort by " + strings.Join(parts, ", "), afterSelect, nil
+}
+
+// sqlDefaultName returns the name of an unaliased item of a SELECT list.
+// Columns keep their name and aggregates of a column are named like
+// sum_x, as in Kusto.
+// Other expressions are named Column1, Column2, and so on.
+func sqlDefaultName(x sqlExpr, unnamed *int) string {
+	switch x := x.(type) {
+	case *sqlColumnRef:
+		return x.parts[len(x.parts)-1]
+	case *sqlCallExpr:
+		if name := sqlAggregateNames[strings.ToLower(x.name)]; name != "" {
+			if len(x.args) == 1 {
+				if ref, ok := x.args[0].(*sqlColumnRef); ok {
+					return name + "_" + ref.parts[len(ref.parts)-1]
+				}
+			}
+			if len(x.args) == 0 {
+				return name + "_"
+			}
+		}
+	}
+	*unnamed++
+	return fmt.Sprintf("Column%d", *unnamed)
+}
+
+func sqlContainsAggregate(x sqlExpr) bool {
+	found := false
+	walkSQLExpr(x, func(x sqlExpr) bool {
+		if isSQLAggregate(x) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// walkSQLExpr calls visit for x and each of its subexpressions
+// until visit returns false.
+func walkSQLExpr(x sqlExpr, visit func(sqlExpr) bool) {
+	if x == nil || !visit(x) {
+		return
+	}
+	switch x := x.(type) {
+	case *sqlCallExpr:
+		for _, arg := range x.args {
+			walkSQLExpr(arg, visit)
+		}
+	case *sqlUnaryExpr:
+		walkSQLExpr(x.x, visit)
+	case *sqlBinaryExpr:
+		walkSQLExpr(x.x, visit)
+		walkSQLExpr(x.y, visit)
+	case *sqlIsNullExpr:
+		walkSQLExpr(x.x, visit)
+	case *sqlInExpr:
+		walkSQLExpr(x.x, visit)
+		for _, val := range x.vals {
+			walkSQLExpr(val, visit)
+		}
+	case *sqlLikeExpr:
+		walkSQLExpr(x.x, visit)
+		walkSQLExpr(x.pattern, visit)
+	case *sqlBetweenExpr:
+		walkSQLExpr(x.x, visit)
+		walkSQLExpr(x.lo, visit)
+		walkSQLExpr(x.hi, visit)
+	case *sqlCaseExpr:
+		walkSQLExpr(x.operand, visit)
+		for _, when := range x.whens {
+			walkSQLExpr(when[0], visit)
+			walkSQLExpr(when[1], visit)
+		}
+		walkSQLExpr(x.els, visit)
+	}
+}
+
+// Precedence of pql expressions, from lowest to highest.
+const (
+	pqlPrecOr = 1 + iota
+	pqlPrecAnd
+	pqlPrecCompare
+	pqlPrecAdd
+	pqlPrecMul
+	pqlPrecUnary
+	pqlPrecPrimary
+)
+
+// sqlBinaryOperators maps SQL binary operators
+// to their pql equivalent and precedence.
+var sqlBinaryOperators = map[string]struct {
+	pql  string
+	prec int
+}{
+	"or":  {"or", pqlPrecOr},
+	"and": {"and", pqlPrecAnd},
+	"=":   {"==", pqlPrecCompare},
+	"<>":  {"!=", pqlPrecCompare},
+	"!=":  {"!=", pqlPrecCompare},
+	"<":   {"<", pqlPrecCompare},
+	"<=":  {"<=", pqlPrecCompare},
+	">":   {">", pqlPrecCompare},
+	">=":  {">=", pqlPrecCompare},
+	"+":   {"+", pqlPrecAdd},
+	"-":   {"-", pqlPrecAdd},
+	"*":   {"*", pqlPrecMul},
+	"/":   {"/", pqlPrecMul},
+	"%":   {"%", pqlPrecMul},
+}
+
+// parenthesize returns x wrapped in parentheses
+// if its precedence is lower than min.
+func parenthesize(x string, prec, min int) string {
+	if prec < min {
+		return "(" + x + ")"
+	}
+	return x
+}
+
+// expr returns the pql for x and its precedence.
+func (cv *sqlConverter) expr(x sqlExpr, ctx *sqlExprContext) (string, int, error) {
+	if ctx.replace != nil {
+		if s, ok, err := ctx.replace(x); err != nil || ok {
+			return s, pqlPrecPrimary, err
+		}
+	}
+	switch x := x.(type) {
+	case *sqlLiteral:
+		if strings.HasPrefix(x.pql, "-") {
+			return x.pql, pqlPrecUnary, nil
+		}
+		return x.pql, pqlPrecPrimary, nil
+	case *sqlColumnRef:
+		return cv.column(x, ctx), pqlPrecPrimary, nil
+	case *sqlCallExpr:
+		return cv.call(x, ctx)
+	case *sqlUnaryExpr:
+		y, prec, err := cv.expr(x.x, ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		if x.op == "not" {
+			return "not(" + y + ")", pqlPrecPrimary, nil
+		}
+		return "-" + parenthesize(y, prec, pqlPrecUnary), pqlPrecUnary, nil
+	case *sqlBinaryExpr:
+		if x.op == "||" {
+			args, err := cv.args(sqlConcatOperands(x), ctx)
+			if err != nil {
+				return "", 0, err
+			}
+			return "strcat(" + args + ")", pqlPrecPrimary, nil
+		}
+		op := sqlBinaryOperators[x.op]
+		left, lprec, err := cv.expr(x.x, ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		right, rprec, err := cv.expr(x.y, ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		// Operators are left-associative, and comparisons don't chain.
+		lmin := op.prec
+		if op.prec == pqlPrecCompare {
+			lmin++
+		}
+		return parenthesize(left, lprec, lmin) + " " + op.pql + " " + parenthesize(right, rprec, op.prec+1), op.prec, nil
+	case *sqlIsNullExpr:
+		y, _, err := cv.expr(x.x, ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		if x.not {
+			return "isnotnull(" + y + ")", pqlPrecPrimary, nil
+		}
+		return "isnull(" + y + ")", pqlPrecPrimary, nil
+	case *sqlInExpr:
+		y, prec, err := cv.expr(x.x, ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		vals, err := cv.args(x.vals, ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		in := parenthesize(y, prec, pqlPrecCompare+1) + " in (" + vals + ")"
+		if x.not {
+			return "not(" + in + ")", pqlPrecPrimary, nil
+		}
+		return in, pqlPrecCompare, nil
+	case *sqlLikeExpr:
+		name := "like"
+		if x.caseInsensitive {
+			name = "ilike"
+		}
+		args, err := cv.args([]sqlExpr{x.x, x.pattern}, ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		like := name + "(" + args + ")"
+		if x.not {
+			return "not(" + like + ")", pqlPrecPrimary, nil
+		}
+		return like, pqlPrecPrimary, nil
+	case *sqlBetweenExpr:
+		op1, op2, join := ">=", "<=", "and"
+		if x.not {
+			op1, op2, join = "<", ">", "or"
+		}
+		return cv.expr(&sqlBinaryExpr{
+			sqlNode: x.sqlNode,
+			op:      join,
+			x:       &sqlBinaryExpr{sqlNode: x.sqlNode, op: op1, x: x.x, y: x.lo},
+			y:       &sqlBinaryExpr{sqlNode: x.sqlNode, op: op2, x: x.x, y: x.hi},
+		}, ctx)
+	case *sqlCaseExpr:
+		return cv.caseExpr(x, 0, ctx)
+	default:
+		return "", 0, fmt.Errorf("convert sql: unhandled expression %T", x)
+	}
+}
+
+// caseExpr returns the pql for the WHEN clauses of x starting at i
+// as nested calls to iff.
+func (cv *sqlConverter) caseExpr(x *sqlCaseExpr, i int, ctx *sqlExprContext) (string, int, error) {
+	if i == len(x.whens) {
+		if x.els == nil {
+			return "null", pqlPrecPrimary, nil
+		}
+		return cv.expr(x.els, ctx)
+	}
+	cond := x.whens[i][0]
+	if x.operand != nil {
+		cond = &sqlBinaryExpr{sqlNode: x.sqlNode, op: "=", x: x.operand, y: cond}
+	}
+	condText, _, err := cv.expr(cond, ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	then, _, err := cv.expr(x.whens[i][1], ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	els, _, err := cv.caseExpr(x, i+1, ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	return "iff(" + condText + ", " + then + ", " + els + ")", pqlPrecPrimary, nil
+}
+
+func sqlConcatOperands(x sqlExpr) []sqlExpr {
+	if b, ok := x.(*sqlBinaryExpr); ok && b.op == "||" {
+		return append(sqlConcatOperands(b.x), sqlConcatOperands(b.y)...)
+	}
+	return []sqlExpr{x}
+}
+
+func (cv *sqlConverter) args(args []sqlExpr, ctx *sqlExprContext) (string, error) {
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		s, _, err := cv.expr(arg, ctx)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ", "), nil
+}
+
+func (cv *sqlConverter) call(call *sqlCallExpr, ctx *sqlExprContext) (string, int, error) {
+	lower := strings.ToLower(call.name)
+	if call.distinct {
+		return "", 0, cv.unsupported(call.span, "%s(DISTINCT ...) is not supported", strings.ToUpper(call.name))
+	}
+	if call.star && lower != "count" {
+		return "", 0, cv.unsupported(call.span, "%s(*) is not supported", call.name)
+	}
+	if isSQLAggregate(call) && slices.ContainsFunc(call.args, sqlContainsAggregate) {
+		return "", 0, cv.unsupported(call.span, "nested aggregate functions are not supported")
+	}
+	args, err := cv.args(call.args, ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	switch {
+	case lower == "count":
+		switch {
+		case call.star || len(call.args) == 0:
+			return "count()", pqlPrecPrimary, nil
+		case len(call.args) == 1:
+			// COUNT(x) counts the rows where x is not null.
+			return "countif(isnotnull(" + args + "))", pqlPrecPrimary, nil
+		default:
+			return "", 0, cv.unsupported(call.span, "COUNT with more than one argument is not supported")
+		}
+	case sqlAggregateNames[lower] != "":
+		return sqlAggregateNames[lower] + "(" + args + ")", pqlPrecPrimary, nil
+	case sqlFunctionNames[lower] != "":
+		return sqlFunctionNames[lower] + "(" + args + ")", pqlPrecPrimary, nil
+	case !isPlainIdent(call.name):
+		return "", 0, cv.unsupported(call.span, "function name %q cannot be written in pql", call.name)
+	default:
+		return call.name + "(" + args + ")", pqlPrecPrimary, nil
+	}
+}
+
+// column returns the pql for a column reference.
+// Table names and aliases are removed,
+// or replaced with $left or $right in a join condition.
+func (cv *sqlConverter) column(ref *sqlColumnRef, ctx *sqlExprContext) string {
+	parts := ref.parts
+	prefix := ""
+	if len(parts) > 1 {
+		if i := ctx.scope.lookup(parts[0]); i >= 0 {
+			parts = parts[1:]
+			if ctx.joinLeft >= 0 {
+				if i < ctx.joinLeft {
+					prefix = "$left."
+				} else {
+					prefix = "$right."
+				}
+			}
+		}
+	}
+	formatted := make([]string, 0, len(parts))
+	for _, part := range parts {
+		formatted = append(formatted, formatIdent(part))
+	}
+	return prefix + strings.Join(formatted, ".")
+}
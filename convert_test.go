@@ -0,0 +1,235 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql/parser"
+)
+
+func TestConvertSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			name: "Star",
+			sql:  "SELECT * FROM T",
+			want: "T",
+		},
+		{
+			name: "ProjectFilterSortLimit",
+			sql:  "SELECT a, b + 1 AS c FROM T WHERE x > 1 AND NOT (y = 'z') ORDER BY a DESC LIMIT 10;",
+			want: "T\n" +
+				"| where x > 1 and not(y == \"z\")\n" +
+				"| project a, c = b + 1\n" +
+				"| sort by a desc\n" +
+				"| take 10",
+		},
+		{
+			name: "SortByInputColumn",
+			sql:  "SELECT a, x + 1 FROM T ORDER BY b NULLS LAST",
+			want: "T\n" +
+				"| sort by b asc nulls last\n" +
+				"| project a, Column1 = x + 1",
+		},
+		{
+			name: "SortByPosition",
+			sql:  "SELECT a, b AS c FROM T ORDER BY 2",
+			want: "T\n" +
+				"| project a, c = b\n" +
+				"| sort by c asc",
+		},
+		{
+			name: "StarWithExpressions",
+			sql:  "SELECT *, a * 2 AS double FROM T",
+			want: "T\n" +
+				"| extend double = a * 2",
+		},
+		{
+			name: "Distinct",
+			sql:  "select distinct host from T",
+			want: "T\n" +
+				"| summarize by host",
+		},
+		{
+			name: "GroupBy",
+			sql:  "SELECT k, count(*) AS n, sum(v) FROM logs.T GROUP BY k HAVING count(*) > 5 ORDER BY n DESC",
+			want: "logs.T\n" +
+				"| summarize n = count(), sum_v = sum(v) by k\n" +
+				"| where n > 5\n" +
+				"| sort by n desc",
+		},
+		{
+			name: "GroupByReordered",
+			sql:  "SELECT count(x) AS n, k FROM T GROUP BY k",
+			want: "T\n" +
+				"| summarize n = countif(isnotnull(x)) by k\n" +
+				"| project n, k",
+		},
+		{
+			name: "AggregateExpression",
+			sql:  "SELECT sum(a) / count(*) AS avg_a, lower(k) AS lk FROM T GROUP BY 2",
+			want: "T\n" +
+				"| summarize __agg1 = sum(a), __agg2 = count() by lk = tolower(k)\n" +
+				"| project avg_a = __agg1 / __agg2, lk",
+		},
+		{
+			name: "AggregateWithoutGroupBy",
+			sql:  "SELECT count(*), max(ts) FROM T",
+			want: "T\n" +
+				"| summarize count_ = count(), max_ts = max(ts)",
+		},
+		{
+			name: "Join",
+			sql:  "select e.name, d.title from emp e left outer join dept as d on e.dept_id = d.id and e.org = d.org",
+			want: "emp\n" +
+				"| join kind=leftouter (dept) on $left.dept_id == $right.id, org\n" +
+				"| project name, title",
+		},
+		{
+			name: "JoinUsing",
+			sql:  "SELECT * FROM T JOIN U USING (id, ts)",
+			want: "T\n" +
+				"| join kind=inner (U) on id, ts",
+		},
+		{
+			name: "CrossJoin",
+			sql:  "SELECT * FROM T, U",
+			want: "T\n" +
+				"| join kind=cross (U)",
+		},
+		{
+			name: "Subquery",
+			sql:  "SELECT * FROM (SELECT a FROM T WHERE a > 1) AS s LIMIT 5",
+			want: "(T | where a > 1 | project a)\n" +
+				"| take 5",
+		},
+		{
+			name: "With",
+			sql:  "WITH recent AS (SELECT * FROM T WHERE ts > now()), everything AS (SELECT * FROM U) SELECT count(*) AS n FROM recent JOIN everything USING (id)",
+			want: "let recent = T | where ts > now();\n" +
+				"recent\n" +
+				"| join kind=inner (U) on id\n" +
+				"| summarize n = count()",
+		},
+		{
+			name: "Predicates",
+			sql:  "SELECT * FROM T WHERE a IN (1, 2) AND b IS NOT NULL AND c IS NULL AND d NOT IN ('x') AND e LIKE 'a%' AND f NOT BETWEEN 1 AND 10",
+			want: "T\n" +
+				"| where a in (1, 2) and isnotnull(b) and isnull(c) and not(d in (\"x\")) and like(e, \"a%\") and (f < 1 or f > 10)",
+		},
+		{
+			name: "Precedence",
+			sql:  "SELECT (a + b) * c - -d AS v, a - (b - c) AS w FROM T WHERE (a = 1 OR b = 2) AND c = 3",
+			want: "T\n" +
+				"| where (a == 1 or b == 2) and c == 3\n" +
+				"| project v = (a + b) * c - -d, w = a - (b - c)",
+		},
+		{
+			name: "Case",
+			sql:  "SELECT CASE WHEN x > 1 THEN 'big' WHEN x > 0 THEN 'small' END AS size, CASE k WHEN 1 THEN 'one' ELSE 'other' END AS name FROM T",
+			want: "T\n" +
+				"| project size = iff(x > 1, \"big\", iff(x > 0, \"small\", null)), name = iff(k == 1, \"one\", \"other\")",
+		},
+		{
+			name: "Functions",
+			sql:  "SELECT upper(a) || '-' || b AS ab, coalesce(c, 0) AS c FROM T",
+			want: "T\n" +
+				"| project ab = strcat(toupper(a), \"-\", b), c = coalesce(c, 0)",
+		},
+		{
+			name: "QuotedIdentifiers",
+			sql:  `SELECT "weird col" FROM "My Table" AS m WHERE m."weird col" <> 'it''s' -- comment`,
+			want: "`My Table`\n" +
+				"| where `weird col` != \"it's\"\n" +
+				"| project `weird col`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ConvertSQL(test.sql)
+			if err != nil {
+				t.Fatalf("ConvertSQL(%q): %v", test.sql, err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ConvertSQL(%q) (-want +got):\n%s", test.sql, diff)
+			}
+			if _, err := Compile(got); err != nil {
+				t.Errorf("Compile(%q): %v", got, err)
+			}
+		})
+	}
+}
+
+func TestConvertSQLErrors(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want parser.Diagnostic
+	}{
+		{
+			sql: "SELECT a FROM",
+			want: parser.Diagnostic{
+				Span:     parser.Span{Start: 13, End: 13},
+				Severity: parser.SeverityError,
+				Code:     parser.CodeSyntax,
+				Message:  "unexpected end of statement (expected identifier)",
+			},
+		},
+		{
+			sql: "SELECT 1",
+			want: parser.Diagnostic{
+				Span:     parser.Span{Start: 8, End: 8},
+				Severity: parser.SeverityError,
+				Code:     CodeUnsupported,
+				Message:  "SELECT without FROM is not supported",
+			},
+		},
+		{
+			sql: "SELECT a FROM T UNION SELECT b FROM U",
+			want: parser.Diagnostic{
+				Span:     parser.Span{Start: 16, End: 21},
+				Severity: parser.SeverityError,
+				Code:     CodeUnsupported,
+				Message:  "UNION is not supported",
+			},
+		},
+		{
+			sql: "SELECT count(DISTINCT a) FROM T",
+			want: parser.Diagnostic{
+				Span:     parser.Span{Start: 7, End: 24},
+				Severity: parser.SeverityError,
+				Code:     CodeUnsupported,
+				Message:  "COUNT(DISTINCT ...) is not supported",
+			},
+		},
+		{
+			sql: "SELECT a FROM T WHERE b IN (SELECT b FROM U)",
+			want: parser.Diagnostic{
+				Span:     parser.Span{Start: 28, End: 34},
+				Severity: parser.SeverityError,
+				Code:     CodeUnsupported,
+				Message:  "subqueries in expressions are not supported",
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := ConvertSQL(test.sql)
+		if err == nil {
+			t.Errorf("ConvertSQL(%q) = %q, <nil>; want error", test.sql, got)
+			continue
+		}
+		diags := parser.Diagnostics(err)
+		if len(diags) != 1 {
+			t.Errorf("ConvertSQL(%q) error = %v; want one diagnostic", test.sql, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, diags[0]); diff != "" {
+			t.Errorf("ConvertSQL(%q) diagnostic (-want +got):\n%s", test.sql, diff)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// A StoredFunction is a named value defined outside of the queries that use it,
+// like a stored function exported from Azure Data Explorer.
+// Stored functions do not have parameters.
+type StoredFunction struct {
+	Name string
+	// Body is the source of the function's value:
+	// a tabular or scalar expression,
+	// optionally preceded by let statements.
+	Body string
+
+	// DocString and Folder are optional descriptions of the function.
+	DocString string
+	Folder    string
+}
+
+// functionSegment records where part of a stored function's body
+// was copied into the prelude of a query.
+type functionSegment struct {
+	fn *StoredFunction
+	// start is the position of the segment in the prelude.
+	start int
+	// bodyStart is the position of the segment in the function's body.
+	bodyStart  int
+	bodyLength int
+}
+
+// functionPrelude returns let statements that bind the functions in opts.Functions
+// that source refers to, directly or through other functions,
+// along with where each part of each function's body is in the result.
+// Let statements in a function's body are written before the function's own.
+func (opts *CompileOptions) functionPrelude(source string) (string, []functionSegment, error) {
+	sb := new(strings.Builder)
+	var segments []functionSegment
+	copyBody := func(fn *StoredFunction, span parser.Span) {
+		segments = append(segments, functionSegment{
+			fn:         fn,
+			start:      sb.Len(),
+			bodyStart:  span.Start,
+			bodyLength: span.Len(),
+		})
+		sb.WriteString(fn.Body[span.Start:span.End])
+	}
+	for _, fn := range referencedFunctions(opts.Functions, source) {
+		if !isSimpleIdentifier(fn.Name) {
+			return "", nil, &compileError{
+				span: parser.Span{Start: -1, End: -1},
+				err:  fmt.Errorf("invalid function name %q", fn.Name),
+				code: CodeInvalidIdentifier,
+			}
+		}
+		// The value is the text after the last semicolon
+		// that is followed by anything other than whitespace and comments.
+		valueStart, valueEnd := 0, len(fn.Body)
+		tokens := parser.Scan(fn.Body)
+		for i, tok := range tokens {
+			if tok.Kind != parser.TokenSemi {
+				continue
+			}
+			if i == len(tokens)-1 {
+				valueEnd = tok.Span.Start
+			} else {
+				valueStart = tok.Span.End
+			}
+		}
+		lets := parser.Span{Start: 0, End: valueStart}
+		value := parser.Span{Start: valueStart, End: valueEnd}
+		if _, err := parser.Parse(fn.Body[:valueStart]); err != nil {
+			return "", nil, functionSyntaxError(fn, err, 0)
+		}
+		prefix := "let " + fn.Name + " = "
+		if _, err := parser.Parse(prefix + fn.Body[valueStart:valueEnd]); err != nil {
+			return "", nil, functionSyntaxError(fn, err, valueStart-len(prefix))
+		}
+		if strings.TrimSpace(fn.Body[valueStart:valueEnd]) == "" {
+			return "", nil, &compileError{
+				span: parser.Span{Start: -1, End: -1},
+				err:  fmt.Errorf("function %s does not end with an expression", fn.Name),
+				code: parser.CodeSyntax,
+			}
+		}
+		if lets.Len() > 0 {
+			copyBody(fn, lets)
+			sb.WriteString("\n")
+		}
+		sb.WriteString(prefix)
+		copyBody(fn, value)
+		sb.WriteString(";\n")
+	}
+	return sb.String(), segments, nil
+}
+
+// referencedFunctions returns the functions that source refers to by name,
+// directly or through the bodies of other functions,
+// in the order they appear in functions.
+func referencedFunctions(functions []*StoredFunction, source string) []*StoredFunction {
+	byName := make(map[string]*StoredFunction)
+	for _, fn := range functions {
+		byName[fn.Name] = fn
+	}
+	used := make(map[*StoredFunction]bool)
+	queue := []string{source}
+	for len(queue) > 0 {
+		text := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		for t := parser.NewTokenizer(text); t.Next(); {
+			tok := t.Token()
+			if tok.Kind != parser.TokenIdentifier {
+				continue
+			}
+			if fn := byName[tok.Value]; fn != nil && !used[fn] {
+				used[fn] = true
+				queue = append(queue, fn.Body)
+			}
+		}
+	}
+	var result []*StoredFunction
+	for _, fn := range functions {
+		if used[fn] {
+			result = append(result, fn)
+		}
+	}
+	return result
+}
+
+// functionSyntaxError returns an error for the first syntax error in err,
+// which was found in text that starts at the given offset in fn's body.
+func functionSyntaxError(fn *StoredFunction, err error, offset int) error {
+	bodyErr := err
+	if diags := parser.Diagnostics(err); len(diags) > 0 && diags[0].Span.IsValid() {
+		span := diags[0].Span
+		span.Start = max(span.Start+offset, 0)
+		span.End = max(span.End+offset, span.Start)
+		bodyErr = &compileError{
+			source: fn.Body,
+			span:   span,
+			err:    errors.New(diags[0].Message),
+			code:   diags[0].Code,
+		}
+	}
+	return &compileError{
+		span: parser.Span{Start: -1, End: -1},
+		err:  fmt.Errorf("function %s: %v", fn.Name, bodyErr),
+		code: parser.CodeSyntax,
+	}
+}
+
+// isSimpleIdentifier reports whether name can be written as an unquoted identifier.
+func isSimpleIdentifier(name string) bool {
+	t := parser.NewTokenizer(name)
+	return t.Next() &&
+		t.Token().Kind == parser.TokenIdentifier &&
+		t.Token().Span == parser.Span{Start: 0, End: len(name)}
+}
+
+// compileWithFunctions implements [*CompileOptions.Compile]
+// for options with stored functions.
+// It compiles source preceded by let statements for the functions
+// and reports positions in the result relative to source.
+func (opts *CompileOptions) compileWithFunctions(ctx context.Context, source string, plan *Plan) (string, error) {
+	// Report syntax errors in source relative to source.
+	if _, err := parser.Parse(source); err != nil {
+		return "", err
+	}
+	prelude, segments, err := opts.functionPrelude(source)
+	if err != nil {
+		return "", err
+	}
+	n := len(prelude)
+	shift := func(span parser.Span) (parser.Span, bool) {
+		if !span.IsValid() || span.Start < n {
+			return parser.Span{Start: -1, End: -1}, false
+		}
+		return parser.Span{Start: span.Start - n, End: span.End - n}, true
+	}
+
+	innerOpts := *opts
+	innerOpts.Functions = nil
+	if opts.Warn != nil {
+		innerOpts.Warn = func(diag parser.Diagnostic) {
+			if span, ok := shift(diag.Span); ok {
+				diag.Span = span
+				opts.Warn(diag)
+			}
+		}
+	}
+	sql, err := innerOpts.compile(ctx, prelude+source, plan)
+	if err != nil {
+		var cerr *compileError
+		if !errors.As(err, &cerr) {
+			return "", err
+		}
+		if span, ok := shift(cerr.span); ok || !cerr.span.IsValid() {
+			return "", &compileError{source: source, span: span, err: cerr.err, code: cerr.code}
+		}
+		for _, seg := range segments {
+			if seg.start <= cerr.span.Start && cerr.span.End <= seg.start+seg.bodyLength {
+				bodyErr := &compileError{
+					source: seg.fn.Body,
+					span: parser.Span{
+						Start: cerr.span.Start - seg.start + seg.bodyStart,
+						End:   cerr.span.End - seg.start + seg.bodyStart,
+					},
+					err:  cerr.err,
+					code: cerr.code,
+				}
+				return "", &compileError{
+					source: source,
+					span:   parser.Span{Start: -1, End: -1},
+					err:    fmt.Errorf("function %s: %v", seg.fn.Name, bodyErr),
+					code:   cerr.code,
+				}
+			}
+		}
+		return "", &compileError{source: source, span: parser.Span{Start: -1, End: -1}, err: cerr.err, code: cerr.code}
+	}
+	if plan != nil {
+		for _, stage := range plan.Stages {
+			stage.Operators = shiftSpans(stage.Operators, shift)
+			stage.Sort, _ = shift(stage.Sort)
+			stage.Take, _ = shift(stage.Take)
+		}
+	}
+	return sql, nil
+}
+
+// shiftSpans returns the spans that shift maps into the query's source.
+func shiftSpans(spans []parser.Span, shift func(parser.Span) (parser.Span, bool)) []parser.Span {
+	var result []parser.Span
+	for _, span := range spans {
+		if shifted, ok := shift(span); ok {
+			result = append(result, shifted)
+		}
+	}
+	return result
+}
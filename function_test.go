@@ -0,0 +1,93 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql/parser"
+)
+
+func TestCompileFunctions(t *testing.T) {
+	functions := []*StoredFunction{
+		{Name: "Threshold", Body: "10"},
+		{Name: "Failures", Body: "let code = 0;\nSignins | where Result != code"},
+		{Name: "Broken", Body: "T | take -1"},
+		{Name: "Invalid", Body: "let x = 1;\nx +"},
+	}
+	tests := []struct {
+		name     string
+		source   string
+		want     string
+		wantErr  string
+		wantDiag []parser.Diagnostic
+	}{
+		{
+			name:   "Tabular",
+			source: "Failures | where Count > Threshold",
+			want: `WITH "Failures" AS (SELECT * FROM "Signins" WHERE coalesce("Result" <> 0, FALSE))` + "\n" +
+				`SELECT * FROM "Failures" WHERE "Count" > 10;`,
+		},
+		{
+			name:   "Shadowed",
+			source: "let Threshold = 5;\nT | where x > Threshold",
+			want:   `SELECT * FROM "T" WHERE "x" > 5;`,
+		},
+		{
+			name:    "ErrorInQuery",
+			source:  "Failures | take -1",
+			wantErr: "1:17: row count must not be negative (got -1)",
+			wantDiag: []parser.Diagnostic{{
+				Span:     parser.Span{Start: 16, End: 18},
+				Severity: parser.SeverityError,
+				Code:     CodeInvalidRowCount,
+				Message:  "row count must not be negative (got -1)",
+			}},
+		},
+		{
+			name:    "ErrorInFunction",
+			source:  "Broken",
+			wantErr: "function Broken: 1:10: row count must not be negative (got -1)",
+			wantDiag: []parser.Diagnostic{{
+				Span:     parser.Span{Start: -1, End: -1},
+				Severity: parser.SeverityError,
+				Code:     CodeInvalidRowCount,
+				Message:  "function Broken: 1:10: row count must not be negative (got -1)",
+			}},
+		},
+		{
+			name:    "SyntaxErrorInFunction",
+			source:  "T | where Invalid",
+			wantErr: "function Invalid: 2:4: expected expression, got EOF",
+			wantDiag: []parser.Diagnostic{{
+				Span:     parser.Span{Start: -1, End: -1},
+				Severity: parser.SeverityError,
+				Code:     parser.CodeSyntax,
+				Message:  "function Invalid: 2:4: expected expression, got EOF",
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &CompileOptions{Functions: functions}
+			got, err := opts.Compile(test.source)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Errorf("Compile(%q) error = %v; want %s", test.source, err, test.wantErr)
+				}
+				if diff := cmp.Diff(test.wantDiag, parser.Diagnostics(err)); diff != "" {
+					t.Errorf("Diagnostics (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Compile(%q) (-want +got):\n%s", test.source, diff)
+			}
+		})
+	}
+}
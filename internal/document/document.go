@@ -0,0 +1,210 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package document compiles and formats files
+// that contain several statements separated by semicolons,
+// for use by the pql command and the language server.
+package document
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/parser"
+)
+
+// Compile compiles each statement in source
+// after the let statements that precede it,
+// passes the SQL for each query to write,
+// and returns the problems it finds with spans relative to source.
+// If write is nil, Compile only checks the statements.
+// The context is passed to the Tracer and to the AnalysisContext's [pql.TableProvider], if any.
+// If opts has an AnalysisContext,
+// references to tables and columns that it does not contain are also reported
+// and the SQL for those statements is not written.
+func Compile(ctx context.Context, opts *pql.CompileOptions, source string, write func(sql string)) []parser.Diagnostic {
+	stmtOpts := new(pql.CompileOptions)
+	if opts != nil {
+		*stmtOpts = *opts
+	}
+
+	var diags []parser.Diagnostic
+	prelude := new(strings.Builder)
+	offset := 0
+	for _, stmt := range parser.SplitStatements(source) {
+		stmtStart := offset
+		offset += len(stmt) + len(";")
+		tokens := parser.Scan(stmt)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		// The statement is compiled after the let statements before it,
+		// so spans must be shifted back to the statement's position in source.
+		preludeLen := prelude.Len()
+		add := func(diag parser.Diagnostic) {
+			if diag.Span.IsValid() && diag.Span.Start >= preludeLen && diag.Span.End <= preludeLen+len(stmt) {
+				diag.Span.Start += stmtStart - preludeLen
+				diag.Span.End += stmtStart - preludeLen
+			} else {
+				diag.Span = parser.Span{Start: -1, End: -1}
+			}
+			diags = append(diags, diag)
+		}
+		stmtOpts.Warn = add
+
+		isLet := tokens[0].Kind == parser.TokenIdentifier && tokens[0].Value == "let"
+		text := prelude.String() + stmt
+		if isLet {
+			text += ";X"
+		}
+		sql, err := stmtOpts.CompileContext(ctx, text)
+		if err != nil {
+			for _, diag := range parser.Diagnostics(err) {
+				add(diag)
+			}
+			continue
+		}
+		// Statements that refer to tables or columns that are not in the schema
+		// are reported instead of being written.
+		if opts != nil && opts.AnalysisContext != nil {
+			checkDiags, err := opts.AnalysisContext.Check(ctx, prelude.String()+stmt)
+			checkDiags = append(checkDiags, parser.Diagnostics(err)...)
+			hasError := false
+			for _, diag := range checkDiags {
+				if diag.Span.IsValid() && diag.Span.Start < preludeLen {
+					// Reported with the let statement.
+					continue
+				}
+				add(diag)
+				hasError = hasError || diag.Severity == parser.SeverityError
+			}
+			if hasError && !isLet {
+				continue
+			}
+		}
+		if !isLet && write != nil {
+			write(sql)
+		}
+
+		if isLet {
+			prelude.WriteString(stmt)
+			prelude.WriteString(";\n")
+		}
+	}
+	slices.SortStableFunc(diags, func(a, b parser.Diagnostic) int {
+		return a.Span.Start - b.Span.Start
+	})
+	return diags
+}
+
+// Format formats each statement in source with [parser.Format].
+// Comments between statements are kept as-is,
+// and statements that contain comments are not reformatted,
+// since the syntax tree does not include comments.
+// Runs of blank lines between statements are reduced to one.
+func Format(source string) (string, error) {
+	sb := new(strings.Builder)
+	parts := parser.SplitStatements(source)
+	for i, part := range parts {
+		terminated := i < len(parts)-1
+
+		// Separate the comments and blank lines before the statement
+		// from its body.
+		bodyStart := len(part)
+		for _, tok := range parser.ScanFull(part) {
+			if tok.Kind != parser.TokenWhitespace && tok.Kind != parser.TokenComment {
+				bodyStart = tok.Span.Start
+				break
+			}
+		}
+		lines := strings.Split(part[:bodyStart], "\n")
+		if i > 0 {
+			// A comment on the same line as the previous semicolon stays there.
+			if c := strings.TrimSpace(lines[0]); c != "" {
+				sb.WriteString(" ")
+				sb.WriteString(c)
+			}
+			sb.WriteString("\n")
+			lines = lines[1:]
+		}
+		if len(lines) > 0 {
+			// The last line is the indentation before the body.
+			lines = lines[:len(lines)-1]
+		}
+		blank := false
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				blank = true
+				continue
+			}
+			if blank && sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			blank = false
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		}
+
+		body := strings.TrimSpace(part[bodyStart:])
+		if body == "" {
+			continue
+		}
+		// Comments after the last statement follow it on their own lines.
+		var trailer string
+		if !terminated {
+			bodyEnd := 0
+			for _, tok := range parser.ScanFull(body) {
+				if tok.Kind != parser.TokenWhitespace && tok.Kind != parser.TokenComment {
+					bodyEnd = tok.Span.End
+				}
+			}
+			body, trailer = body[:bodyEnd], strings.TrimSpace(body[bodyEnd:])
+		}
+		if blank && sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		stmts, err := parser.Parse(body)
+		if err != nil {
+			return "", err
+		}
+		formatted := body
+		bodyTokens := parser.ScanFull(body)
+		if !hasComments(bodyTokens) {
+			formatted, err = parser.Format(stmts[0])
+			if err != nil {
+				return "", err
+			}
+		}
+		sb.WriteString(formatted)
+		if terminated {
+			if last := bodyTokens[len(bodyTokens)-1]; last.Kind == parser.TokenComment {
+				// Keep the semicolon out of a trailing line comment.
+				sb.WriteString("\n")
+			}
+			sb.WriteString(";")
+		} else {
+			sb.WriteString("\n")
+			if trailer != "" {
+				sb.WriteString(trailer)
+				sb.WriteString("\n")
+			}
+		}
+	}
+	if n := sb.Len(); n > 0 && !strings.HasSuffix(sb.String(), "\n") {
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+func hasComments(tokens []parser.Token) bool {
+	for _, tok := range tokens {
+		if tok.Kind == parser.TokenComment {
+			return true
+		}
+	}
+	return false
+}
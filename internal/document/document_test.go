@@ -0,0 +1,115 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package document
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql"
+)
+
+func TestCompile(t *testing.T) {
+	opts := &pql.CompileOptions{
+		AnalysisContext: &pql.AnalysisContext{
+			Tables: map[string]*pql.AnalysisTable{
+				"T": {Columns: []*pql.AnalysisColumn{{Name: "a"}}},
+			},
+		},
+	}
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "Valid",
+			source: "let U = T | take 1;\nU | join (T) on a | as V | join (V) on a\n",
+		},
+		{
+			name:   "SyntaxError",
+			source: "T;\nT | where |;\nT",
+			want:   []string{"error syntax |", "error syntax "},
+		},
+		{
+			name:   "UnknownTable",
+			source: "let x = 1;\nNope | where a > x",
+			want:   []string{"error unknown-table Nope"},
+		},
+		{
+			name:   "UnknownColumn",
+			source: "let U = T | where b > 1;\nU | project a, c",
+			want:   []string{"error unknown-column b", "error unknown-column c"},
+		},
+		{
+			name:   "Warning",
+			source: "T | join hint.strategy=broadcast (T) on a",
+			want:   []string{"warning ignored-hint hint.strategy=broadcast"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, diag := range Compile(context.Background(), opts, test.source, nil) {
+				text := ""
+				if diag.Span.IsValid() {
+					text = test.source[diag.Span.Start:diag.Span.End]
+				}
+				got = append(got, fmt.Sprintf("%v %s %s", diag.Severity, diag.Code, text))
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Compile(opts, %q, nil) (-want +got):\n%s", test.source, diff)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "Empty",
+			source: "",
+			want:   "",
+		},
+		{
+			name:   "Statements",
+			source: "let   x=1;\nT|filter a>x|take 5",
+			want:   "let x = 1;\nT\n| where a > x\n| take 5\n",
+		},
+		{
+			name:   "Comments",
+			source: "// Header\n\n\nlet x = 1; // the x\n\n// Query\nT | take x\n// Trailer\n",
+			want:   "// Header\n\nlet x = 1; // the x\n\n// Query\nT\n| take x\n// Trailer\n",
+		},
+		{
+			name:   "InteriorComment",
+			source: "T  |  take 1 // keep\n;\nU",
+			want:   "T  |  take 1 // keep\n;\nU\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Format(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Format(%q) (-want +got):\n%s", test.source, diff)
+			}
+			again, err := Format(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != got {
+				t.Errorf("Format(%q) = %q; not idempotent", got, again)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// A KQLFeature is a construct in a query
+// whose behavior has been compared with Microsoft's Kusto Query Language (KQL),
+// like an operator, a function call, or a comparison.
+type KQLFeature struct {
+	// Name identifies the construct, like "where", "join kind=inner", or "function strcat".
+	Name string
+	// Span is the location of the construct in the source.
+	Span parser.Span
+	// Divergence describes how the SQL that pql writes for the construct
+	// differs from the construct's meaning in KQL.
+	// It is empty if the construct behaves the same in both.
+	Divergence string
+}
+
+// Compatible reports whether the feature behaves the same in pql and KQL.
+func (f *KQLFeature) Compatible() bool {
+	return f.Divergence == ""
+}
+
+// KQLFeatures returns the operators, function calls, and comparisons in source
+// in the order they appear, along with any differences in their behavior
+// from KQL when compiled with opts,
+// to help migrate queries from Azure Data Explorer or Microsoft Sentinel.
+// KQLFeatures returns an error if source cannot be parsed.
+func (opts *CompileOptions) KQLFeatures(source string) ([]*KQLFeature, error) {
+	stmts, err := parser.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	return opts.kqlFeatures(source, stmts), nil
+}
+
+// kqlFeatures implements [*CompileOptions.KQLFeatures].
+func (opts *CompileOptions) kqlFeatures(source string, stmts []parser.Statement) []*KQLFeature {
+	var features []*KQLFeature
+	add := func(name string, span parser.Span, format string, args ...any) {
+		f := &KQLFeature{Name: name, Span: span}
+		if format != "" {
+			f.Divergence = fmt.Sprintf(format, args...)
+		}
+		features = append(features, f)
+	}
+	var stringComparison StringComparison
+	threeValued, sortLower := false, false
+	if opts != nil {
+		stringComparison = opts.StringComparison
+		threeValued = opts.ThreeValuedComparisons
+		sortLower = opts.CaseInsensitiveSort || opts.SortCollation != ""
+	}
+	sortTerms := func(name string, span parser.Span, terms ...*parser.SortTerm) {
+		if sortLower && len(terms) > 0 {
+			add(name, span, "%s orders strings case-insensitively or by collation instead of by ordinal", name)
+		} else {
+			add(name, span, "")
+		}
+	}
+
+	for _, stmt := range stmts {
+		parser.Walk(stmt, func(n parser.Node) bool {
+			switch n := n.(type) {
+			case *parser.WhereOperator:
+				add("where", n.Keyword, "")
+			case *parser.SortOperator:
+				sortTerms("sort", n.Keyword, n.Terms...)
+			case *parser.TakeOperator:
+				add("take", n.Keyword, "")
+			case *parser.TopOperator:
+				sortTerms("top", n.Keyword, n.Col)
+			case *parser.CountOperator:
+				add("count", n.Keyword, "count names its column %q instead of Count", "count()")
+			case *parser.ProjectOperator:
+				add("project", n.Keyword, "")
+			case *parser.ProjectAwayOperator:
+				add("project-away", n.Keyword, "")
+			case *parser.DistinctOperator:
+				add("distinct", n.Keyword, "")
+			case *parser.ExtendOperator:
+				add("extend", n.Keyword, "")
+				for _, col := range n.Cols {
+					derivedName(add, col.Name, col.X)
+				}
+			case *parser.MvExpandOperator:
+				add("mv-expand", n.Keyword, "mv-expand drops rows whose arrays are empty instead of keeping them with null values")
+			case *parser.SummarizeOperator:
+				add("summarize", n.Keyword, "")
+				for _, col := range n.Cols {
+					derivedName(add, col.Name, col.X)
+				}
+				for _, col := range n.GroupBy {
+					derivedName(add, col.Name, col.X)
+				}
+			case *parser.JoinOperator:
+				switch name := joinFlavorName(n); name {
+				case "innerunique":
+					add("join kind=innerunique", n.Keyword,
+						"innerunique removes duplicate rows from the left side instead of keeping one row per join key")
+				default:
+					add("join kind="+name, n.Keyword, "")
+				}
+			case *parser.UnionOperator:
+				add("union", n.Keyword, "union combines columns by position instead of by name")
+			case *parser.UnionSource:
+				add("union", n.Keyword, "union combines columns by position instead of by name")
+			case *parser.RenderOperator:
+				add("render", n.Keyword, "render adds columns describing the chart instead of only affecting how results are displayed")
+			case *parser.AsOperator:
+				add("as", n.Keyword, "")
+			case *parser.BinaryExpr:
+				switch n.Op {
+				case parser.TokenEq, parser.TokenNE:
+					op := source[n.OpSpan.Start:n.OpSpan.End]
+					switch {
+					case stringComparison == CaseInsensitiveStringComparison:
+						add(op, n.OpSpan, "%s compares strings case-insensitively", op)
+					case threeValued:
+						add(op, n.OpSpan, "%s produces null instead of false when an operand is null", op)
+					default:
+						add(op, n.OpSpan, "")
+					}
+				case parser.TokenCaseInsensitiveEq, parser.TokenCaseInsensitiveNE:
+					op := source[n.OpSpan.Start:n.OpSpan.End]
+					if stringComparison == CollationStringComparison {
+						add(op, n.OpSpan, "%s depends on the database's collation to ignore case", op)
+					} else {
+						add(op, n.OpSpan, "")
+					}
+				}
+			case *parser.InExpr:
+				if stringComparison == CaseInsensitiveStringComparison {
+					add("in", n.In, "in compares strings case-insensitively")
+				} else {
+					add("in", n.In, "")
+				}
+			case *parser.CallExpr:
+				name := n.Func.Name
+				switch {
+				case initKnownFunctions()[name] != nil || kqlPassThroughFunctions[name]:
+					add("function "+name, n.Func.NameSpan, "")
+				default:
+					add("function "+name, n.Func.NameSpan,
+						"%s is passed to the database unchanged, so it may not exist or may behave differently than in KQL", name)
+				}
+			}
+			return true
+		})
+	}
+	slices.SortStableFunc(features, func(f1, f2 *KQLFeature) int {
+		return f1.Span.Start - f2.Span.Start
+	})
+	return features
+}
+
+// derivedName reports an unnamed column in an extend or summarize operator
+// whose name pql derives from its source text.
+// KQL generates names like Column1 or count_ for such columns instead.
+func derivedName(add func(name string, span parser.Span, format string, args ...any), name *parser.Ident, x parser.Expr) {
+	if name != nil || x == nil {
+		return
+	}
+	if _, isColumn := x.(*parser.QualifiedIdent); isColumn {
+		return
+	}
+	add("column name", x.Span(), "column is named after its expression instead of the name KQL generates")
+}
+
+// kqlPassThroughFunctions is the set of functions that pql writes unchanged
+// and that have the same meaning in KQL and in the supported databases.
+var kqlPassThroughFunctions = map[string]bool{
+	"abs":  true,
+	"avg":  true,
+	"max":  true,
+	"min":  true,
+	"sqrt": true,
+	"sum":  true,
+}
+
+// warnKQLDivergences reports the features of stmts that differ from KQL to opts.Warn.
+func (opts *CompileOptions) warnKQLDivergences(source string, stmts []parser.Statement) {
+	for _, f := range opts.kqlFeatures(source, stmts) {
+		if !f.Compatible() {
+			opts.warn(f.Span, CodeKQLDivergence, "%s", f.Divergence)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql/parser"
+)
+
+func TestKQLFeatures(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   *CompileOptions
+		source string
+		want   []*KQLFeature
+	}{
+		{
+			name:   "Compatible",
+			source: "T | where x == 1 and y in ('a') | sort by x | take 5",
+			want: []*KQLFeature{
+				{Name: "where", Span: parser.Span{Start: 4, End: 9}},
+				{Name: "==", Span: parser.Span{Start: 12, End: 14}},
+				{Name: "in", Span: parser.Span{Start: 23, End: 25}},
+				{Name: "sort", Span: parser.Span{Start: 34, End: 41}},
+				{Name: "take", Span: parser.Span{Start: 46, End: 50}},
+			},
+		},
+		{
+			name:   "Divergent",
+			source: "T | join (U) on k | count",
+			want: []*KQLFeature{
+				{
+					Name:       "join kind=innerunique",
+					Span:       parser.Span{Start: 4, End: 8},
+					Divergence: "innerunique removes duplicate rows from the left side instead of keeping one row per join key",
+				},
+				{
+					Name:       "count",
+					Span:       parser.Span{Start: 20, End: 25},
+					Divergence: `count names its column "count()" instead of Count`,
+				},
+			},
+		},
+		{
+			name:   "Functions",
+			source: "T | summarize sum(x), n = dcount(y) by k",
+			want: []*KQLFeature{
+				{Name: "summarize", Span: parser.Span{Start: 4, End: 13}},
+				{
+					Name:       "column name",
+					Span:       parser.Span{Start: 14, End: 20},
+					Divergence: "column is named after its expression instead of the name KQL generates",
+				},
+				{Name: "function sum", Span: parser.Span{Start: 14, End: 17}},
+				{
+					Name:       "function dcount",
+					Span:       parser.Span{Start: 26, End: 32},
+					Divergence: "dcount is passed to the database unchanged, so it may not exist or may behave differently than in KQL",
+				},
+			},
+		},
+		{
+			name:   "Options",
+			opts:   &CompileOptions{ThreeValuedComparisons: true, StringComparison: CollationStringComparison},
+			source: "T | where x != 1 or y =~ 'a'",
+			want: []*KQLFeature{
+				{Name: "where", Span: parser.Span{Start: 4, End: 9}},
+				{
+					Name:       "!=",
+					Span:       parser.Span{Start: 12, End: 14},
+					Divergence: "!= produces null instead of false when an operand is null",
+				},
+				{
+					Name:       "=~",
+					Span:       parser.Span{Start: 22, End: 24},
+					Divergence: "=~ depends on the database's collation to ignore case",
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.opts.KQLFeatures(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("KQLFeatures(%q) (-want +got):\n%s", test.source, diff)
+			}
+		})
+	}
+}
+
+func TestCompileKQLCompatibility(t *testing.T) {
+	var got []parser.Diagnostic
+	opts := &CompileOptions{
+		KQLCompatibility: true,
+		Warn: func(diag parser.Diagnostic) {
+			got = append(got, diag)
+		},
+	}
+	const source = "T | where x == 1 | count"
+	if _, err := opts.Compile(source); err != nil {
+		t.Fatal(err)
+	}
+	want := []parser.Diagnostic{{
+		Span:     parser.Span{Start: 19, End: 24},
+		Severity: parser.SeverityWarning,
+		Code:     CodeKQLDivergence,
+		Message:  `count names its column "count()" instead of Count`,
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Compile(%q) warnings (-want +got):\n%s", source, diff)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// LocateSQL returns the span of the query that compiled to the SQL
+// at the given byte offset in plan.SQL.
+// The span covers the operators of the innermost stage
+// whose SELECT statement contains the offset.
+// LocateSQL returns false if the offset is outside every stage
+// that was compiled from operators,
+// like the final semicolon or a stage that only reads a table.
+func (plan *Plan) LocateSQL(offset int) (parser.Span, bool) {
+	var found *PlanStage
+	for _, stage := range plan.Stages {
+		span := stage.sqlSpan
+		if !span.IsValid() || offset < span.Start || offset >= span.End || len(stage.Operators) == 0 {
+			continue
+		}
+		// Inlined subqueries are nested inside the stages that read them.
+		if found == nil || span.Len() < found.sqlSpan.Len() {
+			found = stage
+		}
+	}
+	if found == nil {
+		return parser.Span{Start: -1, End: -1}, false
+	}
+	span := found.Operators[0]
+	for _, op := range found.Operators[1:] {
+		span.End = max(span.End, op.End)
+	}
+	return span, true
+}
+
+// Patterns for the positions that databases report in error messages.
+var (
+	// ClickHouse reports "(line 1, col 15)" with a 1-based column in bytes.
+	sqlLineColumnPattern = regexp.MustCompile(`\(line (\d+), col (\d+)\)`)
+	// ClickHouse reports "failed at position 15" with a 1-based offset in bytes.
+	sqlBytePositionPattern = regexp.MustCompile(`\bat position (\d+)\b`)
+	// Postgres reports "at character 15" with a 1-based offset in characters.
+	sqlCharPositionPattern = regexp.MustCompile(`\bat character (\d+)\b`)
+)
+
+// LocateError finds the position in the SQL that a database error message refers to
+// and returns the span and text of the part of the query that compiled to it,
+// so that the error can be reported in terms of the query instead of the generated SQL.
+// It recognizes ClickHouse messages like "failed at position 15 (line 1, col 15)"
+// and Postgres messages like "syntax error at or near "x" at character 15".
+// The positions must refer to SQL that starts with plan.SQL.
+//
+// Some drivers report Postgres positions in a field instead of the message,
+// like the Position field of pgconn.PgError.
+// Such a position is a 1-based count of characters,
+// which must be converted to a byte offset before calling [*Plan.LocateSQL].
+func (plan *Plan) LocateError(err error) (span parser.Span, snippet string, ok bool) {
+	offset, ok := sqlErrorOffset(plan.SQL, err.Error())
+	if !ok {
+		return parser.Span{Start: -1, End: -1}, "", false
+	}
+	span, ok = plan.LocateSQL(offset)
+	if !ok || span.End > len(plan.source) {
+		return parser.Span{Start: -1, End: -1}, "", false
+	}
+	return span, plan.source[span.Start:span.End], true
+}
+
+// sqlErrorOffset returns the byte offset in sql of the position in msg.
+func sqlErrorOffset(sql, msg string) (int, bool) {
+	if m := sqlLineColumnPattern.FindStringSubmatch(msg); m != nil {
+		line, err1 := strconv.Atoi(m[1])
+		col, err2 := strconv.Atoi(m[2])
+		if err1 != nil || err2 != nil || line < 1 || col < 1 {
+			return 0, false
+		}
+		offset := 0
+		for ; line > 1; line-- {
+			i := strings.IndexByte(sql[offset:], '\n')
+			if i < 0 {
+				return 0, false
+			}
+			offset += i + 1
+		}
+		offset += col - 1
+		return offset, offset < len(sql)
+	}
+	if m := sqlBytePositionPattern.FindStringSubmatch(msg); m != nil {
+		pos, err := strconv.Atoi(m[1])
+		if err != nil || pos < 1 || pos > len(sql) {
+			return 0, false
+		}
+		return pos - 1, true
+	}
+	if m := sqlCharPositionPattern.FindStringSubmatch(msg); m != nil {
+		pos, err := strconv.Atoi(m[1])
+		if err != nil || pos < 1 {
+			return 0, false
+		}
+		offset := 0
+		for ; pos > 1 && offset < len(sql); pos-- {
+			_, size := utf8.DecodeRuneInString(sql[offset:])
+			offset += size
+		}
+		return offset, offset < len(sql)
+	}
+	return 0, false
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/parser"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// readMessage reads the body of the next message from r.
+// Messages are a set of headers followed by a blank line and the body,
+// whose size is given by the Content-Length header.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for first := true; ; first = false {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && first && line == "" {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read message header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid message header %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message missing Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("read message: %w", err)
+	}
+	return body, nil
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type textDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type documentParams struct {
+	TextDocument   textDocumentItem `json:"textDocument"`
+	Position       position         `json:"position"`
+	NewName        string           `json:"newName"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type diagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type textEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type completionItem struct {
+	Label         string    `json:"label"`
+	Kind          int       `json:"kind,omitempty"`
+	Detail        string    `json:"detail,omitempty"`
+	Documentation string    `json:"documentation,omitempty"`
+	TextEdit      *textEdit `json:"textEdit,omitempty"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    lspRange      `json:"range"`
+}
+
+type documentSymbol struct {
+	Name           string   `json:"name"`
+	Detail         string   `json:"detail,omitempty"`
+	Kind           int      `json:"kind"`
+	Range          lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+}
+
+type workspaceEdit struct {
+	Changes map[string][]*textEdit `json:"changes"`
+}
+
+// rangeFor converts a span of text to a range.
+// Invalid spans become an empty range at the start of the document.
+func rangeFor(text string, span parser.Span) lspRange {
+	if !span.IsValid() {
+		return lspRange{}
+	}
+	return lspRange{
+		Start: positionFor(text, span.Start),
+		End:   positionFor(text, span.End),
+	}
+}
+
+// positionFor returns the position of the byte offset in text.
+// Positions count characters in UTF-16 code units.
+func positionFor(text string, offset int) position {
+	offset = min(max(offset, 0), len(text))
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	n := 0
+	for _, c := range text[lineStart:offset] {
+		n += utf16Len(c)
+	}
+	return position{
+		Line:      strings.Count(text[:lineStart], "\n"),
+		Character: n,
+	}
+}
+
+// offsetFor returns the byte offset in text of pos.
+// Positions past the end of a line refer to the end of the line.
+func offsetFor(text string, pos position) int {
+	offset := 0
+	for i := 0; i < pos.Line; i++ {
+		j := strings.IndexByte(text[offset:], '\n')
+		if j < 0 {
+			return len(text)
+		}
+		offset += j + 1
+	}
+	n := 0
+	for i, c := range text[offset:] {
+		if c == '\n' || n >= pos.Character {
+			return offset + i
+		}
+		n += utf16Len(c)
+	}
+	return len(text)
+}
+
+// utf16Len returns the number of UTF-16 code units that encode c.
+func utf16Len(c rune) int {
+	if c >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// completionKind returns the CompletionItemKind for kind.
+func completionKind(kind pql.CompletionKind) int {
+	switch kind {
+	case pql.CompletionTable:
+		return 7 // Class
+	case pql.CompletionColumn:
+		return 5 // Field
+	case pql.CompletionFunction:
+		return 3 // Function
+	case pql.CompletionKeyword, pql.CompletionOperator:
+		return 14 // Keyword
+	case pql.CompletionVariable:
+		return 6 // Variable
+	case pql.CompletionField:
+		return 10 // Property
+	case pql.CompletionDatabase:
+		return 9 // Module
+	default:
+		return 0
+	}
+}
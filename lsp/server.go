@@ -0,0 +1,291 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package lsp provides a Language Server Protocol server
+// for Pipeline Query Language documents.
+//
+// The server supports full document synchronization, diagnostics,
+// completion, hover, document symbols, formatting, and renaming let statements.
+// It is used by the pql lsp command
+// and can be embedded in other servers that speak the protocol,
+// for example over a WebSocket.
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/internal/document"
+	"github.com/runreveal/pql/parser"
+)
+
+// A Server answers Language Server Protocol requests from a single client.
+type Server struct {
+	opts *pql.CompileOptions
+	// docs maps the URIs of open documents to their text.
+	docs map[string]string
+	// sessions maps the URIs of open documents to their completion sessions.
+	sessions map[string]*pql.CompletionSession
+	output   io.Writer
+}
+
+// NewServer returns a new server that compiles documents with opts.
+// If opts has an AnalysisContext, it is used for completion and hover
+// and references to tables and columns that it does not contain are reported.
+func NewServer(opts *pql.CompileOptions) *Server {
+	if opts == nil {
+		opts = new(pql.CompileOptions)
+	}
+	return &Server{
+		opts:     opts,
+		docs:     make(map[string]string),
+		sessions: make(map[string]*pql.CompletionSession),
+	}
+}
+
+// errExit is returned by [*Server.call] when the client sends
+// the exit notification.
+var errExit = errors.New("exit")
+
+// Serve reads messages from input and writes responses and notifications
+// to output until input ends, the client sends the exit notification,
+// or ctx is canceled.
+// Messages are framed with the protocol's Content-Length headers.
+func (s *Server) Serve(ctx context.Context, output io.Writer, input io.Reader) error {
+	s.output = output
+	r := bufio.NewReader(input)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		body, err := readMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.handle(ctx, body); err == errExit {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) write(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.output, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+// handle answers a single message.
+func (s *Server) handle(ctx context.Context, body []byte) error {
+	resp := &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	req := new(rpcRequest)
+	if err := json.Unmarshal(body, req); err != nil {
+		resp.Error = &rpcError{Code: rpcParseError, Message: err.Error()}
+		return s.write(resp)
+	}
+	result, err := s.call(ctx, req.Method, req.Params)
+	if err == errExit {
+		return err
+	}
+	if len(req.ID) == 0 {
+		// Errors handling notifications can't be reported.
+		return nil
+	}
+	resp.ID = req.ID
+	if err != nil {
+		rpcErr := new(rpcError)
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	} else if result == nil {
+		resp.Result = json.RawMessage("null")
+	} else {
+		resp.Result = result
+	}
+	return s.write(resp)
+}
+
+func (s *Server) call(ctx context.Context, method string, rawParams json.RawMessage) (any, error) {
+	params := new(documentParams)
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+	}
+	uri := params.TextDocument.URI
+
+	switch method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				// Clients send the full text of documents when they change.
+				"textDocumentSync":           1,
+				"completionProvider":         map[string]any{},
+				"hoverProvider":              true,
+				"documentSymbolProvider":     true,
+				"documentFormattingProvider": true,
+				"renameProvider":             true,
+			},
+			"serverInfo": map[string]any{"name": "pql"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		return nil, nil
+	case "exit":
+		return nil, errExit
+	case "textDocument/didOpen":
+		s.docs[uri] = params.TextDocument.Text
+		return nil, s.publishDiagnostics(ctx, uri)
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uri] = params.ContentChanges[n-1].Text
+		}
+		return nil, s.publishDiagnostics(ctx, uri)
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		delete(s.sessions, uri)
+		return nil, s.publishDiagnostics(ctx, uri)
+	case "textDocument/completion":
+		text, ok := s.docs[uri]
+		if !ok {
+			return []*completionItem{}, nil
+		}
+		pos := offsetFor(text, params.Position)
+		session := s.sessions[uri]
+		if session == nil {
+			session = s.opts.AnalysisContext.NewCompletionSession()
+			s.sessions[uri] = session
+		}
+		completions, err := session.SuggestCompletions(ctx, text, parser.Span{Start: pos, End: pos})
+		if err != nil {
+			return nil, err
+		}
+		items := make([]*completionItem, 0, len(completions))
+		for _, c := range completions {
+			items = append(items, &completionItem{
+				Label:         c.Label,
+				Kind:          completionKind(c.Kind),
+				Detail:        c.Detail,
+				Documentation: c.Documentation,
+				TextEdit: &textEdit{
+					Range:   rangeFor(text, c.Span),
+					NewText: c.Text,
+				},
+			})
+		}
+		return items, nil
+	case "textDocument/hover":
+		text, ok := s.docs[uri]
+		if !ok {
+			return nil, nil
+		}
+		pos := offsetFor(text, params.Position)
+		info, err := s.opts.AnalysisContext.Hover(ctx, text, pos)
+		if err != nil || info == nil {
+			return nil, err
+		}
+		value := info.Name
+		if info.Detail != "" {
+			value += ": " + info.Detail
+		}
+		if info.Kind == pql.CompletionFunction {
+			value = info.Detail
+		}
+		value = "```\n" + value + "\n```"
+		if info.Documentation != "" {
+			value += "\n\n" + info.Documentation
+		}
+		return &hover{
+			Contents: markupContent{Kind: "markdown", Value: value},
+			Range:    rangeFor(text, info.Span),
+		}, nil
+	case "textDocument/documentSymbol":
+		text, ok := s.docs[uri]
+		if !ok {
+			return []*documentSymbol{}, nil
+		}
+		return documentSymbols(text), nil
+	case "textDocument/formatting":
+		text, ok := s.docs[uri]
+		if !ok {
+			return []*textEdit{}, nil
+		}
+		formatted, err := document.Format(text)
+		if err != nil || formatted == text {
+			// Documents with syntax errors are left as they are.
+			return []*textEdit{}, nil
+		}
+		return []*textEdit{{
+			Range:   rangeFor(text, parser.Span{Start: 0, End: len(text)}),
+			NewText: formatted,
+		}}, nil
+	case "textDocument/rename":
+		text, ok := s.docs[uri]
+		if !ok {
+			return nil, nil
+		}
+		if !isIdentifier(params.NewName) {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("%q is not a valid name", params.NewName)}
+		}
+		spans := letReferences(text, offsetFor(text, params.Position))
+		if len(spans) == 0 {
+			return nil, nil
+		}
+		edits := make([]*textEdit, 0, len(spans))
+		for _, span := range spans {
+			edits = append(edits, &textEdit{
+				Range:   rangeFor(text, span),
+				NewText: params.NewName,
+			})
+		}
+		return &workspaceEdit{Changes: map[string][]*textEdit{uri: edits}}, nil
+	default:
+		if strings.HasPrefix(method, "$/") {
+			// Optional notifications like $/cancelRequest can be ignored.
+			return nil, nil
+		}
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", method)}
+	}
+}
+
+// publishDiagnostics sends the problems in the document with the given URI
+// to the client.
+// Closed documents have no problems.
+func (s *Server) publishDiagnostics(ctx context.Context, uri string) error {
+	diags := []*diagnostic{}
+	if text, ok := s.docs[uri]; ok {
+		for _, diag := range document.Compile(ctx, s.opts, text, nil) {
+			// Severities have the same values in the protocol.
+			diags = append(diags, &diagnostic{
+				Range:    rangeFor(text, diag.Span),
+				Severity: int(diag.Severity),
+				Code:     diag.Code,
+				Source:   "pql",
+				Message:  diag.Message,
+			})
+		}
+	}
+	return s.write(&rpcNotification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params: map[string]any{
+			"uri":         uri,
+			"diagnostics": diags,
+		},
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql"
+)
+
+func TestServer(t *testing.T) {
+	opts := &pql.CompileOptions{
+		AnalysisContext: &pql.AnalysisContext{
+			Tables: map[string]*pql.AnalysisTable{
+				"T": {Columns: []*pql.AnalysisColumn{{Name: "a", Type: "Int64"}}},
+			},
+		},
+	}
+	messages := []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///q.pql","text":"T\n| where b"}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///q.pql"},"contentChanges":[{"text":"T | where a"}]}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/completion","params":{"textDocument":{"uri":"file:///q.pql"},"position":{"line":0,"character":11}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///q.pql"},"position":{"line":0,"character":10}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/formatting","params":{"textDocument":{"uri":"file:///q.pql"}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+		`{"jsonrpc":"2.0","id":6,"method":"shutdown"}`,
+	}
+	input := new(strings.Builder)
+	for _, msg := range messages {
+		fmt.Fprintf(input, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	output := new(bytes.Buffer)
+	if err := NewServer(opts).Serve(context.Background(), output, strings.NewReader(input.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	r := bufio.NewReader(output)
+	for {
+		body, err := readMessage(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(body))
+	}
+	want := []string{
+		`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{"completionProvider":{},"documentFormattingProvider":true,"documentSymbolProvider":true,"hoverProvider":true,"renameProvider":true,"textDocumentSync":1},"serverInfo":{"name":"pql"}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"diagnostics":[{"range":{"start":{"line":1,"character":8},"end":{"line":1,"character":9}},"severity":1,"code":"unknown-column","source":"pql","message":"unknown column \"b\""}],"uri":"file:///q.pql"}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"diagnostics":[],"uri":"file:///q.pql"}}`,
+		`{"jsonrpc":"2.0","id":2,"result":[{"label":"a","kind":5,"detail":"Int64","textEdit":{"range":{"start":{"line":0,"character":10},"end":{"line":0,"character":11}},"newText":"a"}}]}`,
+		`{"jsonrpc":"2.0","id":3,"result":{"contents":{"kind":"markdown","value":"` + "```" + `\na: Int64\n` + "```" + `"},"range":{"start":{"line":0,"character":10},"end":{"line":0,"character":11}}}}`,
+		`{"jsonrpc":"2.0","id":4,"result":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":11}},"newText":"T\n| where a\n"}]}`,
+		`{"jsonrpc":"2.0","id":5,"result":null}`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("messages (-want +got):\n%s", diff)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	const text = "ab\n😀x\n"
+	tests := []struct {
+		offset int
+		pos    position
+	}{
+		{0, position{0, 0}},
+		{2, position{0, 2}},
+		{3, position{1, 0}},
+		{7, position{1, 2}},
+		{8, position{1, 3}},
+		{9, position{2, 0}},
+	}
+	for _, test := range tests {
+		if got := positionFor(text, test.offset); got != test.pos {
+			t.Errorf("positionFor(%q, %d) = %+v; want %+v", text, test.offset, got, test.pos)
+		}
+		if got := offsetFor(text, test.pos); got != test.offset {
+			t.Errorf("offsetFor(%q, %+v) = %d; want %d", text, test.pos, got, test.offset)
+		}
+	}
+	if got := offsetFor(text, position{0, 10}); got != 2 {
+		t.Errorf("offsetFor(%q, {0, 10}) = %d; want 2", text, got)
+	}
+}
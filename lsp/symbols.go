@@ -0,0 +1,135 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package lsp
+
+import (
+	"github.com/runreveal/pql/parser"
+)
+
+// Symbol kinds from the protocol.
+const (
+	symbolKindClass    = 5
+	symbolKindVariable = 13
+)
+
+// documentSymbols returns the let statements in source.
+// Tabular lets are reported as classes,
+// the same kind that completion uses for tables.
+func documentSymbols(source string) []*documentSymbol {
+	stmts, _ := parser.Parse(source)
+	symbols := []*documentSymbol{}
+	for _, stmt := range stmts {
+		let, ok := stmt.(*parser.LetStatement)
+		if !ok || let.Name == nil {
+			continue
+		}
+		sym := &documentSymbol{
+			Name:           let.Name.Name,
+			Kind:           symbolKindVariable,
+			Range:          rangeFor(source, let.Span()),
+			SelectionRange: rangeFor(source, let.Name.Span()),
+		}
+		if let.Tabular != nil {
+			sym.Kind = symbolKindClass
+		}
+		symbols = append(symbols, sym)
+	}
+	return symbols
+}
+
+// letReferences returns the spans in source
+// of the name bound by a let statement
+// and of every reference to that binding,
+// where the binding is either the let statement whose name is at pos
+// or the one that the identifier at pos refers to.
+// letReferences returns nil if there is no such binding.
+func letReferences(source string, pos int) []parser.Span {
+	stmts, _ := parser.Parse(source)
+	binding := -1
+	name := ""
+	// lets maps names to the index of the statement that last bound them.
+	lets := make(map[string]int)
+	for i, stmt := range stmts {
+		let, isLet := stmt.(*parser.LetStatement)
+		if isLet && let.Name != nil && containsPos(let.Name.Span(), pos) {
+			binding, name = i, let.Name.Name
+			break
+		}
+		if id := referenceAt(stmt, pos); id != nil {
+			if j, ok := lets[id.Name]; ok {
+				binding, name = j, id.Name
+			}
+			break
+		}
+		if isLet && let.Name != nil {
+			lets[let.Name.Name] = i
+		}
+	}
+	if binding < 0 {
+		return nil
+	}
+
+	spans := []parser.Span{stmts[binding].(*parser.LetStatement).Name.Span()}
+	for _, stmt := range stmts[binding+1:] {
+		walkReferences(stmt, func(id *parser.Ident) {
+			if id.Name == name {
+				spans = append(spans, id.Span())
+			}
+		})
+		if let, ok := stmt.(*parser.LetStatement); ok && let.Name != nil && let.Name.Name == name {
+			// Later statements refer to the new binding.
+			break
+		}
+	}
+	return spans
+}
+
+// referenceAt returns the identifier in stmt at pos
+// that could refer to a let statement,
+// or nil if there is none.
+func referenceAt(stmt parser.Statement, pos int) *parser.Ident {
+	var result *parser.Ident
+	walkReferences(stmt, func(id *parser.Ident) {
+		if containsPos(id.Span(), pos) {
+			result = id
+		}
+	})
+	return result
+}
+
+// walkReferences calls f for each identifier in stmt
+// that could refer to a let statement:
+// unquoted, unqualified names in expressions and as table names.
+// The name of a let statement is not a reference.
+func walkReferences(stmt parser.Statement, f func(id *parser.Ident)) {
+	parser.Walk(stmt, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.QualifiedIdent:
+			if len(n.Parts) == 1 && !n.Parts[0].Quoted {
+				f(n.Parts[0])
+			}
+			return false
+		case *parser.TableRef:
+			if n.Database == nil && n.Table != nil && !n.Table.Quoted {
+				f(n.Table)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+// containsPos reports whether span includes pos.
+// The end of the span is included so that the name before the cursor is found.
+func containsPos(span parser.Span, pos int) bool {
+	return span.IsValid() && span.Start <= pos && pos <= span.End
+}
+
+// isIdentifier reports whether name can be used as an unquoted identifier.
+func isIdentifier(name string) bool {
+	t := parser.NewTokenizer(name)
+	return t.Next() &&
+		t.Token().Kind == parser.TokenIdentifier &&
+		t.Token().Span == parser.Span{Start: 0, End: len(name)}
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package lsp
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDocumentSymbols(t *testing.T) {
+	const source = "let x = 1;\nlet U = T | take x;\nU"
+	var got []string
+	for _, sym := range documentSymbols(source) {
+		got = append(got, sym.Name)
+		if sym.SelectionRange.Start.Line != sym.Range.Start.Line {
+			t.Errorf("symbol %s selection range %+v not within %+v", sym.Name, sym.SelectionRange, sym.Range)
+		}
+	}
+	if diff := cmp.Diff([]string{"x", "U"}, got); diff != "" {
+		t.Errorf("documentSymbols(%q) names (-want +got):\n%s", source, diff)
+	}
+}
+
+func TestLetReferences(t *testing.T) {
+	const source = "let x = 1;\n" +
+		"let U = T | where a > x;\n" +
+		"U | extend x = x + 1;\n" +
+		"let x = x * 2;\n" +
+		"T | take x"
+	tests := []struct {
+		name string
+		pos  int
+		want []string
+	}{
+		{
+			name: "Definition",
+			pos:  4,
+			// The first binding is used in the value of the second let x
+			// but not after it.
+			want: []string{"x 4", "x 33", "x 51", "x 66"},
+		},
+		{
+			name: "Reference",
+			pos:  33,
+			want: []string{"x 4", "x 33", "x 51", "x 66"},
+		},
+		{
+			name: "Shadowed",
+			pos:  82,
+			want: []string{"x 62", "x 82"},
+		},
+		{
+			name: "Tabular",
+			pos:  36,
+			want: []string{"U 15", "U 36"},
+		},
+		{
+			name: "NotBound",
+			pos:  29,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, span := range letReferences(source, test.pos) {
+				got = append(got, source[span.Start:span.End]+" "+strconv.Itoa(span.Start))
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("letReferences(source, %d) (-want +got):\n%s", test.pos, diff)
+			}
+		})
+	}
+}
+
+func TestIsIdentifier(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"x", true},
+		{"_foo2", true},
+		{"", false},
+		{"a b", false},
+		{"and", false},
+		{"1x", false},
+	}
+	for _, test := range tests {
+		if got := isIdentifier(test.name); got != test.want {
+			t.Errorf("isIdentifier(%q) = %t; want %t", test.name, got, test.want)
+		}
+	}
+}
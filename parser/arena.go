@@ -0,0 +1,127 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import "sync"
+
+// slabSize is the number of values in each block of a [slab].
+const slabSize = 64
+
+// A slab allocates values of type T from fixed-size blocks.
+// The zero value is an empty slab.
+type slab[T any] struct {
+	cur  []T
+	used [][]T
+	free [][]T
+}
+
+// alloc returns a pointer to a zeroed T.
+func (s *slab[T]) alloc() *T {
+	if len(s.cur) == cap(s.cur) {
+		if s.cur != nil {
+			s.used = append(s.used, s.cur)
+		}
+		if n := len(s.free); n > 0 {
+			s.cur = s.free[n-1][:0]
+			s.free = s.free[:n-1]
+		} else {
+			s.cur = make([]T, 0, slabSize)
+		}
+	}
+	s.cur = s.cur[:len(s.cur)+1]
+	return &s.cur[len(s.cur)-1]
+}
+
+// reset zeroes every value allocated from the slab
+// and makes its blocks available for reuse.
+func (s *slab[T]) reset() {
+	if s.cur != nil {
+		s.used = append(s.used, s.cur)
+		s.cur = nil
+	}
+	for _, block := range s.used {
+		clear(block)
+		s.free = append(s.free, block[:0])
+	}
+	clear(s.used)
+	s.used = s.used[:0]
+}
+
+// An arena holds the memory for the syntax tree of a pooled [ParseResult].
+// Only the most frequently allocated node types are arena-allocated;
+// everything else is allocated normally and left to the garbage collector.
+type arena struct {
+	tokens  []Token
+	idents  slab[Ident]
+	qidents slab[QualifiedIdent]
+	binary  slab[BinaryExpr]
+	lits    slab[BasicLit]
+	calls   slab[CallExpr]
+}
+
+var arenaPool = sync.Pool{
+	New: func() any { return new(arena) },
+}
+
+func getArena() *arena {
+	return arenaPool.Get().(*arena)
+}
+
+// release resets the arena and returns it to the pool.
+// The caller must not use the arena or any of the nodes allocated from it afterward.
+func (a *arena) release() {
+	clear(a.tokens)
+	a.tokens = a.tokens[:0]
+	a.idents.reset()
+	a.qidents.reset()
+	a.binary.reset()
+	a.lits.reset()
+	a.calls.reset()
+	arenaPool.Put(a)
+}
+
+// scan is like [Scan], but it reuses the arena's token buffer.
+// The returned slice is only valid until the arena is released.
+func (a *arena) scan(query string) []Token {
+	t := NewTokenizer(query)
+	for t.Next() {
+		a.tokens = append(a.tokens, t.Token())
+	}
+	return a.tokens
+}
+
+func (p *parser) newIdent() *Ident {
+	if p.arena == nil {
+		return new(Ident)
+	}
+	return p.arena.idents.alloc()
+}
+
+func (p *parser) newQualifiedIdent() *QualifiedIdent {
+	if p.arena == nil {
+		return new(QualifiedIdent)
+	}
+	return p.arena.qidents.alloc()
+}
+
+func (p *parser) newBinaryExpr() *BinaryExpr {
+	if p.arena == nil {
+		return new(BinaryExpr)
+	}
+	return p.arena.binary.alloc()
+}
+
+func (p *parser) newBasicLit() *BasicLit {
+	if p.arena == nil {
+		return new(BasicLit)
+	}
+	return p.arena.lits.alloc()
+}
+
+func (p *parser) newCallExpr() *CallExpr {
+	if p.arena == nil {
+		return new(CallExpr)
+	}
+	return p.arena.calls.alloc()
+}
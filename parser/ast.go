@@ -94,8 +94,8 @@ func (x *TabularExpr) Span() Span {
 }
 
 // TabularDataSource is the interface implemented by all AST node types
-// that can be used as the data source of a [TabularExpr].
-// At the moment, this can only be a [TableRef].
+// that can be used as the data source of a [TabularExpr]:
+// [TableRef], [TableWildcard], [TableCall], [ParenTabularExpr], and [UnionSource].
 type TabularDataSource interface {
 	Node
 	tabularDataSource()
@@ -103,8 +103,19 @@ type TabularDataSource interface {
 
 // A TableRef node refers to a specific table.
 // It implements [TabularDataSource].
+//
+// The cluster and database may be named by identifiers
+// or by calls like cluster("primary").database("security").
+// For names given by calls, the identifier's span covers the whole call.
 type TableRef struct {
-	Table *Ident
+	// Cluster is the cluster the database belongs to.
+	// It is nil if the table name is not qualified with a cluster.
+	// Database is never nil if Cluster is not nil.
+	Cluster *Ident
+	// Database is the database the table belongs to.
+	// It is nil if the table name is not qualified with a database.
+	Database *Ident
+	Table    *Ident
 }
 
 func (ref *TableRef) tabularDataSource() {}
@@ -113,7 +124,103 @@ func (ref *TableRef) Span() Span {
 	if ref == nil {
 		return nullSpan()
 	}
-	return ref.Table.Span()
+	if ref.Database == nil {
+		return ref.Table.Span()
+	}
+	return unionSpans(ref.Database.Span(), ref.Table.Span())
+}
+
+// A TableWildcard node refers to every table whose name matches a pattern,
+// like Events_*.
+// An asterisk in the pattern matches any sequence of characters.
+// It implements [TabularDataSource].
+type TableWildcard struct {
+	// Database is the database the tables belong to.
+	// It is nil if the pattern is not qualified with a database.
+	Database    *Ident
+	Pattern     string
+	PatternSpan Span
+}
+
+func (ref *TableWildcard) tabularDataSource() {}
+
+func (ref *TableWildcard) Span() Span {
+	if ref == nil {
+		return nullSpan()
+	}
+	if ref.Database == nil {
+		return ref.PatternSpan
+	}
+	return unionSpans(ref.Database.Span(), ref.PatternSpan)
+}
+
+// A TableCall node refers to the tables named by a constant string expression,
+// like table("Events_" + suffix).
+// The string may contain asterisks like a [TableWildcard] pattern.
+// It implements [TabularDataSource].
+type TableCall struct {
+	Keyword Span
+	Lparen  Span
+	Name    Expr
+	Rparen  Span
+}
+
+func (call *TableCall) tabularDataSource() {}
+
+func (call *TableCall) Span() Span {
+	if call == nil {
+		return nullSpan()
+	}
+	return unionSpans(call.Keyword, call.Lparen, nodeSpan(call.Name), call.Rparen)
+}
+
+// A ParenTabularExpr is a parenthesized tabular expression
+// used as the data source of another tabular expression.
+// It implements [TabularDataSource].
+type ParenTabularExpr struct {
+	Lparen Span
+	X      *TabularExpr
+	Rparen Span
+}
+
+func (expr *ParenTabularExpr) tabularDataSource() {}
+
+func (expr *ParenTabularExpr) Span() Span {
+	if expr == nil {
+		return nullSpan()
+	}
+	return unionSpans(expr.Lparen, expr.X.Span(), expr.Rparen)
+}
+
+// A UnionSource combines the rows of several data sources
+// at the start of a tabular expression, like `union T1, T2`.
+// It implements [TabularDataSource].
+type UnionSource struct {
+	Keyword Span
+	// WithSource and WithSourceAssign are the spans
+	// of the optional "withsource =" clause.
+	WithSource       Span
+	WithSourceAssign Span
+	// SourceColumn is the name of a column that holds
+	// the name of the table that each row came from.
+	// It is nil if there is no withsource clause.
+	SourceColumn *Ident
+	Tables       []TabularDataSource
+}
+
+func (src *UnionSource) tabularDataSource() {}
+
+func (src *UnionSource) Span() Span {
+	if src == nil {
+		return nullSpan()
+	}
+	return unionSpans(
+		src.Keyword,
+		src.WithSource,
+		src.WithSourceAssign,
+		src.SourceColumn.Span(),
+		nodeSliceSpan(src.Tables),
+	)
 }
 
 // TabularOperator is the interface implemented by all AST node types
@@ -259,6 +366,41 @@ func (op *ProjectColumn) Span() Span {
 	return unionSpans(op.Name.Span(), op.Assign, nodeSpan(op.X))
 }
 
+// ProjectAwayOperator represents a `| project-away` operator in a [TabularExpr].
+// It implements [TabularOperator].
+type ProjectAwayOperator struct {
+	Pipe Span
+	// Keyword is the span of the whole "project-away" operator name.
+	Keyword Span
+	Cols    []*Ident
+}
+
+func (op *ProjectAwayOperator) tabularOperator() {}
+
+func (op *ProjectAwayOperator) Span() Span {
+	if op == nil {
+		return nullSpan()
+	}
+	return unionSpans(op.Pipe, op.Keyword, nodeSliceSpan(op.Cols))
+}
+
+// DistinctOperator represents a `| distinct` operator in a [TabularExpr].
+// It implements [TabularOperator].
+type DistinctOperator struct {
+	Pipe    Span
+	Keyword Span
+	Cols    []*Ident
+}
+
+func (op *DistinctOperator) tabularOperator() {}
+
+func (op *DistinctOperator) Span() Span {
+	if op == nil {
+		return nullSpan()
+	}
+	return unionSpans(op.Pipe, op.Keyword, nodeSliceSpan(op.Cols))
+}
+
 // ExtendOperator represents a `| extend` operator in a [TabularExpr].
 // It implements [TabularOperator].
 type ExtendOperator struct {
@@ -292,6 +434,40 @@ func (op *ExtendColumn) Span() Span {
 	return unionSpans(op.Name.Span(), op.Assign, nodeSpan(op.X))
 }
 
+// MvExpandOperator represents a `| mv-expand` operator in a [TabularExpr].
+// It implements [TabularOperator].
+type MvExpandOperator struct {
+	Pipe Span
+	// Keyword is the span of the whole "mv-expand" operator name.
+	Keyword Span
+	Cols    []*MvExpandColumn
+}
+
+func (op *MvExpandOperator) tabularOperator() {}
+
+func (op *MvExpandOperator) Span() Span {
+	if op == nil {
+		return nullSpan()
+	}
+	return unionSpans(op.Pipe, op.Keyword, nodeSliceSpan(op.Cols))
+}
+
+// A MvExpandColumn is a single column term in a [MvExpandOperator].
+// It consists of an array expression, optionally preceded by a column name.
+// If the column name is omitted, one is derived from the expression.
+type MvExpandColumn struct {
+	Name   *Ident
+	Assign Span
+	X      Expr
+}
+
+func (op *MvExpandColumn) Span() Span {
+	if op == nil {
+		return nullSpan()
+	}
+	return unionSpans(op.Name.Span(), op.Assign, nodeSpan(op.X))
+}
+
 // SummarizeOperator represents a `| summarize` operator in a [TabularExpr].
 // It implements [TabularOperator].
 type SummarizeOperator struct {
@@ -333,6 +509,38 @@ func (op *SummarizeColumn) Span() Span {
 	return unionSpans(op.Name.Span(), op.Assign, nodeSpan(op.X))
 }
 
+// UnionOperator represents a `| union` operator in a [TabularExpr].
+// It implements [TabularOperator].
+type UnionOperator struct {
+	Pipe    Span
+	Keyword Span
+	// WithSource and WithSourceAssign are the spans
+	// of the optional "withsource =" clause.
+	WithSource       Span
+	WithSourceAssign Span
+	// SourceColumn is the name of a column that holds
+	// the name of the table that each row came from.
+	// It is nil if there is no withsource clause.
+	SourceColumn *Ident
+	Tables       []TabularDataSource
+}
+
+func (op *UnionOperator) tabularOperator() {}
+
+func (op *UnionOperator) Span() Span {
+	if op == nil {
+		return nullSpan()
+	}
+	return unionSpans(
+		op.Pipe,
+		op.Keyword,
+		op.WithSource,
+		op.WithSourceAssign,
+		op.SourceColumn.Span(),
+		nodeSliceSpan(op.Tables),
+	)
+}
+
 // JoinOperator represents a `| join` operator in a [TabularExpr].
 // It implements [TabularOperator].
 type JoinOperator struct {
@@ -344,6 +552,9 @@ type JoinOperator struct {
 	// Flavor is the type of join to use.
 	// If absent, innerunique is implied.
 	Flavor *Ident
+	// Hints is the list of "hint.name=value" clauses
+	// that appear before the right table.
+	Hints []*JoinHint
 
 	Lparen Span
 	Right  *TabularExpr
@@ -353,6 +564,8 @@ type JoinOperator struct {
 	// Conditions is one or more AND-ed conditions.
 	// If the expression is a single identifier x,
 	// then it is treated as equivalent to "$left.x == $right.x".
+	// Conditions may be omitted for a cross join,
+	// in which case On is an invalid span.
 	Conditions []Expr
 }
 
@@ -365,6 +578,7 @@ func (op *JoinOperator) Span() Span {
 		op.Kind,
 		op.KindAssign,
 		op.Flavor.Span(),
+		nodeSliceSpan(op.Hints),
 		op.Lparen,
 		op.Right.Span(),
 		op.Rparen,
@@ -373,6 +587,25 @@ func (op *JoinOperator) Span() Span {
 	)
 }
 
+// JoinHint represents a "hint.name=value" clause in a [JoinOperator],
+// like "hint.strategy=broadcast".
+// Hints suggest how a join should be executed
+// but do not change its result.
+type JoinHint struct {
+	Keyword Span
+	Dot     Span
+	Name    *Ident
+	Assign  Span
+	Value   *Ident
+}
+
+func (h *JoinHint) Span() Span {
+	if h == nil {
+		return nullSpan()
+	}
+	return unionSpans(h.Keyword, h.Dot, h.Name.Span(), h.Assign, h.Value.Span())
+}
+
 // AsOperator represents a `| as` operator in a [TabularExpr].
 // It implements [TabularOperator].
 type AsOperator struct {
@@ -562,7 +795,14 @@ type LetStatement struct {
 	Keyword Span
 	Name    *Ident
 	Assign  Span
-	X       Expr
+	// X is the scalar expression bound to Name.
+	// X is nil if the statement binds a tabular expression.
+	X Expr
+	// Tabular is the tabular expression bound to Name.
+	// Tabular is nil if the statement binds a scalar expression.
+	// A let statement binds a tabular expression
+	// if its value has at least one tabular operator.
+	Tabular *TabularExpr
 }
 
 func (stmt *LetStatement) statement() {}
@@ -575,7 +815,7 @@ func (stmt *LetStatement) Span() Span {
 	if stmt.X != nil {
 		xSpan = stmt.X.Span()
 	}
-	return unionSpans(stmt.Keyword, stmt.Name.Span(), stmt.Assign, xSpan)
+	return unionSpans(stmt.Keyword, stmt.Name.Span(), stmt.Assign, xSpan, stmt.Tabular.Span())
 }
 
 // RenderOperator represents a `| render` operator in a [TabularExpr].
@@ -632,6 +872,10 @@ func Walk(n Node, visit func(n Node) bool) {
 	for len(stack) > 0 {
 		curr := stack[len(stack)-1]
 		stack = stack[:len(stack)-1]
+		if isNilNode(curr) {
+			// Missing child in an incomplete tree.
+			continue
+		}
 		switch n := curr.(type) {
 		case *Ident:
 			visit(n)
@@ -651,6 +895,33 @@ func Walk(n Node, visit func(n Node) bool) {
 		case *TableRef:
 			if visit(n) {
 				stack = append(stack, n.Table)
+				if n.Database != nil {
+					stack = append(stack, n.Database)
+				}
+				if n.Cluster != nil {
+					stack = append(stack, n.Cluster)
+				}
+			}
+		case *TableWildcard:
+			if visit(n) && n.Database != nil {
+				stack = append(stack, n.Database)
+			}
+		case *TableCall:
+			if visit(n) && n.Name != nil {
+				stack = append(stack, n.Name)
+			}
+		case *ParenTabularExpr:
+			if visit(n) && n.X != nil {
+				stack = append(stack, n.X)
+			}
+		case *UnionSource:
+			if visit(n) {
+				for i := len(n.Tables) - 1; i >= 0; i-- {
+					stack = append(stack, n.Tables[i])
+				}
+				if n.SourceColumn != nil {
+					stack = append(stack, n.SourceColumn)
+				}
 			}
 		case *CountOperator:
 			visit(n)
@@ -690,6 +961,18 @@ func Walk(n Node, visit func(n Node) bool) {
 				}
 				stack = append(stack, n.Name)
 			}
+		case *ProjectAwayOperator:
+			if visit(n) {
+				for i := len(n.Cols) - 1; i >= 0; i-- {
+					stack = append(stack, n.Cols[i])
+				}
+			}
+		case *DistinctOperator:
+			if visit(n) {
+				for i := len(n.Cols) - 1; i >= 0; i-- {
+					stack = append(stack, n.Cols[i])
+				}
+			}
 		case *ExtendOperator:
 			if visit(n) {
 				for i := len(n.Cols) - 1; i >= 0; i-- {
@@ -703,6 +986,19 @@ func Walk(n Node, visit func(n Node) bool) {
 				}
 				stack = append(stack, n.Name)
 			}
+		case *MvExpandOperator:
+			if visit(n) {
+				for i := len(n.Cols) - 1; i >= 0; i-- {
+					stack = append(stack, n.Cols[i])
+				}
+			}
+		case *MvExpandColumn:
+			if visit(n) {
+				stack = append(stack, n.X)
+				if n.Name != nil {
+					stack = append(stack, n.Name)
+				}
+			}
 		case *SummarizeOperator:
 			if visit(n) {
 				for i := len(n.GroupBy) - 1; i >= 0; i-- {
@@ -727,6 +1023,15 @@ func Walk(n Node, visit func(n Node) bool) {
 				}
 				stack = append(stack, n.Right)
 			}
+		case *UnionOperator:
+			if visit(n) {
+				for i := len(n.Tables) - 1; i >= 0; i-- {
+					stack = append(stack, n.Tables[i])
+				}
+				if n.SourceColumn != nil {
+					stack = append(stack, n.SourceColumn)
+				}
+			}
 		case *AsOperator:
 			if visit(n) {
 				stack = append(stack, n.Name)
@@ -740,6 +1045,10 @@ func Walk(n Node, visit func(n Node) bool) {
 			if visit(n) {
 				stack = append(stack, n.X)
 			}
+		case *ParenExpr:
+			if visit(n) {
+				stack = append(stack, n.X)
+			}
 		case *InExpr:
 			if visit(n) {
 				for i := len(n.Vals) - 1; i >= 0; i-- {
@@ -763,7 +1072,12 @@ func Walk(n Node, visit func(n Node) bool) {
 			}
 		case *LetStatement:
 			if visit(n) {
-				stack = append(stack, n.X)
+				if n.Tabular != nil {
+					stack = append(stack, n.Tabular)
+				}
+				if n.X != nil {
+					stack = append(stack, n.X)
+				}
 				stack = append(stack, n.Name)
 			}
 		// Add to Walk function's switch statement:
@@ -782,3 +1096,35 @@ func Walk(n Node, visit func(n Node) bool) {
 		}
 	}
 }
+
+// NodeAt returns the innermost node in the tree rooted at root
+// whose span contains pos,
+// along with the node's ancestors in order from root to the node's parent.
+// A span contains the positions from its start to its end, inclusive,
+// so a position immediately after an identifier refers to the identifier.
+// NodeAt returns a nil node if root's span does not contain pos.
+func NodeAt(root Node, pos int) (n Node, ancestors []Node) {
+	var path []Node
+	Walk(root, func(n Node) bool {
+		span := n.Span()
+		if !span.IsValid() || pos < span.Start || pos > span.End {
+			return false
+		}
+		// Walk visits children in source order after their parent,
+		// so discard any previous sibling that also contains pos.
+		for len(path) > 0 && !spanContains(path[len(path)-1].Span(), span) {
+			path = path[:len(path)-1]
+		}
+		path = append(path, n)
+		return true
+	})
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return path[len(path)-1], path[:len(path)-1]
+}
+
+// spanContains reports whether outer contains all of inner.
+func spanContains(outer, inner Span) bool {
+	return outer.Start <= inner.Start && inner.End <= outer.End
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNodeAt(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		// want is the list of node types from the root to the innermost node.
+		want []string
+	}{
+		{
+			query: "StormEvents | where State == 'FLORIDA'",
+			pos:   3,
+			want:  []string{"*parser.TabularExpr", "*parser.TableRef", "*parser.Ident"},
+		},
+		{
+			query: "StormEvents | where State == 'FLORIDA'",
+			pos:   len("StormEvents"),
+			want:  []string{"*parser.TabularExpr", "*parser.TableRef", "*parser.Ident"},
+		},
+		{
+			query: "StormEvents | where State == 'FLORIDA'",
+			pos:   len("StormEvents | wh"),
+			want:  []string{"*parser.TabularExpr", "*parser.WhereOperator"},
+		},
+		{
+			query: "StormEvents | where State == 'FLORIDA'",
+			pos:   len("StormEvents | where St"),
+			want: []string{
+				"*parser.TabularExpr",
+				"*parser.WhereOperator",
+				"*parser.BinaryExpr",
+				"*parser.QualifiedIdent",
+				"*parser.Ident",
+			},
+		},
+		{
+			query: "StormEvents | where State == 'FLORIDA'",
+			pos:   len("StormEvents | where State ="),
+			want:  []string{"*parser.TabularExpr", "*parser.WhereOperator", "*parser.BinaryExpr"},
+		},
+		{
+			query: "StormEvents | where f(x, y)",
+			pos:   len("StormEvents | where f(x,"),
+			want:  []string{"*parser.TabularExpr", "*parser.WhereOperator", "*parser.CallExpr"},
+		},
+		{
+			query: "StormEvents | where f(x, y)",
+			pos:   len("StormEvents | where f(x, y"),
+			want: []string{
+				"*parser.TabularExpr",
+				"*parser.WhereOperator",
+				"*parser.CallExpr",
+				"*parser.QualifiedIdent",
+				"*parser.Ident",
+			},
+		},
+		{
+			query: "StormEvents   ",
+			pos:   13,
+			want:  nil,
+		},
+		{
+			query: "StormEvents | where",
+			pos:   len("StormEvents | where"),
+			want:  []string{"*parser.TabularExpr", "*parser.WhereOperator"},
+		},
+	}
+	for _, test := range tests {
+		stmts, _ := Parse(test.query)
+		if len(stmts) != 1 {
+			t.Errorf("Parse(%q) returned %d statements; want 1", test.query, len(stmts))
+			continue
+		}
+		n, ancestors := NodeAt(stmts[0], test.pos)
+		var got []string
+		for _, a := range ancestors {
+			got = append(got, fmt.Sprintf("%T", a))
+		}
+		if n != nil {
+			got = append(got, fmt.Sprintf("%T", n))
+		} else if len(ancestors) > 0 {
+			t.Errorf("NodeAt(Parse(%q), %d) returned ancestors for a nil node", test.query, test.pos)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("NodeAt(Parse(%q), %d) (-want +got):\n%s", test.query, test.pos, diff)
+		}
+	}
+}
+
+func TestNodeAtIdentity(t *testing.T) {
+	const query = "StormEvents | project a, b"
+	stmts, err := Parse(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, ancestors := NodeAt(stmts[0], strings.Index(query, "b"))
+	id, ok := n.(*Ident)
+	if !ok || id.Name != "b" {
+		t.Fatalf("NodeAt(..., %d) = %v; want identifier b", strings.Index(query, "b"), Dump(n))
+	}
+	if len(ancestors) == 0 || ancestors[0] != stmts[0] {
+		t.Errorf("NodeAt(..., %d) ancestors do not start at the root", strings.Index(query, "b"))
+	}
+}
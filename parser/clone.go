@@ -0,0 +1,121 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import "reflect"
+
+// Clone returns a deep copy of the syntax tree rooted at n.
+// The copy shares no nodes with the original,
+// so it can be modified (e.g. with [Rewrite]) without affecting n.
+func Clone[T Node](n T) T {
+	return copyValue(reflect.ValueOf(n), 0).Interface().(T)
+}
+
+// shiftSpans returns a deep copy of the given node
+// with all of its valid spans offset by delta.
+// If delta is zero, shiftSpans returns n.
+func shiftSpans[T Node](n T, delta int) T {
+	if delta == 0 {
+		return n
+	}
+	return copyValue(reflect.ValueOf(n), delta).Interface().(T)
+}
+
+// Equal reports whether the syntax trees rooted at x and y are identical,
+// including their spans.
+func Equal(x, y Node) bool {
+	return equalValue(reflect.ValueOf(x), reflect.ValueOf(y), false)
+}
+
+// EqualIgnoringSpans reports whether the syntax trees rooted at x and y
+// are identical other than their spans.
+// For example, the trees for "x+1" and "x + 1" are equal by this definition.
+func EqualIgnoringSpans(x, y Node) bool {
+	return equalValue(reflect.ValueOf(x), reflect.ValueOf(y), true)
+}
+
+var spanType = reflect.TypeOf(Span{})
+
+// copyValue returns a deep copy of v
+// with all of its valid spans offset by delta.
+func copyValue(v reflect.Value, delta int) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(copyValue(v.Elem(), delta))
+		return p
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		i := reflect.New(v.Type()).Elem()
+		i.Set(copyValue(v.Elem(), delta))
+		return i
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s.Index(i).Set(copyValue(v.Index(i), delta))
+		}
+		return s
+	case reflect.Struct:
+		if v.Type() == spanType {
+			span := v.Interface().(Span)
+			if span.IsValid() {
+				span = newSpan(span.Start+delta, span.End+delta)
+			}
+			return reflect.ValueOf(span)
+		}
+		s := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			s.Field(i).Set(copyValue(v.Field(i), delta))
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+func equalValue(x, y reflect.Value, ignoreSpans bool) bool {
+	if !x.IsValid() || !y.IsValid() {
+		return x.IsValid() == y.IsValid()
+	}
+	if x.Type() != y.Type() {
+		return false
+	}
+	switch x.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if x.IsNil() || y.IsNil() {
+			return x.IsNil() == y.IsNil()
+		}
+		return equalValue(x.Elem(), y.Elem(), ignoreSpans)
+	case reflect.Slice:
+		if x.Len() != y.Len() {
+			return false
+		}
+		for i := 0; i < x.Len(); i++ {
+			if !equalValue(x.Index(i), y.Index(i), ignoreSpans) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if x.Type() == spanType && ignoreSpans {
+			return true
+		}
+		for i := 0; i < x.NumField(); i++ {
+			if !equalValue(x.Field(i), y.Field(i), ignoreSpans) {
+				return false
+			}
+		}
+		return true
+	default:
+		return x.Interface() == y.Interface()
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import "testing"
+
+func TestClone(t *testing.T) {
+	for _, test := range parserTests {
+		if test.err {
+			continue
+		}
+		t.Run(test.name, func(t *testing.T) {
+			stmts, err := Parse(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := Parse(test.query)
+			for i, stmt := range stmts {
+				clone := Clone(stmt)
+				if !Equal(stmt, clone) {
+					t.Errorf("Clone(%#v) = %#v; not equal", stmt, clone)
+				}
+
+				// Modifying the clone must not affect the original.
+				Rewrite(clone, func(c *Cursor) bool {
+					if id, ok := c.Node().(*Ident); ok {
+						id.Name += "_renamed"
+					}
+					return true
+				})
+				if !Equal(want[i], stmt) {
+					t.Errorf("modifying clone of %q changed the original", test.query)
+				}
+			}
+		})
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		x, y              string
+		equal             bool
+		equalIgnoringSpan bool
+	}{
+		{
+			x:                 "StormEvents | where x + 1 > 5",
+			y:                 "StormEvents | where x + 1 > 5",
+			equal:             true,
+			equalIgnoringSpan: true,
+		},
+		{
+			x:                 "StormEvents | where x + 1 > 5",
+			y:                 "StormEvents|where x+1>5",
+			equal:             false,
+			equalIgnoringSpan: true,
+		},
+		{
+			x:                 "StormEvents | where x + 1 > 5",
+			y:                 "StormEvents | where x + 2 > 5",
+			equal:             false,
+			equalIgnoringSpan: false,
+		},
+		{
+			x:                 "StormEvents | project a, b",
+			y:                 "StormEvents | project a",
+			equal:             false,
+			equalIgnoringSpan: false,
+		},
+		{
+			x:                 "StormEvents | where (x)",
+			y:                 "StormEvents | where x",
+			equal:             false,
+			equalIgnoringSpan: false,
+		},
+	}
+	for _, test := range tests {
+		x, err := Parse(test.x)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		y, err := Parse(test.y)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := Equal(x[0], y[0]); got != test.equal {
+			t.Errorf("Equal(Parse(%q), Parse(%q)) = %t; want %t", test.x, test.y, got, test.equal)
+		}
+		if got := EqualIgnoringSpans(x[0], y[0]); got != test.equalIgnoringSpan {
+			t.Errorf("EqualIgnoringSpans(Parse(%q), Parse(%q)) = %t; want %t", test.x, test.y, got, test.equalIgnoringSpan)
+		}
+	}
+}
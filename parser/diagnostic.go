@@ -0,0 +1,118 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import "strconv"
+
+// Severity is the seriousness of a [Diagnostic].
+type Severity int
+
+// Severities.
+const (
+	// SeverityError indicates a problem that prevents the query from being used.
+	SeverityError Severity = 1 + iota
+	// SeverityWarning indicates a likely mistake
+	// that does not prevent the query from being used.
+	SeverityWarning
+)
+
+// String returns the lowercase name of the severity, like "error".
+func (sev Severity) String() string {
+	switch sev {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "Severity(" + strconv.Itoa(int(sev)) + ")"
+	}
+}
+
+// Diagnostic codes produced by the parser.
+const (
+	// CodeSyntax is the code for a query that does not follow the language grammar.
+	CodeSyntax = "syntax"
+	// CodeInvalidToken is the code for text that could not be scanned as a token,
+	// such as an unterminated string.
+	CodeInvalidToken = "invalid-token"
+	// CodeUnknownOperator is the code for a tabular operator name
+	// that is not recognized.
+	CodeUnknownOperator = "unknown-operator"
+	// CodeLimitExceeded is the code for a query
+	// that exceeds one of the limits in [ParseOptions].
+	CodeLimitExceeded = "limit-exceeded"
+)
+
+// A Diagnostic is a single problem found in a query.
+type Diagnostic struct {
+	// Span is the part of the query that the diagnostic refers to.
+	// The span is invalid if the diagnostic does not refer to a specific location.
+	Span     Span
+	Severity Severity
+	// Code is a short, stable identifier for the kind of problem, like [CodeSyntax].
+	// Code is empty if the problem was not reported with a code.
+	Code string
+	// Message is a human-readable description of the problem
+	// that does not include its position.
+	Message string
+}
+
+// DiagnosticError is implemented by errors that describe a single [Diagnostic].
+type DiagnosticError interface {
+	error
+	Diagnostic() Diagnostic
+}
+
+// Diagnostics returns the problems described by an error returned from [Parse]
+// or from the compiler, in the order they were reported.
+// Errors in err's tree that do not implement [DiagnosticError]
+// are returned as diagnostics with an invalid span and an empty code.
+// Diagnostics returns nil if err is nil.
+func Diagnostics(err error) []Diagnostic {
+	var diags []Diagnostic
+	var visit func(err error)
+	visit = func(err error) {
+		switch e := err.(type) {
+		case nil:
+		case DiagnosticError:
+			diags = append(diags, e.Diagnostic())
+		case multiUnwrapper:
+			for _, err := range e.Unwrap() {
+				visit(err)
+			}
+		case interface{ Unwrap() error }:
+			if inner := e.Unwrap(); inner != nil {
+				visit(inner)
+			} else {
+				diags = append(diags, unknownDiagnostic(err))
+			}
+		default:
+			diags = append(diags, unknownDiagnostic(err))
+		}
+	}
+	visit(err)
+	return diags
+}
+
+func unknownDiagnostic(err error) Diagnostic {
+	return Diagnostic{
+		Span:     nullSpan(),
+		Severity: SeverityError,
+		Message:  err.Error(),
+	}
+}
+
+// Diagnostic returns the diagnostic described by the error.
+func (e *parseError) Diagnostic() Diagnostic {
+	code := e.code
+	if code == "" {
+		code = CodeSyntax
+	}
+	return Diagnostic{
+		Span:     e.span,
+		Severity: SeverityError,
+		Code:     code,
+		Message:  e.err.Error(),
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []Diagnostic
+	}{
+		{
+			name:  "Valid",
+			query: "StormEvents | take 5",
+			want:  nil,
+		},
+		{
+			name:  "InvalidToken",
+			query: "StormEvents; 'abc",
+			want: []Diagnostic{
+				{
+					Span:     newSpan(17, 17),
+					Severity: SeverityError,
+					Code:     CodeSyntax,
+					Message:  "expected identifier, got ''abc'",
+				},
+				{
+					Span:     newSpan(13, 17),
+					Severity: SeverityError,
+					Code:     CodeInvalidToken,
+					Message:  "unterminated string",
+				},
+			},
+		},
+		{
+			name:  "Multiple",
+			query: "StormEvents | bork | where x in (1, *)",
+			want: []Diagnostic{
+				{
+					Span:     newSpan(14, 18),
+					Severity: SeverityError,
+					Code:     CodeUnknownOperator,
+					Message:  `unknown operator name "bork"`,
+				},
+				{
+					Span:     newSpan(36, 37),
+					Severity: SeverityError,
+					Code:     CodeSyntax,
+					Message:  "expected expression, got '*'",
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.query)
+			got := Diagnostics(err)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Diagnostics(Parse(%q)) (-want +got):\n%s", test.query, diff)
+			}
+			if diff := cmp.Diff(test.want, ParseIncremental(test.query).Diagnostics()); diff != "" {
+				t.Errorf("ParseIncremental(%q).Diagnostics() (-want +got):\n%s", test.query, diff)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsUnknownError(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", errors.Join(errors.New("bork"), &parseError{
+		source: "x",
+		span:   newSpan(0, 1),
+		err:    errors.New("bad"),
+	}))
+	want := []Diagnostic{
+		{
+			Span:     nullSpan(),
+			Severity: SeverityError,
+			Message:  "bork",
+		},
+		{
+			Span:     newSpan(0, 1),
+			Severity: SeverityError,
+			Code:     CodeSyntax,
+			Message:  "bad",
+		},
+	}
+	if diff := cmp.Diff(want, Diagnostics(err)); diff != "" {
+		t.Errorf("Diagnostics(...) (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Dump returns a human-readable representation of the syntax tree rooted at n
+// for debugging.
+// Each node is written as an s-expression containing the node's type and span,
+// followed by its scalar fields inline and its child nodes on separate, indented lines.
+// Nil children, empty lists, and false booleans are omitted.
+// For example:
+//
+//	(TabularExpr [0,20)
+//	  Source: (TableRef [0,11)
+//	    Table: (Ident [0,11) Name="StormEvents"))
+//	  Operators[0]: (TakeOperator [12,20)
+//	    RowCount: (BasicLit [19,20) Kind=TokenNumber Value="5")))
+//
+// The format is intended for people and may change between versions.
+func Dump(n Node) string {
+	sb := new(strings.Builder)
+	dumpNode(sb, 0, n)
+	return sb.String()
+}
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+func dumpNode(sb *strings.Builder, depth int, n Node) {
+	if isNilNode(n) {
+		sb.WriteString("nil")
+		return
+	}
+	v := reflect.ValueOf(n)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	sb.WriteString("(")
+	sb.WriteString(v.Type().Name())
+	sb.WriteString(" ")
+	sb.WriteString(n.Span().String())
+	if v.Kind() != reflect.Struct {
+		sb.WriteString(")")
+		return
+	}
+
+	// Write scalar fields first so that they stay on the node's line.
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if !field.IsExported() || field.Type == spanType || isDumpChild(field.Type) {
+			continue
+		}
+		if fv.Kind() == reflect.Bool && !fv.Bool() {
+			continue
+		}
+		sb.WriteString(" ")
+		sb.WriteString(field.Name)
+		sb.WriteString("=")
+		if fv.Kind() == reflect.String {
+			fmt.Fprintf(sb, "%q", fv.String())
+		} else {
+			fmt.Fprint(sb, fv.Interface())
+		}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if !field.IsExported() || !isDumpChild(field.Type) {
+			continue
+		}
+		if fv.Kind() == reflect.Slice {
+			for j := 0; j < fv.Len(); j++ {
+				dumpChild(sb, depth+1, fmt.Sprintf("%s[%d]", field.Name, j), fv.Index(j))
+			}
+			continue
+		}
+		if fv.IsNil() {
+			continue
+		}
+		dumpChild(sb, depth+1, field.Name, fv)
+	}
+	sb.WriteString(")")
+}
+
+func dumpChild(sb *strings.Builder, depth int, name string, v reflect.Value) {
+	sb.WriteString("\n")
+	for i := 0; i < depth; i++ {
+		sb.WriteString("  ")
+	}
+	sb.WriteString(name)
+	sb.WriteString(": ")
+	var n Node
+	if !v.IsNil() {
+		n = v.Interface().(Node)
+	}
+	dumpNode(sb, depth, n)
+}
+
+// isDumpChild reports whether a field of type t holds child nodes.
+func isDumpChild(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Implements(nodeType)
+}
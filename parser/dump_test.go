@@ -0,0 +1,76 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDump(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "Take",
+			query: "StormEvents | take 5",
+			want: "(TabularExpr [0,20)\n" +
+				"  Source: (TableRef [0,11)\n" +
+				"    Table: (Ident [0,11) Name=\"StormEvents\"))\n" +
+				"  Operators[0]: (TakeOperator [12,20)\n" +
+				"    RowCount: (BasicLit [19,20) Kind=TokenNumber Value=\"5\")))",
+		},
+		{
+			name:  "Expressions",
+			query: "T | where -x.y in (1) and f(`q r`) | sort by x nulls first",
+			want: "(TabularExpr [0,58)\n" +
+				"  Source: (TableRef [0,1)\n" +
+				"    Table: (Ident [0,1) Name=\"T\"))\n" +
+				"  Operators[0]: (WhereOperator [2,34)\n" +
+				"    Predicate: (BinaryExpr [10,34) Op=TokenAnd\n" +
+				"      X: (InExpr [10,21)\n" +
+				"        X: (UnaryExpr [10,14) Op=TokenMinus\n" +
+				"          X: (QualifiedIdent [11,14)\n" +
+				"            Parts[0]: (Ident [11,12) Name=\"x\")\n" +
+				"            Parts[1]: (Ident [13,14) Name=\"y\")))\n" +
+				"        Vals[0]: (BasicLit [19,20) Kind=TokenNumber Value=\"1\"))\n" +
+				"      Y: (CallExpr [26,34)\n" +
+				"        Func: (Ident [26,27) Name=\"f\")\n" +
+				"        Args[0]: (QualifiedIdent [28,33)\n" +
+				"          Parts[0]: (Ident [28,33) Name=\"q r\" Quoted=true)))))\n" +
+				"  Operators[1]: (SortOperator [35,58)\n" +
+				"    Terms[0]: (SortTerm [45,58) NullsFirst=true\n" +
+				"      X: (QualifiedIdent [45,46)\n" +
+				"        Parts[0]: (Ident [45,46) Name=\"x\")))))",
+		},
+		{
+			name:  "Let",
+			query: "let x = 'a'",
+			want: "(LetStatement [0,11)\n" +
+				"  Name: (Ident [4,5) Name=\"x\")\n" +
+				"  X: (BasicLit [8,11) Kind=TokenString Value=\"a\"))",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stmts, err := Parse(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Dump(stmts[0])
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Dump(Parse(%q)) (-want +got):\n%s", test.query, diff)
+			}
+		})
+	}
+}
+
+func TestDumpNil(t *testing.T) {
+	if got, want := Dump(nil), "nil"; got != want {
+		t.Errorf("Dump(nil) = %q; want %q", got, want)
+	}
+}
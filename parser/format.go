@@ -0,0 +1,741 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format returns the canonical Pipeline Query Language source for the given node.
+// Format places each tabular operator on its own line,
+// uses a single space around binary operators and after commas,
+// spells operators and keywords with their canonical names (e.g. "where" instead of "filter"),
+// and only quotes identifiers that require quoting.
+// Parentheses are added where necessary to preserve the structure of expressions,
+// so Format can be used on syntax trees that were modified by [Rewrite].
+// Spans are ignored.
+//
+// Format returns an error if the syntax tree cannot be represented in source,
+// such as if a required field is nil.
+// This is equivalent to new(FormatOptions).Format(n).
+func Format(n Node) (string, error) {
+	return ((*FormatOptions)(nil)).Format(n)
+}
+
+// FormatOptions is a set of optional parameters
+// that configure the style used by [FormatOptions.Format].
+// nil is treated the same as the zero value,
+// which produces the same output as [Format].
+type FormatOptions struct {
+	// Indent is the number of spaces used for each level of indentation,
+	// such as for the right side of a join or a wrapped line.
+	// If Indent is zero, 4 spaces are used.
+	Indent int
+	// TrailingPipes places the pipe between tabular operators
+	// at the end of the preceding line instead of at the start of the operator's line.
+	TrailingPipes bool
+	// MaxLineLength is the number of characters after which
+	// a tabular operator's line is wrapped.
+	// Wrapping breaks lines after the commas in an operator's arguments
+	// and before the "and" or "or" operators in a where operator's predicate.
+	// Lines that are too long after wrapping are left as-is.
+	// If MaxLineLength is zero or negative, lines are never wrapped.
+	MaxLineLength int
+	// LowercaseIdentifiers converts all identifiers to lowercase
+	// instead of writing them as they appear in the syntax tree.
+	// Column and table names are case-sensitive,
+	// so this is only appropriate for data sources
+	// that use lowercase names.
+	LowercaseIdentifiers bool
+}
+
+// Format returns the Pipeline Query Language source for the given node
+// in the style described by opts.
+// See [Format] for details.
+func (opts *FormatOptions) Format(n Node) (string, error) {
+	f := &formatter{indent: "    "}
+	if opts != nil {
+		f.opts = *opts
+		if opts.Indent > 0 {
+			f.indent = strings.Repeat(" ", opts.Indent)
+		}
+	}
+	f.node(n)
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.buf.String(), nil
+}
+
+type formatter struct {
+	opts   FormatOptions
+	indent string
+	buf    bytes.Buffer
+	depth  int
+	err    error
+}
+
+func (f *formatter) fail(err error) {
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *formatter) newline() {
+	f.buf.WriteString("\n")
+	for i := 0; i < f.depth; i++ {
+		f.buf.WriteString(f.indent)
+	}
+}
+
+// continuation starts a wrapped line,
+// which is indented one level deeper than the current line.
+func (f *formatter) continuation() {
+	f.depth++
+	f.newline()
+	f.depth--
+}
+
+// separator writes the separator between the elements of a list,
+// breaking the line after the comma if wrap is true.
+func (f *formatter) separator(wrap bool) {
+	if wrap {
+		f.buf.WriteString(",")
+		f.continuation()
+	} else {
+		f.buf.WriteString(", ")
+	}
+}
+
+// lineWidth returns the number of characters written since the last line break.
+func (f *formatter) lineWidth() int {
+	b := f.buf.Bytes()
+	return utf8.RuneCount(b[bytes.LastIndexByte(b, '\n')+1:])
+}
+
+func (f *formatter) node(n Node) {
+	switch n := n.(type) {
+	case *LetStatement:
+		f.letStatement(n)
+	case *TabularExpr:
+		f.tabularExpr(n)
+	case TabularDataSource:
+		f.dataSource(n)
+	case TabularOperator:
+		if !f.opts.TrailingPipes {
+			f.buf.WriteString("| ")
+		}
+		f.operator(n, false)
+	case Expr:
+		f.expr(n, 0)
+	case *SortTerm:
+		f.sortTerm(n)
+	case *ProjectColumn:
+		f.column(n.Name, n.X)
+	case *ExtendColumn:
+		f.column(n.Name, n.X)
+	case *SummarizeColumn:
+		f.column(n.Name, n.X)
+	case *MvExpandColumn:
+		f.mvExpandColumn(n)
+	case *RenderProperty:
+		f.renderProperty(n)
+	case *Ident:
+		f.ident(n)
+	case nil:
+		f.fail(errors.New("format: nil node"))
+	default:
+		f.fail(fmt.Errorf("format: unknown node type %T", n))
+	}
+}
+
+func (f *formatter) letStatement(stmt *LetStatement) {
+	f.buf.WriteString("let ")
+	f.ident(stmt.Name)
+	f.buf.WriteString(" = ")
+	switch {
+	case stmt.Tabular != nil:
+		f.tabularExpr(stmt.Tabular)
+	case stmt.X != nil:
+		f.expr(stmt.X, 0)
+	default:
+		f.fail(errors.New("format: let statement has no value"))
+	}
+}
+
+func (f *formatter) tabularExpr(expr *TabularExpr) {
+	if expr == nil {
+		f.fail(errors.New("format: nil tabular expression"))
+		return
+	}
+	f.dataSource(expr.Source)
+	for _, op := range expr.Operators {
+		if f.opts.TrailingPipes {
+			f.buf.WriteString(" |")
+			f.newline()
+		} else {
+			f.newline()
+			f.buf.WriteString("| ")
+		}
+		f.operatorLine(op)
+	}
+}
+
+// nestedTabularExpr writes a tabular expression inside parentheses.
+// Expressions with operators are written on their own indented lines.
+func (f *formatter) nestedTabularExpr(expr *TabularExpr) {
+	if expr == nil || len(expr.Operators) == 0 {
+		f.tabularExpr(expr)
+		return
+	}
+	f.depth++
+	f.newline()
+	f.tabularExpr(expr)
+	f.depth--
+	f.newline()
+}
+
+// operatorLine writes op, wrapping it if it exceeds the maximum line length.
+func (f *formatter) operatorLine(op TabularOperator) {
+	start := f.buf.Len()
+	startColumn := f.lineWidth()
+	f.operator(op, false)
+	if f.opts.MaxLineLength <= 0 {
+		return
+	}
+	// Only consider the operator's first and last lines:
+	// any lines in between belong to a nested tabular expression
+	// that has already been wrapped.
+	text := f.buf.Bytes()[start:]
+	first, last := text, text
+	if i := bytes.IndexByte(text, '\n'); i >= 0 {
+		first = text[:i]
+		last = text[bytes.LastIndexByte(text, '\n')+1:]
+		startColumn = 0
+	}
+	if startColumn+utf8.RuneCount(first) <= f.opts.MaxLineLength &&
+		utf8.RuneCount(last) <= f.opts.MaxLineLength {
+		return
+	}
+	f.buf.Truncate(start)
+	f.operator(op, true)
+}
+
+func (f *formatter) dataSource(src TabularDataSource) {
+	switch src := src.(type) {
+	case *TableRef:
+		if src.Cluster != nil {
+			f.buf.WriteString("cluster(")
+			f.basicLit(&BasicLit{Kind: TokenString, Value: src.Cluster.Name})
+			f.buf.WriteString(").")
+		}
+		if src.Database != nil {
+			f.ident(src.Database)
+			f.buf.WriteString(".")
+		}
+		f.ident(src.Table)
+	case *TableWildcard:
+		if src.Database != nil {
+			f.ident(src.Database)
+			f.buf.WriteString(".")
+		}
+		if !strings.Contains(src.Pattern, "*") {
+			f.fail(fmt.Errorf("format: table pattern %q has no wildcard", src.Pattern))
+			return
+		}
+		f.buf.WriteString(src.Pattern)
+	case *TableCall:
+		f.buf.WriteString("table(")
+		f.expr(src.Name, 0)
+		f.buf.WriteString(")")
+	case *ParenTabularExpr:
+		f.buf.WriteString("(")
+		f.nestedTabularExpr(src.X)
+		f.buf.WriteString(")")
+	case *UnionSource:
+		f.union(src.SourceColumn, src.Tables)
+	case nil:
+		f.fail(errors.New("format: nil data source"))
+	default:
+		f.fail(fmt.Errorf("format: unknown data source %T", src))
+	}
+}
+
+// operator writes op without its pipe.
+// If wrap is true, then long argument lists are split across lines.
+func (f *formatter) operator(op TabularOperator, wrap bool) {
+	switch op := op.(type) {
+	case *CountOperator:
+		f.buf.WriteString("count")
+	case *WhereOperator:
+		f.buf.WriteString("where ")
+		if wrap {
+			f.wrappedPredicate(op.Predicate)
+		} else {
+			f.expr(op.Predicate, 0)
+		}
+	case *SortOperator:
+		f.buf.WriteString("sort by ")
+		for i, term := range op.Terms {
+			if i > 0 {
+				f.separator(wrap)
+			}
+			f.sortTerm(term)
+		}
+	case *TakeOperator:
+		f.buf.WriteString("take ")
+		f.expr(op.RowCount, 0)
+	case *TopOperator:
+		f.buf.WriteString("top ")
+		f.expr(op.RowCount, 0)
+		f.buf.WriteString(" by ")
+		f.sortTerm(op.Col)
+	case *ProjectOperator:
+		f.buf.WriteString("project ")
+		for i, col := range op.Cols {
+			if i > 0 {
+				f.separator(wrap)
+			}
+			if col == nil {
+				f.fail(errors.New("format: nil project column"))
+				return
+			}
+			f.column(col.Name, col.X)
+		}
+	case *UnionOperator:
+		f.union(op.SourceColumn, op.Tables)
+	case *ProjectAwayOperator:
+		f.buf.WriteString("project-away ")
+		for i, col := range op.Cols {
+			if i > 0 {
+				f.separator(wrap)
+			}
+			f.ident(col)
+		}
+	case *DistinctOperator:
+		f.buf.WriteString("distinct ")
+		for i, col := range op.Cols {
+			if i > 0 {
+				f.separator(wrap)
+			}
+			f.ident(col)
+		}
+	case *ExtendOperator:
+		f.buf.WriteString("extend ")
+		for i, col := range op.Cols {
+			if i > 0 {
+				f.separator(wrap)
+			}
+			if col == nil {
+				f.fail(errors.New("format: nil extend column"))
+				return
+			}
+			f.column(col.Name, col.X)
+		}
+	case *MvExpandOperator:
+		f.buf.WriteString("mv-expand ")
+		for i, col := range op.Cols {
+			if i > 0 {
+				f.separator(wrap)
+			}
+			f.mvExpandColumn(col)
+		}
+	case *SummarizeOperator:
+		f.buf.WriteString("summarize")
+		for i, col := range op.Cols {
+			if i == 0 {
+				f.buf.WriteString(" ")
+			} else {
+				f.separator(wrap)
+			}
+			f.summarizeColumn(col)
+		}
+		if len(op.GroupBy) > 0 {
+			if wrap && len(op.Cols) > 0 {
+				f.continuation()
+				f.buf.WriteString("by ")
+			} else {
+				f.buf.WriteString(" by ")
+			}
+			for i, col := range op.GroupBy {
+				if i > 0 {
+					f.separator(wrap)
+				}
+				f.summarizeColumn(col)
+			}
+		}
+	case *JoinOperator:
+		f.buf.WriteString("join ")
+		if op.Flavor != nil {
+			f.buf.WriteString("kind=")
+			f.buf.WriteString(f.identName(op.Flavor.Name))
+			f.buf.WriteString(" ")
+		}
+		for _, hint := range op.Hints {
+			f.buf.WriteString("hint.")
+			f.ident(hint.Name)
+			f.buf.WriteString("=")
+			f.ident(hint.Value)
+			f.buf.WriteString(" ")
+		}
+		f.buf.WriteString("(")
+		f.nestedTabularExpr(op.Right)
+		f.buf.WriteString(")")
+		if op.On.IsValid() || len(op.Conditions) > 0 {
+			f.buf.WriteString(" on ")
+		}
+		for i, cond := range op.Conditions {
+			if i > 0 {
+				f.separator(wrap)
+			}
+			f.expr(cond, 0)
+		}
+	case *AsOperator:
+		f.buf.WriteString("as ")
+		f.ident(op.Name)
+	case *RenderOperator:
+		f.buf.WriteString("render ")
+		f.ident(op.ChartType)
+		if len(op.Props) > 0 {
+			f.buf.WriteString(" with (")
+			for i, prop := range op.Props {
+				if i > 0 {
+					f.separator(wrap)
+				}
+				f.renderProperty(prop)
+			}
+			f.buf.WriteString(")")
+		}
+	case nil:
+		f.fail(errors.New("format: nil operator"))
+	default:
+		f.fail(fmt.Errorf("format: unknown operator %T", op))
+	}
+}
+
+func (f *formatter) sortTerm(term *SortTerm) {
+	if term == nil {
+		f.fail(errors.New("format: nil sort term"))
+		return
+	}
+	f.expr(term.X, 0)
+	if term.Asc {
+		f.buf.WriteString(" asc")
+	} else {
+		f.buf.WriteString(" desc")
+	}
+	// Only write the nulls order if it's different from the default
+	// for the sort direction.
+	switch {
+	case term.Asc && !term.NullsFirst:
+		f.buf.WriteString(" nulls last")
+	case !term.Asc && term.NullsFirst:
+		f.buf.WriteString(" nulls first")
+	}
+}
+
+func (f *formatter) column(name *Ident, x Expr) {
+	f.ident(name)
+	if x != nil {
+		f.buf.WriteString(" = ")
+		f.expr(x, 0)
+	}
+}
+
+func (f *formatter) summarizeColumn(col *SummarizeColumn) {
+	if col == nil {
+		f.fail(errors.New("format: nil summarize column"))
+		return
+	}
+	if col.Name != nil {
+		f.ident(col.Name)
+		f.buf.WriteString(" = ")
+	}
+	f.expr(col.X, 0)
+}
+
+// union writes the arguments of a union operator or data source.
+func (f *formatter) union(sourceColumn *Ident, tables []TabularDataSource) {
+	f.buf.WriteString("union ")
+	if sourceColumn != nil {
+		f.buf.WriteString("withsource=")
+		f.ident(sourceColumn)
+		f.buf.WriteString(" ")
+	}
+	for i, src := range tables {
+		if i > 0 {
+			f.buf.WriteString(", ")
+		}
+		f.dataSource(src)
+	}
+}
+
+func (f *formatter) mvExpandColumn(col *MvExpandColumn) {
+	if col == nil {
+		f.fail(errors.New("format: nil mv-expand column"))
+		return
+	}
+	if col.Name != nil {
+		f.ident(col.Name)
+		f.buf.WriteString(" = ")
+	}
+	f.expr(col.X, 0)
+}
+
+func (f *formatter) renderProperty(prop *RenderProperty) {
+	if prop == nil {
+		f.fail(errors.New("format: nil render property"))
+		return
+	}
+	f.ident(prop.Name)
+	f.buf.WriteString("=")
+	f.expr(prop.Value, 0)
+}
+
+// Expression precedence levels used by the formatter
+// in addition to the binary operator precedences from [operatorPrecedence].
+const (
+	// unaryPrecedence is the precedence of a unary expression.
+	unaryPrecedence = 5
+	// primaryPrecedence is the precedence of an expression
+	// that can be the operand of a unary or index expression.
+	primaryPrecedence = 6
+)
+
+// expr writes x, parenthesizing it if its precedence is below minPrecedence.
+func (f *formatter) expr(x Expr, minPrecedence int) {
+	if x == nil {
+		f.fail(errors.New("format: nil expression"))
+		return
+	}
+	if exprPrecedence(x) < minPrecedence {
+		f.buf.WriteString("(")
+		defer f.buf.WriteString(")")
+	}
+
+	switch x := x.(type) {
+	case *QualifiedIdent:
+		if len(x.Parts) == 0 {
+			f.fail(errors.New("format: empty qualified identifier"))
+			return
+		}
+		for i, part := range x.Parts {
+			if i > 0 {
+				f.buf.WriteString(".")
+			}
+			f.ident(part)
+		}
+	case *BasicLit:
+		f.basicLit(x)
+	case *BinaryExpr:
+		precedence := operatorPrecedence(x.Op)
+		op := binaryOperatorString(x.Op)
+		if precedence < 0 || op == "" {
+			f.fail(fmt.Errorf("format: unknown binary operator %v", x.Op))
+			return
+		}
+		// Binary operators are left-associative.
+		f.expr(x.X, precedence)
+		f.buf.WriteString(" ")
+		f.buf.WriteString(op)
+		f.buf.WriteString(" ")
+		f.expr(x.Y, precedence+1)
+	case *UnaryExpr:
+		switch x.Op {
+		case TokenPlus:
+			f.buf.WriteString("+")
+		case TokenMinus:
+			f.buf.WriteString("-")
+		default:
+			f.fail(fmt.Errorf("format: unknown unary operator %v", x.Op))
+			return
+		}
+		f.expr(x.X, primaryPrecedence)
+	case *InExpr:
+		f.expr(x.X, operatorPrecedence(TokenIn)+1)
+		f.buf.WriteString(" in (")
+		for i, val := range x.Vals {
+			if i > 0 {
+				f.buf.WriteString(", ")
+			}
+			f.expr(val, 0)
+		}
+		f.buf.WriteString(")")
+	case *ParenExpr:
+		f.buf.WriteString("(")
+		f.expr(x.X, 0)
+		f.buf.WriteString(")")
+	case *CallExpr:
+		if x.Func == nil || !isPlainIdent(x.Func.Name) {
+			f.fail(errors.New("format: function name must be a plain identifier"))
+			return
+		}
+		f.buf.WriteString(f.identName(x.Func.Name))
+		f.buf.WriteString("(")
+		for i, arg := range x.Args {
+			if i > 0 {
+				f.buf.WriteString(", ")
+			}
+			f.expr(arg, 0)
+		}
+		f.buf.WriteString(")")
+	case *IndexExpr:
+		// The parser only permits a single index expression
+		// after a primary expression.
+		f.expr(x.X, primaryPrecedence+1)
+		f.buf.WriteString("[")
+		f.expr(x.Index, 0)
+		f.buf.WriteString("]")
+	default:
+		f.fail(fmt.Errorf("format: unknown expression %T", x))
+	}
+}
+
+// wrappedPredicate writes x, breaking the line before each "and" or "or" operator
+// at the top level of x.
+func (f *formatter) wrappedPredicate(x Expr) {
+	binary, ok := x.(*BinaryExpr)
+	if !ok || (binary.Op != TokenAnd && binary.Op != TokenOr) {
+		f.expr(x, 0)
+		return
+	}
+	// Binary operators are left-associative,
+	// so a chain of the same operator nests on the left.
+	operands := []Expr{binary.Y}
+	for {
+		next, ok := binary.X.(*BinaryExpr)
+		if !ok || next.Op != binary.Op {
+			break
+		}
+		operands = append(operands, next.Y)
+		binary = next
+	}
+	operands = append(operands, binary.X)
+
+	precedence := operatorPrecedence(binary.Op)
+	op := binaryOperatorString(binary.Op)
+	f.expr(operands[len(operands)-1], precedence)
+	for i := len(operands) - 2; i >= 0; i-- {
+		f.continuation()
+		f.buf.WriteString(op)
+		f.buf.WriteString(" ")
+		f.expr(operands[i], precedence+1)
+	}
+}
+
+// exprPrecedence returns the precedence of the given expression.
+// Expressions with higher precedence bind more tightly.
+func exprPrecedence(x Expr) int {
+	switch x := x.(type) {
+	case *BinaryExpr:
+		return operatorPrecedence(x.Op)
+	case *InExpr:
+		return operatorPrecedence(TokenIn)
+	case *UnaryExpr:
+		return unaryPrecedence
+	case *IndexExpr:
+		return primaryPrecedence
+	default:
+		return primaryPrecedence + 1
+	}
+}
+
+func binaryOperatorString(op TokenKind) string {
+	switch op {
+	case TokenPlus:
+		return "+"
+	case TokenMinus:
+		return "-"
+	case TokenStar:
+		return "*"
+	case TokenSlash:
+		return "/"
+	case TokenMod:
+		return "%"
+	case TokenEq:
+		return "=="
+	case TokenNE:
+		return "!="
+	case TokenLT:
+		return "<"
+	case TokenLE:
+		return "<="
+	case TokenGT:
+		return ">"
+	case TokenGE:
+		return ">="
+	case TokenCaseInsensitiveEq:
+		return "=~"
+	case TokenCaseInsensitiveNE:
+		return "!~"
+	case TokenAnd:
+		return "and"
+	case TokenOr:
+		return "or"
+	default:
+		return ""
+	}
+}
+
+func (f *formatter) basicLit(lit *BasicLit) {
+	switch lit.Kind {
+	case TokenNumber:
+		f.buf.WriteString(lit.Value)
+	case TokenString:
+		f.buf.WriteString(`"`)
+		for _, c := range lit.Value {
+			switch c {
+			case '"', '\\':
+				f.buf.WriteRune('\\')
+				f.buf.WriteRune(c)
+			case '\n':
+				f.buf.WriteString(`\n`)
+			case '\t':
+				f.buf.WriteString(`\t`)
+			default:
+				f.buf.WriteRune(c)
+			}
+		}
+		f.buf.WriteString(`"`)
+	default:
+		f.fail(fmt.Errorf("format: unknown literal kind %v", lit.Kind))
+	}
+}
+
+func (f *formatter) ident(id *Ident) {
+	if id == nil {
+		f.fail(errors.New("format: nil identifier"))
+		return
+	}
+	name := f.identName(id.Name)
+	if isPlainIdent(name) {
+		f.buf.WriteString(name)
+		return
+	}
+	f.buf.WriteString("`")
+	f.buf.WriteString(strings.ReplaceAll(name, "`", "``"))
+	f.buf.WriteString("`")
+}
+
+// identName returns the identifier name to write for name.
+func (f *formatter) identName(name string) string {
+	if f.opts.LowercaseIdentifiers {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// isPlainIdent reports whether name can be written as an identifier
+// without backtick quoting.
+func isPlainIdent(name string) bool {
+	// The first token covers all of name only if it is the only token.
+	t := NewTokenizer(name)
+	return t.Next() &&
+		t.Token().Kind == TokenIdentifier &&
+		t.Token().Span == newSpan(0, len(name))
+}
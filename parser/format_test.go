@@ -0,0 +1,285 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "Table",
+			query: "StormEvents",
+			want:  "StormEvents",
+		},
+		{
+			name:  "QuotedTable",
+			query: "`Storm Events`",
+			want:  "`Storm Events`",
+		},
+		{
+			name:  "UnnecessaryQuotes",
+			query: "`StormEvents`",
+			want:  "StormEvents",
+		},
+		{
+			name:  "Database",
+			query: "system . `by`",
+			want:  "system.`by`",
+		},
+		{
+			name:  "Pipeline",
+			query: "StormEvents|filter State=='TEXAS'|limit 5|count",
+			want:  "StormEvents\n| where State == \"TEXAS\"\n| take 5\n| count",
+		},
+		{
+			name:  "Sort",
+			query: "StormEvents | order by a, b asc, c desc nulls first, d asc nulls last, e nulls last",
+			want:  "StormEvents\n| sort by a desc, b asc, c desc nulls first, d asc nulls last, e desc",
+		},
+		{
+			name:  "Top",
+			query: "StormEvents | top 3 by x",
+			want:  "StormEvents\n| top 3 by x desc",
+		},
+		{
+			name:  "ProjectExtend",
+			query: "StormEvents | project  a,b=c+1 | extend d=-a",
+			want:  "StormEvents\n| project a, b = c + 1\n| extend d = -a",
+		},
+		{
+			name:  "Summarize",
+			query: "StormEvents | summarize n=count(),sum(x) by State,y=z",
+			want:  "StormEvents\n| summarize n = count(), sum(x) by State, y = z",
+		},
+		{
+			name:  "SummarizeByOnly",
+			query: "StormEvents | summarize by State",
+			want:  "StormEvents\n| summarize by State",
+		},
+		{
+			name:  "ProjectAway",
+			query: "T | project-away  a,`b c`",
+			want:  "T\n| project-away a, `b c`",
+		},
+		{
+			name:  "Distinct",
+			query: "T | distinct  a,`b c`",
+			want:  "T\n| distinct a, `b c`",
+		},
+		{
+			name:  "MvExpand",
+			query: "T | mv-expand tags,p=split(path,'/')",
+			want:  "T\n| mv-expand tags, p = split(path, \"/\")",
+		},
+		{
+			name:  "Union",
+			query: "T | union  withsource = S U,(V|take 1)",
+			want:  "T\n| union withsource=S U, (\n    V\n    | take 1\n)",
+		},
+		{
+			name:  "UnionSource",
+			query: "union U, V* | count",
+			want:  "union U, V*\n| count",
+		},
+		{
+			name:  "Join",
+			query: "T | join kind=leftouter (U) on id, $left.a == $right.b",
+			want:  "T\n| join kind=leftouter (U) on id, $left.a == $right.b",
+		},
+		{
+			name:  "JoinHints",
+			query: "T | join kind=inner hint.strategy=shuffle hint.shufflekey=id (U) on id",
+			want:  "T\n| join kind=inner hint.strategy=shuffle hint.shufflekey=id (U) on id",
+		},
+		{
+			name:  "JoinCross",
+			query: "T | join kind=cross (U)",
+			want:  "T\n| join kind=cross (U)",
+		},
+		{
+			name:  "JoinPipeline",
+			query: "T | join (U | where x | take 5) on id | as J",
+			want:  "T\n| join (\n    U\n    | where x\n    | take 5\n) on id\n| as J",
+		},
+		{
+			name:  "DatabaseCall",
+			query: `database("security").Events`,
+			want:  "security.Events",
+		},
+		{
+			name:  "ClusterQualified",
+			query: `cluster("primary").database("my-db").Events`,
+			want:  "cluster(\"primary\").`my-db`.Events",
+		},
+		{
+			name:  "TableWildcard",
+			query: "logs.Events_*|count",
+			want:  "logs.Events_*\n| count",
+		},
+		{
+			name:  "TableCall",
+			query: `table( "Events_"+suffix )`,
+			want:  `table("Events_" + suffix)`,
+		},
+		{
+			name:  "ParenSource",
+			query: "(T | where x) | count",
+			want:  "(\n    T\n    | where x\n)\n| count",
+		},
+		{
+			name:  "ParenSourceWithoutOperators",
+			query: "( T ) | count",
+			want:  "(T)\n| count",
+		},
+		{
+			name:  "Render",
+			query: "T | render timechart with (title='Hi', ymin=0)",
+			want:  "T\n| render timechart with (title=\"Hi\", ymin=0)",
+		},
+		{
+			name:  "Let",
+			query: "let  x=1+2",
+			want:  "let x = 1 + 2",
+		},
+		{
+			name:  "LetTabular",
+			query: "let t = T | take 1",
+			want:  "let t = T\n| take 1",
+		},
+		{
+			name:  "Precedence",
+			query: "T | where a + b * c - (d - e) / f % 2 > 0 and x in (1, 2) or not(y)",
+			want:  "T\n| where a + b * c - (d - e) / f % 2 > 0 and x in (1, 2) or not(y)",
+		},
+		{
+			name:  "ParenthesesPreserved",
+			query: "T | where (a or b) and (c)",
+			want:  "T\n| where (a or b) and (c)",
+		},
+		{
+			name:  "Index",
+			query: "T | project x = parse_json(y)['k'], z = a.b['c']",
+			want:  "T\n| project x = parse_json(y)[\"k\"], z = a.b[\"c\"]",
+		},
+		{
+			name:  "StringEscapes",
+			query: `T | where x == 'it\'s "q"\n'`,
+			want:  "T\n| where x == \"it's \\\"q\\\"\\n\"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stmts, err := Parse(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Format(stmts[0])
+			if err != nil {
+				t.Fatal("Format:", err)
+			}
+			if got != test.want {
+				t.Errorf("Format(Parse(%q)) =\n%s\nwant:\n%s", test.query, got, test.want)
+			}
+
+			// Formatted source must parse to an equivalent tree
+			// and formatting must be idempotent.
+			// Identifiers may lose unnecessary quotes.
+			reparsed, err := Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := cmp.Options{
+				cmpopts.IgnoreTypes(Span{}),
+				cmpopts.IgnoreFields(Ident{}, "Quoted"),
+				cmpopts.EquateEmpty(),
+			}
+			if diff := cmp.Diff(stmts[0], reparsed[0], opts); diff != "" {
+				t.Errorf("Parse(Format(Parse(%q))) (-want +got):\n%s", test.query, diff)
+			}
+			again, err := Format(reparsed[0])
+			if err != nil {
+				t.Fatal("Format:", err)
+			}
+			if again != got {
+				t.Errorf("Format is not idempotent:\n%s\nthen:\n%s", got, again)
+			}
+		})
+	}
+}
+
+func TestFormatRewritten(t *testing.T) {
+	stmts, err := Parse("T | where a and b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replace "b" with "c or d", which needs parentheses to keep its structure.
+	root := Rewrite(stmts[0], func(c *Cursor) bool {
+		if id, ok := c.Node().(*QualifiedIdent); ok && id.Parts[0].Name == "b" {
+			c.Replace(&BinaryExpr{
+				X:      (&Ident{Name: "c", NameSpan: nullSpan()}).AsQualified(),
+				OpSpan: nullSpan(),
+				Op:     TokenOr,
+				Y:      (&Ident{Name: "d", NameSpan: nullSpan()}).AsQualified(),
+			})
+		}
+		return true
+	})
+	got, err := Format(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "T\n| where a and (c or d)"
+	if got != want {
+		t.Errorf("Format(...) = %q; want %q", got, want)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		node Node
+	}{
+		{name: "Nil", node: nil},
+		{
+			name: "NilPredicate",
+			node: &TabularExpr{
+				Source:    &TableRef{Table: &Ident{Name: "T", NameSpan: nullSpan()}},
+				Operators: []TabularOperator{&WhereOperator{Pipe: nullSpan(), Keyword: nullSpan()}},
+			},
+		},
+		{
+			name: "QuotedFunction",
+			node: &CallExpr{
+				Func:   &Ident{Name: "not a func", NameSpan: nullSpan()},
+				Lparen: nullSpan(),
+				Rparen: nullSpan(),
+			},
+		},
+		{
+			name: "BadOperator",
+			node: &BinaryExpr{
+				X:      &BasicLit{ValueSpan: nullSpan(), Kind: TokenNumber, Value: "1"},
+				OpSpan: nullSpan(),
+				Op:     TokenComma,
+				Y:      &BasicLit{ValueSpan: nullSpan(), Kind: TokenNumber, Value: "2"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := Format(test.node); err == nil {
+				t.Errorf("Format(...) = %q, <nil>; want error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import "fmt"
+
+// A ParseResult is a parsed query
+// that can be updated efficiently after the query text is edited.
+// ParseResults are immutable
+// and may share AST nodes with the ParseResults they were derived from.
+// Callers must not modify the AST nodes they obtain from a ParseResult.
+type ParseResult struct {
+	source     string
+	limits     parseLimits
+	tokens     []Token
+	stmts      []*parsedStatement
+	statements []Statement
+	err        error
+
+	// arena is the memory that the syntax tree was allocated from
+	// if the query was parsed with [ParseOptions.Pooled].
+	arena *arena
+}
+
+// parsedStatement is the parse result of a single semicolon-separated statement.
+type parsedStatement struct {
+	// span is the span from the start of the statement's first token
+	// to the end of its last token.
+	// It is invalid for an empty statement.
+	span Span
+	stmt Statement
+	err  error
+}
+
+// ParseIncremental parses a query like [Parse],
+// but returns a ParseResult that can be updated with [*ParseResult.Reparse].
+// This is equivalent to new(ParseOptions).ParseIncremental(query).
+func ParseIncremental(query string) *ParseResult {
+	return ((*ParseOptions)(nil)).ParseIncremental(query)
+}
+
+// ParseIncremental parses a query like [ParseOptions.Parse],
+// but returns a ParseResult that can be updated with [*ParseResult.Reparse].
+// The returned ParseResult uses the same options when it is reparsed.
+func (opts *ParseOptions) ParseIncremental(query string) *ParseResult {
+	return newParseResult(query, opts.limits(), nil, Span{}, 0, opts != nil && opts.Pooled)
+}
+
+// Release returns the memory of a ParseResult parsed with [ParseOptions.Pooled]
+// to the pool so that it can be used by later parses.
+// After calling Release, the caller must not use r
+// or any of the syntax tree nodes obtained from it.
+// Release does nothing if r was not parsed with [ParseOptions.Pooled].
+func (r *ParseResult) Release() {
+	if r.arena == nil {
+		return
+	}
+	a := r.arena
+	*r = ParseResult{}
+	a.release()
+}
+
+// Source returns the text of the query.
+func (r *ParseResult) Source() string {
+	return r.source
+}
+
+// Tokens returns the tokens of the query, the same as [Scan] would return.
+// The caller must not modify the returned slice.
+func (r *ParseResult) Tokens() []Token {
+	return r.tokens
+}
+
+// Statements returns the statements in the query.
+// The caller must not modify the returned slice.
+func (r *ParseResult) Statements() []Statement {
+	return r.statements
+}
+
+// Err returns the errors encountered while parsing the query
+// or nil if the query was parsed successfully.
+// It is the same error that [Parse] would return for the query.
+func (r *ParseResult) Err() error {
+	return r.err
+}
+
+// Diagnostics returns the problems encountered while parsing the query.
+// It is equivalent to calling [Diagnostics] on [ParseResult.Err].
+func (r *ParseResult) Diagnostics() []Diagnostic {
+	return Diagnostics(r.err)
+}
+
+// Reparse returns the result of parsing the query formed
+// by replacing the given span of r's source with newText.
+// Statements that do not overlap the edited span
+// are reused from r instead of being parsed again,
+// and only the text around the edit is scanned for tokens.
+// The returned ParseResult is equivalent to calling [ParseIncremental]
+// on the new query.
+// If r was parsed with [ParseOptions.Pooled],
+// the new query is parsed from scratch into its own pooled memory,
+// so that each result can be released independently.
+// Reparse panics if span is not a valid span of r's source.
+func (r *ParseResult) Reparse(span Span, newText string) *ParseResult {
+	if !span.IsValid() || span.End > len(r.source) {
+		panic(fmt.Errorf("reparse: edit span %v out of range for query of length %d", span, len(r.source)))
+	}
+	newSource := r.source[:span.Start] + newText + r.source[span.End:]
+	if r.arena != nil {
+		return newParseResult(newSource, r.limits, nil, Span{}, 0, true)
+	}
+	return newParseResult(newSource, r.limits, r, span, len(newText), false)
+}
+
+// newParseResult parses source.
+// If prev is not nil, then source must be the result of replacing editSpan
+// in prev's source with newLen bytes of text,
+// and statements outside the edit are reused from prev.
+// If pooled is true, the syntax tree is allocated from a pooled arena.
+func newParseResult(source string, limits parseLimits, prev *ParseResult, editSpan Span, newLen int, pooled bool) *ParseResult {
+	r := &ParseResult{source: source, limits: limits}
+	var tokens []Token
+	switch {
+	case pooled:
+		r.arena = getArena()
+		tokens = r.arena.scan(source)
+	case prev != nil:
+		tokens = rescan(prev.tokens, source, editSpan, newLen)
+	default:
+		tokens = Scan(source)
+	}
+	r.tokens = tokens
+	stmtTokens := splitStatements(tokens)
+	if err := limits.check(source, tokens, stmtTokens); err != nil {
+		r.err = fmt.Errorf("parse pipeline query language: %w", err)
+		return r
+	}
+
+	var reusable map[Span]*parsedStatement
+	if prev != nil {
+		reusable = make(map[Span]*parsedStatement, len(prev.stmts))
+		for _, ps := range prev.stmts {
+			if ps.span.IsValid() && ps.stmt != nil && ps.err == nil {
+				reusable[ps.span] = ps
+			}
+		}
+	}
+	var resultError error
+	for _, tokens := range stmtTokens {
+		ps := &parsedStatement{span: nullSpan()}
+		if len(tokens) > 0 {
+			ps.span = newSpan(tokens[0].Span.Start, tokens[len(tokens)-1].Span.End)
+		}
+		if old, shift := reusableStatement(reusable, ps.span, editSpan, newLen); old != nil {
+			ps.stmt = shiftSpans(old.stmt, shift)
+		} else {
+			ps.stmt, ps.err = parseStatement(source, tokens, r.arena)
+		}
+		r.stmts = append(r.stmts, ps)
+		if ps.stmt != nil {
+			r.statements = append(r.statements, ps.stmt)
+		}
+		resultError = joinErrors(resultError, ps.err)
+	}
+	if resultError != nil {
+		r.err = fmt.Errorf("parse pipeline query language: %w", resultError)
+	}
+	return r
+}
+
+// rescan returns the tokens of source,
+// which is the result of replacing editSpan in the source prev was scanned from
+// with newLen bytes of text.
+// Tokens that end before the edit are reused as-is.
+// Scanning resumes after them and stops at the first token after the edit
+// that starts at the same place as a token in prev,
+// since the scanner does not carry any state from one token to the next:
+// the rest of the tokens are copied from prev with their spans shifted.
+func rescan(prev []Token, source string, editSpan Span, newLen int) []Token {
+	// Tokens that end right at the edit may change
+	// (e.g. an identifier that was extended).
+	keep := 0
+	for keep < len(prev) && prev[keep].Span.End < editSpan.Start {
+		keep++
+	}
+	start := 0
+	if keep > 0 {
+		start = prev[keep-1].Span.End
+	}
+	tokens := make([]Token, keep, len(prev)+1)
+	copy(tokens, prev)
+
+	delta := newLen - editSpan.Len()
+	editEnd := editSpan.Start + newLen
+	next := keep
+	for t := NewTokenizer(source[start:]); t.Next(); {
+		tok := t.Token()
+		tok.Span.Start += start
+		tok.Span.End += start
+		if tok.Span.Start >= editEnd {
+			oldStart := tok.Span.Start - delta
+			for next < len(prev) && prev[next].Span.Start < oldStart {
+				next++
+			}
+			if next < len(prev) && prev[next].Span.Start == oldStart {
+				for _, old := range prev[next:] {
+					old.Span.Start += delta
+					old.Span.End += delta
+					tokens = append(tokens, old)
+				}
+				return tokens
+			}
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
+
+// reusableStatement returns the previously parsed statement
+// that has the same text as the statement at span in the edited source
+// or nil if there is no such statement.
+// It also returns the offset to add to the previous statement's spans.
+func reusableStatement(reusable map[Span]*parsedStatement, span, editSpan Span, newLen int) (_ *parsedStatement, delta int) {
+	if len(reusable) == 0 || !span.IsValid() {
+		return nil, 0
+	}
+	switch {
+	case span.End <= editSpan.Start:
+		return reusable[span], 0
+	case span.Start >= editSpan.Start+newLen:
+		delta := newLen - editSpan.Len()
+		return reusable[newSpan(span.Start-delta, span.End-delta)], delta
+	default:
+		return nil, 0
+	}
+}
+
+// splitStatements splits the tokens of a query into semicolon-separated statements.
+// The semicolon tokens are not included in the result.
+func splitStatements(tokens []Token) [][]Token {
+	var stmts [][]Token
+	start := 0
+	for i, tok := range tokens {
+		if tok.Kind == TokenSemi {
+			stmts = append(stmts, tokens[start:i])
+			start = i + 1
+		}
+	}
+	return append(stmts, tokens[start:])
+}
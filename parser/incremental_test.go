@@ -0,0 +1,141 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestReparse(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		span    Span
+		newText string
+	}{
+		{
+			name:    "EditMiddleStatement",
+			query:   "let x = 1;\nStormEvents | where x > 5;\nlet y = 2",
+			span:    newSpan(35, 36),
+			newText: "10",
+		},
+		{
+			name:    "InsertStatement",
+			query:   "let x = 1;\nStormEvents | take x",
+			span:    newSpan(10, 10),
+			newText: "\nlet y = 2;",
+		},
+		{
+			name:    "DeleteSemicolon",
+			query:   "let x = 1;\nlet y = 2;\nStormEvents | take x",
+			span:    newSpan(9, 10),
+			newText: "",
+		},
+		{
+			name:    "IntroduceError",
+			query:   "let x = 1;\nStormEvents | take x",
+			span:    newSpan(11, 22),
+			newText: "StormEvents | bogus",
+		},
+		{
+			name:    "FixError",
+			query:   "let x = 1;\nStormEvents | bogus;\nlet y = x",
+			span:    newSpan(25, 30),
+			newText: "take 5",
+		},
+		{
+			name:    "OpenString",
+			query:   "let x = 1;\nlet y = 2;\nStormEvents | take x",
+			span:    newSpan(8, 8),
+			newText: `"`,
+		},
+		{
+			name:    "StartComment",
+			query:   "let x = 1;\nlet y = 2;\nStormEvents | take x",
+			span:    newSpan(10, 10),
+			newText: "//",
+		},
+		{
+			name:    "ExtendIdentifier",
+			query:   "let x = 1;\nStorm | take x",
+			span:    newSpan(16, 16),
+			newText: "Events",
+		},
+		{
+			name:    "AppendToEnd",
+			query:   "let x = 1;\nStormEvents | take x",
+			span:    newSpan(31, 31),
+			newText: " | count",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prev := ParseIncremental(test.query)
+			got := prev.Reparse(test.span, test.newText)
+
+			newQuery := test.query[:test.span.Start] + test.newText + test.query[test.span.End:]
+			if got.Source() != newQuery {
+				t.Errorf("Reparse(...).Source() = %q; want %q", got.Source(), newQuery)
+			}
+			want, wantErr := Parse(newQuery)
+			if diff := cmp.Diff(want, got.Statements(), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Reparse(...).Statements() (-Parse(%q) +got):\n%s", newQuery, diff)
+			}
+			if got, want := errorString(got.Err()), errorString(wantErr); got != want {
+				t.Errorf("Reparse(...).Err() = %s; want %s", got, want)
+			}
+			if diff := cmp.Diff(Scan(newQuery), got.Tokens(), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Reparse(...).Tokens() (-Scan(%q) +got):\n%s", newQuery, diff)
+			}
+		})
+	}
+}
+
+func TestReparseTokens(t *testing.T) {
+	edits := []string{"", "x", `"`, "//", "="}
+	for _, test := range parserTests {
+		prev := ParseIncremental(test.query)
+		for start := 0; start <= len(test.query); start++ {
+			for end := start; end <= start+1 && end <= len(test.query); end++ {
+				for _, newText := range edits {
+					got := prev.Reparse(newSpan(start, end), newText).Tokens()
+					newQuery := test.query[:start] + newText + test.query[end:]
+					if diff := cmp.Diff(Scan(newQuery), got, cmpopts.EquateEmpty()); diff != "" {
+						t.Fatalf("ParseIncremental(%q).Reparse(%v, %q).Tokens() (-Scan(%q) +got):\n%s",
+							test.query, newSpan(start, end), newText, newQuery, diff)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReparseReusesStatements(t *testing.T) {
+	const query = "let x = 1;\nStormEvents | where x > 5;\nlet y = 2"
+	prev := ParseIncremental(query)
+	// Replace "5" with "10".
+	got := prev.Reparse(newSpan(35, 36), "10")
+	if prev.Statements()[0] != got.Statements()[0] {
+		t.Error("statement before edit was not reused")
+	}
+	if prev.Statements()[1] == got.Statements()[1] {
+		t.Error("edited statement was reused")
+	}
+	if want, got := newSpan(39, 48), got.Statements()[2].Span(); got != want {
+		t.Errorf("statement after edit has span %v; want %v", got, want)
+	}
+	if want, got := newSpan(38, 47), prev.Statements()[2].Span(); got != want {
+		t.Errorf("original statement after edit has span %v; want %v", got, want)
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
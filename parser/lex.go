@@ -119,6 +119,17 @@ const (
 	// The Value will be the empty string.
 	TokenSemi
 
+	// TokenWhitespace is a sequence of one or more whitespace characters.
+	// It is only returned by [ScanFull].
+	// The Value will be the empty string.
+	TokenWhitespace
+	// TokenComment is a comment that starts with "//"
+	// and continues to the end of the line,
+	// not including the line break.
+	// It is only returned by [ScanFull].
+	// The Value will be the comment's text after the "//".
+	TokenComment
+
 	// TokenError is a marker for a scan error.
 	// The Value will contain the error message.
 	TokenError TokenKind = -1
@@ -147,201 +158,318 @@ type scanner struct {
 	s    string
 	pos  int
 	last int
+	// full is true if whitespace and comment tokens are returned.
+	full bool
 }
 
 // Scan turns a Pipeline Query Language statement into a sequence of [Token] values.
 // Errors will be indicated with the [TokenError] kind.
+// To read the tokens without building a slice, use [Tokens] or a [Tokenizer].
 func Scan(query string) []Token {
-	s := scanner{s: query}
-	var tokens []Token
+	return scan(query, false)
+}
+
+// ScanFull is like [Scan], but it also returns [TokenWhitespace] and [TokenComment] tokens,
+// so the spans of the returned tokens cover the entire query.
+// This is useful for tools like syntax highlighters that need to preserve all of the source.
+// The tokens returned by ScanFull are not suitable for parsing.
+func ScanFull(query string) []Token {
+	return scan(query, true)
+}
+
+// token returns the next token in the scanner's input.
+// It returns false if there are no more tokens.
+func (s *scanner) token() (Token, bool) {
 	for {
 		start := s.pos
 		c, ok := s.next()
 		if !ok {
-			break
+			return Token{}, false
 		}
 		switch {
 		case unicode.IsSpace(c):
-			// Skip insignificant whitespace.
+			// Whitespace is insignificant unless a full scan was requested.
+			for {
+				c, ok := s.next()
+				if !ok {
+					break
+				}
+				if !unicode.IsSpace(c) {
+					s.prev()
+					break
+				}
+			}
+			if s.full {
+				return Token{
+					Kind: TokenWhitespace,
+					Span: newSpan(start, s.pos),
+				}, true
+			}
 		case isAlpha(c) || c == '_' || c == '$':
 			s.prev()
-			tokens = append(tokens, s.ident())
+			return s.ident(), true
 		case isDigit(c) || c == '.':
 			s.prev()
-			tokens = append(tokens, s.numberOrDot())
+			return s.numberOrDot(), true
 		case c == ',':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenComma,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == '"' || c == '\'':
 			s.prev()
-			tokens = append(tokens, s.string())
+			return s.string(), true
 		case c == '`':
 			s.prev()
-			tokens = append(tokens, s.quotedIdent())
+			return s.quotedIdent(), true
 		case c == '|':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenPipe,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == '(':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenLParen,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == ')':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenRParen,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == '[':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenLBracket,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == ']':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenRBracket,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == '=':
 			c, ok := s.next()
 			switch {
 			case ok && c == '=':
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenEq,
 					Span: newSpan(start, s.pos),
-				})
+				}, true
 			case ok && c == '~':
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenCaseInsensitiveEq,
 					Span: newSpan(start, s.pos),
-				})
+				}, true
 			default:
 				if ok {
 					s.prev()
 				}
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenAssign,
 					Span: newSpan(start, s.pos),
-				})
+				}, true
 			}
 		case c == '!':
 			c, ok := s.next()
 			switch {
 			case ok && c == '=':
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenNE,
 					Span: newSpan(start, s.pos),
-				})
+				}, true
 			case ok && c == '~':
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenCaseInsensitiveNE,
 					Span: newSpan(start, s.pos),
-				})
+				}, true
 			default:
 				// TODO(maybe): Turn this into logical inversion?
 				// KQL seems to use the not() function.
-				tokens = append(tokens,
-					errorToken(newSpan(start, s.pos), "unrecognized token '!'"),
-				)
+				return errorToken(newSpan(start, s.pos), "unrecognized token '!'"), true
 			}
 		case c == '+':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenPlus,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == '-':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenMinus,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == '*':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenStar,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == '/':
 			// Check for double-slash comment.
 			c, ok = s.next()
 			if !ok {
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenSlash,
 					Span: newSpan(start, s.pos),
-				})
-				continue
+				}, true
 			}
 			if c == '/' {
 				// It's a comment, consume to end of line.
 				for {
 					c, ok = s.next()
-					if !ok || c == '\n' {
+					if !ok {
 						break
 					}
+					if c == '\n' {
+						s.prev()
+						break
+					}
+				}
+				if s.full {
+					return Token{
+						Kind:  TokenComment,
+						Span:  newSpan(start, s.pos),
+						Value: s.s[start+len("//") : s.pos],
+					}, true
 				}
 				continue
 			}
 			s.prev()
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenSlash,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == '%':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenMod,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		case c == '<':
 			if c, ok := s.next(); ok && c == '=' {
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenLE,
 					Span: newSpan(start, s.pos),
-				})
+				}, true
 			} else {
 				if ok {
 					s.prev()
 				}
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenLT,
 					Span: newSpan(start, s.pos),
-				})
+				}, true
 			}
 		case c == '>':
 			if c, ok := s.next(); ok && c == '=' {
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenGE,
 					Span: newSpan(start, s.pos),
-				})
+				}, true
 			} else {
 				if ok {
 					s.prev()
 				}
-				tokens = append(tokens, Token{
+				return Token{
 					Kind: TokenGT,
 					Span: newSpan(start, s.pos),
-				})
+				}, true
 			}
 		case c == ';':
-			tokens = append(tokens, Token{
+			return Token{
 				Kind: TokenSemi,
 				Span: newSpan(start, s.pos),
-			})
+			}, true
 		default:
 			span := newSpan(start, s.pos)
-			tokens = append(tokens, errorToken(span, "unrecognized character %q", spanString(query, span)))
+			return errorToken(span, "unrecognized character %q", spanString(s.s, span)), true
 		}
 	}
+}
+
+func scan(query string, full bool) []Token {
+	t := &Tokenizer{s: scanner{s: query, full: full}}
+	// Queries average a little under one token per four bytes.
+	tokens := make([]Token, 0, len(query)/4+1)
+	for t.Next() {
+		tokens = append(tokens, t.Token())
+	}
 	return tokens
 }
 
+// A Tokenizer reads the tokens of a query one at a time
+// without allocating a slice for them.
+// Token values that are part of the query,
+// like identifiers and numbers written in decimal,
+// refer to the query instead of being copied,
+// so most tokens are read without allocating.
+//
+//	t := parser.NewTokenizer(query)
+//	for t.Next() {
+//		tok := t.Token()
+//		...
+//	}
+type Tokenizer struct {
+	s   scanner
+	tok Token
+}
+
+// NewTokenizer returns a Tokenizer that reads the same tokens
+// that [Scan] returns for query.
+func NewTokenizer(query string) *Tokenizer {
+	return &Tokenizer{s: scanner{s: query}}
+}
+
+// NewFullTokenizer returns a Tokenizer that reads the same tokens
+// that [ScanFull] returns for query,
+// including whitespace and comments.
+func NewFullTokenizer(query string) *Tokenizer {
+	return &Tokenizer{s: scanner{s: query, full: true}}
+}
+
+// Next advances the Tokenizer to the next token,
+// which will then be available through [*Tokenizer.Token].
+// It returns false when there are no more tokens.
+func (t *Tokenizer) Next() bool {
+	var ok bool
+	t.tok, ok = t.s.token()
+	return ok
+}
+
+// Token returns the token read by the most recent call to [*Tokenizer.Next].
+func (t *Tokenizer) Token() Token {
+	return t.tok
+}
+
+// Tokens returns an iterator over the tokens that [Scan] returns for query.
+// With Go 1.23 or later, the tokens can be read with a range loop:
+//
+//	for tok := range parser.Tokens(query) {
+//		...
+//	}
+//
+// Unlike Scan, Tokens does not allocate a slice for the tokens,
+// and it stops scanning when the loop ends.
+func Tokens(query string) func(yield func(Token) bool) {
+	return func(yield func(Token) bool) {
+		s := scanner{s: query}
+		for {
+			tok, ok := s.token()
+			if !ok || !yield(tok) {
+				return
+			}
+		}
+	}
+}
+
 // SplitStatements splits the given string by semicolons.
+// Semicolons in strings, quoted identifiers, and comments do not split the string,
+// but a semicolon after an unclosed quote on the same line does,
+// so that a missing quote only affects its own statement.
+// To split statements as they are read from an [io.Reader], use a [StatementScanner].
 func SplitStatements(source string) []string {
-	tokens := Scan(source)
+	t := NewTokenizer(source)
 	var parts []string
 	start := 0
-	for _, tok := range tokens {
-		if tok.Kind == TokenSemi {
+	for t.Next() {
+		if tok := t.Token(); tok.Kind == TokenSemi {
 			parts = append(parts, source[start:tok.Span.Start])
 			start = tok.Span.End
 		}
@@ -388,12 +516,17 @@ func (s *scanner) quotedIdent() Token {
 		return errorToken(newSpan(start, s.pos), "parse quoted identifier: expected '`', found %q", c)
 	}
 
+	semi := -1
 	for {
 		c, ok := s.next()
 		if !ok {
-			return errorToken(newSpan(start, s.pos), "parse quoted identifier: unexpected EOF")
+			return s.unterminated(start, semi, "parse quoted identifier: unexpected EOF")
 		}
 		switch c {
+		case ';':
+			if semi < 0 {
+				semi = s.last
+			}
 		case '`':
 			// Check if double backtick.
 			c, ok = s.next()
@@ -409,7 +542,7 @@ func (s *scanner) quotedIdent() Token {
 			}
 		case '\n':
 			s.prev()
-			return errorToken(newSpan(start, s.pos), "parse quoted identifier: unexpected end of line")
+			return s.unterminated(start, semi, "parse quoted identifier: unexpected end of line")
 		}
 	}
 }
@@ -598,10 +731,11 @@ func (s *scanner) string() Token {
 
 	valueStart := s.pos
 	var valueBuilder *strings.Builder // nil if no escapes encountered
+	semi := -1
 	for {
 		c, ok := s.next()
 		if !ok {
-			return errorToken(newSpan(start, s.pos), "unterminated string")
+			return s.unterminated(start, semi, "unterminated string")
 		}
 		switch c {
 		case quoteChar:
@@ -618,7 +752,7 @@ func (s *scanner) string() Token {
 			}
 		case '\n':
 			s.prev()
-			return errorToken(newSpan(start, s.pos), "unterminated string")
+			return s.unterminated(start, semi, "unterminated string")
 		case '\\':
 			if valueBuilder == nil {
 				valueBuilder = new(strings.Builder)
@@ -626,12 +760,15 @@ func (s *scanner) string() Token {
 			}
 			c, ok := s.next()
 			if !ok {
-				return errorToken(newSpan(start, s.pos), "unterminated string")
+				return s.unterminated(start, semi, "unterminated string")
+			}
+			if c == ';' && semi < 0 {
+				semi = s.last
 			}
 			switch c {
 			case '\n':
 				s.prev()
-				return errorToken(newSpan(start, s.pos), "unterminated string")
+				return s.unterminated(start, semi, "unterminated string")
 			case 'n':
 				valueBuilder.WriteRune('\n')
 			case 't':
@@ -640,6 +777,9 @@ func (s *scanner) string() Token {
 				valueBuilder.WriteRune(c)
 			}
 		default:
+			if c == ';' && semi < 0 {
+				semi = s.last
+			}
 			if valueBuilder != nil {
 				valueBuilder.WriteRune(c)
 			}
@@ -647,6 +787,20 @@ func (s *scanner) string() Token {
 	}
 }
 
+// unterminated returns an error token for a string or quoted identifier
+// that starts at start and is not closed before the end of the line.
+// If the text after the opening quote contains a semicolon,
+// the token ends before the first one (at semi)
+// so that the semicolon still ends the statement.
+// Otherwise, a stray quote or a backslash before the closing quote
+// would join the statement with the next one.
+func (s *scanner) unterminated(start, semi int, msg string) Token {
+	if semi >= 0 {
+		s.setPos(semi)
+	}
+	return errorToken(newSpan(start, s.pos), "%s", msg)
+}
+
 func (s *scanner) next() (rune, bool) {
 	if s.pos >= len(s.s) {
 		return 0, false
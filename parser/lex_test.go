@@ -4,6 +4,7 @@
 package parser
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -515,6 +516,85 @@ func TestScan(t *testing.T) {
 	}
 }
 
+func TestScanFull(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []Token
+	}{
+		{
+			name:  "Empty",
+			query: "",
+			want:  []Token{},
+		},
+		{
+			name:  "Whitespace",
+			query: "foo \t| \n bar\n",
+			want: []Token{
+				{Kind: TokenIdentifier, Span: newSpan(0, 3), Value: "foo"},
+				{Kind: TokenWhitespace, Span: newSpan(3, 5)},
+				{Kind: TokenPipe, Span: newSpan(5, 6)},
+				{Kind: TokenWhitespace, Span: newSpan(6, 9)},
+				{Kind: TokenIdentifier, Span: newSpan(9, 12), Value: "bar"},
+				{Kind: TokenWhitespace, Span: newSpan(12, 13)},
+			},
+		},
+		{
+			name:  "Comments",
+			query: "// hello\nfoo // world",
+			want: []Token{
+				{Kind: TokenComment, Span: newSpan(0, 8), Value: " hello"},
+				{Kind: TokenWhitespace, Span: newSpan(8, 9)},
+				{Kind: TokenIdentifier, Span: newSpan(9, 12), Value: "foo"},
+				{Kind: TokenWhitespace, Span: newSpan(12, 13)},
+				{Kind: TokenComment, Span: newSpan(13, 21), Value: " world"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ScanFull(test.query)
+			if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("ScanFull(%q) (-want +got):\n%s", test.query, diff)
+			}
+		})
+	}
+
+	// ScanFull should return the same tokens as Scan
+	// with whitespace and comments interspersed.
+	for _, test := range lexTests {
+		full := ScanFull(test.query)
+		if err := checkFullCoverage(test.query, full); err != "" {
+			t.Errorf("ScanFull(%q) %s", test.query, err)
+		}
+		var filtered []Token
+		for _, tok := range full {
+			if tok.Kind != TokenWhitespace && tok.Kind != TokenComment {
+				filtered = append(filtered, tok)
+			}
+		}
+		if diff := cmp.Diff(Scan(test.query), filtered, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("ScanFull(%q) without whitespace and comments (-Scan +ScanFull):\n%s", test.query, diff)
+		}
+	}
+}
+
+// checkFullCoverage returns a non-empty string
+// if the tokens' spans do not cover the entire query in order.
+func checkFullCoverage(query string, tokens []Token) string {
+	pos := 0
+	for i, tok := range tokens {
+		if tok.Span.Start != pos {
+			return fmt.Sprintf("[%d].Span = %v; want to start at %d", i, tok.Span, pos)
+		}
+		pos = tok.Span.End
+	}
+	if pos != len(query) {
+		return fmt.Sprintf("ends at %d; want %d", pos, len(query))
+	}
+	return ""
+}
+
 func FuzzScan(f *testing.F) {
 	for _, test := range lexTests {
 		f.Add(test.query)
@@ -531,6 +611,9 @@ func FuzzScan(f *testing.F) {
 				t.Errorf("Scan(%q)[%d].Span = %v; out of bounds of [0,%d)", query, i, tok.Span, len(query))
 			}
 		}
+		if err := checkFullCoverage(query, ScanFull(query)); err != "" {
+			t.Errorf("ScanFull(%q) %s", query, err)
+		}
 	})
 }
 
@@ -542,6 +625,65 @@ func BenchmarkScan(b *testing.B) {
 	}
 }
 
+func TestTokenizer(t *testing.T) {
+	for _, test := range lexTests {
+		var got []Token
+		for tk := NewTokenizer(test.query); tk.Next(); {
+			got = append(got, tk.Token())
+		}
+		if diff := cmp.Diff(Scan(test.query), got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("NewTokenizer(%q) tokens (-Scan +Tokenizer):\n%s", test.query, diff)
+		}
+
+		got = got[:0]
+		for tk := NewFullTokenizer(test.query); tk.Next(); {
+			got = append(got, tk.Token())
+		}
+		if diff := cmp.Diff(ScanFull(test.query), got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("NewFullTokenizer(%q) tokens (-ScanFull +Tokenizer):\n%s", test.query, diff)
+		}
+
+		got = got[:0]
+		Tokens(test.query)(func(tok Token) bool {
+			got = append(got, tok)
+			return true
+		})
+		if diff := cmp.Diff(Scan(test.query), got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Tokens(%q) (-Scan +Tokens):\n%s", test.query, diff)
+		}
+	}
+
+	// Tokens stops when yield returns false.
+	n := 0
+	Tokens("a | b | c")(func(tok Token) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Errorf("Tokens(...) yielded %d tokens after stopping at 2", n)
+	}
+}
+
+func TestTokenizerAllocs(t *testing.T) {
+	const query = `StormEvents | where EventType == "Tornado" or DamageProperty > 5000 // comment`
+	got := testing.AllocsPerRun(100, func() {
+		for tk := NewFullTokenizer(query); tk.Next(); {
+		}
+	})
+	if got != 0 {
+		t.Errorf("reading tokens of %q allocated %v times; want 0", query, got)
+	}
+}
+
+func BenchmarkTokenizer(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		for tk := NewTokenizer(`StormEvents | where EventType == "Tornado" or EventType != "Thunderstorm Wind"`); tk.Next(); {
+		}
+	}
+}
+
 func TestSplitStatements(t *testing.T) {
 	tests := []struct {
 		source string
@@ -551,6 +693,12 @@ func TestSplitStatements(t *testing.T) {
 		{"foo", []string{"foo"}},
 		{"foo;bar", []string{"foo", "bar"}},
 		{"foo';'bar", []string{"foo';'bar"}},
+		{"foo // ;\nbar", []string{"foo // ;\nbar"}},
+		{"foo `a;b`;bar", []string{"foo `a;b`", "bar"}},
+		{`foo 'a\';b';bar`, []string{`foo 'a\';b'`, "bar"}},
+		{`foo 'C:\';bar`, []string{`foo 'C:\'`, "bar"}},
+		{"foo 'a;\nbar';baz", []string{"foo 'a", "\nbar'", "baz"}},
+		{"foo `a;\nbar", []string{"foo `a", "\nbar"}},
 	}
 	for _, test := range tests {
 		got := SplitStatements(test.source)
@@ -19,75 +19,238 @@ type parser struct {
 	pos    int
 
 	splitKind TokenKind
+	// arena is the arena to allocate nodes from
+	// or nil to allocate them normally.
+	arena *arena
 }
 
 // Parse converts a Pipeline Query Language query
 // into an Abstract Syntax Tree (AST).
+// This is equivalent to new(ParseOptions).Parse(query).
 func Parse(query string) ([]Statement, error) {
+	return ((*ParseOptions)(nil)).Parse(query)
+}
+
+// Default limits used when the corresponding [ParseOptions] field is zero.
+const (
+	DefaultMaxDepth      = 100
+	DefaultMaxTokens     = 100_000
+	DefaultMaxStatements = 1000
+)
+
+// ParseOptions is a set of optional parameters
+// that configure parsing.
+// nil is treated the same as the zero value.
+//
+// The limits in ParseOptions bound the resources used to parse a query,
+// so that queries from untrusted sources can be parsed safely.
+// A query that exceeds a limit fails to parse with a [CodeLimitExceeded] diagnostic.
+type ParseOptions struct {
+	// MaxDepth is the maximum nesting depth of parentheses and brackets.
+	// If MaxDepth is zero, [DefaultMaxDepth] is used.
+	// If MaxDepth is negative, the depth is not limited.
+	MaxDepth int
+	// MaxTokens is the maximum number of tokens in the query.
+	// If MaxTokens is zero, [DefaultMaxTokens] is used.
+	// If MaxTokens is negative, the number of tokens is not limited.
+	MaxTokens int
+	// MaxStatements is the maximum number of non-empty statements in the query.
+	// If MaxStatements is zero, [DefaultMaxStatements] is used.
+	// If MaxStatements is negative, the number of statements is not limited.
+	MaxStatements int
+
+	// Pooled makes [ParseOptions.ParseIncremental] allocate the syntax tree
+	// from a pool of memory that is reused once [*ParseResult.Release] is called.
+	// This reduces garbage collection work for callers that parse many queries
+	// and discard each syntax tree soon after parsing it,
+	// like a service that compiles queries to SQL.
+	// Pooled has no effect on [ParseOptions.Parse] or [ParseOptions.ParseExpr].
+	Pooled bool
+}
+
+// Parse converts a Pipeline Query Language query
+// into an Abstract Syntax Tree (AST).
+func (opts *ParseOptions) Parse(query string) ([]Statement, error) {
+	r := newParseResult(query, opts.limits(), nil, Span{}, 0, false)
+	return r.Statements(), r.Err()
+}
+
+// ParseExpr parses a single scalar expression,
+// like the predicate of a where operator.
+// This is equivalent to new(ParseOptions).ParseExpr(source).
+func ParseExpr(source string) (Expr, error) {
+	return ((*ParseOptions)(nil)).ParseExpr(source)
+}
+
+// ParseExpr parses a single scalar expression,
+// like the predicate of a where operator.
+// It is an error for source to contain anything after the expression.
+// If the expression has errors, ParseExpr returns as much of the expression as it could parse
+// along with the errors.
+func (opts *ParseOptions) ParseExpr(source string) (Expr, error) {
+	tokens := Scan(source)
+	if err := opts.limits().check(source, tokens, [][]Token{tokens}); err != nil {
+		return nil, fmt.Errorf("parse pipeline query language expression: %w", err)
+	}
 	p := &parser{
-		source: query,
-		tokens: Scan(query),
+		source: source,
+		tokens: tokens,
 	}
-	var result []Statement
-	var resultError error
-	for {
-		stmtParser := p.splitSemi()
-
-		stmt, err := firstParse(
-			func() (Statement, error) {
-				stmt, err := stmtParser.letStatement()
-				if stmt == nil {
-					// Prevent returning a non-nil interface.
-					return nil, err
-				}
-				return stmt, err
-			},
-			func() (Statement, error) {
-				expr, err := stmtParser.tabularExpr()
-				if expr == nil {
-					// Prevent returning a non-nil interface.
-					return nil, err
-				}
-				return expr, err
-			},
-		)
+	x, err := p.expr()
+	err = makeErrorOpaque(err)
+	if p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		if tok.Kind == TokenError {
+			err = joinErrors(err, &parseError{
+				source: source,
+				span:   tok.Span,
+				err:    errors.New(tok.Value),
+				code:   CodeInvalidToken,
+			})
+		} else {
+			err = joinErrors(err, &parseError{
+				source: source,
+				span:   tok.Span,
+				err:    fmt.Errorf("expected end of expression, got %s", formatToken(source, tok)),
+			})
+		}
+	}
+	if err != nil {
+		return x, fmt.Errorf("parse pipeline query language expression: %w", err)
+	}
+	return x, nil
+}
 
-		if isNotFound(err) {
-			// We're okay with empty statements, we just ignore them.
-			if stmtParser.pos < len(stmtParser.tokens) {
-				trailingToken := stmtParser.tokens[stmtParser.pos]
-				if trailingToken.Kind == TokenError {
-					resultError = joinErrors(err, &parseError{
-						source: p.source,
-						span:   trailingToken.Span,
-						err:    errors.New(trailingToken.Value),
-					})
-				} else {
-					resultError = joinErrors(err, &parseError{
-						source: p.source,
-						span:   trailingToken.Span,
-						err:    errors.New("unrecognized token"),
-					})
-				}
+// parseLimits is the resolved form of [ParseOptions].
+// A negative limit means no limit.
+type parseLimits struct {
+	maxDepth      int
+	maxTokens     int
+	maxStatements int
+}
+
+func (opts *ParseOptions) limits() parseLimits {
+	l := parseLimits{
+		maxDepth:      DefaultMaxDepth,
+		maxTokens:     DefaultMaxTokens,
+		maxStatements: DefaultMaxStatements,
+	}
+	if opts != nil {
+		if opts.MaxDepth != 0 {
+			l.maxDepth = opts.MaxDepth
+		}
+		if opts.MaxTokens != 0 {
+			l.maxTokens = opts.MaxTokens
+		}
+		if opts.MaxStatements != 0 {
+			l.maxStatements = opts.MaxStatements
+		}
+	}
+	return l
+}
+
+// check returns an error if the tokens of a query exceed the limits.
+// stmts is the result of calling [splitStatements] on tokens.
+func (l parseLimits) check(source string, tokens []Token, stmts [][]Token) error {
+	if l.maxTokens >= 0 && len(tokens) > l.maxTokens {
+		return &parseError{
+			source: source,
+			span:   tokens[l.maxTokens].Span,
+			err:    fmt.Errorf("query has more than %d tokens", l.maxTokens),
+			code:   CodeLimitExceeded,
+		}
+	}
+	if l.maxStatements >= 0 {
+		n := 0
+		for _, stmt := range stmts {
+			if len(stmt) == 0 {
+				continue
 			}
-		} else {
-			if stmt != nil {
-				result = append(result, stmt)
+			n++
+			if n > l.maxStatements {
+				return &parseError{
+					source: source,
+					span:   stmt[0].Span,
+					err:    fmt.Errorf("query has more than %d statements", l.maxStatements),
+					code:   CodeLimitExceeded,
+				}
 			}
-			resultError = joinErrors(resultError, makeErrorOpaque(err))
-			resultError = joinErrors(resultError, stmtParser.endSplit())
 		}
-
-		// Next token, if present, guaranteed to be a semicolon.
-		if _, ok := p.next(); !ok {
-			break
+	}
+	if l.maxDepth >= 0 {
+		depth := 0
+		for _, tok := range tokens {
+			switch tok.Kind {
+			case TokenLParen, TokenLBracket:
+				depth++
+				if depth > l.maxDepth {
+					return &parseError{
+						source: source,
+						span:   tok.Span,
+						err:    fmt.Errorf("parentheses and brackets nested more than %d deep", l.maxDepth),
+						code:   CodeLimitExceeded,
+					}
+				}
+			case TokenRParen, TokenRBracket:
+				depth = max(depth-1, 0)
+			case TokenSemi:
+				depth = 0
+			}
 		}
 	}
+	return nil
+}
 
-	if resultError != nil {
-		return result, fmt.Errorf("parse pipeline query language: %w", resultError)
+// parseStatement parses the tokens of a single statement,
+// not including its trailing semicolon.
+// It returns a nil Statement for an empty statement.
+func parseStatement(source string, tokens []Token, a *arena) (Statement, error) {
+	stmtParser := &parser{
+		source:    source,
+		tokens:    tokens,
+		splitKind: TokenSemi,
+		arena:     a,
+	}
+	stmt, err := firstParse(
+		func() (Statement, error) {
+			stmt, err := stmtParser.letStatement()
+			if stmt == nil {
+				// Prevent returning a non-nil interface.
+				return nil, err
+			}
+			return stmt, err
+		},
+		func() (Statement, error) {
+			expr, err := stmtParser.tabularExpr()
+			if expr == nil {
+				// Prevent returning a non-nil interface.
+				return nil, err
+			}
+			return expr, err
+		},
+	)
+
+	if isNotFound(err) {
+		// We're okay with empty statements, we just ignore them.
+		if stmtParser.pos >= len(stmtParser.tokens) {
+			return nil, nil
+		}
+		trailingToken := stmtParser.tokens[stmtParser.pos]
+		if trailingToken.Kind == TokenError {
+			return nil, joinErrors(err, &parseError{
+				source: source,
+				span:   trailingToken.Span,
+				err:    errors.New(trailingToken.Value),
+				code:   CodeInvalidToken,
+			})
+		}
+		return nil, joinErrors(err, &parseError{
+			source: source,
+			span:   trailingToken.Span,
+			err:    errors.New("unrecognized token"),
+		})
 	}
-	return result, nil
+	return stmt, joinErrors(makeErrorOpaque(err), stmtParser.endSplit())
 }
 
 func firstParse[T any](productions ...func() (T, error)) (T, error) {
@@ -129,23 +292,43 @@ func (p *parser) letStatement() (*LetStatement, error) {
 		}
 	}
 	stmt.Assign = assign.Span
+	valueStart := p.pos
 	stmt.X, err = p.expr()
 	if err != nil {
+		// A parenthesized tabular expression fails to parse as a scalar expression.
+		if valueStart < len(p.tokens) && p.tokens[valueStart].Kind == TokenLParen {
+			exprEnd := p.pos
+			p.pos = valueStart
+			if tabular, tabularErr := p.tabularExpr(); tabularErr == nil {
+				stmt.X = nil
+				stmt.Tabular = tabular
+				return stmt, nil
+			}
+			p.pos = exprEnd
+		}
 		return stmt, makeErrorOpaque(err)
 	}
-	return stmt, nil
+	if tok, _ := p.next(); tok.Kind != TokenPipe {
+		p.prev()
+		return stmt, nil
+	}
+
+	// A pipe after the value means that this is a tabular expression.
+	p.pos = valueStart
+	stmt.X = nil
+	stmt.Tabular, err = p.tabularExpr()
+	return stmt, makeErrorOpaque(err)
 }
 
 func (p *parser) tabularExpr() (*TabularExpr, error) {
-	tableName, err := p.ident()
-	if err != nil {
-		return nil, err
+	source, finalError := p.tabularDataSource()
+	if source == nil {
+		return nil, finalError
 	}
 	expr := &TabularExpr{
-		Source: &TableRef{Table: tableName},
+		Source: source,
 	}
 
-	var finalError error
 	for i := 0; ; i++ {
 		pipeToken, _ := p.next()
 		if pipeToken.Kind != TokenPipe {
@@ -172,6 +355,7 @@ func (p *parser) tabularExpr() (*TabularExpr, error) {
 			})
 			continue
 		}
+		operatorName = opParser.hyphenatedName(operatorName)
 		switch operatorName.Value {
 		case "count":
 			op, err := opParser.countOperator(pipeToken, operatorName)
@@ -209,12 +393,30 @@ func (p *parser) tabularExpr() (*TabularExpr, error) {
 				expr.Operators = append(expr.Operators, op)
 			}
 			finalError = joinErrors(finalError, err)
+		case "project-away":
+			op, err := opParser.projectAwayOperator(pipeToken, operatorName)
+			if op != nil {
+				expr.Operators = append(expr.Operators, op)
+			}
+			finalError = joinErrors(finalError, err)
+		case "distinct":
+			op, err := opParser.distinctOperator(pipeToken, operatorName)
+			if op != nil {
+				expr.Operators = append(expr.Operators, op)
+			}
+			finalError = joinErrors(finalError, err)
 		case "extend":
 			op, err := opParser.extendOperator(pipeToken, operatorName)
 			if op != nil {
 				expr.Operators = append(expr.Operators, op)
 			}
 			finalError = joinErrors(finalError, err)
+		case "mv-expand":
+			op, err := opParser.mvExpandOperator(pipeToken, operatorName)
+			if op != nil {
+				expr.Operators = append(expr.Operators, op)
+			}
+			finalError = joinErrors(finalError, err)
 		case "summarize":
 			op, err := opParser.summarizeOperator(pipeToken, operatorName)
 			if op != nil {
@@ -227,6 +429,12 @@ func (p *parser) tabularExpr() (*TabularExpr, error) {
 				expr.Operators = append(expr.Operators, op)
 			}
 			finalError = joinErrors(finalError, err)
+		case "union":
+			op, err := opParser.unionOperator(pipeToken, operatorName)
+			if op != nil {
+				expr.Operators = append(expr.Operators, op)
+			}
+			finalError = joinErrors(finalError, err)
 		case "as":
 			op, err := opParser.asOperator(pipeToken, operatorName)
 			if op != nil {
@@ -245,6 +453,7 @@ func (p *parser) tabularExpr() (*TabularExpr, error) {
 				source: opParser.source,
 				span:   operatorName.Span,
 				err:    fmt.Errorf("unknown operator name %q", operatorName.Value),
+				code:   CodeUnknownOperator,
 			})
 			continue
 		}
@@ -253,6 +462,23 @@ func (p *parser) tabularExpr() (*TabularExpr, error) {
 	}
 }
 
+// hyphenatedName extends tok, an identifier that was just consumed,
+// with any directly adjacent pairs of hyphens and identifiers
+// so that operator names like mv-expand are returned as a single token.
+func (p *parser) hyphenatedName(tok Token) Token {
+	for p.pos+1 < len(p.tokens) {
+		minus, name := p.tokens[p.pos], p.tokens[p.pos+1]
+		if minus.Kind != TokenMinus || name.Kind != TokenIdentifier ||
+			minus.Span.Start != tok.Span.End || name.Span.Start != minus.Span.End {
+			break
+		}
+		tok.Span = newSpan(tok.Span.Start, name.Span.End)
+		tok.Value += "-" + name.Value
+		p.pos += 2
+	}
+	return tok
+}
+
 func (p *parser) countOperator(pipe, keyword Token) (*CountOperator, error) {
 	return &CountOperator{
 		Pipe:    pipe.Span,
@@ -478,6 +704,54 @@ func (p *parser) projectOperator(pipe, keyword Token) (*ProjectOperator, error)
 	}
 }
 
+func (p *parser) projectAwayOperator(pipe, keyword Token) (*ProjectAwayOperator, error) {
+	op := &ProjectAwayOperator{
+		Pipe:    pipe.Span,
+		Keyword: keyword.Span,
+	}
+
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return op, makeErrorOpaque(err)
+		}
+		op.Cols = append(op.Cols, col)
+
+		sep, ok := p.next()
+		if !ok {
+			return op, nil
+		}
+		if sep.Kind != TokenComma {
+			p.prev()
+			return op, nil
+		}
+	}
+}
+
+func (p *parser) distinctOperator(pipe, keyword Token) (*DistinctOperator, error) {
+	op := &DistinctOperator{
+		Pipe:    pipe.Span,
+		Keyword: keyword.Span,
+	}
+
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return op, makeErrorOpaque(err)
+		}
+		op.Cols = append(op.Cols, col)
+
+		sep, ok := p.next()
+		if !ok {
+			return op, nil
+		}
+		if sep.Kind != TokenComma {
+			p.prev()
+			return op, nil
+		}
+	}
+}
+
 func (p *parser) extendOperator(pipe, keyword Token) (*ExtendOperator, error) {
 	op := &ExtendOperator{
 		Pipe:    pipe.Span,
@@ -635,6 +909,35 @@ func (p *parser) extendColumn() (*ExtendColumn, error) {
 	return col, err
 }
 
+func (p *parser) mvExpandOperator(pipe, keyword Token) (*MvExpandOperator, error) {
+	op := &MvExpandOperator{
+		Pipe:    pipe.Span,
+		Keyword: keyword.Span,
+	}
+
+	for {
+		// Columns have the same syntax as in extend.
+		col, err := p.extendColumn()
+		if err != nil {
+			return op, makeErrorOpaque(err)
+		}
+		op.Cols = append(op.Cols, &MvExpandColumn{
+			Name:   col.Name,
+			Assign: col.Assign,
+			X:      col.X,
+		})
+
+		sep, ok := p.next()
+		if !ok {
+			return op, nil
+		}
+		if sep.Kind != TokenComma {
+			p.prev()
+			return op, nil
+		}
+	}
+}
+
 func (p *parser) summarizeOperator(pipe, keyword Token) (*SummarizeOperator, error) {
 	op := &SummarizeOperator{
 		Pipe:    pipe.Span,
@@ -743,6 +1046,13 @@ var joinTypes = map[string]struct{}{
 	"innerunique": {},
 	"inner":       {},
 	"leftouter":   {},
+	"rightouter":  {},
+	"fullouter":   {},
+	"leftanti":    {},
+	"rightanti":   {},
+	"leftsemi":    {},
+	"rightsemi":   {},
+	"cross":       {},
 }
 
 func (p *parser) joinOperator(pipe, keyword Token) (*JoinOperator, error) {
@@ -765,43 +1075,56 @@ func (p *parser) joinOperator(pipe, keyword Token) (*JoinOperator, error) {
 		}
 	}
 
-	// Optional "kind = JoinFlavor" clause.
+	// Optional "kind = JoinFlavor" and "hint.name = value" clauses.
 	var finalError error
-	if tok.Kind == TokenIdentifier && tok.Value == "kind" {
-		op.Kind = tok.Span
-		tok, _ = p.next()
-		if tok.Kind != TokenAssign {
-			return op, joinErrors(finalError, &parseError{
-				source: p.source,
-				span:   tok.Span,
-				err:    fmt.Errorf("expected '=', got %s", formatToken(p.source, tok)),
-			})
-		}
-		op.KindAssign = tok.Span
-		tok, _ = p.next()
-		if tok.Kind != TokenIdentifier {
-			return op, joinErrors(finalError, &parseError{
-				source: p.source,
-				span:   tok.Span,
-				err:    fmt.Errorf("expected join flavor, got %s", formatToken(p.source, tok)),
-			})
-		}
-		op.Flavor = &Ident{
-			Name:     tok.Value,
-			NameSpan: tok.Span,
-		}
-		if _, ok := joinTypes[tok.Value]; !ok {
-			joinTypeList := maps.Keys(joinTypes)
-			slices.Sort(joinTypeList)
-			finalError = joinErrors(finalError, &parseError{
-				source: p.source,
-				span:   tok.Span,
-				err:    fmt.Errorf("expected join flavor (one of %s), got %s", strings.Join(joinTypeList, ", "), tok.Value),
-			})
+	for ; tok.Kind == TokenIdentifier; tok, _ = p.next() {
+		switch {
+		case tok.Value == "kind" && op.Flavor == nil:
+			op.Kind = tok.Span
+			tok, _ = p.next()
+			if tok.Kind != TokenAssign {
+				return op, joinErrors(finalError, &parseError{
+					source: p.source,
+					span:   tok.Span,
+					err:    fmt.Errorf("expected '=', got %s", formatToken(p.source, tok)),
+				})
+			}
+			op.KindAssign = tok.Span
+			tok, _ = p.next()
+			if tok.Kind != TokenIdentifier {
+				return op, joinErrors(finalError, &parseError{
+					source: p.source,
+					span:   tok.Span,
+					err:    fmt.Errorf("expected join flavor, got %s", formatToken(p.source, tok)),
+				})
+			}
+			op.Flavor = &Ident{
+				Name:     tok.Value,
+				NameSpan: tok.Span,
+			}
+			if _, ok := joinTypes[tok.Value]; !ok {
+				joinTypeList := maps.Keys(joinTypes)
+				slices.Sort(joinTypeList)
+				finalError = joinErrors(finalError, &parseError{
+					source: p.source,
+					span:   tok.Span,
+					err:    fmt.Errorf("expected join flavor (one of %s), got %s", strings.Join(joinTypeList, ", "), tok.Value),
+				})
+			}
+			continue
+		case tok.Value == "hint":
+			hint, err := p.joinHint(tok)
+			if hint != nil {
+				op.Hints = append(op.Hints, hint)
+			}
+			if err != nil {
+				return op, joinErrors(finalError, err)
+			}
+			continue
 		}
-	} else {
-		p.prev()
+		break
 	}
+	p.prev()
 
 	// Right table:
 	tok, _ = p.next()
@@ -829,6 +1152,11 @@ func (p *parser) joinOperator(pipe, keyword Token) (*JoinOperator, error) {
 
 	// Conditions:
 	tok, _ = p.next()
+	if op.Flavor != nil && op.Flavor.Name == "cross" && (tok.Kind != TokenIdentifier || tok.Value != "on") {
+		// Cross joins do not need conditions.
+		p.prev()
+		return op, finalError
+	}
 	if tok.Kind != TokenIdentifier || tok.Value != "on" {
 		return op, joinErrors(finalError, &parseError{
 			source: p.source,
@@ -843,6 +1171,98 @@ func (p *parser) joinOperator(pipe, keyword Token) (*JoinOperator, error) {
 	return op, finalError
 }
 
+// joinHint parses a "hint.name = value" clause
+// after the "hint" keyword.
+func (p *parser) joinHint(keyword Token) (*JoinHint, error) {
+	hint := &JoinHint{
+		Keyword: keyword.Span,
+		Dot:     nullSpan(),
+		Assign:  nullSpan(),
+	}
+	tok, _ := p.next()
+	if tok.Kind != TokenDot {
+		return nil, &parseError{
+			source: p.source,
+			span:   tok.Span,
+			err:    fmt.Errorf("expected '.', got %s", formatToken(p.source, tok)),
+		}
+	}
+	hint.Dot = tok.Span
+	var err error
+	hint.Name, err = p.ident()
+	if err != nil {
+		return hint, makeErrorOpaque(err)
+	}
+	tok, _ = p.next()
+	if tok.Kind != TokenAssign {
+		return hint, &parseError{
+			source: p.source,
+			span:   tok.Span,
+			err:    fmt.Errorf("expected '=', got %s", formatToken(p.source, tok)),
+		}
+	}
+	hint.Assign = tok.Span
+	hint.Value, err = p.ident()
+	return hint, makeErrorOpaque(err)
+}
+
+func (p *parser) unionOperator(pipe, keyword Token) (*UnionOperator, error) {
+	src, err := p.unionSource(keyword)
+	return &UnionOperator{
+		Pipe:             pipe.Span,
+		Keyword:          src.Keyword,
+		WithSource:       src.WithSource,
+		WithSourceAssign: src.WithSourceAssign,
+		SourceColumn:     src.SourceColumn,
+		Tables:           src.Tables,
+	}, err
+}
+
+// unionSource parses the arguments of a union
+// after the "union" keyword.
+func (p *parser) unionSource(keyword Token) (*UnionSource, error) {
+	src := &UnionSource{
+		Keyword:          keyword.Span,
+		WithSource:       nullSpan(),
+		WithSourceAssign: nullSpan(),
+	}
+
+	// Optional "withsource = ColumnName" clause.
+	if p.pos+1 < len(p.tokens) &&
+		p.tokens[p.pos].Kind == TokenIdentifier &&
+		p.tokens[p.pos].Value == "withsource" &&
+		p.tokens[p.pos+1].Kind == TokenAssign {
+		tok, _ := p.next()
+		src.WithSource = tok.Span
+		tok, _ = p.next()
+		src.WithSourceAssign = tok.Span
+		var err error
+		src.SourceColumn, err = p.ident()
+		if err != nil {
+			return src, makeErrorOpaque(err)
+		}
+	}
+
+	for {
+		table, err := p.tabularDataSource()
+		if table != nil {
+			src.Tables = append(src.Tables, table)
+		}
+		if err != nil {
+			return src, makeErrorOpaque(err)
+		}
+
+		sep, ok := p.next()
+		if !ok {
+			return src, nil
+		}
+		if sep.Kind != TokenComma {
+			p.prev()
+			return src, nil
+		}
+	}
+}
+
 func (p *parser) asOperator(pipe, keyword Token) (*AsOperator, error) {
 	op := &AsOperator{
 		Pipe:    pipe.Span,
@@ -854,33 +1274,67 @@ func (p *parser) asOperator(pipe, keyword Token) (*AsOperator, error) {
 }
 
 // exprList parses one or more comma-separated expressions.
+// exprList parses a comma-separated list of expressions.
+// If an element fails to parse,
+// exprList skips to the next comma at the same nesting level
+// and continues parsing the rest of the list
+// so that later elements are still present in the result.
 func (p *parser) exprList() ([]Expr, error) {
 	first, err := p.expr()
-	if err != nil {
+	if isNotFound(err) && p.pos >= len(p.tokens) {
 		return nil, err
 	}
-	result := []Expr{first}
+	var result []Expr
+	if first != nil {
+		result = append(result, first)
+	}
+	var finalError error
+	if err != nil {
+		finalError = makeErrorOpaque(err)
+		p.skipToComma()
+	}
 	for {
 		restorePos := p.pos
 		tok, ok := p.next()
 		if !ok {
-			return result, nil
+			return result, finalError
 		}
 		if tok.Kind != TokenComma {
 			p.prev()
-			return result, nil
+			return result, finalError
 		}
 		x, err := p.expr()
-		if isNotFound(err) {
+		if isNotFound(err) && p.pos >= len(p.tokens) {
+			// Permit a trailing comma.
 			p.pos = restorePos
-			return result, nil
+			return result, finalError
 		}
 		if x != nil {
 			result = append(result, x)
 		}
 		if err != nil {
 			// If there's a notFoundError, we want to mask it from the caller.
-			return result, makeErrorOpaque(err)
+			finalError = joinErrors(finalError, makeErrorOpaque(err))
+			p.skipToComma()
+		}
+	}
+}
+
+// skipToComma advances the parser to the next comma
+// that is not nested inside parentheses or brackets
+// or to the end of the parser's tokens.
+func (p *parser) skipToComma() {
+	depth := 0
+	for ; p.pos < len(p.tokens); p.pos++ {
+		switch p.tokens[p.pos].Kind {
+		case TokenLParen, TokenLBracket:
+			depth++
+		case TokenRParen, TokenRBracket:
+			depth--
+		case TokenComma:
+			if depth <= 0 {
+				return
+			}
 		}
 	}
 }
@@ -979,12 +1433,14 @@ func (p *parser) exprBinaryTrail(x Expr, minPrecedence int) (Expr, error) {
 			}
 		}
 
-		x = &BinaryExpr{
+		bin := p.newBinaryExpr()
+		*bin = BinaryExpr{
 			X:      x,
 			OpSpan: op1.Span,
 			Op:     op1.Kind,
 			Y:      y,
 		}
+		x = bin
 	}
 }
 
@@ -1081,11 +1537,13 @@ func (p *parser) innerPrimaryExpr() (Expr, error) {
 	}
 	switch tok.Kind {
 	case TokenNumber, TokenString:
-		return &BasicLit{
+		lit := p.newBasicLit()
+		*lit = BasicLit{
 			ValueSpan: tok.Span,
 			Kind:      tok.Kind,
 			Value:     tok.Value,
-		}, nil
+		}
+		return lit, nil
 	case TokenIdentifier:
 		// Look ahead for a dot-separated identifier.
 		p.prev()
@@ -1127,15 +1585,19 @@ func (p *parser) innerPrimaryExpr() (Expr, error) {
 				err:    fmt.Errorf("expected ')', got %s", formatToken(p.source, finalTok)),
 			})
 		}
-		return &CallExpr{
-			Func: &Ident{
-				Name:     tok.Value,
-				NameSpan: tok.Span,
-			},
+		fn := p.newIdent()
+		*fn = Ident{
+			Name:     tok.Value,
+			NameSpan: tok.Span,
+		}
+		call := p.newCallExpr()
+		*call = CallExpr{
+			Func:   fn,
 			Lparen: nextTok.Span,
 			Args:   args,
 			Rparen: rparen,
-		}, err
+		}
+		return call, err
 	case TokenQuotedIdentifier:
 		p.prev()
 		return p.qualifiedIdent()
@@ -1183,13 +1645,226 @@ func (p *parser) ident() (*Ident, error) {
 			err:    notFoundError{fmt.Errorf("expected identifier, got %s", formatToken(p.source, tok))},
 		}
 	}
-	return &Ident{
+	id := p.newIdent()
+	*id = Ident{
 		Name:     tok.Value,
 		NameSpan: tok.Span,
 		Quoted:   tok.Kind == TokenQuotedIdentifier,
+	}
+	return id, nil
+}
+
+// tabularDataSource parses the data source at the beginning of a tabular expression.
+func (p *parser) tabularDataSource() (TabularDataSource, error) {
+	lparen, _ := p.next()
+	if lparen.Kind == TokenIdentifier && lparen.Value == "table" {
+		if tok, _ := p.next(); tok.Kind == TokenLParen {
+			return p.tableCall(lparen, tok)
+		}
+		p.prev()
+	}
+	if lparen.Kind == TokenIdentifier && lparen.Value == "union" && p.pos < len(p.tokens) {
+		// A table named "union" is followed by a pipe, a dot, or nothing.
+		switch p.tokens[p.pos].Kind {
+		case TokenIdentifier, TokenQuotedIdentifier, TokenStar, TokenLParen:
+			return p.unionSource(lparen)
+		}
+	}
+	if lparen.Kind != TokenLParen {
+		p.prev()
+		return p.tableRef()
+	}
+
+	exprParser := p.split(TokenRParen)
+	x, err := exprParser.tabularExpr()
+	err = makeErrorOpaque(err) // already consumed a parenthesis
+	err = joinErrors(err, exprParser.endSplit())
+	src := &ParenTabularExpr{
+		Lparen: lparen.Span,
+		X:      x,
+		Rparen: nullSpan(),
+	}
+	rparen, _ := p.next()
+	if rparen.Kind != TokenRParen {
+		p.prev()
+		return src, joinErrors(err, &parseError{
+			source: p.source,
+			span:   rparen.Span,
+			err:    fmt.Errorf("expected ')', got %s", formatToken(p.source, rparen)),
+		})
+	}
+	src.Rparen = rparen.Span
+	return src, err
+}
+
+// tableRef parses a table name or wildcard pattern
+// optionally qualified by a database name and a cluster name.
+func (p *parser) tableRef() (TabularDataSource, error) {
+	cluster, err := p.qualifierCall("cluster")
+	if err != nil {
+		return nil, err
+	}
+	database, err := p.qualifierCall("database")
+	if err != nil {
+		return nil, err
+	}
+	if database == nil {
+		name, pattern, err := p.tableName()
+		if err != nil {
+			if cluster != nil {
+				err = makeErrorOpaque(err)
+			}
+			return nil, err
+		}
+		if tok, _ := p.next(); tok.Kind != TokenDot {
+			p.prev()
+			if cluster != nil {
+				return nil, &parseError{
+					source: p.source,
+					span:   unionSpans(name.Span(), pattern.Span()),
+					err:    errors.New("cluster-qualified table must include a database"),
+				}
+			}
+			if pattern != nil {
+				return pattern, nil
+			}
+			return &TableRef{Table: name}, nil
+		}
+		if pattern != nil {
+			return nil, &parseError{
+				source: p.source,
+				span:   pattern.PatternSpan,
+				err:    errors.New("database name cannot contain wildcards"),
+			}
+		}
+		database = name
+	}
+	tableName, pattern, err := p.tableName()
+	if err != nil {
+		return nil, makeErrorOpaque(err)
+	}
+	if pattern != nil {
+		if cluster != nil {
+			return nil, &parseError{
+				source: p.source,
+				span:   pattern.PatternSpan,
+				err:    errors.New("cluster-qualified table name cannot contain wildcards"),
+			}
+		}
+		pattern.Database = database
+		return pattern, nil
+	}
+	return &TableRef{
+		Cluster:  cluster,
+		Database: database,
+		Table:    tableName,
+	}, nil
+}
+
+// qualifierCall parses a call like database("security")
+// followed by a dot.
+// The returned identifier's name is the string argument
+// and its span covers the whole call.
+// qualifierCall returns (nil, nil) and consumes no tokens
+// if the next tokens are not a call to the given function.
+func (p *parser) qualifierCall(funcName string) (*Ident, error) {
+	if p.pos+1 >= len(p.tokens) ||
+		p.tokens[p.pos].Kind != TokenIdentifier ||
+		p.tokens[p.pos].Value != funcName ||
+		p.tokens[p.pos+1].Kind != TokenLParen {
+		return nil, nil
+	}
+	keyword, _ := p.next()
+	p.next() // lparen
+	arg, _ := p.next()
+	if arg.Kind != TokenString {
+		p.prev()
+		return nil, &parseError{
+			source: p.source,
+			span:   arg.Span,
+			err:    fmt.Errorf("expected string, got %s", formatToken(p.source, arg)),
+		}
+	}
+	rparen, _ := p.next()
+	if rparen.Kind != TokenRParen {
+		p.prev()
+		return nil, &parseError{
+			source: p.source,
+			span:   rparen.Span,
+			err:    fmt.Errorf("expected ')', got %s", formatToken(p.source, rparen)),
+		}
+	}
+	if dot, _ := p.next(); dot.Kind != TokenDot {
+		p.prev()
+		return nil, &parseError{
+			source: p.source,
+			span:   dot.Span,
+			err:    fmt.Errorf("expected '.', got %s", formatToken(p.source, dot)),
+		}
+	}
+	return &Ident{
+		Name:     arg.Value,
+		NameSpan: newSpan(keyword.Span.Start, rparen.Span.End),
+		Quoted:   true,
 	}, nil
 }
 
+// tableName parses a table name.
+// If the name is a run of adjacent identifiers and asterisks like Events_*,
+// then tableName returns it as a wildcard pattern instead of an identifier.
+func (p *parser) tableName() (*Ident, *TableWildcard, error) {
+	end := p.pos
+	hasStar := false
+	for ; end < len(p.tokens); end++ {
+		tok := p.tokens[end]
+		if end > p.pos && tok.Span.Start != p.tokens[end-1].Span.End {
+			break
+		}
+		if tok.Kind == TokenStar {
+			hasStar = true
+		} else if tok.Kind != TokenIdentifier {
+			break
+		}
+	}
+	if !hasStar {
+		name, err := p.ident()
+		return name, nil, err
+	}
+	span := newSpan(p.tokens[p.pos].Span.Start, p.tokens[end-1].Span.End)
+	p.pos = end
+	return nil, &TableWildcard{
+		Pattern:     p.source[span.Start:span.End],
+		PatternSpan: span,
+	}, nil
+}
+
+// tableCall parses the arguments of a table() data source.
+// The caller must have already consumed the "table" identifier
+// and the opening parenthesis.
+func (p *parser) tableCall(keyword, lparen Token) (*TableCall, error) {
+	call := &TableCall{
+		Keyword: keyword.Span,
+		Lparen:  lparen.Span,
+		Rparen:  nullSpan(),
+	}
+	nameParser := p.split(TokenRParen)
+	var err error
+	call.Name, err = nameParser.expr()
+	err = makeErrorOpaque(err)
+	err = joinErrors(err, nameParser.endSplit())
+	rparen, _ := p.next()
+	if rparen.Kind != TokenRParen {
+		p.prev()
+		return call, joinErrors(err, &parseError{
+			source: p.source,
+			span:   rparen.Span,
+			err:    fmt.Errorf("expected ')', got %s", formatToken(p.source, rparen)),
+		})
+	}
+	call.Rparen = rparen.Span
+	return call, err
+}
+
 // qualifiedIdent parses one or more dot-separated identifiers.
 func (p *parser) qualifiedIdent() (*QualifiedIdent, error) {
 	id, err := p.ident()
@@ -1197,7 +1872,8 @@ func (p *parser) qualifiedIdent() (*QualifiedIdent, error) {
 		return nil, err
 	}
 
-	qid := id.AsQualified()
+	qid := p.newQualifiedIdent()
+	qid.Parts = []*Ident{id}
 	for {
 		tok, _ := p.next()
 		if tok.Kind != TokenDot {
@@ -1217,7 +1893,7 @@ func (p *parser) qualifiedIdent() (*QualifiedIdent, error) {
 // It ignores tokens that are in parenthetical groups after the initial parse position.
 // If no such token is found, split advances to EOF.
 //
-// For splitting by semicolon, see [*parser.splitSemi].
+// For splitting statements by semicolon, see [splitStatements].
 func (p *parser) split(search TokenKind) *parser {
 	// stack is the list of expected closing parentheses/brackets.
 	// When a closing parenthesis/bracket is encountered,
@@ -1233,6 +1909,7 @@ loop:
 				source:    p.source,
 				tokens:    p.tokens[start:],
 				splitKind: search,
+				arena:     p.arena,
 			}
 		}
 
@@ -1275,31 +1952,7 @@ loop:
 		source:    p.source,
 		tokens:    p.tokens[start:p.pos],
 		splitKind: search,
-	}
-}
-
-// splitSemi advances the parser to right before the next semicolon,
-// and returns a new parser that reads the tokens that were skipped over.
-// If no semicolon is found, splitSemi advances to EOF.
-func (p *parser) splitSemi() *parser {
-	start := p.pos
-	for {
-		tok, ok := p.next()
-		if !ok {
-			return &parser{
-				source:    p.source,
-				tokens:    p.tokens[start:],
-				splitKind: TokenSemi,
-			}
-		}
-		if tok.Kind == TokenSemi {
-			p.prev()
-			return &parser{
-				source:    p.source,
-				tokens:    p.tokens[start:p.pos],
-				splitKind: TokenSemi,
-			}
-		}
+		arena:     p.arena,
 	}
 }
 
@@ -1369,33 +2022,32 @@ type parseError struct {
 	source string
 	span   Span
 	err    error
+	// code is the error's diagnostic code.
+	// An empty code is treated as [CodeSyntax].
+	code string
 }
 
 func (e *parseError) Error() string {
-	line, col := linecol(e.source, e.span.Start)
-	return fmt.Sprintf("%d:%d: %s", line, col, e.err.Error())
+	return fmt.Sprintf("%v: %s", e.Position(), e.err.Error())
 }
 
-func (e *parseError) Unwrap() error {
-	return e.err
+// Span returns the span of the source that the error refers to.
+func (e *parseError) Span() Span {
+	return e.span
 }
 
-func linecol(source string, pos int) (line, col int) {
-	line, col = 1, 1
-	for _, c := range source[:pos] {
-		switch c {
-		case '\n':
-			line++
-			col = 1
-		case '\t':
-			const tabWidth = 8
-			tabLoc := (col - 1) % tabWidth
-			col += tabWidth - tabLoc
-		default:
-			col++
-		}
-	}
-	return
+// Position returns the position of the start of the error's span.
+func (e *parseError) Position() Position {
+	return PositionFor(e.source, e.span.Start)
+}
+
+// Source returns the query that the error's span refers to.
+func (e *parseError) Source() string {
+	return e.source
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
 }
 
 func joinErrors(args ...error) error {
@@ -1434,7 +2086,7 @@ func makeErrorOpaque(err error) error {
 	case multiUnwrapper:
 		errorList := slices.Clone(e.Unwrap())
 		for i, err := range errorList {
-			errorList[i] = opaqueError{err}
+			errorList[i] = makeErrorOpaque(err)
 		}
 		return errors.Join(errorList...)
 	default:
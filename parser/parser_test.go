@@ -4,6 +4,8 @@
 package parser
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -52,6 +54,319 @@ var parserTests = []struct {
 			},
 		}},
 	},
+	{
+		name:  "DatabaseQualifiedTableName",
+		query: "security.`Storm Events`",
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Database: &Ident{
+					Name:     "security",
+					NameSpan: newSpan(0, 8),
+				},
+				Table: &Ident{
+					Name:     "Storm Events",
+					NameSpan: newSpan(9, 23),
+					Quoted:   true,
+				},
+			},
+		}},
+	},
+	{
+		name:  "DatabaseCallTableName",
+		query: `database("security").Events`,
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Database: &Ident{
+					Name:     "security",
+					NameSpan: newSpan(0, 20),
+					Quoted:   true,
+				},
+				Table: &Ident{
+					Name:     "Events",
+					NameSpan: newSpan(21, 27),
+				},
+			},
+		}},
+	},
+	{
+		name:  "ClusterQualifiedTableName",
+		query: `cluster("primary").database("security").Events`,
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Cluster: &Ident{
+					Name:     "primary",
+					NameSpan: newSpan(0, 18),
+					Quoted:   true,
+				},
+				Database: &Ident{
+					Name:     "security",
+					NameSpan: newSpan(19, 39),
+					Quoted:   true,
+				},
+				Table: &Ident{
+					Name:     "Events",
+					NameSpan: newSpan(40, 46),
+				},
+			},
+		}},
+	},
+	{
+		name:  "ClusterWithoutDatabase",
+		query: `cluster("primary").Events`,
+		err:   true,
+	},
+	{
+		name:  "DatabaseCallWithoutString",
+		query: `database(security).Events`,
+		err:   true,
+	},
+	{
+		name:  "DatabaseWithoutTableName",
+		query: "security.",
+		err:   true,
+	},
+	{
+		name:  "TableWildcard",
+		query: "Events_* | count",
+		want: []Statement{&TabularExpr{
+			Source: &TableWildcard{
+				Pattern:     "Events_*",
+				PatternSpan: newSpan(0, 8),
+			},
+			Operators: []TabularOperator{
+				&CountOperator{
+					Pipe:    newSpan(9, 10),
+					Keyword: newSpan(11, 16),
+				},
+			},
+		}},
+	},
+	{
+		name:  "DatabaseQualifiedTableWildcard",
+		query: "logs.*_2024",
+		want: []Statement{&TabularExpr{
+			Source: &TableWildcard{
+				Database: &Ident{
+					Name:     "logs",
+					NameSpan: newSpan(0, 4),
+				},
+				Pattern:     "*_2024",
+				PatternSpan: newSpan(5, 11),
+			},
+		}},
+	},
+	{
+		name:  "DatabaseWildcard",
+		query: "logs*.Events",
+		err:   true,
+	},
+	{
+		name:  "TableCall",
+		query: `table("Events_" + suffix)`,
+		want: []Statement{&TabularExpr{
+			Source: &TableCall{
+				Keyword: newSpan(0, 5),
+				Lparen:  newSpan(5, 6),
+				Name: &BinaryExpr{
+					X: &BasicLit{
+						Kind:      TokenString,
+						Value:     "Events_",
+						ValueSpan: newSpan(6, 15),
+					},
+					OpSpan: newSpan(16, 17),
+					Op:     TokenPlus,
+					Y: &QualifiedIdent{
+						Parts: []*Ident{{
+							Name:     "suffix",
+							NameSpan: newSpan(18, 24),
+						}},
+					},
+				},
+				Rparen: newSpan(24, 25),
+			},
+		}},
+	},
+	{
+		name:  "QuotedTableNamedTable",
+		query: "`table`",
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "table",
+					NameSpan: newSpan(0, 7),
+					Quoted:   true,
+				},
+			},
+		}},
+	},
+	{
+		name:  "UnclosedTableCall",
+		query: `table("Events"`,
+		err:   true,
+		want: []Statement{&TabularExpr{
+			Source: &TableCall{
+				Keyword: newSpan(0, 5),
+				Lparen:  newSpan(5, 6),
+				Name: &BasicLit{
+					Kind:      TokenString,
+					Value:     "Events",
+					ValueSpan: newSpan(6, 14),
+				},
+				Rparen: nullSpan(),
+			},
+		}},
+	},
+	{
+		name:  "ParenSource",
+		query: "(StormEvents | take 5) | count",
+		want: []Statement{&TabularExpr{
+			Source: &ParenTabularExpr{
+				Lparen: newSpan(0, 1),
+				X: &TabularExpr{
+					Source: &TableRef{
+						Table: &Ident{
+							Name:     "StormEvents",
+							NameSpan: newSpan(1, 12),
+						},
+					},
+					Operators: []TabularOperator{
+						&TakeOperator{
+							Pipe:    newSpan(13, 14),
+							Keyword: newSpan(15, 19),
+							RowCount: &BasicLit{
+								Kind:      TokenNumber,
+								Value:     "5",
+								ValueSpan: newSpan(20, 21),
+							},
+						},
+					},
+				},
+				Rparen: newSpan(21, 22),
+			},
+			Operators: []TabularOperator{
+				&CountOperator{
+					Pipe:    newSpan(23, 24),
+					Keyword: newSpan(25, 30),
+				},
+			},
+		}},
+	},
+	{
+		name:  "UnionSource",
+		query: "union withsource=T A, (B | count)",
+		want: []Statement{&TabularExpr{
+			Source: &UnionSource{
+				Keyword:          newSpan(0, 5),
+				WithSource:       newSpan(6, 16),
+				WithSourceAssign: newSpan(16, 17),
+				SourceColumn: &Ident{
+					Name:     "T",
+					NameSpan: newSpan(17, 18),
+				},
+				Tables: []TabularDataSource{
+					&TableRef{
+						Table: &Ident{
+							Name:     "A",
+							NameSpan: newSpan(19, 20),
+						},
+					},
+					&ParenTabularExpr{
+						Lparen: newSpan(22, 23),
+						X: &TabularExpr{
+							Source: &TableRef{
+								Table: &Ident{
+									Name:     "B",
+									NameSpan: newSpan(23, 24),
+								},
+							},
+							Operators: []TabularOperator{
+								&CountOperator{
+									Pipe:    newSpan(25, 26),
+									Keyword: newSpan(27, 32),
+								},
+							},
+						},
+						Rparen: newSpan(32, 33),
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "TableNamedUnion",
+		query: "union | count",
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "union",
+					NameSpan: newSpan(0, 5),
+				},
+			},
+			Operators: []TabularOperator{
+				&CountOperator{
+					Pipe:    newSpan(6, 7),
+					Keyword: newSpan(8, 13),
+				},
+			},
+		}},
+	},
+	{
+		name:  "UnclosedParenSource",
+		query: "(StormEvents | count",
+		err:   true,
+		want: []Statement{&TabularExpr{
+			Source: &ParenTabularExpr{
+				Lparen: newSpan(0, 1),
+				X: &TabularExpr{
+					Source: &TableRef{
+						Table: &Ident{
+							Name:     "StormEvents",
+							NameSpan: newSpan(1, 12),
+						},
+					},
+					Operators: []TabularOperator{
+						&CountOperator{
+							Pipe:    newSpan(13, 14),
+							Keyword: newSpan(15, 20),
+						},
+					},
+				},
+				Rparen: nullSpan(),
+			},
+		}},
+	},
+	{
+		name:  "LetParenSource",
+		query: "let T = (StormEvents | count)",
+		want: []Statement{&LetStatement{
+			Keyword: newSpan(0, 3),
+			Name: &Ident{
+				Name:     "T",
+				NameSpan: newSpan(4, 5),
+			},
+			Assign: newSpan(6, 7),
+			Tabular: &TabularExpr{
+				Source: &ParenTabularExpr{
+					Lparen: newSpan(8, 9),
+					X: &TabularExpr{
+						Source: &TableRef{
+							Table: &Ident{
+								Name:     "StormEvents",
+								NameSpan: newSpan(9, 20),
+							},
+						},
+						Operators: []TabularOperator{
+							&CountOperator{
+								Pipe:    newSpan(21, 22),
+								Keyword: newSpan(23, 28),
+							},
+						},
+					},
+					Rparen: newSpan(28, 29),
+				},
+			},
+		}},
+	},
 	{
 		name:  "PipeCount",
 		query: "StormEvents | count",
@@ -815,6 +1130,16 @@ var parserTests = []struct {
 								Value:     "a",
 								ValueSpan: newSpan(19, 22),
 							},
+							&BasicLit{
+								Kind:      TokenString,
+								Value:     "x",
+								ValueSpan: newSpan(31, 34),
+							},
+							&BasicLit{
+								Kind:      TokenString,
+								Value:     "y",
+								ValueSpan: newSpan(36, 39),
+							},
 						},
 						Rparen: newSpan(39, 40),
 					},
@@ -822,6 +1147,101 @@ var parserTests = []struct {
 			},
 		}},
 	},
+	{
+		name:  "BadFirstArgument",
+		query: "foo | where f(+, x)",
+		err:   true,
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "foo",
+					NameSpan: newSpan(0, 3),
+				},
+			},
+			Operators: []TabularOperator{
+				&WhereOperator{
+					Pipe:    newSpan(4, 5),
+					Keyword: newSpan(6, 11),
+					Predicate: &CallExpr{
+						Func: &Ident{
+							Name:     "f",
+							NameSpan: newSpan(12, 13),
+						},
+						Lparen: newSpan(13, 14),
+						Args: []Expr{
+							&UnaryExpr{
+								OpSpan: newSpan(14, 15),
+								Op:     TokenPlus,
+							},
+							(&Ident{
+								Name:     "x",
+								NameSpan: newSpan(17, 18),
+							}).AsQualified(),
+						},
+						Rparen: newSpan(18, 19),
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "BadInElement",
+		query: "foo | where x in (1, (2 + ), 3, * 4, 5)",
+		err:   true,
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "foo",
+					NameSpan: newSpan(0, 3),
+				},
+			},
+			Operators: []TabularOperator{
+				&WhereOperator{
+					Pipe:    newSpan(4, 5),
+					Keyword: newSpan(6, 11),
+					Predicate: &InExpr{
+						X: (&Ident{
+							Name:     "x",
+							NameSpan: newSpan(12, 13),
+						}).AsQualified(),
+						In:     newSpan(14, 16),
+						Lparen: newSpan(17, 18),
+						Vals: []Expr{
+							&BasicLit{
+								Kind:      TokenNumber,
+								Value:     "1",
+								ValueSpan: newSpan(18, 19),
+							},
+							&ParenExpr{
+								Lparen: newSpan(21, 22),
+								X: &BinaryExpr{
+									X: &BasicLit{
+										Kind:      TokenNumber,
+										Value:     "2",
+										ValueSpan: newSpan(22, 23),
+									},
+									OpSpan: newSpan(24, 25),
+									Op:     TokenPlus,
+								},
+								Rparen: newSpan(26, 27),
+							},
+							&BasicLit{
+								Kind:      TokenNumber,
+								Value:     "3",
+								ValueSpan: newSpan(29, 30),
+							},
+							&BasicLit{
+								Kind:      TokenNumber,
+								Value:     "5",
+								ValueSpan: newSpan(37, 38),
+							},
+						},
+						Rparen: newSpan(38, 39),
+					},
+				},
+			},
+		}},
+	},
 	{
 		name:  "BadParentheticalExpr",
 		query: "foo | where (.bork) + 2",
@@ -1664,9 +2084,8 @@ var parserTests = []struct {
 		}},
 	},
 	{
-		name:  "JoinBadFlavor",
-		query: "X | join kind=salt (Y) on Key",
-		err:   true,
+		name:  "JoinCross",
+		query: "X | join kind=cross (Y)",
 		want: []Statement{&TabularExpr{
 			Source: &TableRef{
 				Table: &Ident{
@@ -1682,34 +2101,28 @@ var parserTests = []struct {
 					Kind:       newSpan(9, 13),
 					KindAssign: newSpan(13, 14),
 					Flavor: &Ident{
-						Name:     "salt",
-						NameSpan: newSpan(14, 18),
+						Name:     "cross",
+						NameSpan: newSpan(14, 19),
 					},
 
-					Lparen: newSpan(19, 20),
+					Lparen: newSpan(20, 21),
 					Right: &TabularExpr{
 						Source: &TableRef{
 							Table: &Ident{
 								Name:     "Y",
-								NameSpan: newSpan(20, 21),
+								NameSpan: newSpan(21, 22),
 							},
 						},
 					},
-					Rparen: newSpan(21, 22),
-					On:     newSpan(23, 25),
-					Conditions: []Expr{
-						(&Ident{
-							Name:     "Key",
-							NameSpan: newSpan(26, 29),
-						}).AsQualified(),
-					},
+					Rparen: newSpan(22, 23),
+					On:     nullSpan(),
 				},
 			},
 		}},
 	},
 	{
-		name:  "JoinComplexRight",
-		query: "X | join (Y | where z == 5) on Key",
+		name:  "JoinHint",
+		query: "X | join kind=inner hint.strategy=broadcast (Y) on Key",
 		want: []Statement{&TabularExpr{
 			Source: &TableRef{
 				Table: &Ident{
@@ -1722,20 +2135,153 @@ var parserTests = []struct {
 					Pipe:    newSpan(2, 3),
 					Keyword: newSpan(4, 8),
 
-					Kind:       nullSpan(),
-					KindAssign: nullSpan(),
+					Kind:       newSpan(9, 13),
+					KindAssign: newSpan(13, 14),
+					Flavor: &Ident{
+						Name:     "inner",
+						NameSpan: newSpan(14, 19),
+					},
+					Hints: []*JoinHint{{
+						Keyword: newSpan(20, 24),
+						Dot:     newSpan(24, 25),
+						Name: &Ident{
+							Name:     "strategy",
+							NameSpan: newSpan(25, 33),
+						},
+						Assign: newSpan(33, 34),
+						Value: &Ident{
+							Name:     "broadcast",
+							NameSpan: newSpan(34, 43),
+						},
+					}},
 
-					Lparen: newSpan(9, 10),
+					Lparen: newSpan(44, 45),
 					Right: &TabularExpr{
 						Source: &TableRef{
 							Table: &Ident{
 								Name:     "Y",
-								NameSpan: newSpan(10, 11),
+								NameSpan: newSpan(45, 46),
 							},
 						},
-						Operators: []TabularOperator{
-							&WhereOperator{
-								Pipe:    newSpan(12, 13),
+					},
+					Rparen: newSpan(46, 47),
+					On:     newSpan(48, 50),
+					Conditions: []Expr{
+						(&Ident{
+							Name:     "Key",
+							NameSpan: newSpan(51, 54),
+						}).AsQualified(),
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "JoinBadHint",
+		query: "X | join hint.strategy (Y) on Key",
+		err:   true,
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&JoinOperator{
+					Pipe:       newSpan(2, 3),
+					Keyword:    newSpan(4, 8),
+					Kind:       nullSpan(),
+					KindAssign: nullSpan(),
+					Hints: []*JoinHint{{
+						Keyword: newSpan(9, 13),
+						Dot:     newSpan(13, 14),
+						Name: &Ident{
+							Name:     "strategy",
+							NameSpan: newSpan(14, 22),
+						},
+						Assign: nullSpan(),
+					}},
+					Lparen: nullSpan(),
+					Rparen: nullSpan(),
+					On:     nullSpan(),
+				},
+			},
+		}},
+	},
+	{
+		name:  "JoinBadFlavor",
+		query: "X | join kind=salt (Y) on Key",
+		err:   true,
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&JoinOperator{
+					Pipe:    newSpan(2, 3),
+					Keyword: newSpan(4, 8),
+
+					Kind:       newSpan(9, 13),
+					KindAssign: newSpan(13, 14),
+					Flavor: &Ident{
+						Name:     "salt",
+						NameSpan: newSpan(14, 18),
+					},
+
+					Lparen: newSpan(19, 20),
+					Right: &TabularExpr{
+						Source: &TableRef{
+							Table: &Ident{
+								Name:     "Y",
+								NameSpan: newSpan(20, 21),
+							},
+						},
+					},
+					Rparen: newSpan(21, 22),
+					On:     newSpan(23, 25),
+					Conditions: []Expr{
+						(&Ident{
+							Name:     "Key",
+							NameSpan: newSpan(26, 29),
+						}).AsQualified(),
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "JoinComplexRight",
+		query: "X | join (Y | where z == 5) on Key",
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&JoinOperator{
+					Pipe:    newSpan(2, 3),
+					Keyword: newSpan(4, 8),
+
+					Kind:       nullSpan(),
+					KindAssign: nullSpan(),
+
+					Lparen: newSpan(9, 10),
+					Right: &TabularExpr{
+						Source: &TableRef{
+							Table: &Ident{
+								Name:     "Y",
+								NameSpan: newSpan(10, 11),
+							},
+						},
+						Operators: []TabularOperator{
+							&WhereOperator{
+								Pipe:    newSpan(12, 13),
 								Keyword: newSpan(14, 19),
 								Predicate: &BinaryExpr{
 									X: (&Ident{
@@ -1893,6 +2439,242 @@ var parserTests = []struct {
 			},
 		}},
 	},
+	{
+		name:  "ProjectAway",
+		query: "X | project-away A, B",
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&ProjectAwayOperator{
+					Pipe:    newSpan(2, 3),
+					Keyword: newSpan(4, 16),
+					Cols: []*Ident{
+						{
+							Name:     "A",
+							NameSpan: newSpan(17, 18),
+						},
+						{
+							Name:     "B",
+							NameSpan: newSpan(20, 21),
+						},
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "Union",
+		query: "X | union Y, Z_*",
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&UnionOperator{
+					Pipe:             newSpan(2, 3),
+					Keyword:          newSpan(4, 9),
+					WithSource:       nullSpan(),
+					WithSourceAssign: nullSpan(),
+					Tables: []TabularDataSource{
+						&TableRef{
+							Table: &Ident{
+								Name:     "Y",
+								NameSpan: newSpan(10, 11),
+							},
+						},
+						&TableWildcard{
+							Pattern:     "Z_*",
+							PatternSpan: newSpan(13, 16),
+						},
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "UnionWithSourceTable",
+		query: "X | union withsource, Y",
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&UnionOperator{
+					Pipe:             newSpan(2, 3),
+					Keyword:          newSpan(4, 9),
+					WithSource:       nullSpan(),
+					WithSourceAssign: nullSpan(),
+					Tables: []TabularDataSource{
+						&TableRef{
+							Table: &Ident{
+								Name:     "withsource",
+								NameSpan: newSpan(10, 20),
+							},
+						},
+						&TableRef{
+							Table: &Ident{
+								Name:     "Y",
+								NameSpan: newSpan(22, 23),
+							},
+						},
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "UnionMissingTable",
+		query: "X | union Y,",
+		err:   true,
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&UnionOperator{
+					Pipe:             newSpan(2, 3),
+					Keyword:          newSpan(4, 9),
+					WithSource:       nullSpan(),
+					WithSourceAssign: nullSpan(),
+					Tables: []TabularDataSource{
+						&TableRef{
+							Table: &Ident{
+								Name:     "Y",
+								NameSpan: newSpan(10, 11),
+							},
+						},
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "Distinct",
+		query: "X | distinct A, B",
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&DistinctOperator{
+					Pipe:    newSpan(2, 3),
+					Keyword: newSpan(4, 12),
+					Cols: []*Ident{
+						{
+							Name:     "A",
+							NameSpan: newSpan(13, 14),
+						},
+						{
+							Name:     "B",
+							NameSpan: newSpan(16, 17),
+						},
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "DistinctWithoutColumns",
+		query: "X | distinct",
+		err:   true,
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&DistinctOperator{
+					Pipe:    newSpan(2, 3),
+					Keyword: newSpan(4, 12),
+				},
+			},
+		}},
+	},
+	{
+		name:  "MvExpand",
+		query: "X | mv-expand tags, p = split(path, '/')",
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+			Operators: []TabularOperator{
+				&MvExpandOperator{
+					Pipe:    newSpan(2, 3),
+					Keyword: newSpan(4, 13),
+					Cols: []*MvExpandColumn{
+						{
+							Assign: nullSpan(),
+							X: (&Ident{
+								Name:     "tags",
+								NameSpan: newSpan(14, 18),
+							}).AsQualified(),
+						},
+						{
+							Name: &Ident{
+								Name:     "p",
+								NameSpan: newSpan(20, 21),
+							},
+							Assign: newSpan(22, 23),
+							X: &CallExpr{
+								Func: &Ident{
+									Name:     "split",
+									NameSpan: newSpan(24, 29),
+								},
+								Lparen: newSpan(29, 30),
+								Args: []Expr{
+									(&Ident{
+										Name:     "path",
+										NameSpan: newSpan(30, 34),
+									}).AsQualified(),
+									&BasicLit{
+										Kind:      TokenString,
+										Value:     "/",
+										ValueSpan: newSpan(36, 39),
+									},
+								},
+								Rparen: newSpan(39, 40),
+							},
+						},
+					},
+				},
+			},
+		}},
+	},
+	{
+		name:  "MvExpandSpacedName",
+		query: "X | mv - expand tags",
+		err:   true,
+		want: []Statement{&TabularExpr{
+			Source: &TableRef{
+				Table: &Ident{
+					Name:     "X",
+					NameSpan: newSpan(0, 1),
+				},
+			},
+		}},
+	},
 	{
 		name:  "Let",
 		query: "let n = 10; Events | take n",
@@ -1930,6 +2712,70 @@ var parserTests = []struct {
 			},
 		},
 	},
+	{
+		name:  "LetTabular",
+		query: "let T = Events | take 5; T",
+		want: []Statement{
+			&LetStatement{
+				Keyword: newSpan(0, 3),
+				Name: &Ident{
+					Name:     "T",
+					NameSpan: newSpan(4, 5),
+				},
+				Assign: newSpan(6, 7),
+				Tabular: &TabularExpr{
+					Source: &TableRef{
+						Table: &Ident{
+							Name:     "Events",
+							NameSpan: newSpan(8, 14),
+						},
+					},
+					Operators: []TabularOperator{
+						&TakeOperator{
+							Pipe:    newSpan(15, 16),
+							Keyword: newSpan(17, 21),
+							RowCount: &BasicLit{
+								Kind:      TokenNumber,
+								ValueSpan: newSpan(22, 23),
+								Value:     "5",
+							},
+						},
+					},
+				},
+			},
+			&TabularExpr{
+				Source: &TableRef{
+					Table: &Ident{
+						Name:     "T",
+						NameSpan: newSpan(25, 26),
+					},
+				},
+			},
+		},
+	},
+	{
+		name:  "LetTabularBadOperator",
+		query: "let T = Events | bork",
+		want: []Statement{
+			&LetStatement{
+				Keyword: newSpan(0, 3),
+				Name: &Ident{
+					Name:     "T",
+					NameSpan: newSpan(4, 5),
+				},
+				Assign: newSpan(6, 7),
+				Tabular: &TabularExpr{
+					Source: &TableRef{
+						Table: &Ident{
+							Name:     "Events",
+							NameSpan: newSpan(8, 14),
+						},
+					},
+				},
+			},
+		},
+		err: true,
+	},
 }
 
 func TestParse(t *testing.T) {
@@ -1959,6 +2805,133 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  Expr
+		err   bool
+	}{
+		{
+			name:  "Comparison",
+			query: "x > 5",
+			want: &BinaryExpr{
+				X: &QualifiedIdent{
+					Parts: []*Ident{{
+						Name:     "x",
+						NameSpan: newSpan(0, 1),
+					}},
+				},
+				OpSpan: newSpan(2, 3),
+				Op:     TokenGT,
+				Y: &BasicLit{
+					Kind:      TokenNumber,
+					Value:     "5",
+					ValueSpan: newSpan(4, 5),
+				},
+			},
+		},
+		{
+			name:  "Call",
+			query: `isnotnull(user.email)`,
+			want: &CallExpr{
+				Func: &Ident{
+					Name:     "isnotnull",
+					NameSpan: newSpan(0, 9),
+				},
+				Lparen: newSpan(9, 10),
+				Args: []Expr{
+					&QualifiedIdent{
+						Parts: []*Ident{
+							{
+								Name:     "user",
+								NameSpan: newSpan(10, 14),
+							},
+							{
+								Name:     "email",
+								NameSpan: newSpan(15, 20),
+							},
+						},
+					},
+				},
+				Rparen: newSpan(20, 21),
+			},
+		},
+		{
+			name:  "Empty",
+			query: "",
+			err:   true,
+		},
+		{
+			name:  "TrailingOperator",
+			query: "x > 5 | count",
+			want: &BinaryExpr{
+				X: &QualifiedIdent{
+					Parts: []*Ident{{
+						Name:     "x",
+						NameSpan: newSpan(0, 1),
+					}},
+				},
+				OpSpan: newSpan(2, 3),
+				Op:     TokenGT,
+				Y: &BasicLit{
+					Kind:      TokenNumber,
+					Value:     "5",
+					ValueSpan: newSpan(4, 5),
+				},
+			},
+			err: true,
+		},
+		{
+			name:  "Statement",
+			query: "let x = 5",
+			err:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseExpr(test.query)
+			if err != nil {
+				if test.err {
+					t.Logf("ParseExpr(%q) error (as expected): %v", test.query, err)
+				} else {
+					t.Errorf("ParseExpr(%q) returned unexpected error: %v", test.query, err)
+				}
+			}
+			if err == nil && test.err {
+				t.Errorf("ParseExpr(%q) did not return an error", test.query)
+			}
+			if test.want == nil {
+				return
+			}
+			if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("ParseExpr(%q) (-want +got):\n%s", test.query, diff)
+			}
+		})
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	const query = "StormEvents\n| where"
+	_, err := Parse(query)
+	if err == nil {
+		t.Fatalf("Parse(%q) did not return an error", query)
+	}
+	var perr PositionedError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Parse(%q) error = %v; want a PositionedError", query, err)
+	}
+	if got, want := perr.Span(), indexSpan(len(query)); got != want {
+		t.Errorf("Parse(%q) error span = %v; want %v", query, got, want)
+	}
+	if got, want := perr.Position(), (Position{Line: 2, Column: 8}); got != want {
+		t.Errorf("Parse(%q) error position = %v; want %v", query, got, want)
+	}
+	if got := perr.Source(); got != query {
+		t.Errorf("Parse(%q) error source = %q; want %q", query, got, query)
+	}
+}
+
 func FuzzParse(f *testing.F) {
 	for _, test := range parserTests {
 		f.Add(test.query)
@@ -1979,3 +2952,150 @@ func BenchmarkParse(b *testing.B) {
 		}
 	}
 }
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  *ParseOptions
+		query string
+		span  Span // invalid if the query should parse successfully
+	}{
+		{
+			name:  "DefaultDepth",
+			opts:  nil,
+			query: "T | where " + strings.Repeat("(", DefaultMaxDepth) + "x" + strings.Repeat(")", DefaultMaxDepth),
+			span:  nullSpan(),
+		},
+		{
+			name:  "DefaultDepthExceeded",
+			opts:  nil,
+			query: "T | where " + strings.Repeat("(", DefaultMaxDepth+1) + "x" + strings.Repeat(")", DefaultMaxDepth+1),
+			span:  newSpan(10+DefaultMaxDepth, 11+DefaultMaxDepth),
+		},
+		{
+			name:  "Depth",
+			opts:  &ParseOptions{MaxDepth: 2},
+			query: "T | where f(x[(1)])",
+			span:  newSpan(14, 15),
+		},
+		{
+			name:  "DepthAcrossStatements",
+			opts:  &ParseOptions{MaxDepth: 2},
+			query: "T | where f((1)); T | where f((1))",
+			span:  nullSpan(),
+		},
+		{
+			name:  "UnlimitedDepth",
+			opts:  &ParseOptions{MaxDepth: -1},
+			query: "T | where " + strings.Repeat("(", 2*DefaultMaxDepth) + "x" + strings.Repeat(")", 2*DefaultMaxDepth),
+			span:  nullSpan(),
+		},
+		{
+			name:  "Tokens",
+			opts:  &ParseOptions{MaxTokens: 3},
+			query: "T | take 5",
+			span:  newSpan(9, 10),
+		},
+		{
+			name:  "TokensWithinLimit",
+			opts:  &ParseOptions{MaxTokens: 4},
+			query: "T | take 5",
+			span:  nullSpan(),
+		},
+		{
+			name:  "Statements",
+			opts:  &ParseOptions{MaxStatements: 2},
+			query: "let x = 1;; let y = 2; T",
+			span:  newSpan(23, 24),
+		},
+		{
+			name:  "StatementsWithinLimit",
+			opts:  &ParseOptions{MaxStatements: 2},
+			query: "let x = 1;; T;",
+			span:  nullSpan(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.opts.Parse(test.query)
+			if !test.span.IsValid() {
+				if err != nil {
+					t.Errorf("Parse(...) = _, %v; want <nil>", err)
+				}
+				return
+			}
+			diags := Diagnostics(err)
+			if len(diags) != 1 {
+				t.Fatalf("Parse(...) diagnostics = %v; want 1 diagnostic", diags)
+			}
+			if got, want := diags[0].Code, CodeLimitExceeded; got != want {
+				t.Errorf("diagnostic code = %q; want %q", got, want)
+			}
+			if got := diags[0].Span; got != test.span {
+				t.Errorf("diagnostic span = %v; want %v", got, test.span)
+			}
+		})
+	}
+}
+
+func TestReparseKeepsLimits(t *testing.T) {
+	opts := &ParseOptions{MaxTokens: 3}
+	r := opts.ParseIncremental("T | count")
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	r = r.Reparse(newSpan(4, 9), "take 5")
+	if diags := r.Diagnostics(); len(diags) != 1 || diags[0].Code != CodeLimitExceeded {
+		t.Errorf("Reparse(...).Diagnostics() = %v; want a %s diagnostic", diags, CodeLimitExceeded)
+	}
+}
+
+func TestParsePooled(t *testing.T) {
+	opts := &ParseOptions{Pooled: true}
+	for _, test := range parserTests {
+		t.Run(test.name, func(t *testing.T) {
+			want, wantErr := Parse(test.query)
+			// Parse twice so that the second parse reuses the memory of the first.
+			for i := 0; i < 2; i++ {
+				r := opts.ParseIncremental(test.query)
+				if diff := cmp.Diff(want, r.Statements(), cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("ParseIncremental(%q) #%d (-want +got):\n%s", test.query, i+1, diff)
+				}
+				if (r.Err() == nil) != (wantErr == nil) {
+					t.Errorf("ParseIncremental(%q).Err() = %v; want %v", test.query, r.Err(), wantErr)
+				}
+				r.Release()
+			}
+		})
+	}
+}
+
+func TestReparsePooled(t *testing.T) {
+	opts := &ParseOptions{Pooled: true}
+	r1 := opts.ParseIncremental("let x = 1;\nT | where y == x")
+	r2 := r1.Reparse(newSpan(8, 9), "2")
+	r1.Release()
+
+	want, err := Parse("let x = 2;\nT | where y == x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, r2.Statements(), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("statements after releasing original (-want +got):\n%s", diff)
+	}
+	r2.Release()
+	r2.Release() // Releasing twice is a no-op.
+}
+
+func BenchmarkParsePooled(b *testing.B) {
+	b.ReportAllocs()
+
+	opts := &ParseOptions{Pooled: true}
+	for i := 0; i < b.N; i++ {
+		r := opts.ParseIncremental(`StormEvents | where EventType == "Tornado" or EventType != "Thunderstorm Wind"`)
+		if err := r.Err(); err != nil {
+			b.Fatal(err)
+		}
+		r.Release()
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Rewrite traverses the syntax tree rooted at root in depth-first order,
+// calling fn for each non-nil node before the node's children.
+// If fn returns false, Rewrite does not traverse the node's children.
+// fn can use the given [Cursor] to replace or delete the current node
+// or to insert nodes around it.
+// Nodes that fn replaces, deletes, or inserts are not traversed.
+//
+// Rewrite modifies the syntax tree in place
+// and returns the root of the modified tree,
+// which is different from root if fn replaced it.
+func Rewrite(root Node, fn func(c *Cursor) bool) Node {
+	parent := &rootNode{Node: root}
+	r := &rewriter{fn: fn}
+	r.apply(parent, "Node", nil, root)
+	return parent.Node
+}
+
+// rootNode is the parent of the root node passed to [Rewrite].
+type rootNode struct {
+	Node
+}
+
+// A Cursor describes a node encountered during [Rewrite].
+// A Cursor is only valid during the call to the function passed to Rewrite.
+type Cursor struct {
+	parent   Node
+	name     string
+	iter     *iterator // nil if the node is not in a slice
+	node     Node
+	detached bool
+}
+
+// iterator is the position of a node in a slice field.
+type iterator struct {
+	// index is the index of the current node.
+	index int
+	// step is the amount to advance index by to get to the next node.
+	step int
+}
+
+// Node returns the current node.
+func (c *Cursor) Node() Node {
+	return c.node
+}
+
+// Parent returns the parent of the current node.
+// Parent returns a placeholder node if the current node is the root.
+func (c *Cursor) Parent() Node {
+	return c.parent
+}
+
+// Name returns the name of the parent's struct field that contains the current node,
+// like "Predicate" for the predicate of a [*WhereOperator].
+// If the current node is an element of a slice, Name returns the name of the slice field.
+func (c *Cursor) Name() string {
+	return c.name
+}
+
+// Index returns the index of the current node
+// in the slice field of its parent that contains it.
+// Index returns -1 if the current node is not part of a slice.
+func (c *Cursor) Index() int {
+	if c.iter == nil {
+		return -1
+	}
+	return c.iter.index
+}
+
+func (c *Cursor) field() reflect.Value {
+	return reflect.ValueOf(c.parent).Elem().FieldByName(c.name)
+}
+
+// Replace replaces the current node with n.
+// If n is nil, the field is set to its zero value.
+// Replace panics if n's type cannot be assigned to the parent's field.
+func (c *Cursor) Replace(n Node) {
+	if c.detached {
+		panic(fmt.Errorf("parser: Replace called on a %T that was replaced or deleted", c.node))
+	}
+	v := c.field()
+	if i := c.Index(); i >= 0 {
+		v = v.Index(i)
+	}
+	if n == nil {
+		v.Set(reflect.Zero(v.Type()))
+	} else {
+		v.Set(reflect.ValueOf(n))
+	}
+	c.detached = true
+}
+
+// Delete deletes the current node from its containing slice.
+// Delete panics if the current node is not part of a slice.
+func (c *Cursor) Delete() {
+	i := c.Index()
+	if i < 0 {
+		panic(fmt.Errorf("parser: Delete called on %T not contained in a slice", c.node))
+	}
+	if c.detached {
+		panic(fmt.Errorf("parser: Delete called on a %T that was replaced or deleted", c.node))
+	}
+	v := c.field()
+	n := v.Len()
+	reflect.Copy(v.Slice(i, n), v.Slice(i+1, n))
+	v.Index(n - 1).Set(reflect.Zero(v.Type().Elem()))
+	v.SetLen(n - 1)
+	c.iter.step--
+	c.detached = true
+}
+
+// InsertAfter inserts n after the current node in its containing slice.
+// InsertAfter panics if the current node is not part of a slice.
+func (c *Cursor) InsertAfter(n Node) {
+	i := c.Index()
+	if i < 0 {
+		panic(fmt.Errorf("parser: InsertAfter called on %T not contained in a slice", c.node))
+	}
+	v := c.field()
+	v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+	l := v.Len()
+	reflect.Copy(v.Slice(i+2, l), v.Slice(i+1, l))
+	v.Index(i + 1).Set(reflect.ValueOf(n))
+	c.iter.step++
+}
+
+// InsertBefore inserts n before the current node in its containing slice.
+// InsertBefore panics if the current node is not part of a slice.
+func (c *Cursor) InsertBefore(n Node) {
+	i := c.Index()
+	if i < 0 {
+		panic(fmt.Errorf("parser: InsertBefore called on %T not contained in a slice", c.node))
+	}
+	v := c.field()
+	v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+	l := v.Len()
+	reflect.Copy(v.Slice(i+1, l), v.Slice(i, l))
+	v.Index(i).Set(reflect.ValueOf(n))
+	c.iter.index++
+}
+
+type rewriter struct {
+	fn func(c *Cursor) bool
+}
+
+func (r *rewriter) apply(parent Node, name string, iter *iterator, n Node) {
+	if isNilNode(n) {
+		return
+	}
+	c := &Cursor{
+		parent: parent,
+		name:   name,
+		iter:   iter,
+		node:   n,
+	}
+	if !r.fn(c) || c.detached {
+		return
+	}
+
+	switch n := n.(type) {
+	case *Ident, *BasicLit, *CountOperator:
+		// No children.
+	case *QualifiedIdent:
+		r.applyList(n, "Parts")
+	case *TabularExpr:
+		r.apply(n, "Source", nil, n.Source)
+		r.applyList(n, "Operators")
+	case *TableRef:
+		r.apply(n, "Cluster", nil, n.Cluster)
+		r.apply(n, "Database", nil, n.Database)
+		r.apply(n, "Table", nil, n.Table)
+	case *TableWildcard:
+		r.apply(n, "Database", nil, n.Database)
+	case *TableCall:
+		r.apply(n, "Name", nil, n.Name)
+	case *ParenTabularExpr:
+		r.apply(n, "X", nil, n.X)
+	case *UnionSource:
+		r.apply(n, "SourceColumn", nil, n.SourceColumn)
+		r.applyList(n, "Tables")
+	case *WhereOperator:
+		r.apply(n, "Predicate", nil, n.Predicate)
+	case *SortOperator:
+		r.applyList(n, "Terms")
+	case *SortTerm:
+		r.apply(n, "X", nil, n.X)
+	case *TakeOperator:
+		r.apply(n, "RowCount", nil, n.RowCount)
+	case *TopOperator:
+		r.apply(n, "RowCount", nil, n.RowCount)
+		r.apply(n, "Col", nil, n.Col)
+	case *UnionOperator:
+		r.apply(n, "SourceColumn", nil, n.SourceColumn)
+		r.applyList(n, "Tables")
+	case *ProjectOperator:
+		r.applyList(n, "Cols")
+	case *ProjectColumn:
+		r.apply(n, "Name", nil, n.Name)
+		r.apply(n, "X", nil, n.X)
+	case *ProjectAwayOperator:
+		r.applyList(n, "Cols")
+	case *DistinctOperator:
+		r.applyList(n, "Cols")
+	case *ExtendOperator:
+		r.applyList(n, "Cols")
+	case *ExtendColumn:
+		r.apply(n, "Name", nil, n.Name)
+		r.apply(n, "X", nil, n.X)
+	case *MvExpandOperator:
+		r.applyList(n, "Cols")
+	case *MvExpandColumn:
+		r.apply(n, "Name", nil, n.Name)
+		r.apply(n, "X", nil, n.X)
+	case *SummarizeOperator:
+		r.applyList(n, "Cols")
+		r.applyList(n, "GroupBy")
+	case *SummarizeColumn:
+		r.apply(n, "Name", nil, n.Name)
+		r.apply(n, "X", nil, n.X)
+	case *JoinOperator:
+		r.apply(n, "Flavor", nil, n.Flavor)
+		r.apply(n, "Right", nil, n.Right)
+		r.applyList(n, "Conditions")
+	case *AsOperator:
+		r.apply(n, "Name", nil, n.Name)
+	case *RenderOperator:
+		r.apply(n, "ChartType", nil, n.ChartType)
+		r.applyList(n, "Props")
+	case *RenderProperty:
+		r.apply(n, "Name", nil, n.Name)
+		r.apply(n, "Value", nil, n.Value)
+	case *BinaryExpr:
+		r.apply(n, "X", nil, n.X)
+		r.apply(n, "Y", nil, n.Y)
+	case *UnaryExpr:
+		r.apply(n, "X", nil, n.X)
+	case *InExpr:
+		r.apply(n, "X", nil, n.X)
+		r.applyList(n, "Vals")
+	case *ParenExpr:
+		r.apply(n, "X", nil, n.X)
+	case *CallExpr:
+		r.apply(n, "Func", nil, n.Func)
+		r.applyList(n, "Args")
+	case *IndexExpr:
+		r.apply(n, "X", nil, n.X)
+		r.apply(n, "Index", nil, n.Index)
+	case *LetStatement:
+		r.apply(n, "Name", nil, n.Name)
+		r.apply(n, "X", nil, n.X)
+		r.apply(n, "Tabular", nil, n.Tabular)
+	default:
+		panic(fmt.Errorf("unknown Node type %T", n))
+	}
+}
+
+// applyList calls apply on each element of the slice field with the given name.
+func (r *rewriter) applyList(parent Node, name string) {
+	iter := new(iterator)
+	for {
+		// The function may have changed the slice, so re-read it on every iteration.
+		v := reflect.ValueOf(parent).Elem().FieldByName(name)
+		if iter.index >= v.Len() {
+			return
+		}
+		var n Node
+		if elem := v.Index(iter.index); !elem.IsNil() {
+			n = elem.Interface().(Node)
+		}
+		iter.step = 1
+		r.apply(parent, name, iter, n)
+		iter.index += iter.step
+	}
+}
+
+// isNilNode reports whether n is nil or a nil pointer.
+func isNilNode(n Node) bool {
+	if n == nil {
+		return true
+	}
+	v := reflect.ValueOf(n)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		fn    func(c *Cursor) bool
+		want  string
+	}{
+		{
+			name:  "Identity",
+			query: "StormEvents | where State == 'TEXAS' | project EventId, State",
+			fn:    func(c *Cursor) bool { return true },
+			want:  "StormEvents | where State == 'TEXAS' | project EventId, State",
+		},
+		{
+			name:  "RenameTable",
+			query: "StormEvents | join (StormEvents | take 5) on EventId",
+			fn: func(c *Cursor) bool {
+				if _, ok := c.Parent().(*TableRef); ok && c.Name() == "Table" {
+					c.Replace(&Ident{Name: "Events", NameSpan: nullSpan()})
+				}
+				return true
+			},
+			want: "Events | join (Events | take 5) on EventId",
+		},
+		{
+			name:  "InsertFilter",
+			query: "StormEvents | take 5",
+			fn: func(c *Cursor) bool {
+				if c.Name() == "Operators" && c.Index() == 0 {
+					c.InsertBefore(&WhereOperator{
+						Pipe:    nullSpan(),
+						Keyword: nullSpan(),
+						Predicate: &BinaryExpr{
+							X:      (&Ident{Name: "State", NameSpan: nullSpan()}).AsQualified(),
+							OpSpan: nullSpan(),
+							Op:     TokenEq,
+							Y:      &BasicLit{ValueSpan: nullSpan(), Kind: TokenString, Value: "TEXAS"},
+						},
+					})
+				}
+				return true
+			},
+			want: "StormEvents | where State == 'TEXAS' | take 5",
+		},
+		{
+			name:  "InsertAfter",
+			query: "StormEvents | take 5 | count",
+			fn: func(c *Cursor) bool {
+				if _, ok := c.Node().(*TakeOperator); ok {
+					c.InsertAfter(&AsOperator{
+						Pipe:    nullSpan(),
+						Keyword: nullSpan(),
+						Name:    &Ident{Name: "Sample", NameSpan: nullSpan()},
+					})
+				}
+				return true
+			},
+			want: "StormEvents | take 5 | as Sample | count",
+		},
+		{
+			name:  "DeleteOperators",
+			query: "StormEvents | sort by EventId | sort by State | take 5 | sort by EventId",
+			fn: func(c *Cursor) bool {
+				if _, ok := c.Node().(*SortOperator); ok {
+					c.Delete()
+				}
+				return true
+			},
+			want: "StormEvents | take 5",
+		},
+		{
+			name:  "DeleteArgument",
+			query: "StormEvents | project x = strcat(State, '-', EventType)",
+			fn: func(c *Cursor) bool {
+				if lit, ok := c.Node().(*BasicLit); ok && lit.Value == "-" {
+					c.Delete()
+				}
+				return true
+			},
+			want: "StormEvents | project x = strcat(State, EventType)",
+		},
+		{
+			name:  "ReplaceExpression",
+			query: "StormEvents | where EventId > limit and State == 'TEXAS'",
+			fn: func(c *Cursor) bool {
+				if id, ok := c.Node().(*QualifiedIdent); ok && len(id.Parts) == 1 && id.Parts[0].Name == "limit" {
+					c.Replace(&BasicLit{ValueSpan: nullSpan(), Kind: TokenNumber, Value: "100"})
+				}
+				return true
+			},
+			want: "StormEvents | where EventId > 100 and State == 'TEXAS'",
+		},
+		{
+			name:  "SkipChildren",
+			query: "StormEvents | join (StormEvents) on EventId",
+			fn: func(c *Cursor) bool {
+				if _, ok := c.Node().(*JoinOperator); ok {
+					return false
+				}
+				if id, ok := c.Node().(*Ident); ok {
+					id.Name = "Events"
+				}
+				return true
+			},
+			want: "Events | join (StormEvents) on EventId",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stmts, err := Parse(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := Parse(test.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Rewrite(stmts[0], test.fn)
+			if diff := cmp.Diff(want[0], got, cmpopts.IgnoreTypes(Span{}), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Rewrite(Parse(%q), ...) (-want +got):\n%s", test.query, diff)
+			}
+		})
+	}
+}
+
+func TestRewriteReplaceRoot(t *testing.T) {
+	stmts, err := Parse("StormEvents | take 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Ident{Name: "x", NameSpan: nullSpan()}
+	got := Rewrite(stmts[0], func(c *Cursor) bool {
+		if c.Node() == stmts[0] {
+			c.Replace(want)
+		}
+		return true
+	})
+	if got != Node(want) {
+		t.Errorf("Rewrite(...) = %#v; want %#v", got, want)
+	}
+}
@@ -62,6 +62,56 @@ func unionSpans(spans ...Span) Span {
 	return u
 }
 
+// Position is a human-readable location in a query.
+type Position struct {
+	// Line is the 1-based line number.
+	Line int
+	// Column is the 1-based column number.
+	// Tabs advance the column to the next tab stop,
+	// where tab stops are every 8 columns.
+	Column int
+}
+
+// PositionFor returns the position of the byte at the given offset in source.
+// PositionFor panics if offset is negative or greater than len(source).
+func PositionFor(source string, offset int) Position {
+	pos := Position{Line: 1, Column: 1}
+	for _, c := range source[:offset] {
+		switch c {
+		case '\n':
+			pos.Line++
+			pos.Column = 1
+		case '\t':
+			const tabWidth = 8
+			tabLoc := (pos.Column - 1) % tabWidth
+			pos.Column += tabWidth - tabLoc
+		default:
+			pos.Column++
+		}
+	}
+	return pos
+}
+
+// String formats the position as "line:column".
+func (pos Position) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// A PositionedError is an error that refers to a location in a query.
+// Errors returned by [Parse] wrap one or more PositionedError values,
+// which can be retrieved with [errors.As].
+// Together, Span and Source locate the error without parsing its message,
+// like converting it to an editor range with [PositionFor].
+type PositionedError interface {
+	error
+	// Span returns the span of the query that the error refers to.
+	Span() Span
+	// Position returns the position of the start of the error's span.
+	Position() Position
+	// Source returns the text of the query that the error's span refers to.
+	Source() string
+}
+
 func spanString(s string, span Span) string {
 	if !span.IsValid() {
 		return ""
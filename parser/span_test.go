@@ -106,3 +106,25 @@ func TestUnionSpans(t *testing.T) {
 		}
 	}
 }
+
+func TestPositionFor(t *testing.T) {
+	tests := []struct {
+		source string
+		offset int
+		want   Position
+	}{
+		{source: "", offset: 0, want: Position{Line: 1, Column: 1}},
+		{source: "abc", offset: 2, want: Position{Line: 1, Column: 3}},
+		{source: "abc\ndef", offset: 3, want: Position{Line: 1, Column: 4}},
+		{source: "abc\ndef", offset: 4, want: Position{Line: 2, Column: 1}},
+		{source: "abc\ndef", offset: 7, want: Position{Line: 2, Column: 4}},
+		{source: "\tx", offset: 1, want: Position{Line: 1, Column: 9}},
+		{source: "ab\tx", offset: 3, want: Position{Line: 1, Column: 9}},
+		{source: "é x", offset: 3, want: Position{Line: 1, Column: 3}},
+	}
+	for _, test := range tests {
+		if got := PositionFor(test.source, test.offset); got != test.want {
+			t.Errorf("PositionFor(%q, %d) = %v; want %v", test.source, test.offset, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"bytes"
+	"io"
+)
+
+// A StatementScanner reads semicolon-separated statements from an [io.Reader]
+// as they arrive, like a [bufio.Scanner] that splits on semicolons.
+// It returns the same statements as [SplitStatements] would for the whole input,
+// but it only holds the statement being read in memory,
+// so it is suitable for large query files and interactive input.
+//
+// A statement is returned as soon as the line containing its semicolon is read.
+// Since no token spans more than one line,
+// semicolons in strings, quoted identifiers, and comments
+// can be recognized without reading any further.
+type StatementScanner struct {
+	r   io.Reader
+	buf []byte
+	// start is the position in buf of the next statement.
+	start int
+	// scanned is the length of the prefix of buf that has been scanned for semicolons.
+	// It is always start or the position after a newline,
+	// so scanning can resume there.
+	scanned int
+	// semis is the positions in buf of the semicolons in buf[start:scanned].
+	semis []int
+
+	text string
+	err  error
+	eof  bool
+	done bool
+}
+
+// statementReadSize is the minimum number of bytes a [StatementScanner] reads at once.
+const statementReadSize = 4096
+
+// NewStatementScanner returns a new StatementScanner that reads from r.
+func NewStatementScanner(r io.Reader) *StatementScanner {
+	return &StatementScanner{r: r}
+}
+
+// Scan advances to the next statement, which is then available from Text.
+// It returns false when the input ends or a read fails.
+// Like [SplitStatements], Scan returns the text after the final semicolon
+// as the last statement, even if it is empty.
+func (s *StatementScanner) Scan() bool {
+	for {
+		if len(s.semis) > 0 {
+			end := s.semis[0]
+			s.semis = s.semis[1:]
+			s.text = string(s.buf[s.start:end])
+			s.start = end + len(";")
+			return true
+		}
+		if s.eof {
+			if s.done {
+				s.text = ""
+				return false
+			}
+			s.done = true
+			s.text = string(s.buf[s.start:])
+			return true
+		}
+		if s.err != nil || !s.fill() {
+			s.text = ""
+			return false
+		}
+	}
+}
+
+// Text returns the statement read by the most recent call to Scan,
+// not including its semicolon.
+func (s *StatementScanner) Text() string {
+	return s.text
+}
+
+// Err returns the first error encountered while reading the input,
+// or nil if the input was read to the end.
+func (s *StatementScanner) Err() error {
+	return s.err
+}
+
+// fill reads more input and scans the newly completed lines for semicolons.
+// It must only be called when there are no unreturned semicolons.
+// It returns false if reading failed.
+func (s *StatementScanner) fill() bool {
+	// Discard the statements that have already been returned.
+	if s.start > 0 {
+		n := copy(s.buf, s.buf[s.start:])
+		s.buf = s.buf[:n]
+		s.scanned -= s.start
+		s.start = 0
+	}
+	if cap(s.buf)-len(s.buf) < statementReadSize {
+		newBuf := make([]byte, len(s.buf), max(2*cap(s.buf), len(s.buf)+statementReadSize))
+		copy(newBuf, s.buf)
+		s.buf = newBuf
+	}
+	n, err := s.r.Read(s.buf[len(s.buf):cap(s.buf)])
+	s.buf = s.buf[:len(s.buf)+n]
+	switch {
+	case err == io.EOF:
+		s.eof = true
+	case err != nil:
+		s.err = err
+		return false
+	}
+
+	end := len(s.buf)
+	if !s.eof {
+		// Only scan complete lines: the rest of the line may change its tokens.
+		end = bytes.LastIndexByte(s.buf[s.scanned:], '\n') + 1
+		if end == 0 {
+			return true
+		}
+		end += s.scanned
+	}
+	start := s.scanned
+	for t := NewTokenizer(string(s.buf[start:end])); t.Next(); {
+		if tok := t.Token(); tok.Kind == TokenSemi {
+			s.semis = append(s.semis, start+tok.Span.Start)
+		}
+	}
+	s.scanned = end
+	return true
+}
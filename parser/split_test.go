@@ -0,0 +1,86 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package parser
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStatementScanner(t *testing.T) {
+	sources := []string{
+		"",
+		"foo",
+		"foo;",
+		"foo;bar",
+		"let x = 1;\nStormEvents | take x;\n",
+		"T | where a == 'x;y' // c;d\n| take 1;\nU",
+		"T | where p == 'C:\\';\nU | where q == \"a\\\"b;c\"",
+		"T `a;\n;b`;c",
+		strings.Repeat("StormEvents | where State == 'TEXAS' | take 10;\n", 500),
+	}
+	readers := []struct {
+		name string
+		new  func(string) io.Reader
+	}{
+		{"Whole", func(s string) io.Reader { return strings.NewReader(s) }},
+		{"OneByte", func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) }},
+		{"Half", func(s string) io.Reader { return iotest.HalfReader(strings.NewReader(s)) }},
+		{"DataErr", func(s string) io.Reader { return iotest.DataErrReader(strings.NewReader(s)) }},
+	}
+	for _, source := range sources {
+		want := SplitStatements(source)
+		for _, r := range readers {
+			s := NewStatementScanner(r.new(source))
+			var got []string
+			for s.Scan() {
+				got = append(got, s.Text())
+			}
+			if err := s.Err(); err != nil {
+				t.Errorf("%s: StatementScanner(%q).Err() = %v", r.name, source, err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("%s: StatementScanner(%q) (-SplitStatements +got):\n%s", r.name, source, diff)
+			}
+		}
+	}
+}
+
+func TestStatementScannerStreams(t *testing.T) {
+	// Statements are returned once their line is complete,
+	// without waiting for the rest of the input.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	s := NewStatementScanner(pr)
+	go pw.Write([]byte("T | take 1; U | take 2;\nV"))
+	for _, want := range []string{"T | take 1", " U | take 2"} {
+		if !s.Scan() {
+			t.Fatalf("Scan() = false; want true (err = %v)", s.Err())
+		}
+		if got := s.Text(); got != want {
+			t.Errorf("Text() = %q; want %q", got, want)
+		}
+	}
+}
+
+func TestStatementScannerError(t *testing.T) {
+	readErr := errors.New("bork")
+	r := io.MultiReader(strings.NewReader("T | take 1;\nU"), iotest.ErrReader(readErr))
+	s := NewStatementScanner(r)
+	var got []string
+	for s.Scan() {
+		got = append(got, s.Text())
+	}
+	if diff := cmp.Diff([]string{"T | take 1"}, got); diff != "" {
+		t.Errorf("statements (-want +got):\n%s", diff)
+	}
+	if err := s.Err(); err != readErr {
+		t.Errorf("Err() = %v; want %v", err, readErr)
+	}
+}
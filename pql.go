@@ -5,9 +5,16 @@
 package pql
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/runreveal/pql/parser"
 )
@@ -27,17 +34,357 @@ type CompileOptions struct {
 	// For example, a "foo": "$1" entry would replace unquoted "foo" identifiers
 	// with "$1" in the resulting SQL.
 	Parameters map[string]string
+
+	// Strict causes Compile to return an error
+	// for operators and expressions that cannot be translated to SQL.
+	// If Strict is false, such constructs are compiled
+	// to NULL values with an explanatory SQL comment.
+	// Strict will become the default in the next major release.
+	Strict bool
+
+	// DisallowUnknownFunctions causes Compile to return an error
+	// for calls to functions that pql does not recognize.
+	// By default, such calls are written to the SQL unchanged
+	// so that database-specific functions can be used.
+	DisallowUnknownFunctions bool
+
+	// AnalysisContext describes the tables available to the query.
+	// It is used to resolve table wildcards like Events_*
+	// and table() calls to the tables they match.
+	// Queries that use them fail to compile if AnalysisContext is nil.
+	// If the columns of both sides of a join are known,
+	// the compiler also moves filters that follow the join into its inputs.
+	AnalysisContext *AnalysisContext
+
+	// SubqueryName returns the name of the common table expression
+	// used for an intermediate query.
+	// index is the position of the expression in the WITH clause
+	// and sql is the expression's body.
+	// If SubqueryName is nil, intermediate queries are named
+	// "__subquery" followed by a number.
+	// Names that collide with a tabular let statement
+	// or another intermediate query cause Compile to return an error.
+	// [HashSubqueryName] produces names that only depend on the body.
+	SubqueryName func(index int, sql string) string
+
+	// InlineSubqueries causes intermediate queries to be written
+	// as nested subqueries in the FROM clause instead of
+	// as common table expressions in a WITH clause.
+	// This is useful when the output is embedded in another statement
+	// that cannot contain a WITH clause.
+	// Queries that are referenced more than once are repeated.
+	InlineSubqueries bool
+
+	// Dialect is the database that the SQL is written for.
+	// The zero value is [ClickHouseDialect].
+	// Other dialects write count() as count(*)
+	// and reject cluster references, which only ClickHouse supports.
+	Dialect Dialect
+
+	// Split determines how the operators in a pipeline
+	// are grouped into intermediate queries.
+	// The zero value is [FusedSplit].
+	Split SplitStrategy
+
+	// Format is the layout of the returned SQL.
+	// The zero value is [DefaultSQLFormat].
+	Format SQLFormat
+
+	// StringComparison determines how the equality operators
+	// (==, !=, =~, !~, and in) are written in SQL.
+	// The zero value is [LowerStringComparison].
+	StringComparison StringComparison
+
+	// ThreeValuedComparisons causes == and != to be written
+	// as plain SQL = and <> operators,
+	// which produce NULL if either operand is NULL.
+	// By default, the comparisons are wrapped in coalesce(..., FALSE)
+	// so that they always produce a boolean,
+	// but this can prevent some databases from using indexes.
+	ThreeValuedComparisons bool
+
+	// InListThreshold is the number of values at which an in operator
+	// whose values are all string literals or all number literals
+	// is written as an array membership test instead of an IN list:
+	// has([...], x) for ClickHouse, x = ANY(ARRAY[...]) for PostgreSQL,
+	// and list_contains([...], x) for DuckDB.
+	// Databases handle one array much faster than thousands of IN operands.
+	// Unlike IN, has and list_contains are false rather than NULL for a NULL operand.
+	// If InListThreshold is zero or negative, in operators are always written as IN lists.
+	InListThreshold int
+
+	// InListParameter, if not nil, is called for each in operator
+	// that reaches InListThreshold with the operator's values
+	// as a []string, []int64, or []float64.
+	// It returns the SQL for a query parameter, like "$1",
+	// that the caller binds to the values,
+	// which is written in place of the array literal.
+	// With [CaseInsensitiveStringComparison], the strings are already lowercased.
+	InListParameter func(values any) string
+
+	// ColumnName returns the SQL name for a column
+	// whose name is derived by the compiler,
+	// like the "count()" column produced by the count operator
+	// or a summarize aggregation without an explicit name,
+	// which is named after its source text.
+	// If ColumnName is nil, derived names are used unchanged.
+	// [SanitizeColumnName] is suitable for databases
+	// that reject punctuation in column aliases.
+	ColumnName func(name string) string
+
+	// CaseInsensitiveSort causes the sort and top operators
+	// to order strings without regard to case
+	// by sorting on the lower() of each key.
+	// SortCollation, if not empty, is written in a COLLATE clause
+	// after each sort key, like 'en' for ClickHouse or "en_US" for PostgreSQL.
+	// It is written verbatim, so it must be quoted as the database requires.
+	//
+	// Both options only apply to keys that may be strings.
+	// If AnalysisContext is set, keys that are columns
+	// with a known non-string type are sorted normally.
+	// Otherwise, every key other than a number literal is treated as a string.
+	CaseInsensitiveSort bool
+	SortCollation       string
+
+	// Warn is called with problems in the query that do not prevent compilation,
+	// like join hints that have no equivalent in SQL and are ignored.
+	// If Warn is nil, such problems are not reported.
+	Warn func(diag parser.Diagnostic)
+
+	// Functions are stored functions that queries can refer to by name,
+	// like those read by [ParseADXFunctions].
+	// Each function that the query refers to is bound
+	// as if by a let statement before the query,
+	// so the query's own let statements can shadow them.
+	// Errors in a function's body are reported without a span in the query.
+	Functions []*StoredFunction
+
+	// KQLCompatibility causes Compile to report constructs
+	// whose behavior differs from Microsoft's Kusto Query Language
+	// to Warn with the code [CodeKQLDivergence],
+	// such as joins without a kind and calls to functions that pql does not translate.
+	// [*CompileOptions.KQLFeatures] reports the same differences
+	// along with the constructs that behave the same.
+	KQLCompatibility bool
+
+	// Tracer, if not nil, is notified of the parse, split, and write phases
+	// of each compilation.
+	Tracer Tracer
+
+	// Concurrency is the maximum number of queries
+	// that [*CompileOptions.CompileAll] compiles at the same time.
+	// If Concurrency is zero or negative, [runtime.GOMAXPROCS] is used.
+	Concurrency int
+}
+
+// warn reports a warning diagnostic to opts.Warn.
+func (opts *CompileOptions) warn(span parser.Span, code string, format string, args ...any) {
+	if opts == nil || opts.Warn == nil {
+		return
+	}
+	opts.Warn(parser.Diagnostic{
+		Span:     span,
+		Severity: parser.SeverityWarning,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// SanitizeColumnName replaces each run of characters in name
+// other than ASCII letters, digits, and underscores with a single underscore.
+// If the result would start with a digit, it is prefixed with an underscore.
+// It is intended for use as [CompileOptions.ColumnName].
+func SanitizeColumnName(name string) string {
+	sb := new(strings.Builder)
+	sb.Grow(len(name))
+	replaced := false
+	for _, c := range name {
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			if sb.Len() == 0 && '0' <= c && c <= '9' {
+				sb.WriteString("_")
+			}
+			sb.WriteRune(c)
+			replaced = false
+		} else if !replaced {
+			sb.WriteString("_")
+			replaced = true
+		}
+	}
+	return sb.String()
+}
+
+// StringComparison is a strategy for comparing strings in SQL.
+// The strategy applies only to operands that are strings or of unknown type,
+// like columns of tables that are not in the schema.
+type StringComparison int
+
+const (
+	// LowerStringComparison compares strings exactly for ==, !=, and in
+	// and compares the lower() of both operands for =~ and !~.
+	LowerStringComparison StringComparison = iota
+	// CollationStringComparison writes =~ and !~ as plain = and <>,
+	// relying on the database's collation to determine case sensitivity.
+	CollationStringComparison
+	// ILikeStringComparison writes =~ and !~ as ILIKE and NOT ILIKE
+	// when the right operand is a string literal,
+	// escaping any wildcard characters in it.
+	// Other operands are compared like [LowerStringComparison].
+	ILikeStringComparison
+	// CaseInsensitiveStringComparison compares the lower() of both operands
+	// for every equality operator, including == and in.
+	CaseInsensitiveStringComparison
+)
+
+// SplitStrategy determines how aggressively the compiler combines
+// the operators in a pipeline into a single SELECT.
+// Fewer intermediate queries are easier for some query planners to optimize,
+// while more intermediate queries are easier for humans to read.
+type SplitStrategy int
+
+const (
+	// FusedSplit combines as many operators as possible into each SELECT:
+	// sort and take are attached to the preceding query,
+	// adjacent where operators share a single WHERE clause,
+	// and where operators are fused with adjacent project, extend,
+	// and summarize operators when column scoping permits.
+	FusedSplit SplitStrategy = iota
+	// RequiredSplit attaches sort and take to the preceding query,
+	// but otherwise starts a new intermediate query for each operator.
+	RequiredSplit
+	// AlwaysSplit starts a new intermediate query for every operator.
+	AlwaysSplit
+)
+
+// dialect returns opts.Dialect or the default if opts is nil.
+func (opts *CompileOptions) dialect() Dialect {
+	if opts == nil {
+		return ClickHouseDialect
+	}
+	return opts.Dialect
+}
+
+// split returns opts.Split or the default if opts is nil.
+func (opts *CompileOptions) split() SplitStrategy {
+	if opts == nil {
+		return FusedSplit
+	}
+	return opts.Split
+}
+
+// HashSubqueryName returns a name for an intermediate query
+// derived from a hash of its SQL.
+// It is intended for use as [CompileOptions.SubqueryName]
+// so that the names do not change when unrelated parts of a query change.
+func HashSubqueryName(index int, sql string) string {
+	h := sha256.Sum256([]byte(sql))
+	return subqueryPrefix + "_" + hex.EncodeToString(h[:8])
 }
 
 // Compile converts the given Pipeline Query Language statement
 // into the equivalent SQL.
+// Errors that refer to a location in source
+// can be retrieved with [errors.As] as a [parser.PositionedError].
+// [parser.Diagnostics] converts the returned error
+// into a list of structured diagnostics.
 func (opts *CompileOptions) Compile(source string) (string, error) {
-	stmts, err := parser.Parse(source)
+	return opts.compile(context.Background(), source, nil)
+}
+
+// CompileContext is like [*CompileOptions.Compile]
+// but passes the given context to the [Tracer]
+// and to the AnalysisContext's [TableProvider], if any.
+func (opts *CompileOptions) CompileContext(ctx context.Context, source string) (string, error) {
+	return opts.compile(ctx, source, nil)
+}
+
+// A Plan is the breakdown of the SQL for a query into stages.
+type Plan struct {
+	// Stages are the common table expressions in the order they are written,
+	// followed by the final query.
+	// If [CompileOptions.InlineSubqueries] is set,
+	// the common table expressions are written inside the final query instead.
+	Stages []*PlanStage
+	// SQL is the compiled query, the same as [*CompileOptions.Compile] returns.
+	SQL string
+
+	// source is the query that was compiled.
+	source string
+}
+
+// A PlanStage is one SELECT statement in the SQL for a query.
+type PlanStage struct {
+	// Name is the name of the common table expression,
+	// or the empty string for the final query.
+	Name string
+	// Operators are the spans of the tabular operators in the source
+	// that the stage computes, in the order they appear in the source.
+	// They include where operators that were combined with other operators
+	// and sort and take operators that were attached to the SELECT statement.
+	// A stage that only reads from a table has no operators.
+	Operators []parser.Span
+	// Sort and Take are the spans of the operators
+	// that became the stage's ORDER BY and LIMIT clauses.
+	// They are invalid if the stage has no such clause.
+	Sort parser.Span
+	Take parser.Span
+	// SQL is the stage's SELECT statement.
+	SQL string
+
+	// sqlSpan is the span of the stage's SELECT statement in [Plan.SQL].
+	// It is invalid if the statement could not be found.
+	sqlSpan parser.Span
+}
+
+// Explain compiles the given Pipeline Query Language statement like [*CompileOptions.Compile]
+// and returns how the query was divided into SQL statements.
+func (opts *CompileOptions) Explain(source string) (*Plan, error) {
+	plan := new(Plan)
+	sql, err := opts.compile(context.Background(), source, plan)
+	if err != nil {
+		return nil, err
+	}
+	plan.SQL = sql
+	plan.source = source
+	return plan, nil
+}
+
+var pooledParseOptions = &parser.ParseOptions{Pooled: true}
+
+// compile implements [*CompileOptions.Compile].
+// If plan is not nil, compile adds the query's stages to it.
+func (opts *CompileOptions) compile(traceCtx context.Context, source string, plan *Plan) (_ string, err error) {
+	if opts != nil && len(opts.Functions) > 0 {
+		return opts.compileWithFunctions(traceCtx, source, plan)
+	}
+	var tracer Tracer
+	if opts != nil {
+		tracer = opts.Tracer
+	}
+	trace := startCompileTrace(traceCtx, tracer)
+	defer func() { trace.end(err) }()
+
+	trace.phase(TracePhaseParse)
+	// The syntax tree does not outlive compilation,
+	// so its memory can be reused by the next query.
+	parsed := pooledParseOptions.ParseIncremental(source)
+	defer parsed.Release()
+	stmts, err := parsed.Statements(), parsed.Err()
 	if err != nil {
 		return "", err
 	}
+	if opts != nil && opts.KQLCompatibility {
+		opts.warnKQLDivergences(source, stmts)
+	}
+	trace.phase(TracePhaseSplit)
 	var expr *parser.TabularExpr
+	var tabularLets []*parser.LetStatement
 	scope := make(map[string]string)
+	consts := make(map[string]string)
+	ints := make(map[string]int64)
+	format := DefaultSQLFormat
+	if opts != nil {
+		format = opts.Format
+	}
 	if opts != nil {
 		for k, v := range opts.Parameters {
 			scope[k] = v
@@ -51,6 +398,7 @@ func (opts *CompileOptions) Compile(source string) (string, error) {
 					source: source,
 					span:   stmt.Span(),
 					err:    fmt.Errorf("batch queries not supported"),
+					code:   CodeUnsupported,
 				}
 			}
 			expr = stmt
@@ -60,94 +408,359 @@ func (opts *CompileOptions) Compile(source string) (string, error) {
 				// they should not be in scope.
 				continue
 			}
-			ctx := &exprContext{
-				source: source,
-				scope:  scope,
-				mode:   letExprMode,
+			if stmt.Tabular != nil {
+				tabularLets = append(tabularLets, stmt)
+				continue
 			}
+			ctx := opts.exprContext(source, scope, letExprMode)
 			sb := new(strings.Builder)
 			if err := writeExpressionMaybeParen(ctx, sb, stmt.X); err != nil {
 				return "", err
 			}
 			scope[stmt.Name.Name] = sb.String()
+			if v, ok := constantString(stmt.X, consts); ok {
+				consts[stmt.Name.Name] = v
+			} else {
+				delete(consts, stmt.Name.Name)
+			}
+			if v, ok := constantInt(stmt.X, ints); ok {
+				ints[stmt.Name.Name] = v
+			} else {
+				delete(ints, stmt.Name.Name)
+			}
 		default:
 			return "", &compileError{
 				source: source,
 				span:   stmt.Span(),
 				err:    fmt.Errorf("unhandled %T statement", stmt),
+				code:   CodeUnsupported,
 			}
 		}
 	}
 	if expr == nil {
 		return "", fmt.Errorf("missing tabular queries")
 	}
+	var nonStringSorts map[*parser.SortTerm]bool
+	var nonStringColumns map[*parser.QualifiedIdent]bool
+	var projectAwayColumns map[*parser.ProjectAwayOperator][]string
+	if opts != nil && opts.AnalysisContext != nil {
+		// Filtering before a join requires knowing the columns on each side.
+		r := &columnResolver{
+			ac:          opts.AnalysisContext,
+			ctx:         trace.ctx,
+			source:      source,
+			tabularLets: tabularLets,
+		}
+		for i, stmt := range tabularLets {
+			r.visibleTabularLets = i
+			newStmt := *stmt
+			newStmt.Tabular = r.pushDownPredicates(stmt.Tabular, scope)
+			tabularLets[i] = &newStmt
+		}
+		r.visibleTabularLets = len(tabularLets)
+		expr = r.pushDownPredicates(expr, scope)
+
+		if opts.CaseInsensitiveSort || opts.SortCollation != "" {
+			nonStringSorts = make(map[*parser.SortTerm]bool)
+			for i, stmt := range tabularLets {
+				r.visibleTabularLets = i
+				r.findNonStringSorts(nonStringSorts, stmt.Tabular)
+			}
+			r.visibleTabularLets = len(tabularLets)
+			r.findNonStringSorts(nonStringSorts, expr)
+		}
+
+		if opts.StringComparison == CaseInsensitiveStringComparison {
+			nonStringColumns = make(map[*parser.QualifiedIdent]bool)
+			for i, stmt := range tabularLets {
+				r.visibleTabularLets = i
+				r.findNonStringComparisons(nonStringColumns, stmt.Tabular)
+			}
+			r.visibleTabularLets = len(tabularLets)
+			r.findNonStringComparisons(nonStringColumns, expr)
+		}
+
+		if opts.Dialect == PostgresDialect {
+			// PostgreSQL cannot exclude columns from SELECT *.
+			projectAwayColumns = make(map[*parser.ProjectAwayOperator][]string)
+			for i, stmt := range tabularLets {
+				r.visibleTabularLets = i
+				r.findProjectAwayColumns(projectAwayColumns, stmt.Tabular)
+			}
+			r.visibleTabularLets = len(tabularLets)
+			r.findProjectAwayColumns(projectAwayColumns, expr)
+		}
+	}
 
-	subqueries, err := splitQueries(nil, source, expr)
+	tables := make(sourceTables)
+	for _, stmt := range tabularLets {
+		if err := opts.resolveTables(trace.ctx, tables, source, consts, stmt.Tabular); err != nil {
+			return "", err
+		}
+	}
+	if err := opts.resolveTables(trace.ctx, tables, source, consts, expr); err != nil {
+		return "", err
+	}
+
+	// Tabular let statements become named subqueries
+	// so that table references in later statements will read from them.
+	var subqueries []*subquery
+	names := make(map[string]*subquery)
+	for _, stmt := range tabularLets {
+		subqueries, err = splitQueries(subqueries, source, opts, tables, names, stmt.Tabular)
+		if err != nil {
+			return "", err
+		}
+		subqueries[len(subqueries)-1].name = stmt.Name.Name
+		names[stmt.Name.Name] = subqueries[len(subqueries)-1]
+	}
+	subqueries, err = splitQueries(subqueries, source, opts, tables, names, expr)
 	if err != nil {
 		return "", err
 	}
 
+	trace.phase(TracePhaseWrite)
 	sb := new(strings.Builder)
 	ctes := subqueries[:len(subqueries)-1]
 	query := subqueries[len(subqueries)-1]
-	ctx := &exprContext{
-		source: source,
-		scope:  scope,
-	}
+	ctx := opts.exprContext(source, scope, defaultExprMode)
+	ctx.ints = ints
+	ctx.nonStringSorts = nonStringSorts
+	ctx.nonStringColumns = nonStringColumns
+	ctx.projectAwayColumns = projectAwayColumns
+	// The bodies of the common table expressions share one builder
+	// instead of growing a builder apiece.
+	// Each body is a substring of the builder's contents,
+	// which stay valid as the builder grows.
+	bodies := new(strings.Builder)
 	if len(ctes) > 0 {
+		bodies.Grow(estimatedSQLLen(source))
+	}
+	writeBody := func(sub *subquery) (string, error) {
+		start := bodies.Len()
+		if err := sub.write(ctx, bodies); err != nil {
+			return "", err
+		}
+		return bodies.String()[start:], nil
+	}
+	if opts != nil && opts.InlineSubqueries {
+		for _, sub := range ctes {
+			body, err := writeBody(sub)
+			if err != nil {
+				return "", err
+			}
+			sub.inlineSQL = body
+			plan.addStage(sub.name, sub, body, format)
+		}
+		sb.Grow(estimatedSQLLen(source) + bodies.Len())
+		if err := query.write(ctx, sb); err != nil {
+			return "", err
+		}
+		plan.addStage("", query, sb.String(), format)
+		var stageSpans []parser.Span
+		if plan != nil {
+			// Each inlined body is written in the final query where it is read.
+			for _, sub := range ctes {
+				span := parser.Span{Start: -1, End: -1}
+				if i := strings.Index(sb.String(), sub.inlineSQL); i >= 0 {
+					span = parser.Span{Start: i, End: i + len(sub.inlineSQL)}
+				}
+				stageSpans = append(stageSpans, span)
+			}
+			stageSpans = append(stageSpans, parser.Span{Start: 0, End: sb.Len()})
+		}
+		sb.WriteString(";")
+		sql := formatSQL(sb.String(), format)
+		plan.setSQLSpans(sb.String(), sql, stageSpans)
+		return sql, nil
+	}
+
+	// Identical generated subqueries are written once.
+	// Subqueries named by let statements are always written
+	// because other queries refer to them by name.
+	var uniqueBodies []string
+	var uniqueCTEs []*subquery
+	bodyIndex := make(map[string]int, len(ctes))
+	usedNames := make(map[string]bool, len(ctes)+len(tabularLets))
+	for _, stmt := range tabularLets {
+		usedNames[stmt.Name.Name] = true
+	}
+	withLen := 0
+	for _, sub := range ctes {
+		body, err := writeBody(sub)
+		if err != nil {
+			return "", err
+		}
+		if i, ok := bodyIndex[body]; ok && strings.HasPrefix(sub.name, subqueryPrefix) {
+			sub.duplicateOf = uniqueCTEs[i]
+			uniqueCTEs[i].operators = append(uniqueCTEs[i].operators, sub.operators...)
+			continue
+		}
+		if opts != nil && opts.SubqueryName != nil && strings.HasPrefix(sub.name, subqueryPrefix) {
+			sub.name = opts.SubqueryName(len(uniqueCTEs), body)
+			if usedNames[sub.name] {
+				return "", fmt.Errorf("subquery name %q is already in use", sub.name)
+			}
+		}
+		usedNames[sub.name] = true
+		bodyIndex[body] = len(uniqueCTEs)
+		uniqueCTEs = append(uniqueCTEs, sub)
+		uniqueBodies = append(uniqueBodies, body)
+		withLen += len(sub.name) + len(body) + len(`"" AS (),`+"\n     ")
+	}
+	for i, sub := range uniqueCTEs {
+		plan.addStage(sub.name, sub, uniqueBodies[i], format)
+	}
+	// The final query usually reads from the common table expressions
+	// instead of repeating them, so it is a fraction of the source's length.
+	sb.Grow(len("WITH ") + withLen + estimatedSQLLen(source)/len(subqueries))
+	var stageSpans []parser.Span
+	if len(uniqueCTEs) > 0 {
 		sb.WriteString("WITH ")
-		for i, sub := range ctes {
+		for i, sub := range uniqueCTEs {
 			quoteIdentifier(sb, sub.name)
 			sb.WriteString(" AS (")
-			if err := sub.write(ctx, sb); err != nil {
-				return "", err
+			if plan != nil {
+				stageSpans = append(stageSpans, parser.Span{Start: sb.Len(), End: sb.Len() + len(uniqueBodies[i])})
 			}
+			sb.WriteString(uniqueBodies[i])
 			sb.WriteString(")")
-			if i < len(ctes)-1 {
+			if i < len(uniqueCTEs)-1 {
 				sb.WriteString(",\n     ")
 			} else {
 				sb.WriteString("\n")
 			}
 		}
 	}
+	queryStart := sb.Len()
 	if err := query.write(ctx, sb); err != nil {
 		return "", err
 	}
+	plan.addStage("", query, sb.String()[queryStart:], format)
+	if plan != nil {
+		stageSpans = append(stageSpans, parser.Span{Start: queryStart, End: sb.Len()})
+	}
 	sb.WriteString(";")
-	return sb.String(), nil
+	sql := formatSQL(sb.String(), format)
+	plan.setSQLSpans(sb.String(), sql, stageSpans)
+	return sql, nil
 }
 
-type subquery struct {
-	name      string
-	sourceSQL string
+// estimatedSQLLen returns a guess at the length of the SQL compiled from source
+// for sizing buffers.
+// Compiled queries are about twice as long as their source on average,
+// since SQL spells out clauses that pql leaves implicit.
+func estimatedSQLLen(source string) int {
+	return 2 * len(source)
+}
+
+// addStage adds a stage with the given name for sub to the plan.
+// addStage does nothing if plan is nil.
+func (plan *Plan) addStage(name string, sub *subquery, sql string, format SQLFormat) {
+	if plan == nil {
+		return
+	}
+	stage := &PlanStage{
+		Name:      name,
+		Operators: slices.Clone(sub.operators),
+		Sort:      sub.sort.Span(),
+		Take:      sub.take.Span(),
+		SQL:       formatSQL(sql, format),
+	}
+	slices.SortFunc(stage.Operators, func(a, b parser.Span) int {
+		return a.Start - b.Start
+	})
+	plan.Stages = append(plan.Stages, stage)
+}
+
+// setSQLSpans records where each stage's SELECT statement is in sql,
+// given the spans of the statements in raw, the SQL before formatting.
+// setSQLSpans does nothing if plan is nil.
+func (plan *Plan) setSQLSpans(raw, sql string, spans []parser.Span) {
+	if plan == nil {
+		return
+	}
+	if raw != sql {
+		spans = formattedSpans(raw, sql, spans)
+	}
+	for i, stage := range plan.Stages {
+		stage.sqlSpan = spans[i]
+	}
+}
 
-	op   parser.TabularOperator
-	sort *parser.SortOperator
-	take *parser.TakeOperator
+type subquery struct {
+	name string
+	// source is the SQL that the subquery reads from.
+	source sqlSource
+	// duplicateOf is an earlier subquery with the same SQL, if any.
+	// References to the subquery are written as references to duplicateOf.
+	duplicateOf *subquery
+	// inlineSQL is the SQL of the subquery if references to it
+	// are replaced by the subquery itself instead of its name.
+	inlineSQL string
+
+	op parser.TabularOperator
+	// filter is an additional predicate for the WHERE clause
+	// of a project, extend, or summarize subquery.
+	// It is the result of fusing a where operator with an adjacent operator.
+	filter parser.Expr
+	sort   *parser.SortOperator
+	take   *parser.TakeOperator
+
+	// operators are the spans of the operators in the source
+	// that the subquery computes.
+	operators []parser.Span
 }
 
 // splitQueries appends queries to dst that represent the given tabular expression.
 // The last element of the returned slice will be the query that represents the full expression.
-func splitQueries(dst []*subquery, source string, expr *parser.TabularExpr) ([]*subquery, error) {
+// names maps the names of tabular let statements and as operators in scope
+// to their subqueries.
+func splitQueries(dst []*subquery, source string, opts *CompileOptions, tables sourceTables, names map[string]*subquery, expr *parser.TabularExpr) ([]*subquery, error) {
 	dstStart := len(dst)
+	if paren, ok := expr.Source.(*parser.ParenTabularExpr); ok {
+		// The parenthesized expression's subqueries precede the outer expression's,
+		// so the outer expression reads from the last of them.
+		var err error
+		dst, err = splitQueries(dst, source, opts, tables, names, paren.X)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if union, ok := expr.Source.(*parser.UnionSource); ok {
+		// Like a parenthesized expression,
+		// the outer expression reads from the union's subquery.
+		var inputs []unionInput
+		for i, arg := range union.Tables {
+			var err error
+			inputs, dst, err = appendUnionInputs(inputs, dst, source, opts, tables, names, arg, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dst = append(dst, unionSubquery(dst, inputs, union.SourceColumn))
+	}
+	fuse := opts.split() == FusedSplit
+	attach := opts.split() != AlwaysSplit
 	var lastSubquery *subquery
 	for i := 0; i < len(expr.Operators); i++ {
 		switch op := expr.Operators[i].(type) {
 		case *parser.AsOperator:
 			var err error
-			lastSubquery, err = chainSubquery(dst, dstStart, expr.Source)
+			lastSubquery, err = chainSubquery(dst, dstStart, tables, names, expr.Source)
 			if err != nil {
 				return nil, err
 			}
 			lastSubquery.name = op.Name.Name
+			names[op.Name.Name] = lastSubquery
 			// AsOperator gets treated basically the same as nil,
 			// but won't permit anything to be attached.
 			lastSubquery.op = op
 			dst = append(dst, lastSubquery)
 		case *parser.SortOperator:
-			if lastSubquery == nil || !canAttachSort(lastSubquery.op) || lastSubquery.sort != nil || lastSubquery.take != nil {
+			if !attach || lastSubquery == nil || !canAttachSort(lastSubquery.op) || lastSubquery.sort != nil || lastSubquery.take != nil {
 				var err error
-				lastSubquery, err = chainSubquery(dst, dstStart, expr.Source)
+				lastSubquery, err = chainSubquery(dst, dstStart, tables, names, expr.Source)
 				if err != nil {
 					return nil, err
 				}
@@ -155,9 +768,9 @@ func splitQueries(dst []*subquery, source string, expr *parser.TabularExpr) ([]*
 			}
 			lastSubquery.sort = op
 		case *parser.TakeOperator:
-			if lastSubquery == nil || !canAttachSort(lastSubquery.op) || lastSubquery.take != nil {
+			if !attach || lastSubquery == nil || !canAttachTake(lastSubquery.op) || lastSubquery.take != nil {
 				var err error
-				lastSubquery, err = chainSubquery(dst, dstStart, expr.Source)
+				lastSubquery, err = chainSubquery(dst, dstStart, tables, names, expr.Source)
 				if err != nil {
 					return nil, err
 				}
@@ -165,9 +778,9 @@ func splitQueries(dst []*subquery, source string, expr *parser.TabularExpr) ([]*
 			}
 			lastSubquery.take = op
 		case *parser.TopOperator:
-			if lastSubquery == nil || !canAttachSort(lastSubquery.op) || lastSubquery.sort != nil || lastSubquery.take != nil {
+			if !attach || lastSubquery == nil || !canAttachSort(lastSubquery.op) || lastSubquery.sort != nil || lastSubquery.take != nil {
 				var err error
-				lastSubquery, err = chainSubquery(dst, dstStart, expr.Source)
+				lastSubquery, err = chainSubquery(dst, dstStart, tables, names, expr.Source)
 				if err != nil {
 					return nil, err
 				}
@@ -187,76 +800,197 @@ func splitQueries(dst []*subquery, source string, expr *parser.TabularExpr) ([]*
 			leftSubquery := len(dst) - 1
 
 			var err error
-			dst, err = splitQueries(dst, source, op.Right)
+			dst, err = splitQueries(dst, source, opts, tables, names, op.Right)
 			if err != nil {
 				return nil, err
 			}
 			lastSubquery = dst[len(dst)-1]
 
-			flavorName := "innerunique"
-			if op.Flavor != nil {
-				flavorName = op.Flavor.Name
+			flavorName := joinFlavorName(op)
+			for _, hint := range op.Hints {
+				// Hints only affect how the join is executed,
+				// so ignoring them does not change the result.
+				opts.warn(hint.Span(), CodeIgnoredHint, "join hint %q ignored", "hint."+hint.Name.Name)
 			}
 
-			joinSource := new(strings.Builder)
-			if flavorName == "innerunique" {
-				joinSource.WriteString("(SELECT DISTINCT * FROM ")
-			}
+			// Inside the DISTINCT query, an inlined left side needs its own alias.
+			alias := flavorName == "innerunique"
+			var leftSource sqlSource
 			if leftSubquery >= dstStart {
-				quoteIdentifier(joinSource, dst[leftSubquery].name)
-			} else {
-				if err := dataSourceSQL(joinSource, expr.Source); err != nil {
+				leftSource.writeSubquery(dst[leftSubquery], alias)
+			} else if err := leftSource.writeDataSource(tables, names, expr.Source, alias); err != nil {
+				return nil, err
+			}
+			var rightSource sqlSource
+			rightSource.writeSubquery(lastSubquery, false)
+
+			joinCtx := opts.exprContext(source, nil, joinExprMode)
+			var joinSource sqlSource
+			switch flavorName {
+			case "leftsemi", "leftanti", "rightsemi", "rightanti":
+				joinSource, err = semiJoinSource(joinCtx, op, flavorName, leftSource, rightSource)
+				if err != nil {
 					return nil, err
 				}
+				lastSubquery = &subquery{
+					name:      subqueryName(len(dst)),
+					source:    joinSource,
+					operators: []parser.Span{op.Span()},
+				}
+				dst = append(dst, lastSubquery)
+				continue
 			}
+
 			if flavorName == "innerunique" {
+				joinSource.WriteString("(SELECT DISTINCT * FROM ")
+				joinSource.writeSource(leftSource)
 				joinSource.WriteString(")")
+			} else {
+				joinSource.writeSource(leftSource)
 			}
 			joinSource.WriteString(` AS "` + leftJoinTableAlias + `"`)
 
+			var on, filter parser.Expr
 			switch flavorName {
 			case "inner", "innerunique":
+				on, filter = splitJoinCondition(op.Conditions)
+			case "cross":
+				for _, c := range op.Conditions {
+					filter = andExpr(filter, rewriteSimpleJoinCondition(c))
+				}
+			default:
+				// ClickHouse requires the ON clause of an outer join
+				// to compare the two tables for equality.
+				if len(op.Conditions) > 0 && !hasJoinEquality(op.Conditions) && opts.dialect() == ClickHouseDialect {
+					return nil, &compileError{
+						source: source,
+						span: parser.Span{
+							Start: op.On.Start,
+							End:   op.Conditions[len(op.Conditions)-1].Span().End,
+						},
+						err:  fmt.Errorf("%s join without an equality condition between the tables is not supported in %v", flavorName, opts.dialect()),
+						code: CodeUnsupported,
+					}
+				}
+				on = buildJoinCondition(op.Conditions)
+			}
+			switch {
+			case on == nil:
+				joinSource.WriteString(" CROSS JOIN ")
+			case flavorName == "inner", flavorName == "innerunique":
 				joinSource.WriteString(" JOIN ")
-			case "leftouter":
+			case flavorName == "leftouter":
 				joinSource.WriteString(" LEFT JOIN ")
+			case flavorName == "rightouter":
+				joinSource.WriteString(" RIGHT JOIN ")
+			case flavorName == "fullouter":
+				joinSource.WriteString(" FULL OUTER JOIN ")
 			default:
 				return nil, &compileError{
 					source: source,
 					span:   op.Flavor.Span(),
 					err:    fmt.Errorf("unhandled join type %q", flavorName),
+					code:   CodeUnsupported,
 				}
 			}
-			quoteIdentifier(joinSource, lastSubquery.name)
+			joinSource.writeSource(rightSource)
+			joinSource.WriteString(` AS "` + rightJoinTableAlias + `"`)
 
-			joinSource.WriteString(` AS "` + rightJoinTableAlias + `" ON `)
-			joinCtx := &exprContext{
-				source: source,
-				mode:   joinExprMode,
+			if on != nil {
+				cond := new(strings.Builder)
+				if err := writeExpression(joinCtx, cond, on); err != nil {
+					return nil, err
+				}
+				joinSource.WriteString(" ON " + cond.String())
 			}
-			if err := writeExpression(joinCtx, joinSource, buildJoinCondition(op.Conditions)); err != nil {
-				return nil, err
+			if filter != nil {
+				cond := new(strings.Builder)
+				if err := writeExpression(joinCtx, cond, filter); err != nil {
+					return nil, err
+				}
+				joinSource.WriteString(" WHERE " + cond.String())
 			}
 
 			lastSubquery = &subquery{
-				name:      subqueryName(len(dst)),
-				sourceSQL: joinSource.String(),
+				name:   subqueryName(len(dst)),
+				source: joinSource,
+			}
+			dst = append(dst, lastSubquery)
+		case *parser.UnionOperator:
+			// The operator's input is always named like an argument
+			// that is not a table, even if it is a table reference.
+			left := unionInput{name: unionArgName(0)}
+			if len(dst) > dstStart {
+				left.source.writeSubquery(dst[len(dst)-1], true)
+			} else if err := left.source.writeDataSource(tables, names, expr.Source, true); err != nil {
+				return nil, err
+			}
+			inputs := []unionInput{left}
+			for i, arg := range op.Tables {
+				var err error
+				inputs, dst, err = appendUnionInputs(inputs, dst, source, opts, tables, names, arg, i+1)
+				if err != nil {
+					return nil, err
+				}
+			}
+			lastSubquery = unionSubquery(dst, inputs, op.SourceColumn)
+			dst = append(dst, lastSubquery)
+		case *parser.WhereOperator:
+			if fuse && canMergeWhere(dst, lastSubquery) {
+				// Adjacent filters are combined into a single WHERE clause
+				// instead of a subquery per filter.
+				prev := lastSubquery.op.(*parser.WhereOperator)
+				lastSubquery.op = &parser.WhereOperator{
+					Pipe:      prev.Pipe,
+					Keyword:   prev.Keyword,
+					Predicate: andExpr(prev.Predicate, op.Predicate),
+				}
+				break
 			}
+			if fuse && canFilterAfter(dst, lastSubquery, opts.dialect()) {
+				// Columns computed by extend can be referred to in the WHERE clause
+				// of the same SELECT.
+				lastSubquery.filter = andExpr(lastSubquery.filter, op.Predicate)
+				break
+			}
+			var err error
+			lastSubquery, err = chainSubquery(dst, dstStart, tables, names, expr.Source)
+			if err != nil {
+				return nil, err
+			}
+			lastSubquery.op = op
+			dst = append(dst, lastSubquery)
+		case *parser.ProjectOperator, *parser.ProjectAwayOperator, *parser.ExtendOperator, *parser.SummarizeOperator, *parser.DistinctOperator:
+			if fuse && canFilterBefore(dst, lastSubquery, opts.exprContext(source, nil, defaultExprMode), op) {
+				// Apply the preceding where operator's predicate
+				// in the same SELECT as op.
+				lastSubquery.filter = lastSubquery.op.(*parser.WhereOperator).Predicate
+				lastSubquery.op = op
+				break
+			}
+			var err error
+			lastSubquery, err = chainSubquery(dst, dstStart, tables, names, expr.Source)
+			if err != nil {
+				return nil, err
+			}
+			lastSubquery.op = op
 			dst = append(dst, lastSubquery)
 		default:
 			var err error
-			lastSubquery, err = chainSubquery(dst, dstStart, expr.Source)
+			lastSubquery, err = chainSubquery(dst, dstStart, tables, names, expr.Source)
 			if err != nil {
 				return nil, err
 			}
 			lastSubquery.op = op
 			dst = append(dst, lastSubquery)
 		}
+		lastSubquery.operators = append(lastSubquery.operators, expr.Operators[i].Span())
 	}
 
 	if len(dst) == dstStart {
 		// Ensure that we add at least one subquery.
 		var err error
-		lastSubquery, err = chainSubquery(dst, dstStart, expr.Source)
+		lastSubquery, err = chainSubquery(dst, dstStart, tables, names, expr.Source)
 		if err != nil {
 			return nil, err
 		}
@@ -266,89 +1000,592 @@ func splitQueries(dst []*subquery, source string, expr *parser.TabularExpr) ([]*
 	return dst, nil
 }
 
-// chainSubquery returns a new subquery
-// that either reads from the previous subquery
-// or from the data source if there is no previous subquery.
-func chainSubquery(dst []*subquery, dstStart int, src parser.TabularDataSource) (*subquery, error) {
-	sub := &subquery{
-		name: subqueryName(len(dst)),
-	}
-	sb := new(strings.Builder)
-	if len(dst) > dstStart {
-		quoteIdentifier(sb, dst[len(dst)-1].name)
-	} else {
-		if err := dataSourceSQL(sb, src); err != nil {
-			return nil, err
-		}
+// canMergeWhere reports whether a where operator that follows lastSubquery
+// can be combined with lastSubquery's filter.
+// Filters cannot be combined across a take,
+// because the filter must apply to the rows that remain after the take.
+func canMergeWhere(dst []*subquery, lastSubquery *subquery) bool {
+	if lastSubquery == nil || len(dst) == 0 || dst[len(dst)-1] != lastSubquery {
+		return false
 	}
-	sub.sourceSQL = sb.String()
-	return sub, nil
-}
-
-func subqueryName(i int) string {
-	return fmt.Sprintf("__subquery%d", i)
+	_, isWhere := lastSubquery.op.(*parser.WhereOperator)
+	return isWhere && lastSubquery.sort == nil && lastSubquery.take == nil
 }
 
-// canAttachSort reports whether the given operator's subquery can have a sort clause attached.
-// This becomes significant for operators like "project"
-// because they change the identifiers in scope.
-func canAttachSort(op parser.TabularOperator) bool {
-	switch op.(type) {
-	case *parser.ProjectOperator, *parser.SummarizeOperator, *parser.AsOperator:
+// canFilterAfter reports whether a where operator that follows lastSubquery
+// can be fused into lastSubquery's WHERE clause.
+// This is only the case for extend,
+// since the predicate may refer to the columns that extend computes.
+// Only ClickHouse resolves names in the WHERE clause
+// to the column aliases of the same SELECT,
+// so other dialects always filter in a separate subquery.
+func canFilterAfter(dst []*subquery, lastSubquery *subquery, dialect Dialect) bool {
+	if dialect != ClickHouseDialect {
 		return false
-	case *parser.RenderOperator:
+	}
+	if lastSubquery == nil || len(dst) == 0 || dst[len(dst)-1] != lastSubquery {
 		return false
-	default:
-		return true
 	}
+	_, isExtend := lastSubquery.op.(*parser.ExtendOperator)
+	return isExtend && lastSubquery.sort == nil && lastSubquery.take == nil
 }
 
-const (
-	leftJoinTableAlias  = "$left"
-	rightJoinTableAlias = "$right"
-)
+// canFilterBefore reports whether op can be fused
+// with the where operator in lastSubquery.
+// Column aliases are visible in the WHERE clause,
+// so op must not compute any column that the predicate refers to.
+func canFilterBefore(dst []*subquery, lastSubquery *subquery, ctx *exprContext, op parser.TabularOperator) bool {
+	if !canMergeWhere(dst, lastSubquery) {
+		return false
+	}
+	pred := lastSubquery.op.(*parser.WhereOperator).Predicate
+	return !referencesColumns(pred, computedColumns(ctx, op))
+}
 
-func buildJoinCondition(conds []parser.Expr) parser.Expr {
-	if len(conds) == 0 {
-		return (&parser.Ident{Name: "true"}).AsQualified()
+// computedColumns returns the names of the columns that op computes,
+// excluding columns that are the unmodified input column of the same name.
+func computedColumns(ctx *exprContext, op parser.TabularOperator) map[string]struct{} {
+	names := make(map[string]struct{})
+	add := func(name *parser.Ident, x parser.Expr) {
+		if x == nil {
+			return
+		}
+		var n string
+		if name != nil {
+			n = name.Name
+		} else {
+			n = ctx.derivedColumnName(x)
+		}
+		if id, ok := x.(*parser.QualifiedIdent); ok && len(id.Parts) == 1 && id.Parts[0].Name == n {
+			return
+		}
+		names[n] = struct{}{}
 	}
-	x := rewriteSimpleJoinCondition(conds[0])
-	for _, y := range conds[1:] {
-		x = &parser.BinaryExpr{
-			X:  x,
-			Op: parser.TokenAnd,
-			Y:  rewriteSimpleJoinCondition(y),
+	switch op := op.(type) {
+	case *parser.ProjectOperator:
+		for _, col := range op.Cols {
+			add(col.Name, col.X)
+		}
+	case *parser.ExtendOperator:
+		for _, col := range op.Cols {
+			add(col.Name, col.X)
+		}
+	case *parser.SummarizeOperator:
+		for _, col := range op.Cols {
+			add(col.Name, col.X)
+		}
+		for _, col := range op.GroupBy {
+			add(col.Name, col.X)
 		}
 	}
-	return x
+	return names
 }
 
-func rewriteSimpleJoinCondition(c parser.Expr) parser.Expr {
-	id, ok := c.(*parser.QualifiedIdent)
-	if !ok || len(id.Parts) != 1 || id.Parts[0].Quoted || builtinIdentifiers[id.Parts[0].Name] != "" {
-		return c
+// referencesColumns reports whether x refers to any of the named columns.
+func referencesColumns(x parser.Expr, names map[string]struct{}) bool {
+	found := false
+	parser.Walk(x, func(n parser.Node) bool {
+		if id, ok := n.(*parser.QualifiedIdent); ok {
+			if _, ok := names[id.Parts[0].Name]; ok {
+				found = true
+			}
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// andExpr returns the conjunction of x and y.
+// If x is nil, andExpr returns y.
+func andExpr(x, y parser.Expr) parser.Expr {
+	if x == nil {
+		return y
 	}
 	return &parser.BinaryExpr{
-		X: &parser.QualifiedIdent{
-			Parts: []*parser.Ident{
-				{Name: leftJoinTableAlias},
-				id.Parts[0],
-			},
-		},
-		Op: parser.TokenEq,
-		Y: &parser.QualifiedIdent{
-			Parts: []*parser.Ident{
-				{Name: rightJoinTableAlias},
-				id.Parts[0],
-			},
-		},
+		X:      x,
+		OpSpan: parser.Span{Start: -1, End: -1},
+		Op:     parser.TokenAnd,
+		Y:      y,
 	}
 }
 
-func hasJoinTerms(x parser.Expr) (left, right bool) {
-	parser.Walk(x, func(n parser.Node) bool {
-		if n, ok := n.(*parser.Ident); ok {
-			switch n.Name {
+// chainSubquery returns a new subquery
+// that either reads from the previous subquery
+// or from the data source if there is no previous subquery.
+func chainSubquery(dst []*subquery, dstStart int, tables sourceTables, names map[string]*subquery, src parser.TabularDataSource) (*subquery, error) {
+	sub := &subquery{
+		name: subqueryName(len(dst)),
+	}
+	if len(dst) > dstStart {
+		sub.source.writeSubquery(dst[len(dst)-1], true)
+		return sub, nil
+	}
+	if err := sub.source.writeDataSource(tables, names, src, true); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// A unionInput is one of the row sets combined by a union.
+type unionInput struct {
+	// name is the value of the union's source column
+	// for the rows of the input.
+	name   string
+	source sqlSource
+}
+
+// unionArgName returns the source column value
+// for the rows of a union argument that is not a table.
+func unionArgName(i int) string {
+	return fmt.Sprintf("union_arg%d", i)
+}
+
+// appendUnionInputs appends the inputs for the i'th argument of a union to inputs.
+// Table wildcards and table() calls produce an input for each table they match.
+// The subqueries of a parenthesized argument are appended to dst.
+func appendUnionInputs(inputs []unionInput, dst []*subquery, source string, opts *CompileOptions, tables sourceTables, names map[string]*subquery, arg parser.TabularDataSource, i int) ([]unionInput, []*subquery, error) {
+	switch arg := arg.(type) {
+	case *parser.TableRef:
+		var in unionInput
+		in.name = arg.Table.Name
+		if err := in.source.writeDataSource(tables, names, arg, true); err != nil {
+			return nil, nil, err
+		}
+		return append(inputs, in), dst, nil
+	case *parser.TableWildcard, *parser.TableCall:
+		matched := tables[arg]
+		if matched == nil {
+			return nil, nil, fmt.Errorf("unresolved data source %T", arg)
+		}
+		for _, name := range matched.names {
+			sb := new(strings.Builder)
+			matched.writeTable(sb, name)
+			var in unionInput
+			in.name = name
+			in.source.WriteString(sb.String())
+			inputs = append(inputs, in)
+		}
+		return inputs, dst, nil
+	default:
+		var err error
+		dst, err = splitQueries(dst, source, opts, tables, names, &parser.TabularExpr{Source: arg})
+		if err != nil {
+			return nil, nil, err
+		}
+		var in unionInput
+		in.name = unionArgName(i)
+		in.source.writeSubquery(dst[len(dst)-1], true)
+		return append(inputs, in), dst, nil
+	}
+}
+
+// unionSubquery returns a new subquery that reads
+// the rows of all the inputs.
+// If column is not nil, the subquery adds a column with that name
+// holding the name of each row's input.
+// Rows are combined by position,
+// so the inputs must have the same columns in the same order.
+// The combined rows are a derived table,
+// which is always given an alias because some databases require one.
+func unionSubquery(dst []*subquery, inputs []unionInput, column *parser.Ident) *subquery {
+	sub := &subquery{
+		name: subqueryName(len(dst)),
+	}
+	sub.source.WriteString("(")
+	for i, in := range inputs {
+		sb := new(strings.Builder)
+		if i > 0 {
+			sb.WriteString(" UNION ALL ")
+		}
+		sb.WriteString("SELECT ")
+		if column != nil {
+			quoteSQLString(sb, in.name)
+			sb.WriteString(" AS ")
+			quoteIdentifier(sb, column.Name)
+			sb.WriteString(", ")
+		}
+		sb.WriteString("* FROM ")
+		sub.source.WriteString(sb.String())
+		sub.source.writeSource(in.source)
+	}
+	sub.source.WriteString(`) AS "` + unionAlias + `"`)
+	return sub
+}
+
+// A sqlSource is the SQL that a subquery reads from.
+// References to other subqueries are kept separate from the rest of the SQL
+// so that they can be redirected when duplicate subqueries are removed
+// or replaced by the subquery's SQL.
+type sqlSource struct {
+	parts []sqlSourcePart
+}
+
+// sqlSourcePart is either a literal piece of SQL or a reference to a subquery.
+type sqlSourcePart struct {
+	sql string
+	sub *subquery
+	// alias is true if an inlined subquery should be given its name as an alias.
+	alias bool
+}
+
+// WriteString appends literal SQL to the source.
+func (src *sqlSource) WriteString(s string) {
+	src.parts = append(src.parts, sqlSourcePart{sql: s})
+}
+
+// writeSubquery appends a reference to sub to the source.
+// If alias is true and the subquery is inlined,
+// the subquery is followed by its name as a table alias.
+func (src *sqlSource) writeSubquery(sub *subquery, alias bool) {
+	src.parts = append(src.parts, sqlSourcePart{sub: sub, alias: alias})
+}
+
+// writeSource appends the contents of other to the source.
+func (src *sqlSource) writeSource(other sqlSource) {
+	src.parts = append(src.parts, other.parts...)
+}
+
+// writeDataSource appends the given data source to the source.
+// Tables named by a tabular let statement or as operator
+// are written as references to the corresponding subquery.
+func (src *sqlSource) writeDataSource(tables sourceTables, names map[string]*subquery, ds parser.TabularDataSource, alias bool) error {
+	if ref, ok := ds.(*parser.TableRef); ok && ref.Database == nil && ref.Cluster == nil {
+		if sub := names[ref.Table.Name]; sub != nil {
+			src.writeSubquery(sub, alias)
+			return nil
+		}
+	}
+	sb := new(strings.Builder)
+	if err := dataSourceSQL(sb, tables, ds, alias); err != nil {
+		return err
+	}
+	src.WriteString(sb.String())
+	return nil
+}
+
+func (src *sqlSource) write(sb *strings.Builder) {
+	for _, part := range src.parts {
+		if part.sub == nil {
+			sb.WriteString(part.sql)
+			continue
+		}
+		sub := part.sub
+		for sub.duplicateOf != nil {
+			sub = sub.duplicateOf
+		}
+		if sub.inlineSQL == "" {
+			quoteIdentifier(sb, sub.name)
+			continue
+		}
+		sb.WriteString("(")
+		sb.WriteString(sub.inlineSQL)
+		sb.WriteString(")")
+		if part.alias {
+			sb.WriteString(" AS ")
+			quoteIdentifier(sb, sub.name)
+		}
+	}
+}
+
+// subqueryPrefix is the prefix of the names of generated subqueries.
+const subqueryPrefix = "__subquery"
+
+// unionAlias is the alias given to the derived table of a UNION ALL.
+const unionAlias = "__union"
+
+func subqueryName(i int) string {
+	return fmt.Sprintf("%s%d", subqueryPrefix, i)
+}
+
+// canAttachTake reports whether the given operator's subquery can have a limit clause attached.
+func canAttachTake(op parser.TabularOperator) bool {
+	switch op.(type) {
+	case *parser.AsOperator, *parser.RenderOperator:
+		return false
+	default:
+		return true
+	}
+}
+
+// canAttachSort reports whether the given operator's subquery can have a sort clause attached.
+// This becomes significant for operators like "project"
+// because they change the identifiers in scope.
+func canAttachSort(op parser.TabularOperator) bool {
+	switch op.(type) {
+	case *parser.ProjectOperator, *parser.SummarizeOperator, *parser.AsOperator:
+		return false
+	case *parser.DistinctOperator:
+		// Some databases require the ORDER BY terms of a SELECT DISTINCT
+		// to appear in its SELECT list, which a sort key like lower(x) does not.
+		return false
+	case *parser.RenderOperator:
+		return false
+	default:
+		return true
+	}
+}
+
+const (
+	leftJoinTableAlias  = "$left"
+	rightJoinTableAlias = "$right"
+)
+
+func buildJoinCondition(conds []parser.Expr) parser.Expr {
+	if len(conds) == 0 {
+		return (&parser.Ident{Name: "true"}).AsQualified()
+	}
+	x := rewriteSimpleJoinCondition(conds[0])
+	for _, y := range conds[1:] {
+		x = &parser.BinaryExpr{
+			X:  x,
+			Op: parser.TokenAnd,
+			Y:  rewriteSimpleJoinCondition(y),
+		}
+	}
+	return x
+}
+
+// joinFlavorName returns the kind of join that op performs.
+func joinFlavorName(op *parser.JoinOperator) string {
+	if op.Flavor == nil {
+		return "innerunique"
+	}
+	return op.Flavor.Name
+}
+
+// splitJoinCondition divides the conditions of an inner join
+// into the conditions for the ON clause and the conditions for a WHERE clause.
+// Many databases only permit equality comparisons between the two tables
+// in the ON clause, so comparisons of the two tables with other operators
+// are moved to the WHERE clause, which is equivalent for inner joins.
+// If there are no equality comparisons between the tables,
+// on is nil and every condition is returned in filter
+// so that the join can be written as a cross join.
+func splitJoinCondition(conds []parser.Expr) (on, filter parser.Expr) {
+	if len(conds) == 0 {
+		return buildJoinCondition(conds), nil
+	}
+	var onTerms, filterTerms []parser.Expr
+	hasEquality := false
+	for _, c := range conds {
+		for _, term := range conjuncts(rewriteSimpleJoinCondition(c)) {
+			left, right := hasJoinTerms(term)
+			switch {
+			case isJoinEquality(term):
+				hasEquality = true
+				onTerms = append(onTerms, term)
+			case left && right:
+				filterTerms = append(filterTerms, term)
+			default:
+				onTerms = append(onTerms, term)
+			}
+		}
+	}
+	if !hasEquality {
+		return nil, andAll(append(onTerms, filterTerms...))
+	}
+	return andAll(onTerms), andAll(filterTerms)
+}
+
+// semiJoinSource returns the source for a semi join or anti join,
+// which returns the rows of one side of the join
+// that have (or for an anti join, do not have) a matching row on the other side.
+// The join is written as a tuple IN subquery
+// because not all databases support semi joins directly.
+// Anti joins are written as NOT EXISTS subqueries
+// except in ClickHouse, which ignores nulls in IN.
+// The conditions must include at least one equality comparison between the tables,
+// and other comparisons between the tables are not supported.
+func semiJoinSource(ctx *exprContext, op *parser.JoinOperator, flavorName string, leftSource, rightSource sqlSource) (sqlSource, error) {
+	outer, inner := leftSource, rightSource
+	outerAlias, innerAlias := leftJoinTableAlias, rightJoinTableAlias
+	isRight := strings.HasPrefix(flavorName, "right")
+	if isRight {
+		outer, inner = inner, outer
+		outerAlias, innerAlias = innerAlias, outerAlias
+	}
+
+	var outerKeys, innerKeys []parser.Expr
+	var outerFilter, innerFilter parser.Expr
+	for _, c := range op.Conditions {
+		for _, term := range conjuncts(rewriteSimpleJoinCondition(c)) {
+			left, right := hasJoinTerms(term)
+			switch {
+			case isJoinEquality(term):
+				b := term.(*parser.BinaryExpr)
+				leftKey, rightKey := b.X, b.Y
+				if xl, _ := hasJoinTerms(b.X); !xl {
+					leftKey, rightKey = rightKey, leftKey
+				}
+				if isRight {
+					outerKeys = append(outerKeys, rightKey)
+					innerKeys = append(innerKeys, leftKey)
+				} else {
+					outerKeys = append(outerKeys, leftKey)
+					innerKeys = append(innerKeys, rightKey)
+				}
+			case left && right:
+				return sqlSource{}, &compileError{
+					source: ctx.source,
+					span:   term.Span(),
+					err:    fmt.Errorf("%s join only supports equality comparisons between the tables", flavorName),
+					code:   CodeUnsupported,
+				}
+			case left && isRight || right && !isRight:
+				innerFilter = andExpr(innerFilter, term)
+			default:
+				outerFilter = andExpr(outerFilter, term)
+			}
+		}
+	}
+	if len(outerKeys) == 0 {
+		return sqlSource{}, &compileError{
+			source: ctx.source,
+			span:   op.On,
+			err:    fmt.Errorf("%s join requires an equality comparison between the tables", flavorName),
+			code:   CodeUnsupported,
+		}
+	}
+
+	sb := new(strings.Builder)
+	if strings.HasSuffix(flavorName, "anti") && ctx.dialect != ClickHouseDialect {
+		// NOT IN is never true if the subquery returns a null key
+		// or for rows with a null key,
+		// but a null key only means that a row has no match.
+		// ClickHouse's IN ignores nulls on both sides,
+		// so ClickHouse keeps NOT IN,
+		// which it supports better than correlated subqueries.
+		var cond parser.Expr
+		for i := range outerKeys {
+			cond = andExpr(cond, &parser.BinaryExpr{
+				X:      outerKeys[i],
+				OpSpan: parser.Span{Start: -1, End: -1},
+				Op:     parser.TokenEq,
+				Y:      innerKeys[i],
+			})
+		}
+		if innerFilter != nil {
+			cond = andExpr(cond, innerFilter)
+		}
+		if outerFilter != nil {
+			cond = andExpr(cond, outerFilter)
+		}
+		var src sqlSource
+		src.writeSource(outer)
+		src.WriteString(` AS "` + outerAlias + `" WHERE NOT EXISTS (SELECT 1 FROM `)
+		src.writeSource(inner)
+		sb.WriteString(` AS "` + innerAlias + `" WHERE `)
+		if err := writeExpression(ctx, sb, cond); err != nil {
+			return sqlSource{}, err
+		}
+		sb.WriteString(")")
+		src.WriteString(sb.String())
+		return src, nil
+	}
+
+	writeKeys := func(keys []parser.Expr) error {
+		for i, k := range keys {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if err := writeExpression(ctx, sb, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var src sqlSource
+	src.writeSource(outer)
+	src.WriteString(` AS "` + outerAlias + `" WHERE `)
+	if strings.HasSuffix(flavorName, "anti") {
+		src.WriteString("NOT (")
+	}
+	sb.WriteString("(")
+	if err := writeKeys(outerKeys); err != nil {
+		return sqlSource{}, err
+	}
+	sb.WriteString(") IN (SELECT ")
+	if err := writeKeys(innerKeys); err != nil {
+		return sqlSource{}, err
+	}
+	sb.WriteString(" FROM ")
+	src.WriteString(sb.String())
+	src.writeSource(inner)
+	sb.Reset()
+	sb.WriteString(` AS "` + innerAlias + `"`)
+	if innerFilter != nil {
+		sb.WriteString(" WHERE ")
+		if err := writeExpression(ctx, sb, innerFilter); err != nil {
+			return sqlSource{}, err
+		}
+	}
+	sb.WriteString(")")
+	if outerFilter != nil {
+		sb.WriteString(" AND ")
+		if err := writeExpressionMaybeParen(ctx, sb, outerFilter); err != nil {
+			return sqlSource{}, err
+		}
+	}
+	if strings.HasSuffix(flavorName, "anti") {
+		sb.WriteString(")")
+	}
+	src.WriteString(sb.String())
+	return src, nil
+}
+
+// hasJoinEquality reports whether any of the given join conditions
+// is an equality comparison between the two tables.
+func hasJoinEquality(conds []parser.Expr) bool {
+	for _, c := range conds {
+		for _, term := range conjuncts(rewriteSimpleJoinCondition(c)) {
+			if isJoinEquality(term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isJoinEquality reports whether x is an equality comparison
+// between an expression of the left table and an expression of the right table.
+func isJoinEquality(x parser.Expr) bool {
+	b, ok := x.(*parser.BinaryExpr)
+	if !ok || b.Op != parser.TokenEq {
+		return false
+	}
+	xl, xr := hasJoinTerms(b.X)
+	yl, yr := hasJoinTerms(b.Y)
+	return xl && !xr && yr && !yl || xr && !xl && yl && !yr
+}
+
+func rewriteSimpleJoinCondition(c parser.Expr) parser.Expr {
+	id, ok := c.(*parser.QualifiedIdent)
+	if !ok || len(id.Parts) != 1 || id.Parts[0].Quoted || builtinIdentifiers[id.Parts[0].Name] != "" {
+		return c
+	}
+	return &parser.BinaryExpr{
+		X: &parser.QualifiedIdent{
+			Parts: []*parser.Ident{
+				{Name: leftJoinTableAlias},
+				id.Parts[0],
+			},
+		},
+		Op: parser.TokenEq,
+		Y: &parser.QualifiedIdent{
+			Parts: []*parser.Ident{
+				{Name: rightJoinTableAlias},
+				id.Parts[0],
+			},
+		},
+	}
+}
+
+func hasJoinTerms(x parser.Expr) (left, right bool) {
+	parser.Walk(x, func(n parser.Node) bool {
+		if n, ok := n.(*parser.Ident); ok {
+			switch n.Name {
 			case leftJoinTableAlias:
 				left = true
 			case rightJoinTableAlias:
@@ -364,7 +1601,7 @@ func (sub *subquery) write(ctx *exprContext, sb *strings.Builder) error {
 	switch op := sub.op.(type) {
 	case nil, *parser.AsOperator:
 		sb.WriteString("SELECT * FROM ")
-		sb.WriteString(sub.sourceSQL)
+		sub.source.write(sb)
 	case *parser.ProjectOperator:
 		sb.WriteString("SELECT ")
 		for i, col := range op.Cols {
@@ -384,7 +1621,31 @@ func (sub *subquery) write(ctx *exprContext, sb *strings.Builder) error {
 			quoteIdentifier(sb, col.Name.Name)
 		}
 		sb.WriteString(" FROM ")
-		sb.WriteString(sub.sourceSQL)
+		sub.source.write(sb)
+		if err := sub.writeFilter(ctx, sb); err != nil {
+			return err
+		}
+	case *parser.ProjectAwayOperator:
+		if err := sub.writeProjectAway(ctx, sb, op); err != nil {
+			return err
+		}
+	case *parser.DistinctOperator:
+		sb.WriteString("SELECT DISTINCT ")
+		for i, col := range op.Cols {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if err := writeExpression(ctx, sb, col.AsQualified()); err != nil {
+				return err
+			}
+			sb.WriteString(" AS ")
+			quoteIdentifier(sb, col.Name)
+		}
+		sb.WriteString(" FROM ")
+		sub.source.write(sb)
+		if err := sub.writeFilter(ctx, sb); err != nil {
+			return err
+		}
 	case *parser.ExtendOperator:
 		sb.WriteString("SELECT *")
 		for _, col := range op.Cols {
@@ -401,12 +1662,18 @@ func (sub *subquery) write(ctx *exprContext, sb *strings.Builder) error {
 			if col.Name != nil {
 				quoteIdentifier(sb, col.Name.Name)
 			} else {
-				span := col.X.Span()
-				quoteIdentifier(sb, ctx.source[span.Start:span.End])
+				quoteIdentifier(sb, ctx.derivedColumnName(col.X))
 			}
 		}
 		sb.WriteString(" FROM ")
-		sb.WriteString(sub.sourceSQL)
+		sub.source.write(sb)
+		if err := sub.writeFilter(ctx, sb); err != nil {
+			return err
+		}
+	case *parser.MvExpandOperator:
+		if err := sub.writeMvExpand(ctx, sb, op); err != nil {
+			return err
+		}
 	case *parser.SummarizeOperator:
 		sb.WriteString("SELECT ")
 		for i, col := range op.GroupBy {
@@ -421,8 +1688,7 @@ func (sub *subquery) write(ctx *exprContext, sb *strings.Builder) error {
 			if col.Name != nil {
 				quoteIdentifier(sb, col.Name.Name)
 			} else {
-				span := col.X.Span()
-				quoteIdentifier(sb, ctx.source[span.Start:span.End])
+				quoteIdentifier(sb, ctx.derivedColumnName(col.X))
 			}
 		}
 		for i, col := range op.Cols {
@@ -436,13 +1702,15 @@ func (sub *subquery) write(ctx *exprContext, sb *strings.Builder) error {
 			if col.Name != nil {
 				quoteIdentifier(sb, col.Name.Name)
 			} else {
-				span := col.X.Span()
-				quoteIdentifier(sb, ctx.source[span.Start:span.End])
+				quoteIdentifier(sb, ctx.derivedColumnName(col.X))
 			}
 		}
 
 		sb.WriteString(" FROM ")
-		sb.WriteString(sub.sourceSQL)
+		sub.source.write(sb)
+		if err := sub.writeFilter(ctx, sb); err != nil {
+			return err
+		}
 
 		if len(op.GroupBy) > 0 {
 			sb.WriteString(" GROUP BY ")
@@ -457,14 +1725,16 @@ func (sub *subquery) write(ctx *exprContext, sb *strings.Builder) error {
 		}
 	case *parser.WhereOperator:
 		sb.WriteString("SELECT * FROM ")
-		sb.WriteString(sub.sourceSQL)
+		sub.source.write(sb)
 		sb.WriteString(" WHERE ")
 		if err := writeExpression(ctx, sb, op.Predicate); err != nil {
 			return err
 		}
 	case *parser.CountOperator:
-		sb.WriteString(`SELECT COUNT(*) AS "count()" FROM `)
-		sb.WriteString(sub.sourceSQL)
+		sb.WriteString(`SELECT COUNT(*) AS `)
+		quoteIdentifier(sb, ctx.columnName("count()"))
+		sb.WriteString(" FROM ")
+		sub.source.write(sb)
 	case *parser.RenderOperator:
 		// First, write the source data
 		sb.WriteString("SELECT *,\n")
@@ -489,69 +1759,714 @@ func (sub *subquery) write(ctx *exprContext, sb *strings.Builder) error {
 			sb.WriteString(prop.Name.Name)
 			sb.WriteString("\"")
 		}
-
-		sb.WriteString("\nFROM ")
-		sb.WriteString(sub.sourceSQL)
+
+		sb.WriteString("\nFROM ")
+		sub.source.write(sb)
+	default:
+		return ctx.unsupported(sb, op.Span(),
+			fmt.Sprintf("SELECT NULL /* unsupported operator %T */", op),
+			fmt.Errorf("unsupported operator %T", op))
+	}
+
+	if sub.sort != nil {
+		sb.WriteString(" ORDER BY ")
+		for i, term := range sub.sort.Terms {
+			if err := writeSortKey(ctx, sb, term); err != nil {
+				return err
+			}
+			if term.Asc {
+				sb.WriteString(" ASC")
+			} else {
+				sb.WriteString(" DESC")
+			}
+			if term.NullsFirst {
+				sb.WriteString(" NULLS FIRST")
+			} else {
+				sb.WriteString(" NULLS LAST")
+			}
+			if i < len(sub.sort.Terms)-1 {
+				sb.WriteString(", ")
+			}
+		}
+	}
+
+	if sub.take != nil {
+		sb.WriteString(" LIMIT ")
+		if err := writeRowCount(ctx, sb, sub.take.RowCount); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeProjectAway writes the SELECT for a project-away operator.
+// ClickHouse and DuckDB can exclude columns from a star,
+// but PostgreSQL needs the remaining columns to be listed,
+// so they must be known from [CompileOptions.AnalysisContext].
+func (sub *subquery) writeProjectAway(ctx *exprContext, sb *strings.Builder, op *parser.ProjectAwayOperator) error {
+	switch ctx.dialect {
+	case PostgresDialect:
+		kept, ok := ctx.projectAwayColumns[op]
+		if !ok {
+			return &compileError{
+				source: ctx.source,
+				span:   op.Span(),
+				err:    fmt.Errorf("project-away requires the columns of its input to be known in %v", ctx.dialect),
+				code:   CodeUnsupported,
+			}
+		}
+		sb.WriteString("SELECT ")
+		for i, name := range kept {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			quoteIdentifier(sb, name)
+		}
+	case DuckDBDialect:
+		sb.WriteString("SELECT * EXCLUDE (")
+		writeIdentList(sb, op.Cols)
+		sb.WriteString(")")
+	default:
+		sb.WriteString("SELECT * EXCEPT (")
+		writeIdentList(sb, op.Cols)
+		sb.WriteString(")")
+	}
+	sb.WriteString(" FROM ")
+	sub.source.write(sb)
+	return sub.writeFilter(ctx, sb)
+}
+
+// writeIdentList writes the quoted names of cols separated by commas.
+func writeIdentList(sb *strings.Builder, cols []*parser.Ident) {
+	for i, col := range cols {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		quoteIdentifier(sb, col.Name)
+	}
+}
+
+// writeMvExpand writes the SELECT for an mv-expand operator,
+// which produces a row for each element of the expanded arrays.
+// Columns that expand an input column in place replace that column.
+// ClickHouse uses an ARRAY JOIN clause,
+// which pairs up the elements of multiple arrays like mv-expand does.
+// Other dialects call unnest in the SELECT list,
+// which DuckDB and PostgreSQL also expand in parallel.
+func (sub *subquery) writeMvExpand(ctx *exprContext, sb *strings.Builder, op *parser.MvExpandOperator) error {
+	if ctx.dialect == ClickHouseDialect {
+		sb.WriteString("SELECT *")
+		for _, col := range op.Cols {
+			if mvExpandReplaces(col) == "" {
+				sb.WriteString(", ")
+				quoteIdentifier(sb, mvExpandColumnName(ctx, col))
+			}
+		}
+		sb.WriteString(" FROM ")
+		sub.source.write(sb)
+		sb.WriteString(" ARRAY JOIN ")
+		for i, col := range op.Cols {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if err := writeExpression(ctx, sb, col.X); err != nil {
+				return err
+			}
+			if mvExpandReplaces(col) == "" {
+				sb.WriteString(" AS ")
+				quoteIdentifier(sb, mvExpandColumnName(ctx, col))
+			}
+		}
+		return sub.writeFilter(ctx, sb)
+	}
+
+	var replaced, added []*parser.MvExpandColumn
+	for _, col := range op.Cols {
+		if mvExpandReplaces(col) != "" {
+			replaced = append(replaced, col)
+		} else {
+			added = append(added, col)
+		}
+	}
+	if len(replaced) > 0 && ctx.dialect != DuckDBDialect {
+		return &compileError{
+			source: ctx.source,
+			span:   replaced[0].Span(),
+			err: fmt.Errorf("mv-expand cannot replace column %q in %v; give the expanded column a new name",
+				mvExpandReplaces(replaced[0]), ctx.dialect),
+			code: CodeUnsupported,
+		}
+	}
+	writeUnnest := func(col *parser.MvExpandColumn) error {
+		sb.WriteString("unnest(")
+		if err := writeExpression(ctx, sb, col.X); err != nil {
+			return err
+		}
+		sb.WriteString(") AS ")
+		quoteIdentifier(sb, mvExpandColumnName(ctx, col))
+		return nil
+	}
+	sb.WriteString("SELECT *")
+	if len(replaced) > 0 {
+		sb.WriteString(" REPLACE (")
+		for i, col := range replaced {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if err := writeUnnest(col); err != nil {
+				return err
+			}
+		}
+		sb.WriteString(")")
+	}
+	for _, col := range added {
+		sb.WriteString(", ")
+		if err := writeUnnest(col); err != nil {
+			return err
+		}
+	}
+	sb.WriteString(" FROM ")
+	sub.source.write(sb)
+	return sub.writeFilter(ctx, sb)
+}
+
+// mvExpandReplaces returns the name of the input column
+// that an mv-expand column expands in place
+// or the empty string if the column is added to the input's columns.
+func mvExpandReplaces(col *parser.MvExpandColumn) string {
+	id, ok := col.X.(*parser.QualifiedIdent)
+	if !ok || len(id.Parts) != 1 {
+		return ""
+	}
+	if col.Name != nil && col.Name.Name != id.Parts[0].Name {
+		return ""
+	}
+	return id.Parts[0].Name
+}
+
+// mvExpandColumnName returns the SQL name of an mv-expand column.
+func mvExpandColumnName(ctx *exprContext, col *parser.MvExpandColumn) string {
+	if name := mvExpandReplaces(col); name != "" {
+		return name
+	}
+	if col.Name != nil {
+		return col.Name.Name
+	}
+	return ctx.derivedColumnName(col.X)
+}
+
+// writeFilter writes the WHERE clause for sub.filter, if any.
+func (sub *subquery) writeFilter(ctx *exprContext, sb *strings.Builder) error {
+	if sub.filter == nil {
+		return nil
+	}
+	sb.WriteString(" WHERE ")
+	return writeExpression(ctx, sb, sub.filter)
+}
+
+// dataSourceSQL writes the SQL for the given data source to sb.
+// If alias is true, a data source that is written as a derived table
+// is followed by a table alias.
+func dataSourceSQL(sb *strings.Builder, tables sourceTables, src parser.TabularDataSource, alias bool) error {
+	switch src := src.(type) {
+	case *parser.TableRef:
+		if src.Cluster != nil {
+			sb.WriteString("cluster(")
+			quoteSQLString(sb, src.Cluster.Name)
+			sb.WriteString(", ")
+			quoteSQLString(sb, src.Database.Name)
+			sb.WriteString(", ")
+			quoteSQLString(sb, src.Table.Name)
+			sb.WriteString(")")
+			return nil
+		}
+		if src.Database != nil {
+			quoteIdentifier(sb, src.Database.Name)
+			sb.WriteString(".")
+		}
+		quoteIdentifier(sb, src.Table.Name)
+		return nil
+	case *parser.TableWildcard, *parser.TableCall:
+		matched := tables[src]
+		if matched == nil {
+			return fmt.Errorf("unresolved data source %T", src)
+		}
+		if len(matched.names) == 1 {
+			matched.writeTable(sb, matched.names[0])
+			return nil
+		}
+		sb.WriteString("(")
+		for i, name := range matched.names {
+			if i > 0 {
+				sb.WriteString(" UNION ALL ")
+			}
+			sb.WriteString("SELECT * FROM ")
+			matched.writeTable(sb, name)
+		}
+		sb.WriteString(")")
+		if alias {
+			sb.WriteString(` AS "` + unionAlias + `"`)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unhandled data source %T", src)
+	}
+}
+
+// sourceTables maps the table wildcards and table() calls in a query
+// to the tables they match.
+type sourceTables map[parser.TabularDataSource]*matchedTables
+
+// matchedTables is the set of tables matched by a table wildcard or table() call.
+type matchedTables struct {
+	// database is the name of the database that the tables are in,
+	// or the empty string if the tables are in the default database.
+	database string
+	names    []string
+}
+
+// writeTable writes the SQL name of the matched table called name to sb.
+func (m *matchedTables) writeTable(sb *strings.Builder, name string) {
+	if m.database != "" {
+		quoteIdentifier(sb, m.database)
+		sb.WriteString(".")
+	}
+	quoteIdentifier(sb, name)
+}
+
+// resolveTables adds the table wildcards and table() calls in expr to tables.
+// consts is the set of let-bound constant strings in scope.
+func (opts *CompileOptions) resolveTables(ctx context.Context, tables sourceTables, source string, consts map[string]string, expr *parser.TabularExpr) error {
+	var err error
+	parser.Walk(expr, func(n parser.Node) bool {
+		if err != nil {
+			return false
+		}
+		var database *parser.Ident
+		var pattern string
+		switch n := n.(type) {
+		case *parser.TableRef:
+			if n.Cluster != nil && opts.dialect() != ClickHouseDialect {
+				err = &compileError{
+					source: source,
+					span:   n.Cluster.Span(),
+					err:    fmt.Errorf("clusters are not supported in %v", opts.dialect()),
+					code:   CodeUnsupported,
+				}
+				return false
+			}
+			return true
+		case *parser.TableWildcard:
+			database = n.Database
+			pattern = n.Pattern
+		case *parser.TableCall:
+			var ok bool
+			pattern, ok = constantString(n.Name, consts)
+			if !ok {
+				err = &compileError{
+					source: source,
+					span:   n.Name.Span(),
+					err:    fmt.Errorf("table name must be a constant string"),
+					code:   CodeUnsupported,
+				}
+				return false
+			}
+		default:
+			return true
+		}
+		if opts == nil || opts.AnalysisContext == nil {
+			err = &compileError{
+				source: source,
+				span:   n.Span(),
+				err:    fmt.Errorf("cannot resolve %q without an analysis context", pattern),
+				code:   CodeUnknownTable,
+			}
+			return false
+		}
+		dbName := ""
+		if database != nil {
+			dbName = database.Name
+		}
+		names, matchErr := opts.AnalysisContext.matchTables(ctx, dbName, pattern)
+		if matchErr != nil {
+			err = &compileError{
+				source: source,
+				span:   n.Span(),
+				err:    matchErr,
+				code:   CodeUnknownTable,
+			}
+			return false
+		}
+		if len(names) == 0 {
+			err = &compileError{
+				source: source,
+				span:   n.Span(),
+				err:    fmt.Errorf("no tables match %q", pattern),
+				code:   CodeUnknownTable,
+			}
+			return false
+		}
+		tables[n.(parser.TabularDataSource)] = &matchedTables{
+			database: dbName,
+			names:    names,
+		}
+		return false
+	})
+	return err
+}
+
+// constantString evaluates x as a string constant.
+// x may consist of string literals, concatenations with "+",
+// and identifiers bound in consts.
+// The second result reports whether x is a string constant.
+func constantString(x parser.Expr, consts map[string]string) (string, bool) {
+	switch x := x.(type) {
+	case *parser.BasicLit:
+		return x.Value, x.Kind == parser.TokenString
+	case *parser.ParenExpr:
+		return constantString(x.X, consts)
+	case *parser.QualifiedIdent:
+		if len(x.Parts) != 1 || x.Parts[0].Quoted {
+			return "", false
+		}
+		v, ok := consts[x.Parts[0].Name]
+		return v, ok
+	case *parser.BinaryExpr:
+		if x.Op != parser.TokenPlus {
+			return "", false
+		}
+		lhs, ok := constantString(x.X, consts)
+		if !ok {
+			return "", false
+		}
+		rhs, ok := constantString(x.Y, consts)
+		if !ok {
+			return "", false
+		}
+		return lhs + rhs, true
+	default:
+		return "", false
+	}
+}
+
+// writeSortKey writes the expression of a sort term to sb,
+// applying the context's case-insensitivity and collation to strings.
+func writeSortKey(ctx *exprContext, sb *strings.Builder, term *parser.SortTerm) error {
+	isString := !ctx.nonStringSorts[term]
+	if lit, ok := term.X.(*parser.BasicLit); ok && lit.Kind != parser.TokenString {
+		isString = false
+	}
+	if !isString || !ctx.caseInsensitiveSort {
+		if err := writeExpression(ctx, sb, term.X); err != nil {
+			return err
+		}
+	} else {
+		sb.WriteString("lower(")
+		if err := writeExpression(ctx, sb, term.X); err != nil {
+			return err
+		}
+		sb.WriteString(")")
+	}
+	if isString && ctx.sortCollation != "" {
+		sb.WriteString(" COLLATE ")
+		sb.WriteString(ctx.sortCollation)
+	}
+	return nil
+}
+
+// findNonStringSorts adds the sort terms in expr
+// whose keys are columns with a known type other than a string to terms.
+func (r *columnResolver) findNonStringSorts(terms map[*parser.SortTerm]bool, expr *parser.TabularExpr) {
+	parser.Walk(expr, func(n parser.Node) bool {
+		x, ok := n.(*parser.TabularExpr)
+		if !ok {
+			return true
+		}
+		for i, op := range x.Operators {
+			var opTerms []*parser.SortTerm
+			switch op := op.(type) {
+			case *parser.SortOperator:
+				opTerms = op.Terms
+			case *parser.TopOperator:
+				opTerms = []*parser.SortTerm{op.Col}
+			default:
+				continue
+			}
+			cols := r.tabularColumns(r.source, &parser.TabularExpr{
+				Source:    x.Source,
+				Operators: x.Operators[:i],
+			})
+			for _, term := range opTerms {
+				id, ok := term.X.(*parser.QualifiedIdent)
+				if !ok || len(id.Parts) != 1 {
+					continue
+				}
+				if j := columnIndex(cols, id.Parts[0].Name); j >= 0 && cols[j].Type != "" && !isStringType(cols[j].Type) {
+					terms[term] = true
+				}
+			}
+		}
+		return true
+	})
+}
+
+// findNonStringComparisons adds the column references
+// in the equality comparisons of expr to idents
+// if the columns are known not to be strings.
+func (r *columnResolver) findNonStringComparisons(idents map[*parser.QualifiedIdent]bool, expr *parser.TabularExpr) {
+	parser.Walk(expr, func(n parser.Node) bool {
+		x, ok := n.(*parser.TabularExpr)
+		if !ok {
+			return true
+		}
+		for i, op := range x.Operators {
+			var cols []*AnalysisColumn
+			parser.Walk(op, func(n parser.Node) bool {
+				var operands []parser.Expr
+				switch n := n.(type) {
+				case *parser.TabularExpr:
+					// Nested pipelines are visited by the outer walk.
+					return false
+				case *parser.BinaryExpr:
+					if n.Op != parser.TokenEq && n.Op != parser.TokenNE {
+						return true
+					}
+					operands = []parser.Expr{n.X, n.Y}
+				case *parser.InExpr:
+					operands = append([]parser.Expr{n.X}, n.Vals...)
+				default:
+					return true
+				}
+				if cols == nil {
+					cols = r.tabularColumns(r.source, &parser.TabularExpr{
+						Source:    x.Source,
+						Operators: x.Operators[:i],
+					})
+				}
+				for _, operand := range operands {
+					id, ok := operand.(*parser.QualifiedIdent)
+					if !ok || len(id.Parts) != 1 {
+						continue
+					}
+					if j := columnIndex(cols, id.Parts[0].Name); j >= 0 && cols[j].Type != "" && !isStringType(cols[j].Type) {
+						idents[id] = true
+					}
+				}
+				return true
+			})
+		}
+		return true
+	})
+}
+
+// findProjectAwayColumns adds the project-away operators in expr
+// whose input columns are known to cols,
+// mapped to the names of the columns that they keep.
+func (r *columnResolver) findProjectAwayColumns(cols map[*parser.ProjectAwayOperator][]string, expr *parser.TabularExpr) {
+	parser.Walk(expr, func(n parser.Node) bool {
+		x, ok := n.(*parser.TabularExpr)
+		if !ok {
+			return true
+		}
+		for i, op := range x.Operators {
+			op, ok := op.(*parser.ProjectAwayOperator)
+			if !ok {
+				continue
+			}
+			input := r.exactColumns(&parser.TabularExpr{
+				Source:    x.Source,
+				Operators: x.Operators[:i],
+			})
+			if input == nil {
+				continue
+			}
+			kept := make([]string, 0, len(input))
+			for _, col := range input {
+				if !slices.ContainsFunc(op.Cols, func(id *parser.Ident) bool { return id.Name == col.Name }) {
+					kept = append(kept, col.Name)
+				}
+			}
+			cols[op] = kept
+		}
+		return true
+	})
+}
+
+// isStringType reports whether the given database type name
+// refers to a string type, like "String", "LowCardinality(String)", or "varchar(255)".
+func isStringType(typ string) bool {
+	typ = strings.ToLower(typ)
+	return strings.Contains(typ, "string") || strings.Contains(typ, "char") || strings.Contains(typ, "text")
+}
+
+// constantInt evaluates x as an integer constant.
+// x may consist of integer literals, arithmetic operators,
+// and identifiers bound in ints.
+// The second result reports whether x is an integer constant
+// whose evaluation does not overflow.
+func constantInt(x parser.Expr, ints map[string]int64) (int64, bool) {
+	switch x := x.(type) {
+	case *parser.BasicLit:
+		if !x.IsInteger() {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(x.Value, 0, 64)
+		return n, err == nil
+	case *parser.ParenExpr:
+		return constantInt(x.X, ints)
+	case *parser.QualifiedIdent:
+		if len(x.Parts) != 1 || x.Parts[0].Quoted {
+			return 0, false
+		}
+		v, ok := ints[x.Parts[0].Name]
+		return v, ok
+	case *parser.UnaryExpr:
+		v, ok := constantInt(x.X, ints)
+		switch {
+		case !ok:
+			return 0, false
+		case x.Op == parser.TokenPlus:
+			return v, true
+		case x.Op == parser.TokenMinus:
+			if v == math.MinInt64 {
+				return 0, false
+			}
+			return -v, true
+		default:
+			return 0, false
+		}
+	case *parser.BinaryExpr:
+		lhs, ok := constantInt(x.X, ints)
+		if !ok {
+			return 0, false
+		}
+		rhs, ok := constantInt(x.Y, ints)
+		if !ok {
+			return 0, false
+		}
+		switch x.Op {
+		case parser.TokenPlus:
+			sum := lhs + rhs
+			if (rhs > 0 && sum < lhs) || (rhs < 0 && sum > lhs) {
+				return 0, false
+			}
+			return sum, true
+		case parser.TokenMinus:
+			diff := lhs - rhs
+			if (rhs > 0 && diff > lhs) || (rhs < 0 && diff < lhs) {
+				return 0, false
+			}
+			return diff, true
+		case parser.TokenStar:
+			if lhs == 0 || rhs == 0 {
+				return 0, true
+			}
+			prod := lhs * rhs
+			if prod/rhs != lhs || (lhs == -1 && rhs == math.MinInt64) || (rhs == -1 && lhs == math.MinInt64) {
+				return 0, false
+			}
+			return prod, true
+		case parser.TokenSlash:
+			if rhs == 0 || (lhs == math.MinInt64 && rhs == -1) {
+				return 0, false
+			}
+			return lhs / rhs, true
+		case parser.TokenMod:
+			if rhs == 0 {
+				return 0, false
+			}
+			if rhs == -1 {
+				return 0, true
+			}
+			return lhs % rhs, true
+		default:
+			return 0, false
+		}
 	default:
-		fmt.Fprintf(sb, "SELECT NULL /* unsupported operator %T */", op)
-		return nil
+		return 0, false
 	}
+}
 
-	if sub.sort != nil {
-		sb.WriteString(" ORDER BY ")
-		for i, term := range sub.sort.Terms {
-			if err := writeExpression(ctx, sb, term.X); err != nil {
-				return err
-			}
-			if term.Asc {
-				sb.WriteString(" ASC")
-			} else {
-				sb.WriteString(" DESC")
+// writeRowCount writes the row count of a take or top operator to sb.
+// Row counts that can be evaluated at compile time
+// are written as a single non-negative integer.
+// Other row counts may only refer to parameters and let statements.
+func writeRowCount(ctx *exprContext, sb *strings.Builder, x parser.Expr) error {
+	if n, ok := constantInt(x, ctx.ints); ok {
+		if n < 0 {
+			return &compileError{
+				source: ctx.source,
+				span:   x.Span(),
+				err:    fmt.Errorf("row count must not be negative (got %d)", n),
+				code:   CodeInvalidRowCount,
 			}
-			if term.NullsFirst {
-				sb.WriteString(" NULLS FIRST")
-			} else {
-				sb.WriteString(" NULLS LAST")
+		}
+		sb.WriteString(strconv.FormatInt(n, 10))
+		return nil
+	}
+	var invalid parser.Node
+	usesScope := false
+	parser.Walk(x, func(n parser.Node) bool {
+		if invalid != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *parser.QualifiedIdent:
+			_, isInt := ctx.ints[n.Parts[0].Name]
+			switch {
+			case len(n.Parts) != 1 || n.Parts[0].Quoted || ctx.scope[n.Parts[0].Name] == "":
+				invalid = n
+			case !isInt:
+				usesScope = true
 			}
-			if i < len(sub.sort.Terms)-1 {
-				sb.WriteString(", ")
+			return false
+		case *parser.BasicLit:
+			if !n.IsInteger() {
+				invalid = n
 			}
 		}
-	}
-
-	if sub.take != nil {
-		sb.WriteString(" LIMIT ")
-		if err := writeExpression(ctx, sb, sub.take.RowCount); err != nil {
-			return err
+		return true
+	})
+	if invalid != nil {
+		return &compileError{
+			source: ctx.source,
+			span:   invalid.Span(),
+			err:    fmt.Errorf("row count must be a constant integer"),
+			code:   CodeInvalidRowCount,
 		}
 	}
-
-	return nil
-}
-
-func dataSourceSQL(sb *strings.Builder, src parser.TabularDataSource) error {
-	switch src := src.(type) {
-	case *parser.TableRef:
-		quoteIdentifier(sb, src.Table.Name)
-		return nil
-	default:
-		return fmt.Errorf("unhandled data source %T", src)
+	if !usesScope {
+		// Only constants, but evaluation failed (e.g. division by zero or overflow).
+		return &compileError{
+			source: ctx.source,
+			span:   x.Span(),
+			err:    fmt.Errorf("row count is not a valid integer"),
+			code:   CodeInvalidRowCount,
+		}
 	}
+	return writeExpression(ctx, sb, x)
 }
 
 func quoteIdentifier(sb *strings.Builder, name string) {
-	const quoteEscape = `""`
-	sb.Grow(len(name) + strings.Count(name, `"`)*(len(quoteEscape)-1) + len(`""`))
+	sb.WriteByte('"')
+	writeEscaped(sb, name, '"')
+	sb.WriteByte('"')
+}
 
-	sb.WriteString(`"`)
-	for _, b := range []byte(name) {
-		if b == '"' {
-			sb.WriteString(quoteEscape)
-		} else {
-			sb.WriteByte(b)
+// writeEscaped writes s to sb, doubling each occurrence of quote.
+// Runs of text without quote are written with a single call.
+func writeEscaped(sb *strings.Builder, s string, quote byte) {
+	for {
+		i := strings.IndexByte(s, quote)
+		if i < 0 {
+			sb.WriteString(s)
+			return
 		}
+		sb.WriteString(s[:i+1])
+		sb.WriteByte(quote)
+		s = s[i+1:]
 	}
-	sb.WriteString(`"`)
 }
 
 var builtinIdentifiers = map[string]string{
@@ -586,6 +2501,75 @@ type exprContext struct {
 	source string
 	scope  map[string]string
 	mode   exprMode
+	// strict is true if unsupported constructs are errors.
+	strict bool
+	// knownFunctionsOnly is [CompileOptions.DisallowUnknownFunctions].
+	knownFunctionsOnly bool
+	// stringComparison is how equality operators are written.
+	stringComparison StringComparison
+	// threeValued is true if comparisons with NULL should produce NULL.
+	threeValued bool
+	// ints is the set of let-bound integer constants in scope.
+	ints map[string]int64
+	// caseInsensitiveSort is [CompileOptions.CaseInsensitiveSort].
+	caseInsensitiveSort bool
+	// sortCollation is [CompileOptions.SortCollation].
+	sortCollation string
+	// nonStringSorts is the set of sort terms known not to be strings.
+	nonStringSorts map[*parser.SortTerm]bool
+	// nonStringColumns is the set of column references
+	// in equality comparisons that are known not to be strings.
+	nonStringColumns map[*parser.QualifiedIdent]bool
+	// projectAwayColumns maps project-away operators
+	// to the names of the columns that they keep
+	// if their input's columns are known.
+	projectAwayColumns map[*parser.ProjectAwayOperator][]string
+	// columnNamer is [CompileOptions.ColumnName].
+	columnNamer func(string) string
+	// dialect is [CompileOptions.Dialect].
+	dialect Dialect
+	// inListThreshold is [CompileOptions.InListThreshold].
+	inListThreshold int
+	// inListParameter is [CompileOptions.InListParameter].
+	inListParameter func(values any) string
+}
+
+// columnName returns the SQL name of a column
+// that the query language names name.
+func (ctx *exprContext) columnName(name string) string {
+	if ctx.columnNamer == nil {
+		return name
+	}
+	return ctx.columnNamer(name)
+}
+
+// derivedColumnName returns the SQL name of a column computed by x
+// that was not given an explicit name.
+func (ctx *exprContext) derivedColumnName(x parser.Expr) string {
+	span := x.Span()
+	return ctx.columnName(ctx.source[span.Start:span.End])
+}
+
+// exprContext returns a new context for writing expressions in source.
+func (opts *CompileOptions) exprContext(source string, scope map[string]string, mode exprMode) *exprContext {
+	ctx := &exprContext{
+		source: source,
+		scope:  scope,
+		mode:   mode,
+	}
+	if opts != nil {
+		ctx.strict = opts.Strict
+		ctx.knownFunctionsOnly = opts.DisallowUnknownFunctions
+		ctx.stringComparison = opts.StringComparison
+		ctx.threeValued = opts.ThreeValuedComparisons
+		ctx.columnNamer = opts.ColumnName
+		ctx.caseInsensitiveSort = opts.CaseInsensitiveSort
+		ctx.sortCollation = opts.SortCollation
+		ctx.dialect = opts.Dialect
+		ctx.inListThreshold = opts.InListThreshold
+		ctx.inListParameter = opts.InListParameter
+	}
+	return ctx
 }
 
 func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error {
@@ -596,7 +2580,7 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 		if !ok {
 			break
 		}
-		x = p
+		x = p.X
 	}
 
 	switch x := x.(type) {
@@ -617,6 +2601,7 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 						source: ctx.source,
 						span:   part.NameSpan,
 						err:    fmt.Errorf("unknown identifier %s in let expression", part.Name),
+						code:   CodeInvalidIdentifier,
 					}
 				}
 			} else if ctx.mode == letExprMode {
@@ -624,6 +2609,7 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 					source: ctx.source,
 					span:   part.NameSpan,
 					err:    fmt.Errorf("quoted identifier not permitted in let expression"),
+					code:   CodeInvalidIdentifier,
 				}
 			}
 		} else if ctx.mode == letExprMode {
@@ -631,6 +2617,7 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 				source: ctx.source,
 				span:   x.Span(),
 				err:    fmt.Errorf("qualified identifier not permitted in let expression"),
+				code:   CodeInvalidIdentifier,
 			}
 		}
 
@@ -643,6 +2630,7 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 					source: ctx.source,
 					span:   part.NameSpan,
 					err:    fmt.Errorf("%s used in non-join context", part.Name),
+					code:   CodeInvalidIdentifier,
 				}
 			}
 			quoteIdentifier(sb, part.Name)
@@ -654,7 +2642,9 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 		case parser.TokenString:
 			quoteSQLString(sb, x.Value)
 		default:
-			fmt.Fprintf(sb, "NULL /* unhandled %s literal */", x.Kind)
+			return ctx.unsupported(sb, x.Span(),
+				fmt.Sprintf("NULL /* unhandled %s literal */", x.Kind),
+				fmt.Errorf("unsupported %s literal", x.Kind))
 		}
 	case *parser.UnaryExpr:
 		switch x.Op {
@@ -663,7 +2653,12 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 		case parser.TokenMinus:
 			sb.WriteString("-")
 		default:
-			fmt.Fprintf(sb, "/* unhandled %s unary op */ ", x.Op)
+			err := ctx.unsupported(sb, x.OpSpan,
+				fmt.Sprintf("/* unhandled %s unary op */ ", x.Op),
+				fmt.Errorf("unsupported unary operator %s", x.Op))
+			if err != nil {
+				return err
+			}
 		}
 		if err := writeExpressionMaybeParen(ctx, sb, x.X); err != nil {
 			return err
@@ -690,45 +2685,19 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 				}
 			}
 
-			sb.WriteString("coalesce(")
-			if err := writeExpressionMaybeParen(ctx, sb, x.X); err != nil {
-				return err
-			}
-			sb.WriteString(" = ")
-			if err := writeExpressionMaybeParen(ctx, sb, x.Y); err != nil {
-				return err
+			if ctx.lowersComparison(x.X, x.Y) {
+				return writeLowerComparison(ctx, sb, x.X, " = ", x.Y)
 			}
-			sb.WriteString(", FALSE)")
+			return writeEquality(ctx, sb, x.X, " = ", x.Y)
 		case parser.TokenNE:
-			sb.WriteString("coalesce(")
-			if err := writeExpressionMaybeParen(ctx, sb, x.X); err != nil {
-				return err
-			}
-			sb.WriteString(" <> ")
-			if err := writeExpressionMaybeParen(ctx, sb, x.Y); err != nil {
-				return err
+			if ctx.lowersComparison(x.X, x.Y) {
+				return writeLowerComparison(ctx, sb, x.X, " <> ", x.Y)
 			}
-			sb.WriteString(", FALSE)")
+			return writeEquality(ctx, sb, x.X, " <> ", x.Y)
 		case parser.TokenCaseInsensitiveEq:
-			sb.WriteString("lower(")
-			if err := writeExpression(ctx, sb, x.X); err != nil {
-				return err
-			}
-			sb.WriteString(") = lower(")
-			if err := writeExpression(ctx, sb, x.Y); err != nil {
-				return err
-			}
-			sb.WriteString(")")
+			return writeCaseInsensitiveComparison(ctx, sb, x.X, false, x.Y)
 		case parser.TokenCaseInsensitiveNE:
-			sb.WriteString("lower(")
-			if err := writeExpression(ctx, sb, x.X); err != nil {
-				return err
-			}
-			sb.WriteString(") <> lower(")
-			if err := writeExpression(ctx, sb, x.Y); err != nil {
-				return err
-			}
-			sb.WriteString(")")
+			return writeCaseInsensitiveComparison(ctx, sb, x.X, true, x.Y)
 		default:
 			if sqlOp, ok := binaryOps[x.Op]; ok {
 				if err := writeExpressionMaybeParen(ctx, sb, x.X); err != nil {
@@ -741,11 +2710,23 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 					return err
 				}
 			} else {
-				fmt.Fprintf(sb, "NULL /* unhandled %s binary op */ ", x.Op)
+				return ctx.unsupported(sb, x.OpSpan,
+					fmt.Sprintf("NULL /* unhandled %s binary op */ ", x.Op),
+					fmt.Errorf("unsupported binary operator %s", x.Op))
 			}
 		}
 	case *parser.InExpr:
-		if err := writeExpressionMaybeParen(ctx, sb, x.X); err != nil {
+		if ok, err := writeInArray(ctx, sb, x); ok || err != nil {
+			return err
+		}
+		lower := ctx.lowersComparison(append([]parser.Expr{x.X}, x.Vals...)...)
+		if lower {
+			sb.WriteString("lower(")
+			if err := writeExpression(ctx, sb, x.X); err != nil {
+				return err
+			}
+			sb.WriteString(")")
+		} else if err := writeExpressionMaybeParen(ctx, sb, x.X); err != nil {
 			return err
 		}
 		sb.WriteString(" IN (")
@@ -753,7 +2734,13 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 			if i > 0 {
 				sb.WriteString(", ")
 			}
-			if err := writeExpressionMaybeParen(ctx, sb, y); err != nil {
+			if lower {
+				sb.WriteString("lower(")
+				if err := writeExpression(ctx, sb, y); err != nil {
+					return err
+				}
+				sb.WriteString(")")
+			} else if err := writeExpressionMaybeParen(ctx, sb, y); err != nil {
 				return err
 			}
 		}
@@ -772,6 +2759,13 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 			if err := f.write(ctx, sb, x); err != nil {
 				return err
 			}
+		} else if ctx.knownFunctionsOnly {
+			return &compileError{
+				source: ctx.source,
+				span:   x.Func.NameSpan,
+				err:    fmt.Errorf("unknown function %s", x.Func.Name),
+				code:   CodeUnknownFunction,
+			}
 		} else {
 			sb.WriteString(x.Func.Name)
 			sb.WriteString("(")
@@ -786,11 +2780,305 @@ func writeExpression(ctx *exprContext, sb *strings.Builder, x parser.Expr) error
 			sb.WriteString(")")
 		}
 	default:
-		fmt.Fprintf(sb, "NULL /* unhandled %T expression */", x)
+		span := parser.Span{Start: -1, End: -1}
+		if x != nil {
+			span = x.Span()
+		}
+		return ctx.unsupported(sb, span,
+			fmt.Sprintf("NULL /* unhandled %T expression */", x),
+			fmt.Errorf("unsupported %T expression", x))
+	}
+	return nil
+}
+
+// unsupported handles a construct that cannot be translated to SQL.
+// In strict mode, it returns an error that refers to span.
+// Otherwise, it writes fallback to sb,
+// so that the query compiles but the construct is visible in the SQL.
+func (ctx *exprContext) unsupported(sb *strings.Builder, span parser.Span, fallback string, err error) error {
+	if ctx.strict {
+		return &compileError{
+			source: ctx.source,
+			span:   span,
+			err:    err,
+			code:   CodeUnsupported,
+		}
+	}
+	sb.WriteString(fallback)
+	return nil
+}
+
+// lowersComparison reports whether an equality comparison
+// of the given operands compares the lower() of each operand.
+// lower() only accepts strings,
+// so operands that are known not to be strings are compared as is.
+func (ctx *exprContext) lowersComparison(operands ...parser.Expr) bool {
+	if ctx.stringComparison != CaseInsensitiveStringComparison {
+		return false
+	}
+	for _, x := range operands {
+		if ctx.isNonString(x) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNonString reports whether x is known not to be a string,
+// like a numeric literal or a column whose type is not a string type.
+func (ctx *exprContext) isNonString(x parser.Expr) bool {
+	switch x := x.(type) {
+	case *parser.BasicLit:
+		return x.Kind != parser.TokenString
+	case *parser.ParenExpr:
+		return ctx.isNonString(x.X)
+	case *parser.UnaryExpr:
+		return ctx.isNonString(x.X)
+	case *parser.QualifiedIdent:
+		if len(x.Parts) == 1 && !x.Parts[0].Quoted {
+			switch x.Parts[0].Name {
+			case "true", "false", "null":
+				return true
+			}
+		}
+		return ctx.nonStringColumns[x]
+	default:
+		return false
+	}
+}
+
+// writeEquality writes the comparison of x and y to sb.
+// Unless the context uses three-valued logic,
+// the comparison is false if either operand is NULL.
+// writeInArray writes x as an array membership test
+// if it has at least [CompileOptions.InListThreshold] literal values of the same kind.
+// It reports whether it wrote x.
+func writeInArray(ctx *exprContext, sb *strings.Builder, x *parser.InExpr) (bool, error) {
+	if ctx.inListThreshold <= 0 || len(x.Vals) < ctx.inListThreshold {
+		return false, nil
+	}
+	lower := ctx.lowersComparison(append([]parser.Expr{x.X}, x.Vals...)...)
+	values, ok := inListValues(x.Vals, lower)
+	if !ok {
+		return false, nil
+	}
+	var array string
+	if ctx.inListParameter != nil {
+		array = ctx.inListParameter(values)
+	} else {
+		array = arrayLiteral(ctx.dialect, values)
+	}
+
+	writeOperand := func() error {
+		if lower {
+			sb.WriteString("lower(")
+			if err := writeExpression(ctx, sb, x.X); err != nil {
+				return err
+			}
+			sb.WriteString(")")
+			return nil
+		}
+		return writeExpressionMaybeParen(ctx, sb, x.X)
+	}
+	switch ctx.dialect {
+	case PostgresDialect:
+		if err := writeOperand(); err != nil {
+			return true, err
+		}
+		sb.WriteString(" = ANY(")
+		sb.WriteString(array)
+		sb.WriteString(")")
+	case DuckDBDialect:
+		sb.WriteString("list_contains(")
+		sb.WriteString(array)
+		sb.WriteString(", ")
+		if err := writeOperand(); err != nil {
+			return true, err
+		}
+		sb.WriteString(")")
+	default:
+		sb.WriteString("has(")
+		sb.WriteString(array)
+		sb.WriteString(", ")
+		if err := writeOperand(); err != nil {
+			return true, err
+		}
+		sb.WriteString(")")
+	}
+	return true, nil
+}
+
+// inListValues returns the values of an in operator
+// as a []string, []int64, or []float64.
+// It returns false if the values are not all literals of the same kind.
+// If lower is true, only strings are accepted and they are lowercased,
+// which is limited to ASCII strings so that the result matches SQL's lower().
+func inListValues(vals []parser.Expr, lower bool) (any, bool) {
+	kind := parser.TokenString
+	if lit, ok := vals[0].(*parser.BasicLit); ok && lit.Kind == parser.TokenNumber && !lower {
+		kind = parser.TokenNumber
+	}
+	lits := make([]*parser.BasicLit, len(vals))
+	isFloat := false
+	for i, v := range vals {
+		lit, ok := v.(*parser.BasicLit)
+		if !ok || lit.Kind != kind {
+			return nil, false
+		}
+		lits[i] = lit
+		isFloat = isFloat || lit.IsFloat()
+	}
+
+	switch {
+	case kind == parser.TokenString:
+		strs := make([]string, len(lits))
+		for i, lit := range lits {
+			strs[i] = lit.Value
+			if lower {
+				if !isASCII(lit.Value) {
+					return nil, false
+				}
+				strs[i] = strings.ToLower(lit.Value)
+			}
+		}
+		return strs, true
+	case isFloat:
+		floats := make([]float64, len(lits))
+		for i, lit := range lits {
+			f, err := strconv.ParseFloat(lit.Value, 64)
+			if err != nil {
+				return nil, false
+			}
+			floats[i] = f
+		}
+		return floats, true
+	default:
+		ints := make([]int64, len(lits))
+		for i, lit := range lits {
+			n, err := strconv.ParseInt(lit.Value, 0, 64)
+			if err != nil {
+				return nil, false
+			}
+			ints[i] = n
+		}
+		return ints, true
+	}
+}
+
+// arrayLiteral returns the SQL for an array of the values returned by [inListValues].
+func arrayLiteral(dialect Dialect, values any) string {
+	sb := new(strings.Builder)
+	if dialect == PostgresDialect {
+		sb.WriteString("ARRAY")
+	}
+	sb.WriteString("[")
+	switch values := values.(type) {
+	case []string:
+		for i, v := range values {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			quoteSQLString(sb, v)
+		}
+	case []int64:
+		for i, v := range values {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(strconv.FormatInt(v, 10))
+		}
+	case []float64:
+		for i, v := range values {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func writeEquality(ctx *exprContext, sb *strings.Builder, x parser.Expr, op string, y parser.Expr) error {
+	if !ctx.threeValued {
+		sb.WriteString("coalesce(")
 	}
+	if err := writeExpressionMaybeParen(ctx, sb, x); err != nil {
+		return err
+	}
+	sb.WriteString(op)
+	if err := writeExpressionMaybeParen(ctx, sb, y); err != nil {
+		return err
+	}
+	if !ctx.threeValued {
+		sb.WriteString(", FALSE)")
+	}
+	return nil
+}
+
+// writeLowerComparison writes the comparison of the lower() of x and y to sb.
+func writeLowerComparison(ctx *exprContext, sb *strings.Builder, x parser.Expr, op string, y parser.Expr) error {
+	sb.WriteString("lower(")
+	if err := writeExpression(ctx, sb, x); err != nil {
+		return err
+	}
+	sb.WriteString(")")
+	sb.WriteString(op)
+	sb.WriteString("lower(")
+	if err := writeExpression(ctx, sb, y); err != nil {
+		return err
+	}
+	sb.WriteString(")")
 	return nil
 }
 
+// writeCaseInsensitiveComparison writes a =~ or !~ operation to sb
+// according to ctx.stringComparison.
+func writeCaseInsensitiveComparison(ctx *exprContext, sb *strings.Builder, x parser.Expr, negate bool, y parser.Expr) error {
+	switch ctx.stringComparison {
+	case CollationStringComparison:
+		op := " = "
+		if negate {
+			op = " <> "
+		}
+		if err := writeExpressionMaybeParen(ctx, sb, x); err != nil {
+			return err
+		}
+		sb.WriteString(op)
+		return writeExpressionMaybeParen(ctx, sb, y)
+	case ILikeStringComparison:
+		lit, ok := y.(*parser.BasicLit)
+		if !ok || lit.Kind != parser.TokenString {
+			break
+		}
+		if err := writeExpressionMaybeParen(ctx, sb, x); err != nil {
+			return err
+		}
+		if negate {
+			sb.WriteString(" NOT")
+		}
+		sb.WriteString(" ILIKE ")
+		quoteSQLString(sb, likeEscaper.Replace(lit.Value))
+		return nil
+	}
+	if negate {
+		return writeLowerComparison(ctx, sb, x, " <> ", y)
+	}
+	return writeLowerComparison(ctx, sb, x, " = ", y)
+}
+
+// likeEscaper escapes the wildcard characters in a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 // writeExpressionMaybeParen writes an expression to sb,
 // surrounding it with parentheses if sufficiently complex.
 func writeExpressionMaybeParen(ctx *exprContext, sb *strings.Builder, x parser.Expr) error {
@@ -799,7 +3087,7 @@ func writeExpressionMaybeParen(ctx *exprContext, sb *strings.Builder, x parser.E
 		if !ok {
 			break
 		}
-		x = p
+		x = p.X
 	}
 
 	switch x := x.(type) {
@@ -824,6 +3112,15 @@ type functionRewrite struct {
 
 	// needsParens should be true if the output SQL can have a binary operator.
 	needsParens bool
+	// aggregate is true if the function combines the values of a group of rows,
+	// like in a summarize operator.
+	aggregate bool
+
+	// signature is a human-readable summary of the function's arguments,
+	// like "strcat(x, ...)".
+	signature string
+	// doc is a short, one sentence description of the function.
+	doc string
 }
 
 var knownFunctions struct {
@@ -834,17 +3131,70 @@ var knownFunctions struct {
 func initKnownFunctions() map[string]*functionRewrite {
 	knownFunctions.init.Do(func() {
 		knownFunctions.m = map[string]*functionRewrite{
-			"count":     {write: writeCountFunction},
-			"countif":   {write: writeCountIfFunction},
-			"iif":       {write: writeIfFunction, needsParens: true},
-			"iff":       {write: writeIfFunction, needsParens: true},
-			"isnotnull": {write: writeIsNotNullFunction, needsParens: true},
-			"isnull":    {write: writeIsNullFunction, needsParens: true},
-			"not":       {write: writeNotFunction},
-			"now":       {write: writeNowFunction},
-			"strcat":    {write: writeStrcatFunction, needsParens: true},
-			"tolower":   {write: writeToLowerFunction, needsParens: true},
-			"toupper":   {write: writeToUpperFunction, needsParens: true},
+			"count": {
+				write:     writeCountFunction,
+				aggregate: true,
+				signature: "count()",
+				doc:       "Returns the number of records in the group.",
+			},
+			"countif": {
+				write:     writeCountIfFunction,
+				aggregate: true,
+				signature: "countif(predicate)",
+				doc:       "Returns the number of records in the group for which predicate is true.",
+			},
+			"iif": {
+				write:       writeIfFunction,
+				needsParens: true,
+				signature:   "iif(if, then, else)",
+				doc:         "Returns then if the condition is true, otherwise returns else.",
+			},
+			"iff": {
+				write:       writeIfFunction,
+				needsParens: true,
+				signature:   "iff(if, then, else)",
+				doc:         "Returns then if the condition is true, otherwise returns else.",
+			},
+			"isnotnull": {
+				write:       writeIsNotNullFunction,
+				needsParens: true,
+				signature:   "isnotnull(x)",
+				doc:         "Reports whether x is not null.",
+			},
+			"isnull": {
+				write:       writeIsNullFunction,
+				needsParens: true,
+				signature:   "isnull(x)",
+				doc:         "Reports whether x is null.",
+			},
+			"not": {
+				write:     writeNotFunction,
+				signature: "not(x)",
+				doc:       "Reverses the value of its boolean argument.",
+			},
+			"now": {
+				write:     writeNowFunction,
+				signature: "now()",
+				doc:       "Returns the current time.",
+			},
+			"strcat": {
+				write:       writeStrcatFunction,
+				needsParens: true,
+				signature:   "strcat(x, ...)",
+				doc:         "Concatenates its string arguments.",
+			},
+			"tolower": {
+				write:       writeToLowerFunction,
+				needsParens: true,
+				signature:   "tolower(s)",
+				doc:         "Converts a string to lower case.",
+			},
+			"toupper": {
+				write:       writeToUpperFunction,
+				needsParens: true,
+				signature:   "toupper(s)",
+				doc:         "Converts a string to upper case.",
+			},
 		}
 	})
 	return knownFunctions.m
@@ -858,7 +3208,8 @@ func writeNotFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallExpr)
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("not(x) takes a single argument (got %d)", len(x.Args)),
+			err:  fmt.Errorf("not(x) takes a single argument (got %d)", len(x.Args)),
+			code: CodeArgumentCount,
 		}
 	}
 	sb.WriteString("NOT ")
@@ -876,7 +3227,8 @@ func writeNowFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallExpr)
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("now()) takes a no arguments (got %d)", len(x.Args)),
+			err:  fmt.Errorf("now()) takes a no arguments (got %d)", len(x.Args)),
+			code: CodeArgumentCount,
 		}
 	}
 	sb.WriteString("CURRENT_TIMESTAMP")
@@ -891,7 +3243,8 @@ func writeIsNullFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallEx
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("isnull(x) takes a single argument (got %d)", len(x.Args)),
+			err:  fmt.Errorf("isnull(x) takes a single argument (got %d)", len(x.Args)),
+			code: CodeArgumentCount,
 		}
 	}
 	if err := writeExpressionMaybeParen(ctx, sb, x.Args[0]); err != nil {
@@ -909,7 +3262,8 @@ func writeIsNotNullFunction(ctx *exprContext, sb *strings.Builder, x *parser.Cal
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("isnotnull(x) takes a single argument (got %d)", len(x.Args)),
+			err:  fmt.Errorf("isnotnull(x) takes a single argument (got %d)", len(x.Args)),
+			code: CodeArgumentCount,
 		}
 	}
 	if err := writeExpressionMaybeParen(ctx, sb, x.Args[0]); err != nil {
@@ -927,7 +3281,8 @@ func writeStrcatFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallEx
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("strcat(x) takes least one argument"),
+			err:  fmt.Errorf("strcat(x) takes least one argument"),
+			code: CodeArgumentCount,
 		}
 	}
 	if err := writeExpressionMaybeParen(ctx, sb, x.Args[0]); err != nil {
@@ -950,13 +3305,22 @@ func writeCountFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallExp
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("count() takes no arguments (got %d)", len(x.Args)),
+			err:  fmt.Errorf("count() takes no arguments (got %d)", len(x.Args)),
+			code: CodeArgumentCount,
 		}
 	}
-	sb.WriteString("count()")
+	sb.WriteString(ctx.countAll())
 	return nil
 }
 
+// countAll returns the aggregate call that counts every row.
+func (ctx *exprContext) countAll() string {
+	if ctx.dialect == ClickHouseDialect {
+		return "count()"
+	}
+	return "count(*)"
+}
+
 func writeCountIfFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallExpr) error {
 	if len(x.Args) != 1 {
 		return &compileError{
@@ -965,10 +3329,12 @@ func writeCountIfFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallE
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("countif(x) takes a single argument (got %d)", len(x.Args)),
+			err:  fmt.Errorf("countif(x) takes a single argument (got %d)", len(x.Args)),
+			code: CodeArgumentCount,
 		}
 	}
-	sb.WriteString("count() FILTER (WHERE ")
+	sb.WriteString(ctx.countAll())
+	sb.WriteString(" FILTER (WHERE ")
 	if err := writeExpression(ctx, sb, x.Args[0]); err != nil {
 		return err
 	}
@@ -984,7 +3350,8 @@ func writeIfFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallExpr)
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("%s(if, then, else) takes 3 arguments (got %d)", x.Func.Name, len(x.Args)),
+			err:  fmt.Errorf("%s(if, then, else) takes 3 arguments (got %d)", x.Func.Name, len(x.Args)),
+			code: CodeArgumentCount,
 		}
 	}
 	sb.WriteString("CASE WHEN coalesce(")
@@ -1011,7 +3378,8 @@ func writeToLowerFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallE
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("tolower(x) takes a single argument (got %d)", len(x.Args)),
+			err:  fmt.Errorf("tolower(x) takes a single argument (got %d)", len(x.Args)),
+			code: CodeArgumentCount,
 		}
 	}
 	sb.WriteString("LOWER(")
@@ -1030,7 +3398,8 @@ func writeToUpperFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallE
 				Start: x.Lparen.End,
 				End:   x.Rparen.Start,
 			},
-			err: fmt.Errorf("toupper(x) takes a single argument (got %d)", len(x.Args)),
+			err:  fmt.Errorf("toupper(x) takes a single argument (got %d)", len(x.Args)),
+			code: CodeArgumentCount,
 		}
 	}
 	sb.WriteString("UPPER(")
@@ -1042,49 +3411,92 @@ func writeToUpperFunction(ctx *exprContext, sb *strings.Builder, x *parser.CallE
 }
 
 func quoteSQLString(sb *strings.Builder, s string) {
-	sb.WriteString("'")
-	for _, b := range []byte(s) {
-		if b == '\'' {
-			sb.WriteString("''")
-		} else {
-			sb.WriteByte(b)
-		}
-	}
-	sb.WriteString("'")
+	sb.WriteByte('\'')
+	writeEscaped(sb, s, '\'')
+	sb.WriteByte('\'')
 }
 
+// Diagnostic codes produced by the compiler,
+// in addition to the parser's codes like [parser.CodeSyntax].
+const (
+	// CodeUnsupported is the code for a valid query that uses a feature
+	// the compiler does not support.
+	CodeUnsupported = "unsupported"
+	// CodeInvalidIdentifier is the code for an identifier
+	// that is unknown or not permitted in its context.
+	CodeInvalidIdentifier = "invalid-identifier"
+	// CodeArgumentCount is the code for a function call
+	// with the wrong number of arguments.
+	CodeArgumentCount = "argument-count"
+	// CodeUnknownFunction is the code for a call to a function
+	// that pql does not recognize
+	// when [CompileOptions.DisallowUnknownFunctions] is set.
+	CodeUnknownFunction = "unknown-function"
+	// CodeUnknownTable is the code for a table wildcard or table() call
+	// that does not match any known tables,
+	// or a table reference that [*AnalysisContext.Check] cannot find.
+	CodeUnknownTable = "unknown-table"
+	// CodeUnknownColumn is the code for a column reference
+	// that [*AnalysisContext.Check] cannot find in the operator's input.
+	CodeUnknownColumn = "unknown-column"
+	// CodeInvalidRowCount is the code for a take or top operator
+	// whose row count is not a non-negative integer.
+	CodeInvalidRowCount = "invalid-row-count"
+	// CodeIgnoredHint is the code for a warning about a hint, like a join hint,
+	// that the compiler does not use.
+	CodeIgnoredHint = "ignored-hint"
+	// CodeKQLDivergence is the code for a warning about a construct
+	// that behaves differently than in KQL
+	// when [CompileOptions.KQLCompatibility] is set.
+	CodeKQLDivergence = "kql-divergence"
+)
+
 type compileError struct {
 	source string
 	span   parser.Span
 	err    error
+	code   string
 }
 
 func (e *compileError) Error() string {
 	if !e.span.IsValid() {
 		return e.err.Error()
 	}
-	line, col := linecol(e.source, e.span.Start)
-	return fmt.Sprintf("%d:%d: %s", line, col, e.err.Error())
+	return fmt.Sprintf("%v: %s", e.Position(), e.err.Error())
 }
 
-func (e *compileError) Unwrap() error {
-	return e.err
+// Span returns the span of the source that the error refers to.
+// The span may be invalid if the error does not refer to a specific location.
+func (e *compileError) Span() parser.Span {
+	return e.span
 }
 
-func linecol(source string, pos int) (line, col int) {
-	line, col = 1, 1
-	for _, c := range source[:pos] {
-		switch c {
-		case '\n':
-			line++
-			col = 1
-		case '\t':
-			const tabWidth = 8
-			tabLoc := (col - 1) % tabWidth
-			col += tabWidth - tabLoc
-		default:
-			col++
-		}
+// Position returns the position of the start of the error's span
+// or the zero Position if the span is invalid.
+func (e *compileError) Position() parser.Position {
+	if !e.span.IsValid() {
+		return parser.Position{}
 	}
-	return
+	return parser.PositionFor(e.source, e.span.Start)
+}
+
+// Source returns the query that the error's span refers to.
+// For an error in the body of a [StoredFunction],
+// it is the query that called the function.
+func (e *compileError) Source() string {
+	return e.source
+}
+
+// Diagnostic returns the diagnostic described by the error.
+func (e *compileError) Diagnostic() parser.Diagnostic {
+	return parser.Diagnostic{
+		Span:     e.span,
+		Severity: parser.SeverityError,
+		Code:     e.code,
+		Message:  e.err.Error(),
+	}
+}
+
+func (e *compileError) Unwrap() error {
+	return e.err
 }
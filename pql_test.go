@@ -4,8 +4,14 @@
 package pql
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/runreveal/pql/parser"
 )
 
 func TestQuoteSQLString(t *testing.T) {
@@ -25,3 +31,1393 @@ func TestQuoteSQLString(t *testing.T) {
 		}
 	}
 }
+
+func TestCompileErrorPosition(t *testing.T) {
+	const source = "StormEvents\n| where now(1)"
+	_, err := Compile(source)
+	if err == nil {
+		t.Fatalf("Compile(%q) did not return an error", source)
+	}
+	var perr parser.PositionedError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Compile(%q) error = %v; want a parser.PositionedError", source, err)
+	}
+	if got, want := perr.Span(), (parser.Span{Start: 24, End: 25}); got != want {
+		t.Errorf("Compile(%q) error span = %v; want %v", source, got, want)
+	}
+	if got, want := perr.Position(), (parser.Position{Line: 2, Column: 13}); got != want {
+		t.Errorf("Compile(%q) error position = %v; want %v", source, got, want)
+	}
+	if got := perr.Source(); got != source {
+		t.Errorf("Compile(%q) error source = %q; want %q", source, got, source)
+	}
+}
+
+func TestCompileDiagnostics(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []parser.Diagnostic
+	}{
+		{
+			source: "StormEvents | where now(1)",
+			want: []parser.Diagnostic{{
+				Span:     parser.Span{Start: 24, End: 25},
+				Severity: parser.SeverityError,
+				Code:     CodeArgumentCount,
+				Message:  "now()) takes a no arguments (got 1)",
+			}},
+		},
+		{
+			source: "StormEvents | bork | where",
+			want: []parser.Diagnostic{
+				{
+					Span:     parser.Span{Start: 14, End: 18},
+					Severity: parser.SeverityError,
+					Code:     parser.CodeUnknownOperator,
+					Message:  `unknown operator name "bork"`,
+				},
+				{
+					Span:     parser.Span{Start: 26, End: 26},
+					Severity: parser.SeverityError,
+					Code:     parser.CodeSyntax,
+					Message:  "expected expression, got EOF",
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.source)
+		if err == nil {
+			t.Errorf("Compile(%q) did not return an error", test.source)
+			continue
+		}
+		got := parser.Diagnostics(err)
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("parser.Diagnostics(Compile(%q)) (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
+
+func TestCompileDisallowUnknownFunctions(t *testing.T) {
+	const source = "StormEvents | where bork(State) and isnotnull(State)"
+	if _, err := Compile(source); err != nil {
+		t.Fatalf("Compile(%q): %v", source, err)
+	}
+	opts := &CompileOptions{DisallowUnknownFunctions: true}
+	_, err := opts.Compile(source)
+	if err == nil {
+		t.Fatalf("opts.Compile(%q) did not return an error", source)
+	}
+	want := []parser.Diagnostic{{
+		Span:     parser.Span{Start: 20, End: 24},
+		Severity: parser.SeverityError,
+		Code:     CodeUnknownFunction,
+		Message:  "unknown function bork",
+	}}
+	if diff := cmp.Diff(want, parser.Diagnostics(err)); diff != "" {
+		t.Errorf("parser.Diagnostics(opts.Compile(%q)) (-want +got):\n%s", source, diff)
+	}
+}
+
+func TestCompileTableWildcards(t *testing.T) {
+	opts := &CompileOptions{
+		AnalysisContext: &AnalysisContext{
+			Tables: map[string]*AnalysisTable{
+				"Events_2023": {},
+				"Events_2024": {},
+				"Users":       {},
+			},
+			Databases: map[string]*AnalysisDatabase{
+				"archive": {
+					Tables: map[string]*AnalysisTable{
+						"Events_2022": {},
+					},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		source string
+		want   string
+		code   string
+	}{
+		{
+			source: "Events_* | count",
+			want:   `SELECT COUNT(*) AS "count()" FROM (SELECT * FROM "Events_2023" UNION ALL SELECT * FROM "Events_2024") AS "__union";`,
+		},
+		{
+			source: "*_2024",
+			want:   `SELECT * FROM "Events_2024";`,
+		},
+		{
+			source: "archive.Events_*",
+			want:   `SELECT * FROM "archive"."Events_2022";`,
+		},
+		{
+			source: "let suffix = \"2024\";\ntable(\"Events_\" + suffix)",
+			want:   `SELECT * FROM "Events_2024";`,
+		},
+		{
+			source: `table("Event*")`,
+			want:   `SELECT * FROM (SELECT * FROM "Events_2023" UNION ALL SELECT * FROM "Events_2024") AS "__union";`,
+		},
+		{
+			source: "Logs_*",
+			code:   CodeUnknownTable,
+		},
+		{
+			source: "table(suffix)",
+			code:   CodeUnsupported,
+		},
+	}
+	for _, test := range tests {
+		got, err := opts.Compile(test.source)
+		if test.code != "" {
+			diags := parser.Diagnostics(err)
+			if len(diags) != 1 || diags[0].Code != test.code {
+				t.Errorf("Compile(%q) = %q, %v; want error with code %q", test.source, got, err, test.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Compile(%q) = %q; want %q", test.source, got, test.want)
+		}
+	}
+
+	if _, err := Compile("Events_*"); err == nil {
+		t.Error("Compile(\"Events_*\") without an analysis context did not return an error")
+	}
+}
+
+func TestCompileClusterQualifiedTable(t *testing.T) {
+	const source = `cluster("primary").database("security").Events | count`
+	got, err := Compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `SELECT COUNT(*) AS "count()" FROM cluster('primary', 'security', 'Events');`
+	if got != want {
+		t.Errorf("Compile(%q) = %q; want %q", source, got, want)
+	}
+}
+
+func TestUnsupportedConstructs(t *testing.T) {
+	const source = "*x"
+	x := &parser.UnaryExpr{
+		OpSpan: parser.Span{Start: 0, End: 1},
+		Op:     parser.TokenStar,
+		X: &parser.QualifiedIdent{
+			Parts: []*parser.Ident{{
+				Name:     "x",
+				NameSpan: parser.Span{Start: 1, End: 2},
+			}},
+		},
+	}
+
+	sb := new(strings.Builder)
+	if err := writeExpression(&exprContext{source: source}, sb, x); err != nil {
+		t.Errorf("writeExpression(...) in non-strict mode: %v", err)
+	}
+	if got, want := sb.String(), `/* unhandled TokenStar unary op */ "x"`; got != want {
+		t.Errorf("writeExpression(...) in non-strict mode wrote %q; want %q", got, want)
+	}
+
+	sb.Reset()
+	err := writeExpression(&exprContext{source: source, strict: true}, sb, x)
+	want := []parser.Diagnostic{{
+		Span:     parser.Span{Start: 0, End: 1},
+		Severity: parser.SeverityError,
+		Code:     CodeUnsupported,
+		Message:  "unsupported unary operator TokenStar",
+	}}
+	if diff := cmp.Diff(want, parser.Diagnostics(err)); diff != "" {
+		t.Errorf("writeExpression(...) in strict mode diagnostics (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompileDoesNotFuseShadowedFilter(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			source: "T | where x > 0 | extend x = 5",
+			want: `WITH "__subquery0" AS (SELECT * FROM "T" WHERE "x" > 0)` + "\n" +
+				`SELECT *, 5 AS "x" FROM "__subquery0";`,
+		},
+		{
+			source: "T | where n > 0 | summarize n = count() by k",
+			want: `WITH "__subquery0" AS (SELECT * FROM "T" WHERE "n" > 0)` + "\n" +
+				`SELECT "k" AS "k", count() AS "n" FROM "__subquery0" GROUP BY "k";`,
+		},
+		{
+			source: "T | where k > 0 | summarize n = count() by k",
+			want:   `SELECT "k" AS "k", count() AS "n" FROM "T" WHERE "k" > 0 GROUP BY "k";`,
+		},
+	}
+	for _, test := range tests {
+		got, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Compile(%q) = %q; want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestCompileExtendFilterDialect(t *testing.T) {
+	const source = "T | extend y = x + 1 | where y > 2"
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{
+			dialect: ClickHouseDialect,
+			want:    `SELECT *, "x" + 1 AS "y" FROM "T" WHERE "y" > 2;`,
+		},
+		{
+			// PostgreSQL does not resolve column aliases in the WHERE clause.
+			dialect: PostgresDialect,
+			want: `WITH "__subquery0" AS (SELECT *, "x" + 1 AS "y" FROM "T")` + "\n" +
+				`SELECT * FROM "__subquery0" WHERE "y" > 2;`,
+		},
+	}
+	for _, test := range tests {
+		opts := &CompileOptions{Dialect: test.dialect}
+		got, err := opts.Compile(source)
+		if err != nil {
+			t.Errorf("Compile(%q) with %v: %v", source, test.dialect, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) with %v (-want +got):\n%s", source, test.dialect, diff)
+		}
+	}
+}
+
+func TestCompilePredicatePushdown(t *testing.T) {
+	opts := &CompileOptions{
+		AnalysisContext: &AnalysisContext{
+			Tables: map[string]*AnalysisTable{
+				"Users": {
+					Columns: []*AnalysisColumn{{Name: "id"}, {Name: "name"}},
+				},
+				"Logins": {
+					Columns: []*AnalysisColumn{{Name: "id"}, {Name: "time"}, {Name: "success"}},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			source: `Users | join kind=inner (Logins) on id | where name == "alice" and success and time > name`,
+			want: `WITH "__subquery0" AS (SELECT * FROM "Users" WHERE coalesce("name" = 'alice', FALSE)),` + "\n" +
+				`     "__subquery1" AS (SELECT * FROM "Logins" WHERE "success"),` + "\n" +
+				`     "__subquery2" AS (SELECT * FROM "__subquery0" AS "$left" JOIN "__subquery1" AS "$right" ON "$left"."id" = "$right"."id")` + "\n" +
+				`SELECT * FROM "__subquery2" WHERE "time" > "name";`,
+		},
+		{
+			// Filters on the right side of a left outer join
+			// must see the rows that did not match.
+			source: `Users | join kind=leftouter (Logins) on id | where success and id > 5`,
+			want: `WITH "__subquery0" AS (SELECT * FROM "Users" WHERE "id" > 5),` + "\n" +
+				`     "__subquery1" AS (SELECT * FROM "Logins"),` + "\n" +
+				`     "__subquery2" AS (SELECT * FROM "__subquery0" AS "$left" LEFT JOIN "__subquery1" AS "$right" ON "$left"."id" = "$right"."id")` + "\n" +
+				`SELECT * FROM "__subquery2" WHERE "success";`,
+		},
+		{
+			// The output of a right semi join has the right side's columns,
+			// even where the left side has a column of the same name.
+			source: `Users | join kind=rightsemi (Logins) on id | where id > 5`,
+			want: `WITH "__subquery0" AS (SELECT * FROM "Logins" WHERE "id" > 5)` + "\n" +
+				`SELECT * FROM "__subquery0" AS "$right" WHERE ("$right"."id") IN (SELECT "$left"."id" FROM "Users" AS "$left");`,
+		},
+		{
+			// After a right outer join, id is the left side's column,
+			// which is null for the right side's unmatched rows.
+			source: `Users | join kind=rightouter (Logins) on id | where id > 5 and success`,
+			want: `WITH "__subquery0" AS (SELECT * FROM "Logins" WHERE "success"),` + "\n" +
+				`     "__subquery1" AS (SELECT * FROM "Users" AS "$left" RIGHT JOIN "__subquery0" AS "$right" ON "$left"."id" = "$right"."id")` + "\n" +
+				`SELECT * FROM "__subquery1" WHERE "id" > 5;`,
+		},
+		{
+			source: `Users | join kind=inner (Unknown) on id | where name == "alice"`,
+			want: `WITH "__subquery0" AS (SELECT * FROM "Unknown"),` + "\n" +
+				`     "__subquery1" AS (SELECT * FROM "Users" AS "$left" JOIN "__subquery0" AS "$right" ON "$left"."id" = "$right"."id")` + "\n" +
+				`SELECT * FROM "__subquery1" WHERE coalesce("name" = 'alice', FALSE);`,
+		},
+	}
+	for _, test := range tests {
+		got, err := opts.Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
+
+func TestCompileSubqueryName(t *testing.T) {
+	const source = "T | where x > 0 | extend x = 5 | where y > 0 | extend y = 5"
+	opts := &CompileOptions{
+		SubqueryName: func(index int, sql string) string {
+			return fmt.Sprintf("q%d", index)
+		},
+	}
+	got, err := opts.Compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `WITH "q0" AS (SELECT * FROM "T" WHERE "x" > 0),` + "\n" +
+		`     "q1" AS (SELECT *, 5 AS "x" FROM "q0" WHERE "y" > 0)` + "\n" +
+		`SELECT *, 5 AS "y" FROM "q1";`
+	if got != want {
+		t.Errorf("Compile(%q) = %q; want %q", source, got, want)
+	}
+
+	opts.SubqueryName = HashSubqueryName
+	first, err := opts.Compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := opts.Compile("let z = 1;\n" + source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("HashSubqueryName names changed after adding an unrelated statement:\n%s\n%s", first, second)
+	}
+
+	opts.SubqueryName = func(index int, sql string) string { return "U" }
+	if _, err := opts.Compile("let U = T;\n" + source); err == nil {
+		t.Error("Compile did not return an error for a subquery name that collides with a let statement")
+	}
+}
+
+func TestCompileInlineSubqueries(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			source: "T | where x > 0 | extend x = 5",
+			want:   `SELECT *, 5 AS "x" FROM (SELECT * FROM "T" WHERE "x" > 0) AS "__subquery0";`,
+		},
+		{
+			source: "let U = T | where x > 0;\nU | join kind=inner (U) on k",
+			want: `SELECT * FROM (SELECT * FROM "T" WHERE "x" > 0) AS "$left" ` +
+				`JOIN (SELECT * FROM (SELECT * FROM "T" WHERE "x" > 0) AS "U") AS "$right" ` +
+				`ON "$left"."k" = "$right"."k";`,
+		},
+	}
+	opts := &CompileOptions{InlineSubqueries: true}
+	for _, test := range tests {
+		got, err := opts.Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Compile(%q) = %q; want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestCompileFormat(t *testing.T) {
+	const source = "T | where s == 'a  (b)' | extend s = 5 | summarize n = count() by s"
+	tests := []struct {
+		format SQLFormat
+		want   string
+	}{
+		{
+			format: CompactSQLFormat,
+			want: `WITH "__subquery0" AS (SELECT * FROM "T" WHERE coalesce("s" = 'a  (b)', FALSE)), ` +
+				`"__subquery1" AS (SELECT *, 5 AS "s" FROM "__subquery0") ` +
+				`SELECT "s" AS "s", count() AS "n" FROM "__subquery1" GROUP BY "s";`,
+		},
+		{
+			format: PrettySQLFormat,
+			want: "WITH \"__subquery0\" AS (\n" +
+				"  SELECT *\n" +
+				"  FROM \"T\"\n" +
+				"  WHERE coalesce(\"s\" = 'a  (b)', FALSE)\n" +
+				"),\n" +
+				"\"__subquery1\" AS (\n" +
+				"  SELECT *, 5 AS \"s\"\n" +
+				"  FROM \"__subquery0\"\n" +
+				")\n" +
+				"SELECT \"s\" AS \"s\", count() AS \"n\"\n" +
+				"FROM \"__subquery1\"\n" +
+				"GROUP BY \"s\";",
+		},
+	}
+	for _, test := range tests {
+		opts := &CompileOptions{Format: test.format}
+		got, err := opts.Compile(source)
+		if err != nil {
+			t.Errorf("Compile(%q) with format %d: %v", source, test.format, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) with format %d (-want +got):\n%s", source, test.format, diff)
+		}
+	}
+}
+
+func TestCompileSplit(t *testing.T) {
+	const source = "T | where x > 1 | where y > 2 | project x | sort by x | take 5"
+	tests := []struct {
+		split SplitStrategy
+		want  string
+	}{
+		{
+			split: FusedSplit,
+			want: `WITH "__subquery0" AS (SELECT "x" AS "x" FROM "T" WHERE ("x" > 1) AND ("y" > 2))` + "\n" +
+				`SELECT * FROM "__subquery0" ORDER BY "x" DESC NULLS LAST LIMIT 5;`,
+		},
+		{
+			split: RequiredSplit,
+			want: `WITH "__subquery0" AS (SELECT * FROM "T" WHERE "x" > 1),` + "\n" +
+				`     "__subquery1" AS (SELECT * FROM "__subquery0" WHERE "y" > 2),` + "\n" +
+				`     "__subquery2" AS (SELECT "x" AS "x" FROM "__subquery1")` + "\n" +
+				`SELECT * FROM "__subquery2" ORDER BY "x" DESC NULLS LAST LIMIT 5;`,
+		},
+		{
+			split: AlwaysSplit,
+			want: `WITH "__subquery0" AS (SELECT * FROM "T" WHERE "x" > 1),` + "\n" +
+				`     "__subquery1" AS (SELECT * FROM "__subquery0" WHERE "y" > 2),` + "\n" +
+				`     "__subquery2" AS (SELECT "x" AS "x" FROM "__subquery1"),` + "\n" +
+				`     "__subquery3" AS (SELECT * FROM "__subquery2" ORDER BY "x" DESC NULLS LAST)` + "\n" +
+				`SELECT * FROM "__subquery3" LIMIT 5;`,
+		},
+	}
+	for _, test := range tests {
+		opts := &CompileOptions{Split: test.split}
+		got, err := opts.Compile(source)
+		if err != nil {
+			t.Errorf("Compile(%q) with split %d: %v", source, test.split, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) with split %d (-want +got):\n%s", source, test.split, diff)
+		}
+	}
+}
+
+func TestCompileDistinct(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			source: "T | where x > 1 | distinct x, y | take 5",
+			want:   `SELECT DISTINCT "x" AS "x", "y" AS "y" FROM "T" WHERE "x" > 1 LIMIT 5;`,
+		},
+		{
+			source: "T | distinct x | sort by x",
+			want: `WITH "__subquery0" AS (SELECT DISTINCT "x" AS "x" FROM "T")` + "\n" +
+				`SELECT * FROM "__subquery0" ORDER BY "x" DESC NULLS LAST;`,
+		},
+	}
+	for _, test := range tests {
+		got, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
+
+func TestCompileProjectAway(t *testing.T) {
+	const source = "People | where Age > 18 | project-away Age"
+	tests := []struct {
+		opts *CompileOptions
+		want string
+	}{
+		{
+			opts: &CompileOptions{Dialect: ClickHouseDialect},
+			want: `SELECT * EXCEPT ("Age") FROM "People" WHERE "Age" > 18;`,
+		},
+		{
+			opts: &CompileOptions{Dialect: DuckDBDialect},
+			want: `SELECT * EXCLUDE ("Age") FROM "People" WHERE "Age" > 18;`,
+		},
+		{
+			opts: &CompileOptions{
+				Dialect: PostgresDialect,
+				AnalysisContext: &AnalysisContext{
+					Tables: map[string]*AnalysisTable{
+						"People": {
+							Columns: []*AnalysisColumn{
+								{Name: "Name", Type: "text"},
+								{Name: "Age", Type: "bigint"},
+							},
+						},
+					},
+				},
+			},
+			want: `SELECT "Name" FROM "People" WHERE "Age" > 18;`,
+		},
+	}
+	for _, test := range tests {
+		got, err := test.opts.Compile(source)
+		if err != nil {
+			t.Errorf("Compile(%q) with %v: %v", source, test.opts.Dialect, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) with %v (-want +got):\n%s", source, test.opts.Dialect, diff)
+		}
+	}
+
+	opts := &CompileOptions{Dialect: PostgresDialect}
+	if _, err := opts.Compile(source); err == nil {
+		t.Errorf("Compile(%q) with postgres and no AnalysisContext did not return an error", source)
+	}
+}
+
+func TestCompileMvExpand(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		source  string
+		want    string
+	}{
+		{
+			dialect: ClickHouseDialect,
+			source:  "T | mv-expand tags, ip = ips | take 5",
+			want:    `SELECT *, "ip" FROM "T" ARRAY JOIN "tags", "ips" AS "ip" LIMIT 5;`,
+		},
+		{
+			dialect: DuckDBDialect,
+			source:  "T | mv-expand tags, ip = ips | take 5",
+			want:    `SELECT * REPLACE (unnest("tags") AS "tags"), unnest("ips") AS "ip" FROM "T" LIMIT 5;`,
+		},
+		{
+			dialect: PostgresDialect,
+			source:  "T | mv-expand tag = tags | sort by tag",
+			want:    `SELECT *, unnest("tags") AS "tag" FROM "T" ORDER BY "tag" DESC NULLS LAST;`,
+		},
+	}
+	for _, test := range tests {
+		opts := &CompileOptions{Dialect: test.dialect}
+		got, err := opts.Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q) with %v: %v", test.source, test.dialect, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) with %v (-want +got):\n%s", test.source, test.dialect, diff)
+		}
+	}
+
+	opts := &CompileOptions{Dialect: PostgresDialect}
+	if _, err := opts.Compile("T | mv-expand tags"); err == nil {
+		t.Error("Compile(\"T | mv-expand tags\") with postgres did not return an error")
+	}
+}
+
+func TestCompileUnion(t *testing.T) {
+	opts := &CompileOptions{
+		AnalysisContext: &AnalysisContext{
+			Tables: map[string]*AnalysisTable{
+				"Events_2023": {},
+				"Events_2024": {},
+				"Users":       {},
+			},
+		},
+	}
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			source: "Users | union Events_2023, Events_2024",
+			want:   `SELECT * FROM (SELECT * FROM "Users" UNION ALL SELECT * FROM "Events_2023" UNION ALL SELECT * FROM "Events_2024") AS "__union";`,
+		},
+		{
+			source: "union withsource=SourceTable Users, Events_*",
+			want: `SELECT * FROM (SELECT 'Users' AS "SourceTable", * FROM "Users" ` +
+				`UNION ALL SELECT 'Events_2023' AS "SourceTable", * FROM "Events_2023" ` +
+				`UNION ALL SELECT 'Events_2024' AS "SourceTable", * FROM "Events_2024") AS "__union";`,
+		},
+		{
+			source: "Users | union withsource=T Events_2023",
+			want:   `SELECT * FROM (SELECT 'union_arg0' AS "T", * FROM "Users" UNION ALL SELECT 'Events_2023' AS "T", * FROM "Events_2023") AS "__union";`,
+		},
+		{
+			source: "Users | take 5 | union withsource=T (Events_2024 | count) | count",
+			want: "WITH \"__subquery0\" AS (SELECT * FROM \"Users\" LIMIT 5),\n" +
+				"     \"__subquery1\" AS (SELECT COUNT(*) AS \"count()\" FROM \"Events_2024\"),\n" +
+				"     \"__subquery2\" AS (SELECT * FROM (SELECT 'union_arg0' AS \"T\", * FROM \"__subquery0\" UNION ALL SELECT 'union_arg1' AS \"T\", * FROM \"__subquery1\") AS \"__union\")\n" +
+				"SELECT COUNT(*) AS \"count()\" FROM \"__subquery2\";",
+		},
+		{
+			source: "let recent = Events_2024 | take 1;\nunion withsource=T recent, Users",
+			want: "WITH \"recent\" AS (SELECT * FROM \"Events_2024\" LIMIT 1)\n" +
+				"SELECT * FROM (SELECT 'recent' AS \"T\", * FROM \"recent\" UNION ALL SELECT 'Users' AS \"T\", * FROM \"Users\") AS \"__union\";",
+		},
+	}
+	for _, test := range tests {
+		got, err := opts.Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
+
+func TestCompileDialect(t *testing.T) {
+	const source = "T | summarize n = count(), big = countif(x > 1) by k"
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{
+			dialect: ClickHouseDialect,
+			want:    `SELECT "k" AS "k", count() AS "n", count() FILTER (WHERE "x" > 1) AS "big" FROM "T" GROUP BY "k";`,
+		},
+		{
+			dialect: PostgresDialect,
+			want:    `SELECT "k" AS "k", count(*) AS "n", count(*) FILTER (WHERE "x" > 1) AS "big" FROM "T" GROUP BY "k";`,
+		},
+		{
+			dialect: DuckDBDialect,
+			want:    `SELECT "k" AS "k", count(*) AS "n", count(*) FILTER (WHERE "x" > 1) AS "big" FROM "T" GROUP BY "k";`,
+		},
+	}
+	for _, test := range tests {
+		opts := &CompileOptions{Dialect: test.dialect}
+		got, err := opts.Compile(source)
+		if err != nil {
+			t.Errorf("Compile(%q) with dialect %v: %v", source, test.dialect, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) with dialect %v (-want +got):\n%s", source, test.dialect, diff)
+		}
+	}
+
+	const clusterSource = "cluster('c').database('db').T"
+	if _, err := (&CompileOptions{Dialect: ClickHouseDialect}).Compile(clusterSource); err != nil {
+		t.Errorf("Compile(%q) with dialect clickhouse: %v", clusterSource, err)
+	}
+	if _, err := (&CompileOptions{Dialect: PostgresDialect}).Compile(clusterSource); err == nil {
+		t.Errorf("Compile(%q) with dialect postgres did not return an error", clusterSource)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	const source = "T | where x > 1 | project x, y = x + 1 | sort by x | take 3"
+	want := &Plan{
+		SQL: `WITH "__subquery0" AS (SELECT "x" AS "x", "x" + 1 AS "y" FROM "T" WHERE "x" > 1)` + "\n" +
+			`SELECT * FROM "__subquery0" ORDER BY "x" DESC NULLS LAST LIMIT 3;`,
+		Stages: []*PlanStage{
+			{
+				Name:      "__subquery0",
+				Operators: []parser.Span{parser.Span{Start: 2, End: 15}, parser.Span{Start: 16, End: 38}},
+				Sort:      parser.Span{Start: -1, End: -1},
+				Take:      parser.Span{Start: -1, End: -1},
+				SQL:       `SELECT "x" AS "x", "x" + 1 AS "y" FROM "T" WHERE "x" > 1`,
+			},
+			{
+				Operators: []parser.Span{parser.Span{Start: 39, End: 50}, parser.Span{Start: 51, End: 59}},
+				Sort:      parser.Span{Start: 39, End: 50},
+				Take:      parser.Span{Start: 51, End: 59},
+				SQL:       `SELECT * FROM "__subquery0" ORDER BY "x" DESC NULLS LAST LIMIT 3`,
+			},
+		},
+	}
+	got, err := (*CompileOptions)(nil).Explain(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(Plan{}, PlanStage{})); diff != "" {
+		t.Errorf("Explain(%q) (-want +got):\n%s", source, diff)
+	}
+	if sql, err := Compile(source); err != nil || sql != got.SQL {
+		t.Errorf("Compile(%q) = %q, %v; want %q, <nil>", source, sql, err, got.SQL)
+	}
+}
+
+func TestPlanLocateError(t *testing.T) {
+	const source = "T | where x > 1 | project x, y = x + 1 | sort by x | take 3"
+	tests := []struct {
+		name string
+		opts *CompileOptions
+		// msg is the error message, with %d replaced by
+		// the 1-based position of at in the plan's SQL.
+		msg  string
+		at   string
+		want string
+	}{
+		{
+			name: "ClickHousePosition",
+			msg:  "Code: 47. DB::Exception: Missing columns: 'y' failed at position %d",
+			at:   `"y" FROM`,
+			want: "| where x > 1 | project x, y = x + 1",
+		},
+		{
+			name: "ClickHouseLineColumn",
+			msg:  "Code: 62. DB::Exception: Syntax error: failed at position 999 ('ORDER') (line 2, col 29): ORDER BY",
+			want: "| sort by x | take 3",
+		},
+		{
+			name: "PostgresCharacter",
+			msg:  `ERROR: column "x" does not exist at character %d`,
+			at:   `"x" + 1`,
+			want: "| where x > 1 | project x, y = x + 1",
+		},
+		{
+			name: "Pretty",
+			opts: &CompileOptions{Format: PrettySQLFormat},
+			msg:  "failed at position %d",
+			at:   "LIMIT",
+			want: "| sort by x | take 3",
+		},
+		{
+			name: "Inline",
+			opts: &CompileOptions{InlineSubqueries: true},
+			msg:  "failed at position %d",
+			at:   `"T"`,
+			want: "| where x > 1 | project x, y = x + 1",
+		},
+		{
+			name: "WithClause",
+			msg:  "failed at position 1",
+		},
+		{
+			name: "NoPosition",
+			msg:  "Code: 241. DB::Exception: Memory limit exceeded",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := test.opts.Explain(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := test.msg
+			if test.at != "" {
+				i := strings.Index(plan.SQL, test.at)
+				if i < 0 {
+					t.Fatalf("%q not found in SQL:\n%s", test.at, plan.SQL)
+				}
+				msg = fmt.Sprintf(test.msg, i+1)
+			}
+			span, snippet, ok := plan.LocateError(errors.New(msg))
+			if test.want == "" {
+				if ok {
+					t.Errorf("LocateError(%q) = %v, %q, true; want false", msg, span, snippet)
+				}
+				return
+			}
+			if !ok || snippet != test.want || source[span.Start:span.End] != snippet {
+				t.Errorf("LocateError(%q) = %v, %q, %t; want %q, true", msg, span, snippet, ok, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileStringComparison(t *testing.T) {
+	tests := []struct {
+		comparison StringComparison
+		source     string
+		want       string
+	}{
+		{
+			comparison: LowerStringComparison,
+			source:     "T | where a =~ 'x' and b in ('y', 'z')",
+			want:       `SELECT * FROM "T" WHERE (lower("a") = lower('x')) AND ("b" IN ('y', 'z'));`,
+		},
+		{
+			comparison: CollationStringComparison,
+			source:     "T | where a =~ 'x' and b !~ c",
+			want:       `SELECT * FROM "T" WHERE ("a" = 'x') AND ("b" <> "c");`,
+		},
+		{
+			comparison: ILikeStringComparison,
+			source:     `T | where a =~ '50%_off\\' and b !~ 'x' and c =~ d`,
+			want:       `SELECT * FROM "T" WHERE (("a" ILIKE '50\%\_off\\') AND ("b" NOT ILIKE 'x')) AND (lower("c") = lower("d"));`,
+		},
+		{
+			comparison: CaseInsensitiveStringComparison,
+			source:     "T | where a == 'x' and b != 'y' and c in ('z')",
+			want:       `SELECT * FROM "T" WHERE ((lower("a") = lower('x')) AND (lower("b") <> lower('y'))) AND (lower("c") IN (lower('z')));`,
+		},
+		{
+			// lower() only accepts strings.
+			comparison: CaseInsensitiveStringComparison,
+			source:     "T | where x == 1 and y != -2.5 and z in (1, 2)",
+			want:       `SELECT * FROM "T" WHERE ((coalesce("x" = 1, FALSE)) AND (coalesce("y" <> -2.5, FALSE))) AND ("z" IN (1, 2));`,
+		},
+	}
+	for _, test := range tests {
+		opts := &CompileOptions{StringComparison: test.comparison}
+		got, err := opts.Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q) with comparison %d: %v", test.source, test.comparison, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Compile(%q) with comparison %d = %q; want %q", test.source, test.comparison, got, test.want)
+		}
+	}
+}
+
+func TestCompileCaseInsensitiveNonStringColumns(t *testing.T) {
+	opts := &CompileOptions{
+		StringComparison: CaseInsensitiveStringComparison,
+		AnalysisContext: &AnalysisContext{
+			Tables: map[string]*AnalysisTable{
+				"T": {
+					Columns: []*AnalysisColumn{
+						{Name: "id", Type: "Int64"},
+						{Name: "parent", Type: "Int64"},
+						{Name: "name", Type: "String"},
+					},
+				},
+			},
+		},
+	}
+	const source = "T | where id == parent and name == 'x'"
+	got, err := opts.Compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `SELECT * FROM "T" WHERE (coalesce("id" = "parent", FALSE)) AND (lower("name") = lower('x'));`
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Compile(%q) (-want +got):\n%s", source, diff)
+	}
+}
+
+func TestCompileInListThreshold(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   *CompileOptions
+		source string
+		want   string
+	}{
+		{
+			name:   "BelowThreshold",
+			opts:   &CompileOptions{InListThreshold: 3},
+			source: "T | where a in ('x', 'y')",
+			want:   `SELECT * FROM "T" WHERE "a" IN ('x', 'y');`,
+		},
+		{
+			name:   "ClickHouse",
+			opts:   &CompileOptions{InListThreshold: 2},
+			source: "T | where a in ('x', 'y')",
+			want:   `SELECT * FROM "T" WHERE has(['x', 'y'], "a");`,
+		},
+		{
+			name:   "Postgres",
+			opts:   &CompileOptions{InListThreshold: 2, Dialect: PostgresDialect},
+			source: "T | where a + 1 in (1, 0x10, 3)",
+			want:   `SELECT * FROM "T" WHERE ("a" + 1) = ANY(ARRAY[1, 16, 3]);`,
+		},
+		{
+			name:   "DuckDB",
+			opts:   &CompileOptions{InListThreshold: 2, Dialect: DuckDBDialect},
+			source: "T | where a in (1, 2.5)",
+			want:   `SELECT * FROM "T" WHERE list_contains([1, 2.5], "a");`,
+		},
+		{
+			name:   "MixedKinds",
+			opts:   &CompileOptions{InListThreshold: 2},
+			source: "T | where a in (1, 'x')",
+			want:   `SELECT * FROM "T" WHERE "a" IN (1, 'x');`,
+		},
+		{
+			name:   "NotLiteral",
+			opts:   &CompileOptions{InListThreshold: 2},
+			source: "T | where a in ('x', b)",
+			want:   `SELECT * FROM "T" WHERE "a" IN ('x', "b");`,
+		},
+		{
+			name: "CaseInsensitive",
+			opts: &CompileOptions{
+				InListThreshold:  2,
+				StringComparison: CaseInsensitiveStringComparison,
+			},
+			source: "T | where a in ('X', 'y')",
+			want:   `SELECT * FROM "T" WHERE has(['x', 'y'], lower("a"));`,
+		},
+		{
+			name: "CaseInsensitiveNonASCII",
+			opts: &CompileOptions{
+				InListThreshold:  2,
+				StringComparison: CaseInsensitiveStringComparison,
+			},
+			source: "T | where a in ('É', 'y')",
+			want:   `SELECT * FROM "T" WHERE lower("a") IN (lower('É'), lower('y'));`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.opts.Compile(test.source)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", test.source, err)
+			}
+			if got != test.want {
+				t.Errorf("Compile(%q) = %q; want %q", test.source, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileInListParameter(t *testing.T) {
+	const source = "T | where a in ('x', 'y', 'z') and b in (1, 2)"
+	var bound []any
+	opts := &CompileOptions{
+		Dialect:         PostgresDialect,
+		InListThreshold: 2,
+		InListParameter: func(values any) string {
+			bound = append(bound, values)
+			return fmt.Sprintf("$%d", len(bound))
+		},
+	}
+	got, err := opts.Compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `SELECT * FROM "T" WHERE ("a" = ANY($1)) AND ("b" = ANY($2));`
+	if got != want {
+		t.Errorf("Compile(%q) = %q; want %q", source, got, want)
+	}
+	wantBound := []any{[]string{"x", "y", "z"}, []int64{1, 2}}
+	if diff := cmp.Diff(wantBound, bound); diff != "" {
+		t.Errorf("InListParameter values (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompileThreeValuedComparisons(t *testing.T) {
+	const source = "T | where a == 1 and b != 'x'"
+	opts := &CompileOptions{ThreeValuedComparisons: true}
+	got, err := opts.Compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `SELECT * FROM "T" WHERE ("a" = 1) AND ("b" <> 'x');`
+	if got != want {
+		t.Errorf("Compile(%q) = %q; want %q", source, got, want)
+	}
+}
+
+func TestCompileColumnName(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			source: "T | count",
+			want:   `SELECT COUNT(*) AS "count_" FROM "T";`,
+		},
+		{
+			source: "T | summarize count(), sum(x) by k",
+			want:   `SELECT "k" AS "k", count() AS "count_", sum("x") AS "sum_x_" FROM "T" GROUP BY "k";`,
+		},
+		{
+			source: "T | extend x + 1",
+			want:   `SELECT *, "x" + 1 AS "x_1" FROM "T";`,
+		},
+	}
+	opts := &CompileOptions{ColumnName: SanitizeColumnName}
+	for _, test := range tests {
+		got, err := opts.Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Compile(%q) = %q; want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestSanitizeColumnName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"count()", "count_"},
+		{"sum(DamageProperty)", "sum_DamageProperty_"},
+		{"State", "State"},
+		{"1 + x", "_1_x"},
+		{"déjà vu", "d_j_vu"},
+	}
+	for _, test := range tests {
+		if got := SanitizeColumnName(test.name); got != test.want {
+			t.Errorf("SanitizeColumnName(%q) = %q; want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompileNonEquiJoin(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			source: "A | join kind=inner (B) on $left.start <= $right.ts and $right.ts < $left.end",
+			want: `WITH "__subquery0" AS (SELECT * FROM "B")` + "\n" +
+				`SELECT * FROM "A" AS "$left" CROSS JOIN "__subquery0" AS "$right" ` +
+				`WHERE ("$left"."start" <= "$right"."ts") AND ("$right"."ts" < "$left"."end");`,
+		},
+		{
+			source: "A | join kind=inner (B) on k, $left.start <= $right.ts",
+			want: `WITH "__subquery0" AS (SELECT * FROM "B")` + "\n" +
+				`SELECT * FROM "A" AS "$left" JOIN "__subquery0" AS "$right" ` +
+				`ON "$left"."k" = "$right"."k" WHERE "$left"."start" <= "$right"."ts";`,
+		},
+		{
+			source: "A | join kind=leftouter (B) on k, $left.start <= $right.ts",
+			want: `WITH "__subquery0" AS (SELECT * FROM "B")` + "\n" +
+				`SELECT * FROM "A" AS "$left" LEFT JOIN "__subquery0" AS "$right" ` +
+				`ON ("$left"."k" = "$right"."k") AND ("$left"."start" <= "$right"."ts");`,
+		},
+	}
+	for _, test := range tests {
+		got, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Compile(%q) = %q; want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestCompileOuterNonEquiJoin(t *testing.T) {
+	tests := []struct {
+		source  string
+		dialect Dialect
+		want    string
+		code    string
+	}{
+		{
+			source:  "A | join kind=leftouter (B) on $left.start <= $right.ts",
+			dialect: ClickHouseDialect,
+			code:    CodeUnsupported,
+		},
+		{
+			source:  "A | join kind=fullouter (B) on $left.start <= $right.ts, $right.ts < $left.end",
+			dialect: ClickHouseDialect,
+			code:    CodeUnsupported,
+		},
+		{
+			source:  "A | join kind=leftouter (B) on $left.start <= $right.ts",
+			dialect: PostgresDialect,
+			want: `WITH "__subquery0" AS (SELECT * FROM "B")` + "\n" +
+				`SELECT * FROM "A" AS "$left" LEFT JOIN "__subquery0" AS "$right" ON "$left"."start" <= "$right"."ts";`,
+		},
+		{
+			source:  "A | join kind=rightouter (B) on $left.start <= $right.ts",
+			dialect: DuckDBDialect,
+			want: `WITH "__subquery0" AS (SELECT * FROM "B")` + "\n" +
+				`SELECT * FROM "A" AS "$left" RIGHT JOIN "__subquery0" AS "$right" ON "$left"."start" <= "$right"."ts";`,
+		},
+	}
+	for _, test := range tests {
+		opts := &CompileOptions{Dialect: test.dialect}
+		got, err := opts.Compile(test.source)
+		if test.code != "" {
+			diags := parser.Diagnostics(err)
+			if len(diags) != 1 || diags[0].Code != test.code {
+				t.Errorf("Compile(%q) with dialect %v = %q, %v; want error with code %q", test.source, test.dialect, got, err, test.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Compile(%q) with dialect %v: %v", test.source, test.dialect, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compile(%q) with dialect %v (-want +got):\n%s", test.source, test.dialect, diff)
+		}
+	}
+}
+
+func TestCompileJoinFlavors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			source: "A | join kind=rightouter (B) on k",
+			want:   `SELECT * FROM "A" AS "$left" RIGHT JOIN "__subquery0" AS "$right" ON "$left"."k" = "$right"."k";`,
+		},
+		{
+			source: "A | join kind=fullouter (B) on k",
+			want:   `SELECT * FROM "A" AS "$left" FULL OUTER JOIN "__subquery0" AS "$right" ON "$left"."k" = "$right"."k";`,
+		},
+		{
+			source: "A | join kind=cross (B)",
+			want:   `SELECT * FROM "A" AS "$left" CROSS JOIN "__subquery0" AS "$right";`,
+		},
+		{
+			source: "A | join kind=leftsemi (B) on k, $right.x > 0",
+			want: `SELECT * FROM "A" AS "$left" WHERE ("$left"."k") IN ` +
+				`(SELECT "$right"."k" FROM "__subquery0" AS "$right" WHERE "$right"."x" > 0);`,
+		},
+		{
+			source: "A | join kind=leftanti (B) on k, $left.x > 0",
+			want: `SELECT * FROM "A" AS "$left" WHERE NOT (("$left"."k") IN ` +
+				`(SELECT "$right"."k" FROM "__subquery0" AS "$right") AND ("$left"."x" > 0));`,
+		},
+		{
+			source: "A | join kind=rightsemi (B) on $left.a == $right.b, k",
+			want: `SELECT * FROM "__subquery0" AS "$right" WHERE ("$right"."b", "$right"."k") IN ` +
+				`(SELECT "$left"."a", "$left"."k" FROM "A" AS "$left");`,
+		},
+		{
+			source: "A | join kind=rightanti (B) on k",
+			want: `SELECT * FROM "__subquery0" AS "$right" WHERE NOT (("$right"."k") IN ` +
+				`(SELECT "$left"."k" FROM "A" AS "$left"));`,
+		},
+	}
+	for _, test := range tests {
+		got, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		want := `WITH "__subquery0" AS (SELECT * FROM "B")` + "\n" + test.want
+		if got != want {
+			t.Errorf("Compile(%q) = %q; want %q", test.source, got, want)
+		}
+	}
+
+	for _, source := range []string{
+		"A | join kind=leftsemi (B) on $left.x < $right.y",
+		"A | join kind=leftanti (B) on k, $left.x < $right.y",
+	} {
+		if _, err := Compile(source); err == nil {
+			t.Errorf("Compile(%q) did not return an error", source)
+		}
+	}
+}
+
+func TestCompileAntiJoinDialects(t *testing.T) {
+	// B may have null keys, which must not exclude every row of A.
+	const source = "A | join kind=leftanti (B | where x > 0) on k, $left.y > 0"
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{
+			dialect: ClickHouseDialect,
+			want: `SELECT * FROM "A" AS "$left" WHERE NOT (("$left"."k") IN ` +
+				`(SELECT "$right"."k" FROM "__subquery0" AS "$right") AND ("$left"."y" > 0));`,
+		},
+		{
+			dialect: PostgresDialect,
+			want: `SELECT * FROM "A" AS "$left" WHERE NOT EXISTS (SELECT 1 FROM "__subquery0" AS "$right" ` +
+				`WHERE ("$left"."k" = "$right"."k") AND ("$left"."y" > 0));`,
+		},
+		{
+			dialect: DuckDBDialect,
+			want: `SELECT * FROM "A" AS "$left" WHERE NOT EXISTS (SELECT 1 FROM "__subquery0" AS "$right" ` +
+				`WHERE ("$left"."k" = "$right"."k") AND ("$left"."y" > 0));`,
+		},
+	}
+	for _, test := range tests {
+		opts := &CompileOptions{Dialect: test.dialect}
+		got, err := opts.Compile(source)
+		if err != nil {
+			t.Errorf("Compile(%q) with dialect %v: %v", source, test.dialect, err)
+			continue
+		}
+		want := `WITH "__subquery0" AS (SELECT * FROM "B" WHERE "x" > 0)` + "\n" + test.want
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Compile(%q) with dialect %v (-want +got):\n%s", source, test.dialect, diff)
+		}
+	}
+
+	const rightSource = "A | join kind=rightanti (B) on k, $right.z > 1"
+	got, err := (&CompileOptions{Dialect: PostgresDialect}).Compile(rightSource)
+	if err != nil {
+		t.Fatalf("Compile(%q) with dialect postgres: %v", rightSource, err)
+	}
+	want := `WITH "__subquery0" AS (SELECT * FROM "B")` + "\n" +
+		`SELECT * FROM "__subquery0" AS "$right" WHERE NOT EXISTS (SELECT 1 FROM "A" AS "$left" ` +
+		`WHERE ("$right"."k" = "$left"."k") AND ("$right"."z" > 1));`
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Compile(%q) with dialect postgres (-want +got):\n%s", rightSource, diff)
+	}
+}
+
+func TestCompileJoinHints(t *testing.T) {
+	const source = "A | join kind=inner hint.strategy=broadcast (B) on k"
+	var warnings []parser.Diagnostic
+	opts := &CompileOptions{
+		Warn: func(diag parser.Diagnostic) {
+			warnings = append(warnings, diag)
+		},
+	}
+	got, err := opts.Compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `WITH "__subquery0" AS (SELECT * FROM "B")` + "\n" +
+		`SELECT * FROM "A" AS "$left" JOIN "__subquery0" AS "$right" ON "$left"."k" = "$right"."k";`
+	if got != want {
+		t.Errorf("Compile(%q) = %q; want %q", source, got, want)
+	}
+	wantWarnings := []parser.Diagnostic{{
+		Span:     parser.Span{Start: len("A | join kind=inner "), End: len("A | join kind=inner hint.strategy=broadcast")},
+		Severity: parser.SeverityWarning,
+		Code:     CodeIgnoredHint,
+		Message:  `join hint "hint.strategy" ignored`,
+	}}
+	if diff := cmp.Diff(wantWarnings, warnings); diff != "" {
+		t.Errorf("Compile(%q) warnings (-want +got):\n%s", source, diff)
+	}
+}
+
+func TestCompileRowCount(t *testing.T) {
+	opts := &CompileOptions{
+		Parameters: map[string]string{"limit_param": "{limit:UInt32}"},
+	}
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{
+			source: "T | take 10 * 3",
+			want:   `SELECT * FROM "T" LIMIT 30;`,
+		},
+		{
+			source: "let n = 5;\nT | take (n + 1) * 2",
+			want:   `SELECT * FROM "T" LIMIT 12;`,
+		},
+		{
+			source: "T | take limit_param",
+			want:   `SELECT * FROM "T" LIMIT {limit:UInt32};`,
+		},
+		{
+			source: "T | top 2 + 1 by x",
+			want:   `SELECT * FROM "T" ORDER BY "x" DESC NULLS LAST LIMIT 3;`,
+		},
+	}
+	for _, test := range tests {
+		got, err := opts.Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Compile(%q) = %q; want %q", test.source, got, test.want)
+		}
+	}
+
+	for _, source := range []string{
+		"T | take 1 - 2",
+		"T | take x",
+		"T | take 2.5 * limit_param",
+		"T | take 1 / 0",
+		"T | take 9223372036854775807 * 2",
+		"T | take 9223372036854775807 * 3",
+		"T | take 9223372036854775807 + 1",
+	} {
+		_, err := opts.Compile(source)
+		diags := parser.Diagnostics(err)
+		if len(diags) != 1 || diags[0].Code != CodeInvalidRowCount {
+			t.Errorf("Compile(%q) = _, %v; want %s error", source, err, CodeInvalidRowCount)
+		}
+	}
+}
+
+func TestCompileCaseInsensitiveSort(t *testing.T) {
+	tests := []struct {
+		opts   *CompileOptions
+		source string
+		want   string
+	}{
+		{
+			opts:   &CompileOptions{CaseInsensitiveSort: true},
+			source: "T | sort by name asc",
+			want:   `SELECT * FROM "T" ORDER BY lower("name") ASC NULLS FIRST;`,
+		},
+		{
+			opts:   &CompileOptions{SortCollation: "'en'"},
+			source: "T | top 5 by name",
+			want:   `SELECT * FROM "T" ORDER BY "name" COLLATE 'en' DESC NULLS LAST LIMIT 5;`,
+		},
+		{
+			opts: &CompileOptions{
+				CaseInsensitiveSort: true,
+				SortCollation:       "'en'",
+				AnalysisContext:     testAnalysisContext,
+			},
+			source: "People | sort by Name asc, Age asc",
+			want:   `SELECT * FROM "People" ORDER BY lower("Name") COLLATE 'en' ASC NULLS FIRST, "Age" ASC NULLS FIRST;`,
+		},
+	}
+	for _, test := range tests {
+		got, err := test.opts.Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Compile(%q) = %q; want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	// largePipeline alternates operators that can and cannot be fused,
+	// so that it compiles to many common table expressions.
+	largePipeline := new(strings.Builder)
+	largePipeline.WriteString("StormEvents")
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(largePipeline, "\n| where DamageProperty > %d and State != 'TEXAS'", i)
+		fmt.Fprintf(largePipeline, "\n| extend x%d = strcat(State, '-', EventType), y%d = DamageProperty * %d", i, i, i)
+		fmt.Fprintf(largePipeline, "\n| summarize n = count(), total = sum(y%d) by State, x%d", i, i)
+		largePipeline.WriteString("\n| extend DamageProperty = total, EventType = x0")
+	}
+	wideProject := new(strings.Builder)
+	wideProject.WriteString("StormEvents | project ")
+	for i := 0; i < 500; i++ {
+		if i > 0 {
+			wideProject.WriteString(", ")
+		}
+		fmt.Fprintf(wideProject, "c%d = iff(DamageProperty > %d, tolower(State), toupper(EventType))", i, i)
+	}
+	benchmarks := []struct {
+		name   string
+		source string
+	}{
+		{"Small", `StormEvents | where EventType == "Tornado" | summarize n = count() by State | top 10 by n`},
+		{"LargePipeline", largePipeline.String()},
+		{"WideProject", wideProject.String()},
+	}
+	for _, bench := range benchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Compile(bench.source); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,487 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pqlarrow connects the [github.com/runreveal/pql/pqleval] evaluator
+// to [Apache Arrow] record batches.
+//
+// [NewSource] and [NewRowIterator] let queries read tables from Arrow records,
+// and [NewRecordReader] and [NewRecord] convert query results back into records
+// for other Arrow-based components.
+//
+// Arrow values are read as the types used by [pqleval.Table]:
+// booleans as bool, integers as int64 (or float64 if they do not fit),
+// floating point numbers as float64, strings and binary values as string,
+// timestamps and dates as [time.Time], lists as []any,
+// and structs and maps with string keys as map[string]any.
+// Values of other types are read as their string representation.
+//
+// [Apache Arrow]: https://arrow.apache.org/
+package pqlarrow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/runreveal/pql/pqleval"
+)
+
+// A SourceFunc is a [pqleval.Source]
+// that reads the records returned by calling the function
+// each time the table is referenced in a query.
+type SourceFunc func(ctx context.Context) (array.RecordReader, error)
+
+// Open calls f and returns an iterator over the rows of its records.
+func (f SourceFunc) Open(ctx context.Context) (pqleval.RowIterator, error) {
+	r, err := f(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewRowIterator(r), nil
+}
+
+// NewSource returns a [pqleval.Source] that reads the given records,
+// which must all have the given schema.
+// Rows are read from the records in place,
+// without copying them into a [pqleval.Table],
+// so the caller must not release the records while the source is in use.
+func NewSource(schema *arrow.Schema, records []arrow.Record) SourceFunc {
+	return func(ctx context.Context) (array.RecordReader, error) {
+		return array.NewRecordReader(schema, records)
+	}
+}
+
+// NewRowIterator returns an iterator over the rows of the records read by r.
+// Its columns are the fields of r's schema.
+// Closing the iterator releases r.
+func NewRowIterator(r array.RecordReader) pqleval.RowIterator {
+	fields := r.Schema().Fields()
+	cols := make([]string, len(fields))
+	for i, f := range fields {
+		cols[i] = f.Name
+	}
+	return &rowIterator{r: r, cols: cols}
+}
+
+type rowIterator struct {
+	r    array.RecordReader
+	cols []string
+	rec  arrow.Record
+	i    int
+}
+
+func (it *rowIterator) Columns() []string {
+	return it.cols
+}
+
+func (it *rowIterator) Next(ctx context.Context) (pqleval.Row, error) {
+	if it.r == nil {
+		return nil, io.EOF
+	}
+	for it.rec == nil || it.i >= int(it.rec.NumRows()) {
+		if !it.r.Next() {
+			if err := it.r.Err(); err != nil {
+				return nil, err
+			}
+			it.rec = nil
+			return nil, io.EOF
+		}
+		it.rec = it.r.Record()
+		it.i = 0
+	}
+	row := make(pqleval.Row, len(it.cols))
+	for j, arr := range it.rec.Columns() {
+		row[j] = Value(arr, it.i)
+	}
+	it.i++
+	return row, nil
+}
+
+func (it *rowIterator) Close() error {
+	if it.r != nil {
+		it.r.Release()
+		it.r = nil
+		it.rec = nil
+	}
+	return nil
+}
+
+// NewTable copies the values of rec into a [pqleval.Table].
+func NewTable(rec arrow.Record) *pqleval.Table {
+	t := &pqleval.Table{Columns: make([]*pqleval.Column, rec.NumCols())}
+	for j, arr := range rec.Columns() {
+		col := &pqleval.Column{
+			Name:   rec.ColumnName(j),
+			Values: make([]any, arr.Len()),
+		}
+		for i := range col.Values {
+			col.Values[i] = Value(arr, i)
+		}
+		t.Columns[j] = col
+	}
+	return t
+}
+
+// Value returns the i'th value of arr
+// as one of the types described in the package documentation.
+// It returns nil for null values.
+func Value(arr arrow.Array, i int) any {
+	if arr.IsNull(i) {
+		return nil
+	}
+	switch arr := arr.(type) {
+	case *array.Null:
+		return nil
+	case *array.Boolean:
+		return arr.Value(i)
+	case *array.Int8:
+		return int64(arr.Value(i))
+	case *array.Int16:
+		return int64(arr.Value(i))
+	case *array.Int32:
+		return int64(arr.Value(i))
+	case *array.Int64:
+		return arr.Value(i)
+	case *array.Uint8:
+		return int64(arr.Value(i))
+	case *array.Uint16:
+		return int64(arr.Value(i))
+	case *array.Uint32:
+		return int64(arr.Value(i))
+	case *array.Uint64:
+		if x := arr.Value(i); x <= math.MaxInt64 {
+			return int64(x)
+		} else {
+			return float64(x)
+		}
+	case *array.Float16:
+		return float64(arr.Value(i).Float32())
+	case *array.Float32:
+		return float64(arr.Value(i))
+	case *array.Float64:
+		return arr.Value(i)
+	case *array.String:
+		return arr.Value(i)
+	case *array.LargeString:
+		return arr.Value(i)
+	case *array.StringView:
+		return arr.Value(i)
+	case *array.Binary:
+		return string(arr.Value(i))
+	case *array.LargeBinary:
+		return string(arr.Value(i))
+	case *array.Timestamp:
+		toTime, err := arr.DataType().(*arrow.TimestampType).GetToTimeFunc()
+		if err != nil {
+			return arr.ValueStr(i)
+		}
+		return toTime(arr.Value(i))
+	case *array.Date32:
+		return arr.Value(i).ToTime()
+	case *array.Date64:
+		return arr.Value(i).ToTime()
+	case *array.Dictionary:
+		return Value(arr.Dictionary(), arr.GetValueIndex(i))
+	case *array.Struct:
+		typ := arr.DataType().(*arrow.StructType)
+		m := make(map[string]any, arr.NumField())
+		for j := 0; j < arr.NumField(); j++ {
+			m[typ.Field(j).Name] = Value(arr.Field(j), i)
+		}
+		return m
+	case *array.Map:
+		start, end := arr.ValueOffsets(i)
+		m := make(map[string]any, end-start)
+		for k := start; k < end; k++ {
+			key, ok := Value(arr.Keys(), int(k)).(string)
+			if !ok {
+				return arr.ValueStr(i)
+			}
+			m[key] = Value(arr.Items(), int(k))
+		}
+		return m
+	case array.ListLike:
+		start, end := arr.ValueOffsets(i)
+		elems := make([]any, 0, end-start)
+		for k := start; k < end; k++ {
+			elems = append(elems, Value(arr.ListValues(), int(k)))
+		}
+		return elems
+	default:
+		return arr.ValueStr(i)
+	}
+}
+
+// NewRecord copies the values of t into a record
+// allocated from mem.
+// Column types are chosen as described in [NewRecordReader].
+// The caller must release the returned record.
+func NewRecord(mem memory.Allocator, t *pqleval.Table) (arrow.Record, error) {
+	cols := make([]string, len(t.Columns))
+	rows := make([]pqleval.Row, t.Len())
+	for j, col := range t.Columns {
+		cols[j] = col.Name
+	}
+	for i := range rows {
+		rows[i] = make(pqleval.Row, len(t.Columns))
+		for j, col := range t.Columns {
+			rows[i][j] = col.Values[i]
+		}
+	}
+	b := array.NewRecordBuilder(mem, inferSchema(cols, rows))
+	defer b.Release()
+	for _, row := range rows {
+		if err := appendRow(b, row); err != nil {
+			return nil, err
+		}
+	}
+	return b.NewRecord(), nil
+}
+
+// RecordReader is an [array.RecordReader]
+// that reads the rows of a [pqleval.RowIterator] in batches.
+type RecordReader struct {
+	refs      atomic.Int64
+	ctx       context.Context
+	it        pqleval.RowIterator
+	b         *array.RecordBuilder
+	batchSize int
+
+	// pending holds the rows read to infer the schema.
+	pending []pqleval.Row
+	rec     arrow.Record
+	err     error
+}
+
+// DefaultBatchSize is the number of rows in each record
+// produced by a [RecordReader] if no batch size is given.
+const DefaultBatchSize = 1024
+
+// NewRecordReader returns a reader that produces records
+// of up to batchSize rows from it,
+// or [DefaultBatchSize] rows if batchSize is not positive.
+// Records are allocated from mem.
+// The caller must release the reader and close it when they are done.
+//
+// NewRecordReader reads the first batch of rows to choose the schema.
+// Columns with only bool, int64, float64, or [time.Time] values
+// have the Boolean, Int64, Float64, or nanosecond Timestamp types.
+// Columns that mix int64 and float64 values are Float64.
+// All other columns are strings:
+// strings are written as-is, times as RFC 3339, and other values as JSON.
+// Reading a later row fails if its value does not fit its column's type.
+func NewRecordReader(ctx context.Context, mem memory.Allocator, it pqleval.RowIterator, batchSize int) (*RecordReader, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	r := &RecordReader{
+		ctx:       ctx,
+		it:        it,
+		batchSize: batchSize,
+	}
+	r.refs.Store(1)
+	for len(r.pending) < batchSize {
+		row, err := it.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.pending = append(r.pending, row)
+	}
+	r.b = array.NewRecordBuilder(mem, inferSchema(it.Columns(), r.pending))
+	return r, nil
+}
+
+// Retain increases the reference count of r by 1.
+func (r *RecordReader) Retain() {
+	r.refs.Add(1)
+}
+
+// Release decreases the reference count of r by 1.
+// When the count reaches zero, the current record and builder are released.
+// Release does not close the row iterator.
+func (r *RecordReader) Release() {
+	if r.refs.Add(-1) != 0 {
+		return
+	}
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	r.b.Release()
+}
+
+// Schema returns the schema of r's records.
+func (r *RecordReader) Schema() *arrow.Schema {
+	return r.b.Schema()
+}
+
+// Next reads the next batch of rows.
+// It returns false at the end of the rows or if an error occurs,
+// which is reported by [RecordReader.Err].
+func (r *RecordReader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	if r.err != nil {
+		return false
+	}
+	n := 0
+	for _, row := range r.pending {
+		if r.err = appendRow(r.b, row); r.err != nil {
+			return false
+		}
+		n++
+	}
+	r.pending = nil
+	for n < r.batchSize {
+		row, err := r.it.Next(r.ctx)
+		if err == io.EOF {
+			r.err = io.EOF
+			break
+		}
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if r.err = appendRow(r.b, row); r.err != nil {
+			return false
+		}
+		n++
+	}
+	if n == 0 {
+		return false
+	}
+	r.rec = r.b.NewRecord()
+	return true
+}
+
+// Record returns the batch read by the last call to [RecordReader.Next].
+// It is valid until the next call to Next
+// unless the caller retains it.
+func (r *RecordReader) Record() arrow.Record {
+	return r.rec
+}
+
+// Err returns the error that stopped [RecordReader.Next], if any.
+func (r *RecordReader) Err() error {
+	if r.err == io.EOF {
+		return nil
+	}
+	return r.err
+}
+
+// inferSchema returns the schema for the given columns
+// based on the values in rows.
+func inferSchema(cols []string, rows []pqleval.Row) *arrow.Schema {
+	fields := make([]arrow.Field, len(cols))
+	for j, name := range cols {
+		var hasBool, hasInt, hasFloat, hasTime, hasOther bool
+		for _, row := range rows {
+			switch row[j].(type) {
+			case nil:
+			case bool:
+				hasBool = true
+			case int64:
+				hasInt = true
+			case float64:
+				hasFloat = true
+			case time.Time:
+				hasTime = true
+			default:
+				hasOther = true
+			}
+		}
+		var typ arrow.DataType = arrow.BinaryTypes.String
+		switch {
+		case hasOther:
+		case hasBool && !hasInt && !hasFloat && !hasTime:
+			typ = arrow.FixedWidthTypes.Boolean
+		case hasInt && !hasBool && !hasFloat && !hasTime:
+			typ = arrow.PrimitiveTypes.Int64
+		case (hasInt || hasFloat) && !hasBool && !hasTime:
+			typ = arrow.PrimitiveTypes.Float64
+		case hasTime && !hasBool && !hasInt && !hasFloat:
+			typ = arrow.FixedWidthTypes.Timestamp_ns
+		}
+		fields[j] = arrow.Field{Name: name, Type: typ, Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// appendRow appends the values in row to the fields of b.
+func appendRow(b *array.RecordBuilder, row pqleval.Row) error {
+	fields := b.Fields()
+	if len(row) != len(fields) {
+		return fmt.Errorf("row has %d values for %d columns", len(row), len(fields))
+	}
+	for j, v := range row {
+		if err := appendValue(fields[j], v); err != nil {
+			return fmt.Errorf("column %s: %v", b.Schema().Field(j).Name, err)
+		}
+	}
+	return nil
+}
+
+func appendValue(b array.Builder, v any) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+	switch b := b.(type) {
+	case *array.BooleanBuilder:
+		if v, ok := v.(bool); ok {
+			b.Append(v)
+			return nil
+		}
+	case *array.Int64Builder:
+		if v, ok := v.(int64); ok {
+			b.Append(v)
+			return nil
+		}
+	case *array.Float64Builder:
+		switch v := v.(type) {
+		case float64:
+			b.Append(v)
+			return nil
+		case int64:
+			b.Append(float64(v))
+			return nil
+		}
+	case *array.TimestampBuilder:
+		if v, ok := v.(time.Time); ok {
+			b.AppendTime(v)
+			return nil
+		}
+	case *array.StringBuilder:
+		s, err := formatValue(v)
+		if err != nil {
+			return err
+		}
+		b.Append(s)
+		return nil
+	}
+	return fmt.Errorf("cannot store %T in %v column", v, b.Type())
+}
+
+func formatValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+}
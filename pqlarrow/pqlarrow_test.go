@@ -0,0 +1,177 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqlarrow
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql/pqleval"
+)
+
+// eventsRecord returns a record with a column of each kind of value.
+func eventsRecord(t *testing.T, mem memory.Allocator) arrow.Record {
+	t.Helper()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "host", Type: arrow.BinaryTypes.String},
+		{Name: "status", Type: arrow.PrimitiveTypes.Uint16},
+		{Name: "bytes", Type: arrow.PrimitiveTypes.Float32, Nullable: true},
+		{Name: "ok", Type: arrow.FixedWidthTypes.Boolean},
+		{Name: "time", Type: arrow.FixedWidthTypes.Timestamp_ms},
+		{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String)},
+	}, nil)
+	rec, _, err := array.RecordFromJSON(mem, schema, strings.NewReader(`[
+		{"host": "a", "status": 200, "bytes": 10, "ok": true, "time": "2024-03-01T00:00:00Z", "tags": ["x"]},
+		{"host": "b", "status": 500, "bytes": null, "ok": false, "time": "2024-03-01T00:00:01Z", "tags": []},
+		{"host": "a", "status": 404, "bytes": 2.5, "ok": false, "time": "2024-03-01T00:00:02Z", "tags": ["y", "z"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestNewTable(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.DefaultAllocator)
+	defer mem.AssertSize(t, 0)
+	rec := eventsRecord(t, mem)
+	defer rec.Release()
+
+	got := NewTable(rec)
+	start := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	want := &pqleval.Table{
+		Columns: []*pqleval.Column{
+			{Name: "host", Values: []any{"a", "b", "a"}},
+			{Name: "status", Values: []any{int64(200), int64(500), int64(404)}},
+			{Name: "bytes", Values: []any{10.0, nil, 2.5}},
+			{Name: "ok", Values: []any{true, false, false}},
+			{Name: "time", Values: []any{start, start.Add(time.Second), start.Add(2 * time.Second)}},
+			{Name: "tags", Values: []any{[]any{"x"}, []any{}, []any{"y", "z"}}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NewTable(...) (-want +got):\n%s", diff)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.DefaultAllocator)
+	defer mem.AssertSize(t, 0)
+	rec := eventsRecord(t, mem)
+	defer rec.Release()
+
+	ctx := context.Background()
+	q, err := pqleval.Prepare("Events | where status >= 400 | extend n = status / 100 | project host, status, bytes, n, failed = not(ok), time, tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := q.Run(ctx, &pqleval.Env{
+		Sources: map[string]pqleval.Source{
+			"Events": NewSource(rec.Schema(), []arrow.Record{rec}),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	r, err := NewRecordReader(ctx, mem, rows, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Release()
+
+	wantSchema := arrow.NewSchema([]arrow.Field{
+		{Name: "host", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "status", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+		{Name: "bytes", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		{Name: "n", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+		{Name: "failed", Type: arrow.FixedWidthTypes.Boolean, Nullable: true},
+		{Name: "time", Type: arrow.FixedWidthTypes.Timestamp_ns, Nullable: true},
+		{Name: "tags", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	if !r.Schema().Equal(wantSchema) {
+		t.Errorf("Schema() = %v; want %v", r.Schema(), wantSchema)
+	}
+	var got []string
+	for r.Next() {
+		data, err := r.Record().MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.TrimSpace(string(data)))
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`[{"bytes":null,"failed":true,"host":"b","n":5,"status":500,"tags":"[]","time":"2024-03-01 00:00:01Z"}` + "\n," +
+			`{"bytes":2.5,"failed":true,"host":"a","n":4,"status":404,"tags":"[\"y\",\"z\"]","time":"2024-03-01 00:00:02Z"}` + "\n]",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("records (-want +got):\n%s", diff)
+	}
+}
+
+func TestRecordReaderTypeMismatch(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.DefaultAllocator)
+	defer mem.AssertSize(t, 0)
+	ctx := context.Background()
+	rows, err := (&pqleval.Table{
+		Columns: []*pqleval.Column{
+			{Name: "x", Values: []any{int64(1), "two"}},
+		},
+	}).Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	r, err := NewRecordReader(ctx, mem, rows, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Release()
+	if !r.Next() {
+		t.Fatalf("first Next() = false; want true (err = %v)", r.Err())
+	}
+	if r.Next() {
+		t.Fatal("second Next() = true; want false")
+	}
+	if err := r.Err(); err == nil || !strings.Contains(err.Error(), "column x") {
+		t.Errorf("Err() = %v; want an error about column x", err)
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.DefaultAllocator)
+	defer mem.AssertSize(t, 0)
+	table := &pqleval.Table{
+		Columns: []*pqleval.Column{
+			{Name: "n", Values: []any{int64(1), 2.5, nil}},
+			{Name: "v", Values: []any{"s", true, map[string]any{"k": int64(1)}}},
+			{Name: "empty", Values: []any{nil, nil, nil}},
+		},
+	}
+	rec, err := NewRecord(mem, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+	got := NewTable(rec)
+	want := &pqleval.Table{
+		Columns: []*pqleval.Column{
+			{Name: "n", Values: []any{1.0, 2.5, nil}},
+			{Name: "v", Values: []any{"s", "true", `{"k":1}`}},
+			{Name: "empty", Values: []any{nil, nil, nil}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NewTable(NewRecord(...)) (-want +got):\n%s", diff)
+	}
+}
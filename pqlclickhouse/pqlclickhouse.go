@@ -0,0 +1,150 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pqlclickhouse compiles Pipeline Query Language queries for ClickHouse
+// with values bound as native ClickHouse query parameters
+// instead of being substituted into the SQL.
+//
+// The values returned by [Compile] are suitable for clickhouse-go's
+// clickhouse.WithParameters option:
+//
+//	sql, values, err := pqlclickhouse.Compile(nil, source, params)
+//	if err != nil {
+//		return err
+//	}
+//	ctx = clickhouse.Context(ctx, clickhouse.WithParameters(values))
+//	rows, err := conn.Query(ctx, sql)
+//
+// They can also be sent as param_<name> settings over the HTTP interface
+// or with SET param_<name> statements in clickhouse-client.
+package pqlclickhouse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runreveal/pql"
+)
+
+// A Parameter is a value bound to a query parameter.
+type Parameter struct {
+	// Value is the parameter's value.
+	// It may be a string, bool, any integer or floating point type,
+	// or a [time.Time].
+	// Other types require Type to be set
+	// and are formatted with [fmt.Sprint].
+	Value any
+
+	// Type is the ClickHouse type of the parameter, like "Int32".
+	// If Type is empty, it is inferred from the Go type of Value.
+	Type string
+}
+
+// Compile converts source to SQL like [pql.CompileOptions.Compile],
+// with each identifier in params replaced by a typed ClickHouse query parameter
+// like {name:Int32}.
+// It returns the SQL and the text values of the parameters keyed by name.
+// Entries in params take precedence over opts.Parameters.
+// opts may be nil.
+func Compile(opts *pql.CompileOptions, source string, params map[string]Parameter) (sql string, values map[string]string, err error) {
+	newOpts := new(pql.CompileOptions)
+	if opts != nil {
+		*newOpts = *opts
+	}
+	newOpts.Parameters = make(map[string]string, len(newOpts.Parameters)+len(params))
+	if opts != nil {
+		for k, v := range opts.Parameters {
+			newOpts.Parameters[k] = v
+		}
+	}
+	values = make(map[string]string, len(params))
+	for name, p := range params {
+		typ, value, err := p.format()
+		if err != nil {
+			return "", nil, fmt.Errorf("parameter %s: %v", name, err)
+		}
+		newOpts.Parameters[name] = "{" + name + ":" + typ + "}"
+		values[name] = value
+	}
+	sql, err = newOpts.Compile(source)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, values, nil
+}
+
+// format returns the ClickHouse type of p and its value
+// in the escaped text format that ClickHouse uses for query parameters.
+func (p Parameter) format() (typ, value string, err error) {
+	typ = p.Type
+	switch v := p.Value.(type) {
+	case string:
+		typ = orDefault(typ, "String")
+		value = valueEscaper.Replace(v)
+	case bool:
+		typ = orDefault(typ, "Bool")
+		value = strconv.FormatBool(v)
+	case int:
+		typ = orDefault(typ, "Int64")
+		value = strconv.FormatInt(int64(v), 10)
+	case int8:
+		typ = orDefault(typ, "Int8")
+		value = strconv.FormatInt(int64(v), 10)
+	case int16:
+		typ = orDefault(typ, "Int16")
+		value = strconv.FormatInt(int64(v), 10)
+	case int32:
+		typ = orDefault(typ, "Int32")
+		value = strconv.FormatInt(int64(v), 10)
+	case int64:
+		typ = orDefault(typ, "Int64")
+		value = strconv.FormatInt(v, 10)
+	case uint:
+		typ = orDefault(typ, "UInt64")
+		value = strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		typ = orDefault(typ, "UInt8")
+		value = strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		typ = orDefault(typ, "UInt16")
+		value = strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		typ = orDefault(typ, "UInt32")
+		value = strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		typ = orDefault(typ, "UInt64")
+		value = strconv.FormatUint(v, 10)
+	case float32:
+		typ = orDefault(typ, "Float32")
+		value = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		typ = orDefault(typ, "Float64")
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		typ = orDefault(typ, "DateTime64(9, 'UTC')")
+		value = v.UTC().Format("2006-01-02 15:04:05.000000000")
+	default:
+		if typ == "" {
+			return "", "", fmt.Errorf("cannot infer ClickHouse type for %T", p.Value)
+		}
+		value = valueEscaper.Replace(fmt.Sprint(v))
+	}
+	return typ, value, nil
+}
+
+func orDefault(typ, defaultType string) string {
+	if typ == "" {
+		return defaultType
+	}
+	return typ
+}
+
+// valueEscaper escapes the characters that are special
+// in ClickHouse's escaped text format.
+var valueEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\n", `\n`,
+)
@@ -0,0 +1,87 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqlclickhouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       *pql.CompileOptions
+		source     string
+		params     map[string]Parameter
+		want       string
+		wantValues map[string]string
+	}{
+		{
+			name:   "Int",
+			source: "Tokens | where Kind == desiredKind",
+			params: map[string]Parameter{
+				"desiredKind": {Value: int32(1)},
+			},
+			want:       `SELECT * FROM "Tokens" WHERE coalesce("Kind" = {desiredKind:Int32}, FALSE);`,
+			wantValues: map[string]string{"desiredKind": "1"},
+		},
+		{
+			name:   "EscapedString",
+			source: "T | where msg == m",
+			params: map[string]Parameter{
+				"m": {Value: "a\tb\\c"},
+			},
+			want:       `SELECT * FROM "T" WHERE coalesce("msg" = {m:String}, FALSE);`,
+			wantValues: map[string]string{"m": `a\tb\\c`},
+		},
+		{
+			name:   "ExplicitType",
+			source: "T | where ts > since and id == x",
+			params: map[string]Parameter{
+				"since": {Value: time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)},
+				"x":     {Value: "123", Type: "UInt64"},
+			},
+			want:       `SELECT * FROM "T" WHERE ("ts" > {since:DateTime64(9, 'UTC')}) AND (coalesce("id" = {x:UInt64}, FALSE));`,
+			wantValues: map[string]string{"since": "2024-03-01 12:00:00.000000000", "x": "123"},
+		},
+		{
+			name: "MergedOptions",
+			opts: &pql.CompileOptions{
+				Parameters: map[string]string{"limit": "10"},
+			},
+			source: "T | where ok == flag | take limit",
+			params: map[string]Parameter{
+				"flag": {Value: true},
+			},
+			want:       `SELECT * FROM "T" WHERE coalesce("ok" = {flag:Bool}, FALSE) LIMIT 10;`,
+			wantValues: map[string]string{"flag": "true"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, gotValues, err := Compile(test.opts, test.source, test.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("sql = %q; want %q", got, test.want)
+			}
+			if diff := cmp.Diff(test.wantValues, gotValues); diff != "" {
+				t.Errorf("values (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompileUnknownType(t *testing.T) {
+	_, _, err := Compile(nil, "T | where x == y", map[string]Parameter{
+		"y": {Value: struct{}{}},
+	})
+	if err == nil {
+		t.Error("Compile did not return an error")
+	}
+}
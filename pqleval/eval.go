@@ -0,0 +1,1321 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pqleval evaluates Pipeline Query Language queries
+// directly over in-memory Go data, without a database.
+//
+// The where, project, project-away, distinct, extend, mv-expand, summarize,
+// sort, take, top, count, join, union, and as operators are supported.
+// Expressions follow the semantics of the SQL produced by
+// [github.com/runreveal/pql.Compile] with the default options:
+// == and != are false if either operand is null,
+// other operators produce null if an operand is null,
+// and where only keeps rows for which the predicate is true.
+//
+// [Query.Run] evaluates a query as a pull-based iterator
+// over tables that can be read on demand from a [Source].
+package pqleval
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// A Table is a column-oriented set of rows.
+// Every column in a table has the same number of values.
+//
+// Values may be nil (null), bool, string, any integer or floating point type,
+// [time.Time], map[string]any, or []any.
+// Integers are converted to int64 and floating point numbers to float64
+// when a table is used in a query,
+// and the tables returned by evaluation only use those types.
+type Table struct {
+	Columns []*Column
+}
+
+// A Column is a named sequence of values in a [Table].
+type Column struct {
+	Name   string
+	Values []any
+}
+
+// NewTable returns a table containing the given rows.
+// The columns are the union of the keys in rows, sorted by name.
+// Keys that are missing from a row are null.
+func NewTable(rows []map[string]any) *Table {
+	var names []string
+	for _, row := range rows {
+		for k := range row {
+			names = append(names, k)
+		}
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	t := &Table{Columns: make([]*Column, 0, len(names))}
+	for _, name := range names {
+		col := &Column{Name: name, Values: make([]any, len(rows))}
+		for i, row := range rows {
+			col.Values[i] = row[name]
+		}
+		t.Columns = append(t.Columns, col)
+	}
+	return t
+}
+
+// Len returns the number of rows in t.
+func (t *Table) Len() int {
+	if t == nil || len(t.Columns) == 0 {
+		return 0
+	}
+	return len(t.Columns[0].Values)
+}
+
+// Column returns the column in t with the given name
+// or nil if there is no such column.
+func (t *Table) Column(name string) *Column {
+	if t == nil {
+		return nil
+	}
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return col
+		}
+	}
+	return nil
+}
+
+// Rows returns the rows of t as maps from column name to value.
+func (t *Table) Rows() []map[string]any {
+	rows := make([]map[string]any, t.Len())
+	for i := range rows {
+		rows[i] = make(map[string]any, len(t.Columns))
+		for _, col := range t.Columns {
+			rows[i][col.Name] = col.Values[i]
+		}
+	}
+	return rows
+}
+
+// Env is the environment that a query is evaluated in.
+type Env struct {
+	// Tables maps table names to their contents.
+	// A table in a database is named by the database name,
+	// a dot, and the table name, like "mydb.Events".
+	Tables map[string]*Table
+
+	// Sources maps table names to tables whose rows are read on demand,
+	// like large files.
+	// Names are formed the same way as in Tables,
+	// and a name in Tables takes precedence over the same name in Sources.
+	Sources map[string]Source
+
+	// Now returns the time used for the now() function.
+	// If Now is nil, [time.Now] is used.
+	Now func() time.Time
+}
+
+// A Query is a parsed query that can be evaluated any number of times.
+type Query struct {
+	source string
+	lets   []*parser.LetStatement
+	expr   *parser.TabularExpr
+}
+
+// Prepare parses the given Pipeline Query Language statement for evaluation.
+// Errors that refer to a location in source
+// can be retrieved with [errors.As] as a [parser.PositionedError].
+func Prepare(source string) (*Query, error) {
+	stmts, err := parser.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{source: source}
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *parser.TabularExpr:
+			if q.expr != nil {
+				return nil, &evalError{
+					source: source,
+					span:   stmt.Span(),
+					err:    fmt.Errorf("batch queries not supported"),
+				}
+			}
+			q.expr = stmt
+		case *parser.LetStatement:
+			if q.expr != nil {
+				// Skip let statements after the query:
+				// they should not be in scope.
+				continue
+			}
+			q.lets = append(q.lets, stmt)
+		default:
+			return nil, &evalError{
+				source: source,
+				span:   stmt.Span(),
+				err:    fmt.Errorf("unhandled %T statement", stmt),
+			}
+		}
+	}
+	if q.expr == nil {
+		return nil, fmt.Errorf("missing tabular queries")
+	}
+	return q, nil
+}
+
+// Eval parses and evaluates the given Pipeline Query Language statement in env.
+// It is equivalent to calling [Prepare] followed by [Query.Eval].
+func Eval(source string, env *Env) (*Table, error) {
+	q, err := Prepare(source)
+	if err != nil {
+		return nil, err
+	}
+	return q.Eval(env)
+}
+
+// Eval evaluates the query in env and returns the entire result.
+// env may be nil if the query does not refer to any tables.
+func (q *Query) Eval(env *Env) (*Table, error) {
+	ctx := context.Background()
+	rows, err := q.Run(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+	return Collect(ctx, rows)
+}
+
+// Run starts evaluating the query in env
+// and returns an iterator over the result.
+// env may be nil if the query does not refer to any tables.
+//
+// Rows are computed as they are requested,
+// so where, project, extend, take, count, and the left side of join
+// only hold a single row of their input in memory at a time.
+// summarize, sort, top, and the right side of join
+// read their entire input before producing any rows.
+// The caller must close the returned iterator.
+func (q *Query) Run(ctx context.Context, env *Env) (*Rows, error) {
+	e := &evaluator{
+		source: q.source,
+		scope:  make(map[string]any),
+		lets:   make(map[string]tabularBinding),
+		now:    time.Now,
+	}
+	if env != nil {
+		e.tables = env.Tables
+		e.sources = env.Sources
+		if env.Now != nil {
+			e.now = env.Now
+		}
+	}
+	for _, stmt := range q.lets {
+		if stmt.Tabular != nil {
+			e.lets[stmt.Name.Name] = e.fork().bindTabularExpr(stmt.Tabular)
+			continue
+		}
+		v, err := e.eval(stmt.X, nil)
+		if err != nil {
+			return nil, err
+		}
+		e.scope[stmt.Name.Name] = v
+	}
+	s, err := e.tabularExpr(ctx, q.expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{s: s}, nil
+}
+
+// table is the row-oriented representation of a [Table] used during evaluation.
+type table struct {
+	cols []string
+	rows [][]any
+}
+
+// index returns a map of column names to their position in a row.
+func (t *table) index() map[string]int {
+	m := make(map[string]int, len(t.cols))
+	for i, name := range t.cols {
+		m[name] = i
+	}
+	return m
+}
+
+// A tabularBinding produces a new stream for each reference
+// to a name bound by a tabular let statement or an as operator.
+type tabularBinding func(ctx context.Context) (*stream, error)
+
+type evaluator struct {
+	source  string
+	tables  map[string]*Table
+	sources map[string]Source
+	now     func() time.Time
+
+	// scope is the set of values bound by scalar let statements.
+	scope map[string]any
+	// lets is the set of tables bound by tabular let statements
+	// and as operators.
+	lets map[string]tabularBinding
+}
+
+// fork returns a copy of e whose bindings can change independently of e.
+func (e *evaluator) fork() *evaluator {
+	e2 := new(evaluator)
+	*e2 = *e
+	e2.scope = maps.Clone(e.scope)
+	e2.lets = maps.Clone(e.lets)
+	return e2
+}
+
+// bindTabularExpr returns a binding that evaluates expr in e.
+func (e *evaluator) bindTabularExpr(expr *parser.TabularExpr) tabularBinding {
+	return func(ctx context.Context) (*stream, error) {
+		return e.tabularExpr(ctx, expr)
+	}
+}
+
+func (e *evaluator) tabularExpr(ctx context.Context, expr *parser.TabularExpr) (*stream, error) {
+	s, err := e.dataSource(ctx, expr.Source)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range expr.Operators {
+		if op, ok := op.(*parser.AsOperator); ok {
+			// References to the name re-evaluate the pipeline up to this point.
+			e.lets[op.Name.Name] = e.fork().bindTabularExpr(&parser.TabularExpr{
+				Source:    expr.Source,
+				Operators: expr.Operators[:i],
+			})
+			continue
+		}
+		next, err := e.operator(ctx, s, op)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s = next
+	}
+	return s, nil
+}
+
+func (e *evaluator) dataSource(ctx context.Context, src parser.TabularDataSource) (*stream, error) {
+	switch src := src.(type) {
+	case *parser.ParenTabularExpr:
+		return e.tabularExpr(ctx, src.X)
+	case *parser.TableRef:
+		if src.Cluster != nil {
+			return nil, &evalError{
+				source: e.source,
+				span:   src.Cluster.Span(),
+				err:    fmt.Errorf("clusters not supported"),
+			}
+		}
+		name := src.Table.Name
+		if src.Database == nil {
+			if b := e.lets[name]; b != nil {
+				return b(ctx)
+			}
+		} else {
+			name = src.Database.Name + "." + name
+		}
+		s, ok, err := e.openTable(ctx, name)
+		if err != nil {
+			return nil, &evalError{
+				source: e.source,
+				span:   src.Span(),
+				err:    fmt.Errorf("open %s: %w", name, err),
+			}
+		}
+		if !ok {
+			return nil, &evalError{
+				source: e.source,
+				span:   src.Span(),
+				err:    fmt.Errorf("unknown table %q", name),
+			}
+		}
+		return s, nil
+	case *parser.TableWildcard:
+		prefix := ""
+		if src.Database != nil {
+			prefix = src.Database.Name + "."
+		}
+		return e.matchTables(ctx, src, prefix+src.Pattern)
+	case *parser.TableCall:
+		name, err := e.eval(src.Name, nil)
+		if err != nil {
+			return nil, err
+		}
+		pattern, ok := name.(string)
+		if !ok {
+			return nil, &evalError{
+				source: e.source,
+				span:   src.Name.Span(),
+				err:    fmt.Errorf("table name must be a string"),
+			}
+		}
+		return e.matchTables(ctx, src, pattern)
+	case *parser.UnionSource:
+		return e.unionTables(ctx, nil, src.SourceColumn, src.Tables)
+	default:
+		return nil, fmt.Errorf("unhandled data source %T", src)
+	}
+}
+
+// openTable returns a stream of the rows of the named table
+// in the environment.
+// It reports false if there is no such table.
+func (e *evaluator) openTable(ctx context.Context, name string) (_ *stream, ok bool, err error) {
+	if t := e.tables[name]; t != nil {
+		return t.stream(), true, nil
+	}
+	src := e.sources[name]
+	if src == nil {
+		return nil, false, nil
+	}
+	it, err := src.Open(ctx)
+	if err != nil {
+		return nil, true, err
+	}
+	return iteratorStream(it), true, nil
+}
+
+// matchTables returns the union of the tables in the environment
+// whose names match pattern,
+// where an asterisk in pattern matches any sequence of characters.
+func (e *evaluator) matchTables(ctx context.Context, src parser.TabularDataSource, pattern string) (*stream, error) {
+	inputs, err := e.openMatchingTables(ctx, nil, src, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return concat(inputs, ""), nil
+}
+
+// openMatchingTables appends a stream to inputs for each table in the environment
+// whose name matches pattern.
+// If it returns an error, it closes the streams in inputs.
+func (e *evaluator) openMatchingTables(ctx context.Context, inputs []namedStream, src parser.TabularDataSource, pattern string) ([]namedStream, error) {
+	var names []string
+	for name := range e.tables {
+		if matchWildcard(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	for name := range e.sources {
+		if _, dup := e.tables[name]; !dup && matchWildcard(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		closeStreams(inputs)
+		return nil, &evalError{
+			source: e.source,
+			span:   src.Span(),
+			err:    fmt.Errorf("no tables match %q", pattern),
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		s, _, err := e.openTable(ctx, name)
+		if err != nil {
+			closeStreams(inputs)
+			return nil, &evalError{
+				source: e.source,
+				span:   src.Span(),
+				err:    fmt.Errorf("open %s: %w", name, err),
+			}
+		}
+		inputs = append(inputs, namedStream{name: name, stream: s})
+	}
+	return inputs, nil
+}
+
+// union returns the rows of s followed by the rows of the operator's tables.
+func (e *evaluator) union(ctx context.Context, s *stream, op *parser.UnionOperator) (*stream, error) {
+	inputs := []namedStream{{name: "union_arg0", stream: s}}
+	return e.unionTables(ctx, inputs, op.SourceColumn, op.Tables)
+}
+
+// unionTables appends the streams of tables to inputs
+// and returns their concatenation.
+// If sourceColumn is not nil, the result has a column with that name
+// holding the name of the table that each row came from.
+// Like in KQL, arguments that are not tables are named union_argN,
+// where N is the argument's position in the union.
+func (e *evaluator) unionTables(ctx context.Context, inputs []namedStream, sourceColumn *parser.Ident, tables []parser.TabularDataSource) (*stream, error) {
+	argOffset := len(inputs)
+	for i, src := range tables {
+		var err error
+		switch src := src.(type) {
+		case *parser.TableWildcard:
+			prefix := ""
+			if src.Database != nil {
+				prefix = src.Database.Name + "."
+			}
+			inputs, err = e.openMatchingTables(ctx, inputs, src, prefix+src.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case *parser.TableCall:
+			var name any
+			name, err = e.eval(src.Name, nil)
+			if err != nil {
+				closeStreams(inputs)
+				return nil, err
+			}
+			pattern, ok := name.(string)
+			if !ok {
+				closeStreams(inputs)
+				return nil, &evalError{
+					source: e.source,
+					span:   src.Name.Span(),
+					err:    fmt.Errorf("table name must be a string"),
+				}
+			}
+			inputs, err = e.openMatchingTables(ctx, inputs, src, pattern)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		s, err := e.dataSource(ctx, src)
+		if err != nil {
+			closeStreams(inputs)
+			return nil, err
+		}
+		name := fmt.Sprintf("union_arg%d", argOffset+i)
+		if ref, ok := src.(*parser.TableRef); ok {
+			name = ref.Table.Name
+		}
+		inputs = append(inputs, namedStream{name: name, stream: s})
+	}
+	column := ""
+	if sourceColumn != nil {
+		column = sourceColumn.Name
+	}
+	return concat(inputs, column), nil
+}
+
+// A namedStream is an input to [concat].
+type namedStream struct {
+	name string
+	*stream
+}
+
+// closeStreams closes all the given streams.
+// It returns the first error encountered.
+func closeStreams(inputs []namedStream) error {
+	var firstErr error
+	for _, s := range inputs {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// concat returns the rows of each input in order.
+// Columns are matched by name,
+// and columns that an input does not have are null in its rows.
+// If sourceColumn is not empty, the result starts with a column with that name
+// holding the name of the input that each row came from.
+func concat(inputs []namedStream, sourceColumn string) *stream {
+	result := &stream{close: func() error { return closeStreams(inputs) }}
+	if sourceColumn != "" {
+		result.cols = append(result.cols, sourceColumn)
+	}
+	for _, s := range inputs {
+		for _, col := range s.cols {
+			if !slices.Contains(result.cols, col) {
+				result.cols = append(result.cols, col)
+			}
+		}
+	}
+
+	resultIndex := (&table{cols: result.cols}).index()
+	remaining := inputs
+	result.next = func(ctx context.Context) ([]any, error) {
+		for len(remaining) > 0 {
+			s := remaining[0]
+			values, err := s.next(ctx)
+			if err == io.EOF {
+				if err := s.Close(); err != nil {
+					return nil, err
+				}
+				remaining = remaining[1:]
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			newValues := make([]any, len(result.cols))
+			for j, col := range s.cols {
+				newValues[resultIndex[col]] = values[j]
+			}
+			if sourceColumn != "" {
+				newValues[0] = s.name
+			}
+			return newValues, nil
+		}
+		return nil, io.EOF
+	}
+	return result
+}
+
+// matchWildcard reports whether name matches pattern,
+// where an asterisk in pattern matches any sequence of characters.
+func matchWildcard(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return len(name) >= len(last) && strings.HasSuffix(name, last)
+}
+
+func (e *evaluator) operator(ctx context.Context, s *stream, op parser.TabularOperator) (*stream, error) {
+	switch op := op.(type) {
+	case *parser.WhereOperator:
+		return e.where(s, op), nil
+	case *parser.ProjectOperator:
+		return e.project(s, op), nil
+	case *parser.ProjectAwayOperator:
+		return projectAway(s, op), nil
+	case *parser.DistinctOperator:
+		return e.distinct(s, op), nil
+	case *parser.ExtendOperator:
+		return e.extend(s, op), nil
+	case *parser.MvExpandOperator:
+		return e.mvExpand(s, op), nil
+	case *parser.SummarizeOperator:
+		return e.summarize(s, op), nil
+	case *parser.SortOperator:
+		return e.sort(s, op.Terms), nil
+	case *parser.TakeOperator:
+		return e.take(s, op.RowCount)
+	case *parser.TopOperator:
+		return e.take(e.sort(s, []*parser.SortTerm{op.Col}), op.RowCount)
+	case *parser.CountOperator:
+		return count(s), nil
+	case *parser.JoinOperator:
+		return e.join(ctx, s, op)
+	case *parser.UnionOperator:
+		return e.union(ctx, s, op)
+	default:
+		return nil, &evalError{
+			source: e.source,
+			span:   op.Span(),
+			err:    fmt.Errorf("unsupported operator %T", op),
+		}
+	}
+}
+
+func (e *evaluator) where(s *stream, op *parser.WhereOperator) *stream {
+	index := s.index()
+	return &stream{
+		cols: s.cols,
+		next: func(ctx context.Context) ([]any, error) {
+			for {
+				values, err := s.next(ctx)
+				if err != nil {
+					return nil, err
+				}
+				v, err := e.eval(op.Predicate, &row{cols: index, values: values})
+				if err != nil {
+					return nil, err
+				}
+				keep, err := e.truth(op.Predicate, v)
+				if err != nil {
+					return nil, err
+				}
+				if keep {
+					return values, nil
+				}
+			}
+		},
+		close: s.Close,
+	}
+}
+
+func (e *evaluator) project(s *stream, op *parser.ProjectOperator) *stream {
+	cols := make([]string, len(op.Cols))
+	exprs := make([]parser.Expr, len(op.Cols))
+	for j, col := range op.Cols {
+		cols[j] = col.Name.Name
+		exprs[j] = col.X
+		if exprs[j] == nil {
+			exprs[j] = col.Name.AsQualified()
+		}
+	}
+	index := s.index()
+	return &stream{
+		cols: cols,
+		next: func(ctx context.Context) ([]any, error) {
+			values, err := s.next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			r := &row{cols: index, values: values}
+			newValues := make([]any, len(exprs))
+			for j, x := range exprs {
+				v, err := e.eval(x, r)
+				if err != nil {
+					return nil, err
+				}
+				newValues[j] = v
+			}
+			return newValues, nil
+		},
+		close: s.Close,
+	}
+}
+
+// projectAway returns s without the columns named by op.
+func projectAway(s *stream, op *parser.ProjectAwayOperator) *stream {
+	var cols []string
+	var src []int
+	for j, name := range s.cols {
+		if !slices.ContainsFunc(op.Cols, func(id *parser.Ident) bool { return id.Name == name }) {
+			cols = append(cols, name)
+			src = append(src, j)
+		}
+	}
+	return &stream{
+		cols: cols,
+		next: func(ctx context.Context) ([]any, error) {
+			values, err := s.next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			newValues := make([]any, len(src))
+			for j, k := range src {
+				newValues[j] = values[k]
+			}
+			return newValues, nil
+		},
+		close: s.Close,
+	}
+}
+
+// distinct returns the first row of s with each combination of op's columns.
+func (e *evaluator) distinct(s *stream, op *parser.DistinctOperator) *stream {
+	cols := make([]string, len(op.Cols))
+	for j, col := range op.Cols {
+		cols[j] = col.Name
+	}
+	index := s.index()
+	seen := make(map[string]struct{})
+	return &stream{
+		cols: cols,
+		next: func(ctx context.Context) ([]any, error) {
+			for {
+				values, err := s.next(ctx)
+				if err != nil {
+					return nil, err
+				}
+				r := &row{cols: index, values: values}
+				newValues := make([]any, len(op.Cols))
+				for j, col := range op.Cols {
+					v, err := e.eval(col.AsQualified(), r)
+					if err != nil {
+						return nil, err
+					}
+					newValues[j] = v
+				}
+				k := groupKey(newValues)
+				if _, dup := seen[k]; dup {
+					continue
+				}
+				seen[k] = struct{}{}
+				return newValues, nil
+			}
+		},
+		close: s.Close,
+	}
+}
+
+func (e *evaluator) extend(s *stream, op *parser.ExtendOperator) *stream {
+	// Columns with the name of an existing column replace it.
+	cols := slices.Clone(s.cols)
+	resultIndex := s.index()
+	dst := make([]int, len(op.Cols))
+	exprs := make([]parser.Expr, len(op.Cols))
+	for j, col := range op.Cols {
+		exprs[j] = col.X
+		var name string
+		switch {
+		case col.Name != nil:
+			name = col.Name.Name
+			if col.X == nil {
+				exprs[j] = col.Name.AsQualified()
+			}
+		default:
+			name = e.derivedColumnName(col.X)
+		}
+		if k, ok := resultIndex[name]; ok {
+			dst[j] = k
+		} else {
+			dst[j] = len(cols)
+			resultIndex[name] = dst[j]
+			cols = append(cols, name)
+		}
+	}
+
+	index := s.index()
+	return &stream{
+		cols: cols,
+		next: func(ctx context.Context) ([]any, error) {
+			values, err := s.next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			r := &row{cols: index, values: values}
+			newValues := make([]any, len(cols))
+			copy(newValues, values)
+			for j, x := range exprs {
+				v, err := e.eval(x, r)
+				if err != nil {
+					return nil, err
+				}
+				newValues[dst[j]] = v
+			}
+			return newValues, nil
+		},
+		close: s.Close,
+	}
+}
+
+// mvExpand returns a row for each element of the arrays in op's columns.
+// Arrays in the same row are expanded in parallel,
+// with missing elements of shorter arrays set to nil.
+// Rows whose arrays are all empty or nil are dropped.
+func (e *evaluator) mvExpand(s *stream, op *parser.MvExpandOperator) *stream {
+	// Columns with the name of an existing column replace it.
+	cols := slices.Clone(s.cols)
+	resultIndex := s.index()
+	dst := make([]int, len(op.Cols))
+	for j, col := range op.Cols {
+		var name string
+		if col.Name != nil {
+			name = col.Name.Name
+		} else {
+			name = e.derivedColumnName(col.X)
+		}
+		if k, ok := resultIndex[name]; ok {
+			dst[j] = k
+		} else {
+			dst[j] = len(cols)
+			resultIndex[name] = dst[j]
+			cols = append(cols, name)
+		}
+	}
+
+	index := s.index()
+	var (
+		values []any
+		arrays = make([][]any, len(op.Cols))
+		i, n   int
+	)
+	return &stream{
+		cols: cols,
+		next: func(ctx context.Context) ([]any, error) {
+			for i >= n {
+				var err error
+				values, err = s.next(ctx)
+				if err != nil {
+					return nil, err
+				}
+				r := &row{cols: index, values: values}
+				i, n = 0, 0
+				for j, col := range op.Cols {
+					v, err := e.eval(col.X, r)
+					if err != nil {
+						return nil, err
+					}
+					switch v := v.(type) {
+					case nil:
+						arrays[j] = nil
+					case []any:
+						arrays[j] = v
+					default:
+						arrays[j] = []any{v}
+					}
+					n = max(n, len(arrays[j]))
+				}
+			}
+			newValues := make([]any, len(cols))
+			copy(newValues, values)
+			for j, a := range arrays {
+				newValues[dst[j]] = nil
+				if i < len(a) {
+					newValues[dst[j]] = a[i]
+				}
+			}
+			i++
+			return newValues, nil
+		},
+		close: s.Close,
+	}
+}
+
+func (e *evaluator) summarize(s *stream, op *parser.SummarizeOperator) *stream {
+	var cols []string
+	for _, col := range op.GroupBy {
+		cols = append(cols, e.summarizeColumnName(col))
+	}
+	for _, col := range op.Cols {
+		cols = append(cols, e.summarizeColumnName(col))
+	}
+
+	return buffered(s, cols, func(t *table) (*table, error) {
+		result := &table{cols: cols}
+
+		// Partition the rows by the values of the group by columns.
+		index := t.index()
+		type group struct {
+			keys []any
+			rows [][]any
+		}
+		var groups []*group
+		groupIndex := make(map[string]*group)
+		for _, values := range t.rows {
+			r := &row{cols: index, values: values}
+			keys := make([]any, len(op.GroupBy))
+			for j, col := range op.GroupBy {
+				v, err := e.eval(col.X, r)
+				if err != nil {
+					return nil, err
+				}
+				keys[j] = v
+			}
+			k := groupKey(keys)
+			g := groupIndex[k]
+			if g == nil {
+				g = &group{keys: keys}
+				groupIndex[k] = g
+				groups = append(groups, g)
+			}
+			g.rows = append(g.rows, values)
+		}
+		if len(groups) == 0 && len(op.GroupBy) == 0 {
+			// Aggregating an empty table without grouping produces a single row.
+			groups = append(groups, new(group))
+		}
+
+		for _, g := range groups {
+			r := &row{cols: index, group: g.rows, aggregate: true}
+			if len(g.rows) > 0 {
+				r.values = g.rows[0]
+			}
+			values := append(make([]any, 0, len(result.cols)), g.keys...)
+			for _, col := range op.Cols {
+				v, err := e.eval(col.X, r)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, v)
+			}
+			result.rows = append(result.rows, values)
+		}
+		return result, nil
+	})
+}
+
+func (e *evaluator) summarizeColumnName(col *parser.SummarizeColumn) string {
+	if col.Name != nil {
+		return col.Name.Name
+	}
+	return e.derivedColumnName(col.X)
+}
+
+// derivedColumnName returns the name of an unnamed column,
+// which is the source text of its expression.
+func (e *evaluator) derivedColumnName(x parser.Expr) string {
+	span := x.Span()
+	return e.source[span.Start:span.End]
+}
+
+func (e *evaluator) sort(s *stream, terms []*parser.SortTerm) *stream {
+	return buffered(s, s.cols, func(t *table) (*table, error) {
+		index := t.index()
+		keys := make([][]any, len(t.rows))
+		for i, values := range t.rows {
+			r := &row{cols: index, values: values}
+			keys[i] = make([]any, len(terms))
+			for j, term := range terms {
+				v, err := e.eval(term.X, r)
+				if err != nil {
+					return nil, err
+				}
+				keys[i][j] = v
+			}
+		}
+
+		order := make([]int, len(t.rows))
+		for i := range order {
+			order[i] = i
+		}
+		var sortErr error
+		slices.SortStableFunc(order, func(a, b int) int {
+			for j, term := range terms {
+				x, y := keys[a][j], keys[b][j]
+				switch {
+				case x == nil && y == nil:
+					continue
+				case x == nil || y == nil:
+					if (x == nil) == term.NullsFirst {
+						return -1
+					}
+					return 1
+				}
+				c, ok := compare(x, y)
+				if !ok {
+					if sortErr == nil {
+						sortErr = &evalError{
+							source: e.source,
+							span:   term.X.Span(),
+							err:    fmt.Errorf("cannot compare %T and %T", x, y),
+						}
+					}
+					return 0
+				}
+				if !term.Asc {
+					c = -c
+				}
+				if c != 0 {
+					return c
+				}
+			}
+			return 0
+		})
+		if sortErr != nil {
+			return nil, sortErr
+		}
+
+		result := &table{
+			cols: t.cols,
+			rows: make([][]any, len(order)),
+		}
+		for i, j := range order {
+			result.rows[i] = t.rows[j]
+		}
+		return result, nil
+	})
+}
+
+func (e *evaluator) take(s *stream, rowCount parser.Expr) (*stream, error) {
+	v, err := e.eval(rowCount, nil)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := v.(int64)
+	if !ok || n < 0 {
+		return nil, &evalError{
+			source: e.source,
+			span:   rowCount.Span(),
+			err:    fmt.Errorf("row count is not a valid integer"),
+		}
+	}
+	return &stream{
+		cols: s.cols,
+		next: func(ctx context.Context) ([]any, error) {
+			if n <= 0 {
+				// Release the input as soon as the limit is reached.
+				if err := s.Close(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			n--
+			return s.next(ctx)
+		},
+		close: s.Close,
+	}, nil
+}
+
+// count returns a stream with the number of rows in s.
+func count(s *stream) *stream {
+	done := false
+	return &stream{
+		cols: []string{"count()"},
+		next: func(ctx context.Context) ([]any, error) {
+			if done {
+				return nil, io.EOF
+			}
+			var n int64
+			for {
+				_, err := s.next(ctx)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				n++
+			}
+			done = true
+			return []any{n}, nil
+		},
+		close: s.Close,
+	}
+}
+
+func (e *evaluator) join(ctx context.Context, left *stream, op *parser.JoinOperator) (*stream, error) {
+	flavor := "innerunique"
+	if op.Flavor != nil {
+		flavor = op.Flavor.Name
+	}
+	switch flavor {
+	case "innerunique", "inner", "cross", "leftouter", "rightouter", "fullouter",
+		"leftsemi", "leftanti", "rightsemi", "rightanti":
+	default:
+		return nil, &evalError{
+			source: e.source,
+			span:   op.Flavor.Span(),
+			err:    fmt.Errorf("unhandled join type %q", flavor),
+		}
+	}
+	rightStream, err := e.tabularExpr(ctx, op.Right)
+	if err != nil {
+		return nil, err
+	}
+	conds := make([]parser.Expr, len(op.Conditions))
+	for i, c := range op.Conditions {
+		conds[i] = simpleJoinCondition(c)
+	}
+
+	result := &stream{
+		close: func() error {
+			err1 := left.Close()
+			err2 := rightStream.Close()
+			if err1 != nil {
+				return err1
+			}
+			return err2
+		},
+	}
+	switch flavor {
+	case "leftsemi", "leftanti":
+		result.cols = left.cols
+	case "rightsemi", "rightanti":
+		result.cols = rightStream.cols
+	default:
+		result.cols = joinColumns(left.cols, rightStream.cols)
+	}
+	combine := func(l, r []any) []any {
+		values := make([]any, 0, len(result.cols))
+		if l == nil {
+			l = make([]any, len(left.cols))
+		}
+		if r == nil {
+			r = make([]any, len(rightStream.cols))
+		}
+		values = append(values, l...)
+		values = append(values, r...)
+		return values
+	}
+
+	var (
+		right        *table
+		rightMatched []bool
+		seen         map[string]struct{}
+		pending      [][]any
+		leftDone     bool
+	)
+	leftIndex := left.index()
+	rightIndex := (&table{cols: rightStream.cols}).index()
+	if flavor == "innerunique" {
+		seen = make(map[string]struct{})
+	}
+	result.next = func(ctx context.Context) ([]any, error) {
+		if right == nil {
+			var err error
+			right, err = collect(ctx, rightStream)
+			if err != nil {
+				return nil, err
+			}
+			rightMatched = make([]bool, len(right.rows))
+		}
+		for len(pending) == 0 {
+			if leftDone {
+				return nil, io.EOF
+			}
+			l, err := left.next(ctx)
+			if err == io.EOF {
+				// Emit the rows that depend on every row on the left being read.
+				leftDone = true
+				for j, r := range right.rows {
+					switch flavor {
+					case "rightsemi", "rightanti":
+						if rightMatched[j] == (flavor == "rightsemi") {
+							pending = append(pending, r)
+						}
+					case "rightouter", "fullouter":
+						if !rightMatched[j] {
+							pending = append(pending, combine(nil, r))
+						}
+					}
+				}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if seen != nil {
+				k := groupKey(l)
+				if _, dup := seen[k]; dup {
+					continue
+				}
+				seen[k] = struct{}{}
+			}
+
+			// Find the rows on the right that match l.
+			leftMatched := false
+			for j, r := range right.rows {
+				jr := &row{
+					left:  &row{cols: leftIndex, values: l},
+					right: &row{cols: rightIndex, values: r},
+				}
+				match := true
+				for _, c := range conds {
+					v, err := e.eval(c, jr)
+					if err != nil {
+						return nil, err
+					}
+					match, err = e.truth(c, v)
+					if err != nil {
+						return nil, err
+					}
+					if !match {
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				leftMatched = true
+				rightMatched[j] = true
+				switch flavor {
+				case "innerunique", "inner", "cross", "leftouter", "rightouter", "fullouter":
+					pending = append(pending, combine(l, r))
+				}
+			}
+			switch flavor {
+			case "leftsemi", "leftanti":
+				if leftMatched == (flavor == "leftsemi") {
+					pending = append(pending, l)
+				}
+			case "leftouter", "fullouter":
+				if !leftMatched {
+					pending = append(pending, combine(l, nil))
+				}
+			}
+		}
+		values := pending[0]
+		pending = pending[1:]
+		return values, nil
+	}
+	return result, nil
+}
+
+// simpleJoinCondition rewrites a join condition that consists
+// of a single identifier x to "$left.x == $right.x".
+func simpleJoinCondition(c parser.Expr) parser.Expr {
+	id, ok := c.(*parser.QualifiedIdent)
+	if !ok || len(id.Parts) != 1 || id.Parts[0].Quoted {
+		return c
+	}
+	if _, isBuiltin := builtinValues[id.Parts[0].Name]; isBuiltin {
+		return c
+	}
+	return &parser.BinaryExpr{
+		X: &parser.QualifiedIdent{
+			Parts: []*parser.Ident{{Name: leftJoinTableAlias}, id.Parts[0]},
+		},
+		OpSpan: id.Span(),
+		Op:     parser.TokenEq,
+		Y: &parser.QualifiedIdent{
+			Parts: []*parser.Ident{{Name: rightJoinTableAlias}, id.Parts[0]},
+		},
+	}
+}
+
+// joinColumns returns the columns of a join of tables
+// with the given columns.
+// Columns on the right with the same name as another column
+// have a number appended to them, like "x1".
+func joinColumns(left, right []string) []string {
+	cols := slices.Clone(left)
+	for _, name := range right {
+		newName := name
+		for i := 1; slices.Contains(cols, newName); i++ {
+			newName = fmt.Sprintf("%s%d", name, i)
+		}
+		cols = append(cols, newName)
+	}
+	return cols
+}
+
+// groupKey returns a string that is equal for equal lists of values.
+func groupKey(values []any) string {
+	sb := new(strings.Builder)
+	for _, v := range values {
+		fmt.Fprintf(sb, "%T:%#v\x00", v, v)
+	}
+	return sb.String()
+}
+
+type evalError struct {
+	source string
+	span   parser.Span
+	err    error
+}
+
+func (e *evalError) Error() string {
+	if !e.span.IsValid() {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Position(), e.err.Error())
+}
+
+// Span returns the span of the source that the error refers to.
+// The span may be invalid if the error does not refer to a specific location.
+func (e *evalError) Span() parser.Span {
+	return e.span
+}
+
+// Position returns the position of the start of the error's span
+// or the zero Position if the span is invalid.
+func (e *evalError) Position() parser.Position {
+	if !e.span.IsValid() {
+		return parser.Position{}
+	}
+	return parser.PositionFor(e.source, e.span.Start)
+}
+
+// Source returns the query that the error's span refers to.
+func (e *evalError) Source() string {
+	return e.source
+}
+
+func (e *evalError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,398 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqleval
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql/parser"
+)
+
+func testEnv() *Env {
+	return &Env{
+		Tables: map[string]*Table{
+			"People": {
+				Columns: []*Column{
+					{Name: "name", Values: []any{"Alice", "Bob", "Carol", "Dave"}},
+					{Name: "age", Values: []any{30, 25, 35, nil}},
+					{Name: "team", Values: []any{"red", "blue", "red", nil}},
+				},
+			},
+			"Teams": NewTable([]map[string]any{
+				{"team": "red", "floor": 1},
+				{"team": "green", "floor": 2},
+			}),
+			"Hosts": NewTable([]map[string]any{
+				{"host": "a", "ips": []any{"10.0.0.1", "10.0.0.2"}, "ports": []any{"22"}},
+				{"host": "b", "ips": []any{}},
+			}),
+			"Logs_a":  NewTable([]map[string]any{{"msg": "x"}}),
+			"Logs_b":  NewTable([]map[string]any{{"msg": "y", "level": "warn"}}),
+			"db.Sink": NewTable([]map[string]any{{"n": uint8(7)}}),
+		},
+		Now: func() time.Time {
+			return time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+		},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  *Table
+	}{
+		{
+			name:  "Where",
+			query: "People | where age > 26 | project name",
+			want:  table1("name", "Alice", "Carol"),
+		},
+		{
+			name:  "WhereNullEquality",
+			query: "People | where team != 'red' | project name",
+			want:  table1("name", "Bob"),
+		},
+		{
+			name:  "CaseInsensitive",
+			query: "People | where name =~ 'ALICE' | project name",
+			want:  table1("name", "Alice"),
+		},
+		{
+			name:  "In",
+			query: "People | where team in ('blue', 'green') | project name",
+			want:  table1("name", "Bob"),
+		},
+		{
+			name:  "ThreeValuedLogic",
+			query: "People | where age > 26 or team == 'blue' | project name",
+			want:  table1("name", "Alice", "Bob", "Carol"),
+		},
+		{
+			name:  "Extend",
+			query: "People | where name == 'Bob' | extend next = age + 1, team = toupper(team), strcat(name, '!')",
+			want: &Table{Columns: []*Column{
+				{Name: "name", Values: []any{"Bob"}},
+				{Name: "age", Values: []any{int64(25)}},
+				{Name: "team", Values: []any{"BLUE"}},
+				{Name: "next", Values: []any{int64(26)}},
+				{Name: "strcat(name, '!')", Values: []any{"Bob!"}},
+			}},
+		},
+		{
+			name:  "Summarize",
+			query: "People | summarize n = count(), total = sum(age), oldest = max(age) by team | sort by team asc nulls last",
+			want: &Table{Columns: []*Column{
+				{Name: "team", Values: []any{"blue", "red", nil}},
+				{Name: "n", Values: []any{int64(1), int64(2), int64(1)}},
+				{Name: "total", Values: []any{int64(25), int64(65), nil}},
+				{Name: "oldest", Values: []any{int64(25), int64(35), nil}},
+			}},
+		},
+		{
+			name:  "SummarizeEmpty",
+			query: "People | where false | summarize count(), avg(age)",
+			want: &Table{Columns: []*Column{
+				{Name: "count()", Values: []any{int64(0)}},
+				{Name: "avg(age)", Values: []any{nil}},
+			}},
+		},
+		{
+			name:  "SortDefault",
+			query: "People | sort by age | project name",
+			want:  table1("name", "Carol", "Alice", "Bob", "Dave"),
+		},
+		{
+			name:  "Take",
+			query: "let n = 1; People | take n + 1 | project name",
+			want:  table1("name", "Alice", "Bob"),
+		},
+		{
+			name:  "Top",
+			query: "People | top 1 by age asc nulls last | project name",
+			want:  table1("name", "Bob"),
+		},
+		{
+			name:  "ProjectAway",
+			query: "People | where name == 'Bob' | project-away age, name",
+			want:  table1("team", "blue"),
+		},
+		{
+			name:  "Distinct",
+			query: "People | distinct team",
+			want:  table1("team", "red", "blue", nil),
+		},
+		{
+			name:  "MvExpand",
+			query: "Hosts | mv-expand ips, port = ports",
+			want: &Table{Columns: []*Column{
+				{Name: "host", Values: []any{"a", "a"}},
+				{Name: "ips", Values: []any{"10.0.0.1", "10.0.0.2"}},
+				{Name: "ports", Values: []any{[]any{"22"}, []any{"22"}}},
+				{Name: "port", Values: []any{"22", nil}},
+			}},
+		},
+		{
+			name:  "Count",
+			query: "People | count",
+			want:  table1("count()", int64(4)),
+		},
+		{
+			name:  "Join",
+			query: "People | join kind=inner (Teams) on team | project name, floor",
+			want: &Table{Columns: []*Column{
+				{Name: "name", Values: []any{"Alice", "Carol"}},
+				{Name: "floor", Values: []any{int64(1), int64(1)}},
+			}},
+		},
+		{
+			name:  "JoinColumnNames",
+			query: "People | where name == 'Alice' | join (Teams) on $left.team == $right.team",
+			want: &Table{Columns: []*Column{
+				{Name: "name", Values: []any{"Alice"}},
+				{Name: "age", Values: []any{int64(30)}},
+				{Name: "team", Values: []any{"red"}},
+				{Name: "floor", Values: []any{int64(1)}},
+				{Name: "team1", Values: []any{"red"}},
+			}},
+		},
+		{
+			name:  "JoinLeftOuter",
+			query: "People | join kind=leftouter (Teams) on team | project name, floor",
+			want: &Table{Columns: []*Column{
+				{Name: "name", Values: []any{"Alice", "Bob", "Carol", "Dave"}},
+				{Name: "floor", Values: []any{int64(1), nil, int64(1), nil}},
+			}},
+		},
+		{
+			name:  "JoinRightAnti",
+			query: "People | join kind=rightanti (Teams) on team",
+			want: &Table{Columns: []*Column{
+				{Name: "floor", Values: []any{int64(2)}},
+				{Name: "team", Values: []any{"green"}},
+			}},
+		},
+		{
+			name:  "JoinLeftAntiNullKeys",
+			query: "People | join kind=leftanti (Teams) on team | project name",
+			want:  table1("name", "Bob", "Dave"),
+		},
+		{
+			name:  "JoinLeftAntiNullRightKey",
+			query: "Teams | join kind=leftanti (People) on team",
+			want: &Table{Columns: []*Column{
+				{Name: "floor", Values: []any{int64(2)}},
+				{Name: "team", Values: []any{"green"}},
+			}},
+		},
+		{
+			name:  "As",
+			query: "People | where team == 'red' | as Red | join kind=leftsemi (Red) on name | project name",
+			want:  table1("name", "Alice", "Carol"),
+		},
+		{
+			name:  "TabularLet",
+			query: "let Young = People | where age < 31; Young | project name",
+			want:  table1("name", "Alice", "Bob"),
+		},
+		{
+			name:  "Wildcard",
+			query: "Logs_*",
+			want: &Table{Columns: []*Column{
+				{Name: "msg", Values: []any{"x", "y"}},
+				{Name: "level", Values: []any{nil, "warn"}},
+			}},
+		},
+		{
+			name:  "Union",
+			query: "Teams | where floor == 2 | union (People | where name == 'Bob' | project team), Logs_a",
+			want: &Table{Columns: []*Column{
+				{Name: "floor", Values: []any{int64(2), nil, nil}},
+				{Name: "team", Values: []any{"green", "blue", nil}},
+				{Name: "msg", Values: []any{nil, nil, "x"}},
+			}},
+		},
+		{
+			name:  "UnionWithSource",
+			query: "union withsource=T Logs_*, (Teams | take 1 | project team)",
+			want: &Table{Columns: []*Column{
+				{Name: "T", Values: []any{"Logs_a", "Logs_b", "union_arg1"}},
+				{Name: "msg", Values: []any{"x", "y", nil}},
+				{Name: "level", Values: []any{nil, "warn", nil}},
+				{Name: "team", Values: []any{nil, nil, "red"}},
+			}},
+		},
+		{
+			name:  "Database",
+			query: "db.Sink | extend m = n * 2.5, t = now()",
+			want: &Table{Columns: []*Column{
+				{Name: "n", Values: []any{int64(7)}},
+				{Name: "m", Values: []any{17.5}},
+				{Name: "t", Values: []any{time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)}},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Eval(test.query, testEnv())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Eval(%q) (-want +got):\n%s", test.query, diff)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "Nope", want: "Nope"},
+		{query: "People | where missing == 1", want: "missing"},
+		{query: "People | where name > 1", want: "name > 1"},
+		{query: "People | extend count()", want: "count"},
+		{query: "People | take -1", want: "-1"},
+		{query: "People | where nosuchfunc(name)", want: "nosuchfunc"},
+	}
+	for _, test := range tests {
+		_, err := Eval(test.query, testEnv())
+		if err == nil {
+			t.Errorf("Eval(%q) did not return an error", test.query)
+			continue
+		}
+		var perr parser.PositionedError
+		if !errors.As(err, &perr) {
+			t.Errorf("Eval(%q) error = %v; want a PositionedError", test.query, err)
+			continue
+		}
+		if got := perr.Source(); got != test.query {
+			t.Errorf("Eval(%q) error source = %q; want the query", test.query, got)
+		}
+		span := perr.Span()
+		if got := test.query[span.Start:span.End]; got != test.want {
+			t.Errorf("Eval(%q) error = %v; span = %q, want %q", test.query, err, got, test.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	src := &countingSource{n: 1000}
+	env := &Env{Sources: map[string]Source{"Numbers": src}}
+	q, err := Prepare("Numbers | where x % 2 == 0 | take 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	rows, err := q.Run(ctx, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"x"}, rows.Columns()); diff != "" {
+		t.Errorf("Columns() (-want +got):\n%s", diff)
+	}
+	var got []Row
+	for {
+		row, err := rows.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	if err := rows.Close(); err != nil {
+		t.Error("Close:", err)
+	}
+	want := []Row{{int64(0)}, {int64(2)}, {int64(4)}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("rows (-want +got):\n%s", diff)
+	}
+	if src.read != 5 {
+		t.Errorf("read %d rows from source; want 5", src.read)
+	}
+	if !src.closed {
+		t.Error("source not closed")
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	env := &Env{Sources: map[string]Source{"Numbers": &countingSource{n: 1000}}}
+	q, err := Prepare("Numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rows, err := q.Run(ctx, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if _, err := rows.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := rows.Next(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Next after cancel = _, %v; want %v", err, context.Canceled)
+	}
+}
+
+// countingSource is a [Source] of n rows with a single column x
+// that counts the rows read from it.
+type countingSource struct {
+	n      int
+	read   int
+	closed bool
+}
+
+func (src *countingSource) Open(ctx context.Context) (RowIterator, error) {
+	return countingIterator{src}, nil
+}
+
+type countingIterator struct {
+	src *countingSource
+}
+
+func (it countingIterator) Columns() []string {
+	return []string{"x"}
+}
+
+func (it countingIterator) Next(ctx context.Context) (Row, error) {
+	if it.src.read >= it.src.n {
+		return nil, io.EOF
+	}
+	it.src.read++
+	return Row{it.src.read - 1}, nil
+}
+
+func (it countingIterator) Close() error {
+	it.src.closed = true
+	return nil
+}
+
+func TestTableRows(t *testing.T) {
+	rows := []map[string]any{
+		{"a": "x", "b": int64(1)},
+		{"a": "y"},
+	}
+	want := []map[string]any{
+		{"a": "x", "b": int64(1)},
+		{"a": "y", "b": nil},
+	}
+	if diff := cmp.Diff(want, NewTable(rows).Rows()); diff != "" {
+		t.Errorf("NewTable(...).Rows() (-want +got):\n%s", diff)
+	}
+}
+
+// table1 returns a table with a single column.
+func table1(name string, values ...any) *Table {
+	return &Table{Columns: []*Column{{Name: name, Values: values}}}
+}
@@ -0,0 +1,487 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqleval
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runreveal/pql/parser"
+)
+
+const (
+	leftJoinTableAlias  = "$left"
+	rightJoinTableAlias = "$right"
+)
+
+// builtinValues is the set of identifiers with a fixed value.
+var builtinValues = map[string]any{
+	"true":  true,
+	"false": false,
+	"null":  nil,
+}
+
+// row is the set of columns that an expression can refer to.
+// A nil *row has no columns.
+type row struct {
+	cols   map[string]int
+	values []any
+
+	// aggregate is true if the expression is a summarize column.
+	// group is the set of rows that aggregate functions are applied to,
+	// and values is the first row of the group, if any.
+	aggregate bool
+	group     [][]any
+
+	// left and right are the rows on each side of a join condition.
+	left, right *row
+}
+
+// lookup returns the value of the named column.
+func (r *row) lookup(name string) (any, bool) {
+	if r == nil {
+		return nil, false
+	}
+	if r.left != nil || r.right != nil {
+		// Unqualified names in join conditions
+		// refer to the left side if possible.
+		if v, ok := r.left.lookup(name); ok {
+			return v, true
+		}
+		return r.right.lookup(name)
+	}
+	i, ok := r.cols[name]
+	if !ok {
+		return nil, false
+	}
+	if r.values == nil {
+		return nil, true
+	}
+	return r.values[i], true
+}
+
+// normalize converts v to one of the types used during evaluation.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return normalizeUint(uint64(v))
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return normalizeUint(v)
+	case float32:
+		return float64(v)
+	default:
+		return v
+	}
+}
+
+func normalizeUint(x uint64) any {
+	if x > math.MaxInt64 {
+		return float64(x)
+	}
+	return int64(x)
+}
+
+func (e *evaluator) eval(x parser.Expr, r *row) (any, error) {
+	switch x := x.(type) {
+	case *parser.ParenExpr:
+		return e.eval(x.X, r)
+	case *parser.QualifiedIdent:
+		return e.ident(x, r)
+	case *parser.BasicLit:
+		switch x.Kind {
+		case parser.TokenString:
+			return x.Value, nil
+		case parser.TokenNumber:
+			if x.IsInteger() {
+				if i, err := strconv.ParseInt(x.Value, 10, 64); err == nil {
+					return i, nil
+				}
+			}
+			f, err := strconv.ParseFloat(x.Value, 64)
+			if err != nil {
+				return nil, e.errorf(x, "invalid number %s", x.Value)
+			}
+			return f, nil
+		default:
+			return nil, e.errorf(x, "unsupported %s literal", x.Kind)
+		}
+	case *parser.UnaryExpr:
+		v, err := e.eval(x.X, r)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		switch x.Op {
+		case parser.TokenPlus:
+			switch v.(type) {
+			case int64, float64:
+				return v, nil
+			}
+		case parser.TokenMinus:
+			switch v := v.(type) {
+			case int64:
+				return -v, nil
+			case float64:
+				return -v, nil
+			}
+		default:
+			return nil, e.errorf(x, "unsupported unary operator %s", x.Op)
+		}
+		return nil, e.errorf(x, "invalid operand %T for unary %s", v, x.Op)
+	case *parser.BinaryExpr:
+		return e.binary(x, r)
+	case *parser.InExpr:
+		v, err := e.eval(x.X, r)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		sawNull := false
+		for _, y := range x.Vals {
+			w, err := e.eval(y, r)
+			if err != nil {
+				return nil, err
+			}
+			if w == nil {
+				sawNull = true
+				continue
+			}
+			eq, ok := equal(v, w)
+			if !ok {
+				return nil, e.errorf(x, "cannot compare %T and %T", v, w)
+			}
+			if eq {
+				return true, nil
+			}
+		}
+		if sawNull {
+			return nil, nil
+		}
+		return false, nil
+	case *parser.IndexExpr:
+		v, err := e.eval(x.X, r)
+		if err != nil {
+			return nil, err
+		}
+		i, err := e.eval(x.Index, r)
+		if err != nil {
+			return nil, err
+		}
+		return index(v, i), nil
+	case *parser.CallExpr:
+		if f := initFunctions()[x.Func.Name]; f != nil {
+			if f.aggregate && (r == nil || !r.aggregate) {
+				return nil, e.errorf(x.Func, "%s() can only be used in summarize", x.Func.Name)
+			}
+			return f.eval(e, x, r)
+		}
+		return nil, e.errorf(x.Func, "unknown function %s", x.Func.Name)
+	default:
+		span := parser.Span{Start: -1, End: -1}
+		if x != nil {
+			span = x.Span()
+		}
+		return nil, &evalError{
+			source: e.source,
+			span:   span,
+			err:    fmt.Errorf("unsupported %T expression", x),
+		}
+	}
+}
+
+func (e *evaluator) ident(x *parser.QualifiedIdent, r *row) (any, error) {
+	first := x.Parts[0]
+	var v any
+	rest := x.Parts[1:]
+	switch {
+	case !first.Quoted && len(x.Parts) == 1 && isBuiltin(first.Name):
+		return builtinValues[first.Name], nil
+	case !first.Quoted && (first.Name == leftJoinTableAlias || first.Name == rightJoinTableAlias):
+		side := r
+		if r != nil {
+			side = r.left
+			if first.Name == rightJoinTableAlias {
+				side = r.right
+			}
+		}
+		if side == nil || len(rest) == 0 {
+			return nil, e.errorf(first, "%s used in non-join context", first.Name)
+		}
+		var ok bool
+		v, ok = side.lookup(rest[0].Name)
+		if !ok {
+			return nil, e.errorf(rest[0], "unknown column %s", rest[0].Name)
+		}
+		rest = rest[1:]
+	default:
+		var ok bool
+		v, ok = e.scope[first.Name]
+		if !ok {
+			v, ok = r.lookup(first.Name)
+		}
+		if !ok {
+			return nil, e.errorf(first, "unknown column %s", first.Name)
+		}
+	}
+	// Further parts access fields of dynamic values.
+	for _, part := range rest {
+		v = index(v, part.Name)
+	}
+	return v, nil
+}
+
+func isBuiltin(name string) bool {
+	_, ok := builtinValues[name]
+	return ok
+}
+
+// index returns the element of a dynamic value v.
+// Maps are indexed by string keys and slices by zero-based integer indices.
+// index returns nil if the element does not exist.
+func index(v, i any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if k, ok := i.(string); ok {
+			return normalize(v[k])
+		}
+	case []any:
+		if k, ok := i.(int64); ok && 0 <= k && k < int64(len(v)) {
+			return normalize(v[k])
+		}
+	}
+	return nil
+}
+
+func (e *evaluator) binary(x *parser.BinaryExpr, r *row) (any, error) {
+	v, err := e.eval(x.X, r)
+	if err != nil {
+		return nil, err
+	}
+	switch x.Op {
+	case parser.TokenAnd, parser.TokenOr:
+		a, err := e.boolean(x.X, v)
+		if err != nil {
+			return nil, err
+		}
+		// Skip evaluating the right operand if it cannot change the result.
+		if a != nil && *a == (x.Op == parser.TokenOr) {
+			return *a, nil
+		}
+		w, err := e.eval(x.Y, r)
+		if err != nil {
+			return nil, err
+		}
+		b, err := e.boolean(x.Y, w)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case b != nil && *b == (x.Op == parser.TokenOr):
+			return *b, nil
+		case a == nil || b == nil:
+			return nil, nil
+		default:
+			return *b, nil
+		}
+	}
+
+	w, err := e.eval(x.Y, r)
+	if err != nil {
+		return nil, err
+	}
+	switch x.Op {
+	case parser.TokenEq, parser.TokenNE:
+		// Equality is false rather than null if either operand is null.
+		if v == nil || w == nil {
+			return false, nil
+		}
+		eq, ok := equal(v, w)
+		if !ok {
+			return nil, e.errorf(x, "cannot compare %T and %T", v, w)
+		}
+		return eq == (x.Op == parser.TokenEq), nil
+	}
+	if v == nil || w == nil {
+		return nil, nil
+	}
+	switch x.Op {
+	case parser.TokenCaseInsensitiveEq, parser.TokenCaseInsensitiveNE:
+		s, ok1 := v.(string)
+		t, ok2 := w.(string)
+		if !ok1 || !ok2 {
+			return nil, e.errorf(x, "invalid operands %T and %T for %s", v, w, x.Op)
+		}
+		eq := strings.ToLower(s) == strings.ToLower(t)
+		return eq == (x.Op == parser.TokenCaseInsensitiveEq), nil
+	case parser.TokenLT, parser.TokenLE, parser.TokenGT, parser.TokenGE:
+		c, ok := compare(v, w)
+		if !ok {
+			return nil, e.errorf(x, "cannot compare %T and %T", v, w)
+		}
+		switch x.Op {
+		case parser.TokenLT:
+			return c < 0, nil
+		case parser.TokenLE:
+			return c <= 0, nil
+		case parser.TokenGT:
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case parser.TokenPlus, parser.TokenMinus, parser.TokenStar, parser.TokenSlash, parser.TokenMod:
+		return e.arithmetic(x, v, w)
+	default:
+		return nil, e.errorf(x, "unsupported binary operator %s", x.Op)
+	}
+}
+
+func (e *evaluator) arithmetic(x *parser.BinaryExpr, v, w any) (any, error) {
+	if a, ok := v.(int64); ok {
+		if b, ok := w.(int64); ok {
+			switch x.Op {
+			case parser.TokenPlus:
+				return a + b, nil
+			case parser.TokenMinus:
+				return a - b, nil
+			case parser.TokenStar:
+				return a * b, nil
+			}
+			if b == 0 {
+				return nil, e.errorf(x, "division by zero")
+			}
+			if x.Op == parser.TokenSlash {
+				return a / b, nil
+			}
+			return a % b, nil
+		}
+	}
+	a, ok1 := toFloat(v)
+	b, ok2 := toFloat(w)
+	if !ok1 || !ok2 {
+		return nil, e.errorf(x, "invalid operands %T and %T for %s", v, w, x.Op)
+	}
+	switch x.Op {
+	case parser.TokenPlus:
+		return a + b, nil
+	case parser.TokenMinus:
+		return a - b, nil
+	case parser.TokenStar:
+		return a * b, nil
+	case parser.TokenSlash:
+		return a / b, nil
+	default:
+		return math.Mod(a, b), nil
+	}
+}
+
+// boolean converts the value of x to a nullable boolean.
+func (e *evaluator) boolean(x parser.Expr, v any) (*bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &v, nil
+	default:
+		return nil, e.errorf(x, "expected a boolean (got %T)", v)
+	}
+}
+
+// truth reports whether the value of x is true.
+// Null is treated as false.
+func (e *evaluator) truth(x parser.Expr, v any) (bool, error) {
+	b, err := e.boolean(x, v)
+	if err != nil || b == nil {
+		return false, err
+	}
+	return *b, nil
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// compare returns -1, 0, or +1 depending on whether x is less than,
+// equal to, or greater than y.
+// The second result is false if x and y cannot be ordered.
+func compare(x, y any) (int, bool) {
+	switch x := x.(type) {
+	case int64:
+		if y, ok := y.(int64); ok {
+			return cmpOrdered(x, y), true
+		}
+	case string:
+		if y, ok := y.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := y.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case y:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	case time.Time:
+		if y, ok := y.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	}
+	a, ok1 := toFloat(x)
+	b, ok2 := toFloat(y)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return cmpOrdered(a, b), true
+}
+
+func cmpOrdered[T int64 | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// equal reports whether x and y are equal.
+// The second result is false if x and y cannot be compared.
+func equal(x, y any) (eq, ok bool) {
+	c, ok := compare(x, y)
+	return c == 0, ok
+}
+
+func (e *evaluator) errorf(n parser.Node, format string, args ...any) error {
+	return &evalError{
+		source: e.source,
+		span:   n.Span(),
+		err:    fmt.Errorf(format, args...),
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqleval
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/runreveal/pql/parser"
+)
+
+type function struct {
+	eval func(e *evaluator, x *parser.CallExpr, r *row) (any, error)
+
+	// aggregate is true if the function combines the values of a group of rows,
+	// like in a summarize operator.
+	aggregate bool
+}
+
+var functions struct {
+	init sync.Once
+	m    map[string]*function
+}
+
+func initFunctions() map[string]*function {
+	functions.init.Do(func() {
+		functions.m = map[string]*function{
+			"avg":       {eval: evalAvgFunction, aggregate: true},
+			"count":     {eval: evalCountFunction, aggregate: true},
+			"countif":   {eval: evalCountIfFunction, aggregate: true},
+			"iff":       {eval: evalIfFunction},
+			"iif":       {eval: evalIfFunction},
+			"isnotnull": {eval: evalIsNotNullFunction},
+			"isnull":    {eval: evalIsNullFunction},
+			"max":       {eval: evalMaxFunction, aggregate: true},
+			"min":       {eval: evalMinFunction, aggregate: true},
+			"not":       {eval: evalNotFunction},
+			"now":       {eval: evalNowFunction},
+			"strcat":    {eval: evalStrcatFunction},
+			"sum":       {eval: evalSumFunction, aggregate: true},
+			"tolower":   {eval: evalToLowerFunction},
+			"toupper":   {eval: evalToUpperFunction},
+		}
+	})
+	return functions.m
+}
+
+// checkArgs returns an error if x does not have n arguments.
+func (e *evaluator) checkArgs(x *parser.CallExpr, signature string, n int) error {
+	if len(x.Args) == n {
+		return nil
+	}
+	var msg string
+	switch n {
+	case 0:
+		msg = "no arguments"
+	case 1:
+		msg = "a single argument"
+	default:
+		msg = fmt.Sprintf("%d arguments", n)
+	}
+	return &evalError{
+		source: e.source,
+		span: parser.Span{
+			Start: x.Lparen.End,
+			End:   x.Rparen.Start,
+		},
+		err: fmt.Errorf("%s takes %s (got %d)", signature, msg, len(x.Args)),
+	}
+}
+
+func evalNotFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if err := e.checkArgs(x, "not(x)", 1); err != nil {
+		return nil, err
+	}
+	v, err := e.eval(x.Args[0], r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := e.boolean(x.Args[0], v)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return !*b, nil
+}
+
+func evalNowFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if err := e.checkArgs(x, "now()", 0); err != nil {
+		return nil, err
+	}
+	return e.now(), nil
+}
+
+func evalIsNullFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if err := e.checkArgs(x, "isnull(x)", 1); err != nil {
+		return nil, err
+	}
+	v, err := e.eval(x.Args[0], r)
+	if err != nil {
+		return nil, err
+	}
+	return v == nil, nil
+}
+
+func evalIsNotNullFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if err := e.checkArgs(x, "isnotnull(x)", 1); err != nil {
+		return nil, err
+	}
+	v, err := e.eval(x.Args[0], r)
+	if err != nil {
+		return nil, err
+	}
+	return v != nil, nil
+}
+
+func evalIfFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if err := e.checkArgs(x, x.Func.Name+"(if, then, else)", 3); err != nil {
+		return nil, err
+	}
+	v, err := e.eval(x.Args[0], r)
+	if err != nil {
+		return nil, err
+	}
+	cond, err := e.truth(x.Args[0], v)
+	if err != nil {
+		return nil, err
+	}
+	if cond {
+		return e.eval(x.Args[1], r)
+	}
+	return e.eval(x.Args[2], r)
+}
+
+func evalStrcatFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if len(x.Args) == 0 {
+		return nil, &evalError{
+			source: e.source,
+			span: parser.Span{
+				Start: x.Lparen.End,
+				End:   x.Rparen.Start,
+			},
+			err: fmt.Errorf("strcat(x) takes least one argument"),
+		}
+	}
+	sb := new(strings.Builder)
+	for _, arg := range x.Args {
+		v, err := e.eval(arg, r)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case nil:
+			// Like SQL's || operator, any null argument produces null.
+			return nil, nil
+		case string:
+			sb.WriteString(v)
+		default:
+			fmt.Fprint(sb, v)
+		}
+	}
+	return sb.String(), nil
+}
+
+func evalToLowerFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	return e.mapString(x, r, "tolower(x)", strings.ToLower)
+}
+
+func evalToUpperFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	return e.mapString(x, r, "toupper(x)", strings.ToUpper)
+}
+
+// mapString applies f to the single string argument of x.
+func (e *evaluator) mapString(x *parser.CallExpr, r *row, signature string, f func(string) string) (any, error) {
+	if err := e.checkArgs(x, signature, 1); err != nil {
+		return nil, err
+	}
+	v, err := e.eval(x.Args[0], r)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, e.errorf(x.Args[0], "%s expects a string (got %T)", x.Func.Name, v)
+	}
+	return f(s), nil
+}
+
+func evalCountFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if err := e.checkArgs(x, "count()", 0); err != nil {
+		return nil, err
+	}
+	return int64(len(r.group)), nil
+}
+
+func evalCountIfFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if err := e.checkArgs(x, "countif(x)", 1); err != nil {
+		return nil, err
+	}
+	var n int64
+	err := e.eachValue(x.Args[0], r, func(v any) error {
+		ok, err := e.truth(x.Args[0], v)
+		if ok {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func evalSumFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if err := e.checkArgs(x, "sum(x)", 1); err != nil {
+		return nil, err
+	}
+	var intSum int64
+	var floatSum float64
+	n, isFloat := 0, false
+	err := e.eachValue(x.Args[0], r, func(v any) error {
+		switch v := v.(type) {
+		case nil:
+			return nil
+		case int64:
+			intSum += v
+		case float64:
+			floatSum += v
+			isFloat = true
+		default:
+			return e.errorf(x.Args[0], "sum(x) expects a number (got %T)", v)
+		}
+		n++
+		return nil
+	})
+	switch {
+	case err != nil || n == 0:
+		// Like SQL, the sum of no values is null.
+		return nil, err
+	case isFloat:
+		return floatSum + float64(intSum), nil
+	default:
+		return intSum, nil
+	}
+}
+
+func evalAvgFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	if err := e.checkArgs(x, "avg(x)", 1); err != nil {
+		return nil, err
+	}
+	var sum float64
+	var n int
+	err := e.eachValue(x.Args[0], r, func(v any) error {
+		if v == nil {
+			return nil
+		}
+		f, ok := toFloat(v)
+		if !ok {
+			return e.errorf(x.Args[0], "avg(x) expects a number (got %T)", v)
+		}
+		sum += f
+		n++
+		return nil
+	})
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	return sum / float64(n), nil
+}
+
+func evalMinFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	return e.extremum(x, r, "min(x)", -1)
+}
+
+func evalMaxFunction(e *evaluator, x *parser.CallExpr, r *row) (any, error) {
+	return e.extremum(x, r, "max(x)", 1)
+}
+
+// extremum returns the non-null value v of x in the group
+// for which compare(v, w) == sign for every other value w.
+func (e *evaluator) extremum(x *parser.CallExpr, r *row, signature string, sign int) (any, error) {
+	if err := e.checkArgs(x, signature, 1); err != nil {
+		return nil, err
+	}
+	var result any
+	err := e.eachValue(x.Args[0], r, func(v any) error {
+		if v == nil {
+			return nil
+		}
+		if result == nil {
+			result = v
+			return nil
+		}
+		c, ok := compare(v, result)
+		if !ok {
+			return e.errorf(x.Args[0], "cannot compare %T and %T", v, result)
+		}
+		if c == sign {
+			result = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// eachValue calls f with the value of x for each row in the group of r.
+func (e *evaluator) eachValue(x parser.Expr, r *row, f func(v any) error) error {
+	for _, values := range r.group {
+		v, err := e.eval(x, &row{cols: r.cols, values: values})
+		if err != nil {
+			return err
+		}
+		if err := f(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqleval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// A Row is a single row of a result.
+// Its values are in the same order as the iterator's columns.
+type Row []any
+
+// A RowIterator is a pull-based sequence of rows.
+type RowIterator interface {
+	// Columns returns the names of the columns of each row.
+	Columns() []string
+	// Next returns the next row.
+	// It returns [io.EOF] if there are no more rows.
+	Next(ctx context.Context) (Row, error)
+	// Close releases any resources held by the iterator.
+	Close() error
+}
+
+// A Source is a table whose rows are read on demand.
+// Each reference to the table in a query opens a new iterator.
+//
+// Values may be any of the types allowed in a [Table].
+type Source interface {
+	Open(ctx context.Context) (RowIterator, error)
+}
+
+// Open returns an iterator over the rows of t.
+// It allows a Table to be used as a [Source].
+func (t *Table) Open(ctx context.Context) (RowIterator, error) {
+	return &Rows{s: t.stream()}, nil
+}
+
+// stream returns a stream that produces the normalized rows of t.
+func (t *Table) stream() *stream {
+	cols := make([]string, len(t.Columns))
+	for j, col := range t.Columns {
+		cols[j] = col.Name
+	}
+	n := t.Len()
+	i := 0
+	return &stream{
+		cols: cols,
+		next: func(ctx context.Context) ([]any, error) {
+			if i >= n {
+				return nil, io.EOF
+			}
+			values := make([]any, len(t.Columns))
+			for j, col := range t.Columns {
+				if i < len(col.Values) {
+					values[j] = normalize(col.Values[i])
+				}
+			}
+			i++
+			return values, nil
+		},
+	}
+}
+
+// Rows is an iterator over the result of a query.
+type Rows struct {
+	s   *stream
+	err error
+}
+
+var errRowsClosed = errors.New("pqleval: rows closed")
+
+// Columns returns the names of the result's columns.
+func (r *Rows) Columns() []string {
+	return slices.Clone(r.s.cols)
+}
+
+// Next returns the next row of the result.
+// It returns [io.EOF] if there are no more rows.
+// Once Next returns an error, it returns the same error on every call.
+func (r *Rows) Next(ctx context.Context) (Row, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	values, err := r.s.next(ctx)
+	if err != nil {
+		r.err = err
+		r.s.Close()
+		return nil, err
+	}
+	return slices.Clone(values), nil
+}
+
+// Close stops the evaluation and releases the sources it opened.
+func (r *Rows) Close() error {
+	if r.err == nil {
+		r.err = errRowsClosed
+	}
+	return r.s.Close()
+}
+
+// Collect reads the remaining rows of it into a [Table] and closes it.
+func Collect(ctx context.Context, it RowIterator) (*Table, error) {
+	cols := it.Columns()
+	t := &Table{Columns: make([]*Column, len(cols))}
+	for j, name := range cols {
+		t.Columns[j] = &Column{Name: name, Values: []any{}}
+	}
+	for {
+		row, err := it.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		for j, col := range t.Columns {
+			col.Values = append(col.Values, row[j])
+		}
+	}
+	if err := it.Close(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// stream is a sequence of rows produced during evaluation.
+type stream struct {
+	cols []string
+	// next returns the next row or io.EOF if there are no more rows.
+	// It must not be called after it returns an error.
+	next func(ctx context.Context) ([]any, error)
+	// close releases the stream's inputs. It may be nil.
+	close func() error
+}
+
+// Close calls s.close the first time it is called.
+func (s *stream) Close() error {
+	f := s.close
+	if f == nil {
+		return nil
+	}
+	s.close = nil
+	return f()
+}
+
+// index returns a map of column names to their position in a row.
+func (s *stream) index() map[string]int {
+	return (&table{cols: s.cols}).index()
+}
+
+// iteratorStream returns a stream that reads from it.
+func iteratorStream(it RowIterator) *stream {
+	cols := it.Columns()
+	return &stream{
+		cols: cols,
+		next: func(ctx context.Context) ([]any, error) {
+			row, err := it.Next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if len(row) != len(cols) {
+				return nil, fmt.Errorf("source returned %d values for %d columns", len(row), len(cols))
+			}
+			values := make([]any, len(row))
+			for j, v := range row {
+				values[j] = normalize(v)
+			}
+			return values, nil
+		},
+		close: it.Close,
+	}
+}
+
+// collect reads the remaining rows of s into a table and closes s.
+func collect(ctx context.Context, s *stream) (*table, error) {
+	t := &table{cols: s.cols}
+	for {
+		values, err := s.next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		t.rows = append(t.rows, values)
+	}
+	if err := s.Close(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// buffered returns a stream with the given columns
+// that reads all of s on the first call to next
+// and produces the rows of the table that f returns.
+func buffered(s *stream, cols []string, f func(t *table) (*table, error)) *stream {
+	var result *table
+	i := 0
+	return &stream{
+		cols: cols,
+		next: func(ctx context.Context) ([]any, error) {
+			if result == nil {
+				t, err := collect(ctx, s)
+				if err != nil {
+					return nil, err
+				}
+				result, err = f(t)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if i >= len(result.rows) {
+				return nil, io.EOF
+			}
+			i++
+			return result.rows[i-1], nil
+		},
+		close: s.Close,
+	}
+}
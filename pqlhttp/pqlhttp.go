@@ -0,0 +1,412 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pqlhttp serves the Pipeline Query Language compiler over HTTP.
+//
+// A [Handler] answers JSON requests at the following paths:
+//
+//	POST /compile   CompileRequest -> CompileResponse
+//	POST /validate  CompileRequest -> ValidateResponse
+//	POST /complete  CompleteRequest -> CompleteResponse
+//	GET  /schema    Schema
+//	GET  /healthz   {"status": "ok"}
+//
+// Requests that cannot be handled receive an [ErrorResponse]
+// with a 4xx or 5xx status.
+// Problems in a query are not request errors:
+// they are reported as diagnostics in a 200 response.
+// To serve the endpoints under a prefix, use [http.StripPrefix].
+package pqlhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/internal/document"
+	"github.com/runreveal/pql/parser"
+)
+
+// DefaultMaxRequestSize is the largest request body in bytes
+// that a [Handler] accepts when its MaxRequestSize is zero.
+const DefaultMaxRequestSize = 1 << 20
+
+// Handler is an [http.Handler] that serves the compiler's endpoints.
+// Its fields must not be changed while it is serving requests.
+type Handler struct {
+	// CompileOptions configures how queries are compiled.
+	// If its AnalysisContext is not nil,
+	// it provides the tables for /complete and /schema
+	// and references to tables and columns that it does not contain
+	// are reported as diagnostics.
+	// A nil CompileOptions is treated the same as the zero value.
+	CompileOptions *pql.CompileOptions
+
+	// MaxRequestSize is the largest request body in bytes that the handler accepts.
+	// If MaxRequestSize is zero, [DefaultMaxRequestSize] is used.
+	// If MaxRequestSize is negative, the size is not limited.
+	MaxRequestSize int64
+
+	// Timeout, if positive, limits the time spent on each request
+	// looking up tables with the AnalysisContext's [pql.TableProvider].
+	Timeout time.Duration
+}
+
+// CompileRequest is the body of a /compile or /validate request.
+type CompileRequest struct {
+	// Source is the text of the query.
+	// It may contain several statements separated by semicolons.
+	Source string `json:"source"`
+	// Dialect is the name of the SQL dialect to write,
+	// like "clickhouse" or "postgres".
+	// If empty, the handler's [pql.CompileOptions.Dialect] is used.
+	Dialect string `json:"dialect,omitempty"`
+}
+
+// CompileResponse is the body of a /compile response.
+type CompileResponse struct {
+	// SQL is the SQL for each query in the source
+	// that compiled without errors.
+	SQL []string `json:"sql"`
+	// Diagnostics is the list of problems found in the source.
+	Diagnostics []*Diagnostic `json:"diagnostics"`
+}
+
+// ValidateResponse is the body of a /validate response.
+type ValidateResponse struct {
+	// Valid is true if the source has no error diagnostics.
+	Valid bool `json:"valid"`
+	// Diagnostics is the list of problems found in the source.
+	Diagnostics []*Diagnostic `json:"diagnostics"`
+}
+
+// CompleteRequest is the body of a /complete request.
+type CompleteRequest struct {
+	// Source is the text of the query being edited.
+	Source string `json:"source"`
+	// Offset is the byte offset of the cursor in Source.
+	Offset int `json:"offset"`
+}
+
+// CompleteResponse is the body of a /complete response.
+type CompleteResponse struct {
+	Items []*Completion `json:"items"`
+}
+
+// Diagnostic is the JSON representation of a [parser.Diagnostic].
+// Offsets are byte offsets into the source.
+// Lines and columns are 1-based.
+// The location fields are omitted if the diagnostic
+// does not refer to a specific location.
+type Diagnostic struct {
+	Start     *int   `json:"start,omitempty"`
+	End       *int   `json:"end,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+	Severity  string `json:"severity"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Completion is the JSON representation of a [pql.Completion].
+// Start and End are the byte offsets of the text to replace.
+type Completion struct {
+	Label         string `json:"label"`
+	Text          string `json:"text"`
+	Start         int    `json:"start"`
+	End           int    `json:"end"`
+	Kind          string `json:"kind"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// Schema is the body of a /schema response.
+// It has the same form as a schema file read by [pql.ParseSchema].
+type Schema struct {
+	Tables    map[string]*Table    `json:"tables"`
+	Databases map[string]*Database `json:"databases,omitempty"`
+}
+
+// Database is the JSON representation of a [pql.AnalysisDatabase].
+type Database struct {
+	Description string            `json:"description,omitempty"`
+	Tables      map[string]*Table `json:"tables"`
+}
+
+// Table is the JSON representation of a [pql.AnalysisTable].
+type Table struct {
+	Description string    `json:"description,omitempty"`
+	Columns     []*Column `json:"columns"`
+}
+
+// Column is the JSON representation of a [pql.AnalysisColumn].
+type Column struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Fields      []*Column `json:"fields,omitempty"`
+}
+
+// ErrorResponse is the body of a response with an error status.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// ServeHTTP dispatches a request to the endpoint for its path.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	switch r.URL.Path {
+	case "/compile":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		req := new(CompileRequest)
+		if !h.readRequest(w, r, req) {
+			return
+		}
+		opts, err := h.compileOptions(req.Dialect)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		resp := &CompileResponse{SQL: []string{}}
+		diags := document.Compile(ctx, opts, req.Source, func(sql string) {
+			resp.SQL = append(resp.SQL, sql)
+		})
+		resp.Diagnostics = newDiagnostics(req.Source, diags)
+		writeJSON(w, http.StatusOK, resp)
+	case "/validate":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		req := new(CompileRequest)
+		if !h.readRequest(w, r, req) {
+			return
+		}
+		opts, err := h.compileOptions(req.Dialect)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		diags := document.Compile(ctx, opts, req.Source, nil)
+		resp := &ValidateResponse{
+			Valid: !slices.ContainsFunc(diags, func(diag parser.Diagnostic) bool {
+				return diag.Severity == parser.SeverityError
+			}),
+			Diagnostics: newDiagnostics(req.Source, diags),
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case "/complete":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		req := new(CompleteRequest)
+		if !h.readRequest(w, r, req) {
+			return
+		}
+		if req.Offset < 0 || req.Offset > len(req.Source) {
+			writeError(w, http.StatusBadRequest, errors.New("offset must be within source"))
+			return
+		}
+		h.complete(ctx, w, req)
+	case "/schema":
+		if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		var ac *pql.AnalysisContext
+		if h.CompileOptions != nil {
+			ac = h.CompileOptions.AnalysisContext
+		}
+		writeJSON(w, http.StatusOK, newSchema(ac))
+	case "/healthz":
+		if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no endpoint at %s", r.URL.Path))
+	}
+}
+
+func (h *Handler) complete(ctx context.Context, w http.ResponseWriter, req *CompleteRequest) {
+	var ac *pql.AnalysisContext
+	if h.CompileOptions != nil {
+		ac = h.CompileOptions.AnalysisContext
+	}
+	pos := parser.Span{Start: req.Offset, End: req.Offset}
+	completions, err := ac.SuggestCompletionsContext(ctx, req.Source, pos)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, err)
+		return
+	}
+	resp := &CompleteResponse{Items: make([]*Completion, 0, len(completions))}
+	for _, c := range completions {
+		resp.Items = append(resp.Items, &Completion{
+			Label:         c.Label,
+			Text:          c.Text,
+			Start:         c.Span.Start,
+			End:           c.Span.End,
+			Kind:          strings.ToLower(strings.TrimPrefix(c.Kind.String(), "Completion")),
+			Detail:        c.Detail,
+			Documentation: c.Documentation,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// compileOptions returns the options for compiling a request
+// that asks for the given dialect.
+func (h *Handler) compileOptions(dialect string) (*pql.CompileOptions, error) {
+	opts := new(pql.CompileOptions)
+	if h.CompileOptions != nil {
+		*opts = *h.CompileOptions
+	}
+	if dialect == "" {
+		return opts, nil
+	}
+	for _, d := range []pql.Dialect{pql.ClickHouseDialect, pql.PostgresDialect, pql.DuckDBDialect} {
+		if dialect == d.String() {
+			opts.Dialect = d
+			return opts, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown dialect %q (must be clickhouse, postgres, or duckdb)", dialect)
+}
+
+// readRequest decodes the JSON body of r into v.
+// If the body is invalid, readRequest writes an error response
+// and returns false.
+func (h *Handler) readRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	body := r.Body
+	if limit := h.maxRequestSize(); limit >= 0 {
+		body = http.MaxBytesReader(w, body, limit)
+	}
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		if maxErr := new(http.MaxBytesError); errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body larger than %d bytes", maxErr.Limit))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return false
+	}
+	return true
+}
+
+func (h *Handler) maxRequestSize() int64 {
+	if h.MaxRequestSize == 0 {
+		return DefaultMaxRequestSize
+	}
+	return h.MaxRequestSize
+}
+
+// allowMethods reports whether r uses one of the given methods.
+// If it does not, allowMethods writes an error response.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	if slices.Contains(methods, r.Method) {
+		return true
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data = append(data, '\n')
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &ErrorResponse{Error: err.Error()})
+}
+
+func newDiagnostics(source string, diags []parser.Diagnostic) []*Diagnostic {
+	result := make([]*Diagnostic, 0, len(diags))
+	for _, diag := range diags {
+		d := &Diagnostic{
+			Severity: diag.Severity.String(),
+			Code:     diag.Code,
+			Message:  diag.Message,
+		}
+		if diag.Span.IsValid() {
+			d.Start = &diag.Span.Start
+			d.End = &diag.Span.End
+			start := parser.PositionFor(source, diag.Span.Start)
+			end := parser.PositionFor(source, diag.Span.End)
+			d.Line, d.Column = start.Line, start.Column
+			d.EndLine, d.EndColumn = end.Line, end.Column
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// newSchema returns the JSON representation of the tables in ac.
+// Tables that are only available from ac's TableProvider are not included.
+func newSchema(ac *pql.AnalysisContext) *Schema {
+	schema := &Schema{Tables: map[string]*Table{}}
+	if ac == nil {
+		return schema
+	}
+	schema.Tables = newTables(ac.Tables)
+	if len(ac.Databases) > 0 {
+		schema.Databases = make(map[string]*Database, len(ac.Databases))
+		for name, db := range ac.Databases {
+			schema.Databases[name] = &Database{
+				Description: db.Description,
+				Tables:      newTables(db.Tables),
+			}
+		}
+	}
+	return schema
+}
+
+func newTables(tables map[string]*pql.AnalysisTable) map[string]*Table {
+	result := make(map[string]*Table, len(tables))
+	for name, tbl := range tables {
+		result[name] = &Table{
+			Description: tbl.Description,
+			Columns:     newColumns(tbl.Columns),
+		}
+	}
+	return result
+}
+
+func newColumns(cols []*pql.AnalysisColumn) []*Column {
+	result := make([]*Column, 0, len(cols))
+	for _, col := range cols {
+		c := &Column{
+			Name:        col.Name,
+			Type:        col.Type,
+			Description: col.Description,
+		}
+		if len(col.Fields) > 0 {
+			c.Fields = newColumns(col.Fields)
+		}
+		result = append(result, c)
+	}
+	return result
+}
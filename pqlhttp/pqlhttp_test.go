@@ -0,0 +1,155 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqlhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql"
+)
+
+func TestHandler(t *testing.T) {
+	h := &Handler{
+		CompileOptions: &pql.CompileOptions{
+			AnalysisContext: &pql.AnalysisContext{
+				Tables: map[string]*pql.AnalysisTable{
+					"T": {
+						Description: "Test table",
+						Columns:     []*pql.AnalysisColumn{{Name: "a", Type: "Int64"}},
+					},
+				},
+			},
+		},
+		MaxRequestSize: 100,
+	}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Compile",
+			method:     http.MethodPost,
+			path:       "/compile",
+			body:       `{"source": "T | take 1; T | where b"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"sql":["SELECT * FROM \"T\" LIMIT 1;"],"diagnostics":[{"start":22,"end":23,"line":1,"column":23,"endLine":1,"endColumn":24,"severity":"error","code":"unknown-column","message":"unknown column \"b\""}]}`,
+		},
+		{
+			name:       "CompileDialect",
+			method:     http.MethodPost,
+			path:       "/compile",
+			body:       `{"source": "T | count", "dialect": "postgres"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"sql":["SELECT COUNT(*) AS \"count()\" FROM \"T\";"],"diagnostics":[]}`,
+		},
+		{
+			name:       "UnknownDialect",
+			method:     http.MethodPost,
+			path:       "/compile",
+			body:       `{"source": "T", "dialect": "bork"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"unknown dialect \"bork\" (must be clickhouse, postgres, or duckdb)"}`,
+		},
+		{
+			name:       "Validate",
+			method:     http.MethodPost,
+			path:       "/validate",
+			body:       `{"source": "T | where a > 1"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"valid":true,"diagnostics":[]}`,
+		},
+		{
+			name:       "ValidateSyntaxError",
+			method:     http.MethodPost,
+			path:       "/validate",
+			body:       `{"source": "T | where"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"valid":false,"diagnostics":[{"start":9,"end":9,"line":1,"column":10,"endLine":1,"endColumn":10,"severity":"error","code":"syntax","message":"expected expression, got EOF"}]}`,
+		},
+		{
+			name:       "Complete",
+			method:     http.MethodPost,
+			path:       "/complete",
+			body:       `{"source": "T | where a", "offset": 11}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"items":[{"label":"a","text":"a","start":10,"end":11,"kind":"column","detail":"Int64"}]}`,
+		},
+		{
+			name:       "CompleteBadOffset",
+			method:     http.MethodPost,
+			path:       "/complete",
+			body:       `{"source": "T", "offset": 5}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"offset must be within source"}`,
+		},
+		{
+			name:       "Schema",
+			method:     http.MethodGet,
+			path:       "/schema",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"tables":{"T":{"description":"Test table","columns":[{"name":"a","type":"Int64"}]}}}`,
+		},
+		{
+			name:       "Health",
+			method:     http.MethodGet,
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"status":"ok"}`,
+		},
+		{
+			name:       "WrongMethod",
+			method:     http.MethodGet,
+			path:       "/compile",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   `{"error":"method GET not allowed"}`,
+		},
+		{
+			name:       "InvalidJSON",
+			method:     http.MethodPost,
+			path:       "/compile",
+			body:       `{`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"invalid request: unexpected EOF"}`,
+		},
+		{
+			name:       "TooLarge",
+			method:     http.MethodPost,
+			path:       "/compile",
+			body:       `{"source": "` + strings.Repeat("T;", 100) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody:   `{"error":"request body larger than 100 bytes"}`,
+		},
+		{
+			name:       "NotFound",
+			method:     http.MethodGet,
+			path:       "/bork",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"error":"no endpoint at /bork"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Errorf("status = %d; want %d", rec.Code, test.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+				t.Errorf("Content-Type = %q; want JSON", got)
+			}
+			if diff := cmp.Diff(test.wantBody+"\n", rec.Body.String()); diff != "" {
+				t.Errorf("body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,634 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqlsigma
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/runreveal/pql"
+	"github.com/runreveal/pql/build"
+)
+
+// A Converter converts Sigma rules into pql queries.
+// The zero value uses the rules' field names unchanged
+// and fails for every rule because it has no tables.
+type Converter struct {
+	// Fields maps Sigma field names to column names.
+	// Fields that are not in the map are used unchanged.
+	Fields map[string]string
+
+	// Tables maps log sources to the tables that contain their events.
+	// A rule is converted to a query of the first entry's table
+	// whose non-empty log source fields are equal to the rule's.
+	// Table names may be qualified with a database, like "security.Events".
+	Tables []TableMapping
+	// DefaultTable is the table to query for rules
+	// whose log source does not match any entry in Tables.
+	// If DefaultTable is empty, such rules cannot be converted.
+	DefaultTable string
+
+	// Dialect is the database that the query will be compiled for.
+	// It determines the functions used for substring and regular expression matches.
+	Dialect pql.Dialect
+}
+
+// A TableMapping associates a log source with a table.
+type TableMapping struct {
+	LogSource LogSource
+	Table     string
+}
+
+// Convert returns a pql query that selects the events matched by rule.
+func (c *Converter) Convert(rule *Rule) (string, error) {
+	table, err := c.table(rule.LogSource)
+	if err != nil {
+		return "", fmt.Errorf("convert %s: %v", ruleName(rule), err)
+	}
+	cv := &converter{Converter: c, rule: rule}
+	var predicates []build.Expr
+	for _, cond := range rule.conditions {
+		x, err := cv.condition(cond)
+		if err != nil {
+			return "", fmt.Errorf("convert %s: %v", ruleName(rule), err)
+		}
+		predicates = append(predicates, x)
+	}
+	return table.Where(build.Or(predicates...)).String(), nil
+}
+
+// ruleName returns a name for rule to use in error messages.
+func ruleName(rule *Rule) string {
+	switch {
+	case rule.Title != "":
+		return strconv.Quote(rule.Title)
+	case rule.ID != "":
+		return rule.ID
+	default:
+		return "sigma rule"
+	}
+}
+
+// table returns a query that reads the table for src.
+func (c *Converter) table(src LogSource) (*build.Query, error) {
+	name := c.DefaultTable
+	for _, m := range c.Tables {
+		if matchLogSource(m.LogSource.Product, src.Product) &&
+			matchLogSource(m.LogSource.Category, src.Category) &&
+			matchLogSource(m.LogSource.Service, src.Service) {
+			name = m.Table
+			break
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no table for log source %q", src)
+	}
+	if db, table, ok := strings.Cut(name, "."); ok {
+		return build.DatabaseTable(db, table), nil
+	}
+	return build.Table(name), nil
+}
+
+func matchLogSource(want, got string) bool {
+	return want == "" || want == got
+}
+
+// converter holds the state of a single call to [*Converter.Convert].
+type converter struct {
+	*Converter
+	rule *Rule
+}
+
+// condition converts a rule condition like "selection and not filter".
+func (cv *converter) condition(cond string) (build.Expr, error) {
+	p := &conditionParser{cv: cv, tokens: conditionTokens.FindAllString(cond, -1)}
+	if len(p.tokens) == 0 {
+		return build.Expr{}, fmt.Errorf("empty condition")
+	}
+	x, err := p.or()
+	if err != nil {
+		return build.Expr{}, fmt.Errorf("condition %q: %v", cond, err)
+	}
+	if tok := p.peek(); tok != "" {
+		if tok == "|" {
+			return build.Expr{}, fmt.Errorf("condition %q: aggregations are not supported", cond)
+		}
+		return build.Expr{}, fmt.Errorf("condition %q: unexpected %q", cond, tok)
+	}
+	return x, nil
+}
+
+var conditionTokens = regexp.MustCompile(`[()|]|[^\s()|]+`)
+
+// conditionParser parses a condition with the grammar:
+//
+//	or     = and { "or" and }
+//	and    = not { "and" not }
+//	not    = "not" not | "(" or ")" | quantifier | identifier
+//	quantifier = ("1" | "any" | "all") "of" (pattern | "them")
+type conditionParser struct {
+	cv     *converter
+	tokens []string
+	pos    int
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *conditionParser) or() (build.Expr, error) {
+	x, err := p.and()
+	if err != nil {
+		return build.Expr{}, err
+	}
+	terms := []build.Expr{x}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		y, err := p.and()
+		if err != nil {
+			return build.Expr{}, err
+		}
+		terms = append(terms, y)
+	}
+	return build.Or(terms...), nil
+}
+
+func (p *conditionParser) and() (build.Expr, error) {
+	x, err := p.not()
+	if err != nil {
+		return build.Expr{}, err
+	}
+	terms := []build.Expr{x}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		y, err := p.not()
+		if err != nil {
+			return build.Expr{}, err
+		}
+		terms = append(terms, y)
+	}
+	return build.And(terms...), nil
+}
+
+func (p *conditionParser) not() (build.Expr, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return build.Expr{}, fmt.Errorf("unexpected end of condition")
+	case strings.EqualFold(tok, "not"):
+		x, err := p.not()
+		if err != nil {
+			return build.Expr{}, err
+		}
+		return build.Not(x), nil
+	case tok == "(":
+		x, err := p.or()
+		if err != nil {
+			return build.Expr{}, err
+		}
+		if p.next() != ")" {
+			return build.Expr{}, fmt.Errorf("missing )")
+		}
+		return x, nil
+	case tok == ")" || tok == "|" || strings.EqualFold(tok, "and") || strings.EqualFold(tok, "or"):
+		return build.Expr{}, fmt.Errorf("unexpected %q", tok)
+	case strings.EqualFold(p.peek(), "of"):
+		p.next()
+		return p.quantifier(tok, p.next())
+	default:
+		v, ok := p.cv.rule.selections.values[tok]
+		if !ok {
+			return build.Expr{}, fmt.Errorf("unknown search identifier %q", tok)
+		}
+		return p.cv.selection(tok, v)
+	}
+}
+
+// quantifier converts "1 of pattern" or "all of pattern".
+func (p *conditionParser) quantifier(quantity, pattern string) (build.Expr, error) {
+	combine := build.Or
+	switch strings.ToLower(quantity) {
+	case "1", "any":
+	case "all":
+		combine = build.And
+	default:
+		return build.Expr{}, fmt.Errorf("unsupported quantifier %q", quantity+" of")
+	}
+	if pattern == "" {
+		return build.Expr{}, fmt.Errorf("missing pattern after %q", quantity+" of")
+	}
+	var terms []build.Expr
+	for _, name := range p.cv.rule.selections.keys {
+		var matched bool
+		if pattern == "them" {
+			matched = !strings.HasPrefix(name, "_")
+		} else {
+			var err error
+			if matched, err = path.Match(pattern, name); err != nil {
+				return build.Expr{}, fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+		if !matched {
+			continue
+		}
+		x, err := p.cv.selection(name, p.cv.rule.selections.values[name])
+		if err != nil {
+			return build.Expr{}, err
+		}
+		terms = append(terms, x)
+	}
+	if len(terms) == 0 {
+		return build.Expr{}, fmt.Errorf("%q does not match any search identifiers", pattern)
+	}
+	return combine(terms...), nil
+}
+
+// selection converts the search identifier with the given name and definition.
+// A mapping matches if all of its fields match
+// and a list of mappings matches if any of them match.
+func (cv *converter) selection(name string, v any) (build.Expr, error) {
+	switch v := v.(type) {
+	case *yamlMap:
+		return cv.fieldMap(name, v)
+	case []any:
+		var terms []build.Expr
+		for _, item := range v {
+			m, ok := item.(*yamlMap)
+			if !ok {
+				return build.Expr{}, fmt.Errorf("search identifier %q: keyword searches are not supported", name)
+			}
+			x, err := cv.fieldMap(name, m)
+			if err != nil {
+				return build.Expr{}, err
+			}
+			terms = append(terms, x)
+		}
+		if len(terms) == 0 {
+			return build.Expr{}, fmt.Errorf("search identifier %q is empty", name)
+		}
+		return build.Or(terms...), nil
+	default:
+		return build.Expr{}, fmt.Errorf("search identifier %q must be a mapping or list", name)
+	}
+}
+
+func (cv *converter) fieldMap(name string, m *yamlMap) (build.Expr, error) {
+	var terms []build.Expr
+	for _, key := range m.keys {
+		x, err := cv.field(key, m.values[key])
+		if err != nil {
+			return build.Expr{}, fmt.Errorf("search identifier %q: %v", name, err)
+		}
+		terms = append(terms, x)
+	}
+	if len(terms) == 0 {
+		return build.Expr{}, fmt.Errorf("search identifier %q is empty", name)
+	}
+	return build.And(terms...), nil
+}
+
+// modifiers is the set of value modifiers applied to a field.
+type modifiers struct {
+	contains   bool
+	startsWith bool
+	endsWith   bool
+	all        bool
+	cased      bool
+	exists     bool
+	fieldRef   bool
+	// re is true for regular expressions.
+	// reFlags are the flags for its i, m, and s sub-modifiers.
+	re      bool
+	reFlags string
+	// compare builds the comparison for the gt, gte, lt, and lte modifiers.
+	// It is nil if there is none.
+	compare func(x, y build.Expr) build.Expr
+}
+
+// field converts a field and its values, like "Image|endswith: '\cmd.exe'".
+func (cv *converter) field(key string, value any) (build.Expr, error) {
+	name, modNames, _ := strings.Cut(key, "|")
+	var mods modifiers
+	if modNames != "" {
+		for _, mod := range strings.Split(modNames, "|") {
+			switch mod {
+			case "contains":
+				mods.contains = true
+			case "startswith":
+				mods.startsWith = true
+			case "endswith":
+				mods.endsWith = true
+			case "all":
+				mods.all = true
+			case "cased":
+				mods.cased = true
+			case "exists":
+				mods.exists = true
+			case "fieldref":
+				mods.fieldRef = true
+			case "re":
+				mods.re = true
+			case "i", "m", "s":
+				if !mods.re {
+					return build.Expr{}, fmt.Errorf("%s: modifier %q must follow re", key, mod)
+				}
+				mods.reFlags += mod
+			case "gt":
+				mods.compare = build.Gt
+			case "gte":
+				mods.compare = build.Ge
+			case "lt":
+				mods.compare = build.Lt
+			case "lte":
+				mods.compare = build.Le
+			default:
+				return build.Expr{}, fmt.Errorf("%s: unsupported modifier %q", key, mod)
+			}
+		}
+	}
+	if name == "" {
+		return build.Expr{}, fmt.Errorf("%s: keyword searches are not supported", key)
+	}
+	column := build.Col(cv.column(name))
+
+	values, isList := value.([]any)
+	if !isList {
+		values = []any{value}
+	}
+	if len(values) == 0 {
+		return build.Expr{}, fmt.Errorf("%s: no values", key)
+	}
+	combine := build.Or
+	if mods.all {
+		combine = build.And
+	}
+	terms := make([]build.Expr, 0, len(values))
+	for _, v := range values {
+		x, err := cv.match(column, v, &mods)
+		if err != nil {
+			return build.Expr{}, fmt.Errorf("%s: %v", key, err)
+		}
+		terms = append(terms, x)
+	}
+	return combine(terms...), nil
+}
+
+// column returns the column name for a Sigma field name.
+func (c *Converter) column(name string) string {
+	if mapped, ok := c.Fields[name]; ok {
+		return mapped
+	}
+	return name
+}
+
+// match converts a comparison of column with a single value.
+func (cv *converter) match(column build.Expr, value any, mods *modifiers) (build.Expr, error) {
+	switch {
+	case mods.exists:
+		exists, ok := value.(bool)
+		if !ok {
+			return build.Expr{}, fmt.Errorf("exists requires true or false")
+		}
+		if exists {
+			return build.Call("isnotnull", column), nil
+		}
+		return build.Call("isnull", column), nil
+	case mods.compare != nil:
+		n, ok := numberLit(value)
+		if !ok {
+			return build.Expr{}, fmt.Errorf("%v is not a number", value)
+		}
+		return mods.compare(column, n), nil
+	case mods.fieldRef:
+		other, ok := value.(string)
+		if !ok {
+			return build.Expr{}, fmt.Errorf("fieldref requires a field name")
+		}
+		return build.Eq(column, build.Col(cv.column(other))), nil
+	}
+
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return build.Call("isnull", column), nil
+	case bool:
+		return build.Eq(column, build.Bool(v)), nil
+	case int64, float64:
+		if !mods.contains && !mods.startsWith && !mods.endsWith && !mods.re {
+			n, _ := numberLit(v)
+			return build.Eq(column, n), nil
+		}
+		s = fmt.Sprint(v)
+	case string:
+		s = v
+	default:
+		return build.Expr{}, fmt.Errorf("unsupported value %v", value)
+	}
+
+	if mods.re {
+		pattern := s
+		if mods.reFlags != "" {
+			pattern = "(?" + mods.reFlags + ")" + pattern
+		}
+		return cv.regexMatch(column, pattern), nil
+	}
+	parts := parseWildcards(s)
+	if mods.contains || mods.endsWith {
+		parts = append([]wildcardPart{{wildcard: '*'}}, parts...)
+	}
+	if mods.contains || mods.startsWith {
+		parts = append(parts, wildcardPart{wildcard: '*'})
+	}
+	return cv.wildcardMatch(column, parts, mods.cased), nil
+}
+
+// wildcardPart is a literal string or a single wildcard in a Sigma value.
+type wildcardPart struct {
+	// wildcard is '*' or '?', or zero for a literal.
+	wildcard byte
+	text     string
+}
+
+// parseWildcards splits a Sigma value into literals and wildcards.
+// A backslash escapes a following wildcard or backslash
+// and is otherwise literal.
+func parseWildcards(s string) []wildcardPart {
+	var parts []wildcardPart
+	sb := new(strings.Builder)
+	flush := func() {
+		if sb.Len() > 0 {
+			parts = append(parts, wildcardPart{text: sb.String()})
+			sb.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(`*?\`, s[i+1]) >= 0:
+			sb.WriteByte(s[i+1])
+			i++
+		case c == '*' || c == '?':
+			flush()
+			parts = append(parts, wildcardPart{wildcard: c})
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	flush()
+	return parts
+}
+
+// wildcardMatch converts a match of column with a value containing wildcards.
+func (c *Converter) wildcardMatch(column build.Expr, parts []wildcardPart, cased bool) build.Expr {
+	// Adjacent * wildcards are equivalent to one.
+	var compact []wildcardPart
+	for _, part := range parts {
+		if part.wildcard == '*' && len(compact) > 0 && compact[len(compact)-1].wildcard == '*' {
+			continue
+		}
+		compact = append(compact, part)
+	}
+	parts = compact
+
+	var literal string
+	leading, trailing, simple := false, false, true
+	for i, part := range parts {
+		switch {
+		case part.wildcard == '*' && i == 0:
+			leading = true
+		case part.wildcard == '*' && i == len(parts)-1:
+			trailing = true
+		case part.wildcard != 0 || literal != "":
+			simple = false
+		default:
+			literal = part.text
+		}
+	}
+	if !simple {
+		return c.regexMatch(column, wildcardRegexp(parts, cased))
+	}
+	if literal == "" && leading {
+		// A lone * matches any value.
+		return build.Call("isnotnull", column)
+	}
+	if !leading && !trailing {
+		if cased {
+			return build.Eq(column, build.Str(literal))
+		}
+		return build.CaseInsensitiveEq(column, build.Str(literal))
+	}
+	target := column
+	if !cased {
+		target = build.Call("tolower", column)
+		literal = strings.ToLower(literal)
+	}
+	switch {
+	case leading && trailing:
+		return c.containsMatch(target, literal)
+	case trailing:
+		return c.startsWithMatch(target, literal)
+	default:
+		return c.endsWithMatch(target, literal)
+	}
+}
+
+// wildcardRegexp returns a regular expression equivalent to the given parts.
+func wildcardRegexp(parts []wildcardPart, cased bool) string {
+	sb := new(strings.Builder)
+	if !cased {
+		sb.WriteString("(?i)")
+	}
+	sb.WriteString("^")
+	for _, part := range parts {
+		switch part.wildcard {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(part.text))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// The match functions below are passed through to the database,
+// so their names depend on the dialect.
+
+func (c *Converter) containsMatch(x build.Expr, s string) build.Expr {
+	f := "position"
+	if c.Dialect != pql.ClickHouseDialect {
+		f = "strpos"
+	}
+	return build.Gt(build.Call(f, x, build.Str(s)), build.Int(0))
+}
+
+func (c *Converter) startsWithMatch(x build.Expr, s string) build.Expr {
+	if c.Dialect == pql.ClickHouseDialect {
+		return build.Call("startsWith", x, build.Str(s))
+	}
+	return build.Call("starts_with", x, build.Str(s))
+}
+
+func (c *Converter) endsWithMatch(x build.Expr, s string) build.Expr {
+	switch c.Dialect {
+	case pql.ClickHouseDialect:
+		return build.Call("endsWith", x, build.Str(s))
+	case pql.DuckDBDialect:
+		return build.Call("ends_with", x, build.Str(s))
+	default:
+		n := build.Int(int64(utf8.RuneCountInString(s)))
+		return build.Eq(build.Call("right", x, n), build.Str(s))
+	}
+}
+
+func (c *Converter) regexMatch(x build.Expr, pattern string) build.Expr {
+	switch c.Dialect {
+	case pql.ClickHouseDialect:
+		return build.Call("match", x, build.Str(pattern))
+	case pql.DuckDBDialect:
+		return build.Call("regexp_matches", x, build.Str(pattern))
+	default:
+		return build.Call("textregexeq", x, build.Str(pattern))
+	}
+}
+
+// numberLit returns a numeric literal for v,
+// which may be a number or a string containing one.
+func numberLit(v any) (build.Expr, bool) {
+	switch v := v.(type) {
+	case int64:
+		return build.Int(v), true
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return build.Expr{}, false
+		}
+		return build.Float(v), true
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return build.Int(i), true
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return build.Expr{}, false
+		}
+		return numberLit(f)
+	default:
+		return build.Expr{}, false
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pqlsigma converts [Sigma] detection rules
+// into Pipeline Query Language queries.
+//
+// A rule's detection becomes the predicate of a where operator
+// applied to the table for the rule's log source.
+// Field names and tables are mapped with a [Converter].
+// Sigma matches strings case-insensitively unless the cased modifier is used,
+// so plain values are compared with =~
+// and substring, prefix, suffix, and wildcard matches
+// compare the lowercase of the field.
+// These matches and regular expressions are written as calls
+// to the database's string functions, which the compiler passes through.
+//
+// Keyword detections, aggregations in conditions,
+// and value modifiers that transform the value
+// (like base64 and windash) are not supported.
+//
+// [Sigma]: https://sigmahq.io/
+package pqlsigma
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Rule is a Sigma detection rule.
+type Rule struct {
+	Title       string
+	ID          string
+	Status      string
+	Description string
+	Level       string
+	Tags        []string
+	LogSource   LogSource
+
+	// selections is the rule's named search identifiers in order.
+	selections *yamlMap
+	// conditions are the rule's conditions.
+	// A rule matches if any of them are true.
+	conditions []string
+}
+
+// A LogSource describes the events that a rule applies to.
+// Empty fields are not part of the description.
+type LogSource struct {
+	Product  string
+	Category string
+	Service  string
+}
+
+// String returns the non-empty fields of src separated by slashes,
+// like "windows/process_creation".
+func (src LogSource) String() string {
+	var parts []string
+	for _, part := range []string{src.Product, src.Category, src.Service} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// ParseRule parses a Sigma rule in YAML.
+// Files with multiple documents, like rule collections, are rejected.
+func ParseRule(data []byte) (*Rule, error) {
+	doc, err := decodeYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse sigma rule: %v", err)
+	}
+	m, ok := doc.(*yamlMap)
+	if !ok {
+		return nil, fmt.Errorf("parse sigma rule: not a mapping")
+	}
+	rule := new(Rule)
+	for _, f := range []struct {
+		key string
+		dst *string
+	}{
+		{"title", &rule.Title},
+		{"id", &rule.ID},
+		{"status", &rule.Status},
+		{"description", &rule.Description},
+		{"level", &rule.Level},
+	} {
+		if *f.dst, err = stringField(m, f.key); err != nil {
+			return nil, fmt.Errorf("parse sigma rule: %v", err)
+		}
+	}
+	rule.Description = strings.TrimRight(rule.Description, "\n")
+	if tags, ok := m.get("tags").([]any); ok {
+		for _, tag := range tags {
+			rule.Tags = append(rule.Tags, fmt.Sprint(tag))
+		}
+	}
+	if src, ok := m.get("logsource").(*yamlMap); ok {
+		for _, f := range []struct {
+			key string
+			dst *string
+		}{
+			{"product", &rule.LogSource.Product},
+			{"category", &rule.LogSource.Category},
+			{"service", &rule.LogSource.Service},
+		} {
+			if *f.dst, err = stringField(src, f.key); err != nil {
+				return nil, fmt.Errorf("parse sigma rule: logsource: %v", err)
+			}
+		}
+	}
+
+	detection, ok := m.get("detection").(*yamlMap)
+	if !ok {
+		return nil, fmt.Errorf("parse sigma rule: missing detection")
+	}
+	rule.selections = &yamlMap{values: make(map[string]any)}
+	for _, key := range detection.keys {
+		switch key {
+		case "condition":
+			switch cond := detection.values[key].(type) {
+			case string:
+				rule.conditions = []string{cond}
+			case []any:
+				for _, c := range cond {
+					s, ok := c.(string)
+					if !ok {
+						return nil, fmt.Errorf("parse sigma rule: condition must be a string")
+					}
+					rule.conditions = append(rule.conditions, s)
+				}
+			default:
+				return nil, fmt.Errorf("parse sigma rule: condition must be a string")
+			}
+		case "timeframe":
+			// Only used by aggregations, which are not supported.
+		default:
+			rule.selections.keys = append(rule.selections.keys, key)
+			rule.selections.values[key] = detection.values[key]
+		}
+	}
+	if len(rule.conditions) == 0 {
+		return nil, fmt.Errorf("parse sigma rule: missing condition")
+	}
+	return rule, nil
+}
+
+// stringField returns the scalar value of the given key in m as a string.
+func stringField(m *yamlMap, key string) (string, error) {
+	switch v := m.get(key).(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case *yamlMap, []any:
+		return "", fmt.Errorf("%s must be a string", key)
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
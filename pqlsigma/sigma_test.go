@@ -0,0 +1,357 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqlsigma
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/runreveal/pql"
+)
+
+const whoamiRule = `title: Whoami Execution
+id: e28a5a99-da44-436d-b7a0-2afc20a5f413
+status: test
+description: |
+  Detects the execution of whoami,
+  which is often used by attackers after exploitation.
+tags:
+  - attack.discovery
+  - attack.t1033
+logsource:
+  category: process_creation
+  product: windows
+detection:
+  selection:
+    - Image|endswith: '\whoami.exe'
+    - OriginalFileName: 'whoami.exe'
+  filter:
+    User|contains:
+      - 'AUTHORI'
+      - 'AUTORI'
+  condition: selection and not filter
+level: high
+`
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule([]byte(whoamiRule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Rule{
+		Title:       "Whoami Execution",
+		ID:          "e28a5a99-da44-436d-b7a0-2afc20a5f413",
+		Status:      "test",
+		Description: "Detects the execution of whoami,\nwhich is often used by attackers after exploitation.",
+		Level:       "high",
+		Tags:        []string{"attack.discovery", "attack.t1033"},
+		LogSource: LogSource{
+			Product:  "windows",
+			Category: "process_creation",
+		},
+	}
+	if diff := cmp.Diff(want, rule, cmpopts.IgnoreUnexported(Rule{})); diff != "" {
+		t.Errorf("ParseRule(...) (-want +got):\n%s", diff)
+	}
+	if got, want := rule.LogSource.String(), "windows/process_creation"; got != want {
+		t.Errorf("rule.LogSource.String() = %q; want %q", got, want)
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "NotMapping",
+			source: "- a\n- b\n",
+			want:   "not a mapping",
+		},
+		{
+			name:   "MissingDetection",
+			source: "title: x\n",
+			want:   "missing detection",
+		},
+		{
+			name:   "MissingCondition",
+			source: "detection:\n  selection:\n    a: 1\n",
+			want:   "missing condition",
+		},
+		{
+			name:   "MultipleDocuments",
+			source: "title: x\n---\ntitle: y\n",
+			want:   "line 2: multiple documents",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseRule([]byte(test.source))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("ParseRule(%q) = _, %v; want error containing %q", test.source, err, test.want)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name      string
+		detection string
+		dialect   pql.Dialect
+		want      string
+		err       string
+	}{
+		{
+			name:      "Whoami",
+			detection: whoamiRule[strings.Index(whoamiRule, "detection:"):],
+			want: `ProcessEvents
+| where (endsWith(tolower(image), "\\whoami.exe") or (original_file_name =~ "whoami.exe")) and not((position(tolower(user), "authori") > 0) or (position(tolower(user), "autori") > 0))`,
+		},
+		{
+			name: "Prefixes",
+			detection: `detection:
+  selection:
+    Image|endswith: '\cmd.exe'
+    CommandLine|contains: '/c'
+    ParentImage|startswith: 'C:\Windows\'
+  condition: selection
+`,
+			want: `ProcessEvents
+| where endsWith(tolower(image), "\\cmd.exe") and (position(tolower(command_line), "/c") > 0) and startsWith(tolower(ParentImage), "c:\\windows\\")`,
+		},
+		{
+			name: "Postgres",
+			detection: `detection:
+  selection:
+    Image|endswith: '\cmd.exe'
+    CommandLine|contains: '/c'
+    ParentImage|startswith: 'C:\Windows\'
+    User|re: '^adm'
+  condition: selection
+`,
+			dialect: pql.PostgresDialect,
+			want: `ProcessEvents
+| where (right(tolower(image), 8) == "\\cmd.exe") and (strpos(tolower(command_line), "/c") > 0) and starts_with(tolower(ParentImage), "c:\\windows\\") and textregexeq(user, "^adm")`,
+		},
+		{
+			name: "DuckDB",
+			detection: `detection:
+  selection:
+    Image|endswith: '\cmd.exe'
+    CommandLine|contains: '/c'
+    ParentImage|startswith: 'C:\Windows\'
+    User|re: '^adm'
+  condition: selection
+`,
+			dialect: pql.DuckDBDialect,
+			want: `ProcessEvents
+| where ends_with(tolower(image), "\\cmd.exe") and (strpos(tolower(command_line), "/c") > 0) and starts_with(tolower(ParentImage), "c:\\windows\\") and regexp_matches(user, "^adm")`,
+		},
+		{
+			name: "Regexp",
+			detection: `detection:
+  selection:
+    Image|endswith: '\cmd.exe'
+    CommandLine|re: '^cmd /[ck]'
+  condition: selection
+`,
+			want: `ProcessEvents
+| where endsWith(tolower(image), "\\cmd.exe") and match(command_line, "^cmd /[ck]")`,
+		},
+		{
+			name: "Wildcards",
+			detection: `detection:
+  selection:
+    CommandLine: '*-enc *'
+    Image: 'C:\\*\cmd.exe'
+    User: 'adm?n'
+    ParentImage: '*'
+  condition: selection
+`,
+			want: `ProcessEvents
+| where (position(tolower(command_line), "-enc ") > 0) and match(image, "(?i)^C:\\\\.*\\\\cmd\\.exe$") and match(user, "(?i)^adm.n$") and isnotnull(ParentImage)`,
+		},
+		{
+			name: "EscapedWildcard",
+			detection: `detection:
+  selection:
+    CommandLine: 'what\?'
+  condition: selection
+`,
+			want: `ProcessEvents
+| where command_line =~ "what?"`,
+		},
+		{
+			name: "Modifiers",
+			detection: `detection:
+  selection:
+    CommandLine|contains|all:
+      - 'net'
+      - 'user'
+    User|cased: 'SYSTEM'
+    CommandLine|re|i: 'mimikatz'
+    EventID|gte: 4624
+    Hashes|exists: false
+    TargetUser|fieldref: User
+  condition: selection
+`,
+			want: `ProcessEvents
+| where ((position(tolower(command_line), "net") > 0) and (position(tolower(command_line), "user") > 0)) and (user == "SYSTEM") and match(command_line, "(?i)mimikatz") and (EventID >= 4624) and isnull(Hashes) and (TargetUser == user)`,
+		},
+		{
+			name: "Values",
+			detection: `detection:
+  selection:
+    EventID:
+      - 4624
+      - 4625
+    Elevated: true
+    Parent: null
+  condition: selection
+`,
+			want: `ProcessEvents
+| where ((EventID == 4624) or (EventID == 4625)) and (Elevated == true) and isnull(Parent)`,
+		},
+		{
+			name: "Quantifiers",
+			detection: `detection:
+  selection_img:
+    Image|endswith: '\powershell.exe'
+  selection_cli:
+    CommandLine|contains: 'bypass'
+  filter:
+    User: 'admin'
+  condition: all of selection_* and not 1 of filter*
+`,
+			want: `ProcessEvents
+| where (endsWith(tolower(image), "\\powershell.exe") and (position(tolower(command_line), "bypass") > 0)) and not(user =~ "admin")`,
+		},
+		{
+			name: "Them",
+			detection: `detection:
+  a:
+    x: 1
+  b:
+    y: 2
+  _helper:
+    z: 3
+  condition: 1 of them
+`,
+			want: `ProcessEvents
+| where (x == 1) or (y == 2)`,
+		},
+		{
+			name: "MultipleConditions",
+			detection: `detection:
+  a:
+    x: 1
+  b:
+    y: 2
+  condition:
+    - a
+    - b
+`,
+			want: `ProcessEvents
+| where (x == 1) or (y == 2)`,
+		},
+		{
+			name: "Keywords",
+			detection: `detection:
+  keywords:
+    - 'mimikatz'
+  condition: keywords
+`,
+			err: "keyword searches are not supported",
+		},
+		{
+			name: "Aggregation",
+			detection: `detection:
+  selection:
+    x: 1
+  condition: selection | count() > 5
+`,
+			err: "aggregations are not supported",
+		},
+		{
+			name: "UnsupportedModifier",
+			detection: `detection:
+  selection:
+    CommandLine|base64: 'secret'
+  condition: selection
+`,
+			err: `unsupported modifier "base64"`,
+		},
+		{
+			name: "UnknownIdentifier",
+			detection: `detection:
+  selection:
+    x: 1
+  condition: selection and filter
+`,
+			err: `unknown search identifier "filter"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := "title: Test\nlogsource:\n  category: process_creation\n  product: windows\n" + test.detection
+			rule, err := ParseRule([]byte(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &Converter{
+				Fields: map[string]string{
+					"Image":            "image",
+					"OriginalFileName": "original_file_name",
+					"CommandLine":      "command_line",
+					"User":             "user",
+				},
+				Tables: []TableMapping{
+					{LogSource: LogSource{Product: "linux"}, Table: "LinuxEvents"},
+					{LogSource: LogSource{Category: "process_creation"}, Table: "ProcessEvents"},
+				},
+				Dialect: test.dialect,
+			}
+			got, err := c.Convert(rule)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("Convert(...) = %q, %v; want error containing %q", got, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Convert(...) (-want +got):\n%s", diff)
+			}
+			if _, err := (&pql.CompileOptions{Dialect: test.dialect}).Compile(got); err != nil {
+				t.Errorf("Compile(%q) with dialect %v: %v", got, test.dialect, err)
+			}
+		})
+	}
+}
+
+func TestConvertTable(t *testing.T) {
+	rule, err := ParseRule([]byte("logsource:\n  product: aws\n  service: cloudtrail\ndetection:\n  s:\n    eventName: ConsoleLogin\n  condition: s\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Converter{DefaultTable: "security.Events"}
+	got, err := c.Convert(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "security.Events\n| where eventName =~ \"ConsoleLogin\""; got != want {
+		t.Errorf("Convert(...) = %q; want %q", got, want)
+	}
+
+	c.DefaultTable = ""
+	if _, err := c.Convert(rule); err == nil || !strings.Contains(err.Error(), `no table for log source "aws/cloudtrail"`) {
+		t.Errorf("Convert(...) without tables = _, %v; want no table error", err)
+	}
+}
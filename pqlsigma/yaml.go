@@ -0,0 +1,116 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqlsigma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlMap is a YAML mapping that remembers the order of its keys.
+type yamlMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func (m *yamlMap) get(key string) any {
+	if m == nil {
+		return nil
+	}
+	return m.values[key]
+}
+
+// decodeYAML decodes a single YAML document into
+// *yamlMap, []any, string, int64, float64, bool, or nil values.
+// Mappings keep the order of their keys,
+// which determines the order of a rule's search identifiers.
+func decodeYAML(data []byte) (any, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var next yaml.Node
+	if err := dec.Decode(&next); !errors.Is(err, io.EOF) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("line %d: multiple documents are not supported", next.Line)
+	}
+	return yamlValue(&doc)
+}
+
+// yamlValue converts a node to the values returned by [decodeYAML].
+func yamlValue(n *yaml.Node) (any, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(n.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.MappingNode:
+		m := &yamlMap{values: make(map[string]any, len(n.Content)/2)}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: mapping keys must be scalars", k.Line)
+			}
+			if _, dup := m.values[k.Value]; dup {
+				return nil, fmt.Errorf("line %d: duplicate key %q", k.Line, k.Value)
+			}
+			value, err := yamlValue(v)
+			if err != nil {
+				return nil, err
+			}
+			m.keys = append(m.keys, k.Value)
+			m.values[k.Value] = value
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		list := make([]any, 0, len(n.Content))
+		for _, elem := range n.Content {
+			v, err := yamlValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!null":
+			return nil, nil
+		case "!!bool":
+			var b bool
+			err := n.Decode(&b)
+			return b, err
+		case "!!int":
+			var i int64
+			if err := n.Decode(&i); err == nil {
+				return i, nil
+			}
+			// Too large for an int64.
+			var f float64
+			err := n.Decode(&f)
+			return f, err
+		case "!!float":
+			var f float64
+			err := n.Decode(&f)
+			return f, err
+		default:
+			return n.Value, nil
+		}
+	default:
+		return nil, fmt.Errorf("line %d: unsupported YAML node", n.Line)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqlsigma
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	tests := []struct {
+		source string
+		want   any
+		err    string
+	}{
+		{
+			source: "a: 1\nb: -2.5\nc: true\nd: null\ne: ~\nf: hello world # comment\n",
+			want: &yamlMap{
+				keys:   []string{"a", "b", "c", "d", "e", "f"},
+				values: map[string]any{"a": int64(1), "b": -2.5, "c": true, "d": nil, "e": nil, "f": "hello world"},
+			},
+		},
+		{
+			source: "a: 'it''s'\nb: \"tab\\there\"\nc: '\\whoami.exe'\n",
+			want: &yamlMap{
+				keys:   []string{"a", "b", "c"},
+				values: map[string]any{"a": "it's", "b": "tab\there", "c": `\whoami.exe`},
+			},
+		},
+		{
+			source: "list:\n  - a\n  - b: 1\n    c: 2\nflow: [1, 'x', y]\n",
+			want: &yamlMap{
+				keys: []string{"list", "flow"},
+				values: map[string]any{
+					"list": []any{
+						"a",
+						&yamlMap{keys: []string{"b", "c"}, values: map[string]any{"b": int64(1), "c": int64(2)}},
+					},
+					"flow": []any{int64(1), "x", "y"},
+				},
+			},
+		},
+		{
+			source: "literal: |\n  line 1\n  line 2\nfolded: >-\n  one\n  two\n",
+			want: &yamlMap{
+				keys:   []string{"literal", "folded"},
+				values: map[string]any{"literal": "line 1\nline 2\n", "folded": "one two"},
+			},
+		},
+		{
+			source: "a: &x {b: 1, c: [2]}\nd: *x\n",
+			want: &yamlMap{
+				keys: []string{"a", "d"},
+				values: map[string]any{
+					"a": &yamlMap{keys: []string{"b", "c"}, values: map[string]any{"b": int64(1), "c": []any{int64(2)}}},
+					"d": &yamlMap{keys: []string{"b", "c"}, values: map[string]any{"b": int64(1), "c": []any{int64(2)}}},
+				},
+			},
+		},
+		{
+			source: "a: 1\na: 2\n",
+			err:    `line 2: duplicate key "a"`,
+		},
+		{
+			source: "a: 1\n---\nb: 2\n",
+			err:    "multiple documents are not supported",
+		},
+		{
+			source: "a: [1\n",
+			err:    "yaml:",
+		},
+	}
+	for _, test := range tests {
+		got, err := decodeYAML([]byte(test.source))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("decodeYAML(%q) = _, %v; want error containing %q", test.source, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("decodeYAML(%q): %v", test.source, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(yamlMap{})); diff != "" {
+			t.Errorf("decodeYAML(%q) (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
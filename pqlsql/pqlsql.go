@@ -0,0 +1,95 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pqlsql runs Pipeline Query Language queries
+// against databases accessed through [database/sql].
+package pqlsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+
+	"github.com/runreveal/pql"
+)
+
+// Querier is the interface for running a SQL query.
+// It is implemented by [*sql.DB], [*sql.Conn], and [*sql.Tx].
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// A Result is the output of a query.
+type Result struct {
+	// Columns is the schema of the rows.
+	Columns []*Column
+	// Rows holds the values of each row in the same order as Columns.
+	// Values are those produced by the database driver
+	// when scanning into an any.
+	Rows [][]any
+}
+
+// A Column describes a column in a [Result].
+type Column struct {
+	Name string
+	// DatabaseType is the database system's name for the column's type,
+	// like "VARCHAR" or "UInt64".
+	// It is empty if the driver does not report it.
+	DatabaseType string
+	// ScanType is a Go type suitable for scanning the column's values.
+	// It may be nil if the driver does not report it.
+	ScanType reflect.Type
+	// Nullable reports whether the column may contain nulls.
+	// It is only meaningful if NullableKnown is true.
+	Nullable      bool
+	NullableKnown bool
+}
+
+// Query compiles source with opts and runs the resulting SQL on db.
+// args are bound to the query's placeholders,
+// which are usually introduced with [pql.CompileOptions.Parameters]
+// by mapping an identifier to a placeholder like "$1" or "?".
+// Query reads the entire result into memory.
+func Query(ctx context.Context, db Querier, opts *pql.CompileOptions, source string, args ...any) (*Result, error) {
+	query, err := opts.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("run pql query: %w", err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("run pql query: %w", err)
+	}
+	result := &Result{Columns: make([]*Column, len(types))}
+	for i, typ := range types {
+		col := &Column{
+			Name:         typ.Name(),
+			DatabaseType: typ.DatabaseTypeName(),
+			ScanType:     typ.ScanType(),
+		}
+		col.Nullable, col.NullableKnown = typ.Nullable()
+		result.Columns[i] = col
+	}
+
+	dst := make([]any, len(types))
+	for rows.Next() {
+		values := make([]any, len(types))
+		for i := range values {
+			dst[i] = &values[i]
+		}
+		if err := rows.Scan(dst...); err != nil {
+			return nil, fmt.Errorf("run pql query: %w", err)
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("run pql query: %w", err)
+	}
+	return result, nil
+}
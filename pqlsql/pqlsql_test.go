@@ -0,0 +1,115 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqlsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql"
+)
+
+func TestQuery(t *testing.T) {
+	d := &fakeDriver{
+		columns: []string{"name", "n"},
+		rows:    [][]driver.Value{{"alice", int64(3)}, {"bob", nil}},
+	}
+	db := sql.OpenDB(d)
+	defer db.Close()
+
+	opts := &pql.CompileOptions{
+		Parameters: map[string]string{"minAge": "$1"},
+	}
+	got, err := Query(context.Background(), db, opts, "People | where age > minAge | project name, n", 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantQuery, err := opts.Compile("People | where age > minAge | project name, n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.query != wantQuery {
+		t.Errorf("query = %q; want %q", d.query, wantQuery)
+	}
+	if want := []driver.Value{int64(21)}; !cmp.Equal(d.args, want) {
+		t.Errorf("args = %v; want %v", d.args, want)
+	}
+	want := &Result{
+		Columns: []*Column{
+			{Name: "name", DatabaseType: "FAKE", ScanType: reflect.TypeOf(new(any)).Elem()},
+			{Name: "n", DatabaseType: "FAKE", ScanType: reflect.TypeOf(new(any)).Elem()},
+		},
+		Rows: [][]any{{"alice", int64(3)}, {"bob", nil}},
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b reflect.Type) bool { return a == b })); diff != "" {
+		t.Errorf("Query(...) (-want +got):\n%s", diff)
+	}
+}
+
+func TestQueryCompileError(t *testing.T) {
+	d := new(fakeDriver)
+	db := sql.OpenDB(d)
+	defer db.Close()
+
+	_, err := Query(context.Background(), db, nil, "People | where")
+	if err == nil {
+		t.Fatal("Query did not return an error")
+	}
+	if d.query != "" {
+		t.Errorf("query %q was run despite compile error", d.query)
+	}
+}
+
+// fakeDriver is a [driver.Connector] that records the last query it receives
+// and returns a fixed set of rows.
+type fakeDriver struct {
+	columns []string
+	rows    [][]driver.Value
+
+	query string
+	args  []driver.Value
+}
+
+func (d *fakeDriver) Connect(context.Context) (driver.Conn, error) { return fakeConn{d}, nil }
+func (d *fakeDriver) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("transactions not supported") }
+
+func (c fakeConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	c.d.query = query
+	c.d.args = args
+	return &fakeRows{columns: c.d.columns, rows: c.d.rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string { return "FAKE" }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
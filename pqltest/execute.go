@@ -0,0 +1,290 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pqltest
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// An Engine is a database that can execute the SQL
+// produced by the compiler.
+type Engine interface {
+	// Query runs query with dir as the working directory
+	// and returns the result as CSV with a header row.
+	// tables are available to the query by their names,
+	// and params holds the values of the query's parameters.
+	Query(ctx context.Context, dir string, tables []*Table, params map[string]string, query string) ([]byte, error)
+}
+
+// A Table is a data file that is loaded as a table.
+type Table struct {
+	// Name is the name of the table.
+	Name string
+	// Path is the absolute path to the data file.
+	Path string
+	// Format is the format of the data file: "csv" or "json".
+	// CSV files have a header row.
+	// JSON files are in ClickHouse's JSON format.
+	Format string
+}
+
+// FindTables returns the tables in a directory.
+// Every file ending in .csv or .json in the directory is a table
+// named after the file without its extension.
+func FindTables(dir string) ([]*Table, error) {
+	var err error
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("find local tables: %v", err)
+	}
+	listing, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("find local tables: %v", err)
+	}
+
+	var result []*Table
+	for _, entry := range listing {
+		filename := entry.Name()
+		if entry.Type().IsRegular() && !shouldIgnoreFilename(filename) {
+			if baseName, isCSV := strings.CutSuffix(filename, ".csv"); isCSV {
+				result = append(result, &Table{
+					Name:   baseName,
+					Path:   filepath.Join(dir, filename),
+					Format: "csv",
+				})
+			} else if baseName, isJSON := strings.CutSuffix(filename, ".json"); isJSON {
+				result = append(result, &Table{
+					Name:   baseName,
+					Path:   filepath.Join(dir, filename),
+					Format: "json",
+				})
+			}
+		}
+	}
+	return result, nil
+}
+
+// RunExecute runs a subtest for each golden test in dir
+// that has an output.csv file.
+// The subtest runs the test's output.sql on engine
+// with the tables in tablesDir
+// and compares the result to the output.csv file.
+func RunExecute(t *testing.T, engine Engine, dir, tablesDir string) {
+	tests, err := Find(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := FindTables(tablesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		wantCSV, wantCSVError := os.ReadFile(filepath.Join(test.Dir, "output.csv"))
+		if errors.Is(wantCSVError, os.ErrNotExist) {
+			continue
+		}
+
+		t.Run(test.Name, func(t *testing.T) {
+			if test.Skip {
+				t.Skipf("'skip' file present in %s; skipping...", test.Dir)
+			}
+			if wantCSVError != nil {
+				t.Fatal("Could not read expected output:", wantCSVError)
+			}
+
+			pqlInput, err := test.Input()
+			if err != nil {
+				t.Fatal(err)
+			}
+			compileOptions, parameterValues, err := test.Options()
+			if err != nil {
+				t.Fatal(err)
+			}
+			query, err := compileOptions.Compile(pqlInput)
+			if err != nil {
+				t.Fatal("Compile:", err)
+			}
+
+			gotCSV, err := engine.Query(context.Background(), test.Dir, tables, parameterValues, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := csv.NewReader(bytes.NewReader(gotCSV)).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := csv.NewReader(bytes.NewReader(wantCSV)).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.Unordered {
+				sort.Slice(got, func(i, j int) bool {
+					return isRowLess(got[i], got[j])
+				})
+				sort.Slice(want, func(i, j int) bool {
+					return isRowLess(want[i], want[j])
+				})
+			}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("query results (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func isRowLess(row1, row2 []string) bool {
+	for i, n := 0, min(len(row1), len(row2)); i < n; i++ {
+		if row1[i] < row2[i] {
+			return true
+		}
+		if row1[i] > row2[i] {
+			return false
+		}
+	}
+	return len(row1) < len(row2)
+}
+
+// ClickHouseLocal returns an [Engine] that runs queries
+// with the clickhouse-local program found on the PATH.
+func ClickHouseLocal() (Engine, error) {
+	exe, err := exec.LookPath("clickhouse")
+	if err != nil {
+		return nil, err
+	}
+	return clickhouseLocal{exe}, nil
+}
+
+type clickhouseLocal struct {
+	exe string
+}
+
+func (ch clickhouseLocal) Query(ctx context.Context, dir string, tables []*Table, params map[string]string, query string) ([]byte, error) {
+	var args []string
+	args = append(args, "local", "--format", "CSVWithNames")
+	fnameBuf := new(strings.Builder)
+	formatBuf := new(strings.Builder)
+	for _, tab := range tables {
+		fnameBuf.Reset()
+		quoteSQLString(fnameBuf, tab.Path)
+		formatBuf.Reset()
+		switch tab.Format {
+		case "csv":
+			quoteSQLString(formatBuf, "CSVWithNames")
+		case "json":
+			quoteSQLString(formatBuf, "JSON")
+		default:
+			return nil, fmt.Errorf("table %s: unknown format %q", tab.Name, tab.Format)
+		}
+		stmt := fmt.Sprintf("CREATE TABLE \"%s\" AS file(%s, %s);", tab.Name, fnameBuf, formatBuf)
+		args = append(args, "--query", stmt)
+	}
+	args = appendClickhouseParameterArgs(args, params)
+	args = append(args, "--query", query)
+
+	c := exec.CommandContext(ctx, ch.exe, args...)
+	c.Dir = dir
+	stdout := new(bytes.Buffer)
+	c.Stdout = stdout
+	stderr := new(bytes.Buffer)
+	c.Stderr = stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("clickhouse local: %v\n%s", err, stderr)
+	}
+	return stdout.Bytes(), nil
+}
+
+func appendClickhouseParameterArgs(dst []string, params map[string]string) []string {
+	if len(params) == 0 {
+		return dst
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	sb := new(strings.Builder)
+	for _, k := range keys {
+		sb.WriteString("SET param_")
+		sb.WriteString(k)
+		sb.WriteString(" = ")
+		quoteSQLString(sb, params[k])
+		sb.WriteString(";")
+	}
+	dst = append(dst, "--query", sb.String())
+	return dst
+}
+
+// DuckDB returns an [Engine] that runs queries
+// with the duckdb program found on the PATH.
+// Only CSV tables are loaded, and queries with parameters are not supported.
+func DuckDB() (Engine, error) {
+	exe, err := exec.LookPath("duckdb")
+	if err != nil {
+		return nil, err
+	}
+	return duckDB{exe}, nil
+}
+
+type duckDB struct {
+	exe string
+}
+
+func (d duckDB) Query(ctx context.Context, dir string, tables []*Table, params map[string]string, query string) ([]byte, error) {
+	if len(params) > 0 {
+		return nil, fmt.Errorf("duckdb: query parameters not supported")
+	}
+	script := new(strings.Builder)
+	for _, tab := range tables {
+		if tab.Format != "csv" {
+			continue
+		}
+		fmt.Fprintf(script, "CREATE TABLE \"%s\" AS SELECT * FROM read_csv_auto(", tab.Name)
+		quoteSQLString(script, tab.Path)
+		script.WriteString(", header = true);\n")
+	}
+	script.WriteString(query)
+	script.WriteString("\n")
+
+	c := exec.CommandContext(ctx, d.exe, "-csv")
+	c.Dir = dir
+	c.Stdin = strings.NewReader(script.String())
+	stdout := new(bytes.Buffer)
+	c.Stdout = stdout
+	stderr := new(bytes.Buffer)
+	c.Stderr = stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("duckdb: %v\n%s", err, stderr)
+	}
+	return stdout.Bytes(), nil
+}
+
+func quoteSQLString(sb *strings.Builder, s string) {
+	sb.WriteString("'")
+	for _, b := range []byte(s) {
+		if b == '\'' {
+			sb.WriteString("''")
+		} else {
+			sb.WriteByte(b)
+		}
+	}
+	sb.WriteString("'")
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pqltest runs golden tests for Pipeline Query Language queries.
+//
+// A golden test suite is a directory with a subdirectory for each test.
+// Each test directory has the following files:
+//
+//   - input.pql: The input Pipeline Query Language.
+//   - output.sql: The expected generated SQL.
+//     Generally, this is written by [RunCompile] in record mode
+//     and then inspected for correctness.
+//   - output.csv (optional): The expected output table for the query,
+//     checked by [RunExecute].
+//   - options.jwcc (optional): Compile options.
+//     The "parameters" key maps parameter names to objects
+//     with a "clickhouse" key holding the parameter's SQL
+//     and a "value" key holding its value.
+//   - skip (optional): If present, the test is skipped.
+//   - unordered (optional): If present, the rows in output.csv
+//     may appear in any order.
+//
+// Directories whose names start with "." or "_" are ignored.
+package pqltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql"
+	"github.com/tailscale/hujson"
+)
+
+// A Test is a single golden test.
+type Test struct {
+	// Name is the name of the test's directory.
+	Name string
+	// Dir is the path to the test's directory.
+	Dir string
+	// Skip is true if the test should not be run.
+	Skip bool
+	// Unordered is true if the rows of the test's output
+	// may appear in any order.
+	Unordered bool
+}
+
+// Find returns the golden tests in the given directory.
+func Find(dir string) ([]*Test, error) {
+	listing, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("find golden tests: %v", err)
+	}
+
+	var result []*Test
+	for _, entry := range listing {
+		fileName := entry.Name()
+		if !entry.IsDir() || shouldIgnoreFilename(fileName) {
+			continue
+		}
+		test := &Test{
+			Name: fileName,
+			Dir:  filepath.Join(dir, fileName),
+		}
+		if _, err := os.Stat(filepath.Join(test.Dir, "skip")); err == nil {
+			test.Skip = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("find golden tests: check for skip: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(test.Dir, "unordered")); err == nil {
+			test.Unordered = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("find golden tests: check for unordered: %v", err)
+		}
+		result = append(result, test)
+	}
+	return result, nil
+}
+
+// Input returns the content of the test's input.pql file.
+func (test *Test) Input() (string, error) {
+	input, err := os.ReadFile(filepath.Join(test.Dir, "input.pql"))
+	return string(input), err
+}
+
+// Options returns the compile options in the test's options.jwcc file
+// and the values of its parameters.
+// If the test does not have an options.jwcc file,
+// Options returns nil options.
+func (test *Test) Options() (opts *pql.CompileOptions, parameterValues map[string]string, err error) {
+	type testParameter struct {
+		Value string `json:"value"`
+		SQL   string `json:"clickhouse"`
+	}
+
+	path := filepath.Join(test.Dir, "options.jwcc")
+	input, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	input, err = hujson.Standardize(input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	var parsed struct {
+		Parameters map[string]testParameter `json:"parameters"`
+	}
+	if err := json.Unmarshal(input, &parsed); err != nil {
+		return nil, nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	opts = &pql.CompileOptions{
+		Parameters: make(map[string]string, len(parsed.Parameters)),
+	}
+	parameterValues = make(map[string]string, len(parsed.Parameters))
+	for name, p := range parsed.Parameters {
+		opts.Parameters[name] = p.SQL
+		parameterValues[name] = p.Value
+	}
+	return opts, parameterValues, nil
+}
+
+// RunCompile runs a subtest for each golden test in dir
+// that compiles the test's input
+// and compares the result to its output.sql file.
+// If record is true, RunCompile writes the output.sql files instead.
+func RunCompile(t *testing.T, dir string, record bool) {
+	tests, err := Find(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if test.Skip {
+				t.Skipf("'skip' file present in %s; skipping...", test.Dir)
+			}
+
+			input, err := test.Input()
+			if err != nil {
+				t.Fatal(err)
+			}
+			compileOptions, _, err := test.Options()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := compileOptions.Compile(input)
+			if err != nil {
+				t.Error("Compile(...):", err)
+			}
+
+			outputPath := filepath.Join(test.Dir, "output.sql")
+			if record {
+				// For easier editing, ensure there is a trailing newline.
+				if got != "" && !strings.HasSuffix(got, "\n") {
+					got += "\n"
+				}
+
+				if err := os.WriteFile(outputPath, []byte(got), 0o666); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Strip trailing newlines for comparison.
+			// Makes it easier to hand-edit goldens when editors place trailing newlines.
+			got = strings.TrimRight(got, "\n")
+			want = bytes.TrimRight(want, "\n")
+			if diff := cmp.Diff(string(want), got); diff != "" {
+				t.Errorf("output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func shouldIgnoreFilename(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"slices"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// pushDownPredicates returns expr with the predicates of where operators
+// that immediately follow a join moved into the join's inputs
+// wherever the predicate only refers to the columns of one input.
+// Filtering the inputs of a join is equivalent to filtering its output,
+// but the database does not always do this on its own.
+//
+// The columns of each input are determined by r.
+// Predicates are left in place if the columns of either input are unknown.
+// scope is the set of identifiers that do not refer to columns.
+// pushDownPredicates does not modify expr.
+func (r *columnResolver) pushDownPredicates(expr *parser.TabularExpr, scope map[string]string) *parser.TabularExpr {
+	if expr == nil {
+		return nil
+	}
+	newExpr := &parser.TabularExpr{
+		Source:    expr.Source,
+		Operators: make([]parser.TabularOperator, 0, len(expr.Operators)),
+	}
+	if paren, ok := expr.Source.(*parser.ParenTabularExpr); ok {
+		newExpr.Source = &parser.ParenTabularExpr{
+			Lparen: paren.Lparen,
+			X:      r.pushDownPredicates(paren.X, scope),
+			Rparen: paren.Rparen,
+		}
+	}
+	for i := 0; i < len(expr.Operators); i++ {
+		join, ok := expr.Operators[i].(*parser.JoinOperator)
+		if !ok {
+			newExpr.Operators = append(newExpr.Operators, expr.Operators[i])
+			continue
+		}
+		join = r.pushDownJoinRight(join, scope)
+		var where *parser.WhereOperator
+		if i+1 < len(expr.Operators) {
+			where, _ = expr.Operators[i+1].(*parser.WhereOperator)
+		}
+		if where == nil {
+			newExpr.Operators = append(newExpr.Operators, join)
+			continue
+		}
+
+		left := &parser.TabularExpr{
+			Source:    newExpr.Source,
+			Operators: newExpr.Operators,
+		}
+		leftCols := r.exactColumns(left)
+		rightCols := r.exactColumns(join.Right)
+		if leftCols == nil || rightCols == nil {
+			newExpr.Operators = append(newExpr.Operators, join, where)
+			i++
+			continue
+		}
+		// Unqualified references to columns present on both sides
+		// refer to the left side's column.
+		// Outer joins produce rows for the unmatched rows of the outer side,
+		// so filters cannot be moved to the other side.
+		// Filters on the output of a semi or anti join
+		// can only refer to the side that is returned.
+		// Only right semi and anti joins return the right side's columns
+		// under names that the left side also has.
+		canPushLeft, canPushRight := true, true
+		rightOnly := false
+		switch joinFlavorName(join) {
+		case "leftouter", "leftsemi", "leftanti":
+			canPushRight = false
+		case "rightsemi", "rightanti":
+			canPushLeft = false
+			rightOnly = true
+		case "rightouter":
+			canPushLeft = false
+		case "fullouter":
+			canPushLeft, canPushRight = false, false
+		}
+		var leftPreds, rightPreds, rest []parser.Expr
+		for _, pred := range conjuncts(where.Predicate) {
+			cols, ok := referencedColumns(pred, scope)
+			switch {
+			case !ok || len(cols) == 0:
+				rest = append(rest, pred)
+			case !canPushLeft && allColumnsIn(cols, rightCols) && (rightOnly || !anyColumnIn(cols, leftCols)):
+				if canPushRight {
+					rightPreds = append(rightPreds, pred)
+				} else {
+					rest = append(rest, pred)
+				}
+			case canPushLeft && allColumnsIn(cols, leftCols):
+				leftPreds = append(leftPreds, pred)
+			case canPushRight && allColumnsIn(cols, rightCols) && !anyColumnIn(cols, leftCols):
+				rightPreds = append(rightPreds, pred)
+			default:
+				rest = append(rest, pred)
+			}
+		}
+		if len(leftPreds) > 0 {
+			newExpr.Operators = append(newExpr.Operators, synthesizedWhere(leftPreds))
+		}
+		if len(rightPreds) > 0 {
+			newJoin := *join
+			newJoin.Right = &parser.TabularExpr{
+				Source:    join.Right.Source,
+				Operators: append(slices.Clip(join.Right.Operators), synthesizedWhere(rightPreds)),
+			}
+			join = &newJoin
+		}
+		newExpr.Operators = append(newExpr.Operators, join)
+		if len(rest) > 0 {
+			newExpr.Operators = append(newExpr.Operators, &parser.WhereOperator{
+				Pipe:      where.Pipe,
+				Keyword:   where.Keyword,
+				Predicate: andAll(rest),
+			})
+		}
+		i++
+	}
+	return newExpr
+}
+
+// pushDownJoinRight returns join with predicates pushed down
+// within its right side.
+func (r *columnResolver) pushDownJoinRight(join *parser.JoinOperator, scope map[string]string) *parser.JoinOperator {
+	if join.Right == nil {
+		return join
+	}
+	newJoin := *join
+	newJoin.Right = r.pushDownPredicates(join.Right, scope)
+	return &newJoin
+}
+
+// conjuncts splits x into the operands of its top-level "and" operators.
+func conjuncts(x parser.Expr) []parser.Expr {
+	for {
+		p, ok := x.(*parser.ParenExpr)
+		if !ok {
+			break
+		}
+		x = p.X
+	}
+	if b, ok := x.(*parser.BinaryExpr); ok && b.Op == parser.TokenAnd {
+		return append(conjuncts(b.X), conjuncts(b.Y)...)
+	}
+	return []parser.Expr{x}
+}
+
+// andAll returns the conjunction of the given predicates.
+func andAll(preds []parser.Expr) parser.Expr {
+	var x parser.Expr
+	for _, pred := range preds {
+		x = andExpr(x, pred)
+	}
+	return x
+}
+
+func synthesizedWhere(preds []parser.Expr) *parser.WhereOperator {
+	return &parser.WhereOperator{
+		Pipe:      parser.Span{Start: -1, End: -1},
+		Keyword:   parser.Span{Start: -1, End: -1},
+		Predicate: andAll(preds),
+	}
+}
+
+// referencedColumns returns the names of the columns that x refers to.
+// It reports false if x refers to a column in a way
+// that prevents moving it to another query,
+// like a qualified join column reference.
+func referencedColumns(x parser.Expr, scope map[string]string) (_ []string, ok bool) {
+	var names []string
+	ok = true
+	parser.Walk(x, func(n parser.Node) bool {
+		id, isIdent := n.(*parser.QualifiedIdent)
+		if !isIdent {
+			return ok
+		}
+		name := id.Parts[0]
+		if !name.Quoted {
+			if _, isVar := scope[name.Name]; isVar {
+				return false
+			}
+			if _, isBuiltin := builtinIdentifiers[name.Name]; isBuiltin {
+				return false
+			}
+			if name.Name == leftJoinTableAlias || name.Name == rightJoinTableAlias {
+				ok = false
+				return false
+			}
+		}
+		names = append(names, name.Name)
+		return false
+	})
+	return names, ok
+}
+
+func allColumnsIn(names []string, cols []*AnalysisColumn) bool {
+	for _, name := range names {
+		if columnIndex(cols, name) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func anyColumnIn(names []string, cols []*AnalysisColumn) bool {
+	for _, name := range names {
+		if columnIndex(cols, name) >= 0 {
+			return true
+		}
+	}
+	return false
+}
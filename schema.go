@@ -0,0 +1,506 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tailscale/hujson"
+)
+
+// Dialect identifies a database system.
+type Dialect int
+
+const (
+	// ClickHouseDialect is the dialect of ClickHouse.
+	ClickHouseDialect Dialect = iota
+	// PostgresDialect is the dialect of PostgreSQL.
+	PostgresDialect
+	// DuckDBDialect is the dialect of DuckDB.
+	DuckDBDialect
+)
+
+// String returns the lowercase name of the dialect, like "clickhouse".
+func (d Dialect) String() string {
+	switch d {
+	case ClickHouseDialect:
+		return "clickhouse"
+	case PostgresDialect:
+		return "postgres"
+	case DuckDBDialect:
+		return "duckdb"
+	default:
+		return fmt.Sprintf("Dialect(%d)", int(d))
+	}
+}
+
+// LoadSchema builds an [AnalysisContext] from the columns
+// listed in the information_schema of db.
+// Tables in the current schema (the current database in ClickHouse)
+// are added to Tables
+// and tables in other schemas are added to Databases.
+// System schemas like information_schema itself are omitted.
+func LoadSchema(ctx context.Context, db *sql.DB, dialect Dialect) (*AnalysisContext, error) {
+	var currentQuery string
+	var systemSchemas []string
+	switch dialect {
+	case ClickHouseDialect:
+		currentQuery = "SELECT currentDatabase()"
+		systemSchemas = []string{"system", "information_schema", "INFORMATION_SCHEMA"}
+	case PostgresDialect:
+		currentQuery = "SELECT current_schema()"
+		systemSchemas = []string{"information_schema", "pg_catalog", "pg_toast"}
+	case DuckDBDialect:
+		currentQuery = "SELECT current_schema()"
+		systemSchemas = []string{"information_schema", "pg_catalog"}
+	default:
+		return nil, fmt.Errorf("load schema: unsupported dialect %v", dialect)
+	}
+
+	var current string
+	if err := db.QueryRowContext(ctx, currentQuery).Scan(&current); err != nil {
+		return nil, fmt.Errorf("load schema: %w", err)
+	}
+
+	sb := new(strings.Builder)
+	sb.WriteString("SELECT table_schema, table_name, column_name, data_type " +
+		"FROM information_schema.columns " +
+		"WHERE table_schema NOT IN (")
+	for i, name := range systemSchemas {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		quoteSQLString(sb, name)
+	}
+	sb.WriteString(") ORDER BY table_schema, table_name, ordinal_position")
+
+	ac := &AnalysisContext{
+		Tables:    make(map[string]*AnalysisTable),
+		Databases: make(map[string]*AnalysisDatabase),
+	}
+	err := queryRows(ctx, db, sb.String(), func(rows *sql.Rows) error {
+		var schemaName, tableName string
+		col := new(AnalysisColumn)
+		if err := rows.Scan(&schemaName, &tableName, &col.Name, &col.Type); err != nil {
+			return err
+		}
+		tab := ac.loadedTable(current, schemaName, tableName)
+		tab.Columns = append(tab.Columns, col)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load schema: %w", err)
+	}
+	return ac, nil
+}
+
+// LoadSchemaFile reads an [AnalysisContext] from a schema file.
+// See [ParseSchema] for the file format.
+func LoadSchemaFile(path string) (*AnalysisContext, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ac, err := ParseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return ac, nil
+}
+
+// ParseSchema parses a schema file into an [AnalysisContext].
+// A schema file is a JSON object that may contain comments and trailing commas
+// (also known as JWCC), like:
+//
+//	{
+//	  // Tables in the default database.
+//	  "tables": {
+//	    "Events": {
+//	      "description": "Audit events",
+//	      "columns": [
+//	        {"name": "id", "type": "UInt64"},
+//	        {"name": "actor", "type": "JSON", "fields": [
+//	          {"name": "email", "type": "String"},
+//	        ]},
+//	      ],
+//	    },
+//	  },
+//	  "databases": {
+//	    "security": {
+//	      "description": "Security data",
+//	      "tables": {
+//	        "Logins": {"columns": [{"name": "user", "description": "User name"}]},
+//	      },
+//	    },
+//	  },
+//	}
+//
+// Unknown keys are an error.
+func ParseSchema(data []byte) (*AnalysisContext, error) {
+	data, err := hujson.Standardize(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse schema: %v", err)
+	}
+	var parsed struct {
+		Tables    map[string]*schemaFileTable `json:"tables"`
+		Databases map[string]*struct {
+			Description string                      `json:"description"`
+			Tables      map[string]*schemaFileTable `json:"tables"`
+		} `json:"databases"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("parse schema: %v", err)
+	}
+
+	ac := &AnalysisContext{
+		Tables: convertSchemaFileTables(parsed.Tables),
+	}
+	if len(parsed.Databases) > 0 {
+		ac.Databases = make(map[string]*AnalysisDatabase, len(parsed.Databases))
+		for name, db := range parsed.Databases {
+			if db == nil {
+				return nil, fmt.Errorf("parse schema: database %q is null", name)
+			}
+			ac.Databases[name] = &AnalysisDatabase{
+				Description: db.Description,
+				Tables:      convertSchemaFileTables(db.Tables),
+			}
+		}
+	}
+	return ac, nil
+}
+
+// schemaFileTable is the JSON representation of an [AnalysisTable]
+// in a schema file.
+type schemaFileTable struct {
+	Description string              `json:"description"`
+	Columns     []*schemaFileColumn `json:"columns"`
+}
+
+// schemaFileColumn is the JSON representation of an [AnalysisColumn]
+// in a schema file.
+type schemaFileColumn struct {
+	Name        string              `json:"name"`
+	Type        string              `json:"type"`
+	Description string              `json:"description"`
+	Fields      []*schemaFileColumn `json:"fields"`
+}
+
+func convertSchemaFileTables(tables map[string]*schemaFileTable) map[string]*AnalysisTable {
+	if tables == nil {
+		return nil
+	}
+	result := make(map[string]*AnalysisTable, len(tables))
+	for name, tab := range tables {
+		if tab == nil {
+			tab = new(schemaFileTable)
+		}
+		result[name] = &AnalysisTable{
+			Description: tab.Description,
+			Columns:     convertSchemaFileColumns(tab.Columns),
+		}
+	}
+	return result
+}
+
+func convertSchemaFileColumns(cols []*schemaFileColumn) []*AnalysisColumn {
+	if cols == nil {
+		return nil
+	}
+	result := make([]*AnalysisColumn, 0, len(cols))
+	for _, col := range cols {
+		if col == nil {
+			continue
+		}
+		result = append(result, &AnalysisColumn{
+			Name:        col.Name,
+			Type:        col.Type,
+			Description: col.Description,
+			Fields:      convertSchemaFileColumns(col.Fields),
+		})
+	}
+	return result
+}
+
+// loadedTable returns the table with the given name in ac,
+// adding it if necessary.
+// Tables in the current database are added to ac.Tables
+// and other tables are added to ac.Databases.
+func (ac *AnalysisContext) loadedTable(current, database, name string) *AnalysisTable {
+	tables := ac.Tables
+	if database != current {
+		db := ac.Databases[database]
+		if db == nil {
+			db = &AnalysisDatabase{Tables: make(map[string]*AnalysisTable)}
+			ac.Databases[database] = db
+		}
+		tables = db.Tables
+	}
+	tab := tables[name]
+	if tab == nil {
+		tab = new(AnalysisTable)
+		tables[name] = tab
+	}
+	return tab
+}
+
+// ClickHouseSchemaLoader builds an [AnalysisContext]
+// from the system.tables and system.columns tables of a ClickHouse server,
+// including column types and the comments on tables and columns.
+type ClickHouseSchemaLoader struct {
+	DB *sql.DB
+
+	// Databases is the set of databases to load.
+	// If Databases is empty, every database other than
+	// the system and information_schema databases is loaded.
+	Databases []string
+}
+
+// Load returns the current schema of the server.
+// Tables in the connection's current database are added to Tables
+// and tables in other databases are added to Databases.
+func (l *ClickHouseSchemaLoader) Load(ctx context.Context) (*AnalysisContext, error) {
+	var current string
+	if err := l.DB.QueryRowContext(ctx, "SELECT currentDatabase()").Scan(&current); err != nil {
+		return nil, fmt.Errorf("load clickhouse schema: %w", err)
+	}
+
+	filter := new(strings.Builder)
+	if len(l.Databases) > 0 {
+		filter.WriteString("database IN (")
+		for i, name := range l.Databases {
+			if i > 0 {
+				filter.WriteString(", ")
+			}
+			quoteSQLString(filter, name)
+		}
+		filter.WriteString(")")
+	} else {
+		filter.WriteString("database NOT IN ('system', 'information_schema', 'INFORMATION_SCHEMA')")
+	}
+
+	ac := &AnalysisContext{
+		Tables:    make(map[string]*AnalysisTable),
+		Databases: make(map[string]*AnalysisDatabase),
+	}
+	err := queryRows(ctx, l.DB, "SELECT database, name, comment FROM system.tables WHERE "+filter.String(), func(rows *sql.Rows) error {
+		var database, name, comment string
+		if err := rows.Scan(&database, &name, &comment); err != nil {
+			return err
+		}
+		ac.loadedTable(current, database, name).Description = comment
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load clickhouse schema: %w", err)
+	}
+	err = queryRows(ctx, l.DB, "SELECT database, table, name, type, comment FROM system.columns WHERE "+filter.String()+" ORDER BY database, table, position", func(rows *sql.Rows) error {
+		var database, table string
+		col := new(AnalysisColumn)
+		if err := rows.Scan(&database, &table, &col.Name, &col.Type, &col.Description); err != nil {
+			return err
+		}
+		tab := ac.loadedTable(current, database, table)
+		tab.Columns = append(tab.Columns, col)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load clickhouse schema: %w", err)
+	}
+	return ac, nil
+}
+
+// Run calls Load immediately and then every interval
+// until ctx is done,
+// passing each result to update.
+// update is called on the goroutine that called Run.
+// To share the latest schema with other goroutines,
+// update can store it in an [sync/atomic.Pointer].
+// Run returns ctx.Err() once ctx is done.
+func (l *ClickHouseSchemaLoader) Run(ctx context.Context, interval time.Duration, update func(ac *AnalysisContext, err error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		update(l.Load(ctx))
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// queryRows runs query on db and calls f for each row of the result.
+func queryRows(ctx context.Context, db *sql.DB, query string, f func(rows *sql.Rows) error) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := f(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// SchemaFromStruct returns the schema of a table
+// whose rows have the fields of the struct type T
+// (or the struct type that T points to).
+// Each exported field is a column, except for fields tagged with `pql:"-"`.
+// The fields of embedded structs are columns of the table
+// as if they were fields of T.
+//
+// A column's name is given by the field's pql tag,
+// or its json tag if it has no pql tag, or else by the field's name.
+// Its type is given by the field's pqltype tag
+// or else is the ClickHouse name of the field's Go type, like "Int64" or "Array(String)".
+// Its description is given by the field's pqldesc tag.
+// Struct fields are described as nested fields of their columns.
+//
+//	type Event struct {
+//		ID        uint64    `pql:"id" pqldesc:"Unique event ID"`
+//		Timestamp time.Time `json:"ts"`
+//		Actor     struct {
+//			Email string `json:"email"`
+//		} `json:"actor" pqltype:"JSON"`
+//	}
+//
+//	ac := &pql.AnalysisContext{
+//		Tables: map[string]*pql.AnalysisTable{"Events": pql.SchemaFromStruct[Event]()},
+//	}
+//
+// SchemaFromStruct panics if T is not a struct or a pointer to a struct.
+func SchemaFromStruct[T any]() *AnalysisTable {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("pql.SchemaFromStruct: %v is not a struct", typ))
+	}
+	return &AnalysisTable{
+		Columns: structColumns(typ, map[reflect.Type]bool{typ: true}),
+	}
+}
+
+// structColumns returns the columns for the fields of the struct type typ.
+// visiting is the set of struct types that enclose typ,
+// whose fields are not described again to prevent infinite recursion.
+func structColumns(typ reflect.Type, visiting map[reflect.Type]bool) []*AnalysisColumn {
+	var cols []*AnalysisColumn
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, hasTag := field.Tag.Lookup("pql")
+		if tag == "-" {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && !hasTag && fieldType.Kind() == reflect.Struct {
+			if !visiting[fieldType] {
+				visiting[fieldType] = true
+				cols = append(cols, structColumns(fieldType, visiting)...)
+				delete(visiting, fieldType)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		col := &AnalysisColumn{
+			Name:        tag,
+			Type:        field.Tag.Get("pqltype"),
+			Description: field.Tag.Get("pqldesc"),
+		}
+		if col.Name == "" {
+			jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if jsonName == "-" {
+				continue
+			}
+			col.Name = jsonName
+		}
+		if col.Name == "" {
+			col.Name = field.Name
+		}
+		if col.Type == "" {
+			col.Type = clickHouseTypeName(field.Type)
+		}
+		if fieldType.Kind() == reflect.Struct && fieldType != timeType && !visiting[fieldType] {
+			visiting[fieldType] = true
+			col.Fields = structColumns(fieldType, visiting)
+			delete(visiting, fieldType)
+		}
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// clickHouseTypeName returns the name of the ClickHouse data type
+// that corresponds to typ,
+// or the empty string if there is no single corresponding type.
+func clickHouseTypeName(typ reflect.Type) string {
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "Bool"
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("Int%d", typ.Bits())
+	case reflect.Int:
+		return "Int64"
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("UInt%d", typ.Bits())
+	case reflect.Uint, reflect.Uintptr:
+		return "UInt64"
+	case reflect.Float32, reflect.Float64:
+		return fmt.Sprintf("Float%d", typ.Bits())
+	case reflect.String:
+		return "String"
+	case reflect.Pointer:
+		elem := clickHouseTypeName(typ.Elem())
+		if elem == "" || typ.Elem().Kind() == reflect.Slice || typ.Elem().Kind() == reflect.Map {
+			return elem
+		}
+		return "Nullable(" + elem + ")"
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return "String"
+		}
+		elem := clickHouseTypeName(typ.Elem())
+		if elem == "" {
+			return ""
+		}
+		return "Array(" + elem + ")"
+	case reflect.Map:
+		key, elem := clickHouseTypeName(typ.Key()), clickHouseTypeName(typ.Elem())
+		if key == "" || elem == "" {
+			return ""
+		}
+		return "Map(" + key + ", " + elem + ")"
+	case reflect.Struct:
+		if typ == timeType {
+			return "DateTime64(9)"
+		}
+		return ""
+	default:
+		return ""
+	}
+}
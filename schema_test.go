@@ -0,0 +1,368 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadSchema(t *testing.T) {
+	d := &fakeSQLDriver{
+		results: map[string]fakeSQLResult{
+			"SELECT current_schema()": {
+				columns: []string{"current_schema"},
+				rows:    [][]driver.Value{{"public"}},
+			},
+			"SELECT table_schema, table_name, column_name, data_type FROM information_schema.columns": {
+				columns: []string{"table_schema", "table_name", "column_name", "data_type"},
+				rows: [][]driver.Value{
+					{"audit", "Logins", "user", "text"},
+					{"public", "Events", "id", "bigint"},
+					{"public", "Events", "name", "text"},
+				},
+			},
+		},
+	}
+	db := sql.OpenDB(d)
+	defer db.Close()
+
+	got, err := LoadSchema(context.Background(), db, PostgresDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &AnalysisContext{
+		Tables: map[string]*AnalysisTable{
+			"Events": {
+				Columns: []*AnalysisColumn{
+					{Name: "id", Type: "bigint"},
+					{Name: "name", Type: "text"},
+				},
+			},
+		},
+		Databases: map[string]*AnalysisDatabase{
+			"audit": {
+				Tables: map[string]*AnalysisTable{
+					"Logins": {
+						Columns: []*AnalysisColumn{
+							{Name: "user", Type: "text"},
+						},
+					},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LoadSchema(...) (-want +got):\n%s", diff)
+	}
+	if q := d.queries[len(d.queries)-1]; !strings.Contains(q, "NOT IN ('information_schema', 'pg_catalog', 'pg_toast')") {
+		t.Errorf("query %q does not exclude system schemas", q)
+	}
+}
+
+func TestClickHouseSchemaLoader(t *testing.T) {
+	d := &fakeSQLDriver{
+		results: map[string]fakeSQLResult{
+			"SELECT currentDatabase()": {
+				columns: []string{"currentDatabase()"},
+				rows:    [][]driver.Value{{"default"}},
+			},
+			"SELECT database, name, comment FROM system.tables": {
+				columns: []string{"database", "name", "comment"},
+				rows: [][]driver.Value{
+					{"default", "Events", "Raw events"},
+					{"default", "Empty", ""},
+					{"logs", "Access", ""},
+				},
+			},
+			"SELECT database, table, name, type, comment FROM system.columns": {
+				columns: []string{"database", "table", "name", "type", "comment"},
+				rows: [][]driver.Value{
+					{"default", "Events", "id", "UInt64", "Event ID"},
+					{"default", "Events", "ts", "DateTime", ""},
+					{"logs", "Access", "path", "String", ""},
+				},
+			},
+		},
+	}
+	db := sql.OpenDB(d)
+	defer db.Close()
+
+	loader := &ClickHouseSchemaLoader{
+		DB:        db,
+		Databases: []string{"default", "logs"},
+	}
+	got, err := loader.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &AnalysisContext{
+		Tables: map[string]*AnalysisTable{
+			"Events": {
+				Description: "Raw events",
+				Columns: []*AnalysisColumn{
+					{Name: "id", Type: "UInt64", Description: "Event ID"},
+					{Name: "ts", Type: "DateTime"},
+				},
+			},
+			"Empty": {},
+		},
+		Databases: map[string]*AnalysisDatabase{
+			"logs": {
+				Tables: map[string]*AnalysisTable{
+					"Access": {
+						Columns: []*AnalysisColumn{
+							{Name: "path", Type: "String"},
+						},
+					},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Load(...) (-want +got):\n%s", diff)
+	}
+	if q := d.queries[len(d.queries)-1]; !strings.Contains(q, "WHERE database IN ('default', 'logs')") {
+		t.Errorf("query %q does not filter databases", q)
+	}
+
+	// Run loads the schema until the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := 0
+	err = loader.Run(ctx, time.Millisecond, func(ac *AnalysisContext, err error) {
+		if err != nil {
+			t.Error("Run:", err)
+		}
+		if n++; n == 2 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run(...) = %v; want %v", err, context.Canceled)
+	}
+	if n != 2 {
+		t.Errorf("update called %d times; want 2", n)
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	const input = `{
+  // Tables in the default database.
+  "tables": {
+    "Events": {
+      "description": "Audit events",
+      "columns": [
+        {"name": "id", "type": "UInt64"},
+        {"name": "actor", "type": "JSON", "fields": [
+          {"name": "email", "type": "String"},
+        ]},
+      ],
+    },
+  },
+  "databases": {
+    "security": {
+      "description": "Security data",
+      "tables": {
+        "Logins": {"columns": [{"name": "user", "description": "User name"}]},
+      },
+    },
+  },
+}`
+	got, err := ParseSchema([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &AnalysisContext{
+		Tables: map[string]*AnalysisTable{
+			"Events": {
+				Description: "Audit events",
+				Columns: []*AnalysisColumn{
+					{Name: "id", Type: "UInt64"},
+					{
+						Name: "actor",
+						Type: "JSON",
+						Fields: []*AnalysisColumn{
+							{Name: "email", Type: "String"},
+						},
+					},
+				},
+			},
+		},
+		Databases: map[string]*AnalysisDatabase{
+			"security": {
+				Description: "Security data",
+				Tables: map[string]*AnalysisTable{
+					"Logins": {
+						Columns: []*AnalysisColumn{
+							{Name: "user", Description: "User name"},
+						},
+					},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseSchema(...) (-want +got):\n%s", diff)
+	}
+
+	if _, err := ParseSchema([]byte(`{"tabels": {}}`)); err == nil {
+		t.Error("ParseSchema with unknown key did not return an error")
+	}
+}
+
+func TestLoadSchemaFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.jwcc")
+	if err := os.WriteFile(path, []byte(`{"tables": {"T": {"columns": [{"name": "x"}]}}}`), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadSchemaFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &AnalysisContext{
+		Tables: map[string]*AnalysisTable{
+			"T": {Columns: []*AnalysisColumn{{Name: "x"}}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LoadSchemaFile(...) (-want +got):\n%s", diff)
+	}
+}
+
+// fakeSQLDriver is a [driver.Connector] that returns fixed results
+// for queries that start with a given prefix.
+type fakeSQLDriver struct {
+	results map[string]fakeSQLResult
+	queries []string
+}
+
+type fakeSQLResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (d *fakeSQLDriver) Connect(context.Context) (driver.Conn, error) { return fakeSQLConn{d}, nil }
+func (d *fakeSQLDriver) Driver() driver.Driver                        { return nil }
+
+type fakeSQLConn struct{ d *fakeSQLDriver }
+
+func (c fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c fakeSQLConn) Close() error              { return nil }
+func (c fakeSQLConn) Begin() (driver.Tx, error) { return nil, errors.New("transactions not supported") }
+
+func (c fakeSQLConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	c.d.queries = append(c.d.queries, query)
+	for prefix, result := range c.d.results {
+		if strings.HasPrefix(query, prefix) {
+			return &fakeSQLRows{columns: result.columns, rows: result.rows}, nil
+		}
+	}
+	return nil, errors.New("unexpected query " + query)
+}
+
+type fakeSQLRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return r.columns }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSchemaFromStruct(t *testing.T) {
+	type Base struct {
+		ID      uint64 `pql:"id" pqldesc:"Unique event ID"`
+		private int
+	}
+	type Actor struct {
+		Email string `json:"email,omitempty"`
+		Admin bool
+	}
+	type Event struct {
+		Base
+		Timestamp time.Time         `json:"ts"`
+		Actor     *Actor            `json:"actor" pqltype:"JSON"`
+		Tags      []string          `json:"tags"`
+		Labels    map[string]string `json:"labels"`
+		Count     *int32            `json:"count"`
+		Payload   []byte            `json:"payload"`
+		Score     float64
+		Ignored   string `pql:"-"`
+		Hidden    string `json:"-"`
+		Any       any    `json:"any"`
+	}
+	got := SchemaFromStruct[*Event]()
+	want := &AnalysisTable{
+		Columns: []*AnalysisColumn{
+			{Name: "id", Type: "UInt64", Description: "Unique event ID"},
+			{Name: "ts", Type: "DateTime64(9)"},
+			{
+				Name: "actor",
+				Type: "JSON",
+				Fields: []*AnalysisColumn{
+					{Name: "email", Type: "String"},
+					{Name: "Admin", Type: "Bool"},
+				},
+			},
+			{Name: "tags", Type: "Array(String)"},
+			{Name: "labels", Type: "Map(String, String)"},
+			{Name: "count", Type: "Nullable(Int32)"},
+			{Name: "payload", Type: "String"},
+			{Name: "Score", Type: "Float64"},
+			{Name: "any"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("SchemaFromStruct[*Event]() (-want +got):\n%s", diff)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("SchemaFromStruct[int]() did not panic")
+			}
+		}()
+		SchemaFromStruct[int]()
+	}()
+}
+
+func TestSchemaFromStructRecursive(t *testing.T) {
+	type Node struct {
+		Name     string  `json:"name"`
+		Children []*Node `json:"children"`
+		Parent   *Node   `json:"parent"`
+	}
+	got := SchemaFromStruct[Node]()
+	want := &AnalysisTable{
+		Columns: []*AnalysisColumn{
+			{Name: "name", Type: "String"},
+			{Name: "children"},
+			{Name: "parent"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("SchemaFromStruct[Node]() (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/runreveal/pql/parser"
+)
+
+// SQLFormat is a layout for the SQL produced by [CompileOptions.Compile].
+type SQLFormat int
+
+const (
+	// DefaultSQLFormat writes each common table expression on its own line
+	// and each query on a single line.
+	DefaultSQLFormat SQLFormat = iota
+	// CompactSQLFormat writes the entire statement on a single line.
+	// It is suitable for logs and other line-oriented transports.
+	CompactSQLFormat
+	// PrettySQLFormat starts each clause on its own line
+	// and indents subqueries by their nesting depth.
+	// It is intended for humans reviewing the output.
+	PrettySQLFormat
+)
+
+// sqlIndent is the indentation for each level of subquery in [PrettySQLFormat].
+const sqlIndent = "  "
+
+// sqlClauseKeywords is the set of keywords that start a new line
+// in [PrettySQLFormat].
+var sqlClauseKeywords = map[string]struct{}{
+	"FROM":   {},
+	"WHERE":  {},
+	"GROUP":  {},
+	"HAVING": {},
+	"ORDER":  {},
+	"LIMIT":  {},
+	"JOIN":   {},
+	"LEFT":   {},
+	"UNION":  {},
+}
+
+// formatSQL rewrites the whitespace in the SQL produced by the compiler
+// according to format.
+// String literals, quoted identifiers, and comments are left intact.
+func formatSQL(sql string, format SQLFormat) string {
+	switch format {
+	case CompactSQLFormat, PrettySQLFormat:
+	default:
+		return sql
+	}
+	tokens := splitSQL(sql)
+	sb := new(strings.Builder)
+	sb.Grow(len(sql))
+	if format == CompactSQLFormat {
+		for i, tok := range tokens {
+			if i > 0 && tok.spaceBefore {
+				sb.WriteString(" ")
+			}
+			sb.WriteString(tok.text)
+		}
+		return sb.String()
+	}
+
+	// subqueries has an element for each open parenthesis
+	// that is true if the parenthesis starts a subquery.
+	var subqueries []bool
+	depth := 0
+	newline := func(extra string) {
+		sb.WriteString("\n")
+		sb.WriteString(strings.Repeat(sqlIndent, depth))
+		sb.WriteString(extra)
+	}
+	atClauseLevel := func() bool {
+		return len(subqueries) == 0 || subqueries[len(subqueries)-1]
+	}
+	for i, tok := range tokens {
+		_, isClause := sqlClauseKeywords[tok.text]
+		switch tok.text {
+		case "LEFT":
+			isClause = i+1 < len(tokens) && tokens[i+1].text == "JOIN"
+		case "JOIN":
+			isClause = i == 0 || tokens[i-1].text != "LEFT"
+		}
+		switch {
+		case i == 0:
+		case tok.text == ")" && len(subqueries) > 0 && subqueries[len(subqueries)-1]:
+			depth--
+			newline("")
+		case isClause && atClauseLevel():
+			newline("")
+		case tokens[i-1].text == "(" && len(subqueries) > 0 && subqueries[len(subqueries)-1]:
+			newline("")
+		case tok.newlineBefore:
+			if tok.text == "SELECT" || (tokens[i-1].text == "," && i > 1 && tokens[i-2].text == ")") {
+				// Start of the final query or the next common table expression.
+				newline("")
+			} else {
+				newline(sqlIndent)
+			}
+		case tok.spaceBefore:
+			sb.WriteString(" ")
+		}
+		sb.WriteString(tok.text)
+
+		switch tok.text {
+		case "(":
+			isSubquery := i+1 < len(tokens) && tokens[i+1].text == "SELECT"
+			subqueries = append(subqueries, isSubquery)
+			if isSubquery {
+				depth++
+			}
+		case ")":
+			if len(subqueries) > 0 {
+				subqueries = subqueries[:len(subqueries)-1]
+			}
+		}
+	}
+	return sb.String()
+}
+
+// sqlToken is a lexical element of SQL as used by [formatSQL].
+type sqlToken struct {
+	text          string
+	offset        int
+	spaceBefore   bool
+	newlineBefore bool
+}
+
+// splitSQL splits sql into tokens separated by whitespace or punctuation.
+func splitSQL(sql string) []sqlToken {
+	var tokens []sqlToken
+	space, newline := false, false
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			space = true
+			newline = newline || c == '\n'
+			i++
+			continue
+		case c == '\'' || c == '"':
+			i++
+			for i < len(sql) && sql[i] != c {
+				i++
+			}
+			i++
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += 2 + end + 2
+			}
+		case c == '(' || c == ')' || c == ',' || c == ';':
+			i++
+		default:
+			for i < len(sql) && !strings.ContainsRune(" \t\r\n'\"(),;", rune(sql[i])) && !strings.HasPrefix(sql[i:], "/*") {
+				i++
+			}
+		}
+		i = min(i, len(sql))
+		tokens = append(tokens, sqlToken{
+			text:          sql[start:i],
+			offset:        start,
+			spaceBefore:   space,
+			newlineBefore: newline,
+		})
+		space, newline = false, false
+	}
+	return tokens
+}
+
+// formattedSpans translates spans of whole tokens in raw
+// to the spans of the same tokens in formatted,
+// the result of [formatSQL] for raw.
+// Spans that cannot be translated are invalid in the result.
+func formattedSpans(raw, formatted string, spans []parser.Span) []parser.Span {
+	rawTokens := splitSQL(raw)
+	formattedTokens := splitSQL(formatted)
+	result := make([]parser.Span, len(spans))
+	for i, span := range spans {
+		result[i] = parser.Span{Start: -1, End: -1}
+		if !span.IsValid() || len(rawTokens) != len(formattedTokens) {
+			continue
+		}
+		// Find the tokens that start within the span.
+		start, _ := slices.BinarySearchFunc(rawTokens, span.Start, compareTokenOffset)
+		end, _ := slices.BinarySearchFunc(rawTokens, span.End, compareTokenOffset)
+		if start >= end {
+			continue
+		}
+		last := formattedTokens[end-1]
+		result[i] = parser.Span{
+			Start: formattedTokens[start].offset,
+			End:   last.offset + len(last.text),
+		}
+	}
+	return result
+}
+
+func compareTokenOffset(tok sqlToken, offset int) int {
+	return cmp.Compare(tok.offset, offset)
+}
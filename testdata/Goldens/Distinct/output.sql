@@ -0,0 +1,2 @@
+WITH "__subquery0" AS (SELECT DISTINCT "Directory" AS "Directory" FROM "SourceFiles")
+SELECT * FROM "__subquery0" ORDER BY "Directory" ASC NULLS FIRST;
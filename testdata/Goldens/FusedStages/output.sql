@@ -0,0 +1,2 @@
+WITH "__subquery0" AS (SELECT *, "DamageProperty" / 1000 AS "Damage" FROM "StormEvents" WHERE ("DamageProperty" > 0) AND ("Damage" > 10))
+SELECT "State" AS "State", "Damage" AS "Damage" FROM "__subquery0" LIMIT 5;
@@ -0,0 +1,2 @@
+WITH "__subquery0" AS (SELECT "State" AS "State" FROM "StormEvents" WHERE "DamageProperty" > 0)
+SELECT * FROM "__subquery0" AS "$left" JOIN "__subquery0" AS "$right" ON "$left"."State" = "$right"."State" ORDER BY "State" ASC NULLS FIRST;
@@ -0,0 +1,3 @@
+WITH "Starts" AS (SELECT * FROM "MyLogTable" WHERE coalesce("EventType" = 'Start', FALSE)),
+     "__subquery1" AS (SELECT "EventId" AS "EventId", "TargetId" AS "TargetId" FROM "Starts" WHERE coalesce("TargetType" = 'X', FALSE))
+SELECT * FROM "__subquery1" ORDER BY "EventId" ASC NULLS FIRST;
@@ -0,0 +1,2 @@
+WITH "__subquery0" AS (SELECT * FROM "HostAddresses" ARRAY JOIN "ips" ORDER BY "ips" ASC NULLS FIRST)
+SELECT "host" AS "host", "ips" AS "ip" FROM "__subquery0";
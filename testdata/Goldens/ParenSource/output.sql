@@ -0,0 +1,2 @@
+WITH "__subquery0" AS (SELECT * FROM "StormEvents" WHERE coalesce("State" = 'FLORIDA', FALSE))
+SELECT COUNT(*) AS "count()" FROM "__subquery0";
@@ -0,0 +1 @@
+SELECT * EXCEPT ("EventType", "TargetId") FROM "MyLogTable" WHERE coalesce("EventType" = 'Stop', FALSE) ORDER BY "EventId" ASC NULLS FIRST;
@@ -0,0 +1,5 @@
+WITH "__subquery0" AS (SELECT * FROM "MyLogTable" WHERE coalesce("EventType" = 'Stop', FALSE)),
+     "__subquery1" AS (SELECT * FROM "MyLogTable" WHERE coalesce("TargetType" = 'Y', FALSE)),
+     "__subquery2" AS (SELECT * FROM (SELECT 'union_arg0' AS "Source", * FROM "__subquery0" UNION ALL SELECT 'union_arg1' AS "Source", * FROM "__subquery1") AS "__union"),
+     "__subquery3" AS (SELECT "Source" AS "Source", count() AS "n" FROM "__subquery2" GROUP BY "Source")
+SELECT * FROM "__subquery3" ORDER BY "Source" ASC NULLS FIRST;
@@ -0,0 +1 @@
+SELECT * FROM "StormEvents" WHERE (("DamageProperty" > 0) AND ((coalesce("State" = 'FLORIDA', FALSE)) OR (coalesce("State" = 'GEORGIA', FALSE)))) AND (coalesce("EventType" <> 'Heavy Rain', FALSE));
@@ -0,0 +1 @@
+SELECT * FROM "StormEvents" WHERE ((coalesce("State" = 'FLORIDA', FALSE)) OR (coalesce("State" = 'MISSISSIPPI', FALSE))) AND ("DamageProperty" > 0);
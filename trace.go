@@ -0,0 +1,88 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import "context"
+
+// A Tracer observes the phases of compiling a query or suggesting completions,
+// for example to record them as OpenTelemetry spans
+// and to count their latency and errors as metrics.
+// A Tracer must be safe to call from multiple goroutines concurrently.
+type Tracer interface {
+	// Start is called when a phase begins.
+	// The returned context is used for the rest of the phase,
+	// including nested phases and calls to the [TableProvider],
+	// so it can carry a span to use as their parent.
+	// The returned function is called exactly once when the phase ends,
+	// with the error that ended it or nil if the phase succeeded.
+	Start(ctx context.Context, phase TracePhase) (context.Context, func(err error))
+}
+
+// TracePhase is the name of a phase passed to a [Tracer].
+type TracePhase string
+
+// Phases of [*CompileOptions.CompileContext].
+// A compile phase encloses a parse, split, and write phase in that order.
+// Later phases are skipped if an earlier one fails.
+const (
+	// TracePhaseCompile is the whole of compiling a query.
+	TracePhaseCompile TracePhase = "compile"
+	// TracePhaseParse is parsing the source into statements.
+	TracePhaseParse TracePhase = "parse"
+	// TracePhaseSplit is resolving tables and let statements
+	// and dividing the query into intermediate queries.
+	TracePhaseSplit TracePhase = "split"
+	// TracePhaseWrite is writing the SQL for the intermediate queries.
+	TracePhaseWrite TracePhase = "write"
+)
+
+// TracePhaseComplete is the phase of
+// [*AnalysisContext.SuggestCompletionsContext]
+// and [*CompletionSession.SuggestCompletions].
+const TracePhaseComplete TracePhase = "complete"
+
+// compileTrace reports the phases of compiling a query to a [Tracer].
+// Its methods do nothing but keep track of the context if the tracer is nil.
+type compileTrace struct {
+	tracer Tracer
+	// parent is the context of the compile phase.
+	parent context.Context
+	// ctx is the context of the current phase.
+	ctx      context.Context
+	endPhase func(err error)
+	endAll   func(err error)
+}
+
+// startCompileTrace starts the compile phase.
+func startCompileTrace(ctx context.Context, tracer Tracer) *compileTrace {
+	t := &compileTrace{tracer: tracer, parent: ctx, ctx: ctx}
+	if tracer != nil {
+		t.parent, t.endAll = tracer.Start(ctx, TracePhaseCompile)
+		t.ctx = t.parent
+	}
+	return t
+}
+
+// phase ends the current phase, if any, and starts the given phase.
+func (t *compileTrace) phase(phase TracePhase) {
+	if t.tracer == nil {
+		return
+	}
+	if t.endPhase != nil {
+		t.endPhase(nil)
+	}
+	t.ctx, t.endPhase = t.tracer.Start(t.parent, phase)
+}
+
+// end ends the current phase and the compile phase with the given error.
+func (t *compileTrace) end(err error) {
+	if t.tracer == nil {
+		return
+	}
+	if t.endPhase != nil {
+		t.endPhase(err)
+		t.endPhase = nil
+	}
+	t.endAll(err)
+}
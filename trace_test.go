@@ -0,0 +1,146 @@
+// Copyright 2024 RunReveal Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pql
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runreveal/pql/parser"
+)
+
+// recordingTracer records the start and end of each phase
+// along with the phase it is nested in.
+type recordingTracer struct {
+	events []string
+}
+
+type tracePhaseKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, phase TracePhase) (context.Context, func(err error)) {
+	name := string(phase)
+	if parent, ok := ctx.Value(tracePhaseKey{}).(string); ok {
+		name = parent + "/" + name
+	}
+	t.events = append(t.events, "start "+name)
+	return context.WithValue(ctx, tracePhaseKey{}, name), func(err error) {
+		event := "end " + name
+		if err != nil {
+			event += " error"
+		}
+		t.events = append(t.events, event)
+	}
+}
+
+func TestCompileTracer(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{
+			source: "T | where x > 1",
+			want: []string{
+				"start compile",
+				"start compile/parse",
+				"end compile/parse",
+				"start compile/split",
+				"end compile/split",
+				"start compile/write",
+				"end compile/write",
+				"end compile",
+			},
+		},
+		{
+			source: "T | where",
+			want: []string{
+				"start compile",
+				"start compile/parse",
+				"end compile/parse error",
+				"end compile error",
+			},
+		},
+		{
+			source: "T | where x > 1; U",
+			want: []string{
+				"start compile",
+				"start compile/parse",
+				"end compile/parse",
+				"start compile/split",
+				"end compile/split error",
+				"end compile error",
+			},
+		},
+	}
+	for _, test := range tests {
+		tracer := new(recordingTracer)
+		opts := &CompileOptions{Tracer: tracer}
+		opts.Compile(test.source)
+		if diff := cmp.Diff(test.want, tracer.events); diff != "" {
+			t.Errorf("Compile(%q) events (-want +got):\n%s", test.source, diff)
+		}
+	}
+}
+
+// contextProvider is a [TableProvider] that reports
+// the phase in the context of each lookup.
+type contextProvider struct {
+	phases []string
+}
+
+func (p *contextProvider) LookupTable(ctx context.Context, name string) (*AnalysisTable, error) {
+	phase, _ := ctx.Value(tracePhaseKey{}).(string)
+	p.phases = append(p.phases, phase)
+	return nil, nil
+}
+
+func (p *contextProvider) ListTables(ctx context.Context, prefix string) ([]string, error) {
+	phase, _ := ctx.Value(tracePhaseKey{}).(string)
+	p.phases = append(p.phases, phase)
+	return []string{"Events_1"}, nil
+}
+
+func TestCompileContextTracer(t *testing.T) {
+	provider := new(contextProvider)
+	opts := &CompileOptions{
+		AnalysisContext: &AnalysisContext{Provider: provider},
+		Tracer:          new(recordingTracer),
+	}
+	const source = "Events_* | take 1"
+	if _, err := opts.CompileContext(context.Background(), source); err != nil {
+		t.Fatal(err)
+	}
+	for _, phase := range provider.phases {
+		if phase != "compile/split" {
+			t.Errorf("CompileContext(ctx, %q) looked up tables in phase %q; want compile/split", source, phase)
+		}
+	}
+	if len(provider.phases) == 0 {
+		t.Errorf("CompileContext(ctx, %q) did not use the provider", source)
+	}
+}
+
+func TestSuggestCompletionsTracer(t *testing.T) {
+	tracer := new(recordingTracer)
+	ac := &AnalysisContext{
+		Tables: map[string]*AnalysisTable{"StormEvents": {}},
+		Tracer: tracer,
+	}
+	const source = "Storm"
+	got, err := ac.SuggestCompletionsContext(context.Background(), source, parser.Span{Start: 5, End: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !strings.HasPrefix(got[0].Label, "StormEvents") {
+		t.Errorf("SuggestCompletionsContext(ctx, %q, 5) = %v; want StormEvents", source, got)
+	}
+	if _, err := ac.NewCompletionSession().SuggestCompletions(context.Background(), source, parser.Span{Start: 5, End: 5}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start complete", "end complete", "start complete", "end complete"}
+	if diff := cmp.Diff(want, tracer.events); diff != "" {
+		t.Errorf("events (-want +got):\n%s", diff)
+	}
+}